@@ -0,0 +1,49 @@
+// Package client is for Go programs which embed the NRTM v4 client rather than running
+// nrtm4client or nrtm4serve, e.g. to keep tables of their own in step with a mirror.
+package client
+
+import (
+	"github.com/petchells/nrtm4client/internal/nrtm4/mem"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg"
+	"github.com/petchells/nrtm4client/internal/nrtm4/service"
+)
+
+// Config is the client's configuration, as read from the config file
+type Config = service.AppConfig
+
+// Processor connects, updates and queries sources
+type Processor = service.NRTMProcessor
+
+// Repository is where the mirror is kept
+type Repository = persist.Repository
+
+// ReadConfigFile reads the config file at path into config
+func ReadConfigFile(path string, config *Config) error {
+	return service.ReadConfigFile(path, config)
+}
+
+// NewRepository returns the repository for the config's database, which calls the hooks
+// registered so far. It must be initialized with the database URL before it's used.
+func NewRepository(config Config) Repository {
+	if mem.IsMemoryURL(config.DatabaseURL()) {
+		return mem.NewRepository()
+	}
+	return pg.PostgresRepository{
+		SnapshotWriters:    config.SnapshotWriters,
+		SlowQueryThreshold: config.SlowQueryThreshold,
+		Hooks:              pg.RegisteredHooks(),
+		Password:           config.Database.Password(),
+		Retry:              config.Retry.RepositoryRetrier(),
+	}
+}
+
+// Open initializes the config's repository and returns a processor which uses it. Call close
+// when the processor is no longer needed.
+func Open(config Config) (processor Processor, close func() error, err error) {
+	repo := NewRepository(config)
+	if err = repo.Initialize(config.DatabaseURL()); err != nil {
+		return Processor{}, nil, err
+	}
+	return service.NewNRTMProcessor(config, repo, service.NewHTTPClient(config.Network)), repo.Close, nil
+}
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+// ObjectHook is called in the same transaction as each change to the objects table. Only the
+// PostgreSQL repository calls hooks.
+type ObjectHook = pg.ObjectHook
+
+// ObjectImageHook is an ObjectHook which is also given both versions of each changed object
+type ObjectImageHook = pg.ObjectImageHook
+
+// Source is the source a hook's objects belong to
+type Source = persist.NRTMSource
+
+// Object is an RPSL object passed to a hook
+type Object = rpsl.Rpsl
+
+// File is the snapshot or delta file a hook's change came from
+type File = protocol.NrtmFileJSON
+
+// RegisterHook adds a hook to every repository NewRepository or Open returns from then on, so
+// register hooks from an init function or before opening the repository.
+func RegisterHook(hook ObjectHook) {
+	pg.RegisterHook(hook)
+}
@@ -0,0 +1,44 @@
+package client
+
+import "github.com/petchells/nrtm4client/internal/nrtm4/service"
+
+// Scheduler updates sources on a schedule. Get one from Processor.NewScheduler.
+type Scheduler = service.Scheduler
+
+// SchedulePolicy says how a Scheduler updates a source
+type SchedulePolicy = service.SchedulePolicy
+
+// ScheduledJob is the state of one of a Scheduler's jobs
+type ScheduledJob = service.ScheduledJob
+
+// ScheduledRun is the outcome of one run of a job, and when the job will run next
+type ScheduledRun = service.ScheduledRun
+
+// TriggeredSync is an update which was asked for with Scheduler.Trigger
+type TriggeredSync = service.TriggeredSync
+
+// SyncResult is what an update did
+type SyncResult = service.SyncResult
+
+// CatchUpMode says how an update applies a backlog of deltas
+type CatchUpMode = service.CatchUpMode
+
+const (
+	// CatchUpAuto loads the latest snapshot instead of the deltas when it's estimated to be faster
+	CatchUpAuto = service.CatchUpAuto
+	// CatchUpDeltas always applies each delta
+	CatchUpDeltas = service.CatchUpDeltas
+	// CatchUpSnapshot loads the latest snapshot whenever it's newer than the source
+	CatchUpSnapshot = service.CatchUpSnapshot
+)
+
+var (
+	// ErrJobExists the scheduler already has a job for the source
+	ErrJobExists = service.ErrJobExists
+	// ErrInvalidSchedulePolicy the policy's interval isn't positive
+	ErrInvalidSchedulePolicy = service.ErrInvalidSchedulePolicy
+	// ErrSchedulerStopped the scheduler isn't running, so it can't start an update
+	ErrSchedulerStopped = service.ErrSchedulerStopped
+	// ErrSourceNotFound a source with the given label is not in the repo
+	ErrSourceNotFound = service.ErrSourceNotFound
+)
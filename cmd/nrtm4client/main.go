@@ -6,24 +6,45 @@ import (
 
 	"github.com/petchells/nrtm4client/internal/nrtm4/cli"
 	"github.com/petchells/nrtm4client/internal/nrtm4/service"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
 )
 
 const mandatorySourceMessage = "Source name must be provided with the -source flag"
 
 func main() {
-	envVars := []string{"PG_DATABASE_URL", "NRTM4_FILE_PATH"}
-	for _, ev := range envVars {
-		if len(os.Getenv(ev)) <= 0 {
-			log.Fatalln("Environment variable not set: ", ev)
+	config := service.AppConfig{
+		NRTMFilePath:     os.Getenv("NRTM4_FILE_PATH"),
+		PgDatabaseURL:    os.Getenv("PG_DATABASE_URL"),
+		BoltDatabasePath: os.Getenv("BOLT_DATABASE_PATH"),
+	}
+	configFile := os.Getenv("NRTM4_CONFIG_FILE")
+	if len(configFile) > 0 {
+		if err := service.ReadConfigFile(configFile, &config); err != nil {
+			log.Fatalln("Cannot read config file", configFile, err)
 		}
 	}
-	dbURL := os.Getenv("PG_DATABASE_URL")
-	boltDBPath := os.Getenv("BOLT_DATABASE_PATH")
-	nrtmFilePath := os.Getenv("NRTM4_FILE_PATH")
-	config := service.AppConfig{
-		NRTMFilePath:     nrtmFilePath,
-		PgDatabaseURL:    dbURL,
-		BoltDatabasePath: boltDBPath,
+	if err := util.ApplyLogLevels(config.LogLevels); err != nil {
+		log.Fatalln("Cannot set log levels", err)
+	}
+	util.WatchLogSignal()
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		os.Exit(cli.Version(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "use-context" {
+		os.Exit(cli.UseContext(os.Args[2:], configFile, config))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "lint-delta" {
+		os.Exit(cli.LintDelta(os.Args[2:], config))
+	}
+	var err error
+	if os.Args, err = cli.SelectContext(os.Args, &config); err != nil {
+		log.Fatalln("Cannot use context", err)
+	}
+	if len(config.DatabaseURL()) == 0 {
+		log.Fatalln("Environment variable not set: ", "PG_DATABASE_URL")
+	}
+	if len(config.NRTMFilePath) == 0 {
+		log.Fatalln("Environment variable not set: ", "NRTM4_FILE_PATH")
 	}
 	commander := cli.InitializeCommandProcessor(config)
 	cli.Exec(commander)
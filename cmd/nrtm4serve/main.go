@@ -6,27 +6,38 @@ import (
 	"os"
 
 	"github.com/petchells/nrtm4client/internal/nrtm4/service"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
 	"github.com/petchells/nrtm4client/internal/nrtm4serve"
 )
 
 var port = flag.Int("port", 8080, "server port number")
-var webdir = flag.String("webdir", "", "path to static web root")
+var webdir = flag.String("webdir", "", "path to static web root. Default is the web client built into the binary, if there is one")
+var context = flag.String("context", "", "named context from the config file, instead of current_context")
 
 func main() {
 	flag.Parse()
-	envVars := []string{"PG_DATABASE_URL", "NRTM4_FILE_PATH"}
-	for _, ev := range envVars {
-		if len(os.Getenv(ev)) <= 0 {
-			log.Fatalln("Environment variable not set: ", ev)
+	config := service.AppConfig{
+		NRTMFilePath:     os.Getenv("NRTM4_FILE_PATH"),
+		PgDatabaseURL:    os.Getenv("PG_DATABASE_URL"),
+		BoltDatabasePath: os.Getenv("BOLT_DATABASE_PATH"),
+	}
+	if configFile := os.Getenv("NRTM4_CONFIG_FILE"); len(configFile) > 0 {
+		if err := service.ReadConfigFile(configFile, &config); err != nil {
+			log.Fatalln("Cannot read config file", configFile, err)
 		}
 	}
-	dbURL := os.Getenv("PG_DATABASE_URL")
-	boltDBPath := os.Getenv("BOLT_DATABASE_PATH")
-	nrtmFilePath := os.Getenv("NRTM4_FILE_PATH")
-	config := service.AppConfig{
-		NRTMFilePath:     nrtmFilePath,
-		PgDatabaseURL:    dbURL,
-		BoltDatabasePath: boltDBPath,
+	if err := util.ApplyLogLevels(config.LogLevels); err != nil {
+		log.Fatalln("Cannot set log levels", err)
+	}
+	util.WatchLogSignal()
+	if err := config.UseContext(*context); err != nil {
+		log.Fatalln("Cannot use context", err)
+	}
+	if len(config.DatabaseURL()) == 0 {
+		log.Fatalln("Environment variable not set: ", "PG_DATABASE_URL")
+	}
+	if len(config.NRTMFilePath) == 0 {
+		log.Fatalln("Environment variable not set: ", "NRTM4_FILE_PATH")
 	}
 	nrtm4serve.Launch(config, *port, *webdir)
 }
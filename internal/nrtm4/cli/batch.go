@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// ErrUnterminatedQuote a quoted argument in a batch line has no closing quote
+var ErrUnterminatedQuote = errors.New("unterminated quote")
+
+// exit is os.Exit, except while a batch is running, when it only ends the current command
+var exit = os.Exit
+
+// exitCode is what exit panics with in a batch
+type exitCode int
+
+func fatal(v ...any) {
+	log.Print(v...)
+	exit(1)
+}
+
+func fatalf(format string, v ...any) {
+	log.Printf(format, v...)
+	exit(1)
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ContinueOnError)
+}
+
+// parseFlags parses a command's flags, and exits like flag.ExitOnError does when they're wrong
+func parseFlags(fs *flag.FlagSet, args []string) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			exit(0)
+		}
+		exit(2)
+	}
+}
+
+// runBatch runs a command from each line of r in turn, in the same process, so they share the
+// database pool. Blank lines and lines starting with # are skipped. It stops at the first command
+// which exits with an error, unless keepGoing is true, and returns the exit code of the last
+// command which failed.
+func runBatch(r io.Reader, keepGoing bool, run func([]string)) int {
+	exit = func(code int) { panic(exitCode(code)) }
+	defer func() { exit = os.Exit }()
+	failed := 0
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args, err := splitCommandLine(line)
+		if err != nil {
+			logger.Error("Cannot read batch command", "line", lineNo, "error", err)
+			failed = 1
+		} else if code := runBatchCommand(args, run); code != 0 {
+			logger.Error("Batch command failed", "line", lineNo, "command", args[0], "exit", code)
+			failed = code
+		}
+		if failed != 0 && !keepGoing {
+			return failed
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Error("Cannot read batch", "error", err)
+		return 1
+	}
+	return failed
+}
+
+// runBatchCommand runs one command, and returns the code it exited with, or 0 if it returned
+func runBatchCommand(args []string, run func([]string)) (code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			c, ok := r.(exitCode)
+			if !ok {
+				panic(r)
+			}
+			code = int(c)
+		}
+	}()
+	run(args)
+	return 0
+}
+
+// splitCommandLine splits a line into arguments at spaces. Single or double quotes keep spaces
+// in an argument.
+func splitCommandLine(line string) ([]string, error) {
+	args := []string{}
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	for _, c := range line {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("%w: %v", ErrUnterminatedQuote, line)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
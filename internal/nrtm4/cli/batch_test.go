@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	args, err := splitCommandLine(`update  --source RIPE --label "my label" --x='a "b"'`)
+	expected := []string{"update", "--source", "RIPE", "--label", "my label", `--x=a "b"`}
+	if err != nil || !slices.Equal(args, expected) {
+		t.Errorf("Expected %q but was %q %v", expected, args, err)
+	}
+	if args, err = splitCommandLine(`lookup --label ""`); err != nil || len(args) != 3 || args[2] != "" {
+		t.Errorf("Expected an empty argument but was %q %v", args, err)
+	}
+	if _, err = splitCommandLine(`list --label "open`); !errors.Is(err, ErrUnterminatedQuote) {
+		t.Error("Expected ErrUnterminatedQuote but was", err)
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	input := `
+# Comments and blank lines are skipped
+list
+validate --url https://example.com/notification.json
+connect --url "https://example.com/notification.json"
+update --source RIPE
+`
+	var ran []string
+	run := func(args []string) {
+		ran = append(ran, args[0])
+		switch args[0] {
+		case "validate":
+			exit(0)
+		case "connect":
+			fatalf("connect failed")
+		}
+	}
+	if code := runBatch(strings.NewReader(input), false, run); code != 1 {
+		t.Error("Expected exit code 1 but was", code)
+	}
+	if !slices.Equal(ran, []string{"list", "validate", "connect"}) {
+		t.Error("Expected the batch to stop at the failed command but ran", ran)
+	}
+
+	ran = nil
+	if code := runBatch(strings.NewReader(input), true, run); code != 1 {
+		t.Error("Expected exit code 1 but was", code)
+	}
+	if !slices.Equal(ran, []string{"list", "validate", "connect", "update"}) {
+		t.Error("Expected every command to run with keep-going but ran", ran)
+	}
+
+	// Flag errors fail the command instead of the process
+	code := runBatch(strings.NewReader("squash --nope"), false, func(args []string) {
+		parseFlags(newFlagSet("squash"), args[1:])
+	})
+	if code != 2 {
+		t.Error("Expected exit code 2 for an unknown flag but was", code)
+	}
+}
@@ -1,50 +1,275 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/service"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
 )
 
 // ExecutionProcessor top-level processing for app functions
 type ExecutionProcessor interface {
-	Connect(string, string) error
-	Update(string, string) error
+	ConnectSample(string, string, service.Sample) (service.SyncResult, error)
+	ConnectAll([]service.ConnectRequest, int) []service.ConnectResult
+	Update(string, string, service.CatchUpMode) (service.SyncResult, error)
 	ListSources() ([]persist.NRTMSourceDetails, error)
 	ReplaceLabel(string, string, string) (*persist.NRTMSource, error)
 	RemoveSource(string, string) error
+	Digest(string, string, string) (service.ChangeDigest, error)
+	SendDigest(service.ChangeDigest) error
+	VerifyAuditLog() (int, error)
+	GetNotification(string, string, uint32) (persist.Notification, error)
+	FetchRawNotification(string, string) ([]byte, error)
+	SchemaInfo() (persist.SchemaInfo, error)
+	IndexProgress() ([]persist.IndexProgress, error)
+	PartitionObjects(int) error
+	CheckSchemaVersion(bool) error
+	VerifyCache(bool) (service.CacheReport, error)
+	CheckConformance(string, bool, bool, service.DeltaOrdering) service.ConformanceReport
+	Doctor() service.DoctorReport
+	LiveTest(string, service.LiveTestOptions) service.LiveTestReport
+	Simulate(service.Scenario, service.SimulationOptions) (service.SimulationReport, error)
+	ImportIRRd(string, string, string, uint32) (service.IRRdImportReport, error)
+	PinSources([]string) (service.ReadSnapshot, error)
+	PinGroup(string) (service.ReadSnapshot, error)
+	PinnedVersion(service.ReadSnapshot, string, string) (uint32, error)
+	StaleObjects(string, string, time.Duration, []string) (service.StaleObjectsReport, error)
+	Promote() error
+	UpdateGroup(string, service.CatchUpMode) ([]service.SyncResult, error)
+	UpdateAll(int, service.CatchUpMode) (service.UpdateSummary, error)
+	PauseSource(string, string, bool) error
+	PauseGroup(string, bool) error
+	ExportDeltas(string, string, uint32, uint32, string, service.OutputEncoding, string) ([]string, error)
+	VerifySnapshot(string, string, string) (persist.SnapshotComparison, error)
+	AttributeHistory(string, string, string, []string) ([]service.AttributeHistoryEntry, error)
+	CheckDelegations(string, string, string, string) (service.DelegationReport, error)
+	SetGraph(string, string, string) (service.SetGraph, error)
+	Ownership(string, string, time.Duration) (service.OwnershipReport, error)
+	Lookup(string, string, uint32, []string) (service.LookupResult, error)
+	UndeleteObject(string, string, string, string) (persist.ObjectVersion, error)
+	CleanupSessions(string, time.Duration, bool) (service.SessionCleanupReport, error)
+	SquashHistory(string, string, uint32) (persist.SquashedHistory, error)
+	CompactHistory(string, string, int) (persist.CompactedHistory, error)
+	SnapshotLineage(string, string) ([]persist.SnapshotRef, error)
+	FetchArchivedSnapshot(string, string, uint32, string) (string, error)
+	GetOversizedObjects(string, string) ([]persist.OversizedObject, error)
+	CompareUpstream(string, string, string, string, []string) ([]service.UpstreamComparison, error)
+	Forecast(time.Duration) (service.ForecastReport, error)
+	CheckReferences(string, string) (service.ReferenceReport, error)
+	Interrupt() *service.Interrupt
 }
 
 // CommandExecutor invokes processor and outputs responses to command line input
 type CommandExecutor struct {
-	processor ExecutionProcessor
+	processor     ExecutionProcessor
+	defaultSource string
+	defaultLabel  string
+	aliases       map[string]string
 }
 
 // NewCommandProcessor creates a CommandExecutor and injects the processor
 func NewCommandProcessor(processor ExecutionProcessor) CommandExecutor {
-	return CommandExecutor{processor}
+	return CommandExecutor{processor: processor}
 }
 
-// Connect establishes a new connection to a NRTM source server
-func (ce CommandExecutor) Connect(notificationURL string, label string) {
-	err := ce.processor.Connect(notificationURL, label)
+// CheckSchemaVersion returns false if the database schema can't be used by this client
+func (ce CommandExecutor) CheckSchemaVersion(allowForwardCompat bool) bool {
+	if err := ce.processor.CheckSchemaVersion(allowForwardCompat); err != nil {
+		logger.Error("Incompatible database schema", "error", err)
+		return false
+	}
+	return true
+}
+
+// Connect establishes a new connection to a NRTM source server. A non-zero sample loads only
+// part of the snapshot. It returns 1 if the connect fails.
+func (ce CommandExecutor) Connect(notificationURL string, label string, sample service.Sample) int {
+	result, err := ce.processor.ConnectSample(notificationURL, label, sample)
+	printWarnings(result)
 	if err != nil {
 		logger.Error("Failed to Connect", "url", notificationURL, "error", err)
-		return
+		return 1
+	}
+	if len(result.Warnings) > 0 {
+		logger.Warn("Connect completed with warnings", "url", notificationURL, "version", result.ToVersion, "warnings", len(result.Warnings))
+		return 0
 	}
 	logger.Info("Connect successful", "url", notificationURL)
+	return 0
+}
+
+// WatchInterruptSignals lets SIGINT and SIGTERM end a sync gracefully. The first stops it after
+// the delta being applied, and the second aborts it and rolls the delta back.
+func (ce CommandExecutor) WatchInterruptSignals() {
+	interrupt := ce.processor.Interrupt()
+	util.WatchInterruptSignals(interrupt.Stop, interrupt.Abort)
+}
+
+// ImportIRRd seeds a new source from an IRRd export taken at version, then continues it with
+// the server's deltas. It returns 1 if the import fails.
+func (ce CommandExecutor) ImportIRRd(path, notificationURL, label string, version uint32) int {
+	report, err := ce.processor.ImportIRRd(path, notificationURL, label, version)
+	printWarnings(report.Sync)
+	if err != nil {
+		logger.Error("Failed to import IRRd export", "file", path, "url", notificationURL, "error", err)
+		return 1
+	}
+	fmt.Printf("%v %q: imported %d objects at version %d, now at version %d\n",
+		report.Sync.Source, report.Sync.Label, report.Imported, version, report.Sync.ToVersion)
+	if report.OtherSources+report.Filtered+report.Invalid > 0 {
+		fmt.Printf("Skipped %d objects of other sources, %d filtered and %d invalid\n", report.OtherSources, report.Filtered, report.Invalid)
+	}
+	if len(report.Sync.Warnings) > 0 {
+		logger.Warn("Import completed with warnings", "url", notificationURL, "version", report.Sync.ToVersion, "warnings", len(report.Sync.Warnings))
+		return 0
+	}
+	logger.Info("Import successful", "url", notificationURL)
+	return 0
+}
+
+// printWarnings writes a sync's warnings to stdout, so they stand apart from the log
+func printWarnings(result service.SyncResult) {
+	for _, w := range result.Warnings {
+		fmt.Printf("WARNING %v %q %v\n", result.Source, result.Label, w)
+	}
+}
+
+// ConnectAll connects the sources listed in a file in parallel, and prints the outcome for each.
+// It returns 0 when every source connected and 1 otherwise.
+func (ce CommandExecutor) ConnectAll(path string, parallel int) int {
+	file, err := os.Open(path)
+	if err != nil {
+		logger.Error("Cannot open file", "path", path, "error", err)
+		return 1
+	}
+	defer file.Close()
+	reqs, err := service.ReadConnectRequests(file)
+	if err != nil {
+		logger.Error("Cannot read sources", "path", path, "error", err)
+		return 1
+	}
+	failed := 0
+	for _, result := range ce.processor.ConnectAll(reqs, parallel) {
+		printWarnings(result.Sync)
+		if result.Err != nil {
+			failed++
+			fmt.Printf("FAILED    %v %q: %v\n", result.URL, result.Label, result.Err)
+		} else if len(result.Sync.Warnings) > 0 {
+			fmt.Printf("CONNECTED %v %q with %d warnings\n", result.URL, result.Label, len(result.Sync.Warnings))
+		} else {
+			fmt.Printf("CONNECTED %v %q\n", result.URL, result.Label)
+		}
+	}
+	logger.Info("Connect finished", "sources", len(reqs), "failed", failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
 }
 
-// Update brings local mirror up to date
-func (ce CommandExecutor) Update(source string, label string) {
-	err := ce.processor.Update(source, label)
+// Update brings local mirror up to date. It returns 1 if the update fails.
+func (ce CommandExecutor) Update(source string, label string, catchUp service.CatchUpMode) int {
+	result, err := ce.processor.Update(source, label, catchUp)
+	printWarnings(result)
 	if err != nil {
 		logger.Warn("Error occurred during update", "error", err)
+		return 1
+	} else if len(result.Warnings) > 0 {
+		logger.Warn("Update completed with warnings", "version", result.ToVersion, "warnings", len(result.Warnings))
 	} else {
 		logger.Info("Update finished successfully")
 	}
+	return 0
+}
+
+// UpdateGroup brings every source in a group up to date. It returns 1 if any of them fails.
+func (ce CommandExecutor) UpdateGroup(group string, catchUp service.CatchUpMode) int {
+	results, err := ce.processor.UpdateGroup(group, catchUp)
+	warnings := 0
+	for _, result := range results {
+		printWarnings(result)
+		warnings += len(result.Warnings)
+	}
+	if err != nil {
+		logger.Warn("Error occurred during group update", "group", group, "error", err)
+		return 1
+	}
+	if warnings > 0 {
+		logger.Warn("Group update completed with warnings", "group", group, "warnings", warnings)
+		return 0
+	}
+	logger.Info("Group update successful", "group", group)
+	return 0
+}
+
+// UpdateAll updates every source, parallel at a time, and prints the outcome for each, or a JSON
+// summary. It returns the summary's exit code, or 1 if the sources can't be read.
+func (ce CommandExecutor) UpdateAll(parallel int, catchUp service.CatchUpMode, format string) int {
+	summary, err := ce.processor.UpdateAll(parallel, catchUp)
+	if err != nil {
+		logger.Error("Cannot read sources", "error", err)
+		return 1
+	}
+	if format == "json" {
+		bytes, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			logger.Error("Failed to format summary", "error", err)
+			return 1
+		}
+		fmt.Println(string(bytes))
+		return summary.ExitCode
+	}
+	for _, result := range summary.Results {
+		printWarnings(result.SyncResult)
+		switch result.Status {
+		case service.UpdateFailed:
+			fmt.Printf("FAILED    %v %q (%v): %v\n", result.Source, result.Label, result.Failure, result.Error)
+		case service.UpdateSkipped:
+			fmt.Printf("SKIPPED   %v %q: %v\n", result.Source, result.Label, result.Reason)
+		case service.UpdateWarnings:
+			fmt.Printf("UPDATED   %v %q to %d with %d warnings\n", result.Source, result.Label, result.ToVersion, len(result.Warnings))
+		default:
+			fmt.Printf("UPDATED   %v %q to %d\n", result.Source, result.Label, result.ToVersion)
+		}
+	}
+	logger.Info("Update finished", "succeeded", summary.Succeeded, "warned", summary.Warned,
+		"failed", summary.Failed, "skipped", summary.Skipped)
+	return summary.ExitCode
 }
 
+// Pause stops a source, or every source in a group, being updated. It's undone by resume.
+func (ce CommandExecutor) Pause(src, label, group string, paused bool) {
+	action := "Paused"
+	if !paused {
+		action = "Resumed"
+	}
+	var err error
+	if len(group) > 0 {
+		err = ce.processor.PauseGroup(group, paused)
+	} else {
+		err = ce.processor.PauseSource(src, label, paused)
+	}
+	if err != nil {
+		logger.Error(action+" failed", "source", src, "label", label, "group", group, "error", err)
+		return
+	}
+	logger.Info(action, "source", src, "label", label, "group", group)
+}
+
+// maxListedExpiries is how many of a source's upcoming expiries list shows
+const maxListedExpiries = 3
+
+// maxListedSessions is how many of a source's most recent sessions list shows
+const maxListedSessions = 5
+
 // ListSources shows all sources in db
 func (ce CommandExecutor) ListSources(src, label string) {
 	// Not doing anything with these args for now", "src", src, "label", label
@@ -59,12 +284,81 @@ func (ce CommandExecutor) ListSources(src, label string) {
 		Label        : %v
 		Version      : %v
 		Last updated : %v
+		Paused       : %v
+
+`, i+1, src.Source, src.Label, src.Version, src.Notifications[0].Created, src.Paused)
+		if next := src.Notifications[0].Payload.NextSession; next != nil {
+			nextID := "(not announced)"
+			if next.SessionID != nil {
+				nextID = *next.SessionID
+			}
+			fmt.Printf(`		Next session : %v at %v
+
+`, nextID, next.Timestamp)
+		}
+		for j, expiry := range src.Expiries {
+			if j == maxListedExpiries {
+				fmt.Printf("\t\t               ...and %d more\n", len(src.Expiries)-j)
+				break
+			}
+			heading := "Expires      :"
+			if j > 0 {
+				heading = "              "
+			}
+			fmt.Printf("\t\t%v %v %d at %v\n", heading, expiry.File, expiry.Version, expiry.Expires.Format(time.RFC3339))
+		}
+		if len(src.Expiries) > 0 {
+			fmt.Println()
+		}
+		printSessions(src.Sessions)
+		if len(src.TermsURL) > 0 {
+			fmt.Printf(`		Terms        : %v
+
+`, src.TermsURL)
+		}
+		if src.SampleRate > 0 {
+			fmt.Printf(`		Sample       : %v%% of objects
+
+`, strconv.FormatFloat(src.SampleRate*100, 'g', 4, 64))
+		}
+		if q := src.Quarantine; q != nil {
+			fmt.Printf(`		Quarantined  : until %v after %d failures
+		Reason       : %v
 
-`, i+1, src.Source, src.Label, src.Version, src.Notifications[0].Created)
+`, q.Until, q.Failures, q.Reason)
+		}
 	}
 	logger.Info("List finished successfully")
 }
 
+// printSessions prints the timeline of the most recent sessions seen at a source's URL, with
+// whether each rotation was announced
+func printSessions(sessions []persist.SessionSeen) {
+	if len(sessions) == 0 {
+		return
+	}
+	fmt.Printf("\t\tSessions     : %d seen\n", len(sessions))
+	if len(sessions) > maxListedSessions {
+		fmt.Printf("\t\t               ...and %d older\n", len(sessions)-maxListedSessions)
+	}
+	for i, session := range sessions {
+		if i < len(sessions)-maxListedSessions {
+			continue
+		}
+		rotation := ""
+		if i > 0 {
+			rotation = "  unannounced rotation"
+			if session.Announced {
+				rotation = "  announced rotation"
+			}
+		}
+		fmt.Printf("\t\t               %v  %v to %v  versions %d-%d%v\n", session.SessionID,
+			session.FirstSeen.Format(time.RFC3339), session.LastSeen.Format(time.RFC3339),
+			session.FirstVersion, session.LastVersion, rotation)
+	}
+	fmt.Println()
+}
+
 // ReplaceLabel Replaces a label for a source/label
 func (ce CommandExecutor) ReplaceLabel(src, fromLabel, toLabel string) {
 	var updated *persist.NRTMSource
@@ -84,3 +378,726 @@ func (ce CommandExecutor) RemoveSource(src, label string) {
 	}
 	logger.Info("Removed source")
 }
+
+// Digest prints a summary of changes over a period, and optionally sends it to the notifier
+func (ce CommandExecutor) Digest(src, label, period string, send bool) {
+	digest, err := ce.processor.Digest(src, label, period)
+	if err != nil {
+		logger.Error("Digest failed with error", "error", err)
+		return
+	}
+	fmt.Println(digest.String())
+	if !send {
+		return
+	}
+	if err = ce.processor.SendDigest(digest); err != nil {
+		logger.Error("Failed to send digest", "error", err)
+		return
+	}
+	logger.Info("Digest sent")
+}
+
+// VerifyAuditLog checks the hash chain and signatures in the audit log
+func (ce CommandExecutor) VerifyAuditLog() {
+	n, err := ce.processor.VerifyAuditLog()
+	if err != nil {
+		logger.Error("Audit log verification failed", "verifiedEntries", n, "error", err)
+		return
+	}
+	logger.Info("Audit log verified", "entries", n)
+}
+
+// ShowSchema prints the tables, columns and indexes in the repository
+func (ce CommandExecutor) ShowSchema() {
+	info, err := ce.processor.SchemaInfo()
+	if err != nil {
+		logger.Error("Failed to read schema", "error", err)
+		return
+	}
+	fmt.Printf("Backend:        %v\n", info.Backend)
+	fmt.Printf("Schema version: %v\n", info.SchemaVersion)
+	for _, t := range info.Tables {
+		fmt.Printf("\n%v  ~%d rows, %v\n", t.Name, t.EstimatedRows, formatBytes(t.TotalBytes))
+		for _, c := range t.Columns {
+			null := "not null"
+			if c.Nullable {
+				null = "null"
+			}
+			fmt.Printf("    %-24v %-28v %v\n", c.Name, c.Type, null)
+		}
+		for _, idx := range t.Indexes {
+			fmt.Printf("    index %-36v %v\n", idx.Name, formatBytes(idx.Bytes))
+		}
+	}
+}
+
+// ShowIndexes prints how far each optional index has been built
+func (ce CommandExecutor) ShowIndexes() {
+	progress, err := ce.processor.IndexProgress()
+	if err != nil {
+		logger.Error("Failed to read index progress", "error", err)
+		return
+	}
+	if len(progress) == 0 {
+		fmt.Println("No optional indexes. List them in indexes.build in the config file.")
+		return
+	}
+	for _, ip := range progress {
+		state := "not started"
+		if !ip.CaughtUp.IsZero() {
+			state = "ready since " + ip.CaughtUp.Format(time.RFC3339)
+		} else if !ip.Started.IsZero() {
+			percent := 0.0
+			if ip.TotalObjects > 0 {
+				percent = min(100, float64(ip.Objects)*100/float64(ip.TotalObjects))
+			}
+			state = fmt.Sprintf("building, %.1f%%", percent)
+		}
+		fmt.Printf("%-12v %-32v %d of ~%d objects\n", ip.Name, state, ip.Objects, ip.TotalObjects)
+	}
+}
+
+// PartitionObjects partitions the objects table by a hash of the primary key
+func (ce CommandExecutor) PartitionObjects(partitions int) {
+	if err := ce.processor.PartitionObjects(partitions); err != nil {
+		logger.Error("Failed to partition objects", "partitions", partitions, "error", err)
+		return
+	}
+	logger.Info("Objects table is partitioned", "partitions", partitions)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// VerifyCache reports downloaded files which are corrupt, stale or orphaned
+func (ce CommandExecutor) VerifyCache(remove bool) {
+	report, err := ce.processor.VerifyCache(remove)
+	if err != nil {
+		logger.Error("Cache verification failed", "error", err)
+		return
+	}
+	for _, list := range []struct {
+		heading string
+		names   []string
+	}{
+		{"Corrupt", report.Corrupt},
+		{"Stale", report.Stale},
+		{"Orphaned", report.Orphaned},
+		{"Deleted", report.Deleted},
+		{"In use", report.InUse},
+		{"Unchecked", report.Unchecked},
+	} {
+		for _, name := range list.names {
+			fmt.Printf("%-9v %v\n", list.heading, name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(report.Unreachable)) {
+		logger.Error("Cannot fetch notification file", "source", name, "error", report.Unreachable[name])
+	}
+	logger.Info("Cache verified",
+		"ok", len(report.OK),
+		"corrupt", len(report.Corrupt),
+		"stale", len(report.Stale),
+		"orphaned", len(report.Orphaned),
+		"deleted", len(report.Deleted),
+		"in_use", len(report.InUse),
+		"unchecked", len(report.Unchecked),
+		"unreachable", len(report.Unreachable),
+	)
+}
+
+// SquashHistory collapses a source's history before a version into a baseline
+func (ce CommandExecutor) SquashHistory(src, label string, before uint32) {
+	squashed, err := ce.processor.SquashHistory(src, label, before)
+	if err != nil {
+		logger.Error("Squash failed", "error", err)
+		return
+	}
+	logger.Info("Squashed history", "baseline", squashed.Baseline, "removed", squashed.Removed, "rebased", squashed.Rebased)
+}
+
+// CompactHistory stores old versions of a source's objects as diffs
+func (ce CommandExecutor) CompactHistory(src, label string, fullEvery int) {
+	compacted, err := ce.processor.CompactHistory(src, label, fullEvery)
+	if err != nil {
+		logger.Error("Compaction failed", "error", err)
+		return
+	}
+	logger.Info("Compacted history", "compacted", compacted.Compacted,
+		"bytes_before", compacted.BytesBefore, "bytes_after", compacted.BytesAfter)
+}
+
+// SnapshotLineage prints the snapshots recorded for a source, the most recently advertised first
+func (ce CommandExecutor) SnapshotLineage(src, label string) {
+	refs, err := ce.processor.SnapshotLineage(src, label)
+	if err != nil {
+		logger.Error("Cannot list snapshots", "error", err)
+		return
+	}
+	for _, ref := range refs {
+		fmt.Printf("%v %10d %v %v first=%v last=%v\n", ref.SessionID, ref.Version, ref.Hash, ref.URL,
+			ref.FirstSeen.Format(time.RFC3339), ref.LastSeen.Format(time.RFC3339))
+	}
+}
+
+// FetchArchivedSnapshot downloads a recorded snapshot and prints its path
+func (ce CommandExecutor) FetchArchivedSnapshot(src, label string, version uint32, dir string) {
+	path, err := ce.processor.FetchArchivedSnapshot(src, label, version, dir)
+	if err != nil {
+		logger.Error("Cannot fetch snapshot", "version", version, "error", err)
+		return
+	}
+	fmt.Println(path)
+}
+
+// OversizedObjects prints the records of a source which were quarantined for being larger than
+// max_object_size. --show prints the start of each record as well.
+func (ce CommandExecutor) OversizedObjects(src, label string, show bool) {
+	objs, err := ce.processor.GetOversizedObjects(src, label)
+	if err != nil {
+		logger.Error("Cannot list oversized objects", "error", err)
+		return
+	}
+	for _, obj := range objs {
+		fmt.Printf("%10d %v %v %d bytes, quarantined %v\n", obj.Version, obj.ObjectType, obj.PrimaryKey, obj.Size,
+			obj.Quarantined.Format(time.RFC3339))
+		if show {
+			fmt.Println(obj.RecordStart)
+		}
+	}
+}
+
+// CleanupSessions removes sessions superseded by a re-initialization and prints a line for each,
+// with the rows deleted
+func (ce CommandExecutor) CleanupSessions(src string, olderThan time.Duration, dryRun bool) {
+	report, err := ce.processor.CleanupSessions(src, olderThan, dryRun)
+	if err != nil {
+		logger.Error("Session cleanup failed", "error", err)
+		return
+	}
+	for _, session := range report.Sessions {
+		s := session.Source
+		if dryRun {
+			fmt.Printf("%v %q %v superseded %v\n", s.Source, s.Label, s.SessionID, s.Superseded.Format(time.RFC3339))
+			continue
+		}
+		fmt.Printf("%v %q %v objects=%d notifications=%d files=%d\n", s.Source, s.Label, s.SessionID,
+			session.Rows.Objects, session.Rows.Notifications, session.Rows.Files)
+	}
+	total := report.Total()
+	logger.Info("Session cleanup finished",
+		"sessions", len(report.Sessions),
+		"dryRun", dryRun,
+		"objects", total.Objects,
+		"notifications", total.Notifications,
+		"files", total.Files,
+	)
+}
+
+// CheckReferences writes the references in the source's objects to objects it doesn't have
+func (ce CommandExecutor) CheckReferences(src, label, format, path string) {
+	report, err := ce.processor.CheckReferences(src, label)
+	if err != nil {
+		logger.Error("Failed to check references", "error", err)
+		return
+	}
+	out := os.Stdout
+	if len(path) > 0 {
+		if out, err = os.Create(path); err != nil {
+			logger.Error("Cannot create file", "path", path, "error", err)
+			return
+		}
+		defer out.Close()
+	}
+	if err = service.WriteReferenceReport(out, report, format); err != nil {
+		logger.Error("Failed to write references report", "error", err)
+		return
+	}
+	logger.Info("References checked", "objects", report.Checked, "references", report.References, "dangling", len(report.Dangling), "version", report.Version)
+}
+
+// Forecast prints how fast the downloaded files and the database are growing, and when they'll
+// be full at that rate
+func (ce CommandExecutor) Forecast(window time.Duration, format string) {
+	report, err := ce.processor.Forecast(window)
+	if err != nil {
+		logger.Error("Forecast failed", "error", err)
+		return
+	}
+	if format == "json" {
+		bytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logger.Error("Failed to format report", "error", err)
+			return
+		}
+		fmt.Println(string(bytes))
+		return
+	}
+	fmt.Printf("Growth over the last %.0f days\n", report.WindowDays)
+	for _, g := range report.Sources {
+		fmt.Printf("%v %q: %.1f versions/day, %.0f changes/day, %.2f sessions/day, files %v/day, database %v/day\n",
+			g.Source, g.Label, g.VersionsPerDay, g.ChangesPerDay, g.SessionsPerDay,
+			formatBytes(int64(g.DiskBytesPerDay)), formatBytes(int64(g.DBBytesPerDay)))
+	}
+	printCapacityForecast("Files", report.Disk)
+	printCapacityForecast("Database", report.Database)
+}
+
+func printCapacityForecast(name string, c service.CapacityForecast) {
+	free := "unknown"
+	if c.FreeBytes != nil {
+		free = formatBytes(*c.FreeBytes)
+	}
+	full := "not growing"
+	switch {
+	case c.FreeBytes == nil:
+		full = "unknown"
+	case c.Full != nil:
+		full = fmt.Sprintf("%v, in %.0f days", c.Full.Format(time.DateOnly), *c.DaysLeft)
+	case c.DaysLeft != nil:
+		full = "not in the foreseeable future"
+	}
+	fmt.Printf("%-8v used %v, free %v, growing %v/day, full %v\n", name, formatBytes(c.UsedBytes), free, formatBytes(int64(c.BytesPerDay)), full)
+}
+
+// Promote makes a standby instance the primary
+func (ce CommandExecutor) Promote() {
+	if err := ce.processor.Promote(); err != nil {
+		logger.Error("Promote failed", "error", err)
+		return
+	}
+	logger.Info("This instance is now the primary")
+}
+
+// Validate checks a server's notification file against the spec and returns an exit code
+func (ce CommandExecutor) Validate(notificationURL string, checkFiles, strictFileNames bool, ordering service.DeltaOrdering, format string) int {
+	report := ce.processor.CheckConformance(notificationURL, checkFiles, strictFileNames, ordering)
+	if format == "json" {
+		bytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logger.Error("Failed to format report", "error", err)
+			return 1
+		}
+		fmt.Println(string(bytes))
+		return report.ExitCode
+	}
+	for _, check := range report.Checks {
+		fmt.Printf("%-7v %-7v %-26v %v\n", check.Status, check.Severity, check.ID, check.Description)
+		if len(check.Message) > 0 {
+			fmt.Printf("        %v\n", check.Message)
+		}
+	}
+	return report.ExitCode
+}
+
+// Doctor checks the installation and prints a fix for each check which fails. It returns the
+// same exit codes as Validate.
+func (ce CommandExecutor) Doctor(format string) int {
+	report := ce.processor.Doctor()
+	if format == "json" {
+		bytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logger.Error("Failed to format report", "error", err)
+			return 1
+		}
+		fmt.Println(string(bytes))
+		return report.ExitCode
+	}
+	for _, check := range report.Checks {
+		fmt.Printf("%-7v %-7v %-22v %v\n", check.Status, check.Severity, check.ID, check.Description)
+		if len(check.Message) > 0 {
+			fmt.Printf("        %v\n", check.Message)
+		}
+		if len(check.Fix) > 0 {
+			fmt.Printf("        Fix: %v\n", check.Fix)
+		}
+	}
+	return report.ExitCode
+}
+
+// LiveTest connects a real server's source under a temporary label, updates it and checks what
+// was saved, then removes it. It returns the same exit codes as Validate.
+func (ce CommandExecutor) LiveTest(notificationURL string, opts service.LiveTestOptions, format string) int {
+	report := ce.processor.LiveTest(notificationURL, opts)
+	if format == "json" {
+		bytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logger.Error("Failed to format report", "error", err)
+			return 1
+		}
+		fmt.Println(string(bytes))
+		return report.ExitCode
+	}
+	for _, step := range report.Steps {
+		fmt.Printf("%-7v %-7v %-14v %8.1fs %v\n", step.Status, step.Severity, step.ID, step.Seconds, step.Description)
+		if len(step.Message) > 0 {
+			fmt.Printf("        %v\n", step.Message)
+		}
+	}
+	fmt.Printf("%v %q: version %d, %d objects\n", report.Source, report.Label, report.Version, report.Objects)
+	return report.ExitCode
+}
+
+// Simulate replays a recorded scenario in virtual time and prints what each run of the
+// simulated scheduler did. It returns the same exit codes as Validate, or 1 if the simulation
+// couldn't run.
+func (ce CommandExecutor) Simulate(dir string, opts service.SimulationOptions, format string) int {
+	scenario, err := service.ReadScenario(dir)
+	if err != nil {
+		logger.Error("Cannot read scenario", "dir", dir, "error", err)
+		return 1
+	}
+	report, err := ce.processor.Simulate(scenario, opts)
+	if err != nil {
+		logger.Error("Simulation failed", "scenario", dir, "error", err)
+		return 1
+	}
+	if format == "json" {
+		bytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logger.Error("Failed to format report", "error", err)
+			return 1
+		}
+		fmt.Println(string(bytes))
+		return report.ExitCode
+	}
+	for _, event := range report.Events {
+		fmt.Printf("%v %-7v %-24v version %d\n", event.At.Format(time.RFC3339), event.Command, event.Notification, event.Version)
+		for _, w := range event.Warnings {
+			fmt.Printf("    warning %v\n", w)
+		}
+		for _, alert := range event.Alerts {
+			fmt.Printf("    alert   %v\n", alert)
+		}
+		if len(event.Error) > 0 {
+			fmt.Printf("    error   %v\n", event.Error)
+		}
+	}
+	fmt.Printf("%v %q: %v to %v\n", report.Source, report.Label, report.Start.Format(time.RFC3339), report.End.Format(time.RFC3339))
+	return report.ExitCode
+}
+
+// VerifySnapshot compares a server's snapshot with an existing source and prints the
+// differences. It returns 0 when they match, 1 when they don't and 2 if the check failed.
+func (ce CommandExecutor) VerifySnapshot(url, src, label string) int {
+	cmp, err := ce.processor.VerifySnapshot(url, src, label)
+	if err != nil {
+		logger.Error("Snapshot verification failed", "error", err)
+		return 2
+	}
+	fmt.Printf(`Version    : %v
+Matching   : %v
+Missing    : %v
+Unexpected : %v
+Different  : %v
+`, cmp.Version, cmp.Matching, cmp.Missing, cmp.Unexpected, cmp.Different)
+	for _, d := range cmp.Examples {
+		fmt.Printf("%-10v %v %v\n", d.Kind, d.ObjectType, d.PrimaryKey)
+	}
+	if cmp.Missing+cmp.Unexpected+cmp.Different > 0 {
+		logger.Warn("Source has diverged from the server's snapshot", "source", src, "label", label)
+		return 1
+	}
+	logger.Info("Source matches the server's snapshot", "source", src, "label", label)
+	return 0
+}
+
+// ExportDeltas writes a file for each version in a range
+func (ce CommandExecutor) ExportDeltas(src, label string, fromVersion, toVersion uint32, format string, encoding service.OutputEncoding, dir string) {
+	paths, err := ce.processor.ExportDeltas(src, label, fromVersion, toVersion, format, encoding, dir)
+	for _, path := range paths {
+		fmt.Println(path)
+	}
+	if err != nil {
+		logger.Error("Export failed", "error", err)
+		return
+	}
+	logger.Info("Export finished successfully", "files", len(paths))
+}
+
+// Changes prints when the attributes of the objects with a key changed, with the values removed
+// and added at each version
+func (ce CommandExecutor) Changes(src, label, key string, attrs []string) {
+	entries, err := ce.processor.AttributeHistory(src, label, key, attrs)
+	if err != nil {
+		logger.Error("Failed to get attribute history", "error", err)
+		return
+	}
+	for _, entry := range entries {
+		applied := "-"
+		if !entry.Applied.IsZero() {
+			applied = entry.Applied.Format(time.RFC3339)
+		}
+		fmt.Printf("Version %v  %v  %v\n", entry.Version, applied, entry.ObjectType)
+		for _, change := range entry.Changes {
+			for _, v := range change.Removed {
+				fmt.Printf("  - %-16v %v\n", change.Name+":", v)
+			}
+			for _, v := range change.Added {
+				fmt.Printf("  + %-16v %v\n", change.Name+":", v)
+			}
+		}
+	}
+	logger.Info("Found attribute changes", "key", key, "versions", len(entries))
+}
+
+// DelegatedStats prints the objects for resources an RIR's stats file doesn't show as delegated.
+// It returns 0 if there are none, 1 if there are some and 2 if the check couldn't be done.
+func (ce CommandExecutor) DelegatedStats(src, label, statsFile, registry string) int {
+	report, err := ce.processor.CheckDelegations(src, label, statsFile, registry)
+	if err != nil {
+		logger.Error("Delegated stats check failed", "error", err)
+		return 2
+	}
+	fmt.Printf(`Stats file  : %v
+Checked     : %v
+Unparsed    : %v
+Undelegated : %v
+`, report.StatsFile, report.Checked, report.Unparsed, len(report.Undelegated))
+	for _, obj := range report.Undelegated {
+		kind := "undelegated"
+		if obj.Partly {
+			kind = "partly"
+		}
+		fmt.Printf("%-12v %v %v\n", kind, obj.ObjectType, obj.PrimaryKey)
+	}
+	if len(report.Undelegated) > 0 {
+		logger.Warn("Found objects for resources which aren't delegated", "source", src, "label", label, "objects", len(report.Undelegated))
+		return 1
+	}
+	return 0
+}
+
+// SetGraph writes the membership graph of a source's as-sets and route-sets to a file, or to
+// stdout if path is empty, and logs any cycles
+func (ce CommandExecutor) SetGraph(src, label, root, format, path string) {
+	graph, err := ce.processor.SetGraph(src, label, root)
+	if err != nil {
+		logger.Error("Failed to build set graph", "error", err)
+		return
+	}
+	out := os.Stdout
+	if len(path) > 0 {
+		if out, err = os.Create(path); err != nil {
+			logger.Error("Cannot create file", "path", path, "error", err)
+			return
+		}
+		defer out.Close()
+	}
+	if err = service.WriteSetGraph(out, graph, format); err != nil {
+		logger.Error("Failed to write set graph", "error", err)
+		return
+	}
+	for _, cycle := range graph.Cycles {
+		logger.Warn("Sets contain each other", "sets", strings.Join(cycle, ", "))
+	}
+	logger.Info("Set graph written", "nodes", len(graph.Nodes), "edges", len(graph.Edges), "cycles", len(graph.Cycles))
+}
+
+// Pin takes a read snapshot of the sources, or of a group when group isn't empty, and prints
+// its token, which lookup --pin and the /export endpoint read the sources at
+func (ce CommandExecutor) Pin(members []string, group, format string) {
+	var snapshot service.ReadSnapshot
+	var err error
+	if len(group) > 0 {
+		snapshot, err = ce.processor.PinGroup(group)
+	} else {
+		snapshot, err = ce.processor.PinSources(members)
+	}
+	if err != nil {
+		logger.Error("Pin failed", "error", err)
+		return
+	}
+	if format == "json" {
+		bytes, err := json.MarshalIndent(snapshot, "", "  ")
+		if err != nil {
+			logger.Error("Failed to marshal read snapshot", "error", err)
+			return
+		}
+		fmt.Println(string(bytes))
+		return
+	}
+	fmt.Println(snapshot.Token())
+}
+
+// Lookup prints the objects for the keys in a file, or stdin if path is "-", as they were at
+// version, or now if it's 0. The format is rpsl, where keys which weren't found are listed in
+// comments at the end, or json. It returns 1 if the objects can't be looked up.
+func (ce CommandExecutor) Lookup(src, label string, version uint32, path, format string, encoding service.OutputEncoding) int {
+	in := os.Stdin
+	if path != "-" {
+		var err error
+		if in, err = os.Open(path); err != nil {
+			logger.Error("Cannot open file", "path", path, "error", err)
+			return 1
+		}
+		defer in.Close()
+	}
+	keys, err := service.ReadLookupKeys(in)
+	if err != nil {
+		logger.Error("Cannot read keys", "path", path, "error", err)
+		return 1
+	}
+	result, err := ce.processor.Lookup(src, label, version, keys)
+	if err != nil {
+		logger.Error("Lookup failed", "error", err)
+		return 1
+	}
+	if format == "json" {
+		bytes, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			logger.Error("Failed to marshal result", "error", err)
+			return 1
+		}
+		fmt.Println(string(bytes))
+	} else {
+		out := service.NewEncodingWriter(os.Stdout, encoding)
+		for _, obj := range result.Objects {
+			fmt.Fprintln(out, strings.TrimRight(obj.Payload, "\n"))
+			fmt.Fprintln(out)
+		}
+		for _, key := range result.Missing {
+			fmt.Fprintf(out, "%% Not found: %v\n", key)
+		}
+		if err = out.Close(); err != nil {
+			logger.Error("Failed to write result", "error", err)
+			return 1
+		}
+	}
+	logger.Info("Lookup finished", "keys", len(keys), "objects", len(result.Objects), "missing", len(result.Missing))
+	return 0
+}
+
+// CompareUpstream prints how the mirror's objects with a key differ from the registry's, with
+// the values only in the mirror (-) and only in the registry (+). The format is text or json.
+func (ce CommandExecutor) CompareUpstream(src, label, objectType, key string, attrs []string, format string) {
+	comparisons, err := ce.processor.CompareUpstream(src, label, objectType, key, attrs)
+	if err != nil {
+		logger.Error("Failed to compare with upstream", "error", err)
+		return
+	}
+	if format == "json" {
+		bytes, err := json.MarshalIndent(comparisons, "", "  ")
+		if err != nil {
+			logger.Error("Failed to marshal result", "error", err)
+			return
+		}
+		fmt.Println(string(bytes))
+	} else {
+		for _, comparison := range comparisons {
+			fmt.Printf("%v %v  %v  %v\n", comparison.ObjectType, comparison.PrimaryKey, comparison.Status, comparison.Server)
+			for _, change := range comparison.Changes {
+				for _, v := range change.Removed {
+					fmt.Printf("  - %-16v %v\n", change.Name+":", v)
+				}
+				for _, v := range change.Added {
+					fmt.Printf("  + %-16v %v\n", change.Name+":", v)
+				}
+			}
+		}
+	}
+	for _, comparison := range comparisons {
+		if comparison.Status != service.UpstreamSame {
+			logger.Warn("Mirror differs from upstream", "type", comparison.ObjectType, "key", comparison.PrimaryKey, "status", comparison.Status)
+		}
+	}
+}
+
+// UndeleteObject restores a deleted object
+func (ce CommandExecutor) UndeleteObject(src, label, objectType, key string) {
+	restored, err := ce.processor.UndeleteObject(src, label, objectType, key)
+	if err != nil {
+		logger.Error("UndeleteObject failed with error", "error", err)
+		return
+	}
+	logger.Info("Undeleted object", "type", restored.ObjectType, "key", restored.PrimaryKey, "version", restored.FromVersion)
+}
+
+// ShowNotification prints a stored notification, or the one on the server if raw is true. When
+// verbatim is true the stored notification is printed exactly as it was received.
+func (ce CommandExecutor) ShowNotification(src, label string, version uint32, raw, verbatim bool) {
+	if raw {
+		bytes, err := ce.processor.FetchRawNotification(src, label)
+		if err != nil {
+			logger.Error("Failed to fetch notification", "error", err)
+			return
+		}
+		fmt.Println(string(bytes))
+		return
+	}
+	notification, err := ce.processor.GetNotification(src, label, version)
+	if err != nil {
+		logger.Error("Failed to get notification", "version", version, "error", err)
+		return
+	}
+	if verbatim {
+		if len(notification.Payload.Raw) == 0 {
+			logger.Warn("No verbatim copy of this notification was stored", "version", notification.Version)
+			return
+		}
+		fmt.Println(string(notification.Payload.Raw))
+		return
+	}
+	bytes, err := json.MarshalIndent(notification.Payload, "", "  ")
+	if err != nil {
+		logger.Error("Failed to format notification", "error", err)
+		return
+	}
+	fmt.Printf("# Version %v first seen at %v\n%v\n", notification.Version, notification.Created, string(bytes))
+}
+
+// Ownership writes the counts of a source's objects by maintainer and organisation to a file,
+// or to stdout if path is empty
+func (ce CommandExecutor) Ownership(src, label string, recent time.Duration, format, path string) {
+	report, err := ce.processor.Ownership(src, label, recent)
+	if err != nil {
+		logger.Error("Failed to count objects by owner", "error", err)
+		return
+	}
+	out := os.Stdout
+	if len(path) > 0 {
+		if out, err = os.Create(path); err != nil {
+			logger.Error("Cannot create file", "path", path, "error", err)
+			return
+		}
+		defer out.Close()
+	}
+	if err = service.WriteOwnership(out, report, format); err != nil {
+		logger.Error("Failed to write ownership report", "error", err)
+		return
+	}
+	logger.Info("Ownership report written", "owners", len(report.Owners), "fromVersion", report.FromVersion, "toVersion", report.ToVersion)
+}
+
+// StaleObjects writes the source's objects which haven't been modified for longer than maxAge,
+// or the source's object_ttl, as CSV or JSON
+func (ce CommandExecutor) StaleObjects(src, label string, maxAge time.Duration, objectTypes []string, format, path string) {
+	report, err := ce.processor.StaleObjects(src, label, maxAge, objectTypes)
+	if err != nil {
+		logger.Error("Failed to find stale objects", "error", err)
+		return
+	}
+	out := os.Stdout
+	if len(path) > 0 {
+		if out, err = os.Create(path); err != nil {
+			logger.Error("Cannot create file", "path", path, "error", err)
+			return
+		}
+		defer out.Close()
+	}
+	if err = service.WriteStaleObjects(out, report, format); err != nil {
+		logger.Error("Failed to write stale objects report", "error", err)
+		return
+	}
+	logger.Info("Stale objects report written", "stale", len(report.Stale), "checked", report.Checked, "undated", report.Undated, "maxAge", report.MaxAge)
+}
@@ -3,18 +3,24 @@ package cli
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/service"
 )
 
 type ProcessorStub struct{}
 
-func (ps ProcessorStub) Connect(url, label string) error {
-	return errors.New("test error")
+func (ps ProcessorStub) ConnectSample(url, label string, sample service.Sample) (service.SyncResult, error) {
+	return service.SyncResult{}, errors.New("test error")
 }
 
-func (ps ProcessorStub) Update(srcName, label string) error {
-	return nil
+func (ps ProcessorStub) ConnectAll(reqs []service.ConnectRequest, parallel int) []service.ConnectResult {
+	return []service.ConnectResult{}
+}
+
+func (ps ProcessorStub) Update(srcName, label string, catchUp service.CatchUpMode) (service.SyncResult, error) {
+	return service.SyncResult{}, nil
 }
 
 func (ps ProcessorStub) ListSources() ([]persist.NRTMSourceDetails, error) {
@@ -29,12 +35,184 @@ func (ps ProcessorStub) RemoveSource(src, label string) error {
 	return nil
 }
 
+func (ps ProcessorStub) Digest(src, label, period string) (service.ChangeDigest, error) {
+	return service.ChangeDigest{}, nil
+}
+
+func (ps ProcessorStub) SendDigest(digest service.ChangeDigest) error {
+	return nil
+}
+
+func (ps ProcessorStub) VerifyAuditLog() (int, error) {
+	return 0, nil
+}
+
+func (ps ProcessorStub) GetNotification(src, label string, version uint32) (persist.Notification, error) {
+	return persist.Notification{}, nil
+}
+
+func (ps ProcessorStub) FetchRawNotification(src, label string) ([]byte, error) {
+	return []byte{}, nil
+}
+
+func (ps ProcessorStub) IndexProgress() ([]persist.IndexProgress, error) {
+	return nil, nil
+}
+
+func (ps ProcessorStub) SchemaInfo() (persist.SchemaInfo, error) {
+	return persist.SchemaInfo{}, nil
+}
+
+func (ps ProcessorStub) PartitionObjects(partitions int) error {
+	return nil
+}
+
+func (ps ProcessorStub) CheckSchemaVersion(allowNewer bool) error {
+	return nil
+}
+
+func (ps ProcessorStub) VerifyCache(remove bool) (service.CacheReport, error) {
+	return service.CacheReport{}, nil
+}
+
+func (ps ProcessorStub) CheckConformance(url string, checkFiles, strictFileNames bool, ordering service.DeltaOrdering) service.ConformanceReport {
+	return service.ConformanceReport{}
+}
+
+func (ps ProcessorStub) Forecast(window time.Duration) (service.ForecastReport, error) {
+	return service.ForecastReport{}, nil
+}
+
+func (ps ProcessorStub) CheckReferences(src, label string) (service.ReferenceReport, error) {
+	return service.ReferenceReport{}, nil
+}
+
+func (ps ProcessorStub) Interrupt() *service.Interrupt {
+	return new(service.Interrupt)
+}
+
+func (ps ProcessorStub) Promote() error {
+	return nil
+}
+
+func (ps ProcessorStub) UpdateGroup(group string, catchUp service.CatchUpMode) ([]service.SyncResult, error) {
+	return []service.SyncResult{}, nil
+}
+
+func (ps ProcessorStub) UpdateAll(parallel int, catchUp service.CatchUpMode) (service.UpdateSummary, error) {
+	return service.UpdateSummary{Results: []service.UpdateOutcome{}}, nil
+}
+
+func (ps ProcessorStub) PauseSource(src, label string, paused bool) error {
+	return nil
+}
+
+func (ps ProcessorStub) PauseGroup(group string, paused bool) error {
+	return nil
+}
+
+func (ps ProcessorStub) ExportDeltas(src, label string, fromVersion, toVersion uint32, format string, encoding service.OutputEncoding, dir string) ([]string, error) {
+	return nil, nil
+}
+
+func (ps ProcessorStub) VerifySnapshot(url, src, label string) (persist.SnapshotComparison, error) {
+	return persist.SnapshotComparison{}, nil
+}
+
+func (ps ProcessorStub) AttributeHistory(src, label, key string, attrs []string) ([]service.AttributeHistoryEntry, error) {
+	return nil, nil
+}
+
+func (ps ProcessorStub) CheckDelegations(src, label, statsFile, registry string) (service.DelegationReport, error) {
+	return service.DelegationReport{}, nil
+}
+
+func (ps ProcessorStub) SetGraph(src, label, root string) (service.SetGraph, error) {
+	return service.SetGraph{}, nil
+}
+
+func (ps ProcessorStub) Ownership(src, label string, recent time.Duration) (service.OwnershipReport, error) {
+	return service.OwnershipReport{}, nil
+}
+
+func (ps ProcessorStub) Lookup(src, label string, version uint32, keys []string) (service.LookupResult, error) {
+	return service.LookupResult{}, nil
+}
+
+func (ps ProcessorStub) CompareUpstream(src, label, objectType, key string, attrs []string) ([]service.UpstreamComparison, error) {
+	return nil, nil
+}
+
+func (ps ProcessorStub) Doctor() service.DoctorReport {
+	return service.DoctorReport{}
+}
+
+func (ps ProcessorStub) LiveTest(url string, opts service.LiveTestOptions) service.LiveTestReport {
+	return service.LiveTestReport{}
+}
+
+func (ps ProcessorStub) Simulate(scenario service.Scenario, opts service.SimulationOptions) (service.SimulationReport, error) {
+	return service.SimulationReport{}, nil
+}
+
+func (ps ProcessorStub) ImportIRRd(path, url, label string, version uint32) (service.IRRdImportReport, error) {
+	return service.IRRdImportReport{}, nil
+}
+
+func (ps ProcessorStub) PinSources(members []string) (service.ReadSnapshot, error) {
+	return service.ReadSnapshot{}, nil
+}
+
+func (ps ProcessorStub) PinGroup(group string) (service.ReadSnapshot, error) {
+	return service.ReadSnapshot{}, nil
+}
+
+func (ps ProcessorStub) PinnedVersion(snapshot service.ReadSnapshot, source, label string) (uint32, error) {
+	return snapshot.Version(source, label)
+}
+
+func (ps ProcessorStub) StaleObjects(src, label string, maxAge time.Duration, objectTypes []string) (service.StaleObjectsReport, error) {
+	return service.StaleObjectsReport{}, nil
+}
+
+func (ps ProcessorStub) CleanupSessions(src string, olderThan time.Duration, dryRun bool) (service.SessionCleanupReport, error) {
+	return service.SessionCleanupReport{}, nil
+}
+
+func (ps ProcessorStub) SquashHistory(src, label string, before uint32) (persist.SquashedHistory, error) {
+	return persist.SquashedHistory{}, nil
+}
+
+func (ps ProcessorStub) CompactHistory(src, label string, fullEvery int) (persist.CompactedHistory, error) {
+	return persist.CompactedHistory{}, nil
+}
+
+func (ps ProcessorStub) SnapshotLineage(src, label string) ([]persist.SnapshotRef, error) {
+	return []persist.SnapshotRef{}, nil
+}
+
+func (ps ProcessorStub) FetchArchivedSnapshot(src, label string, version uint32, dir string) (string, error) {
+	return "", nil
+}
+
+func (ps ProcessorStub) GetOversizedObjects(src, label string) ([]persist.OversizedObject, error) {
+	return []persist.OversizedObject{}, nil
+}
+
+func (ps ProcessorStub) UndeleteObject(src, label, objectType, key string) (persist.ObjectVersion, error) {
+	return persist.ObjectVersion{}, nil
+}
+
 func TestCommandExecutorConnect(t *testing.T) {
-	ce := CommandExecutor{ProcessorStub{}}
-	ce.Connect("url", "label")
+	ce := CommandExecutor{processor: ProcessorStub{}}
+	if code := ce.Connect("url", "label", service.Sample{}); code != 1 {
+		t.Error("Expected a failed connect to exit with 1 but was", code)
+	}
 }
 
 func TestCommandExecutorUpdate(t *testing.T) {
-	ce := CommandExecutor{ProcessorStub{}}
-	ce.Update("srcName", "label")
+	ce := CommandExecutor{processor: ProcessorStub{}}
+	if code := ce.Update("srcName", "label", service.CatchUpAuto); code != 0 {
+		t.Error("Expected a successful update to exit with 0 but was", code)
+	}
 }
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/service"
+)
+
+const contextFlag = "context"
+
+// SelectContext takes --context out of the command line and applies the context it names to
+// config. Without the flag, the config file's current_context is applied, if there is one.
+func SelectContext(args []string, config *service.AppConfig) ([]string, error) {
+	args, name := removeValueFlag(args, contextFlag)
+	return args, config.UseContext(name)
+}
+
+// UseContext is the use-context command. With a name it makes that context the current one in
+// the config file, without one it lists the contexts. It doesn't need the database, so it's run
+// before a connection is made. It returns an exit code.
+func UseContext(args []string, configFile string, config service.AppConfig) int {
+	if len(configFile) == 0 {
+		logger.Error("NRTM4_CONFIG_FILE must be set to use contexts")
+		return 1
+	}
+	if len(args) == 0 {
+		for _, name := range slices.Sorted(maps.Keys(config.Contexts)) {
+			marker := " "
+			if name == config.CurrentContext {
+				marker = "*"
+			}
+			ctx := config.Contexts[name]
+			fmt.Printf("%v %-16v %v %v\n", marker, name, ctx.FilePath, redactDatabaseURL(ctx.DatabaseURL))
+		}
+		return 0
+	}
+	if err := service.SetCurrentContext(configFile, args[0]); err != nil {
+		logger.Error("Cannot switch context", "context", args[0], "error", err)
+		return 1
+	}
+	logger.Info("Switched context", "context", args[0])
+	return 0
+}
+
+// redactDatabaseURL hides the password in a database URL
+func redactDatabaseURL(dbURL string) string {
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted()
+}
+
+// removeValueFlag takes a flag and its value out of args, as either "--name value" or
+// "--name=value"
+func removeValueFlag(args []string, name string) ([]string, string) {
+	value := ""
+	remaining := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-"+name || arg == "--"+name {
+			if i+1 < len(args) {
+				value = args[i+1]
+				i++
+			}
+			continue
+		}
+		if v, ok := strings.CutPrefix(arg, "-"+name+"="); ok {
+			value = v
+			continue
+		}
+		if v, ok := strings.CutPrefix(arg, "--"+name+"="); ok {
+			value = v
+			continue
+		}
+		remaining = append(remaining, arg)
+	}
+	return remaining, value
+}
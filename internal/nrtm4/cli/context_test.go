@@ -0,0 +1,28 @@
+package cli
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRemoveValueFlag(t *testing.T) {
+	for _, args := range [][]string{
+		{"nrtm4client", "--context", "prod", "list"},
+		{"nrtm4client", "list", "-context=prod"},
+	} {
+		remaining, value := removeValueFlag(args, contextFlag)
+		if value != "prod" || !slices.Equal(remaining, []string{"nrtm4client", "list"}) {
+			t.Error("Unexpected result for", args, remaining, value)
+		}
+	}
+	remaining, value := removeValueFlag([]string{"nrtm4client", "list"}, contextFlag)
+	if value != "" || len(remaining) != 2 {
+		t.Error("Expected args without the flag to be unchanged", remaining, value)
+	}
+}
+
+func TestRedactDatabaseURL(t *testing.T) {
+	if url := redactDatabaseURL("postgres://nrtm4:secret@db:5432/nrtm4"); url != "postgres://nrtm4:xxxxx@db:5432/nrtm4" {
+		t.Error("Expected the password to be hidden but was", url)
+	}
+}
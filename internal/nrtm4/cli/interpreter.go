@@ -4,8 +4,14 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/service"
 )
 
 var (
@@ -15,97 +21,784 @@ var (
 
 const mandatorySourceMessage = "Source name must be provided with the -source flag"
 
+const sourceOrGroupMessage = "Either -source or -group must be provided, not both"
+
+const allowForwardCompatFlag = "allow-forward-compat"
+
+// schemaAccess is how a command uses the database, which decides how its schema version is checked
+type schemaAccess int
+
+const (
+	// writesSchema commands need the schema version to match
+	writesSchema schemaAccess = iota
+	// readsSchema commands can also be run against a newer schema with --allow-forward-compat
+	readsSchema
+	// skipsSchema commands aren't checked: they don't use the database, report on the schema
+	// themselves, or run commands which are checked one by one
+	skipsSchema
+)
+
+// commandSchemaAccess classifies every command by how it uses the database
+var commandSchemaAccess = map[string]schemaAccess{
+	"connect":           writesSchema,
+	"update":            writesSchema,
+	"rename":            writesSchema,
+	"remove":            writesSchema,
+	"pause":             writesSchema,
+	"resume":            writesSchema,
+	"db":                writesSchema,
+	"promote":           writesSchema,
+	"undelete":          writesSchema,
+	"gc-sessions":       writesSchema,
+	"squash":            writesSchema,
+	"compact-history":   writesSchema,
+	"e2e-live":          writesSchema,
+	"simulate":          writesSchema,
+	"import-irrd":       writesSchema,
+	"list":              readsSchema,
+	"digest":            readsSchema,
+	"show-notification": readsSchema,
+	"verify-audit":      readsSchema,
+	"verify-cache":      readsSchema,
+	"export-deltas":     readsSchema,
+	"changes":           readsSchema,
+	"delegated-stats":   readsSchema,
+	"set-graph":         readsSchema,
+	"ownership":         readsSchema,
+	"stale-objects":     readsSchema,
+	"forecast":          readsSchema,
+	"check-references":  readsSchema,
+	"lookup":            readsSchema,
+	"pin":               readsSchema,
+	"snapshots":         readsSchema,
+	"oversized":         readsSchema,
+	"compare-upstream":  readsSchema,
+	"validate":          skipsSchema,
+	"doctor":            skipsSchema,
+	"batch":             skipsSchema,
+}
+
 // Exec reads the command line args and invokes functions on the commander
 func Exec(commander CommandExecutor) {
 
 	connectCommand := func(args []string) {
-		fs := flag.NewFlagSet("connect", flag.ExitOnError)
+		fs := newFlagSet("connect")
 		notificationURL := fs.String("url", "", "URL to notification JSON")
 		sourceLabel := fs.String("label", "", "The label for the source. Can be empty.")
-		if err := fs.Parse(args); err != nil {
-			fmt.Printf("error: %s", err)
-			return
+		verifyAgainst := fs.String("verify-against", "", "Compare the snapshot with an existing SOURCE or SOURCE/label instead of connecting")
+		fromConfig := fs.String("from-config", "", "Connect every source listed in a JSON file, in parallel")
+		parallel := fs.Int("parallel", 4, "How many sources --from-config connects at once")
+		sampleFlag := fs.String("sample", "", "Load only a sample of the snapshot: a percentage, e.g. 1%, or a number of objects")
+		parseFlags(fs, args)
+		sample, err := service.ParseSample(*sampleFlag)
+		if err != nil {
+			fatal(err)
+		}
+		if len(*fromConfig) > 0 {
+			if len(*notificationURL) > 0 || len(*verifyAgainst) > 0 {
+				fatal("--from-config can't be used with --url or --verify-against")
+			}
+			if !sample.IsZero() {
+				fatal("--sample can't be used with --from-config")
+			}
+			exit(commander.ConnectAll(*fromConfig, *parallel))
 		}
 		if len(*notificationURL) == 0 {
-			log.Fatal("URL must be provided")
+			fatal("URL must be provided")
 		}
-		commander.Connect(*notificationURL, *sourceLabel)
+		if len(*verifyAgainst) > 0 {
+			if !sample.IsZero() {
+				fatal("--sample can't be used with --verify-against")
+			}
+			src, lbl, _ := strings.Cut(*verifyAgainst, "/")
+			exit(commander.VerifySnapshot(*notificationURL, src, lbl))
+		}
+		exit(commander.Connect(*notificationURL, *sourceLabel, sample))
 	}
 
 	updateCommand := func(args []string) {
-		fs := flag.NewFlagSet("update", flag.ExitOnError)
+		fs := newFlagSet("update")
 		src := fs.String("source", "", "The name of the source")
 		lbl := fs.String("label", "", "The label for the source. Can be empty.")
-		if err := fs.Parse(args); err != nil {
-			fmt.Printf("error: %s", err)
+		group := fs.String("group", "", "Update every source in a group from the config file")
+		all := fs.Bool("all", false, "Update every source, in parallel")
+		parallel := fs.Int("parallel", 4, "How many sources --all updates at once")
+		format := fs.String("format", "text", "Outcome format for --all: text or json")
+		catchUpFlag := fs.String("catch-up", "auto", "How to apply a backlog of deltas: auto, deltas or snapshot")
+		parseFlags(fs, args)
+		catchUp, err := service.ParseCatchUpMode(*catchUpFlag)
+		if err != nil {
+			fatal(err)
+		}
+		if *all {
+			if len(*src) > 0 || len(*group) > 0 {
+				fatal("--all can't be used with --source or --group")
+			}
+			if *format != "text" && *format != "json" {
+				fatalf("Unknown format: %v", *format)
+			}
+			exit(commander.UpdateAll(*parallel, catchUp, *format))
+		}
+		if len(*group) > 0 {
+			if len(*src) > 0 {
+				fatalf(sourceOrGroupMessage)
+			}
+			exit(commander.UpdateGroup(*group, catchUp))
 			return
 		}
+		commander.useDefaultSource(src, lbl)
 		if len(*src) == 0 {
-			log.Fatalf(mandatorySourceMessage)
+			fatalf(mandatorySourceMessage)
 		}
-		commander.Update(*src, *lbl)
+		exit(commander.Update(*src, *lbl, catchUp))
 	}
 
-	listCommand := func(args []string) {
-		fs := flag.NewFlagSet("list", flag.ExitOnError)
+	pauseCommand := func(name string, paused bool, args []string) {
+		fs := newFlagSet(name)
 		src := fs.String("source", "", "The name of the source")
 		lbl := fs.String("label", "", "The label for the source. Can be empty.")
-		if err := fs.Parse(args); err != nil {
-			fmt.Printf("error: %s", err)
-			return
+		group := fs.String("group", "", "Apply to every source in a group from the config file")
+		parseFlags(fs, args)
+		if len(*src) > 0 == (len(*group) > 0) {
+			fatalf(sourceOrGroupMessage)
 		}
+		commander.Pause(*src, *lbl, *group, paused)
+	}
+
+	listCommand := func(args []string) {
+		fs := newFlagSet("list")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		parseFlags(fs, args)
 		commander.ListSources(*src, *lbl)
 	}
 
 	replaceLabelCommand := func(args []string) {
-		fs := flag.NewFlagSet("rename", flag.ExitOnError)
+		fs := newFlagSet("rename")
 		src := fs.String("source", "", "The name of the source")
 		lbl := fs.String("label", "", "The label for the source. Can be empty.")
 		tolbl := fs.String("to", "", "The replacement label text")
-		if err := fs.Parse(args); err != nil {
-			fmt.Printf("error: %s", err)
-			return
-		}
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
 		if len(*src) == 0 {
-			log.Fatalf(mandatorySourceMessage)
+			fatalf(mandatorySourceMessage)
 		}
 		if len(*lbl) == 0 && len(*tolbl) == 0 {
-			log.Fatalf("At least -label or -to must be specified")
+			fatalf("At least -label or -to must be specified")
 		}
 		commander.ReplaceLabel(*src, *lbl, *tolbl)
 	}
 
 	removeCommand := func(args []string) {
-		fs := flag.NewFlagSet("remove", flag.ExitOnError)
+		fs := newFlagSet("remove")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		commander.RemoveSource(*src, *lbl)
+	}
+
+	digestCommand := func(args []string) {
+		fs := newFlagSet("digest")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		period := fs.String("period", "daily", "Period to summarize: daily or weekly")
+		send := fs.Bool("send", false, "Send the digest with the configured notifier")
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		commander.Digest(*src, *lbl, *period, *send)
+	}
+
+	showNotificationCommand := func(args []string) {
+		fs := newFlagSet("show-notification")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		version := fs.Uint("version", 0, "Version of a stored notification. Default is the most recent")
+		raw := fs.Bool("raw", false, "Fetch the notification from the server and print it as is")
+		verbatim := fs.Bool("verbatim", false, "Print the stored notification exactly as the server sent it")
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		commander.ShowNotification(*src, *lbl, uint32(*version), *raw, *verbatim)
+	}
+
+	exportDeltasCommand := func(args []string) {
+		fs := newFlagSet("export-deltas")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		from := fs.Uint("from", 0, "First version to export")
+		to := fs.Uint("to", 0, "Last version to export. Default is the source's current version")
+		format := fs.String("format", service.RPSLDiffFormat, "Export format: rpsl-diff")
+		encodingFlag := fs.String("encoding", "utf-8", "Text encoding: utf-8, latin-1 or escape")
+		dir := fs.String("dir", ".", "Directory the files are written to")
+		parseFlags(fs, args)
+		encoding, err := service.ParseOutputEncoding(*encodingFlag)
+		if err != nil {
+			fatal(err)
+		}
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		if *from == 0 {
+			fatalf("-from must be provided")
+		}
+		commander.ExportDeltas(*src, *lbl, uint32(*from), uint32(*to), *format, encoding, *dir)
+	}
+
+	changesCommand := func(args []string) {
+		fs := newFlagSet("changes")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		key := fs.String("key", "", "Primary key of the object. A route prefix finds every route for it")
+		attrs := fs.String("attr", "", "Comma-separated attributes to report on. Default is all of them")
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		if len(*key) == 0 {
+			fatalf("-key must be provided")
+		}
+		var attrList []string
+		if len(*attrs) > 0 {
+			attrList = strings.Split(*attrs, ",")
+		}
+		commander.Changes(*src, *lbl, *key, attrList)
+	}
+
+	delegatedStatsCommand := func(args []string) {
+		fs := newFlagSet("delegated-stats")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		file := fs.String("file", "", "URL or path of a delegated-extended stats file. Default is the source's delegated_stats config")
+		registry := fs.String("registry", "", "Only use records for this registry, e.g. ripencc")
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		exit(commander.DelegatedStats(*src, *lbl, *file, *registry))
+	}
+
+	setGraphCommand := func(args []string) {
+		fs := newFlagSet("set-graph")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		root := fs.String("root", "", "Only include sets reachable from this as-set or route-set")
+		format := fs.String("format", service.DOTFormat, "Output format: dot or json")
+		out := fs.String("out", "", "File to write to. Default is stdout")
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		commander.SetGraph(*src, *lbl, *root, *format, *out)
+	}
+
+	ownershipCommand := func(args []string) {
+		fs := newFlagSet("ownership")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		recent := fs.Duration("recent", 30*24*time.Hour, "Count the changes made in this period, e.g. 168h")
+		format := fs.String("format", service.CSVFormat, "Output format: csv or json")
+		out := fs.String("out", "", "File to write to. Default is stdout")
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		commander.Ownership(*src, *lbl, *recent, *format, *out)
+	}
+
+	staleObjectsCommand := func(args []string) {
+		fs := newFlagSet("stale-objects")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		olderThan := fs.Duration("older-than", 0, "Report objects not modified for this long, e.g. 17520h. Default is the source's object_ttl")
+		types := fs.String("type", "", "Comma-separated object types to check. Default is every type")
+		format := fs.String("format", service.CSVFormat, "Output format: csv or json")
+		out := fs.String("out", "", "File to write to. Default is stdout")
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		var typeList []string
+		if len(*types) > 0 {
+			typeList = strings.Split(*types, ",")
+		}
+		commander.StaleObjects(*src, *lbl, *olderThan, typeList, *format, *out)
+	}
+
+	checkReferencesCommand := func(args []string) {
+		fs := newFlagSet("check-references")
 		src := fs.String("source", "", "The name of the source")
 		lbl := fs.String("label", "", "The label for the source. Can be empty.")
-		if err := fs.Parse(args); err != nil {
-			fmt.Printf("error: %s", err)
+		format := fs.String("format", service.CSVFormat, "Output format: csv or json")
+		out := fs.String("out", "", "File to write to. Default is stdout")
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		commander.CheckReferences(*src, *lbl, *format, *out)
+	}
+
+	forecastCommand := func(args []string) {
+		fs := newFlagSet("forecast")
+		window := fs.Duration("window", 0, "How far back to measure growth, e.g. 168h. Default is 720h")
+		format := fs.String("format", "text", "Output format: text or json")
+		parseFlags(fs, args)
+		if *format != "text" && *format != "json" {
+			fatalf("Unknown format: %v", *format)
+		}
+		if *window < 0 {
+			fatal("--window can't be negative")
+		}
+		commander.Forecast(*window, *format)
+	}
+
+	lookupCommand := func(args []string) {
+		fs := newFlagSet("lookup")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		file := fs.String("file", "-", "File with one primary key per line. Default is stdin")
+		format := fs.String("format", "rpsl", "Output format: rpsl or json")
+		atVersion := fs.Uint("at-version", 0, "Look up the objects as they were at this version. Default is the latest.")
+		encodingFlag := fs.String("encoding", "utf-8", "Text encoding of rpsl output: utf-8, latin-1 or escape")
+		pin := fs.String("pin", "", "Look up the objects at the version a token from the pin command pins the source at")
+		parseFlags(fs, args)
+		encoding, err := service.ParseOutputEncoding(*encodingFlag)
+		if err != nil {
+			fatal(err)
+		}
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		if len(*pin) > 0 {
+			if *atVersion > 0 {
+				fatal("--pin can't be used with --at-version")
+			}
+			snapshot, err := service.ParseReadSnapshot(*pin)
+			if err != nil {
+				fatal(err)
+			}
+			version, err := commander.processor.PinnedVersion(snapshot, *src, *lbl)
+			if err != nil {
+				fatal(err)
+			}
+			*atVersion = uint(version)
+		}
+		if *format != "rpsl" && *format != "json" {
+			fatalf("Unknown format: %v", *format)
+		}
+		if *format == "json" && encoding != service.EncodingUTF8 {
+			fatalf("--encoding only applies to the rpsl format")
+		}
+		exit(commander.Lookup(*src, *lbl, uint32(*atVersion), *file, *format, encoding))
+	}
+
+	pinCommand := func(args []string) {
+		fs := newFlagSet("pin")
+		sources := fs.String("sources", "", "Comma-separated sources to pin, each SOURCE or SOURCE/label. Default is every source")
+		group := fs.String("group", "", "Pin the sources in this group")
+		format := fs.String("format", "text", "Output format: text for the token, or json")
+		parseFlags(fs, args)
+		if len(*sources) > 0 && len(*group) > 0 {
+			fatal("--sources can't be used with --group")
+		}
+		if *format != "text" && *format != "json" {
+			fatalf("Unknown format: %v", *format)
+		}
+		var members []string
+		if len(*sources) > 0 {
+			members = strings.Split(*sources, ",")
+		}
+		commander.Pin(members, *group, *format)
+	}
+
+	compareUpstreamCommand := func(args []string) {
+		fs := newFlagSet("compare-upstream")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		objectType := fs.String("type", "", "Object type. Default is the type of each of the mirror's objects with the key")
+		key := fs.String("key", "", "Primary key of the object")
+		attrs := fs.String("attr", "", "Comma-separated attributes to compare. Default is all of them")
+		format := fs.String("format", "text", "Output format: text or json")
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		if len(*key) == 0 {
+			fatalf("-key must be provided")
+		}
+		if *format != "text" && *format != "json" {
+			fatalf("Unknown format: %v", *format)
+		}
+		var attrList []string
+		if len(*attrs) > 0 {
+			attrList = strings.Split(*attrs, ",")
+		}
+		commander.CompareUpstream(*src, *lbl, *objectType, *key, attrList, *format)
+	}
+
+	undeleteCommand := func(args []string) {
+		fs := newFlagSet("undelete")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		objectType := fs.String("type", "", "The object type, e.g. route")
+		key := fs.String("key", "", "The primary key of the object")
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		if len(*objectType) == 0 || len(*key) == 0 {
+			fatalf("Both --type and --key must be provided")
+		}
+		commander.UndeleteObject(*src, *lbl, *objectType, *key)
+	}
+
+	squashCommand := func(args []string) {
+		fs := newFlagSet("squash")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		before := fs.Uint("before", 0, "Collapse the history before this version into a baseline at it")
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		if *before == 0 {
+			fatalf("--before must be provided")
+		}
+		commander.SquashHistory(*src, *lbl, uint32(*before))
+	}
+
+	compactHistoryCommand := func(args []string) {
+		fs := newFlagSet("compact-history")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		fullEvery := fs.Int("full-every", 10, "Keep every Nth version of an object in full")
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		commander.CompactHistory(*src, *lbl, *fullEvery)
+	}
+
+	snapshotsCommand := func(args []string) {
+		fs := newFlagSet("snapshots")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		fetch := fs.Uint("fetch", 0, "Download the recorded snapshot with this version")
+		dir := fs.String("dir", ".", "Directory the snapshot is downloaded to")
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
+		if len(*src) == 0 {
+			fatalf(mandatorySourceMessage)
+		}
+		if *fetch > 0 {
+			commander.FetchArchivedSnapshot(*src, *lbl, uint32(*fetch), *dir)
 			return
 		}
+		commander.SnapshotLineage(*src, *lbl)
+	}
+
+	oversizedCommand := func(args []string) {
+		fs := newFlagSet("oversized")
+		src := fs.String("source", "", "The name of the source")
+		lbl := fs.String("label", "", "The label for the source. Can be empty.")
+		show := fs.Bool("show", false, "Print the start of each quarantined record")
+		parseFlags(fs, args)
+		commander.useDefaultSource(src, lbl)
 		if len(*src) == 0 {
-			log.Fatalf(mandatorySourceMessage)
+			fatalf(mandatorySourceMessage)
 		}
-		commander.RemoveSource(*src, *lbl)
+		commander.OversizedObjects(*src, *lbl, *show)
 	}
 
-	runCmd := func(args []string) {
+	validateCommand := func(args []string) {
+		fs := newFlagSet("validate")
+		notificationURL := fs.String("url", "", "URL to notification JSON")
+		checkFiles := fs.Bool("files", false, "Download the snapshot and delta files and check their hashes")
+		strictFileNames := fs.Bool("strict-file-names", false, "Fail when file names don't follow the spec's naming convention")
+		orderingFlag := fs.String("ordering", "", "Check the changes inside delta files: strict or allow-add-delete. Needs --files")
+		format := fs.String("format", "text", "Report format: text or json")
+		parseFlags(fs, args)
+		if len(*notificationURL) == 0 {
+			fatal("URL must be provided")
+		}
+		ordering, err := service.ParseDeltaOrdering(*orderingFlag)
+		if err != nil {
+			fatal(err)
+		}
+		if ordering != service.OrderingOff && !*checkFiles {
+			fatal("--ordering needs --files")
+		}
+		if *format != "text" && *format != "json" {
+			fatalf("Unknown format: %v", *format)
+		}
+		exit(commander.Validate(*notificationURL, *checkFiles, *strictFileNames, ordering, *format))
+	}
+
+	liveTestCommand := func(args []string) {
+		fs := newFlagSet("e2e-live")
+		notificationURL := fs.String("url", "", "URL to the notification JSON of a live server")
+		mntBy := fs.String("mnt-by", "", "Comma-separated maintainers whose objects are saved")
+		org := fs.String("org", "", "Comma-separated organisations whose objects are saved")
+		maxSize := fs.Int64("max-size", 1024, "Largest snapshot or delta file to download, in MiB. 0 is no limit")
+		keep := fs.Bool("keep", false, "Keep the test source and its files instead of removing them")
+		format := fs.String("format", "text", "Report format: text or json")
+		parseFlags(fs, args)
+		if len(*notificationURL) == 0 {
+			fatal("URL must be provided")
+		}
+		if len(*mntBy) == 0 && len(*org) == 0 {
+			fatal("At least one of --mnt-by or --org must be provided")
+		}
+		if *format != "text" && *format != "json" {
+			fatalf("Unknown format: %v", *format)
+		}
+		filter := &service.FilterConfig{}
+		if len(*mntBy) > 0 {
+			filter.MntBy = strings.Split(*mntBy, ",")
+		}
+		if len(*org) > 0 {
+			filter.Org = strings.Split(*org, ",")
+		}
+		opts := service.LiveTestOptions{Filter: filter, MaxFileSize: *maxSize << 20, Keep: *keep}
+		exit(commander.LiveTest(*notificationURL, opts, *format))
+	}
+
+	simulateCommand := func(args []string) {
+		fs := newFlagSet("simulate")
+		dir := fs.String("scenario", "", "Directory with scenario.json and the files it replays")
+		keep := fs.Bool("keep", false, "Keep the simulated source and its files instead of removing them")
+		format := fs.String("format", "text", "Report format: text or json")
+		parseFlags(fs, args)
+		if len(*dir) == 0 {
+			fatal("Scenario directory must be provided")
+		}
+		if *format != "text" && *format != "json" {
+			fatalf("Unknown format: %v", *format)
+		}
+		exit(commander.Simulate(*dir, service.SimulationOptions{Keep: *keep}, *format))
+	}
+
+	importIRRdCommand := func(args []string) {
+		fs := newFlagSet("import-irrd")
+		path := fs.String("file", "", "IRRd export to import, RPSL text, optionally gzipped")
+		notificationURL := fs.String("url", "", "URL to notification JSON")
+		version := fs.Uint("version", 0, "The server's NRTMv4 version the export was taken at")
+		sourceLabel := fs.String("label", "", "The label for the source. Can be empty.")
+		parseFlags(fs, args)
+		if len(*path) == 0 || len(*notificationURL) == 0 {
+			fatal("--file and --url must be provided")
+		}
+		if *version == 0 || *version > math.MaxUint32 {
+			fatal("--version must be the NRTMv4 version the export was taken at")
+		}
+		exit(commander.ImportIRRd(*path, *notificationURL, *sourceLabel, uint32(*version)))
+	}
+
+	doctorCommand := func(args []string) {
+		fs := newFlagSet("doctor")
+		format := fs.String("format", "text", "Report format: text or json")
+		parseFlags(fs, args)
+		if *format != "text" && *format != "json" {
+			fatalf("Unknown format: %v", *format)
+		}
+		exit(commander.Doctor(*format))
+	}
+
+	verifyCacheCommand := func(args []string) {
+		fs := newFlagSet("verify-cache")
+		remove := fs.Bool("delete", false, "Delete files which are corrupt, stale or orphaned")
+		parseFlags(fs, args)
+		commander.VerifyCache(*remove)
+	}
+
+	gcSessionsCommand := func(args []string) {
+		fs := newFlagSet("gc-sessions")
+		src := fs.String("source", "", "Only clean up sessions of this source")
+		olderThan := fs.Duration("older-than", 0, "Only remove sessions superseded longer ago than this, e.g. 720h")
+		dryRun := fs.Bool("dry-run", false, "List the sessions which would be removed")
+		parseFlags(fs, args)
+		commander.CleanupSessions(*src, *olderThan, *dryRun)
+	}
+
+	dbPartitionCommand := func(args []string) {
+		fs := newFlagSet("db partition")
+		partitions := fs.Int("partitions", 0, "Number of partitions to split the objects table into")
+		parseFlags(fs, args)
+		if *partitions < 2 {
+			fatalf("-partitions must be 2 or more")
+		}
+		commander.PartitionObjects(*partitions)
+	}
+
+	dbCommand := func(args []string) {
+		if len(args) == 0 {
+			fatalf("db needs a subcommand: schema, indexes, partition")
+		}
+		switch args[0] {
+		case "schema":
+			commander.ShowSchema()
+		case "indexes":
+			commander.ShowIndexes()
+		case "partition":
+			dbPartitionCommand(args[1:])
+		default:
+			fatalf("Unknown db subcommand: %v", args[0])
+		}
+	}
+
+	checkSchema := func(args []string, allowForwardCompat bool) {
+		// Anything else is an alias, which is checked once it's expanded
+		access, known := commandSchemaAccess[args[1]]
+		if !known || access == skipsSchema {
+			return
+		}
+		if args[1] == "db" && len(args) > 2 && (args[2] == "schema" || args[2] == "indexes") {
+			access = readsSchema
+		}
+		if allowForwardCompat && access != readsSchema {
+			fatalf("-%v can only be used with read-only commands", allowForwardCompatFlag)
+		}
+		if !commander.CheckSchemaVersion(allowForwardCompat) {
+			exit(1)
+		}
+	}
+
+	// Signals only change what a sync does, so other commands are still ended by them
+	var watchOnce sync.Once
+	watchInterrupts := func() {
+		watchOnce.Do(commander.WatchInterruptSignals)
+	}
+
+	var runCmd func(args []string)
+
+	batchCommand := func(args []string) {
+		fs := newFlagSet("batch")
+		keepGoing := fs.Bool("keep-going", false, "Carry on after a command fails")
+		parseFlags(fs, args)
+		exit(runBatch(os.Stdin, *keepGoing, func(args []string) {
+			if len(args) > 0 && args[0] == "batch" {
+				fatalf("batch can't be used in a batch")
+			}
+			runCmd(append([]string{os.Args[0]}, args...))
+		}))
+	}
+
+	runCmd = func(args []string) {
+		args, allowForwardCompat := removeFlag(args, allowForwardCompatFlag)
 		if len(args) >= 2 {
+			checkSchema(args, allowForwardCompat)
 			subArgs := args[2:]
 			switch args[1] {
 			case "connect":
+				watchInterrupts()
 				connectCommand(subArgs)
 			case "update":
+				watchInterrupts()
 				updateCommand(subArgs)
 			case "list":
 				listCommand(subArgs)
+			case "pause":
+				pauseCommand("pause", true, subArgs)
+			case "resume":
+				pauseCommand("resume", false, subArgs)
 			case "rename":
 				replaceLabelCommand(subArgs)
 			case "remove":
 				removeCommand(subArgs)
+			case "digest":
+				digestCommand(subArgs)
+			case "show-notification":
+				showNotificationCommand(subArgs)
+			case "verify-audit":
+				commander.VerifyAuditLog()
+			case "promote":
+				commander.Promote()
+			case "export-deltas":
+				exportDeltasCommand(subArgs)
+			case "changes":
+				changesCommand(subArgs)
+			case "delegated-stats":
+				delegatedStatsCommand(subArgs)
+			case "set-graph":
+				setGraphCommand(subArgs)
+			case "ownership":
+				ownershipCommand(subArgs)
+			case "stale-objects":
+				staleObjectsCommand(subArgs)
+			case "forecast":
+				forecastCommand(subArgs)
+			case "check-references":
+				checkReferencesCommand(subArgs)
+			case "pin":
+				pinCommand(subArgs)
+			case "lookup":
+				lookupCommand(subArgs)
+			case "compare-upstream":
+				compareUpstreamCommand(subArgs)
+			case "undelete":
+				undeleteCommand(subArgs)
+			case "gc-sessions":
+				gcSessionsCommand(subArgs)
+			case "squash":
+				squashCommand(subArgs)
+			case "compact-history":
+				compactHistoryCommand(subArgs)
+			case "snapshots":
+				snapshotsCommand(subArgs)
+			case "oversized":
+				oversizedCommand(subArgs)
+			case "validate":
+				validateCommand(subArgs)
+			case "verify-cache":
+				verifyCacheCommand(subArgs)
+			case "doctor":
+				doctorCommand(subArgs)
+			case "e2e-live":
+				liveTestCommand(subArgs)
+			case "simulate":
+				simulateCommand(subArgs)
+			case "import-irrd":
+				watchInterrupts()
+				importIRRdCommand(subArgs)
+			case "db":
+				dbCommand(subArgs)
+			case "batch":
+				batchCommand(subArgs)
 			default:
+				expanded, ok, err := commander.expandAlias(args)
+				if err != nil {
+					fatalf("Cannot expand alias %v: %v", args[1], err)
+				}
+				if ok {
+					if allowForwardCompat {
+						expanded = append(expanded, "--"+allowForwardCompatFlag)
+					}
+					runCmd(expanded)
+					return
+				}
 				log.Print(usage(args[0]))
 				flag.Usage()
+				exit(1)
 			}
 		} else {
 			log.Print(usage(args[0]))
@@ -135,6 +828,20 @@ func Exec(commander CommandExecutor) {
 
 }
 
+// removeFlag takes a boolean flag out of args so the command's flag set doesn't reject it
+func removeFlag(args []string, name string) ([]string, bool) {
+	found := false
+	remaining := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "-"+name || arg == "--"+name {
+			found = true
+			continue
+		}
+		remaining = append(remaining, arg)
+	}
+	return remaining, found
+}
+
 func usage(cmd string) string {
 	return fmt.Sprintf(`
 	%v <command> OPTIONS
@@ -146,6 +853,7 @@ func usage(cmd string) string {
 	PG_DATABASE_URL
 	URL to the PostgreSQL database in this format:
 	postgresql://[user[:password]@][netloc][:port][/dbname][?param1=value1&...]
+	or mem:// to keep everything in memory until the client exits.
 
 	NRTM4_FILE_PATH
 	The path where downloaded NRTMv4 snapshot and delta files will be written. If
@@ -153,6 +861,9 @@ func usage(cmd string) string {
 	during updates; when the update is complete the files can be removed.
 	...Which is probably a good idea, there's a lot of files.
 
+	Both can be set by a context in NRTM4_CONFIG_FILE instead. Select one with
+	--context NAME, or make it the default with: %v use-context NAME
+
 
 	E.g.
 	envvars="\
@@ -164,5 +875,5 @@ func usage(cmd string) string {
 	env ${envvars} nrtm4client list
 
 	env ${envvars} nrtm4client update -source EXAMPLE
-	`, cmd)
+	`, cmd, cmd)
 }
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"testing"
+)
+
+func TestEveryCommandHasSchemaAccess(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "interpreter.go", nil, 0)
+	if err != nil {
+		t.Fatal("Failed to parse interpreter.go", err)
+	}
+	commands := []string{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		sw, ok := n.(*ast.SwitchStmt)
+		if !ok {
+			return true
+		}
+		// runCmd switches on args[1]
+		index, ok := sw.Tag.(*ast.IndexExpr)
+		if !ok {
+			return true
+		}
+		if lit, ok := index.Index.(*ast.BasicLit); !ok || lit.Value != "1" {
+			return true
+		}
+		for _, stmt := range sw.Body.List {
+			for _, expr := range stmt.(*ast.CaseClause).List {
+				if lit, ok := expr.(*ast.BasicLit); ok {
+					name, _ := strconv.Unquote(lit.Value)
+					commands = append(commands, name)
+				}
+			}
+		}
+		return false
+	})
+	if len(commands) == 0 {
+		t.Fatal("Expected to find the commands in interpreter.go")
+	}
+	for _, name := range commands {
+		if _, ok := commandSchemaAccess[name]; !ok {
+			t.Error("Expected", name, "to be in commandSchemaAccess")
+		}
+	}
+	if len(commandSchemaAccess) != len(commands) {
+		t.Error("Expected", len(commands), "commands in commandSchemaAccess but there were", len(commandSchemaAccess))
+	}
+}
@@ -3,18 +3,18 @@ package cli
 import (
 	"log"
 
-	"github.com/petchells/nrtm4client/internal/nrtm4/pg"
+	"github.com/petchells/nrtm4client/client"
 	"github.com/petchells/nrtm4client/internal/nrtm4/service"
 )
 
 // InitializeCommandProcessor starts a db connection pool
 func InitializeCommandProcessor(config service.AppConfig) CommandExecutor {
-	var httpClient service.HTTPClient
-	repo := pg.PostgresRepository{}
-	if err := repo.Initialize(config.PgDatabaseURL); err != nil {
+	httpClient := service.NewHTTPClient(config.Network)
+	repo := client.NewRepository(config)
+	if err := repo.Initialize(config.DatabaseURL()); err != nil {
 		log.Fatal("Failed to initialize repository")
 	}
 	defer repo.Close()
 	processor := service.NewNRTMProcessor(config, repo, httpClient)
-	return NewCommandProcessor(processor)
+	return NewCommandProcessor(processor).WithDefaults(config)
 }
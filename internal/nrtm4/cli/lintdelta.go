@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/service"
+)
+
+// LintDelta is the lint-delta command. It checks one delta file, from a path or a URL, and
+// prints what's wrong with it. It doesn't need the database, so it's run before a connection is
+// made. It returns the same exit codes as validate.
+func LintDelta(args []string, config service.AppConfig) int {
+	fs := flag.NewFlagSet("lint-delta", flag.ContinueOnError)
+	format := fs.String("format", "text", "Report format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		logger.Error("lint-delta needs one file or URL")
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		logger.Error("Unknown format", "format", *format)
+		return 1
+	}
+	processor := service.NewNRTMProcessor(config, nil, service.NewHTTPClient(config.Network))
+	report := processor.LintDelta(fs.Arg(0))
+	if *format == "json" {
+		bytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logger.Error("Failed to format report", "error", err)
+			return 1
+		}
+		fmt.Println(string(bytes))
+		return report.ExitCode
+	}
+	for _, finding := range report.Findings {
+		fmt.Printf("%-7v record %-6d %-20v %v\n", finding.Severity, finding.Record, finding.ID, finding.Message)
+	}
+	fmt.Printf("%v: %d records, %d changes, %d findings\n", report.File, report.Records, report.Changes, len(report.Findings))
+	return report.ExitCode
+}
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"github.com/petchells/nrtm4client/internal/nrtm4/service"
+)
+
+// WithDefaults sets the source and label used when a command which needs a source isn't given
+// one, and the command aliases, from config
+func (ce CommandExecutor) WithDefaults(config service.AppConfig) CommandExecutor {
+	ce.defaultSource = config.DefaultSource
+	ce.defaultLabel = config.DefaultLabel
+	ce.aliases = config.Aliases
+	return ce
+}
+
+// useDefaultSource fills in the default source when src is empty, and its label unless one was
+// given
+func (ce CommandExecutor) useDefaultSource(src, lbl *string) {
+	if len(*src) > 0 || len(ce.defaultSource) == 0 {
+		return
+	}
+	*src = ce.defaultSource
+	if lbl != nil && len(*lbl) == 0 {
+		*lbl = ce.defaultLabel
+	}
+}
+
+// expandAlias replaces an alias in args with the command line it runs. The rest of args are
+// added after it. It returns false if args[1] isn't an alias.
+func (ce CommandExecutor) expandAlias(args []string) ([]string, bool, error) {
+	command, ok := ce.aliases[args[1]]
+	if !ok {
+		return args, false, nil
+	}
+	expanded, err := splitCommandLine(command)
+	if err != nil {
+		return args, false, err
+	}
+	return append(append([]string{args[0]}, expanded...), args[2:]...), true, nil
+}
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/service"
+)
+
+func TestUseDefaultSource(t *testing.T) {
+	ce := CommandExecutor{}.WithDefaults(service.AppConfig{DefaultSource: "RIPE", DefaultLabel: "prod"})
+	src, lbl := "", ""
+	ce.useDefaultSource(&src, &lbl)
+	if src != "RIPE" || lbl != "prod" {
+		t.Error("Expected the default source and label but was", src, lbl)
+	}
+	src, lbl = "", "test"
+	ce.useDefaultSource(&src, &lbl)
+	if src != "RIPE" || lbl != "test" {
+		t.Error("Expected the given label to be kept but was", src, lbl)
+	}
+	src, lbl = "ARIN", ""
+	ce.useDefaultSource(&src, &lbl)
+	if src != "ARIN" || lbl != "" {
+		t.Error("Expected the given source to be kept but was", src, lbl)
+	}
+	src = ""
+	ce.useDefaultSource(&src, nil)
+	if src != "RIPE" {
+		t.Error("Expected the default source without a label but was", src)
+	}
+}
+
+func TestExpandAlias(t *testing.T) {
+	ce := CommandExecutor{}.WithDefaults(service.AppConfig{Aliases: map[string]string{
+		"up":   "update --catch-up deltas",
+		"find": `lookup --source RIPE --label "prod mirror"`,
+	}})
+	expanded, ok, err := ce.expandAlias([]string{"nrtm4client", "up", "--source", "ARIN"})
+	if err != nil || !ok {
+		t.Fatal("Expected alias to be expanded", ok, err)
+	}
+	if !slices.Equal(expanded, []string{"nrtm4client", "update", "--catch-up", "deltas", "--source", "ARIN"}) {
+		t.Error("Unexpected expansion", expanded)
+	}
+	expanded, _, _ = ce.expandAlias([]string{"nrtm4client", "find", "AS64500"})
+	if !slices.Equal(expanded, []string{"nrtm4client", "lookup", "--source", "RIPE", "--label", "prod mirror", "AS64500"}) {
+		t.Error("Unexpected expansion", expanded)
+	}
+	if _, ok, _ = ce.expandAlias([]string{"nrtm4client", "list"}); ok {
+		t.Error("Expected a command which isn't an alias to be left alone")
+	}
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// Version is the version command. It prints how the binary was built, and doesn't need the
+// database, so it's run before a connection is made. It returns an exit code.
+func Version(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	format := fs.String("format", "text", "Output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	info := util.GetBuildInfo()
+	switch *format {
+	case "json":
+		bytes, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			logger.Error("Failed to format version", "error", err)
+			return 1
+		}
+		fmt.Println(string(bytes))
+	case "text":
+		fmt.Printf("Version:       %v\n", info.Version)
+		fmt.Printf("Commit:        %v\n", info.Commit)
+		fmt.Printf("Go version:    %v\n", info.GoVersion)
+		fmt.Printf("Platform:      %v\n", info.Platform)
+		fmt.Printf("Backends:      %v\n", strings.Join(info.Backends, ", "))
+		fmt.Printf("Spec revision: %v\n", info.SpecRevision)
+	default:
+		logger.Error("Unknown format", "format", *format)
+		return 1
+	}
+	return 0
+}
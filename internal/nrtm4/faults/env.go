@@ -0,0 +1,23 @@
+//go:build faults
+
+package faults
+
+import (
+	"log"
+	"os"
+)
+
+// Binaries built with the faults tag read faults from NRTM4_FAULTS, for chaos testing a
+// deployment
+func init() {
+	spec := os.Getenv("NRTM4_FAULTS")
+	if len(spec) == 0 {
+		return
+	}
+	faults, err := Parse(spec)
+	if err != nil {
+		log.Fatalln("Cannot read NRTM4_FAULTS", err)
+	}
+	Enable(faults...)
+	logger.Warn("Fault injection is enabled", "faults", spec)
+}
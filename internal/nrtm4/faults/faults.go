@@ -0,0 +1,210 @@
+// Package faults injects failures at named points in the client, so that the recovery paths,
+// resuming downloads, retrying bad hashes and rolling back transactions, can be tested. No
+// faults are active unless a test enables them, or the binary is built with the faults tag and
+// NRTM4_FAULTS is set.
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+var logger = util.Logger
+
+// Points where faults can be injected
+const (
+	// NotificationRequest is reached before a notification file is requested
+	NotificationRequest = "http.notification"
+	// FileRequest is reached before a snapshot or delta file is requested
+	FileRequest = "http.file"
+	// FileBody is the body of a snapshot or delta file as it's read
+	FileBody = "http.file.body"
+	// TxCommit is reached when a database transaction is about to be committed
+	TxCommit = "db.commit"
+)
+
+// Kinds of fault
+const (
+	// Error makes the operation at the point fail with ErrInjected
+	Error = "error"
+	// Delay makes the operation at the point wait
+	Delay = "delay"
+	// Truncate ends a body early, as if the connection was cut
+	Truncate = "truncate"
+	// Corrupt flips the bits of a body's bytes, so its hash doesn't match
+	Corrupt = "corrupt"
+)
+
+var (
+	// ErrInjected the operation failed because an error fault fired
+	ErrInjected = errors.New("injected fault")
+	// ErrInvalidFault a fault spec can't be parsed
+	ErrInvalidFault = errors.New("invalid fault")
+)
+
+// Fault is a failure injected at a point
+type Fault struct {
+	Kind  string
+	Point string
+	// Probability is the chance the fault fires each time the point is reached. Zero means it
+	// always fires.
+	Probability float64
+	// Times is how many times the fault fires before it's used up. Zero is no limit.
+	Times int
+	// Wait is how long a delay fault waits
+	Wait time.Duration
+	// After is how many bytes of a body are read before it's truncated or corrupted
+	After int64
+	fired int
+}
+
+var (
+	mu      sync.Mutex
+	active  []*Fault
+	enabled atomic.Bool
+)
+
+// Enable replaces the active faults
+func Enable(faults ...Fault) {
+	mu.Lock()
+	defer mu.Unlock()
+	active = make([]*Fault, len(faults))
+	for i := range faults {
+		f := faults[i]
+		active[i] = &f
+	}
+	enabled.Store(len(active) > 0)
+}
+
+// Disable removes all the faults
+func Disable() {
+	Enable()
+}
+
+// Parse reads faults in the form kind@point[:key=value,...], separated by semicolons, e.g.
+// "truncate@http.file.body:after=1000,times=1;error@db.commit:p=0.1". The keys are p, times,
+// wait and after.
+func Parse(spec string) ([]Fault, error) {
+	faults := []Fault{}
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		head, opts, _ := strings.Cut(part, ":")
+		kind, point, found := strings.Cut(head, "@")
+		if !found || len(point) == 0 {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFault, part)
+		}
+		f := Fault{Kind: kind, Point: point}
+		switch kind {
+		case Error, Delay, Truncate, Corrupt:
+		default:
+			return nil, fmt.Errorf("%w: unknown kind %v", ErrInvalidFault, kind)
+		}
+		for _, opt := range strings.Split(opts, ",") {
+			if len(opt) == 0 {
+				continue
+			}
+			key, value, _ := strings.Cut(opt, "=")
+			var err error
+			switch key {
+			case "p":
+				f.Probability, err = strconv.ParseFloat(value, 64)
+			case "times":
+				f.Times, err = strconv.Atoi(value)
+			case "wait":
+				f.Wait, err = time.ParseDuration(value)
+			case "after":
+				f.After, err = strconv.ParseInt(value, 10, 64)
+			default:
+				err = fmt.Errorf("unknown option %v", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v: %v", ErrInvalidFault, part, err)
+			}
+		}
+		faults = append(faults, f)
+	}
+	return faults, nil
+}
+
+// fire returns the first active fault of one of the kinds at point which fires this time
+func fire(point string, kinds ...string) *Fault {
+	if !enabled.Load() {
+		return nil
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, f := range active {
+		if f.Point != point || (f.Times > 0 && f.fired >= f.Times) {
+			continue
+		}
+		match := false
+		for _, kind := range kinds {
+			match = match || f.Kind == kind
+		}
+		if !match || (f.Probability > 0 && rand.Float64() >= f.Probability) {
+			continue
+		}
+		f.fired++
+		logger.Warn("Injecting fault", "kind", f.Kind, "point", point)
+		return f
+	}
+	return nil
+}
+
+// Check is called when a point is reached. It waits if a delay fault fires, and returns
+// ErrInjected if an error fault fires.
+func Check(point string) error {
+	if f := fire(point, Delay); f != nil {
+		util.AppClock.Sleep(f.Wait)
+	}
+	if f := fire(point, Error); f != nil {
+		return fmt.Errorf("%w at %v", ErrInjected, point)
+	}
+	return nil
+}
+
+// Body wraps a body read at point, so a truncate or corrupt fault can spoil it
+func Body(point string, body io.ReadCloser) io.ReadCloser {
+	f := fire(point, Truncate, Corrupt)
+	if f == nil {
+		return body
+	}
+	return &faultyBody{ReadCloser: body, kind: f.Kind, remaining: f.After}
+}
+
+type faultyBody struct {
+	io.ReadCloser
+	kind      string
+	remaining int64
+}
+
+func (b *faultyBody) Read(p []byte) (int, error) {
+	if b.kind == Truncate {
+		if b.remaining <= 0 {
+			return 0, io.EOF
+		}
+		if int64(len(p)) > b.remaining {
+			p = p[:b.remaining]
+		}
+	}
+	n, err := b.ReadCloser.Read(p)
+	for i := range n {
+		if b.kind == Corrupt && b.remaining <= 0 {
+			p[i] ^= 0xff
+		}
+		b.remaining--
+	}
+	return n, err
+}
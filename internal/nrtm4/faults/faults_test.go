@@ -0,0 +1,70 @@
+package faults
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	faults, err := Parse("truncate@http.file.body:after=1000,times=1; error@db.commit:p=0.1;delay@http.notification:wait=2s")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	expected := []Fault{
+		{Kind: Truncate, Point: FileBody, After: 1000, Times: 1},
+		{Kind: Error, Point: TxCommit, Probability: 0.1},
+		{Kind: Delay, Point: NotificationRequest, Wait: 2 * time.Second},
+	}
+	if len(faults) != len(expected) {
+		t.Fatal("Expected", expected, "but was", faults)
+	}
+	for i, f := range expected {
+		if faults[i] != f {
+			t.Error("Expected", f, "but was", faults[i])
+		}
+	}
+	for _, spec := range []string{"error", "explode@db.commit", "error@db.commit:times=often", "delay@http.file:speed=1"} {
+		if _, err = Parse(spec); !errors.Is(err, ErrInvalidFault) {
+			t.Error("Expected ErrInvalidFault for", spec, "but was", err)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	defer Disable()
+	if err := Check(TxCommit); err != nil {
+		t.Error("Expected no error without faults but was", err)
+	}
+	Enable(Fault{Kind: Error, Point: TxCommit, Times: 2}, Fault{Kind: Delay, Point: FileRequest, Wait: 10 * time.Millisecond})
+	for i, expected := range []error{ErrInjected, ErrInjected, nil} {
+		if err := Check(TxCommit); !errors.Is(err, expected) || (expected == nil && err != nil) {
+			t.Error("Expected", expected, "on check", i, "but was", err)
+		}
+	}
+	start := time.Now()
+	if err := Check(FileRequest); err != nil || time.Since(start) < 10*time.Millisecond {
+		t.Error("Expected a delay without an error but was", time.Since(start), err)
+	}
+}
+
+func TestBody(t *testing.T) {
+	defer Disable()
+	body := "Far and few, far and few, are the lands where the Jumblies live"
+	Enable(Fault{Kind: Truncate, Point: FileBody, After: 11, Times: 1})
+	read, err := io.ReadAll(Body(FileBody, io.NopCloser(strings.NewReader(body))))
+	if err != nil || string(read) != body[:11] {
+		t.Error("Expected a truncated body but was", string(read), err)
+	}
+	read, _ = io.ReadAll(Body(FileBody, io.NopCloser(strings.NewReader(body))))
+	if string(read) != body {
+		t.Error("Expected the fault to be used up but was", string(read))
+	}
+	Enable(Fault{Kind: Corrupt, Point: FileBody, After: 4})
+	read, _ = io.ReadAll(Body(FileBody, io.NopCloser(strings.NewReader(body))))
+	if len(read) != len(body) || string(read[:4]) != body[:4] || read[4] == body[4] {
+		t.Error("Expected the body to be corrupted after 4 bytes but was", string(read))
+	}
+}
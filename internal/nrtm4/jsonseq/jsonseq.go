@@ -3,3 +3,7 @@
 // A jsonseq record is simply the bytes between the record markers -- it's up to
 // you to unmarshall them to the JSON types you expect.
 package jsonseq
+
+import "github.com/petchells/nrtm4client/internal/nrtm4/util"
+
+var logger = util.ModuleLogger("jsonseq")
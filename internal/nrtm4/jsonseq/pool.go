@@ -0,0 +1,37 @@
+package jsonseq
+
+import "sync"
+
+// maxPooledBuffer is the largest buffer kept for reuse. The odd huge record shouldn't pin its
+// memory for the rest of the import.
+const maxPooledBuffer = 1 << 20
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 4096)
+		return &b
+	},
+}
+
+func getBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+// Retain copies a record into a pooled buffer, so it can be used after the RecordReaderFunc it
+// was passed to has returned. The caller owns the copy until it hands it back with Release, and
+// mustn't use it after that.
+func Retain(record []byte) *[]byte {
+	buf := getBuffer()
+	*buf = append((*buf)[:0], record...)
+	return buf
+}
+
+// Release returns a buffer from Retain to the pool
+func Release(buf *[]byte) {
+	if cap(*buf) > maxPooledBuffer {
+		logger.Debug("Not pooling oversized record buffer", "capacity", cap(*buf))
+		return
+	}
+	*buf = (*buf)[:0]
+	bufferPool.Put(buf)
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"strings"
 )
@@ -20,6 +21,25 @@ var ErrNotJSONSeq = errors.New("not a JSON seq file")
 // ErrExtraneousBytes returned when non-JSON chars are found in the payload
 var ErrExtraneousBytes = errors.New("bytes found before record marker")
 
+// ErrRecordTooLarge a record is longer than the limit passed to ReadRecordsLimit
+var ErrRecordTooLarge = errors.New("record is too large")
+
+// RecordTooLargeError is passed to a RecordReaderFunc by ReadRecordsLimit, with the start of a
+// record which is longer than the limit
+type RecordTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *RecordTooLargeError) Error() string {
+	return fmt.Sprintf("%v: %d bytes, limit is %d", ErrRecordTooLarge, e.Size, e.Limit)
+}
+
+// Is makes errors.Is(err, ErrRecordTooLarge) true
+func (e *RecordTooLargeError) Is(target error) bool {
+	return target == ErrRecordTooLarge
+}
+
 // RecordReaderFunc defines the callback function for jsonseq reads
 type RecordReaderFunc func([]byte, error) error
 
@@ -40,9 +60,21 @@ func ReadStringRecords(jsonSeq string, fn RecordReaderFunc) error {
 // 	return ReadRecords(reader, fn)
 // }
 
-// ReadRecords reads a jsonseq file and calls fn for each record
+// ReadRecords reads a jsonseq file and calls fn for each record. The bytes passed to fn belong
+// to the reader and are overwritten by the next record, so they're only valid until fn returns.
+// A callback which needs a record after it returns, e.g. to parse it in another goroutine, must
+// copy it first, which Retain does without allocating.
 func ReadRecords(reader *bufio.Reader, fn RecordReaderFunc) error {
-	jsonBytes, err := reader.ReadBytes(RS)
+	return ReadRecordsLimit(reader, 0, fn)
+}
+
+// ReadRecordsLimit is ReadRecords, except that a record longer than limit bytes isn't read into
+// memory. fn is given the first limit bytes of it and a *RecordTooLargeError instead, and if it
+// was the last record, fn is then called with no bytes and io.EOF. A limit of 0 means no limit.
+func ReadRecordsLimit(reader *bufio.Reader, limit int, fn RecordReaderFunc) error {
+	buf := getBuffer()
+	defer Release(buf)
+	jsonBytes, _, err := readRecord(reader, buf, 0)
 	if err != nil {
 		return ErrNotJSONSeq
 	}
@@ -51,7 +83,19 @@ func ReadRecords(reader *bufio.Reader, fn RecordReaderFunc) error {
 		return ErrExtraneousBytes
 	}
 	for {
-		jsonBytes, err := reader.ReadBytes(RS)
+		jsonBytes, size, err := readRecord(reader, buf, limit)
+		if limit > 0 && size > limit && (err == nil || err == io.EOF) {
+			if ferr := fn(bytes.TrimSpace(jsonBytes[:min(limit, len(jsonBytes))]), &RecordTooLargeError{Size: size, Limit: limit}); ferr != nil {
+				return ferr
+			}
+			if err == nil {
+				continue
+			}
+			if ferr := fn(nil, io.EOF); ferr != nil {
+				return ferr
+			}
+			return io.EOF
+		}
 		if err == nil {
 			err = trimBytes(jsonBytes[:len(jsonBytes)-1], fn)
 			if err != nil {
@@ -69,6 +113,33 @@ func ReadRecords(reader *bufio.Reader, fn RecordReaderFunc) error {
 	}
 }
 
+// readRecord reads up to and including the next RS, and returns the record's size without the
+// RS. When the record fits in the reader's buffer the result is a slice of it, otherwise the
+// record is gathered in buf, which stops growing once it's over limit, if limit isn't 0.
+func readRecord(reader *bufio.Reader, buf *[]byte, limit int) ([]byte, int, error) {
+	line, err := reader.ReadSlice(RS)
+	size := len(line)
+	if err != bufio.ErrBufferFull {
+		if err == nil {
+			size--
+		}
+		return line, size, err
+	}
+	b := append((*buf)[:0], line...)
+	for err == bufio.ErrBufferFull {
+		line, err = reader.ReadSlice(RS)
+		size += len(line)
+		if limit == 0 || len(b) <= limit {
+			b = append(b, line...)
+		}
+	}
+	if err == nil {
+		size--
+	}
+	*buf = b
+	return b, size, err
+}
+
 func trimBytes(b []byte, fn RecordReaderFunc) error {
 	res := bytes.TrimSpace(b)
 	if len(res) > 0 {
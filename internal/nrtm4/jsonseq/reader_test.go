@@ -1,11 +1,16 @@
 package jsonseq
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 	"testing"
 
-	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
 )
 
 func TestJSONSequenceParser(t *testing.T) {
@@ -16,7 +21,7 @@ func TestJSONSequenceParser(t *testing.T) {
 	i := 0
 	err := ReadStringRecords(snapshotExample, func(possJsonBytes []byte, err error) error {
 		if i == 0 {
-			snapshot := new(persist.SnapshotFileJSON)
+			snapshot := new(protocol.SnapshotFileJSON)
 			err = json.Unmarshal(possJsonBytes, snapshot)
 			if err != nil {
 				t.Fatal(err)
@@ -25,7 +30,7 @@ func TestJSONSequenceParser(t *testing.T) {
 				t.Fatal("Expected", sessionID, "but was", snapshot.SessionID)
 			}
 		} else if i == 1 {
-			object := new(persist.SnapshotObjectJSON)
+			object := new(protocol.SnapshotObjectJSON)
 			err = json.Unmarshal(possJsonBytes, object)
 			if err != nil {
 				t.Fatal(err)
@@ -74,13 +79,13 @@ func TestJSONSequenceParserErrors(t *testing.T) {
 func unmarshalFunc(possJSONBytes []byte, err error) error {
 	i := 0
 	if i == 0 {
-		snapshot := new(persist.SnapshotFileJSON)
+		snapshot := new(protocol.SnapshotFileJSON)
 		err = json.Unmarshal(possJSONBytes, snapshot)
 		if err != nil {
 			return err
 		}
 	} else if i > 0 {
-		object := new(persist.SnapshotObjectJSON)
+		object := new(protocol.SnapshotObjectJSON)
 		err = json.Unmarshal(possJSONBytes, object)
 		if err != nil {
 			return err
@@ -101,3 +106,115 @@ var snapshotExample = `
 {"object": "route: 192.0.2.0/24\norigin: AS65530\nsource: EXAMPLE"}
 {"object": "route: 2001:db8::/32\norigin: AS65530\nsource: EXAMPLE"}
 `
+
+func TestReadRecordsLargerThanBuffer(t *testing.T) {
+	big := `{"object": "` + strings.Repeat("x", 10000) + `"}`
+	seq := "\x1e{}\n\x1e" + big + "\n\x1e{}\n"
+	reader := bufio.NewReaderSize(strings.NewReader(seq), 16)
+	var records []string
+	err := ReadRecords(reader, func(b []byte, err error) error {
+		records = append(records, string(b))
+		return nil
+	})
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0] != "{}" || records[1] != big || records[2] != "{}" {
+		t.Fatal("Unexpected records", len(records))
+	}
+}
+
+func TestReadRecordsLimit(t *testing.T) {
+	big := `{"object": "` + strings.Repeat("x", 10000) + `"}`
+	for _, seq := range []string{
+		"\x1e{}\n\x1e" + big + "\n\x1e{}\n",
+		"\x1e{}\n\x1e{}\n\x1e" + big + "\n",
+	} {
+		reader := bufio.NewReaderSize(strings.NewReader(seq), 16)
+		var records []string
+		tooLarge := 0
+		err := ReadRecordsLimit(reader, 100, func(b []byte, err error) error {
+			var e *RecordTooLargeError
+			if errors.As(err, &e) {
+				tooLarge++
+				if len(b) != 100 || e.Size < len(big) || !errors.Is(err, ErrRecordTooLarge) {
+					t.Error("Unexpected oversized record", len(b), e)
+				}
+				return nil
+			}
+			if len(b) > 0 {
+				records = append(records, string(b))
+			}
+			return nil
+		})
+		if err != io.EOF {
+			t.Fatal(err)
+		}
+		if tooLarge != 1 || len(records) != 2 || records[0] != "{}" || records[1] != "{}" {
+			t.Error("Unexpected records", tooLarge, records)
+		}
+	}
+}
+
+func TestRetain(t *testing.T) {
+	var retained []*[]byte
+	err := ReadStringRecords("\x1e{\"a\":1}\n\x1e{\"b\":2}\n", func(b []byte, err error) error {
+		retained = append(retained, Retain(b))
+		return nil
+	})
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+	if len(retained) != 2 || string(*retained[0]) != `{"a":1}` || string(*retained[1]) != `{"b":2}` {
+		t.Fatal("Unexpected retained records", retained)
+	}
+	for _, buf := range retained {
+		Release(buf)
+	}
+}
+
+func TestReadRecordsAllocations(t *testing.T) {
+	seq := []byte(manyRecords(1000))
+	src := bytes.NewReader(seq)
+	reader := bufio.NewReaderSize(src, 64*1024)
+	count := 0
+	allocs := testing.AllocsPerRun(10, func() {
+		src.Reset(seq)
+		reader.Reset(src)
+		ReadRecords(reader, func(b []byte, err error) error {
+			count++
+			return nil
+		})
+	})
+	if count == 0 {
+		t.Fatal("Expected records to be read")
+	}
+	// Allocations mustn't grow with the number of records
+	if allocs > 10 {
+		t.Error("Expected a constant number of allocations but was", allocs, "for 1000 records")
+	}
+}
+
+func BenchmarkReadRecords(b *testing.B) {
+	seq := []byte(manyRecords(10000))
+	src := bytes.NewReader(seq)
+	reader := bufio.NewReaderSize(src, 64*1024)
+	b.SetBytes(int64(len(seq)))
+	b.ReportAllocs()
+	for range b.N {
+		src.Reset(seq)
+		reader.Reset(src)
+		ReadRecords(reader, func(b []byte, err error) error {
+			return nil
+		})
+	}
+}
+
+func manyRecords(n int) string {
+	var sb strings.Builder
+	sb.WriteString("\x1e{\"nrtm_version\": 4, \"type\": \"snapshot\"}\n")
+	for i := range n {
+		fmt.Fprintf(&sb, "\x1e{\"object\": \"route: 192.0.%d.0/24\\norigin: AS65530\\nsource: EXAMPLE\"}\n", i%256)
+	}
+	return sb.String()
+}
@@ -0,0 +1,167 @@
+package mem
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// indexBuild is how far the build of an optional index has got. There's nothing to look up in
+// memory, so only which objects have been given to it is kept.
+type indexBuild struct {
+	started  time.Time
+	caughtUp time.Time
+	objects  int64
+	indexed  map[uint64]bool
+}
+
+// unindex forgets an object version which has been removed, so the index builds don't count it
+func (repo *MemoryRepository) unindex(id uint64) {
+	for _, build := range repo.indexBuilds {
+		delete(build.indexed, id)
+	}
+}
+
+// GetSchemaInfo describes the kinds of rows the repository holds, named after the tables the
+// pg repository keeps them in
+func (repo *MemoryRepository) GetSchemaInfo() (persist.SchemaInfo, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	var notifications, runs, objects, objectBytes int64
+	for _, n := range repo.notifications {
+		notifications += int64(len(n))
+	}
+	for _, r := range repo.syncRuns {
+		runs += int64(len(r))
+	}
+	for id := range repo.objects {
+		for _, r := range repo.sourceRows(id) {
+			objects++
+			objectBytes += int64(len(r.rpsl))
+		}
+	}
+	return persist.SchemaInfo{
+		Backend: "memory",
+		Tables: []persist.TableInfo{
+			{Name: "nrtm_file", EstimatedRows: int64(len(repo.files))},
+			{Name: "nrtm_notification", EstimatedRows: notifications},
+			{Name: "nrtm_rpslobject", EstimatedRows: objects, TotalBytes: objectBytes},
+			{Name: "nrtm_source", EstimatedRows: int64(len(repo.sources))},
+			{Name: "nrtm_sync_run", EstimatedRows: runs},
+		},
+	}, nil
+}
+
+// BuildIndexBatch gives up to batchSize objects which haven't been indexed yet to values, and
+// returns how many there were. The values aren't kept, since nothing searches them in memory.
+func (repo *MemoryRepository) BuildIndexBatch(name string, batchSize int, values func(rpsl.Rpsl) []string) (int, error) {
+	if !slices.Contains([]string{persist.FullTextIndex, persist.PrefixIndex, persist.AttributeIndex}, name) {
+		return 0, fmt.Errorf("%w: %v", persist.ErrUnknownIndex, name)
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	now := util.AppClock.Now()
+	build, ok := repo.indexBuilds[name]
+	if !ok {
+		build = &indexBuild{started: now, indexed: map[uint64]bool{}}
+		repo.indexBuilds[name] = build
+	}
+	rows := []*objectRow{}
+	for id := range repo.objects {
+		rows = append(rows, repo.sourceRows(id)...)
+	}
+	slices.SortFunc(rows, func(a, b *objectRow) int { return cmp.Compare(a.id, b.id) })
+	indexed := 0
+	for _, r := range rows {
+		if indexed == batchSize {
+			break
+		}
+		if build.indexed[r.id] {
+			continue
+		}
+		values(rpsl.Rpsl{ObjectType: r.objectType, PrimaryKey: r.primaryKey, Payload: r.rpsl})
+		build.indexed[r.id] = true
+		indexed++
+	}
+	build.objects += int64(indexed)
+	if indexed < batchSize && build.caughtUp.IsZero() {
+		build.caughtUp = now
+	}
+	return indexed, nil
+}
+
+// GetIndexProgress returns the progress of each optional index which has been started
+func (repo *MemoryRepository) GetIndexProgress() ([]persist.IndexProgress, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	var total int64
+	for _, objects := range repo.objects {
+		for _, rows := range objects {
+			total += int64(len(rows))
+		}
+	}
+	progress := []persist.IndexProgress{}
+	for name, build := range repo.indexBuilds {
+		progress = append(progress, persist.IndexProgress{
+			Name:         name,
+			Objects:      build.objects,
+			TotalObjects: total,
+			Started:      build.started,
+			CaughtUp:     build.caughtUp,
+		})
+	}
+	slices.SortFunc(progress, func(a, b persist.IndexProgress) int { return strings.Compare(a.Name, b.Name) })
+	return progress, nil
+}
+
+// IsIdle is always true, since nothing else can use the repository
+func (repo *MemoryRepository) IsIdle() (bool, error) {
+	return true, nil
+}
+
+// PartitionObjects isn't supported, objects are already kept by source and key
+func (repo *MemoryRepository) PartitionObjects(partitions int) error {
+	return fmt.Errorf("%w: partitioning objects", ErrNotSupported)
+}
+
+// AnalyzeObjects does nothing, there are no planner statistics to refresh
+func (repo *MemoryRepository) AnalyzeObjects() error {
+	return nil
+}
+
+// RunScript isn't supported, there's no database to run SQL against
+func (repo *MemoryRepository) RunScript(script string) error {
+	return fmt.Errorf("%w: running an SQL script", ErrNotSupported)
+}
+
+// GetSchemaVersion returns the last client version recorded. There's no schema to migrate, so
+// its version always matches the client's.
+func (repo *MemoryRepository) GetSchemaVersion() (persist.SchemaVersion, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return persist.SchemaVersion{LastClientVersion: repo.clientVersion}, nil
+}
+
+// RecordClientVersion saves the client version
+func (repo *MemoryRepository) RecordClientVersion(clientVersion string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.clientVersion = clientVersion
+	return nil
+}
+
+// IsStandby is always false, the repository can't be a replica
+func (repo *MemoryRepository) IsStandby() (bool, error) {
+	return false, nil
+}
+
+// Promote isn't supported, since the repository is never a standby
+func (repo *MemoryRepository) Promote() error {
+	return fmt.Errorf("%w: promoting a standby", ErrNotSupported)
+}
@@ -0,0 +1,27 @@
+/*
+Package mem implements the Repository interface in memory, with the same history of object
+versions as the pg package. It needs no database, so it's quick to set up for tests of the
+service layer and for throwaway analyses of small sources, but everything is lost when the
+process exits.
+*/
+package mem
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+var logger = util.ModuleLogger("mem")
+
+// URLScheme is the prefix of a database URL which selects the memory repository
+const URLScheme = "mem://"
+
+// ErrNotSupported the operation needs a database, such as running SQL
+var ErrNotSupported = errors.New("not supported by the memory repository")
+
+// IsMemoryURL is true when a database URL selects the memory repository
+func IsMemoryURL(url string) bool {
+	return strings.HasPrefix(url, URLScheme)
+}
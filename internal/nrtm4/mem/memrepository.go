@@ -0,0 +1,573 @@
+package mem
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// maxNotificationHistory is how many notifications GetNotificationHistory returns
+const maxNotificationHistory = 100
+
+// errNoSource the source isn't in the repo
+var errNoSource = errors.New("no such source")
+
+// MemoryRepository implementation of the Repository interface. Every call holds a lock for as
+// long as it takes, so each one is atomic like a transaction in the pg repository, and callers
+// see either all of a call's changes or none of them.
+type MemoryRepository struct {
+	mu            sync.RWMutex
+	lastID        uint64
+	sources       map[uint64]*sourceRow
+	notifications map[uint64][]persist.Notification
+	files         []persist.NRTMFile
+	pendingDeltas map[uint64][]protocol.FileRefJSON
+	snapshotRefs  map[uint64][]persist.SnapshotRef
+	oversized     map[uint64][]persist.OversizedObject
+	syncRuns      map[uint64][]syncRunRow
+	sessions      map[sessionKey][]persist.SessionSeen
+	objects       map[uint64]map[objectKey][]*objectRow
+	indexBuilds   map[string]*indexBuild
+	clientVersion string
+}
+
+// sourceRow is a source with the version readers see, and the earliest version they can read
+type sourceRow struct {
+	persist.NRTMSource
+	applied  uint32
+	baseline uint32
+}
+
+// syncRunRow is a sync run and the source it was for
+type syncRunRow struct {
+	sourceID uint64
+	persist.SyncRun
+}
+
+// sessionKey is the source name and notification URL sessions are seen at
+type sessionKey struct {
+	source string
+	url    string
+}
+
+// NewRepository returns an empty memory repository
+func NewRepository() *MemoryRepository {
+	return &MemoryRepository{
+		sources:       map[uint64]*sourceRow{},
+		notifications: map[uint64][]persist.Notification{},
+		pendingDeltas: map[uint64][]protocol.FileRefJSON{},
+		snapshotRefs:  map[uint64][]persist.SnapshotRef{},
+		oversized:     map[uint64][]persist.OversizedObject{},
+		syncRuns:      map[uint64][]syncRunRow{},
+		sessions:      map[sessionKey][]persist.SessionSeen{},
+		objects:       map[uint64]map[objectKey][]*objectRow{},
+		indexBuilds:   map[string]*indexBuild{},
+	}
+}
+
+// Initialize implementation of the Repository interface. There's nothing to connect to, so it
+// only checks the URL is a memory one.
+func (repo *MemoryRepository) Initialize(dbURL string) error {
+	if !IsMemoryURL(dbURL) {
+		return fmt.Errorf("%w: %v is not a %v URL", ErrNotSupported, dbURL, URLScheme)
+	}
+	logger.Info("Using an in-memory repository, nothing is kept when the process exits")
+	return nil
+}
+
+// Ping implementation of the Repository interface
+func (repo *MemoryRepository) Ping() error {
+	return nil
+}
+
+// WithStatementTimeout returns the repository itself, since its reads don't run statements
+// which could be cancelled
+func (repo *MemoryRepository) WithStatementTimeout(time.Duration) persist.Repository {
+	return repo
+}
+
+// Close implementation of the Repository interface
+func (repo *MemoryRepository) Close() error {
+	return nil
+}
+
+// nextID returns a new id for a row of any kind
+func (repo *MemoryRepository) nextID() uint64 {
+	repo.lastID++
+	return repo.lastID
+}
+
+// GetSources returns a list of all sources, in the order they were created
+func (repo *MemoryRepository) GetSources() ([]persist.NRTMSource, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	var sources []persist.NRTMSource
+	for _, row := range repo.sources {
+		sources = append(sources, row.NRTMSource)
+	}
+	slices.SortFunc(sources, func(a, b persist.NRTMSource) int { return cmp.Compare(a.ID, b.ID) })
+	return sources, nil
+}
+
+// SaveSource updates a source if ID is non-zero, or creates a new one if it is. An update also
+// saves the notification, unless it's the same as the last one.
+func (repo *MemoryRepository) SaveSource(source persist.NRTMSource, notification protocol.NotificationJSON) (persist.NRTMSource, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if source.ID == 0 {
+		row := &sourceRow{NRTMSource: persist.NRTMSource{
+			ID:              repo.nextID(),
+			Source:          source.Source,
+			SessionID:       source.SessionID,
+			Version:         source.Version,
+			NotificationURL: source.NotificationURL,
+			Label:           source.Label,
+			Created:         util.AppClock.Now(),
+			TermsURL:        source.TermsURL,
+			SampleRate:      source.SampleRate,
+		}, baseline: source.Version}
+		repo.sources[row.ID] = row
+		return row.NRTMSource, nil
+	}
+	row, ok := repo.sources[source.ID]
+	if !ok {
+		return source, errNoSource
+	}
+	if err := repo.saveNotification(source.ID, notification); err != nil {
+		return source, err
+	}
+	row.NRTMSource = source
+	if q := source.Quarantine; q != nil && q.Failures == 0 {
+		row.Quarantine = nil
+	}
+	if fp := source.LastNotification; fp != nil && len(fp.Hash) == 0 {
+		row.LastNotification = nil
+	}
+	return row.NRTMSource, nil
+}
+
+// saveNotification adds a notification to a source's history if it's a later version than the
+// last one, or the same version with a different snapshot
+func (repo *MemoryRepository) saveNotification(sourceID uint64, payload protocol.NotificationJSON) error {
+	notifications := repo.notifications[sourceID]
+	if len(notifications) > 0 {
+		last := notifications[len(notifications)-1]
+		if payload.Version == last.Version && payload.SnapshotRef.Version == last.Payload.SnapshotRef.Version {
+			// Nothing to do
+			return nil
+		}
+		if payload.Version < last.Version {
+			logger.Error("Expected higher notification version", "lastN.Version", last.Version, "payload.Version", payload.Version)
+			return errors.New("expected higher notification version than the one found")
+		}
+	}
+	repo.notifications[sourceID] = append(notifications, persist.Notification{
+		ID:           repo.nextID(),
+		Version:      payload.Version,
+		NRTMSourceID: sourceID,
+		Payload:      payload,
+		Created:      util.AppClock.Now(),
+	})
+	return nil
+}
+
+// RemoveSource removes a source from the repo, and returns the number of rows deleted from each
+// kind of row. The sessions seen at its notification URL go too, unless another source shares it.
+func (repo *MemoryRepository) RemoveSource(source persist.NRTMSource) (persist.RemovedRows, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	var removed persist.RemovedRows
+	row, ok := repo.sources[source.ID]
+	if !ok {
+		return removed, nil
+	}
+	for _, rows := range repo.objects[source.ID] {
+		removed.Objects += int64(len(rows))
+		for _, r := range rows {
+			repo.unindex(r.id)
+		}
+	}
+	removed.Notifications = int64(len(repo.notifications[source.ID]))
+	repo.files = slices.DeleteFunc(repo.files, func(f persist.NRTMFile) bool {
+		if f.NrtmSourceID == source.ID {
+			removed.Files++
+			return true
+		}
+		return false
+	})
+	delete(repo.objects, source.ID)
+	delete(repo.notifications, source.ID)
+	delete(repo.pendingDeltas, source.ID)
+	delete(repo.snapshotRefs, source.ID)
+	delete(repo.oversized, source.ID)
+	delete(repo.syncRuns, source.ID)
+	delete(repo.sources, source.ID)
+	shared := false
+	for _, other := range repo.sources {
+		shared = shared || (other.Source == row.Source && other.NotificationURL == row.NotificationURL)
+	}
+	if !shared {
+		delete(repo.sessions, sessionKey{row.Source, row.NotificationURL})
+	}
+	return removed, nil
+}
+
+// updateSource calls fn with a source's row, if it's in the repo
+func (repo *MemoryRepository) updateSource(source persist.NRTMSource, fn func(*sourceRow)) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if row, ok := repo.sources[source.ID]; ok {
+		fn(row)
+	}
+	return nil
+}
+
+// PauseSource sets whether a source is paused
+func (repo *MemoryRepository) PauseSource(source persist.NRTMSource, paused bool) error {
+	return repo.updateSource(source, func(row *sourceRow) { row.Paused = paused })
+}
+
+// SupersedeSource records when a source's session was replaced by a new one
+func (repo *MemoryRepository) SupersedeSource(source persist.NRTMSource, at time.Time) error {
+	return repo.updateSource(source, func(row *sourceRow) { row.Superseded = &at })
+}
+
+// SaveQuarantine quarantines a source, or releases it when q is nil
+func (repo *MemoryRepository) SaveQuarantine(source persist.NRTMSource, q *persist.Quarantine) error {
+	return repo.updateSource(source, func(row *sourceRow) {
+		row.Quarantine = nil
+		if q != nil && q.Failures > 0 {
+			quarantine := *q
+			row.Quarantine = &quarantine
+		}
+	})
+}
+
+// SaveNotificationFingerprint records the last notification file which was completely processed
+func (repo *MemoryRepository) SaveNotificationFingerprint(source persist.NRTMSource, fp persist.NotificationFingerprint) error {
+	return repo.updateSource(source, func(row *sourceRow) {
+		row.LastNotification = nil
+		if len(fp.Hash) > 0 {
+			row.LastNotification = &fp
+		}
+	})
+}
+
+// SaveTermsURL records the source's license or terms of use
+func (repo *MemoryRepository) SaveTermsURL(source persist.NRTMSource, termsURL string) error {
+	return repo.updateSource(source, func(row *sourceRow) {
+		row.TermsURL = termsURL
+	})
+}
+
+// SaveLabel renames a source's label
+func (repo *MemoryRepository) SaveLabel(source persist.NRTMSource, label string) error {
+	return repo.updateSource(source, func(row *sourceRow) { row.Label = label })
+}
+
+// GetNotificationHistory gets the last 100 notification versions
+func (repo *MemoryRepository) GetNotificationHistory(source persist.NRTMSource, fromVersion, toVersion uint32) ([]persist.Notification, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	notifs := []persist.Notification{}
+	all := repo.notifications[source.ID]
+	for i := len(all) - 1; i >= 0 && len(notifs) < maxNotificationHistory; i-- {
+		if all[i].Version >= fromVersion && all[i].Version <= toVersion {
+			notifs = append(notifs, all[i])
+		}
+	}
+	return notifs, nil
+}
+
+// SaveFile saves a reference to an NRTM file
+func (repo *MemoryRepository) SaveFile(nrtmFile *persist.NRTMFile) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	nrtmFile.ID = repo.nextID()
+	file := *nrtmFile
+	file.ApplyTime = file.ApplyTime.Truncate(time.Millisecond)
+	file.Created = util.AppClock.Now()
+	repo.files = append(repo.files, file)
+	return nil
+}
+
+// GetFileByHash finds the most recent file saved for a source with the given hash. It returns
+// nil if there isn't one.
+func (repo *MemoryRepository) GetFileByHash(source persist.NRTMSource, hash string) (*persist.NRTMFile, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	for i := len(repo.files) - 1; i >= 0; i-- {
+		if file := repo.files[i]; file.NrtmSourceID == source.ID && file.Hash == hash {
+			return &file, nil
+		}
+	}
+	return nil, nil
+}
+
+// latestFile is the file most recently saved for a source at a version which passes keep, or
+// nil if there isn't one
+func (repo *MemoryRepository) latestFile(sourceID uint64, version uint32, keep func(persist.NRTMFile) bool) *persist.NRTMFile {
+	for i := len(repo.files) - 1; i >= 0; i-- {
+		if file := repo.files[i]; file.NrtmSourceID == sourceID && file.Version == version && keep(file) {
+			return &file
+		}
+	}
+	return nil
+}
+
+// SavePendingDeltas replaces a source's queue of deltas waiting to be applied. An empty list
+// clears it.
+func (repo *MemoryRepository) SavePendingDeltas(source persist.NRTMSource, refs []protocol.FileRefJSON) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.pendingDeltas[source.ID] = slices.Clone(refs)
+	return nil
+}
+
+// GetPendingDeltas lists the deltas queued for a source which are after its version, lowest
+// version first
+func (repo *MemoryRepository) GetPendingDeltas(source persist.NRTMSource) ([]protocol.FileRefJSON, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	refs := []protocol.FileRefJSON{}
+	for _, ref := range repo.pendingDeltas[source.ID] {
+		if ref.Version > source.Version {
+			refs = append(refs, ref)
+		}
+	}
+	slices.SortStableFunc(refs, func(a, b protocol.FileRefJSON) int { return cmp.Compare(a.Version, b.Version) })
+	return refs, nil
+}
+
+// SaveSnapshotRefs records the snapshots a notification advertised. A snapshot which was seen
+// before keeps its first seen time and hash, and its url is updated in case the server moved it.
+func (repo *MemoryRepository) SaveSnapshotRefs(source persist.NRTMSource, refs []persist.SnapshotRef) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	saved := repo.snapshotRefs[source.ID]
+	for _, ref := range refs {
+		i := slices.IndexFunc(saved, func(s persist.SnapshotRef) bool {
+			return s.SessionID == ref.SessionID && s.Version == ref.Version
+		})
+		if i < 0 {
+			saved = append(saved, ref)
+			continue
+		}
+		saved[i].URL = ref.URL
+		saved[i].LastSeen = ref.LastSeen
+	}
+	repo.snapshotRefs[source.ID] = saved
+	return nil
+}
+
+// GetSnapshotRefs lists the snapshots recorded for a source, the most recently advertised first
+func (repo *MemoryRepository) GetSnapshotRefs(source persist.NRTMSource) ([]persist.SnapshotRef, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	refs := slices.Clone(repo.snapshotRefs[source.ID])
+	if refs == nil {
+		refs = []persist.SnapshotRef{}
+	}
+	slices.SortFunc(refs, func(a, b persist.SnapshotRef) int {
+		if c := b.LastSeen.Compare(a.LastSeen); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Version, a.Version)
+	})
+	return refs, nil
+}
+
+// SaveOversizedObject quarantines a record which was too large to apply. Quarantining the same
+// record again replaces it.
+func (repo *MemoryRepository) SaveOversizedObject(source persist.NRTMSource, obj persist.OversizedObject) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	saved := slices.DeleteFunc(repo.oversized[source.ID], func(o persist.OversizedObject) bool {
+		return o.Version == obj.Version && o.ObjectType == obj.ObjectType && o.PrimaryKey == obj.PrimaryKey
+	})
+	repo.oversized[source.ID] = append(saved, obj)
+	return nil
+}
+
+// GetOversizedObjects lists the records quarantined for a source, the most recent first
+func (repo *MemoryRepository) GetOversizedObjects(source persist.NRTMSource) ([]persist.OversizedObject, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	objs := slices.Clone(repo.oversized[source.ID])
+	if objs == nil {
+		objs = []persist.OversizedObject{}
+	}
+	slices.SortFunc(objs, func(a, b persist.OversizedObject) int {
+		if c := cmp.Compare(b.Version, a.Version); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.ObjectType, b.ObjectType); c != 0 {
+			return c
+		}
+		return strings.Compare(a.PrimaryKey, b.PrimaryKey)
+	})
+	return objs, nil
+}
+
+// SaveSyncRun records the outcome of an update. Durations are kept to the millisecond, as they
+// are in the database.
+func (repo *MemoryRepository) SaveSyncRun(source persist.NRTMSource, run persist.SyncRun) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	run.Started = run.Started.UTC()
+	run.Duration = run.Duration.Truncate(time.Millisecond)
+	run.Lag = run.Lag.Truncate(time.Millisecond)
+	repo.syncRuns[source.ID] = append(repo.syncRuns[source.ID], syncRunRow{source.ID, run})
+	return nil
+}
+
+// GetSyncRuns returns the updates of a source which started in [from, to), oldest first
+func (repo *MemoryRepository) GetSyncRuns(source persist.NRTMSource, from, to time.Time) ([]persist.SyncRun, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	runs := []persist.SyncRun{}
+	for _, row := range repo.syncRuns[source.ID] {
+		if !row.Started.Before(from) && row.Started.Before(to) {
+			runs = append(runs, row.SyncRun)
+		}
+	}
+	slices.SortStableFunc(runs, func(a, b persist.SyncRun) int { return a.Started.Compare(b.Started) })
+	return runs, nil
+}
+
+// PruneSyncRuns removes the records of updates which started before a point in time
+func (repo *MemoryRepository) PruneSyncRuns(before time.Time) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	var removed int64
+	for id, rows := range repo.syncRuns {
+		repo.syncRuns[id] = slices.DeleteFunc(rows, func(row syncRunRow) bool {
+			if row.Started.Before(before) {
+				removed++
+				return true
+			}
+			return false
+		})
+	}
+	return removed, nil
+}
+
+// SaveSessionSeen records that a session was seen at the source's notification URL. A session
+// which was seen before keeps its first seen time and version, and stays announced once it has
+// been.
+func (repo *MemoryRepository) SaveSessionSeen(source persist.NRTMSource, seen persist.SessionSeen) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	key := sessionKey{source.Source, source.NotificationURL}
+	sessions := repo.sessions[key]
+	i := slices.IndexFunc(sessions, func(s persist.SessionSeen) bool { return s.SessionID == seen.SessionID })
+	if i < 0 {
+		repo.sessions[key] = append(sessions, seen)
+		return nil
+	}
+	s := &sessions[i]
+	if seen.LastSeen.After(s.LastSeen) {
+		s.LastSeen = seen.LastSeen
+	}
+	s.FirstVersion = min(s.FirstVersion, seen.FirstVersion)
+	s.LastVersion = max(s.LastVersion, seen.LastVersion)
+	s.Announced = s.Announced || seen.Announced
+	return nil
+}
+
+// GetSessionHistory lists the sessions seen at the source's notification URL, oldest first
+func (repo *MemoryRepository) GetSessionHistory(source persist.NRTMSource) ([]persist.SessionSeen, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	sessions := slices.Clone(repo.sessions[sessionKey{source.Source, source.NotificationURL}])
+	if sessions == nil {
+		sessions = []persist.SessionSeen{}
+	}
+	slices.SortFunc(sessions, func(a, b persist.SessionSeen) int {
+		if c := a.FirstSeen.Compare(b.FirstSeen); c != 0 {
+			return c
+		}
+		return strings.Compare(a.SessionID, b.SessionID)
+	})
+	return sessions, nil
+}
+
+// SetAppliedVersion makes a version visible to readers, once every change in it has been saved,
+// and moves the source's version on with it
+func (repo *MemoryRepository) SetAppliedVersion(source persist.NRTMSource, version uint32) error {
+	return repo.updateSource(source, func(row *sourceRow) {
+		row.Version = version
+		row.applied = version
+	})
+}
+
+// SetAppliedVersionWithScript can't run the SQL script, so the version isn't applied either
+func (repo *MemoryRepository) SetAppliedVersionWithScript(source persist.NRTMSource, version uint32, script string) error {
+	return fmt.Errorf("%w: running a post-sync SQL script", ErrNotSupported)
+}
+
+// GetAppliedVersions reads the versions readers see of several sources at the same moment. A
+// source which has gone has 0.
+func (repo *MemoryRepository) GetAppliedVersions(sources []persist.NRTMSource) ([]uint32, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	versions := make([]uint32, len(sources))
+	for i, source := range sources {
+		if row, ok := repo.sources[source.ID]; ok {
+			versions[i] = row.applied
+		}
+	}
+	return versions, nil
+}
+
+// readVersion is the version of a source that readers see: version, or when it's 0, the latest
+// version which has been completely applied. Versions before the source's baseline can't be read.
+func (repo *MemoryRepository) readVersion(source persist.NRTMSource, version uint32) (uint32, error) {
+	row, ok := repo.sources[source.ID]
+	if !ok {
+		return 0, errNoSource
+	}
+	if version == 0 {
+		return row.applied, nil
+	}
+	if version > row.applied {
+		return 0, fmt.Errorf("%w: %d, the latest is %d", persist.ErrVersionNotApplied, version, row.applied)
+	}
+	if version < row.baseline {
+		return 0, fmt.Errorf("%w: %d, the earliest is %d", persist.ErrVersionNotInRepo, version, row.baseline)
+	}
+	return version, nil
+}
+
+// versionAt is the highest version we knew about at a point in time. If the source was
+// connected after that, then it's the earliest version of its objects, so snapshot objects
+// aren't counted.
+func (repo *MemoryRepository) versionAt(source persist.NRTMSource, at time.Time) uint32 {
+	var version uint32
+	found := false
+	for _, n := range repo.notifications[source.ID] {
+		if n.Created.Before(at) && (!found || n.Version > version) {
+			version, found = n.Version, true
+		}
+	}
+	if found {
+		return version
+	}
+	rows := repo.sourceRows(source.ID)
+	if len(rows) == 0 {
+		return 0
+	}
+	version = rows[0].from
+	for _, r := range rows {
+		version = min(version, r.from)
+	}
+	return version
+}
@@ -0,0 +1,231 @@
+package mem
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+func route(descr string) rpsl.Rpsl {
+	return rpsl.Rpsl{
+		ObjectType: "ROUTE",
+		PrimaryKey: "192.0.2.0/24AS65000",
+		Payload:    "route: 192.0.2.0/24\norigin: AS65000\ndescr: " + descr + "\nmnt-by: EXAMPLE-MNT\nsource: EXAMPLE\n",
+	}
+}
+
+func newTestSource(t *testing.T, repo *MemoryRepository) persist.NRTMSource {
+	source, err := repo.SaveSource(persist.NRTMSource{Source: "EXAMPLE", SessionID: "session", Version: 1}, protocol.NotificationJSON{})
+	if err != nil {
+		t.Fatal("Could not save source", err)
+	}
+	return source
+}
+
+func applyDelta(t *testing.T, repo *MemoryRepository, source persist.NRTMSource, version uint32, changes ...persist.DeltaChange) []persist.DeltaChange {
+	missing, err := repo.ApplyDeltaChanges(source, changes, protocol.NrtmFileJSON{Version: version})
+	if err != nil {
+		t.Fatal("Failed to apply changes", err)
+	}
+	if err = repo.SetAppliedVersion(source, version); err != nil {
+		t.Fatal("Failed to set applied version", err)
+	}
+	return missing
+}
+
+func lookup(t *testing.T, repo *MemoryRepository, source persist.NRTMSource, version uint32) []rpsl.Rpsl {
+	objects, err := repo.LookupObjects(source, version, []string{"192.0.2.0/24AS65000"})
+	if err != nil {
+		t.Fatal("Failed to look up objects", err)
+	}
+	return objects
+}
+
+func TestInitializeNeedsMemoryURL(t *testing.T) {
+	repo := NewRepository()
+	if err := repo.Initialize("postgres://localhost/nrtm4"); !errors.Is(err, ErrNotSupported) {
+		t.Error("Expected ErrNotSupported but was", err)
+	}
+	if err := repo.Initialize("mem://"); err != nil {
+		t.Error("Unexpected error", err)
+	}
+}
+
+func TestObjectHistory(t *testing.T) {
+	repo := NewRepository()
+	source := newTestSource(t, repo)
+	if err := repo.SaveSnapshotObjects(source, []rpsl.Rpsl{route("first")}, protocol.NrtmFileJSON{Version: 1}); err != nil {
+		t.Fatal("Failed to save snapshot objects", err)
+	}
+	repo.SetAppliedVersion(source, 1)
+	modify := []persist.DeltaChange{{Action: persist.DeltaAddModifyAction, Object: route("second")}}
+	applyDelta(t, repo, source, 2, modify...)
+	if modify[0].Previous == nil || modify[0].Previous.Payload != route("first").Payload {
+		t.Error("Expected the previous version to be set", modify[0].Previous)
+	}
+	del := persist.DeltaChange{Action: persist.DeltaDeleteAction, Object: rpsl.Rpsl{ObjectType: "ROUTE", PrimaryKey: "192.0.2.0/24AS65000"}}
+	if missing := applyDelta(t, repo, source, 3, del); len(missing) != 0 {
+		t.Error("Expected the delete to find the object", missing)
+	}
+	if missing := applyDelta(t, repo, source, 4, del); len(missing) != 1 {
+		t.Error("Expected a delete of a deleted object to be returned", missing)
+	}
+
+	for version, expected := range map[uint32]string{1: "first", 2: "second"} {
+		objects := lookup(t, repo, source, version)
+		if len(objects) != 1 || objects[0].Payload != route(expected).Payload {
+			t.Error("Expected", expected, "at version", version, "but was", objects)
+		}
+	}
+	if objects := lookup(t, repo, source, 0); len(objects) != 0 {
+		t.Error("Expected the object to be deleted", objects)
+	}
+	if _, err := repo.LookupObjects(source, 5, nil); !errors.Is(err, persist.ErrVersionNotApplied) {
+		t.Error("Expected ErrVersionNotApplied but was", err)
+	}
+	history, _ := repo.GetObjectHistory(source, "192.0.2.0/24")
+	if len(history) != 2 || history[0].ToVersion != 2 || history[1].FromVersion != 2 || history[1].ToVersion != 3 {
+		t.Error("Unexpected history", history)
+	}
+	changes := []persist.ObjectChange{}
+	repo.GetObjectChanges(source, 2, 3, func(c persist.ObjectChange) error {
+		changes = append(changes, c)
+		return nil
+	})
+	if len(changes) != 2 || changes[0].Version != 2 || changes[0].Deleted || changes[1].Version != 3 || !changes[1].Deleted {
+		t.Error("Expected a modification then a deletion but was", changes)
+	}
+
+	restored, err := repo.UndeleteObject(source, "ROUTE", "192.0.2.0/24AS65000", time.Time{})
+	if err != nil || restored.RPSL != route("second").Payload {
+		t.Fatal("Expected the last version to be restored", restored, err)
+	}
+	if _, err = repo.UndeleteObject(source, "ROUTE", "192.0.2.0/24AS65000", time.Time{}); !errors.Is(err, persist.ErrNoDeletedObject) {
+		t.Error("Expected ErrNoDeletedObject but was", err)
+	}
+	// The restored version is a local override, so the server's deletion is still a change
+	changes = []persist.ObjectChange{}
+	repo.GetObjectChanges(source, 2, 3, func(c persist.ObjectChange) error {
+		changes = append(changes, c)
+		return nil
+	})
+	if len(changes) != 2 || !changes[1].Deleted {
+		t.Error("Expected the restored version not to be a change but was", changes)
+	}
+	history, _ = repo.GetObjectHistory(source, "192.0.2.0/24")
+	restoredVersions := 0
+	for _, ov := range history {
+		if !ov.Restored.IsZero() && ov.ToVersion == 0 {
+			restoredVersions++
+		}
+	}
+	if len(history) != 3 || restoredVersions != 1 {
+		t.Error("Expected the current version to be marked as restored", history)
+	}
+}
+
+func TestObjectChangesDeletesFirst(t *testing.T) {
+	repo := NewRepository()
+	source := newTestSource(t, repo)
+	repo.SaveSnapshotObjects(source, []rpsl.Rpsl{route("first")}, protocol.NrtmFileJSON{Version: 1})
+	repo.SetAppliedVersion(source, 1)
+	other := func(origin string) rpsl.Rpsl {
+		return rpsl.Rpsl{ObjectType: "ROUTE", PrimaryKey: "192.0.2.0/24" + origin, Payload: "route: 192.0.2.0/24\norigin: " + origin + "\nsource: EXAMPLE\n"}
+	}
+	applyDelta(t, repo, source, 2,
+		persist.DeltaChange{Action: persist.DeltaAddModifyAction, Object: other("AS64999")},
+		persist.DeltaChange{Action: persist.DeltaDeleteAction, Object: rpsl.Rpsl{ObjectType: "ROUTE", PrimaryKey: "192.0.2.0/24AS65000"}},
+		persist.DeltaChange{Action: persist.DeltaAddModifyAction, Object: other("AS65001")},
+		persist.DeltaChange{Action: persist.DeltaDeleteAction, Object: rpsl.Rpsl{ObjectType: "ROUTE", PrimaryKey: "192.0.2.0/24AS65001"}},
+	)
+	changes := []persist.ObjectChange{}
+	repo.GetObjectChanges(source, 2, 2, func(c persist.ObjectChange) error {
+		changes = append(changes, c)
+		return nil
+	})
+	if len(changes) != 2 || !changes[0].Deleted || changes[0].PrimaryKey != "192.0.2.0/24AS65000" || changes[1].Deleted {
+		t.Error("Expected the deletion before the addition, and nothing for an object which only lasted the delta, but was", changes)
+	}
+}
+
+func TestDiscardAndSquash(t *testing.T) {
+	repo := NewRepository()
+	source := newTestSource(t, repo)
+	repo.SaveSnapshotObjects(source, []rpsl.Rpsl{route("first")}, protocol.NrtmFileJSON{Version: 1})
+	applyDelta(t, repo, source, 2, persist.DeltaChange{Action: persist.DeltaAddModifyAction, Object: route("second")})
+	if sources, _ := repo.GetSources(); sources[0].Version != 2 {
+		t.Error("Expected the source's version to move on with the applied version", sources)
+	}
+	if _, err := repo.ApplyDeltaChanges(source, []persist.DeltaChange{{Action: persist.DeltaAddModifyAction, Object: route("third")}}, protocol.NrtmFileJSON{Version: 3}); err != nil {
+		t.Fatal("Failed to apply changes", err)
+	}
+	changed, err := repo.DiscardUnappliedChanges(source)
+	if err != nil || changed != 2 {
+		t.Error("Expected the new version to be removed and the old one restored", changed, err)
+	}
+	if objects := lookup(t, repo, source, 0); len(objects) != 1 || objects[0].Payload != route("second").Payload {
+		t.Error("Expected the applied version to be current", objects)
+	}
+	squashed, _ := repo.SquashHistory(source, 2)
+	if squashed.Removed != 1 || squashed.Rebased != 0 {
+		t.Error("Unexpected squash", squashed)
+	}
+	if history, _ := repo.GetObjectHistory(source, "192.0.2.0/24AS65000"); len(history) != 1 {
+		t.Error("Expected only the current version to be left", history)
+	}
+	if _, err := repo.LookupObjects(source, 1, []string{"192.0.2.0/24AS65000"}); !errors.Is(err, persist.ErrVersionNotInRepo) {
+		t.Error("Expected a version before the squash baseline to be rejected but was", err)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	repo := NewRepository()
+	source := newTestSource(t, repo)
+	other := route("other")
+	other.PrimaryKey = "198.51.100.0/24AS65000"
+	repo.SaveSnapshotObjects(source, []rpsl.Rpsl{route("first"), other}, protocol.NrtmFileJSON{Version: 1})
+	repo.SetAppliedVersion(source, 1)
+	load := func(objects ...rpsl.Rpsl) persist.SnapshotLoader {
+		return func(fn func([]rpsl.Rpsl) error) error { return fn(objects) }
+	}
+	added := route("added")
+	added.PrimaryKey = "203.0.113.0/24AS65000"
+
+	comparison, err := repo.CompareSnapshot(source, 1, load(route("second"), added))
+	if err != nil {
+		t.Fatal("Failed to compare snapshot", err)
+	}
+	if comparison.Matching != 0 || comparison.Missing != 1 || comparison.Unexpected != 1 || comparison.Different != 1 || len(comparison.Examples) != 3 {
+		t.Error("Unexpected comparison", comparison)
+	}
+	if _, err = repo.CompareSnapshot(source, 0, load()); !errors.Is(err, persist.ErrVersionNotInRepo) {
+		t.Error("Expected ErrVersionNotInRepo but was", err)
+	}
+
+	changes, err := repo.ApplySnapshot(source, 5, load(route("second"), added))
+	if err != nil {
+		t.Fatal("Failed to apply snapshot", err)
+	}
+	if changes.Added != 1 || changes.Modified != 1 || changes.Deleted != 1 {
+		t.Error("Unexpected changes", changes)
+	}
+	if versions, _ := repo.GetAppliedVersions([]persist.NRTMSource{source}); versions[0] != 5 {
+		t.Error("Expected the snapshot version to be applied", versions)
+	}
+	if objects := lookup(t, repo, source, 0); len(objects) != 1 || objects[0].Payload != route("second").Payload {
+		t.Error("Expected the snapshot's version of the object", objects)
+	}
+
+	next := newTestSource(t, repo)
+	changes, err = repo.ApplySessionSnapshot(next, source, 1, load(route("second")))
+	if err != nil {
+		t.Fatal("Failed to apply session snapshot", err)
+	}
+	if changes.Carried != 2 || changes.Added != 0 || changes.Modified != 0 || changes.Deleted != 1 {
+		t.Error("Unexpected changes", changes)
+	}
+}
@@ -0,0 +1,618 @@
+package mem
+
+import (
+	"cmp"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+const maxTopMaintainers = 10
+
+// errNoCurrentObject the object isn't one of the source's current objects
+var errNoCurrentObject = errors.New("no current version of the object")
+
+var mntByRegexp = regexp.MustCompile(`(?im)^mnt-by:\s*([^\s#]+)`)
+
+// objectRow is one version of an object, from the version which added it until the one which
+// replaced or deleted it, or until now when to is zero
+type objectRow struct {
+	id         uint64
+	objectType string
+	primaryKey string
+	from       uint32
+	to         uint32
+	rpsl       string
+	// restored is when undelete restored the version, or zero if it came from the server
+	restored time.Time
+}
+
+// objectKey identifies an object in a source. Types and keys are compared in upper case.
+type objectKey struct {
+	objectType string
+	primaryKey string
+}
+
+func keyOf(objectType, primaryKey string) objectKey {
+	return objectKey{strings.ToUpper(objectType), strings.ToUpper(primaryKey)}
+}
+
+func (r *objectRow) visibleAt(version uint32) bool {
+	return r.from <= version && (r.to == 0 || r.to > version)
+}
+
+func (r *objectRow) image() *rpsl.Rpsl {
+	return &rpsl.Rpsl{ObjectType: r.objectType, PrimaryKey: r.primaryKey, Payload: r.rpsl}
+}
+
+// current is the version of an object with no to version, or nil if it's been deleted
+func current(rows []*objectRow) *objectRow {
+	for _, r := range rows {
+		if r.to == 0 {
+			return r
+		}
+	}
+	return nil
+}
+
+// followed is true when a version of the object starts where r ends, so r was replaced rather
+// than deleted
+func followed(rows []*objectRow, r *objectRow) bool {
+	return slices.ContainsFunc(rows, func(nxt *objectRow) bool { return nxt != r && nxt.from == r.to && nxt.restored.IsZero() })
+}
+
+// changedFrom is true when r is a version the server made after baseline, rather than one
+// restored by undelete
+func changedFrom(r *objectRow, baseline uint32) bool {
+	return r.from > baseline && r.restored.IsZero()
+}
+
+// preceded is true when a version of the object ends where r starts, so r modified it
+func preceded(rows []*objectRow, r *objectRow) bool {
+	return slices.ContainsFunc(rows, func(prev *objectRow) bool { return prev != r && prev.to == r.from })
+}
+
+// sourceRows lists every version of every object in a source, ordered by id
+func (repo *MemoryRepository) sourceRows(sourceID uint64) []*objectRow {
+	rows := []*objectRow{}
+	for _, versions := range repo.objects[sourceID] {
+		rows = append(rows, versions...)
+	}
+	slices.SortFunc(rows, func(a, b *objectRow) int { return cmp.Compare(a.id, b.id) })
+	return rows
+}
+
+// eachObject calls fn with the versions of each of a source's objects
+func (repo *MemoryRepository) eachObject(sourceID uint64, fn func([]*objectRow)) {
+	for _, versions := range repo.objects[sourceID] {
+		fn(versions)
+	}
+}
+
+// addRow saves a new version of an object
+func (repo *MemoryRepository) addRow(sourceID uint64, objectType, primaryKey string, from uint32, text string) *objectRow {
+	objects, ok := repo.objects[sourceID]
+	if !ok {
+		objects = map[objectKey][]*objectRow{}
+		repo.objects[sourceID] = objects
+	}
+	row := &objectRow{id: repo.nextID(), objectType: objectType, primaryKey: primaryKey, from: from, rpsl: text}
+	key := keyOf(objectType, primaryKey)
+	objects[key] = append(objects[key], row)
+	return row
+}
+
+// removeRows deletes the versions of a source's objects which match, and returns how many
+func (repo *MemoryRepository) removeRows(sourceID uint64, match func(*objectRow) bool) int64 {
+	var removed int64
+	for key, rows := range repo.objects[sourceID] {
+		rows = slices.DeleteFunc(rows, func(r *objectRow) bool {
+			if match(r) {
+				repo.unindex(r.id)
+				removed++
+				return true
+			}
+			return false
+		})
+		if len(rows) == 0 {
+			delete(repo.objects[sourceID], key)
+		} else {
+			repo.objects[sourceID][key] = rows
+		}
+	}
+	return removed
+}
+
+// SaveSnapshotObjects saves a list of rpsl objects at the snapshot's version
+func (repo *MemoryRepository) SaveSnapshotObjects(source persist.NRTMSource, rpslObjects []rpsl.Rpsl, file protocol.NrtmFileJSON) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, obj := range rpslObjects {
+		repo.addRow(source.ID, obj.ObjectType, obj.PrimaryKey, file.Version, obj.Payload)
+	}
+	return nil
+}
+
+// AddModifyObject saves a new version of an object, and ends the version it replaces
+func (repo *MemoryRepository) AddModifyObject(source persist.NRTMSource, object rpsl.Rpsl, file protocol.NrtmFileJSON) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.addModifyObject(source.ID, object, file.Version)
+	return nil
+}
+
+// addModifyObject saves a new version of an object and returns the version it replaced, which
+// is nil when the object is new
+func (repo *MemoryRepository) addModifyObject(sourceID uint64, object rpsl.Rpsl, version uint32) *rpsl.Rpsl {
+	rows := repo.objects[sourceID][keyOf(object.ObjectType, object.PrimaryKey)]
+	if i := slices.IndexFunc(rows, func(r *objectRow) bool { return r.from == version }); i >= 0 {
+		// Already processed an operation, just overwrite it
+		row := rows[i]
+		var previous *rpsl.Rpsl
+		if row.to == 0 {
+			previous = row.image()
+		}
+		row.objectType, row.primaryKey, row.to, row.rpsl = object.ObjectType, object.PrimaryKey, 0, object.Payload
+		return previous
+	}
+	var previous *rpsl.Rpsl
+	if row := current(rows); row != nil {
+		previous = row.image()
+		row.to = version
+	}
+	repo.addRow(sourceID, object.ObjectType, object.PrimaryKey, version, object.Payload)
+	return previous
+}
+
+// DeleteObject doesn't remove the object, instead it ends its current version at the file
+// version
+func (repo *MemoryRepository) DeleteObject(source persist.NRTMSource, objectType string, primaryKey string, file protocol.NrtmFileJSON) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	_, err := repo.deleteObject(source.ID, objectType, primaryKey, file.Version)
+	return err
+}
+
+// deleteObject ends the current version of an object and returns it
+func (repo *MemoryRepository) deleteObject(sourceID uint64, objectType, primaryKey string, version uint32) (*rpsl.Rpsl, error) {
+	row := current(repo.objects[sourceID][keyOf(objectType, primaryKey)])
+	if row == nil {
+		return nil, errNoCurrentObject
+	}
+	row.to = version
+	return row.image(), nil
+}
+
+// ApplyDeltaChanges applies a group of a delta's changes in order. Deletes of objects which
+// aren't in the repo don't fail the group, they're returned instead. The version of each object
+// before its change is set in the change's Previous.
+func (repo *MemoryRepository) ApplyDeltaChanges(source persist.NRTMSource, changes []persist.DeltaChange, file protocol.NrtmFileJSON) ([]persist.DeltaChange, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	var missing []persist.DeltaChange
+	for i, change := range changes {
+		if change.Action != persist.DeltaDeleteAction {
+			changes[i].Previous = repo.addModifyObject(source.ID, change.Object, file.Version)
+			continue
+		}
+		var err error
+		changes[i].Previous, err = repo.deleteObject(source.ID, change.Object.ObjectType, change.Object.PrimaryKey, file.Version)
+		if err != nil {
+			missing = append(missing, change)
+		}
+	}
+	return missing, nil
+}
+
+// DiscardUnappliedChanges rolls a source back to its applied version, throwing away what was
+// saved from a delta which wasn't completely applied, and returns the number of object versions
+// changed
+func (repo *MemoryRepository) DiscardUnappliedChanges(source persist.NRTMSource) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	row, ok := repo.sources[source.ID]
+	if !ok {
+		return 0, errNoSource
+	}
+	applied := row.applied
+	changed := repo.removeRows(source.ID, func(r *objectRow) bool { return r.from > applied })
+	repo.eachObject(source.ID, func(rows []*objectRow) {
+		for _, r := range rows {
+			if r.to > applied {
+				r.to = 0
+				changed++
+			}
+		}
+	})
+	row.Version = applied
+	return changed, nil
+}
+
+// UndeleteObject restores the last version of an object which was deleted after a point in
+// time. It's added again at the source's current version, marked as restored so it isn't taken
+// for a change the server made, and the history still shows when it was deleted. It returns
+// persist.ErrNoDeletedObject if the object exists, or if it wasn't deleted in that time.
+func (repo *MemoryRepository) UndeleteObject(source persist.NRTMSource, objectType string, primaryKey string, since time.Time) (persist.ObjectVersion, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	baseline := repo.versionAt(source, since)
+	rows := repo.objects[source.ID][keyOf(objectType, primaryKey)]
+	var deleted *objectRow
+	if current(rows) == nil {
+		for _, r := range rows {
+			if r.to > baseline && !followed(rows, r) && (deleted == nil || r.to > deleted.to) {
+				deleted = r
+			}
+		}
+	}
+	if deleted == nil {
+		return persist.ObjectVersion{}, persist.ErrNoDeletedObject
+	}
+	row := repo.addRow(source.ID, deleted.objectType, deleted.primaryKey, source.Version, deleted.rpsl)
+	row.restored = util.AppClock.Now()
+	return persist.ObjectVersion{
+		ObjectType:  row.objectType,
+		PrimaryKey:  row.primaryKey,
+		FromVersion: row.from,
+		RPSL:        row.rpsl,
+		Restored:    row.restored,
+	}, nil
+}
+
+// SquashHistory collapses a source's history before baseline. Object versions which ended at or
+// before baseline are removed, and the versions left which started before baseline are moved to
+// start at it.
+func (repo *MemoryRepository) SquashHistory(source persist.NRTMSource, baseline uint32) (persist.SquashedHistory, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	squashed := persist.SquashedHistory{Baseline: baseline}
+	squashed.Removed = repo.removeRows(source.ID, func(r *objectRow) bool { return r.to != 0 && r.to <= baseline })
+	repo.eachObject(source.ID, func(rows []*objectRow) {
+		for _, r := range rows {
+			if r.from < baseline {
+				r.from = baseline
+				squashed.Rebased++
+			}
+		}
+	})
+	if row, ok := repo.sources[source.ID]; ok {
+		row.baseline = max(row.baseline, baseline)
+	}
+	return squashed, nil
+}
+
+// CompactHistory does nothing, since the memory repository keeps every version in full
+func (repo *MemoryRepository) CompactHistory(source persist.NRTMSource, fullEvery int) (persist.CompactedHistory, error) {
+	return persist.CompactedHistory{FullEvery: fullEvery}, nil
+}
+
+// GetChangeSummary counts objects added, modified and deleted since a point in time
+func (repo *MemoryRepository) GetChangeSummary(source persist.NRTMSource, since time.Time) (persist.ChangeSummary, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	baseline := repo.versionAt(source, since)
+	summary := persist.ChangeSummary{FromVersion: baseline, ToVersion: source.Version}
+	maintainers := map[string]int{}
+	repo.eachObject(source.ID, func(rows []*objectRow) {
+		for _, r := range rows {
+			changed := changedFrom(r, baseline)
+			if changed && preceded(rows, r) {
+				summary.Modified++
+			} else if changed {
+				summary.Added++
+			}
+			if r.to > baseline && !followed(rows, r) {
+				summary.Deleted++
+				changed = true
+			}
+			if changed {
+				for _, m := range mntByRegexp.FindAllStringSubmatch(r.rpsl, -1) {
+					maintainers[strings.ToUpper(m[1])]++
+				}
+			}
+		}
+	})
+	for mntner, changes := range maintainers {
+		summary.TopMaintainers = append(summary.TopMaintainers, persist.MaintainerCount{Maintainer: mntner, Changes: changes})
+	}
+	slices.SortFunc(summary.TopMaintainers, func(a, b persist.MaintainerCount) int {
+		if c := cmp.Compare(b.Changes, a.Changes); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Maintainer, b.Maintainer)
+	})
+	if len(summary.TopMaintainers) > maxTopMaintainers {
+		summary.TopMaintainers = summary.TopMaintainers[:maxTopMaintainers]
+	}
+	return summary, nil
+}
+
+// GetOwnerCounts counts the current objects which reference each value of the given attributes,
+// e.g. mnt-by, and the changes since a point in time to objects which reference them. Changes
+// are counted the same way as GetChangeSummary. Attribute names are used in a regular
+// expression, so the caller must check they're only letters, digits and hyphens.
+func (repo *MemoryRepository) GetOwnerCounts(source persist.NRTMSource, attributes []string, since time.Time) (persist.OwnerCounts, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	baseline := repo.versionAt(source, since)
+	counts := persist.OwnerCounts{FromVersion: baseline, ToVersion: source.Version, Owners: []persist.OwnerCount{}}
+	re, err := regexp.Compile(`(?im)^(` + strings.Join(attributes, "|") + `):\s*([^\s#]+)`)
+	if err != nil {
+		return counts, err
+	}
+	type owner struct{ attribute, owner string }
+	objects := map[owner]map[uint64]bool{}
+	changes := map[owner]map[uint64]bool{}
+	repo.eachObject(source.ID, func(rows []*objectRow) {
+		for _, r := range rows {
+			if r.to != 0 && r.from <= baseline && r.to <= baseline {
+				continue
+			}
+			for _, m := range re.FindAllStringSubmatch(r.rpsl, -1) {
+				o := owner{strings.ToLower(m[1]), strings.ToUpper(m[2])}
+				if objects[o] == nil {
+					objects[o], changes[o] = map[uint64]bool{}, map[uint64]bool{}
+				}
+				if r.to == 0 {
+					objects[o][r.id] = true
+				}
+				if changedFrom(r, baseline) || (r.to > baseline && !followed(rows, r)) {
+					changes[o][r.id] = true
+				}
+			}
+		}
+	})
+	for o, ids := range objects {
+		counts.Owners = append(counts.Owners, persist.OwnerCount{Attribute: o.attribute, Owner: o.owner, Objects: len(ids), Changes: len(changes[o])})
+	}
+	slices.SortFunc(counts.Owners, func(a, b persist.OwnerCount) int {
+		return cmp.Or(
+			strings.Compare(a.Attribute, b.Attribute),
+			cmp.Compare(b.Objects, a.Objects),
+			cmp.Compare(b.Changes, a.Changes),
+			strings.Compare(a.Owner, b.Owner),
+		)
+	})
+	return counts, nil
+}
+
+// GetObjectChanges calls fn with each object added, modified and deleted from one version to
+// another, inclusive. They're ordered by version, with deletions before additions. An object
+// replaced at a version only counts as deleted if there's no replacement, and one added and
+// deleted by the same delta isn't a change.
+func (repo *MemoryRepository) GetObjectChanges(source persist.NRTMSource, fromVersion, toVersion uint32, fn func(persist.ObjectChange) error) error {
+	repo.mu.RLock()
+	changes := []persist.ObjectChange{}
+	repo.eachObject(source.ID, func(rows []*objectRow) {
+		for _, r := range rows {
+			if r.from == r.to {
+				// Added and deleted by the same delta
+				continue
+			}
+			if r.from >= fromVersion && r.from <= toVersion && r.restored.IsZero() {
+				changes = append(changes, persist.ObjectChange{Version: r.from, ObjectType: r.objectType, PrimaryKey: r.primaryKey, RPSL: r.rpsl})
+			}
+			if r.to != 0 && r.to >= fromVersion && r.to <= toVersion && !followed(rows, r) {
+				changes = append(changes, persist.ObjectChange{Version: r.to, Deleted: true, ObjectType: r.objectType, PrimaryKey: r.primaryKey, RPSL: r.rpsl})
+			}
+		}
+	})
+	repo.mu.RUnlock()
+	slices.SortFunc(changes, func(a, b persist.ObjectChange) int {
+		deleted := func(c persist.ObjectChange) int {
+			if c.Deleted {
+				return 1
+			}
+			return 0
+		}
+		return cmp.Or(
+			cmp.Compare(a.Version, b.Version),
+			cmp.Compare(deleted(b), deleted(a)),
+			strings.Compare(a.ObjectType, b.ObjectType),
+			strings.Compare(a.PrimaryKey, b.PrimaryKey),
+		)
+	})
+	for _, change := range changes {
+		if err := fn(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visibleObjects lists the versions of a source's objects which were current at a version and
+// pass keep, ordered by type then primary key
+func (repo *MemoryRepository) visibleObjects(sourceID uint64, version uint32, keep func(*objectRow) bool) []*objectRow {
+	visible := []*objectRow{}
+	repo.eachObject(sourceID, func(rows []*objectRow) {
+		for _, r := range rows {
+			if r.visibleAt(version) && keep(r) {
+				visible = append(visible, r)
+			}
+		}
+	})
+	slices.SortFunc(visible, func(a, b *objectRow) int {
+		return cmp.Or(strings.Compare(a.objectType, b.objectType), strings.Compare(a.primaryKey, b.primaryKey))
+	})
+	return visible
+}
+
+// GetCurrentObjects calls fn with each of a source's objects of the given types, or all of them
+// when there are no types, as they were at a version. Version 0 is the latest applied version.
+func (repo *MemoryRepository) GetCurrentObjects(source persist.NRTMSource, version uint32, objectTypes []string, fn func(rpsl.Rpsl) error) error {
+	repo.mu.RLock()
+	version, err := repo.readVersion(source, version)
+	if err != nil {
+		repo.mu.RUnlock()
+		return err
+	}
+	objects := []rpsl.Rpsl{}
+	for _, r := range repo.visibleObjects(source.ID, version, func(r *objectRow) bool {
+		return len(objectTypes) == 0 || slices.Contains(objectTypes, r.objectType)
+	}) {
+		objects = append(objects, rpsl.Rpsl{Source: source.Source, ObjectType: r.objectType, PrimaryKey: r.primaryKey, Payload: r.rpsl})
+	}
+	repo.mu.RUnlock()
+	for _, obj := range objects {
+		if err = fn(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CountCurrentObjects counts a source's objects at its latest applied version
+func (repo *MemoryRepository) CountCurrentObjects(source persist.NRTMSource) (int, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	version, err := repo.readVersion(source, 0)
+	if err != nil {
+		return 0, err
+	}
+	return len(repo.visibleObjects(source.ID, version, func(*objectRow) bool { return true })), nil
+}
+
+// LookupObjects finds a source's objects with any of the primary keys, as they were at a
+// version. Version 0 is the latest applied version.
+func (repo *MemoryRepository) LookupObjects(source persist.NRTMSource, version uint32, primaryKeys []string) ([]rpsl.Rpsl, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	objects := []rpsl.Rpsl{}
+	version, err := repo.readVersion(source, version)
+	if err != nil {
+		return objects, err
+	}
+	rows := repo.visibleObjects(source.ID, version, func(r *objectRow) bool { return slices.Contains(primaryKeys, r.primaryKey) })
+	slices.SortStableFunc(rows, func(a, b *objectRow) int { return strings.Compare(a.primaryKey, b.primaryKey) })
+	for _, r := range rows {
+		objects = append(objects, rpsl.Rpsl{Source: source.Source, ObjectType: r.objectType, PrimaryKey: r.primaryKey, Payload: r.rpsl})
+	}
+	return objects, nil
+}
+
+// GetObjectHistory returns every version of the objects with a primary key, oldest first. The key
+// of a route is its prefix and origin, so a prefix on its own finds all the routes for it. The
+// times are when the snapshot or delta files for the versions were saved.
+func (repo *MemoryRepository) GetObjectHistory(source persist.NRTMSource, primaryKey string) ([]persist.ObjectVersion, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	primaryKey = strings.ToUpper(primaryKey)
+	appliedAt := func(version uint32) time.Time {
+		var earliest time.Time
+		for _, file := range repo.files {
+			if file.NrtmSourceID == source.ID && file.Version == version && file.Type != persist.NotificationFile &&
+				(earliest.IsZero() || file.Created.Before(earliest)) {
+				earliest = file.Created
+			}
+		}
+		return earliest
+	}
+	history := []persist.ObjectVersion{}
+	for _, r := range repo.sourceRows(source.ID) {
+		isRoute := r.objectType == "ROUTE" || r.objectType == "ROUTE6"
+		if r.primaryKey != primaryKey && !(isRoute && strings.HasPrefix(r.primaryKey, primaryKey+"AS")) {
+			continue
+		}
+		history = append(history, persist.ObjectVersion{
+			ObjectType:  r.objectType,
+			PrimaryKey:  r.primaryKey,
+			FromVersion: r.from,
+			ToVersion:   r.to,
+			FromTime:    appliedAt(r.from),
+			ToTime:      appliedAt(r.to),
+			RPSL:        r.rpsl,
+			Restored:    r.restored,
+		})
+	}
+	slices.SortStableFunc(history, func(a, b persist.ObjectVersion) int {
+		return cmp.Or(
+			strings.Compare(a.ObjectType, b.ObjectType),
+			cmp.Compare(a.FromVersion, b.FromVersion),
+			strings.Compare(a.PrimaryKey, b.PrimaryKey),
+		)
+	})
+	return history, nil
+}
+
+// provenance is where a version of a source came from, from the latest file saved for it
+func (repo *MemoryRepository) provenance(source persist.NRTMSource, version uint32) persist.Provenance {
+	p := persist.Provenance{Source: source.Source, SessionID: source.SessionID, Version: version}
+	if file := repo.latestFile(source.ID, version, func(persist.NRTMFile) bool { return true }); file != nil {
+		p.FileType, p.URL, p.Hash, p.RunID, p.Applied = file.Type.String(), file.URL, file.Hash, file.RunID, file.Created
+	}
+	return p
+}
+
+// GetProvenance returns the provenance of each of the versions, in the order given
+func (repo *MemoryRepository) GetProvenance(source persist.NRTMSource, versions []uint32) ([]persist.Provenance, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	provenance := []persist.Provenance{}
+	for _, version := range versions {
+		provenance = append(provenance, repo.provenance(source, version))
+	}
+	return provenance, nil
+}
+
+// GetObjectProvenance returns the provenance of the objects with the primary keys as they were
+// at a version. Version 0 is the latest applied version.
+func (repo *MemoryRepository) GetObjectProvenance(source persist.NRTMSource, version uint32, primaryKeys []string) ([]persist.ObjectProvenance, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	provenance := []persist.ObjectProvenance{}
+	version, err := repo.readVersion(source, version)
+	if err != nil {
+		return provenance, err
+	}
+	rows := repo.visibleObjects(source.ID, version, func(r *objectRow) bool { return slices.Contains(primaryKeys, r.primaryKey) })
+	slices.SortStableFunc(rows, func(a, b *objectRow) int { return strings.Compare(a.primaryKey, b.primaryKey) })
+	for _, r := range rows {
+		provenance = append(provenance, persist.ObjectProvenance{
+			ObjectType: r.objectType,
+			PrimaryKey: r.primaryKey,
+			Provenance: repo.provenance(source, r.from),
+		})
+	}
+	return provenance, nil
+}
+
+// GetDeltaActivity returns the notifications saved for a source since a point in time, and the
+// number of objects changed by each delta after the first of them
+func (repo *MemoryRepository) GetDeltaActivity(source persist.NRTMSource, since time.Time) (persist.DeltaActivity, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	activity := persist.DeltaActivity{Changes: map[uint32]int{}}
+	for _, n := range repo.notifications[source.ID] {
+		if n.Created.Before(since) {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, n.Payload.Timestamp)
+		if err != nil {
+			logger.Debug("Skipping notification with invalid timestamp", "version", n.Version, "timestamp", n.Payload.Timestamp)
+			continue
+		}
+		activity.Notifications = append(activity.Notifications, persist.VersionTimestamp{Version: n.Version, Timestamp: ts})
+	}
+	if len(activity.Notifications) == 0 {
+		return activity, nil
+	}
+	first := activity.Notifications[0].Version
+	repo.eachObject(source.ID, func(rows []*objectRow) {
+		for _, r := range rows {
+			if changedFrom(r, first) {
+				activity.Changes[r.from]++
+			}
+			if r.to > first && !followed(rows, r) {
+				activity.Changes[r.to]++
+			}
+		}
+	})
+	return activity, nil
+}
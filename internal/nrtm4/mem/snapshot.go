@@ -0,0 +1,186 @@
+package mem
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+const (
+	maxDivergenceExamples = 100
+	// applyStatsDeltas is how many of the most recent deltas ApplyStats are taken from
+	applyStatsDeltas = 100
+)
+
+// loadSnapshot reads all the snapshot's objects, by type and primary key
+func loadSnapshot(load persist.SnapshotLoader) (map[objectKey]rpsl.Rpsl, error) {
+	objects := map[objectKey]rpsl.Rpsl{}
+	err := load(func(batch []rpsl.Rpsl) error {
+		for _, obj := range batch {
+			objects[keyOf(obj.ObjectType, obj.PrimaryKey)] = obj
+		}
+		return nil
+	})
+	return objects, err
+}
+
+// CompareSnapshot compares snapshot objects with the source's objects at the snapshot version.
+// Nothing in the repo is changed.
+func (repo *MemoryRepository) CompareSnapshot(source persist.NRTMSource, version uint32, load persist.SnapshotLoader) (persist.SnapshotComparison, error) {
+	comparison := persist.SnapshotComparison{Version: version, Examples: []persist.ObjectDivergence{}}
+	snapshot, err := loadSnapshot(load)
+	if err != nil {
+		return comparison, err
+	}
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	rows := repo.sourceRows(source.ID)
+	if len(rows) == 0 || slices.MinFunc(rows, func(a, b *objectRow) int { return cmp.Compare(a.from, b.from) }).from > version {
+		return comparison, persist.ErrVersionNotInRepo
+	}
+	diverged := func(objectType, primaryKey, kind string) {
+		comparison.Examples = append(comparison.Examples, persist.ObjectDivergence{ObjectType: objectType, PrimaryKey: primaryKey, Kind: kind})
+	}
+	seen := map[objectKey]bool{}
+	for _, r := range rows {
+		if !r.visibleAt(version) {
+			continue
+		}
+		key := keyOf(r.objectType, r.primaryKey)
+		seen[key] = true
+		obj, ok := snapshot[key]
+		switch {
+		case !ok:
+			comparison.Unexpected++
+			diverged(r.objectType, r.primaryKey, "unexpected")
+		case obj.Payload != r.rpsl:
+			comparison.Different++
+			diverged(r.objectType, r.primaryKey, "different")
+		default:
+			comparison.Matching++
+		}
+	}
+	for key, obj := range snapshot {
+		if !seen[key] {
+			comparison.Missing++
+			diverged(obj.ObjectType, obj.PrimaryKey, "missing")
+		}
+	}
+	slices.SortFunc(comparison.Examples, func(a, b persist.ObjectDivergence) int {
+		return cmp.Or(
+			strings.Compare(a.Kind, b.Kind),
+			strings.Compare(a.ObjectType, b.ObjectType),
+			strings.Compare(a.PrimaryKey, b.PrimaryKey),
+		)
+	})
+	if len(comparison.Examples) > maxDivergenceExamples {
+		comparison.Examples = comparison.Examples[:maxDivergenceExamples]
+	}
+	return comparison, nil
+}
+
+// ApplySnapshot brings a source's objects up to a later snapshot of the same session, instead of
+// applying each delta in between. Objects which aren't in the snapshot are deleted, and objects
+// which are new or different are added, at the snapshot version. The source's version and
+// applied version are set to the snapshot version.
+func (repo *MemoryRepository) ApplySnapshot(source persist.NRTMSource, version uint32, load persist.SnapshotLoader) (persist.SnapshotChanges, error) {
+	changes := persist.SnapshotChanges{Version: version}
+	snapshot, err := loadSnapshot(load)
+	if err != nil {
+		return changes, err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return changes, repo.applySnapshot(source, snapshot, &changes)
+}
+
+// ApplySessionSnapshot re-initializes a source with the first snapshot of a new session, as
+// changes to the objects of its previous session. The previous session's current objects are
+// carried over to source at version 0, since the new session's versions start again, then the
+// differences are applied the same way as ApplySnapshot.
+func (repo *MemoryRepository) ApplySessionSnapshot(source persist.NRTMSource, previous persist.NRTMSource, version uint32, load persist.SnapshotLoader) (persist.SnapshotChanges, error) {
+	changes := persist.SnapshotChanges{Version: version}
+	snapshot, err := loadSnapshot(load)
+	if err != nil {
+		return changes, err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, r := range repo.sourceRows(previous.ID) {
+		if r.to == 0 {
+			repo.addRow(source.ID, r.objectType, r.primaryKey, 0, r.rpsl)
+			changes.Carried++
+		}
+	}
+	return changes, repo.applySnapshot(source, snapshot, &changes)
+}
+
+// applySnapshot brings the source's current objects in line with the snapshot, at the version of
+// changes
+func (repo *MemoryRepository) applySnapshot(source persist.NRTMSource, snapshot map[objectKey]rpsl.Rpsl, changes *persist.SnapshotChanges) error {
+	row, ok := repo.sources[source.ID]
+	if !ok {
+		return errNoSource
+	}
+	version := changes.Version
+	repo.eachObject(source.ID, func(rows []*objectRow) {
+		r := current(rows)
+		if r == nil {
+			return
+		}
+		if _, ok := snapshot[keyOf(r.objectType, r.primaryKey)]; !ok {
+			r.to = version
+			changes.Deleted++
+		}
+	})
+	for key, obj := range snapshot {
+		r := current(repo.objects[source.ID][key])
+		switch {
+		case r == nil:
+			changes.Added++
+		case r.rpsl != obj.Payload:
+			r.to = version
+			changes.Modified++
+		default:
+			continue
+		}
+		repo.addRow(source.ID, obj.ObjectType, obj.PrimaryKey, version, obj.Payload)
+	}
+	row.Version, row.applied = version, version
+	return nil
+}
+
+// GetApplyStats adds up the recorded sizes and times of a source's most recent deltas, and of the
+// last snapshot it loaded
+func (repo *MemoryRepository) GetApplyStats(source persist.NRTMSource) (persist.ApplyStats, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	var stats persist.ApplyStats
+	deltas := []persist.NRTMFile{}
+	var snapshot *persist.NRTMFile
+	for _, file := range repo.files {
+		if file.NrtmSourceID != source.ID || file.ApplyTime <= 0 {
+			continue
+		}
+		switch file.Type {
+		case persist.DeltaFile:
+			deltas = append(deltas, file)
+		case persist.SnapshotFile:
+			snapshot = &file
+		}
+	}
+	slices.SortStableFunc(deltas, func(a, b persist.NRTMFile) int { return cmp.Compare(b.Version, a.Version) })
+	for _, file := range deltas[:min(len(deltas), applyStatsDeltas)] {
+		stats.Deltas++
+		stats.DeltaBytes += file.Size
+		stats.DeltaTime += file.ApplyTime
+	}
+	if snapshot != nil {
+		stats.SnapshotBytes = snapshot.Size
+		stats.SnapshotTime = snapshot.ApplyTime
+	}
+	return stats, nil
+}
@@ -4,6 +4,9 @@ import (
 	"errors"
 	"strings"
 	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
 )
 
 // NRTMSource holds information about a remote NRTM source
@@ -15,16 +18,80 @@ type NRTMSource struct {
 	NotificationURL string
 	Label           string
 	Created         time.Time
+	Paused          bool
+	Quarantine      *Quarantine
+	// TermsURL points to the registry's license or terms of use for the data
+	TermsURL string
+	// Superseded is when the source was re-initialized and this session replaced by a new one,
+	// nil while the session is live
+	Superseded *time.Time
+	// LastNotification identifies the last notification file which was completely processed
+	LastNotification *NotificationFingerprint
+	// SampleRate is the fraction of objects kept when the source was connected with a sample of
+	// its snapshot, or 0 when it has every object
+	SampleRate float64
+}
+
+// NotificationFingerprint identifies a notification file by the hash of its body and the ETag
+// the server sent with it. Checked is when it was last fetched and processed in full.
+type NotificationFingerprint struct {
+	Hash    string
+	ETag    string
+	Checked time.Time
+}
+
+// Quarantine says why a source stopped updating, and when it will next be tried
+type Quarantine struct {
+	Failures int
+	Reason   string
+	Until    time.Time
+}
+
+// RemovedRows counts the rows deleted along with a source
+type RemovedRows struct {
+	Objects       int64
+	Notifications int64
+	Files         int64
+}
+
+// SquashedHistory is what squashing a source's history removed. Versions of objects which were
+// replaced or deleted by the baseline version are removed, and the ones left from before it are
+// rebased onto it.
+type SquashedHistory struct {
+	Baseline uint32
+	Removed  int64
+	Rebased  int64
+}
+
+// CompactedHistory is what compacting a source's history did. Compacted versions are stored as
+// diffs against the version which replaced them, and the bytes are of the texts they replaced
+// and the diffs they were replaced with.
+type CompactedHistory struct {
+	FullEvery   int
+	Compacted   int64
+	BytesBefore int64
+	BytesAfter  int64
 }
 
 // NRTMSourceDetails is a source with notification objects
 type NRTMSourceDetails struct {
 	NRTMSource
 	Notifications []Notification
+	// Expiries are the expiry times in the last notification which haven't passed, soonest first
+	Expiries []Expiry
+	// Sessions are the sessions seen at the source's notification URL, oldest first
+	Sessions []SessionSeen
+}
+
+// Expiry is when a server said a notification or file stops being valid
+type Expiry struct {
+	File    string
+	Version uint32
+	Expires time.Time
 }
 
 // NewNRTMSource prepares a new source object
-func NewNRTMSource(notification NotificationJSON, label string, notificationURL string) NRTMSource {
+func NewNRTMSource(notification protocol.NotificationJSON, label string, notificationURL string) NRTMSource {
 	return NRTMSource{
 		Source:          notification.Source,
 		SessionID:       notification.SessionID,
@@ -39,19 +106,28 @@ type Notification struct {
 	ID           uint64 `json:",string"`
 	Version      uint32
 	NRTMSourceID uint64 `json:",string"`
-	Payload      NotificationJSON
+	Payload      protocol.NotificationJSON
 	Created      time.Time
 }
 
 // NRTMFile describes a downloaded NRTM file
 type NRTMFile struct {
-	ID           uint64 `json:",string"`
-	Version      uint32
-	Type         NTRMFileType
-	URL          string
-	FileName     string
+	ID       uint64 `json:",string"`
+	Version  uint32
+	Type     NTRMFileType
+	URL      string
+	FileName string
+	Hash     string
+	// Header is the file's header record exactly as the server sent it
+	Header []byte
+	// Size is the length of the file in bytes
+	Size int64
+	// ApplyTime is how long the file took to download and apply, zero if it wasn't measured
+	ApplyTime    time.Duration
 	NrtmSourceID uint64 `json:",string"`
-	Created      time.Time
+	// RunID identifies the connect or update which applied the file
+	RunID   uint64 `json:",string"`
+	Created time.Time
 }
 
 // NTRMFileType enumerator for file types
@@ -88,3 +164,262 @@ func ToFileType(s string) (NTRMFileType, error) {
 	}
 	return -1, errors.New("invalid type")
 }
+
+// ObjectChange is an object added or modified at a version, or deleted at it when Deleted is
+// true. RPSL is the object as it was before it was deleted.
+type ObjectChange struct {
+	Version    uint32
+	Deleted    bool
+	ObjectType string
+	PrimaryKey string
+	RPSL       string
+}
+
+const (
+	// DeltaDeleteAction NRTM4 code for a delete operation
+	DeltaDeleteAction string = "delete"
+	// DeltaAddModifyAction NRTM4 code for an addition or modification operation
+	DeltaAddModifyAction = "add_modify"
+)
+
+// DeltaChange is one change from a delta file. Action is DeltaAddModifyAction or
+// DeltaDeleteAction, and Object only has ObjectType and PrimaryKey for a delete. Previous is
+// the version of the object the change replaced or deleted, which is set when it's applied and
+// is nil for a new object.
+type DeltaChange struct {
+	Action   string
+	Object   rpsl.Rpsl
+	Previous *rpsl.Rpsl
+}
+
+// Provenance is where a version of a source's objects came from: the session, the file which
+// brought the source to that version, and the run of the client which applied it. The file
+// fields are empty if the client didn't record the file.
+type Provenance struct {
+	Source    string
+	SessionID string
+	Version   uint32
+	FileType  string
+	URL       string
+	Hash      string
+	RunID     uint64 `json:",string"`
+	Applied   time.Time
+}
+
+// SnapshotRef is a snapshot a server advertised, as the current snapshot or a previous one. They're
+// kept so an older baseline can be fetched again while the server still has it.
+type SnapshotRef struct {
+	SessionID string
+	Version   uint32
+	URL       string
+	Hash      string
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// SessionSeen is a session a server published at a notification URL, with when and at which
+// versions the client saw it. Announced is true when the previous session's notification said
+// the server would rotate to it.
+type SessionSeen struct {
+	SessionID    string
+	FirstSeen    time.Time
+	LastSeen     time.Time
+	FirstVersion uint32
+	LastVersion  uint32
+	Announced    bool
+}
+
+// OversizedObject is a snapshot or delta record which was larger than max_object_size, so it
+// was quarantined instead of being applied. RecordStart is as much of it as was read. The type
+// and key are taken from its first line, and are empty if that wasn't read.
+type OversizedObject struct {
+	Version     uint32
+	ObjectType  string
+	PrimaryKey  string
+	Size        int
+	RecordStart string
+	Quarantined time.Time
+}
+
+// SyncRun is the outcome of one update of a source, kept so its history can be graphed. Lag is
+// how far the server's timestamp for ToVersion was behind the end of the run, and is zero when
+// it isn't known. Failure and Reason are as in a telemetry report.
+type SyncRun struct {
+	RunID       uint64 `json:",string"`
+	Started     time.Time
+	Duration    time.Duration
+	FromVersion uint32
+	ToVersion   uint32
+	Changes     int
+	Lag         time.Duration
+	Warnings    int
+	Success     bool
+	Failure     string
+	Reason      string
+}
+
+// ObjectProvenance is the provenance of the current version of an object
+type ObjectProvenance struct {
+	ObjectType string
+	PrimaryKey string
+	Provenance
+}
+
+// ObjectVersion is an object as it was from one version until the next, or until now when
+// ToVersion is zero. The times are when the client applied those versions, if it knows.
+type ObjectVersion struct {
+	ObjectType  string
+	PrimaryKey  string
+	FromVersion uint32
+	ToVersion   uint32
+	FromTime    time.Time
+	ToTime      time.Time
+	RPSL        string
+	// Restored is when the version was restored by undelete, or zero if the server sent it. A
+	// restored version is a local override, not a change the server made at FromVersion.
+	Restored time.Time
+}
+
+// SnapshotComparison counts the differences between a snapshot and a source's objects at the
+// snapshot's version, with some examples of objects which differ
+type SnapshotComparison struct {
+	Version  uint32
+	Matching int
+	// Missing objects are in the snapshot but not the repo
+	Missing int
+	// Unexpected objects are in the repo but not the snapshot
+	Unexpected int
+	Different  int
+	Examples   []ObjectDivergence
+}
+
+// ObjectDivergence is an object which is missing, unexpected or different
+type ObjectDivergence struct {
+	ObjectType string
+	PrimaryKey string
+	Kind       string
+}
+
+// SnapshotChanges counts the objects a snapshot changed when it was applied over a source's
+// objects to catch up with the server
+type SnapshotChanges struct {
+	Version  uint32
+	Added    int
+	Modified int
+	Deleted  int
+	// Carried is how many objects were carried over from the previous session
+	Carried int
+}
+
+// ApplyStats is how long a source's recent files took to download and apply. Times and sizes
+// are totals over the files which were measured.
+type ApplyStats struct {
+	Deltas        int
+	DeltaBytes    int64
+	DeltaTime     time.Duration
+	SnapshotBytes int64
+	SnapshotTime  time.Duration
+}
+
+// OwnerCount is how many current objects reference a maintainer or organisation in an
+// attribute, and how many changes were made to objects which reference it
+type OwnerCount struct {
+	Attribute string
+	Owner     string
+	Objects   int
+	Changes   int
+}
+
+// OwnerCounts counts objects and changes by owner. Changes are counted from FromVersion.
+type OwnerCounts struct {
+	FromVersion uint32
+	ToVersion   uint32
+	Owners      []OwnerCount
+}
+
+// ChangeSummary counts the changes made to a source's objects between two versions
+type ChangeSummary struct {
+	FromVersion    uint32
+	ToVersion      uint32
+	Added          int
+	Modified       int
+	Deleted        int
+	TopMaintainers []MaintainerCount
+}
+
+// DeltaActivity is a source's recent history, which its normal rate of change is learned from
+type DeltaActivity struct {
+	// Notifications are the versions the client has seen with the server's timestamp, oldest first
+	Notifications []VersionTimestamp
+	// Changes counts the objects added, modified or deleted by each delta, by version
+	Changes map[uint32]int
+}
+
+// VersionTimestamp is when the server said a source was at a version
+type VersionTimestamp struct {
+	Version   uint32
+	Timestamp time.Time
+}
+
+// MaintainerCount is the number of changed objects maintained by a mntner
+type MaintainerCount struct {
+	Maintainer string
+	Changes    int
+}
+
+// SchemaInfo describes the tables in the repository
+type SchemaInfo struct {
+	Backend       string
+	SchemaVersion int
+	Tables        []TableInfo
+}
+
+// TableInfo describes a table, its columns and indexes
+type TableInfo struct {
+	Name          string
+	EstimatedRows int64
+	TotalBytes    int64
+	Columns       []ColumnInfo
+	Indexes       []IndexInfo
+}
+
+// ColumnInfo describes a column
+type ColumnInfo struct {
+	Name     string
+	Type     string
+	Nullable bool
+}
+
+// IndexInfo describes an index
+type IndexInfo struct {
+	Name  string
+	Bytes int64
+}
+
+// SchemaVersion compares the database schema with the one the client was built for
+type SchemaVersion struct {
+	Current           int
+	Supported         int
+	LastClientVersion string
+}
+
+// Optional indexes, which are built in the background rather than when objects are saved
+const (
+	// FullTextIndex is a text search vector of each object
+	FullTextIndex = "fulltext"
+	// PrefixIndex holds the prefixes covered by inetnum, inet6num, route and route6 objects
+	PrefixIndex = "prefix"
+	// AttributeIndex holds the attributes of each object as JSON
+	AttributeIndex = "attributes"
+)
+
+// IndexProgress is how far the build of an optional index has got. CaughtUp is zero until
+// every object which existed when the build started has been indexed.
+type IndexProgress struct {
+	Name    string
+	Objects int64
+	// TotalObjects is the planner's estimate of the number of objects in the repo
+	TotalObjects int64
+	Started      time.Time
+	CaughtUp     time.Time
+}
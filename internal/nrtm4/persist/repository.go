@@ -1,19 +1,96 @@
 package persist
 
 import (
+	"errors"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
 	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
 )
 
+// ErrVersionNotInRepo the repo doesn't have a source's objects at a version, because it was
+// connected after that version
+var ErrVersionNotInRepo = errors.New("the repo has no objects for that version")
+
+// ErrNoDeletedObject there's no deleted version of the object which can be restored
+var ErrNoDeletedObject = errors.New("no deleted object found in the undelete window")
+
+// ErrUnknownIndex the name isn't one of the optional indexes
+var ErrUnknownIndex = errors.New("unknown index")
+
+// ErrVersionNotApplied a version was asked for which hasn't been applied yet
+var ErrVersionNotApplied = errors.New("version has not been applied")
+
+// ErrStatementTimeout the database cancelled a statement which ran for longer than the
+// repository's statement timeout
+var ErrStatementTimeout = errors.New("statement timed out")
+
+// SnapshotLoader is given a function which it calls with each batch of objects it reads
+type SnapshotLoader func(func([]rpsl.Rpsl) error) error
+
 // Repository defines the functions for NRTMClient's persistent storage
 type Repository interface {
 	Initialize(string) error
-	SaveSource(NRTMSource, NotificationJSON) (NRTMSource, error)
-	RemoveSource(NRTMSource) error
+	Ping() error
+	WithStatementTimeout(time.Duration) Repository
+	SaveSource(NRTMSource, protocol.NotificationJSON) (NRTMSource, error)
+	RemoveSource(NRTMSource) (RemovedRows, error)
+	PauseSource(NRTMSource, bool) error
+	SupersedeSource(NRTMSource, time.Time) error
+	SaveQuarantine(NRTMSource, *Quarantine) error
+	SaveNotificationFingerprint(NRTMSource, NotificationFingerprint) error
+	SaveTermsURL(NRTMSource, string) error
+	SaveLabel(NRTMSource, string) error
 	GetSources() ([]NRTMSource, error)
 	GetNotificationHistory(NRTMSource, uint32, uint32) ([]Notification, error)
 	SaveFile(*NRTMFile) error
-	SaveSnapshotObjects(NRTMSource, []rpsl.Rpsl, NrtmFileJSON) error
-	AddModifyObject(NRTMSource, rpsl.Rpsl, NrtmFileJSON) error
-	DeleteObject(NRTMSource, string, string, NrtmFileJSON) error
+	GetFileByHash(NRTMSource, string) (*NRTMFile, error)
+	SavePendingDeltas(NRTMSource, []protocol.FileRefJSON) error
+	GetPendingDeltas(NRTMSource) ([]protocol.FileRefJSON, error)
+	SaveSnapshotRefs(NRTMSource, []SnapshotRef) error
+	GetSnapshotRefs(NRTMSource) ([]SnapshotRef, error)
+	SaveOversizedObject(NRTMSource, OversizedObject) error
+	GetOversizedObjects(NRTMSource) ([]OversizedObject, error)
+	SetAppliedVersion(NRTMSource, uint32) error
+	SetAppliedVersionWithScript(NRTMSource, uint32, string) error
+	GetAppliedVersions([]NRTMSource) ([]uint32, error)
+	DiscardUnappliedChanges(NRTMSource) (int64, error)
+	SaveSyncRun(NRTMSource, SyncRun) error
+	SaveSessionSeen(NRTMSource, SessionSeen) error
+	GetSessionHistory(NRTMSource) ([]SessionSeen, error)
+	GetSyncRuns(NRTMSource, time.Time, time.Time) ([]SyncRun, error)
+	PruneSyncRuns(time.Time) (int64, error)
+	SaveSnapshotObjects(NRTMSource, []rpsl.Rpsl, protocol.NrtmFileJSON) error
+	AddModifyObject(NRTMSource, rpsl.Rpsl, protocol.NrtmFileJSON) error
+	DeleteObject(NRTMSource, string, string, protocol.NrtmFileJSON) error
+	ApplyDeltaChanges(NRTMSource, []DeltaChange, protocol.NrtmFileJSON) ([]DeltaChange, error)
+	UndeleteObject(NRTMSource, string, string, time.Time) (ObjectVersion, error)
+	SquashHistory(NRTMSource, uint32) (SquashedHistory, error)
+	CompactHistory(NRTMSource, int) (CompactedHistory, error)
+	GetChangeSummary(NRTMSource, time.Time) (ChangeSummary, error)
+	GetOwnerCounts(NRTMSource, []string, time.Time) (OwnerCounts, error)
+	GetObjectChanges(NRTMSource, uint32, uint32, func(ObjectChange) error) error
+	GetCurrentObjects(NRTMSource, uint32, []string, func(rpsl.Rpsl) error) error
+	CountCurrentObjects(NRTMSource) (int, error)
+	LookupObjects(NRTMSource, uint32, []string) ([]rpsl.Rpsl, error)
+	GetObjectHistory(NRTMSource, string) ([]ObjectVersion, error)
+	GetProvenance(NRTMSource, []uint32) ([]Provenance, error)
+	GetObjectProvenance(NRTMSource, uint32, []string) ([]ObjectProvenance, error)
+	GetDeltaActivity(NRTMSource, time.Time) (DeltaActivity, error)
+	CompareSnapshot(NRTMSource, uint32, SnapshotLoader) (SnapshotComparison, error)
+	ApplySnapshot(NRTMSource, uint32, SnapshotLoader) (SnapshotChanges, error)
+	ApplySessionSnapshot(NRTMSource, NRTMSource, uint32, SnapshotLoader) (SnapshotChanges, error)
+	GetApplyStats(NRTMSource) (ApplyStats, error)
+	GetSchemaInfo() (SchemaInfo, error)
+	BuildIndexBatch(string, int, func(rpsl.Rpsl) []string) (int, error)
+	GetIndexProgress() ([]IndexProgress, error)
+	IsIdle() (bool, error)
+	PartitionObjects(int) error
+	AnalyzeObjects() error
+	RunScript(string) error
+	GetSchemaVersion() (SchemaVersion, error)
+	RecordClientVersion(string) error
+	IsStandby() (bool, error)
+	Promote() error
 	Close() error
 }
@@ -0,0 +1,21 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// AnalyzeObjects refreshes the planner statistics of the objects table, and its partitions if
+// it's partitioned. Autovacuum gets round to it eventually, but until then queries after a
+// large import can be planned as if the table was still small.
+func (repo PostgresRepository) AnalyzeObjects() error {
+	start := time.Now()
+	defer func() { repo.logSlow("AnalyzeObjects", nil, start, unknownRows) }()
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `ANALYZE nrtm_rpslobject`)
+		return err
+	})
+}
@@ -0,0 +1,302 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+	pgpersist "github.com/petchells/nrtm4client/internal/nrtm4/pg/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+// applyStatsDeltas is how many of the most recent deltas ApplyStats are taken from
+const applyStatsDeltas = 100
+
+// ApplySnapshot brings a source's objects up to a later snapshot of the same session, instead of
+// applying each delta in between. Objects which aren't in the snapshot are deleted, and objects
+// which are new or different are added, at the snapshot version, so their history doesn't show
+// the versions in between. The source's version and applied version are set to the snapshot
+// version in the same transaction.
+func (repo PostgresRepository) ApplySnapshot(
+	source persist.NRTMSource,
+	version uint32,
+	load persist.SnapshotLoader,
+) (persist.SnapshotChanges, error) {
+	changes := persist.SnapshotChanges{Version: version}
+	start := time.Now()
+	defer func() {
+		repo.logSlow("ApplySnapshot", &source, start, changes.Added+changes.Modified+changes.Deleted)
+	}()
+	file := protocol.NrtmFileJSON{
+		NrtmVersion: 4,
+		Type:        persist.SnapshotFile.String(),
+		Source:      source.Source,
+		SessionID:   source.SessionID,
+		Version:     version,
+	}
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		if err := loadSnapshotTable(tx, "nrtm_catchup_object", load); err != nil {
+			return err
+		}
+		return repo.applySnapshotTable(tx, source, file, &changes)
+	})
+	return changes, err
+}
+
+// ApplySessionSnapshot re-initializes a source with the first snapshot of a new session, as
+// changes to the objects of its previous session instead of a fresh start. The snapshot is
+// loaded first, then the previous session's current objects are carried over to source, and
+// the differences are applied at the snapshot version, the same way as ApplySnapshot. Carried
+// objects start at version 0, since the new session's versions start again. It all happens in
+// one transaction, so nothing reading the source sees it empty or half loaded.
+func (repo PostgresRepository) ApplySessionSnapshot(
+	source persist.NRTMSource,
+	previous persist.NRTMSource,
+	version uint32,
+	load persist.SnapshotLoader,
+) (persist.SnapshotChanges, error) {
+	changes := persist.SnapshotChanges{Version: version}
+	start := time.Now()
+	defer func() {
+		repo.logSlow("ApplySessionSnapshot", &source, start, changes.Carried+changes.Added+changes.Modified+changes.Deleted)
+	}()
+	file := protocol.NrtmFileJSON{
+		NrtmVersion: 4,
+		Type:        persist.SnapshotFile.String(),
+		Source:      source.Source,
+		SessionID:   source.SessionID,
+		Version:     version,
+	}
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		changes = persist.SnapshotChanges{Version: version}
+		if err := loadSnapshotTable(tx, "nrtm_catchup_object", load); err != nil {
+			return err
+		}
+		carried, err := repo.carryObjects(tx, source, previous, file)
+		if err != nil {
+			return err
+		}
+		changes.Carried = carried
+		return repo.applySnapshotTable(tx, source, file, &changes)
+	})
+	return changes, err
+}
+
+// carryObjects copies the current objects of previous to source, starting at version 0, and
+// tells the hooks about them as if they were saved from a snapshot
+func (repo PostgresRepository) carryObjects(tx pgx.Tx, source, previous persist.NRTMSource, file protocol.NrtmFileJSON) (int, error) {
+	tag, err := tx.Exec(context.Background(), `
+		INSERT INTO nrtm_rpslobject (id, object_type, primary_key, nrtm_source_id, from_version, to_version, rpsl)
+		SELECT id_generator(), object_type, primary_key, $2, 0, 0, rpsl
+		FROM nrtm_rpslobject
+		WHERE nrtm_source_id = $1
+			AND to_version = 0`, previous.ID, source.ID,
+	)
+	if err != nil || len(repo.Hooks) == 0 {
+		return int(tag.RowsAffected()), err
+	}
+	rows, err := tx.Query(context.Background(), `
+		SELECT object_type, primary_key, rpsl FROM nrtm_rpslobject WHERE nrtm_source_id = $1`, source.ID)
+	if err != nil {
+		return 0, err
+	}
+	objects := []rpsl.Rpsl{}
+	for rows.Next() {
+		var row pgpersist.RPSLObject
+		if err = rows.Scan(&row.ObjectType, &row.PrimaryKey, &row.RPSL); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		objects = append(objects, *rowImage(&row))
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	for batch := range slices.Chunk(objects, cursorBatchSize) {
+		if err = repo.runHooks(func(hook ObjectHook) error {
+			return hook.SnapshotObjectsSaved(tx, source, batch, file)
+		}); err != nil {
+			return 0, err
+		}
+	}
+	return len(objects), nil
+}
+
+// applySnapshotTable brings the source's current objects in line with the snapshot loaded into
+// nrtm_catchup_object, at the version of file
+func (repo PostgresRepository) applySnapshotTable(tx pgx.Tx, source persist.NRTMSource, file protocol.NrtmFileJSON, changes *persist.SnapshotChanges) error {
+	added, err := snapshotObjectsToAdd(tx, source)
+	if err != nil {
+		return err
+	}
+	deleted, err := closeReplacedObjects(tx, source, file.Version)
+	if err != nil {
+		return err
+	}
+	inputRows := make([][]any, len(added))
+	for i, row := range added {
+		inputRows[i] = []any{
+			uint64(db.NextID()),
+			row.ObjectType,
+			row.PrimaryKey,
+			source.ID,
+			file.Version,
+			0,
+			row.RPSL,
+		}
+	}
+	rpslDescriptor := db.GetDescriptor(&pgpersist.RPSLObject{})
+	if _, err = tx.CopyFrom(
+		context.Background(),
+		pgx.Identifier{rpslDescriptor.TableName()},
+		rpslDescriptor.ColumnNames(),
+		pgx.CopyFromRows(inputRows),
+	); err != nil {
+		return err
+	}
+	for _, row := range added {
+		if row.ID > 0 {
+			changes.Modified++
+		} else {
+			changes.Added++
+		}
+		if err = repo.objectAdded(tx, source, *rowImage(&row), file); err != nil {
+			return err
+		}
+	}
+	for _, row := range deleted {
+		changes.Deleted++
+		if err = repo.runHooks(func(hook ObjectHook) error {
+			return hook.ObjectDeleted(tx, source, row.ObjectType, row.PrimaryKey, file)
+		}); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(context.Background(), `
+		UPDATE nrtm_source SET version = $2, applied_version = $2 WHERE id = $1`, source.ID, file.Version)
+	return err
+}
+
+// snapshotObjectsToAdd finds the snapshot objects which aren't current in the repo. ID is set to
+// the id of the current object they replace, or zero for new objects.
+func snapshotObjectsToAdd(tx pgx.Tx, source persist.NRTMSource) ([]pgpersist.RPSLObject, error) {
+	rows, err := tx.Query(context.Background(), `
+		SELECT v.object_type, v.primary_key, v.rpsl, COALESCE(r.id, 0)
+		FROM nrtm_catchup_object v
+		LEFT JOIN nrtm_rpslobject r
+			ON r.nrtm_source_id = $1
+			AND r.object_type = v.object_type
+			AND r.primary_key = v.primary_key
+			AND r.to_version = 0
+		WHERE r.id IS NULL OR r.rpsl <> v.rpsl`, source.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	objects := []pgpersist.RPSLObject{}
+	for rows.Next() {
+		var obj pgpersist.RPSLObject
+		if err = rows.Scan(&obj.ObjectType, &obj.PrimaryKey, &obj.RPSL, &obj.ID); err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+// closeReplacedObjects sets to_version on current objects which aren't in the snapshot, or are
+// different in it, removes their optional index entries, and returns the ones which aren't in it
+func closeReplacedObjects(tx pgx.Tx, source persist.NRTMSource, version uint32) ([]pgpersist.RPSLObject, error) {
+	rows, err := tx.Query(context.Background(), `
+		UPDATE nrtm_rpslobject r
+		SET to_version = $2
+		WHERE r.nrtm_source_id = $1
+			AND r.to_version = 0
+			AND NOT EXISTS (
+				SELECT 1 FROM nrtm_catchup_object v
+				WHERE v.object_type = r.object_type
+					AND v.primary_key = r.primary_key
+					AND v.rpsl = r.rpsl
+			)
+		RETURNING r.object_type, r.primary_key, EXISTS (
+			SELECT 1 FROM nrtm_catchup_object v
+			WHERE v.object_type = r.object_type
+				AND v.primary_key = r.primary_key
+		)`, source.ID, version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deleted := []pgpersist.RPSLObject{}
+	for rows.Next() {
+		var obj pgpersist.RPSLObject
+		var replaced bool
+		if err = rows.Scan(&obj.ObjectType, &obj.PrimaryKey, &replaced); err != nil {
+			return nil, err
+		}
+		if !replaced {
+			deleted = append(deleted, obj)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, idx := range optionalIndexes {
+		if _, err = tx.Exec(context.Background(), fmt.Sprintf(`
+			DELETE FROM %v i
+			USING nrtm_rpslobject r
+			WHERE i.rpslobject_id = r.id
+				AND r.nrtm_source_id = $1
+				AND r.to_version = $2`, idx.table), source.ID, version,
+		); err != nil {
+			return nil, err
+		}
+	}
+	return deleted, nil
+}
+
+// GetApplyStats adds up the recorded sizes and times of a source's most recent deltas, and of the
+// last snapshot it loaded
+func (repo PostgresRepository) GetApplyStats(source persist.NRTMSource) (persist.ApplyStats, error) {
+	var stats persist.ApplyStats
+	start := time.Now()
+	defer func() { repo.logSlow("GetApplyStats", &source, start, stats.Deltas) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		var deltaMs, snapshotMs int64
+		if err := tx.QueryRow(context.Background(), `
+			SELECT COUNT(*), COALESCE(SUM(size), 0), COALESCE(SUM(apply_ms), 0)
+			FROM (
+				SELECT size, apply_ms
+				FROM nrtm_file
+				WHERE nrtm_source_id = $1 AND type = $2 AND apply_ms > 0
+				ORDER BY version DESC
+				LIMIT $3
+			) f`, source.ID, persist.DeltaFile.String(), applyStatsDeltas,
+		).Scan(&stats.Deltas, &stats.DeltaBytes, &deltaMs); err != nil {
+			return err
+		}
+		stats.DeltaTime = time.Duration(deltaMs) * time.Millisecond
+		err := tx.QueryRow(context.Background(), `
+			SELECT size, apply_ms
+			FROM nrtm_file
+			WHERE nrtm_source_id = $1 AND type = $2 AND apply_ms > 0
+			ORDER BY created DESC
+			LIMIT 1`, source.ID, persist.SnapshotFile.String(),
+		).Scan(&stats.SnapshotBytes, &snapshotMs)
+		if err == pgx.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		stats.SnapshotTime = time.Duration(snapshotMs) * time.Millisecond
+		return nil
+	})
+	return stats, err
+}
@@ -0,0 +1,100 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+const maxTopMaintainers = 10
+
+// GetChangeSummary counts objects added, modified and deleted since a point in time
+func (repo PostgresRepository) GetChangeSummary(source persist.NRTMSource, since time.Time) (persist.ChangeSummary, error) {
+	summary := persist.ChangeSummary{ToVersion: source.Version}
+	start := time.Now()
+	defer func() {
+		repo.logSlow("GetChangeSummary", &source, start, summary.Added+summary.Modified+summary.Deleted)
+	}()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		baseline, err := versionAt(tx, source, since)
+		if err != nil {
+			return err
+		}
+		summary.FromVersion = baseline
+		if err = tx.QueryRow(context.Background(), changeCountsSQL, source.ID, baseline).Scan(
+			&summary.Added, &summary.Modified, &summary.Deleted,
+		); err != nil {
+			return err
+		}
+		rows, err := tx.Query(context.Background(), topMaintainersSQL, source.ID, baseline, maxTopMaintainers)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var mc persist.MaintainerCount
+			if err = rows.Scan(&mc.Maintainer, &mc.Changes); err != nil {
+				return err
+			}
+			summary.TopMaintainers = append(summary.TopMaintainers, mc)
+		}
+		return rows.Err()
+	})
+	return summary, err
+}
+
+// versionAt is the highest version we knew about at a point in time. If the source was
+// connected after that, then it's the snapshot version, so snapshot objects aren't counted.
+func versionAt(tx pgx.Tx, source persist.NRTMSource, at time.Time) (uint32, error) {
+	var version uint32
+	err := tx.QueryRow(context.Background(), `
+		SELECT COALESCE(
+			(SELECT MAX(version) FROM nrtm_notification WHERE nrtm_source_id = $1 AND created < $2),
+			(SELECT MIN(from_version) FROM nrtm_rpslobject WHERE nrtm_source_id = $1),
+			0
+		)`, source.ID, at).Scan(&version)
+	return version, err
+}
+
+var changeCountsSQL = `
+	SELECT
+		COUNT(*) FILTER (WHERE r.from_version > $2 AND r.restored IS NULL AND prev.id IS NULL),
+		COUNT(*) FILTER (WHERE r.from_version > $2 AND r.restored IS NULL AND prev.id IS NOT NULL),
+		COUNT(*) FILTER (WHERE r.to_version > $2 AND nxt.id IS NULL)
+	FROM nrtm_rpslobject r
+	LEFT JOIN nrtm_rpslobject prev
+		ON prev.nrtm_source_id = r.nrtm_source_id
+		AND prev.object_type = r.object_type
+		AND prev.primary_key = r.primary_key
+		AND prev.to_version = r.from_version
+	LEFT JOIN nrtm_rpslobject nxt
+		ON nxt.nrtm_source_id = r.nrtm_source_id
+		AND nxt.object_type = r.object_type
+		AND nxt.primary_key = r.primary_key
+		AND nxt.from_version = r.to_version
+		AND nxt.restored IS NULL
+	WHERE r.nrtm_source_id = $1
+		AND (r.from_version > $2 OR r.to_version > $2)`
+
+var topMaintainersSQL = `
+	SELECT UPPER(m[1]) AS mntner, COUNT(*) AS changes
+	FROM nrtm_rpslobject r,
+		REGEXP_MATCHES(COALESCE(r.rpsl, nrtm_rpsl(r.id)), '^mnt-by:\s*([^\s#]+)', 'gni') AS m
+	WHERE r.nrtm_source_id = $1
+		AND (
+			(r.from_version > $2 AND r.restored IS NULL)
+			OR (r.to_version > $2 AND NOT EXISTS (
+				SELECT 1 FROM nrtm_rpslobject nxt
+				WHERE nxt.nrtm_source_id = r.nrtm_source_id
+					AND nxt.object_type = r.object_type
+					AND nxt.primary_key = r.primary_key
+					AND nxt.from_version = r.to_version
+					AND nxt.restored IS NULL
+			))
+		)
+	GROUP BY mntner
+	ORDER BY changes DESC, mntner
+	LIMIT $3`
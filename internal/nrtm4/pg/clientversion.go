@@ -0,0 +1,56 @@
+package pg
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// SchemaVersion is the latest migration in third_party/tern that this code works with
+const SchemaVersion = 25
+
+// GetSchemaVersion compares the database schema with the one this client was built for
+func (repo PostgresRepository) GetSchemaVersion() (persist.SchemaVersion, error) {
+	version := persist.SchemaVersion{Supported: SchemaVersion}
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		var err error
+		if version.Current, err = schemaVersion(tx); err != nil {
+			return err
+		}
+		var exists bool
+		if err = tx.QueryRow(context.Background(), `
+			SELECT to_regclass('public.nrtm_client_version') IS NOT NULL`).Scan(&exists); err != nil || !exists {
+			return err
+		}
+		err = tx.QueryRow(context.Background(), `
+			SELECT client_version FROM nrtm_client_version
+			ORDER BY schema_version DESC, last_run DESC
+			LIMIT 1`).Scan(&version.LastClientVersion)
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+		return err
+	})
+	return version, err
+}
+
+// RecordClientVersion saves the client version along with the schema version it works with.
+// Nothing is saved on a standby, which is read-only.
+func (repo PostgresRepository) RecordClientVersion(clientVersion string) error {
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		var standby bool
+		if err := tx.QueryRow(context.Background(), `SELECT pg_is_in_recovery()`).Scan(&standby); err != nil || standby {
+			return err
+		}
+		now := util.AppClock.Now()
+		_, err := tx.Exec(context.Background(), `
+			INSERT INTO nrtm_client_version (client_version, schema_version, first_run, last_run)
+			VALUES ($1, $2, $3, $3)
+			ON CONFLICT (client_version, schema_version) DO UPDATE SET last_run = $3`,
+			clientVersion, SchemaVersion, now)
+		return err
+	})
+}
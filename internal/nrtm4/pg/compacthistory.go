@@ -0,0 +1,126 @@
+package pg
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// compactBatchSize is how many revisions are turned into diffs by one statement
+const compactBatchSize = 1000
+
+// revisionDiff is an old version of an object to be stored as a diff against the version which
+// replaced it
+type revisionDiff struct {
+	id   int64
+	diff string
+	base int64
+}
+
+// CompactHistory stores the old versions of a source's objects as diffs against the version
+// which replaced them. Each object's versions are walked from the newest, and every fullEvery-th
+// one is left in full so rebuilding a version never has to apply more than fullEvery-1 diffs.
+// Only versions which were replaced by a version that has been applied are compacted, and a
+// diff is only kept if it's smaller than the text.
+func (repo PostgresRepository) CompactHistory(source persist.NRTMSource, fullEvery int) (persist.CompactedHistory, error) {
+	compacted := persist.CompactedHistory{FullEvery: fullEvery}
+	start := time.Now()
+	defer func() { repo.logSlow("CompactHistory", &source, start, int(compacted.Compacted)) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		compacted = persist.CompactedHistory{FullEvery: fullEvery}
+		var diffs []revisionDiff
+		var objectType, primaryKey, prevText string
+		var prevID int64
+		var prevFrom uint32
+		depth := 0
+		err := queryCursor(tx, "compact_history", compactHistorySQL, []any{source.ID}, func(rows pgx.Rows) error {
+			var id int64
+			var rowType, rowKey string
+			var from, to uint32
+			var text *string
+			var diff []diffOp
+			var settled bool
+			if err := rows.Scan(&id, &rowType, &rowKey, &from, &to, &text, &diff, &settled); err != nil {
+				return err
+			}
+			newest := rowType != objectType || rowKey != primaryKey
+			objectType, primaryKey = rowType, rowKey
+			defer func() { prevID, prevFrom = id, from }()
+			if text == nil {
+				// Already a diff against the version walked before it
+				if newest {
+					prevText, depth = "", 0
+					return nil
+				}
+				prevText = applyLineDiff(prevText, diff)
+				depth++
+				return nil
+			}
+			base := prevText
+			prevText = *text
+			if newest || !settled || to != prevFrom || len(base) == 0 || len(*text) == 0 || depth+1 >= fullEvery {
+				depth = 0
+				return nil
+			}
+			encoded, err := json.Marshal(lineDiff(base, *text))
+			if err != nil {
+				return err
+			}
+			if len(encoded) >= len(*text) {
+				depth = 0
+				return nil
+			}
+			diffs = append(diffs, revisionDiff{id: id, diff: string(encoded), base: prevID})
+			compacted.BytesBefore += int64(len(*text))
+			compacted.BytesAfter += int64(len(encoded))
+			depth++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(diffs); i += compactBatchSize {
+			batch := diffs[i:min(i+compactBatchSize, len(diffs))]
+			ids := make([]int64, len(batch))
+			texts := make([]string, len(batch))
+			bases := make([]int64, len(batch))
+			for j, d := range batch {
+				ids[j], texts[j], bases[j] = d.id, d.diff, d.base
+			}
+			tag, err := tx.Exec(context.Background(), `
+				UPDATE nrtm_rpslobject r
+				SET rpsl = NULL, rpsl_diff = u.diff::jsonb, diff_base = u.base
+				FROM unnest($2::bigint[], $3::text[], $4::bigint[]) AS u(id, diff, base)
+				WHERE r.nrtm_source_id = $1
+					AND r.id = u.id`, source.ID, ids, texts, bases,
+			)
+			if err != nil {
+				return err
+			}
+			compacted.Compacted += tag.RowsAffected()
+		}
+		return nil
+	})
+	return compacted, err
+}
+
+// compactHistorySQL Only objects with old versions are read, newest version first. A version is
+// settled when the version which replaced it has been applied, so it won't be rewritten.
+var compactHistorySQL = `
+	SELECT r.id, r.object_type, r.primary_key, r.from_version, r.to_version, r.rpsl, r.rpsl_diff,
+		r.to_version <> 0 AND r.to_version <= s.applied_version
+	FROM nrtm_rpslobject r
+	JOIN nrtm_source s ON s.id = r.nrtm_source_id
+	WHERE r.nrtm_source_id = $1
+		AND EXISTS (
+			SELECT 1 FROM nrtm_rpslobject old
+			WHERE old.nrtm_source_id = r.nrtm_source_id
+				AND old.object_type = r.object_type
+				AND old.primary_key = r.primary_key
+				AND old.to_version <> 0
+		)
+	ORDER BY r.object_type, r.primary_key, r.from_version DESC`
@@ -0,0 +1,125 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+const maxDivergenceExamples = 100
+
+// CompareSnapshot loads snapshot objects into a temporary table and compares them with the
+// source's objects at the snapshot version. The table is dropped when the transaction ends, so
+// nothing in the repo is changed.
+func (repo PostgresRepository) CompareSnapshot(
+	source persist.NRTMSource,
+	version uint32,
+	load persist.SnapshotLoader,
+) (persist.SnapshotComparison, error) {
+	cmp := persist.SnapshotComparison{Version: version, Examples: []persist.ObjectDivergence{}}
+	start := time.Now()
+	defer func() { repo.logSlow("CompareSnapshot", &source, start, cmp.Matching+cmp.Missing+cmp.Different) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		var earliest *int64
+		if err := tx.QueryRow(context.Background(), `
+			SELECT MIN(from_version) FROM nrtm_rpslobject WHERE nrtm_source_id = $1`, source.ID,
+		).Scan(&earliest); err != nil {
+			return err
+		}
+		if earliest == nil || *earliest > int64(version) {
+			return persist.ErrVersionNotInRepo
+		}
+		if err := loadSnapshotTable(tx, "nrtm_verify_object", load); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(context.Background(), compareCountsSQL, source.ID, version).Scan(
+			&cmp.Matching, &cmp.Missing, &cmp.Unexpected, &cmp.Different,
+		); err != nil {
+			return err
+		}
+		rows, err := tx.Query(context.Background(), compareExamplesSQL, source.ID, version, maxDivergenceExamples)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var d persist.ObjectDivergence
+			if err = rows.Scan(&d.ObjectType, &d.PrimaryKey, &d.Kind); err != nil {
+				return err
+			}
+			cmp.Examples = append(cmp.Examples, d)
+		}
+		return rows.Err()
+	})
+	return cmp, err
+}
+
+// loadSnapshotTable creates a temporary table of snapshot objects, which is dropped when the
+// transaction ends
+func loadSnapshotTable(tx pgx.Tx, table string, load persist.SnapshotLoader) error {
+	if _, err := tx.Exec(context.Background(), `
+		CREATE TEMPORARY TABLE `+table+` (
+			object_type varchar(255) not null,
+			primary_key varchar(255) not null,
+			rpsl text not null
+		) ON COMMIT DROP`); err != nil {
+		return err
+	}
+	err := load(func(objects []rpsl.Rpsl) error {
+		rows := make([][]any, len(objects))
+		for i, obj := range objects {
+			rows[i] = []any{obj.ObjectType, obj.PrimaryKey, obj.Payload}
+		}
+		_, err := tx.CopyFrom(
+			context.Background(),
+			pgx.Identifier{table},
+			[]string{"object_type", "primary_key", "rpsl"},
+			pgx.CopyFromRows(rows),
+		)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(context.Background(), "ANALYZE "+table)
+	return err
+}
+
+// compareJoinSQL pairs each object in the repo at a version with the snapshot object of the
+// same type and primary key. An object is in the repo at a version from its from_version up to,
+// but not including, its to_version.
+var compareJoinSQL = `
+	FROM (
+		SELECT object_type, primary_key, COALESCE(rpsl, nrtm_rpsl(id)) AS rpsl
+		FROM nrtm_rpslobject
+		WHERE nrtm_source_id = $1
+			AND from_version <= $2
+			AND (to_version = 0 OR to_version > $2)
+	) r
+	FULL OUTER JOIN nrtm_verify_object v
+		ON v.object_type = r.object_type
+		AND v.primary_key = r.primary_key`
+
+var compareCountsSQL = `
+	SELECT
+		COUNT(*) FILTER (WHERE r.rpsl = v.rpsl),
+		COUNT(*) FILTER (WHERE r.primary_key IS NULL),
+		COUNT(*) FILTER (WHERE v.primary_key IS NULL),
+		COUNT(*) FILTER (WHERE r.rpsl <> v.rpsl)` + compareJoinSQL
+
+var compareExamplesSQL = `
+	SELECT
+		COALESCE(r.object_type, v.object_type) AS object_type,
+		COALESCE(r.primary_key, v.primary_key) AS primary_key,
+		CASE
+			WHEN r.primary_key IS NULL THEN 'missing'
+			WHEN v.primary_key IS NULL THEN 'unexpected'
+			ELSE 'different'
+		END AS kind` + compareJoinSQL + `
+	WHERE r.primary_key IS NULL OR v.primary_key IS NULL OR r.rpsl <> v.rpsl
+	ORDER BY kind, object_type, primary_key
+	LIMIT $3`
@@ -0,0 +1,93 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+// GetCurrentObjects calls fn with each of a source's objects of the given types, or all of them
+// when there are no types, as they were at a version. Version 0 is the latest applied version.
+// The objects are read through a cursor, a batch at a time.
+func (repo PostgresRepository) GetCurrentObjects(source persist.NRTMSource, version uint32, objectTypes []string, fn func(rpsl.Rpsl) error) error {
+	count := 0
+	start := time.Now()
+	defer func() { repo.logSlow("GetCurrentObjects", &source, start, count) }()
+	return repo.readTransaction(func(tx pgx.Tx) error {
+		version, err := readVersion(tx, source, version)
+		if err != nil {
+			return err
+		}
+		return queryCursor(tx, "current_objects", `
+			SELECT object_type, primary_key, COALESCE(rpsl, nrtm_rpsl(id))
+			FROM nrtm_rpslobject
+			WHERE nrtm_source_id = $1
+				AND `+visibleAt(3)+`
+				AND (coalesce(cardinality($2::text[]), 0) = 0 OR object_type = ANY($2))
+			ORDER BY object_type, primary_key`, []any{source.ID, objectTypes, version}, func(rows pgx.Rows) error {
+			obj := rpsl.Rpsl{Source: source.Source}
+			if err := rows.Scan(&obj.ObjectType, &obj.PrimaryKey, &obj.Payload); err != nil {
+				return err
+			}
+			count++
+			return fn(obj)
+		})
+	})
+}
+
+// CountCurrentObjects counts a source's objects at its latest applied version
+func (repo PostgresRepository) CountCurrentObjects(source persist.NRTMSource) (int, error) {
+	count := 0
+	start := time.Now()
+	defer func() { repo.logSlow("CountCurrentObjects", &source, start, count) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		version, err := readVersion(tx, source, 0)
+		if err != nil {
+			return err
+		}
+		return tx.QueryRow(context.Background(), `
+			SELECT count(*)
+			FROM nrtm_rpslobject
+			WHERE nrtm_source_id = $1
+				AND `+visibleAt(2), source.ID, version).Scan(&count)
+	})
+	return count, err
+}
+
+// LookupObjects finds a source's objects with any of the primary keys in one query, as they were
+// at a version. Version 0 is the latest applied version.
+func (repo PostgresRepository) LookupObjects(source persist.NRTMSource, version uint32, primaryKeys []string) ([]rpsl.Rpsl, error) {
+	objects := []rpsl.Rpsl{}
+	start := time.Now()
+	defer func() { repo.logSlow("LookupObjects", &source, start, len(objects)) }()
+	err := repo.readTransaction(func(tx pgx.Tx) error {
+		version, err := readVersion(tx, source, version)
+		if err != nil {
+			return err
+		}
+		rows, err := tx.Query(context.Background(), `
+			SELECT object_type, primary_key, COALESCE(rpsl, nrtm_rpsl(id))
+			FROM nrtm_rpslobject
+			WHERE nrtm_source_id = $1
+				AND `+visibleAt(3)+`
+				AND primary_key = ANY($2)
+			ORDER BY primary_key, object_type`, source.ID, primaryKeys, version)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			obj := rpsl.Rpsl{Source: source.Source}
+			if err = rows.Scan(&obj.ObjectType, &obj.PrimaryKey, &obj.Payload); err != nil {
+				return err
+			}
+			objects = append(objects, obj)
+		}
+		return rows.Err()
+	})
+	return objects, err
+}
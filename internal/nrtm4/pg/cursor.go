@@ -0,0 +1,45 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// cursorBatchSize is how many rows are fetched from a cursor at a time
+const cursorBatchSize = 1000
+
+// queryCursor runs sql in a server-side cursor and calls fn with each row. Rows are fetched
+// cursorBatchSize at a time, so neither the client nor the connection holds the whole result.
+// The cursor only lives as long as tx, so name needs to be unique in it.
+func queryCursor(tx pgx.Tx, name, sql string, args []any, fn func(pgx.Rows) error) error {
+	ctx := context.Background()
+	if _, err := tx.Exec(ctx, "DECLARE "+name+" NO SCROLL CURSOR FOR "+sql, args...); err != nil {
+		return err
+	}
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM %v", cursorBatchSize, name)
+	for {
+		rows, err := tx.Query(ctx, fetch)
+		if err != nil {
+			return err
+		}
+		n := 0
+		for rows.Next() {
+			n++
+			if err = fn(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if n < cursorBatchSize {
+			break
+		}
+	}
+	_, err := tx.Exec(ctx, "CLOSE "+name)
+	return err
+}
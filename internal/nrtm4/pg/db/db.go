@@ -28,4 +28,4 @@ package db
 
 import "github.com/petchells/nrtm4client/internal/nrtm4/util"
 
-var logger = util.Logger
+var logger = util.ModuleLogger("pg")
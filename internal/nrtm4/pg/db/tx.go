@@ -5,10 +5,13 @@ import (
 	"errors"
 	"log"
 	"os"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/petchells/nrtm4client/internal/nrtm4/faults"
+	"github.com/petchells/nrtm4client/internal/nrtm4/retry"
 )
 
 // TxFn can be run inside a transaction
@@ -16,18 +19,56 @@ type TxFn func(pgx.Tx) error
 
 var pool *pgxpool.Pool
 
-// InitializeConnectionPool must be called before connecting to db
-func InitializeConnectionPool(url string) error {
-	p, err := pgxpool.New(context.Background(), os.Getenv("PG_DATABASE_URL"))
+// connectRetry retries starting a transaction when the database can't be connected to
+var connectRetry *retry.Retrier
+
+// pingTimeout is how long Ping waits for a connection
+const pingTimeout = 10 * time.Second
+
+// PasswordFunc returns the password for a new connection, e.g. a short-lived token
+type PasswordFunc func(context.Context) (string, error)
+
+// InitializeConnectionPool must be called before connecting to db. When password isn't nil
+// it's called for each new connection, and what it returns replaces any password in url.
+// Connecting is retried with retrier, which may be nil.
+func InitializeConnectionPool(url string, password PasswordFunc, retrier *retry.Retrier) error {
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		log.Fatal("ERROR db.connect: ", err)
+		return err
+	}
+	if password != nil {
+		config.BeforeConnect = func(ctx context.Context, cc *pgx.ConnConfig) error {
+			pw, err := password(ctx)
+			if err != nil {
+				return err
+			}
+			cc.Password = pw
+			return nil
+		}
+	}
+	p, err := pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
 		log.Fatal("ERROR db.connect: ", err)
 		return err
 	}
 	pool = p
+	connectRetry = retrier
 	logger.Info("Number of db pool connections", "max", p.Config().MaxConns)
 	return nil
 }
 
+// Ping checks that a connection can be made to the database. Unlike WithTransaction, it returns
+// an error when it can't connect rather than exiting.
+func Ping() error {
+	if pool == nil {
+		return errors.New("connection pool is nil. see db.InitializeConnectionPool(connectionURL)")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	return pool.Ping(ctx)
+}
+
 // WithTransaction executes a function within a transaction
 func WithTransaction(fn TxFn) error {
 	var err error
@@ -35,7 +76,11 @@ func WithTransaction(fn TxFn) error {
 	if pool == nil {
 		return errors.New("connection pool is nil. see db.InitializeConnectionPool(connectionURL)")
 	}
-	if tx, err = pool.Begin(context.Background()); err != nil {
+	err = connectRetry.Do("database", isConnectError, func(int) error {
+		tx, err = pool.Begin(context.Background())
+		return err
+	})
+	if err != nil {
 		if cerr, ok := err.(*pgconn.ConnectError); ok {
 			logger.Error("No connection to PostgreSQL database.", "error", cerr)
 			log.Println("ERROR: No connection to PostgreSQL database")
@@ -57,9 +102,17 @@ func WithTransaction(fn TxFn) error {
 		}
 	}()
 	err = fn(tx)
+	if err == nil {
+		err = faults.Check(faults.TxCommit)
+	}
 	return err
 }
 
+func isConnectError(err error) bool {
+	var cerr *pgconn.ConnectError
+	return errors.As(err, &cerr)
+}
+
 // NextID gets a new id from the pg sequence generator
 func NextID() uint64 {
 	if pool == nil {
@@ -0,0 +1,88 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// GetDeltaActivity returns the notifications saved for a source since a point in time, and the
+// number of objects changed by each delta after the first of them
+func (repo PostgresRepository) GetDeltaActivity(source persist.NRTMSource, since time.Time) (persist.DeltaActivity, error) {
+	activity := persist.DeltaActivity{Changes: map[uint32]int{}}
+	start := time.Now()
+	defer func() {
+		repo.logSlow("GetDeltaActivity", &source, start, len(activity.Notifications)+len(activity.Changes))
+	}()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		rows, err := tx.Query(context.Background(), `
+			SELECT version, payload->>'timestamp'
+			FROM nrtm_notification
+			WHERE nrtm_source_id = $1 AND created >= $2
+			ORDER BY version, created`, source.ID, since)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var version uint32
+			var timestamp string
+			if err = rows.Scan(&version, &timestamp); err != nil {
+				rows.Close()
+				return err
+			}
+			ts, err := time.Parse(time.RFC3339, timestamp)
+			if err != nil {
+				logger.Debug("Skipping notification with invalid timestamp", "version", version, "timestamp", timestamp)
+				continue
+			}
+			activity.Notifications = append(activity.Notifications, persist.VersionTimestamp{Version: version, Timestamp: ts})
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil || len(activity.Notifications) == 0 {
+			return err
+		}
+		rows, err = tx.Query(context.Background(), changesPerVersionSQL, source.ID, activity.Notifications[0].Version)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var version uint32
+			var changes int
+			if err = rows.Scan(&version, &changes); err != nil {
+				return err
+			}
+			activity.Changes[version] = changes
+		}
+		return rows.Err()
+	})
+	return activity, err
+}
+
+// changesPerVersionSQL counts changes the same way as objectChangesSQL
+var changesPerVersionSQL = `
+	SELECT version, COUNT(*)
+	FROM (
+		SELECT r.from_version AS version
+		FROM nrtm_rpslobject r
+		WHERE r.nrtm_source_id = $1
+			AND r.from_version > $2
+			AND r.restored IS NULL
+		UNION ALL
+		SELECT r.to_version
+		FROM nrtm_rpslobject r
+		WHERE r.nrtm_source_id = $1
+			AND r.to_version > $2
+			AND NOT EXISTS (
+				SELECT 1 FROM nrtm_rpslobject nxt
+				WHERE nxt.nrtm_source_id = r.nrtm_source_id
+					AND nxt.object_type = r.object_type
+					AND nxt.primary_key = r.primary_key
+					AND nxt.from_version = r.to_version
+					AND nxt.restored IS NULL
+			)
+	) c
+	GROUP BY version`
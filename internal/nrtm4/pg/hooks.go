@@ -0,0 +1,61 @@
+package pg
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+// ObjectHook lets an embedder keep its own tables in step with the mirror, such as a map of
+// prefixes to origins. Each function is called in the transaction which changed the objects,
+// so an error rolls back the change along with anything the hook wrote.
+type ObjectHook interface {
+	// SnapshotObjectsSaved is called for each batch of objects written from a snapshot
+	SnapshotObjectsSaved(tx pgx.Tx, source persist.NRTMSource, objects []rpsl.Rpsl, file protocol.NrtmFileJSON) error
+	// ObjectAdded is called when a delta adds an object or replaces it with a new version
+	ObjectAdded(tx pgx.Tx, source persist.NRTMSource, object rpsl.Rpsl, file protocol.NrtmFileJSON) error
+	// ObjectDeleted is called when a delta deletes an object
+	ObjectDeleted(tx pgx.Tx, source persist.NRTMSource, objectType, primaryKey string, file protocol.NrtmFileJSON) error
+}
+
+// ObjectImageHook is an ObjectHook which is also given both versions of each object a delta or
+// an undelete changes. ObjectChanged is called after ObjectAdded or ObjectDeleted, with a nil
+// previous for a new object and a nil object for a delete.
+type ObjectImageHook interface {
+	ObjectHook
+	ObjectChanged(tx pgx.Tx, source persist.NRTMSource, previous, object *rpsl.Rpsl, file protocol.NrtmFileJSON) error
+}
+
+// runHooks calls fn for each hook in turn, and stops at the first error
+func (repo PostgresRepository) runHooks(fn func(ObjectHook) error) error {
+	for _, hook := range repo.Hooks {
+		if err := fn(hook); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	registeredHooks   []ObjectHook
+	registeredHooksMu sync.Mutex
+)
+
+// RegisterHook adds a hook to those RegisteredHooks returns. It's usually called from an init
+// function, before the repository is opened.
+func RegisterHook(hook ObjectHook) {
+	registeredHooksMu.Lock()
+	defer registeredHooksMu.Unlock()
+	registeredHooks = append(registeredHooks, hook)
+}
+
+// RegisteredHooks returns the hooks registered with RegisterHook, in the order they were added
+func RegisteredHooks() []ObjectHook {
+	registeredHooksMu.Lock()
+	defer registeredHooksMu.Unlock()
+	return slices.Clone(registeredHooks)
+}
@@ -0,0 +1,107 @@
+package pg
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+type recordingHook struct {
+	name  string
+	calls *[]string
+	err   error
+}
+
+func (h recordingHook) SnapshotObjectsSaved(tx pgx.Tx, source persist.NRTMSource, objects []rpsl.Rpsl, file protocol.NrtmFileJSON) error {
+	*h.calls = append(*h.calls, h.name+" snapshot")
+	return h.err
+}
+
+func (h recordingHook) ObjectAdded(tx pgx.Tx, source persist.NRTMSource, object rpsl.Rpsl, file protocol.NrtmFileJSON) error {
+	*h.calls = append(*h.calls, h.name+" added "+object.PrimaryKey)
+	return h.err
+}
+
+func (h recordingHook) ObjectDeleted(tx pgx.Tx, source persist.NRTMSource, objectType, primaryKey string, file protocol.NrtmFileJSON) error {
+	*h.calls = append(*h.calls, h.name+" deleted "+primaryKey)
+	return h.err
+}
+
+func TestRunHooksStopsAtFirstError(t *testing.T) {
+	calls := []string{}
+	hookErr := errors.New("side table is full")
+	repo := PostgresRepository{Hooks: []ObjectHook{
+		recordingHook{name: "first", calls: &calls},
+		recordingHook{name: "second", calls: &calls, err: hookErr},
+		recordingHook{name: "third", calls: &calls},
+	}}
+	err := repo.objectAdded(nil, persist.NRTMSource{}, rpsl.Rpsl{PrimaryKey: "AS3333"}, protocol.NrtmFileJSON{})
+	if err != hookErr {
+		t.Error("Expected hook error but was", err)
+	}
+	if len(calls) != 2 || calls[0] != "first added AS3333" || calls[1] != "second added AS3333" {
+		t.Error("Unexpected hook calls", calls)
+	}
+}
+
+type imageHook struct {
+	recordingHook
+}
+
+func (h imageHook) ObjectChanged(tx pgx.Tx, source persist.NRTMSource, previous, object *rpsl.Rpsl, file protocol.NrtmFileJSON) error {
+	image := func(obj *rpsl.Rpsl) string {
+		if obj == nil {
+			return "none"
+		}
+		return obj.Payload
+	}
+	*h.calls = append(*h.calls, h.name+" changed "+image(previous)+" to "+image(object))
+	return h.err
+}
+
+func TestObjectImageHook(t *testing.T) {
+	calls := []string{}
+	repo := PostgresRepository{Hooks: []ObjectHook{
+		recordingHook{name: "plain", calls: &calls},
+		imageHook{recordingHook{name: "images", calls: &calls}},
+	}}
+	before := rpsl.Rpsl{PrimaryKey: "AS3333", Payload: "v1"}
+	after := rpsl.Rpsl{PrimaryKey: "AS3333", Payload: "v2"}
+	if err := repo.objectChanged(nil, persist.NRTMSource{}, &before, &after, protocol.NrtmFileJSON{}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err := repo.objectChanged(nil, persist.NRTMSource{}, &after, nil, protocol.NrtmFileJSON{}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	expected := []string{
+		"plain added AS3333", "images added AS3333", "images changed v1 to v2",
+		"plain deleted AS3333", "images deleted AS3333", "images changed v2 to none",
+	}
+	if !slices.Equal(calls, expected) {
+		t.Error("Expected", expected, "but was", calls)
+	}
+}
+
+func TestRegisterHook(t *testing.T) {
+	defer func(hooks []ObjectHook) { registeredHooks = hooks }(registeredHooks)
+	registeredHooks = nil
+	calls := []string{}
+	RegisterHook(recordingHook{name: "first", calls: &calls})
+	RegisterHook(imageHook{recordingHook{name: "second", calls: &calls}})
+	hooks := RegisteredHooks()
+	if len(hooks) != 2 {
+		t.Fatal("Expected two hooks but was", hooks)
+	}
+	if _, ok := hooks[1].(ObjectImageHook); !ok {
+		t.Error("Expected the second hook to be an ObjectImageHook")
+	}
+	hooks[0] = nil
+	if RegisteredHooks()[0] == nil {
+		t.Error("Expected the registered hooks to be copied")
+	}
+}
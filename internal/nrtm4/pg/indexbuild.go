@@ -0,0 +1,199 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// optionalIndex is the table an optional index is stored in, and the expression which converts
+// a value for it from text
+type optionalIndex struct {
+	table  string
+	column string
+	expr   string
+}
+
+var optionalIndexes = map[string]optionalIndex{
+	persist.FullTextIndex:  {"nrtm_fulltext_index", "document", "to_tsvector('simple', v)"},
+	persist.PrefixIndex:    {"nrtm_prefix_index", "prefix", "v::cidr"},
+	persist.AttributeIndex: {"nrtm_attribute_index", "attributes", "v::jsonb"},
+}
+
+// Object ids start with the number of milliseconds since an epoch, shifted left 22 bits (see
+// id_generator). Ids are allocated before the transaction which saves the objects commits, so a
+// build looks back this far for objects which were committed after ones with higher ids.
+const indexLookback = 24 * time.Hour
+
+var indexLookbackIDs = int64(indexLookback.Milliseconds()) << 22
+
+// BuildIndexBatch adds up to batchSize current objects which haven't been indexed yet to an
+// optional index, and returns how many were added. values gives the values to index for an
+// object. An object without any is stored with a null value, so it isn't picked up again.
+// Versions of objects which have been replaced or deleted aren't indexed, and their entries are
+// removed when they're replaced (see dropIndexEntries).
+func (repo PostgresRepository) BuildIndexBatch(name string, batchSize int, values func(rpsl.Rpsl) []string) (int, error) {
+	idx, ok := optionalIndexes[name]
+	if !ok {
+		return 0, fmt.Errorf("%w: %v", persist.ErrUnknownIndex, name)
+	}
+	indexed := 0
+	start := time.Now()
+	defer func() { repo.logSlow("BuildIndexBatch", nil, start, indexed) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		now := util.AppClock.Now()
+		if _, err := tx.Exec(context.Background(), `
+			INSERT INTO nrtm_index_build (name, started) VALUES ($1, $2)
+			ON CONFLICT (name) DO NOTHING`, name, now,
+		); err != nil {
+			return err
+		}
+		var lastID int64
+		// Locks the row, so two builders don't index the same objects
+		if err := tx.QueryRow(context.Background(), `
+			SELECT last_object_id FROM nrtm_index_build WHERE name = $1 FOR UPDATE`, name,
+		).Scan(&lastID); err != nil {
+			return err
+		}
+		rows, err := tx.Query(context.Background(), fmt.Sprintf(`
+			SELECT r.id, r.nrtm_source_id, r.object_type, r.primary_key, COALESCE(r.rpsl, nrtm_rpsl(r.id))
+			FROM nrtm_rpslobject r
+			WHERE r.id > $1
+				AND r.to_version = 0
+				AND NOT EXISTS (SELECT 1 FROM %v i WHERE i.rpslobject_id = r.id)
+			ORDER BY r.id
+			LIMIT $2`, idx.table), max(0, lastID-indexLookbackIDs), batchSize)
+		if err != nil {
+			return err
+		}
+		var ids, sourceIDs []int64
+		var vals []*string
+		for rows.Next() {
+			var id, sourceID int64
+			var obj rpsl.Rpsl
+			if err = rows.Scan(&id, &sourceID, &obj.ObjectType, &obj.PrimaryKey, &obj.Payload); err != nil {
+				rows.Close()
+				return err
+			}
+			indexed++
+			lastID = max(lastID, id)
+			objValues := values(obj)
+			if len(objValues) == 0 {
+				ids, sourceIDs, vals = append(ids, id), append(sourceIDs, sourceID), append(vals, nil)
+			}
+			for _, v := range objValues {
+				ids, sourceIDs, vals = append(ids, id), append(sourceIDs, sourceID), append(vals, &v)
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) > 0 {
+			if _, err = tx.Exec(context.Background(), fmt.Sprintf(`
+				INSERT INTO %v (rpslobject_id, nrtm_source_id, %v)
+				SELECT id, src, %v FROM UNNEST($1::bigint[], $2::bigint[], $3::text[]) AS u(id, src, v)`,
+				idx.table, idx.column, idx.expr), ids, sourceIDs, vals,
+			); err != nil {
+				return err
+			}
+		}
+		var caughtUp *time.Time
+		if indexed < batchSize {
+			caughtUp = &now
+		}
+		_, err = tx.Exec(context.Background(), `
+			UPDATE nrtm_index_build
+			SET last_object_id = $2, objects = objects + $3, caught_up = COALESCE(caught_up, $4)
+			WHERE name = $1`, name, lastID, indexed, caughtUp)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return indexed, nil
+}
+
+// dropIndexEntries removes the optional index entries of object rows which have been replaced,
+// deleted or overwritten
+func dropIndexEntries(tx pgx.Tx, ids ...uint64) error {
+	for _, idx := range optionalIndexes {
+		if _, err := tx.Exec(context.Background(), fmt.Sprintf(`
+			DELETE FROM %v WHERE rpslobject_id = ANY($1)`, idx.table), ids,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewindIndexBuilds makes the optional index builds look at objects again from id on, for rows
+// which are current again, or were overwritten, after the builds went past them
+func rewindIndexBuilds(tx pgx.Tx, id uint64) error {
+	_, err := tx.Exec(context.Background(), `
+		UPDATE nrtm_index_build SET last_object_id = LEAST(last_object_id, $1)`, int64(id)-1)
+	return err
+}
+
+// GetIndexProgress returns the progress of each optional index which has been started
+func (repo PostgresRepository) GetIndexProgress() ([]persist.IndexProgress, error) {
+	start := time.Now()
+	progress := []persist.IndexProgress{}
+	defer func() { repo.logSlow("GetIndexProgress", nil, start, len(progress)) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		var total int64
+		// A partitioned table's own estimate is zero, so its partitions are added up
+		if err := tx.QueryRow(context.Background(), `
+			SELECT COALESCE(SUM(GREATEST(c.reltuples, 0)), 0)::bigint
+			FROM pg_class c
+			WHERE c.oid = 'nrtm_rpslobject'::regclass
+				OR c.oid IN (SELECT inhrelid FROM pg_inherits WHERE inhparent = 'nrtm_rpslobject'::regclass)`,
+		).Scan(&total); err != nil {
+			return err
+		}
+		rows, err := tx.Query(context.Background(), `
+			SELECT name, objects, started, caught_up FROM nrtm_index_build ORDER BY name`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			ip := persist.IndexProgress{TotalObjects: total}
+			var started, caughtUp *time.Time
+			if err = rows.Scan(&ip.Name, &ip.Objects, &started, &caughtUp); err != nil {
+				return err
+			}
+			if started != nil {
+				ip.Started = *started
+			}
+			if caughtUp != nil {
+				ip.CaughtUp = *caughtUp
+			}
+			progress = append(progress, ip)
+		}
+		return rows.Err()
+	})
+	return progress, err
+}
+
+// IsIdle returns true if no other session is running a query or has a transaction open on the
+// database
+func (repo PostgresRepository) IsIdle() (bool, error) {
+	var busy int
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		return tx.QueryRow(context.Background(), `
+			SELECT COUNT(*) FROM pg_stat_activity
+			WHERE datname = current_database()
+				AND backend_type = 'client backend'
+				AND pid <> pg_backend_pid()
+				AND state <> 'idle'`,
+		).Scan(&busy)
+	})
+	return busy == 0, err
+}
@@ -0,0 +1,110 @@
+package pg
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// GetObjectChanges calls fn with each object added, modified and deleted from one version to
+// another, inclusive. They're ordered by version, with deletions before additions, and read
+// through a cursor a batch at a time.
+func (repo PostgresRepository) GetObjectChanges(source persist.NRTMSource, fromVersion, toVersion uint32, fn func(persist.ObjectChange) error) error {
+	count := 0
+	start := time.Now()
+	defer func() { repo.logSlow("GetObjectChanges", &source, start, count) }()
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		args := []any{source.ID, fromVersion, toVersion}
+		return queryCursor(tx, "object_changes", objectChangesSQL, args, func(rows pgx.Rows) error {
+			var oc persist.ObjectChange
+			if err := rows.Scan(&oc.Version, &oc.Deleted, &oc.ObjectType, &oc.PrimaryKey, &oc.RPSL); err != nil {
+				return err
+			}
+			count++
+			return fn(oc)
+		})
+	})
+}
+
+// objectChangesSQL An object replaced at a version has its to_version set to the version of its
+// replacement, so it only counts as deleted if there's no replacement. An object added and
+// deleted by the same delta was never there at any version, so it isn't a change, and each key
+// has at most one change in a version. Objects restored by undelete aren't changes the server
+// made, so they're left out.
+var objectChangesSQL = `
+	SELECT r.from_version, false, r.object_type, r.primary_key, COALESCE(r.rpsl, nrtm_rpsl(r.id))
+	FROM nrtm_rpslobject r
+	WHERE r.nrtm_source_id = $1
+		AND r.from_version BETWEEN $2 AND $3
+		AND r.from_version <> r.to_version
+		AND r.restored IS NULL
+	UNION ALL
+	SELECT r.to_version, true, r.object_type, r.primary_key, COALESCE(r.rpsl, nrtm_rpsl(r.id))
+	FROM nrtm_rpslobject r
+	WHERE r.nrtm_source_id = $1
+		AND r.to_version BETWEEN $2 AND $3
+		AND r.from_version <> r.to_version
+		AND NOT EXISTS (
+			SELECT 1 FROM nrtm_rpslobject nxt
+			WHERE nxt.nrtm_source_id = r.nrtm_source_id
+				AND nxt.object_type = r.object_type
+				AND nxt.primary_key = r.primary_key
+				AND nxt.from_version = r.to_version
+				AND nxt.restored IS NULL
+		)
+	ORDER BY 1, 2 DESC, 3, 4`
+
+// GetObjectHistory returns every version of the objects with a primary key, oldest first. The key
+// of a route is its prefix and origin, so a prefix on its own finds all the routes for it.
+func (repo PostgresRepository) GetObjectHistory(source persist.NRTMSource, primaryKey string) ([]persist.ObjectVersion, error) {
+	history := []persist.ObjectVersion{}
+	start := time.Now()
+	defer func() { repo.logSlow("GetObjectHistory", &source, start, len(history)) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		rows, err := tx.Query(context.Background(), objectHistorySQL, source.ID, strings.ToUpper(primaryKey))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var ov persist.ObjectVersion
+			var fromTime, toTime, restored *time.Time
+			if err = rows.Scan(&ov.ObjectType, &ov.PrimaryKey, &ov.FromVersion, &ov.ToVersion, &fromTime, &toTime, &ov.RPSL, &restored); err != nil {
+				return err
+			}
+			if restored != nil {
+				ov.Restored = *restored
+			}
+			if fromTime != nil {
+				ov.FromTime = *fromTime
+			}
+			if toTime != nil {
+				ov.ToTime = *toTime
+			}
+			history = append(history, ov)
+		}
+		return rows.Err()
+	})
+	return history, err
+}
+
+// objectHistorySQL The applied times come from the snapshot and delta files saved for a version
+var objectHistorySQL = `
+	SELECT r.object_type, r.primary_key, r.from_version, r.to_version,
+		(SELECT MIN(f.created) FROM nrtm_file f
+			WHERE f.nrtm_source_id = r.nrtm_source_id AND f.version = r.from_version AND f.type <> 'notification'),
+		(SELECT MIN(f.created) FROM nrtm_file f
+			WHERE f.nrtm_source_id = r.nrtm_source_id AND f.version = r.to_version AND f.type <> 'notification'),
+		COALESCE(r.rpsl, nrtm_rpsl(r.id)),
+		r.restored
+	FROM nrtm_rpslobject r
+	WHERE r.nrtm_source_id = $1
+		AND (
+			r.primary_key = $2
+			OR (r.object_type IN ('ROUTE', 'ROUTE6') AND r.primary_key LIKE $2 || 'AS%')
+		)
+	ORDER BY r.object_type, r.from_version, r.primary_key`
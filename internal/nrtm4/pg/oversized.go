@@ -0,0 +1,55 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// SaveOversizedObject quarantines a record which was too large to apply. Quarantining the same
+// record again replaces it.
+func (repo PostgresRepository) SaveOversizedObject(source persist.NRTMSource, obj persist.OversizedObject) error {
+	start := time.Now()
+	defer func() { repo.logSlow("SaveOversizedObject", &source, start, 1) }()
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `
+			INSERT INTO nrtm_oversized_object (nrtm_source_id, version, object_type, primary_key, size, record_start, quarantined)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (nrtm_source_id, version, object_type, primary_key)
+			DO UPDATE SET size = EXCLUDED.size, record_start = EXCLUDED.record_start, quarantined = EXCLUDED.quarantined`,
+			source.ID, obj.Version, obj.ObjectType, obj.PrimaryKey, obj.Size, obj.RecordStart, obj.Quarantined,
+		)
+		return err
+	})
+}
+
+// GetOversizedObjects lists the records quarantined for a source, the most recent first
+func (repo PostgresRepository) GetOversizedObjects(source persist.NRTMSource) ([]persist.OversizedObject, error) {
+	start := time.Now()
+	objs := []persist.OversizedObject{}
+	defer func() { repo.logSlow("GetOversizedObjects", &source, start, len(objs)) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		rows, err := tx.Query(context.Background(), `
+			SELECT version, object_type, primary_key, size, record_start, quarantined
+			FROM nrtm_oversized_object
+			WHERE nrtm_source_id = $1
+			ORDER BY version DESC, object_type, primary_key`, source.ID,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var obj persist.OversizedObject
+			if err = rows.Scan(&obj.Version, &obj.ObjectType, &obj.PrimaryKey, &obj.Size, &obj.RecordStart, &obj.Quarantined); err != nil {
+				return err
+			}
+			objs = append(objs, obj)
+		}
+		return rows.Err()
+	})
+	return objs, err
+}
@@ -0,0 +1,59 @@
+package pg
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// GetOwnerCounts counts the current objects which reference each value of the given attributes,
+// e.g. mnt-by, and the changes since a point in time to objects which reference them. Changes
+// are counted the same way as GetChangeSummary. Attribute names are used in a regular
+// expression, so the caller must check they're only letters, digits and hyphens.
+func (repo PostgresRepository) GetOwnerCounts(source persist.NRTMSource, attributes []string, since time.Time) (persist.OwnerCounts, error) {
+	counts := persist.OwnerCounts{ToVersion: source.Version, Owners: []persist.OwnerCount{}}
+	start := time.Now()
+	defer func() { repo.logSlow("GetOwnerCounts", &source, start, len(counts.Owners)) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		baseline, err := versionAt(tx, source, since)
+		if err != nil {
+			return err
+		}
+		counts.FromVersion = baseline
+		rows, err := tx.Query(context.Background(), ownerCountsSQL, source.ID, baseline, strings.Join(attributes, "|"))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var oc persist.OwnerCount
+			if err = rows.Scan(&oc.Attribute, &oc.Owner, &oc.Objects, &oc.Changes); err != nil {
+				return err
+			}
+			counts.Owners = append(counts.Owners, oc)
+		}
+		return rows.Err()
+	})
+	return counts, err
+}
+
+var ownerCountsSQL = `
+	SELECT LOWER(m[1]) AS attribute, UPPER(m[2]) AS owner,
+		COUNT(DISTINCT r.id) FILTER (WHERE r.to_version = 0) AS objects,
+		COUNT(DISTINCT r.id) FILTER (WHERE (r.from_version > $2 AND r.restored IS NULL) OR (r.to_version > $2 AND nxt.id IS NULL)) AS changes
+	FROM nrtm_rpslobject r
+	LEFT JOIN nrtm_rpslobject nxt
+		ON nxt.nrtm_source_id = r.nrtm_source_id
+		AND nxt.object_type = r.object_type
+		AND nxt.primary_key = r.primary_key
+		AND nxt.from_version = r.to_version
+		AND nxt.restored IS NULL,
+		REGEXP_MATCHES(COALESCE(r.rpsl, nrtm_rpsl(r.id)), '^(' || $3 || '):\s*([^\s#]+)', 'gni') AS m
+	WHERE r.nrtm_source_id = $1
+		AND (r.to_version = 0 OR r.from_version > $2 OR r.to_version > $2)
+	GROUP BY attribute, owner
+	ORDER BY attribute, objects DESC, changes DESC, owner`
@@ -0,0 +1,162 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// ErrInvalidPartitionCount objects can be split into two or more partitions
+var ErrInvalidPartitionCount = errors.New("number of partitions must be at least 2")
+
+// PartitionObjects rebuilds the objects table so it's partitioned by a hash of the primary
+// key. Lookups filter on primary_key, so PostgreSQL only has to visit one partition for them.
+// The table is locked while the objects are copied, which can take a long time.
+func (repo PostgresRepository) PartitionObjects(partitions int) error {
+	start := time.Now()
+	defer func() { repo.logSlow("PartitionObjects", nil, start, unknownRows) }()
+	if partitions < 2 {
+		return ErrInvalidPartitionCount
+	}
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		var current int
+		if err := tx.QueryRow(context.Background(), `
+			SELECT COUNT(*) FROM pg_inherits WHERE inhparent = 'nrtm_rpslobject'::regclass`,
+		).Scan(&current); err != nil {
+			return err
+		}
+		if current == partitions {
+			logger.Info("Objects are already partitioned", "partitions", partitions)
+			return nil
+		}
+		ddl, err := readObjectTableDDL(tx)
+		if err != nil {
+			return err
+		}
+		for _, sql := range partitionObjectsSQL(partitions, ddl) {
+			if _, err := tx.Exec(context.Background(), sql); err != nil {
+				logger.Error("Failed to partition objects", "sql", sql, "error", err)
+				return err
+			}
+		}
+		logger.Info("Partitioned objects", "from", current, "to", partitions)
+		return nil
+	})
+}
+
+// objectTableDDL is what has to be rebuilt when the objects table is replaced. It's read from
+// the catalog, so it's whatever the migrations have made it rather than a copy which can drift.
+type objectTableDDL struct {
+	// constraints are each "name definition"
+	constraints []string
+	// indexes are the CREATE INDEX statements of indexes which aren't a constraint's
+	indexes []string
+	// views are the views which select from the table, and their queries
+	views []viewDefinition
+}
+
+type viewDefinition struct {
+	name  string
+	query string
+}
+
+func readObjectTableDDL(tx pgx.Tx) (objectTableDDL, error) {
+	var ddl objectTableDDL
+	constraints, err := queryTextRows(tx, `
+		SELECT conname, contype::text, pg_get_constraintdef(oid)
+		FROM pg_constraint
+		WHERE conrelid = 'nrtm_rpslobject'::regclass
+		ORDER BY contype, conname`)
+	if err != nil {
+		return ddl, err
+	}
+	for _, row := range constraints {
+		def := row[2]
+		// The partition key has to be in a partitioned table's primary key
+		if row[1] == "p" && !strings.Contains(def, "primary_key") {
+			def = strings.TrimSuffix(def, ")") + ", primary_key)"
+		}
+		ddl.constraints = append(ddl.constraints, row[0]+" "+def)
+	}
+	indexes, err := queryTextRows(tx, `
+		SELECT pg_get_indexdef(i.indexrelid)
+		FROM pg_index i
+		WHERE i.indrelid = 'nrtm_rpslobject'::regclass
+			AND NOT EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conindid = i.indexrelid)
+		ORDER BY i.indexrelid`)
+	if err != nil {
+		return ddl, err
+	}
+	for _, row := range indexes {
+		ddl.indexes = append(ddl.indexes, row[0])
+	}
+	views, err := queryTextRows(tx, `
+		SELECT v.oid::regclass::text, pg_get_viewdef(v.oid)
+		FROM pg_class v
+		WHERE v.relkind = 'v' AND EXISTS (
+			SELECT 1 FROM pg_rewrite rw
+			JOIN pg_depend d ON d.classid = 'pg_rewrite'::regclass AND d.objid = rw.oid
+			WHERE rw.ev_class = v.oid AND d.refobjid = 'nrtm_rpslobject'::regclass)
+		ORDER BY v.oid`)
+	for _, row := range views {
+		ddl.views = append(ddl.views, viewDefinition{name: row[0], query: row[1]})
+	}
+	return ddl, err
+}
+
+// queryTextRows reads every row of a query whose columns are all text
+func queryTextRows(tx pgx.Tx, sql string) ([][]string, error) {
+	rows, err := tx.Query(context.Background(), sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := [][]string{}
+	for rows.Next() {
+		row := make([]string, len(rows.FieldDescriptions()))
+		dest := make([]any, len(row))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// partitionObjectsSQL creates a partitioned copy of the objects table and swaps it in. The
+// partition names include the number of partitions so they don't clash with the ones in the
+// table being replaced. The views on the table are pointed at the copy with CREATE OR REPLACE,
+// which keeps their grants, before the old table is dropped. Constraints and indexes are
+// added once it has gone, since their names have to be unique.
+func partitionObjectsSQL(partitions int, ddl objectTableDDL) []string {
+	sqls := []string{`
+		CREATE TABLE nrtm_rpslobject_new (LIKE nrtm_rpslobject INCLUDING DEFAULTS)
+		PARTITION BY HASH (primary_key)`,
+	}
+	for i := range partitions {
+		sqls = append(sqls, fmt.Sprintf(`
+		CREATE TABLE nrtm_rpslobject_%d_%d PARTITION OF nrtm_rpslobject_new
+		FOR VALUES WITH (MODULUS %d, REMAINDER %d)`, partitions, i, partitions, i))
+	}
+	sqls = append(sqls, `
+		INSERT INTO nrtm_rpslobject_new SELECT * FROM nrtm_rpslobject`, `
+		ALTER TABLE nrtm_rpslobject RENAME TO nrtm_rpslobject_old`, `
+		ALTER TABLE nrtm_rpslobject_new RENAME TO nrtm_rpslobject`,
+	)
+	for _, view := range ddl.views {
+		sqls = append(sqls, fmt.Sprintf("CREATE OR REPLACE VIEW %v AS %v", view.name, view.query))
+	}
+	sqls = append(sqls, "DROP TABLE nrtm_rpslobject_old")
+	for _, constraint := range ddl.constraints {
+		sqls = append(sqls, "ALTER TABLE nrtm_rpslobject ADD CONSTRAINT "+constraint)
+	}
+	return append(sqls, ddl.indexes...)
+}
@@ -0,0 +1,75 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// SavePendingDeltas replaces a source's queue of deltas waiting to be applied. An empty list
+// clears it.
+func (repo PostgresRepository) SavePendingDeltas(source persist.NRTMSource, refs []protocol.FileRefJSON) error {
+	start := time.Now()
+	defer func() { repo.logSlow("SavePendingDeltas", &source, start, len(refs)) }()
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		if _, err := tx.Exec(context.Background(), `
+			DELETE FROM nrtm_pending_delta WHERE nrtm_source_id = $1`, source.ID,
+		); err != nil {
+			return err
+		}
+		if len(refs) == 0 {
+			return nil
+		}
+		versions := make([]int64, len(refs))
+		urls := make([]string, len(refs))
+		hashes := make([]string, len(refs))
+		expires := make([]string, len(refs))
+		for i, ref := range refs {
+			versions[i] = int64(ref.Version)
+			urls[i] = ref.URL
+			hashes[i] = ref.Hash
+			expires[i] = ref.Expires
+		}
+		_, err := tx.Exec(context.Background(), `
+			INSERT INTO nrtm_pending_delta (nrtm_source_id, version, url, hash, expires, queued)
+			SELECT $1, v, u, h, e, $6
+			FROM UNNEST($2::integer[], $3::text[], $4::text[], $5::text[]) AS q(v, u, h, e)`,
+			source.ID, versions, urls, hashes, expires, util.AppClock.Now(),
+		)
+		return err
+	})
+}
+
+// GetPendingDeltas lists the deltas queued for a source which are after its version, lowest
+// version first
+func (repo PostgresRepository) GetPendingDeltas(source persist.NRTMSource) ([]protocol.FileRefJSON, error) {
+	start := time.Now()
+	refs := []protocol.FileRefJSON{}
+	defer func() { repo.logSlow("GetPendingDeltas", &source, start, len(refs)) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		rows, err := tx.Query(context.Background(), `
+			SELECT version, url, hash, expires
+			FROM nrtm_pending_delta
+			WHERE nrtm_source_id = $1 AND version > $2
+			ORDER BY version`, source.ID, source.Version,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var ref protocol.FileRefJSON
+			if err = rows.Scan(&ref.Version, &ref.URL, &ref.Hash, &ref.Expires); err != nil {
+				return err
+			}
+			refs = append(refs, ref)
+		}
+		return rows.Err()
+	})
+	return refs, err
+}
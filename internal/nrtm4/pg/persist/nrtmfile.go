@@ -10,9 +10,14 @@ import (
 type NRTMFile struct {
 	db.EntityManaged `em:"nrtm_file nf"`
 	ID               uint64    `em:"."`
+	ApplyMs          int64     `em:"."`
 	Created          time.Time `em:"."`
 	FileName         string    `em:"."`
+	Hash             string    `em:"."`
+	Header           []byte    `em:"."`
 	NRTMSourceID     uint64    `em:"."`
+	RunID            uint64    `em:"."`
+	Size             int64     `em:"."`
 	Type             string    `em:"."`
 	URL              string    `em:"."`
 	Version          uint32    `em:"."`
@@ -7,23 +7,24 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
 	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
 	"github.com/petchells/nrtm4client/internal/nrtm4/util"
 )
 
 // Notification is a binding to a PG database table
 type Notification struct {
 	db.EntityManaged `em:"nrtm_notification nnot"`
-	ID               uint64                   `em:"."`
-	Version          uint32                   `em:"."`
-	NRTMSourceID     uint64                   `em:"."`
-	Payload          persist.NotificationJSON `em:"."`
-	Created          time.Time                `em:"."`
+	ID               uint64                    `em:"."`
+	Version          uint32                    `em:"."`
+	NRTMSourceID     uint64                    `em:"."`
+	Payload          protocol.NotificationJSON `em:"."`
+	Created          time.Time                 `em:"."`
+	Raw              []byte                    `em:"."`
 }
 
 // NewNotification saves a notification in the database
-func NewNotification(tx pgx.Tx, sourceID uint64, payload persist.NotificationJSON) error {
+func NewNotification(tx pgx.Tx, sourceID uint64, payload protocol.NotificationJSON) error {
 	lastN := new(Notification)
 	descr := db.GetDescriptor(lastN)
 	sql := fmt.Sprintf(`
@@ -44,6 +45,7 @@ func NewNotification(tx pgx.Tx, sourceID uint64, payload persist.NotificationJSO
 			NRTMSourceID: sourceID,
 			Payload:      payload,
 			Created:      util.AppClock.Now(),
+			Raw:          payload.Raw,
 		})
 	}
 
@@ -10,14 +10,24 @@ import (
 
 // NRTMSource pg database mapping for nrtm_source
 type NRTMSource struct {
-	db.EntityManaged `em:"nrtm_source src"`
-	ID               uint64    `em:"."`
-	Source           string    `em:"."`
-	SessionID        string    `em:"."`
-	Version          uint32    `em:"."`
-	NotificationURL  string    `em:"."`
-	Label            string    `em:"."`
-	Created          time.Time `em:"."`
+	db.EntityManaged    `em:"nrtm_source src"`
+	ID                  uint64     `em:"."`
+	Source              string     `em:"."`
+	SessionID           string     `em:"."`
+	Version             uint32     `em:"."`
+	NotificationURL     string     `em:"."`
+	Label               string     `em:"."`
+	Created             time.Time  `em:"."`
+	Paused              bool       `em:"."`
+	QuarantineFailures  int        `em:"."`
+	QuarantineReason    string     `em:"."`
+	QuarantinedUntil    *time.Time `em:"."`
+	TermsURL            string     `em:"."`
+	Superseded          *time.Time `em:"."`
+	NotificationHash    string     `em:"."`
+	NotificationEtag    string     `em:"."`
+	NotificationChecked *time.Time `em:"."`
+	SampleRate          float64    `em:"."`
 }
 
 // NewNRTMSource is a shorthand function which prepares a source object for storage
@@ -31,13 +41,15 @@ func NewNRTMSource(source persist.NRTMSource) NRTMSource {
 		NotificationURL: source.NotificationURL,
 		Label:           source.Label,
 		Created:         util.AppClock.Now(),
+		TermsURL:        source.TermsURL,
+		SampleRate:      source.SampleRate,
 	}
 	return sourceObj
 }
 
 // FromNRTMSource is a shorthand function which transforms a Pg source to a generic persist source
 func FromNRTMSource(source persist.NRTMSource) NRTMSource {
-	return NRTMSource{
+	pgSource := NRTMSource{
 		ID:              source.ID,
 		Source:          source.Source,
 		SessionID:       source.SessionID,
@@ -45,12 +57,27 @@ func FromNRTMSource(source persist.NRTMSource) NRTMSource {
 		NotificationURL: source.NotificationURL,
 		Label:           source.Label,
 		Created:         source.Created,
+		Paused:          source.Paused,
+		TermsURL:        source.TermsURL,
+		Superseded:      source.Superseded,
+		SampleRate:      source.SampleRate,
 	}
+	if fp := source.LastNotification; fp != nil {
+		pgSource.NotificationHash = fp.Hash
+		pgSource.NotificationEtag = fp.ETag
+		pgSource.NotificationChecked = &fp.Checked
+	}
+	if q := source.Quarantine; q != nil {
+		pgSource.QuarantineFailures = q.Failures
+		pgSource.QuarantineReason = q.Reason
+		pgSource.QuarantinedUntil = &q.Until
+	}
+	return pgSource
 }
 
 // AsNRTMSource return this row as a app-level source
 func (s *NRTMSource) AsNRTMSource() persist.NRTMSource {
-	return persist.NRTMSource{
+	source := persist.NRTMSource{
 		ID:              s.ID,
 		Source:          s.Source,
 		SessionID:       s.SessionID,
@@ -58,5 +85,24 @@ func (s *NRTMSource) AsNRTMSource() persist.NRTMSource {
 		NotificationURL: s.NotificationURL,
 		Label:           s.Label,
 		Created:         s.Created,
+		Paused:          s.Paused,
+		TermsURL:        s.TermsURL,
+		Superseded:      s.Superseded,
+		SampleRate:      s.SampleRate,
+	}
+	if len(s.NotificationHash) > 0 && s.NotificationChecked != nil {
+		source.LastNotification = &persist.NotificationFingerprint{
+			Hash:    s.NotificationHash,
+			ETag:    s.NotificationEtag,
+			Checked: *s.NotificationChecked,
+		}
+	}
+	if s.QuarantineFailures > 0 && s.QuarantinedUntil != nil {
+		source.Quarantine = &persist.Quarantine{
+			Failures: s.QuarantineFailures,
+			Reason:   s.QuarantineReason,
+			Until:    *s.QuarantinedUntil,
+		}
 	}
+	return source
 }
@@ -11,7 +11,7 @@ func TestNRTMSourceMapping(t *testing.T) {
 }
 
 func TestColumnNameConversionFromFieldTags(t *testing.T) {
-	expected := [...]string{"id", "source", "session_id", "version", "notification_url", "label", "created"}
+	expected := [...]string{"id", "source", "session_id", "version", "notification_url", "label", "created", "paused", "quarantine_failures", "quarantine_reason", "quarantined_until", "terms_url", "superseded", "notification_hash", "notification_etag", "notification_checked", "sample_rate"}
 	o := NRTMSource{}
 	dtor := db.GetDescriptor(&o)
 	names := dtor.ColumnNames()
@@ -3,4 +3,4 @@ package persist
 
 import "github.com/petchells/nrtm4client/internal/nrtm4/util"
 
-var logger = util.Logger
+var logger = util.ModuleLogger("pg")
@@ -6,4 +6,4 @@ package pg
 
 import "github.com/petchells/nrtm4client/internal/nrtm4/util"
 
-var logger = util.Logger
+var logger = util.ModuleLogger("pg")
@@ -2,28 +2,55 @@ package pg
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
 	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
 	pgpersist "github.com/petchells/nrtm4client/internal/nrtm4/pg/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/retry"
 	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
 	"github.com/petchells/nrtm4client/internal/nrtm4/util"
 )
 
 // PostgresRepository implementation of the Repository interface
 type PostgresRepository struct {
+	// SnapshotWriters is the number of connections a batch of snapshot objects is split
+	// across. Zero or one writes each batch on a single connection.
+	SnapshotWriters int
+	// SlowQueryThreshold is how long a call can take before it's logged as slow. Zero turns
+	// logging off.
+	SlowQueryThreshold time.Duration
+	// Hooks are called in the same transaction as each change to the objects table
+	Hooks []ObjectHook
+	// Password is called for the password of each new connection, when it isn't in the URL
+	Password db.PasswordFunc
+	// Retry retries connecting to the database. Nil tries once.
+	Retry *retry.Retrier
+	// StatementTimeout is how long the database lets each statement of a read run which a web
+	// API client asked for. Zero is no limit.
+	StatementTimeout time.Duration
 }
 
 // Initialize implementation of the Repository interface
 func (repo PostgresRepository) Initialize(dbURL string) error {
-	return db.InitializeConnectionPool(dbURL)
+	return db.InitializeConnectionPool(dbURL, repo.Password, repo.Retry)
+}
+
+// Ping checks the database can be connected to
+func (repo PostgresRepository) Ping() error {
+	return db.Ping()
 }
 
 // GetSources returns a list of all sources
 func (repo PostgresRepository) GetSources() ([]persist.NRTMSource, error) {
 	var sources []persist.NRTMSource
+	start := time.Now()
+	defer func() { repo.logSlow("GetSources", nil, start, len(sources)) }()
 	var err error
 	var pgsources []pgpersist.NRTMSource
 	src := new(pgpersist.NRTMSource)
@@ -41,39 +68,173 @@ func (repo PostgresRepository) GetSources() ([]persist.NRTMSource, error) {
 	return sources, nil
 }
 
-// RemoveSource removes a source from the repo
-func (repo PostgresRepository) RemoveSource(source persist.NRTMSource) error {
+// RemoveSource removes a source from the repo, and returns the number of rows deleted from each
+// table
+func (repo PostgresRepository) RemoveSource(source persist.NRTMSource) (persist.RemovedRows, error) {
+	var removed persist.RemovedRows
+	start := time.Now()
+	defer func() {
+		repo.logSlow("RemoveSource", &source, start, int(removed.Objects+removed.Notifications+removed.Files))
+	}()
 	err := db.WithTransaction(func(tx pgx.Tx) error {
-		sqls := []string{`
+		sqls := []struct {
+			sql   string
+			count *int64
+		}{{`
+			DELETE FROM
+				nrtm_fulltext_index
+			WHERE nrtm_source_id = $1
+			`, nil}, {`
+			DELETE FROM
+				nrtm_prefix_index
+			WHERE nrtm_source_id = $1
+			`, nil}, {`
+			DELETE FROM
+				nrtm_attribute_index
+			WHERE nrtm_source_id = $1
+			`, nil}, {`
+			DELETE FROM
+				nrtm_pending_delta
+			WHERE nrtm_source_id = $1
+			`, nil}, {`
+			DELETE FROM
+				nrtm_snapshot_ref
+			WHERE nrtm_source_id = $1
+			`, nil}, {`
+			DELETE FROM
+				nrtm_oversized_object
+			WHERE nrtm_source_id = $1
+			`, nil}, {`
+			DELETE FROM
+				nrtm_sync_run
+			WHERE nrtm_source_id = $1
+			`, nil}, {`
+			DELETE FROM
+				nrtm_session_history h
+			USING nrtm_source s
+			WHERE s.id = $1
+				AND h.source = s.source
+				AND h.notification_url = s.notification_url
+				AND NOT EXISTS (
+					SELECT 1 FROM nrtm_source o
+					WHERE o.source = s.source
+						AND o.notification_url = s.notification_url
+						AND o.id <> s.id
+				)
+			`, nil}, {`
 			DELETE FROM
 				nrtm_rpslobject
 			WHERE nrtm_source_id = $1
-			`, `
+			`, &removed.Objects}, {`
 			DELETE FROM
 				nrtm_notification
 			WHERE nrtm_source_id = $1
-			`, `
+			`, &removed.Notifications}, {`
 			DELETE FROM
 				nrtm_file
 			WHERE nrtm_source_id = $1
-			`, `
+			`, &removed.Files}, {`
 			DELETE FROM
 				nrtm_source
 			WHERE id = $1
-			`}
-		for _, sql := range sqls {
-			_, err := tx.Exec(context.Background(), sql, source.ID)
+			`, nil}}
+		for _, stmt := range sqls {
+			tag, err := tx.Exec(context.Background(), stmt.sql, source.ID)
 			if err != nil {
 				return err
 			}
+			if stmt.count != nil {
+				*stmt.count = tag.RowsAffected()
+			}
 		}
 		return nil
 	})
 	if err != nil {
 		logger.Error("Error in RemoveSource", "error", err)
+		return persist.RemovedRows{}, err
+	}
+	return removed, nil
+}
+
+// PauseSource sets whether a source is paused
+func (repo PostgresRepository) PauseSource(source persist.NRTMSource, paused bool) error {
+	start := time.Now()
+	defer func() { repo.logSlow("PauseSource", &source, start, 1) }()
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `
+			UPDATE nrtm_source SET paused = $2 WHERE id = $1`, source.ID, paused)
+		return err
+	})
+}
+
+// SupersedeSource records when a source's session was replaced by a new one
+func (repo PostgresRepository) SupersedeSource(source persist.NRTMSource, at time.Time) error {
+	start := time.Now()
+	defer func() { repo.logSlow("SupersedeSource", &source, start, 1) }()
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `
+			UPDATE nrtm_source SET superseded = $2 WHERE id = $1`, source.ID, at)
 		return err
+	})
+}
+
+// SaveQuarantine quarantines a source, or releases it when q is nil
+func (repo PostgresRepository) SaveQuarantine(source persist.NRTMSource, q *persist.Quarantine) error {
+	start := time.Now()
+	defer func() { repo.logSlow("SaveQuarantine", &source, start, 1) }()
+	if q == nil {
+		q = &persist.Quarantine{}
 	}
-	return nil
+	var until *time.Time
+	if q.Failures > 0 {
+		until = &q.Until
+	}
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `
+			UPDATE nrtm_source
+			SET quarantine_failures = $2, quarantine_reason = $3, quarantined_until = $4
+			WHERE id = $1`, source.ID, q.Failures, q.Reason, until)
+		return err
+	})
+}
+
+// SaveNotificationFingerprint records the last notification file which was completely processed
+func (repo PostgresRepository) SaveNotificationFingerprint(source persist.NRTMSource, fp persist.NotificationFingerprint) error {
+	start := time.Now()
+	defer func() { repo.logSlow("SaveNotificationFingerprint", &source, start, 1) }()
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `
+			UPDATE nrtm_source
+			SET notification_hash = $2, notification_etag = $3, notification_checked = $4
+			WHERE id = $1`, source.ID, fp.Hash, fp.ETag, fp.Checked)
+		return err
+	})
+}
+
+// SaveTermsURL records the source's license or terms of use
+func (repo PostgresRepository) SaveTermsURL(source persist.NRTMSource, termsURL string) error {
+	start := time.Now()
+	defer func() { repo.logSlow("SaveTermsURL", &source, start, 1) }()
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `
+			UPDATE nrtm_source
+			SET terms_url = $2
+			WHERE id = $1`, source.ID, termsURL)
+		return err
+	})
+}
+
+// SaveLabel renames a source's label
+func (repo PostgresRepository) SaveLabel(source persist.NRTMSource, label string) error {
+	start := time.Now()
+	defer func() { repo.logSlow("SaveLabel", &source, start, 1) }()
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `
+			UPDATE nrtm_source
+			SET label = $2
+			WHERE id = $1`, source.ID, label)
+		return err
+	})
 }
 
 // GetNotificationHistory gets the last 100 notification versions
@@ -81,6 +242,9 @@ func (repo PostgresRepository) GetNotificationHistory(source persist.NRTMSource,
 	if toVersion < fromVersion {
 		return []persist.Notification{}, nil
 	}
+	start := time.Now()
+	notifs := make([]persist.Notification, 0, 100)
+	defer func() { repo.logSlow("GetNotificationHistory", &source, start, len(notifs)) }()
 	notif := new(pgpersist.Notification)
 	notifDesc := db.GetDescriptor(notif)
 	sql := fmt.Sprintf(`
@@ -95,8 +259,7 @@ func (repo PostgresRepository) GetNotificationHistory(source persist.NRTMSource,
 		notifDesc.ColumnNamesCommaSeparated(),
 		notifDesc.TableName(),
 	)
-	notifs := make([]persist.Notification, 0, 100)
-	err := db.WithTransaction(func(tx pgx.Tx) error {
+	err := repo.readTransaction(func(tx pgx.Tx) error {
 		rows, err := tx.Query(context.Background(), sql, source.ID, fromVersion, toVersion)
 		if err != nil {
 			return err
@@ -115,12 +278,19 @@ func (repo PostgresRepository) GetNotificationHistory(source persist.NRTMSource,
 }
 
 // SaveSource updates a source if ID is non-zero, or creates a new one if it is
-func (repo PostgresRepository) SaveSource(source persist.NRTMSource, notification persist.NotificationJSON) (persist.NRTMSource, error) {
+func (repo PostgresRepository) SaveSource(source persist.NRTMSource, notification protocol.NotificationJSON) (persist.NRTMSource, error) {
+	start := time.Now()
+	defer func() { repo.logSlow("SaveSource", &source, start, 1) }()
 	var pgSource pgpersist.NRTMSource
 	err := db.WithTransaction(func(tx pgx.Tx) error {
 		if source.ID == 0 {
 			pgSource = pgpersist.NewNRTMSource(source)
-			return db.Create(tx, &pgSource)
+			if err := db.Create(tx, &pgSource); err != nil {
+				return err
+			}
+			_, err := tx.Exec(context.Background(), `
+				UPDATE nrtm_source SET baseline = version WHERE id = $1`, pgSource.ID)
+			return err
 		}
 		pgSource = pgpersist.FromNRTMSource(source)
 		err := db.Update(tx, &pgSource)
@@ -139,6 +309,8 @@ func (repo PostgresRepository) Close() error {
 
 // SaveFile saves a reference to an NRTM file
 func (repo PostgresRepository) SaveFile(nrtmFile *persist.NRTMFile) error {
+	start := time.Now()
+	defer func() { repo.logSlow("SaveFile", nil, start, 1) }()
 	return db.WithTransaction(func(tx pgx.Tx) error {
 		st := pgpersist.NRTMFile{
 			ID:           uint64(db.NextID()),
@@ -147,6 +319,11 @@ func (repo PostgresRepository) SaveFile(nrtmFile *persist.NRTMFile) error {
 			Type:         nrtmFile.Type.String(),
 			NRTMSourceID: nrtmFile.NrtmSourceID,
 			FileName:     nrtmFile.FileName,
+			Hash:         nrtmFile.Hash,
+			Header:       nrtmFile.Header,
+			Size:         nrtmFile.Size,
+			ApplyMs:      nrtmFile.ApplyTime.Milliseconds(),
+			RunID:        nrtmFile.RunID,
 			Created:      util.AppClock.Now(),
 		}
 		nrtmFile.ID = st.ID
@@ -154,15 +331,104 @@ func (repo PostgresRepository) SaveFile(nrtmFile *persist.NRTMFile) error {
 	})
 }
 
-// SaveSnapshotObjects saves a list of rpsl object in a go routine
+// GetFileByHash finds the most recent file saved for a source with the given hash. It returns
+// nil if there isn't one.
+func (repo PostgresRepository) GetFileByHash(source persist.NRTMSource, hash string) (*persist.NRTMFile, error) {
+	start := time.Now()
+	defer func() { repo.logSlow("GetFileByHash", &source, start, 1) }()
+	file := new(pgpersist.NRTMFile)
+	fileDesc := db.GetDescriptor(file)
+	sql := fmt.Sprintf(`
+		SELECT %v
+		FROM %v
+		WHERE nrtm_source_id = $1
+		AND hash = $2
+		ORDER BY created DESC
+		LIMIT 1
+		`,
+		fileDesc.ColumnNamesCommaSeparated(),
+		fileDesc.TableName(),
+	)
+	var found *persist.NRTMFile
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		err := tx.QueryRow(context.Background(), sql, source.ID, hash).Scan(db.SelectValues(file)...)
+		if err == pgx.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fileType, err := persist.ToFileType(file.Type)
+		if err != nil {
+			return err
+		}
+		found = &persist.NRTMFile{
+			ID:           file.ID,
+			Version:      file.Version,
+			Type:         fileType,
+			URL:          file.URL,
+			FileName:     file.FileName,
+			Hash:         file.Hash,
+			Header:       file.Header,
+			Size:         file.Size,
+			ApplyTime:    time.Duration(file.ApplyMs) * time.Millisecond,
+			NrtmSourceID: file.NRTMSourceID,
+			RunID:        file.RunID,
+			Created:      file.Created,
+		}
+		return nil
+	})
+	return found, err
+}
+
+// SaveSnapshotObjects saves a list of rpsl objects. The list is split into shards which are
+// written concurrently, each in its own transaction, when SnapshotWriters is more than one.
 func (repo PostgresRepository) SaveSnapshotObjects(
 	source persist.NRTMSource,
 	rpslObjects []rpsl.Rpsl,
-	file persist.NrtmFileJSON,
+	file protocol.NrtmFileJSON,
 ) error {
 	if len(rpslObjects) == 0 {
 		return nil
 	}
+	start := time.Now()
+	defer func() { repo.logSlow("SaveSnapshotObjects", &source, start, len(rpslObjects)) }()
+	shards := shardObjects(rpslObjects, repo.SnapshotWriters)
+	if len(shards) == 1 {
+		return repo.copySnapshotObjects(source, shards[0], file)
+	}
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = repo.copySnapshotObjects(source, shard, file)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// shardObjects splits objects into at most n slices of roughly equal length
+func shardObjects(objects []rpsl.Rpsl, n int) [][]rpsl.Rpsl {
+	if n < 1 {
+		n = 1
+	}
+	n = min(n, len(objects))
+	shards := make([][]rpsl.Rpsl, 0, n)
+	size := (len(objects) + n - 1) / n
+	for start := 0; start < len(objects); start += size {
+		end := min(start+size, len(objects))
+		shards = append(shards, objects[start:end])
+	}
+	return shards
+}
+
+func (repo PostgresRepository) copySnapshotObjects(
+	source persist.NRTMSource,
+	rpslObjects []rpsl.Rpsl,
+	file protocol.NrtmFileJSON,
+) error {
 	return db.WithTransaction(func(tx pgx.Tx) error {
 		inputRows := make([][]any, len(rpslObjects))
 		for i, rpslObject := range rpslObjects {
@@ -192,7 +458,9 @@ func (repo PostgresRepository) SaveSnapshotObjects(
 			logger.Warn("Failed to save objects", "types", types.String(), "error", err)
 			return err
 		}
-		return nil
+		return repo.runHooks(func(hook ObjectHook) error {
+			return hook.SnapshotObjectsSaved(tx, source, rpslObjects, file)
+		})
 	})
 }
 
@@ -200,61 +468,173 @@ func (repo PostgresRepository) SaveSnapshotObjects(
 func (repo PostgresRepository) AddModifyObject(
 	source persist.NRTMSource,
 	rpsl rpsl.Rpsl,
-	file persist.NrtmFileJSON,
+	file protocol.NrtmFileJSON,
 ) error {
+	start := time.Now()
+	defer func() { repo.logSlow("AddModifyObject", &source, start, 1) }()
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		_, err := repo.addModifyObject(tx, source, rpsl, file)
+		return err
+	})
+}
+
+// addModifyObject saves a new version of an object and returns the version it replaced, which
+// is nil when the object is new
+func (repo PostgresRepository) addModifyObject(tx pgx.Tx, source persist.NRTMSource, object rpsl.Rpsl, file protocol.NrtmFileJSON) (*rpsl.Rpsl, error) {
 	newRow := &pgpersist.RPSLObject{
-		ObjectType:   rpsl.ObjectType,
-		PrimaryKey:   rpsl.PrimaryKey,
+		ObjectType:   object.ObjectType,
+		PrimaryKey:   object.PrimaryKey,
 		NRTMSourceID: source.ID,
 		FromVersion:  file.Version,
-		RPSL:         rpsl.Payload,
+		RPSL:         object.Payload,
 	}
-	return db.WithTransaction(func(tx pgx.Tx) error {
+	var err error
 
-		var err error
+	curDelta := getPossibleCurrentDeltaFrom(tx, *newRow)
+	if curDelta != nil {
+		// Already processed an operation, just overwrite it
+		var previous *rpsl.Rpsl
+		if curDelta.ToVersion == 0 {
+			previous = rowImage(curDelta)
+		}
+		newRow.ID = curDelta.ID
+		if err = db.Update(tx, newRow); err != nil {
+			return nil, err
+		}
+		// The row holds a different object now, so it's indexed again
+		if err = dropIndexEntries(tx, newRow.ID); err != nil {
+			return nil, err
+		}
+		if err = rewindIndexBuilds(tx, newRow.ID); err != nil {
+			return nil, err
+		}
+		return previous, repo.objectChanged(tx, source, previous, &object, file)
+	}
 
-		curDelta := getPossibleCurrentDeltaFrom(tx, *newRow)
-		if curDelta != nil {
-			// Already processed an operation, just overwrite it
-			newRow.ID = curDelta.ID
-			return db.Update(tx, newRow)
+	sql := selectCurrentObjectQuery()
+	rpslObject := new(pgpersist.RPSLObject)
+	err = tx.QueryRow(context.Background(), sql, source.ID, object.PrimaryKey, object.ObjectType).Scan(db.SelectValues(rpslObject)...)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, err
+	}
+	var previous *rpsl.Rpsl
+	if err != pgx.ErrNoRows {
+		previous = rowImage(rpslObject)
+		rpslObject.ToVersion = file.Version
+		err = db.Update(tx, rpslObject)
+		if err != nil {
+			return nil, err
 		}
+		if err = dropIndexEntries(tx, rpslObject.ID); err != nil {
+			return nil, err
+		}
+	}
+	newRow.ID = db.NextID()
+	if err = db.Create(tx, newRow); err != nil {
+		return nil, err
+	}
+	return previous, repo.objectChanged(tx, source, previous, &object, file)
+}
 
-		sql := selectCurrentObjectQuery()
-		rpslObject := new(pgpersist.RPSLObject)
-		err = tx.QueryRow(context.Background(), sql, source.ID, rpsl.PrimaryKey, rpsl.ObjectType).Scan(db.SelectValues(rpslObject)...)
-		if err != nil && err != pgx.ErrNoRows {
-			return err
+// objectChanged runs the hooks for a change to an object, where a nil object is a delete
+func (repo PostgresRepository) objectChanged(tx pgx.Tx, source persist.NRTMSource, previous, object *rpsl.Rpsl, file protocol.NrtmFileJSON) error {
+	return repo.runHooks(func(hook ObjectHook) error {
+		var err error
+		if object != nil {
+			err = hook.ObjectAdded(tx, source, *object, file)
+		} else {
+			err = hook.ObjectDeleted(tx, source, previous.ObjectType, previous.PrimaryKey, file)
 		}
-		if err != pgx.ErrNoRows {
-			rpslObject.ToVersion = file.Version
-			err = db.Update(tx, rpslObject)
-			if err != nil {
-				return err
-			}
+		if imageHook, ok := hook.(ObjectImageHook); ok && err == nil {
+			err = imageHook.ObjectChanged(tx, source, previous, object, file)
 		}
-		newRow.ID = db.NextID()
-		return db.Create(tx, newRow)
+		return err
+	})
+}
+
+// objectAdded runs the hooks for an object a new session's snapshot added or replaced, which
+// doesn't have the version it replaced at hand
+func (repo PostgresRepository) objectAdded(tx pgx.Tx, source persist.NRTMSource, object rpsl.Rpsl, file protocol.NrtmFileJSON) error {
+	return repo.runHooks(func(hook ObjectHook) error {
+		return hook.ObjectAdded(tx, source, object, file)
 	})
 }
 
+// rowImage is the object a row holds. Its type and key are the ones it was saved with, since
+// parsing the text again would need the source's quirks.
+func rowImage(row *pgpersist.RPSLObject) *rpsl.Rpsl {
+	return &rpsl.Rpsl{ObjectType: row.ObjectType, PrimaryKey: row.PrimaryKey, Payload: row.RPSL}
+}
+
 // DeleteObject doesn't remove any rows, instead it sets `to_version` to the file version
 func (repo PostgresRepository) DeleteObject(
 	source persist.NRTMSource,
 	objectType string,
 	primaryKey string,
-	file persist.NrtmFileJSON,
+	file protocol.NrtmFileJSON,
 ) error {
+	start := time.Now()
+	defer func() { repo.logSlow("DeleteObject", &source, start, 1) }()
 	return db.WithTransaction(func(tx pgx.Tx) error {
-		sql := selectCurrentObjectQuery()
-		rpslObject := new(pgpersist.RPSLObject)
-		err := tx.QueryRow(context.Background(), sql, source.ID, primaryKey, objectType).Scan(db.SelectValues(rpslObject)...)
-		if err != nil {
-			return err
+		_, err := repo.deleteObject(tx, source, objectType, primaryKey, file)
+		return err
+	})
+}
+
+// deleteObject ends the current version of an object and returns it
+func (repo PostgresRepository) deleteObject(tx pgx.Tx, source persist.NRTMSource, objectType, primaryKey string, file protocol.NrtmFileJSON) (*rpsl.Rpsl, error) {
+	sql := selectCurrentObjectQuery()
+	rpslObject := new(pgpersist.RPSLObject)
+	err := tx.QueryRow(context.Background(), sql, source.ID, primaryKey, objectType).Scan(db.SelectValues(rpslObject)...)
+	if err != nil {
+		return nil, err
+	}
+	previous := rowImage(rpslObject)
+	rpslObject.ToVersion = file.Version
+	if err = db.Update(tx, rpslObject); err != nil {
+		return nil, err
+	}
+	if err = dropIndexEntries(tx, rpslObject.ID); err != nil {
+		return nil, err
+	}
+	return previous, repo.objectChanged(tx, source, previous, nil, file)
+}
+
+// ApplyDeltaChanges applies a group of a delta's changes in order, in one transaction, so hooks
+// see them all or none of them. Deletes of objects which aren't in the repo don't fail the
+// group, they're returned instead. The version of each object before its change is set in the
+// change's Previous.
+func (repo PostgresRepository) ApplyDeltaChanges(
+	source persist.NRTMSource,
+	changes []persist.DeltaChange,
+	file protocol.NrtmFileJSON,
+) ([]persist.DeltaChange, error) {
+	start := time.Now()
+	defer func() { repo.logSlow("ApplyDeltaChanges", &source, start, len(changes)) }()
+	var missing []persist.DeltaChange
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		missing = nil
+		for i, change := range changes {
+			var err error
+			if change.Action == persist.DeltaDeleteAction {
+				changes[i].Previous, err = repo.deleteObject(tx, source, change.Object.ObjectType, change.Object.PrimaryKey, file)
+				if err == pgx.ErrNoRows {
+					missing = append(missing, change)
+					continue
+				}
+			} else {
+				changes[i].Previous, err = repo.addModifyObject(tx, source, change.Object, file)
+			}
+			if err != nil {
+				return err
+			}
 		}
-		rpslObject.ToVersion = file.Version
-		return db.Update(tx, rpslObject)
+		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	return missing, nil
 }
 
 func selectCurrentObjectQuery() string {
@@ -297,6 +677,7 @@ func getPossibleCurrentDeltaFrom(tx pgx.Tx, curRPSL pgpersist.RPSLObject) *pgper
 }
 
 func asNotification(n pgpersist.Notification) persist.Notification {
+	n.Payload.Raw = n.Raw
 	return persist.Notification{
 		ID:           n.ID,
 		Version:      n.Version,
@@ -4,6 +4,8 @@ import (
 	"strings"
 	"testing"
 	"unicode"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
 )
 
 func TestSelectObjectSQL(t *testing.T) {
@@ -23,6 +25,63 @@ func TestSelectObjectSQL(t *testing.T) {
 
 }
 
+func TestShardObjects(t *testing.T) {
+	objects := make([]rpsl.Rpsl, 10)
+	tests := []struct {
+		writers  int
+		expected []int
+	}{
+		{0, []int{10}},
+		{1, []int{10}},
+		{3, []int{4, 4, 2}},
+		{5, []int{2, 2, 2, 2, 2}},
+		{20, []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		shards := shardObjects(objects, tt.writers)
+		if len(shards) != len(tt.expected) {
+			t.Error("Expected", len(tt.expected), "shards for", tt.writers, "writers but was", len(shards))
+			continue
+		}
+		for i, shard := range shards {
+			if len(shard) != tt.expected[i] {
+				t.Error("Expected shard", i, "to have", tt.expected[i], "objects but was", len(shard))
+			}
+		}
+	}
+}
+
+func TestPartitionObjectsSQL(t *testing.T) {
+	ddl := objectTableDDL{
+		constraints: []string{"rpslobject__pk PRIMARY KEY (id, primary_key)"},
+		indexes:     []string{"CREATE INDEX rpslobject__primary_key__idx ON public.nrtm_rpslobject USING btree (upper((primary_key)::text))"},
+		views:       []viewDefinition{{name: "current_objects", query: "SELECT r.rpsl FROM nrtm_rpslobject r;"}},
+	}
+	sqls := partitionObjectsSQL(3, ddl)
+
+	expected := []string{
+		"CREATE TABLE nrtm_rpslobject_new (LIKE nrtm_rpslobject INCLUDING DEFAULTS) PARTITION BY HASH (primary_key)",
+		"CREATE TABLE nrtm_rpslobject_3_0 PARTITION OF nrtm_rpslobject_new FOR VALUES WITH (MODULUS 3, REMAINDER 0)",
+		"CREATE TABLE nrtm_rpslobject_3_1 PARTITION OF nrtm_rpslobject_new FOR VALUES WITH (MODULUS 3, REMAINDER 1)",
+		"CREATE TABLE nrtm_rpslobject_3_2 PARTITION OF nrtm_rpslobject_new FOR VALUES WITH (MODULUS 3, REMAINDER 2)",
+		"INSERT INTO nrtm_rpslobject_new SELECT * FROM nrtm_rpslobject",
+		"ALTER TABLE nrtm_rpslobject RENAME TO nrtm_rpslobject_old",
+		"ALTER TABLE nrtm_rpslobject_new RENAME TO nrtm_rpslobject",
+		"CREATE OR REPLACE VIEW current_objects AS SELECT r.rpsl FROM nrtm_rpslobject r;",
+		"DROP TABLE nrtm_rpslobject_old",
+		"ALTER TABLE nrtm_rpslobject ADD CONSTRAINT rpslobject__pk PRIMARY KEY (id, primary_key)",
+		"CREATE INDEX rpslobject__primary_key__idx ON public.nrtm_rpslobject USING btree (upper((primary_key)::text))",
+	}
+	if len(sqls) != len(expected) {
+		t.Fatal("Expected", len(expected), "statements but was", len(sqls))
+	}
+	for i, exp := range expected {
+		if reduceWhiteSpace(sqls[i]) != exp {
+			t.Errorf("Got unexpected SQL\n%v\nbut wanted\n%v\n", reduceWhiteSpace(sqls[i]), exp)
+		}
+	}
+}
+
 func TestReduceWhiteSpace(t *testing.T) {
 	input := [...]string{
 		"How now     brown      cow",
@@ -0,0 +1,98 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// provenanceFileSQL finds the file which brought a source to a version. A version can be
+// recorded more than once, e.g. when a snapshot is loaded again, so the latest is used.
+const provenanceFileSQL = `
+	LEFT JOIN LATERAL (
+		SELECT f.type, f.url, f.hash, f.run_id, f.created
+		FROM nrtm_file f
+		WHERE f.nrtm_source_id = $1 AND f.version = v.version
+		ORDER BY f.created DESC
+		LIMIT 1
+	) f ON true`
+
+// GetProvenance returns the provenance of each of the versions, in the order given
+func (repo PostgresRepository) GetProvenance(source persist.NRTMSource, versions []uint32) ([]persist.Provenance, error) {
+	provenance := []persist.Provenance{}
+	start := time.Now()
+	defer func() { repo.logSlow("GetProvenance", &source, start, len(provenance)) }()
+	vs := make([]int64, len(versions))
+	for i, v := range versions {
+		vs[i] = int64(v)
+	}
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		rows, err := tx.Query(context.Background(), `
+			SELECT v.version, COALESCE(f.type, ''), COALESCE(f.url, ''), COALESCE(f.hash, ''),
+				COALESCE(f.run_id, 0), f.created
+			FROM UNNEST($2::integer[]) WITH ORDINALITY AS v(version, n)`+provenanceFileSQL+`
+			ORDER BY v.n`, source.ID, vs)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			p := persist.Provenance{Source: source.Source, SessionID: source.SessionID}
+			var applied *time.Time
+			if err = rows.Scan(&p.Version, &p.FileType, &p.URL, &p.Hash, &p.RunID, &applied); err != nil {
+				return err
+			}
+			if applied != nil {
+				p.Applied = *applied
+			}
+			provenance = append(provenance, p)
+		}
+		return rows.Err()
+	})
+	return provenance, err
+}
+
+// GetObjectProvenance returns the provenance of the objects with the primary keys as they were
+// at a version. Version 0 is the latest applied version.
+func (repo PostgresRepository) GetObjectProvenance(source persist.NRTMSource, version uint32, primaryKeys []string) ([]persist.ObjectProvenance, error) {
+	provenance := []persist.ObjectProvenance{}
+	start := time.Now()
+	defer func() { repo.logSlow("GetObjectProvenance", &source, start, len(provenance)) }()
+	err := repo.readTransaction(func(tx pgx.Tx) error {
+		version, err := readVersion(tx, source, version)
+		if err != nil {
+			return err
+		}
+		rows, err := tx.Query(context.Background(), `
+			SELECT v.object_type, v.primary_key, v.version, COALESCE(f.type, ''), COALESCE(f.url, ''),
+				COALESCE(f.hash, ''), COALESCE(f.run_id, 0), f.created
+			FROM (
+				SELECT object_type, primary_key, from_version AS version
+				FROM nrtm_rpslobject
+				WHERE nrtm_source_id = $1
+					AND `+visibleAt(3)+`
+					AND primary_key = ANY($2)
+			) v`+provenanceFileSQL+`
+			ORDER BY v.primary_key, v.object_type`, source.ID, primaryKeys, version)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			p := persist.ObjectProvenance{Provenance: persist.Provenance{Source: source.Source, SessionID: source.SessionID}}
+			var applied *time.Time
+			if err = rows.Scan(&p.ObjectType, &p.PrimaryKey, &p.Version, &p.FileType, &p.URL, &p.Hash, &p.RunID, &applied); err != nil {
+				return err
+			}
+			if applied != nil {
+				p.Applied = *applied
+			}
+			provenance = append(provenance, p)
+		}
+		return rows.Err()
+	})
+	return provenance, err
+}
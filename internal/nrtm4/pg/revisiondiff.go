@@ -0,0 +1,118 @@
+package pg
+
+import (
+	"strings"
+)
+
+// maxDiffCells bounds the table used to find the lines two texts share. Texts with more
+// changed lines than that are diffed as one replacement, which is still correct, just larger.
+const maxDiffCells = 1 << 20
+
+// diffOp is one edit of a revision diff, in the form the nrtm_rpsl() function reads: Keep
+// copies the next lines of the base text, Delete skips them and Insert adds new lines
+type diffOp struct {
+	Keep   int      `json:"k,omitempty"`
+	Delete int      `json:"d,omitempty"`
+	Insert []string `json:"i,omitempty"`
+}
+
+// lineDiff returns the edits which turn the lines of base into the lines of text
+func lineDiff(base, text string) []diffOp {
+	a := strings.Split(base, "\n")
+	b := strings.Split(text, "\n")
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ops := []diffOp{}
+	ops = appendOp(ops, diffOp{Keep: prefix})
+	for _, op := range middleDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		ops = appendOp(ops, op)
+	}
+	return appendOp(ops, diffOp{Keep: suffix})
+}
+
+// middleDiff diffs the lines between the common prefix and suffix with a longest common
+// subsequence table
+func middleDiff(a, b []string) []diffOp {
+	if len(a)*len(b) > maxDiffCells || len(a) == 0 || len(b) == 0 {
+		return []diffOp{{Delete: len(a)}, {Insert: b}}
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	ops := []diffOp{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = appendOp(ops, diffOp{Keep: 1})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = appendOp(ops, diffOp{Delete: 1})
+			i++
+		default:
+			ops = appendOp(ops, diffOp{Insert: []string{b[j]}})
+			j++
+		}
+	}
+	return ops
+}
+
+// appendOp adds an edit, merging it into the last one when they're the same kind
+func appendOp(ops []diffOp, op diffOp) []diffOp {
+	if op.Keep == 0 && op.Delete == 0 && len(op.Insert) == 0 {
+		return ops
+	}
+	if len(ops) > 0 {
+		last := &ops[len(ops)-1]
+		switch {
+		case op.Keep > 0 && last.Keep > 0:
+			last.Keep += op.Keep
+			return ops
+		case op.Delete > 0 && last.Delete > 0:
+			last.Delete += op.Delete
+			return ops
+		case len(op.Insert) > 0 && len(last.Insert) > 0:
+			last.Insert = append(last.Insert, op.Insert...)
+			return ops
+		}
+	}
+	return append(ops, op)
+}
+
+// applyLineDiff rebuilds a text from its base and diff, the same way nrtm_rpsl() does
+func applyLineDiff(base string, ops []diffOp) string {
+	lines := strings.Split(base, "\n")
+	result := []string{}
+	pos := 0
+	for _, op := range ops {
+		switch {
+		case op.Keep > 0:
+			end := min(pos+op.Keep, len(lines))
+			result = append(result, lines[min(pos, end):end]...)
+			pos += op.Keep
+		case op.Delete > 0:
+			pos += op.Delete
+		default:
+			result = append(result, op.Insert...)
+		}
+	}
+	return strings.Join(result, "\n")
+}
@@ -0,0 +1,49 @@
+package pg
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLineDiff(t *testing.T) {
+	base := "route: 192.0.2.0/24\norigin: AS64500\nmnt-by: EXAMPLE-MNT\nsource: EXAMPLE\n"
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"same", base},
+		{"changed line", strings.Replace(base, "AS64500", "AS64501", 1)},
+		{"added lines", base + "remarks: one\nremarks: two\n"},
+		{"removed line", strings.Replace(base, "mnt-by: EXAMPLE-MNT\n", "", 1)},
+		{"no shared lines", "person: Example\nnic-hdl: EX1-TEST"},
+		{"reordered", "source: EXAMPLE\nmnt-by: EXAMPLE-MNT\norigin: AS64500\nroute: 192.0.2.0/24\n"},
+	}
+	for _, tt := range tests {
+		ops := lineDiff(base, tt.text)
+		if got := applyLineDiff(base, ops); got != tt.text {
+			t.Errorf("%v: expected\n%q\nbut rebuilt\n%q", tt.name, tt.text, got)
+		}
+		// The diff is stored as JSON, so it has to survive a round trip
+		encoded, err := json.Marshal(ops)
+		if err != nil {
+			t.Fatal(tt.name, err)
+		}
+		var decoded []diffOp
+		if err = json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatal(tt.name, err)
+		}
+		if got := applyLineDiff(base, decoded); got != tt.text {
+			t.Errorf("%v: expected decoded diff to rebuild\n%q\nbut was\n%q", tt.name, tt.text, got)
+		}
+	}
+}
+
+func TestLineDiffKeepsSharedLines(t *testing.T) {
+	ops := lineDiff("a\nb\nc\nd", "a\nB\nc\nd")
+	expected := `[{"k":1},{"d":1},{"i":["B"]},{"k":2}]`
+	encoded, _ := json.Marshal(ops)
+	if string(encoded) != expected {
+		t.Errorf("Expected %v but was %v", expected, string(encoded))
+	}
+}
@@ -0,0 +1,108 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// GetSchemaInfo describes the tables in the public schema
+func (repo PostgresRepository) GetSchemaInfo() (persist.SchemaInfo, error) {
+	start := time.Now()
+	defer func() { repo.logSlow("GetSchemaInfo", nil, start, unknownRows) }()
+	info := persist.SchemaInfo{Backend: "postgresql"}
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		var err error
+		if info.SchemaVersion, err = schemaVersion(tx); err != nil {
+			return err
+		}
+		tables := map[string]*persist.TableInfo{}
+		names := []string{}
+		rows, err := tx.Query(context.Background(), `
+			SELECT c.relname, GREATEST(c.reltuples, 0)::bigint, pg_total_relation_size(c.oid)
+			FROM pg_class c
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE n.nspname = 'public' AND c.relkind IN ('r', 'p')
+			ORDER BY c.relname`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			t := new(persist.TableInfo)
+			if err = rows.Scan(&t.Name, &t.EstimatedRows, &t.TotalBytes); err != nil {
+				rows.Close()
+				return err
+			}
+			tables[t.Name] = t
+			names = append(names, t.Name)
+		}
+		rows.Close()
+		rows, err = tx.Query(context.Background(), `
+			SELECT table_name, column_name, data_type, is_nullable = 'YES'
+			FROM information_schema.columns
+			WHERE table_schema = 'public'
+			ORDER BY table_name, ordinal_position`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var tableName string
+			var col persist.ColumnInfo
+			if err = rows.Scan(&tableName, &col.Name, &col.Type, &col.Nullable); err != nil {
+				rows.Close()
+				return err
+			}
+			if t, ok := tables[tableName]; ok {
+				t.Columns = append(t.Columns, col)
+			}
+		}
+		rows.Close()
+		rows, err = tx.Query(context.Background(), `
+			SELECT i.tablename, i.indexname, pg_relation_size(c.oid)
+			FROM pg_indexes i
+			JOIN pg_class c ON c.relname = i.indexname
+			JOIN pg_namespace n ON n.oid = c.relnamespace AND n.nspname = i.schemaname
+			WHERE i.schemaname = 'public'
+			ORDER BY i.tablename, i.indexname`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var tableName string
+			var idx persist.IndexInfo
+			if err = rows.Scan(&tableName, &idx.Name, &idx.Bytes); err != nil {
+				rows.Close()
+				return err
+			}
+			if t, ok := tables[tableName]; ok {
+				t.Indexes = append(t.Indexes, idx)
+			}
+		}
+		rows.Close()
+		for _, name := range names {
+			info.Tables = append(info.Tables, *tables[name])
+		}
+		return nil
+	})
+	return info, err
+}
+
+// schemaVersion is the version recorded by tern, or zero if tern hasn't been run
+func schemaVersion(tx pgx.Tx) (int, error) {
+	var exists bool
+	err := tx.QueryRow(context.Background(), `
+		SELECT to_regclass('public.schema_version') IS NOT NULL`).Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+	var version int
+	err = tx.QueryRow(context.Background(), `
+		SELECT version FROM schema_version LIMIT 1`).Scan(&version)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
@@ -0,0 +1,62 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// SaveSessionSeen records that a session was seen at the source's notification URL. A session
+// which was seen before keeps its first_seen time and version, and stays announced once it has
+// been.
+func (repo PostgresRepository) SaveSessionSeen(source persist.NRTMSource, seen persist.SessionSeen) error {
+	start := time.Now()
+	defer func() { repo.logSlow("SaveSessionSeen", &source, start, 1) }()
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `
+			INSERT INTO nrtm_session_history (source, notification_url, session_id, first_seen, last_seen,
+				first_version, last_version, announced)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (source, notification_url, session_id)
+			DO UPDATE SET
+				last_seen = GREATEST(nrtm_session_history.last_seen, EXCLUDED.last_seen),
+				first_version = LEAST(nrtm_session_history.first_version, EXCLUDED.first_version),
+				last_version = GREATEST(nrtm_session_history.last_version, EXCLUDED.last_version),
+				announced = nrtm_session_history.announced OR EXCLUDED.announced`,
+			source.Source, source.NotificationURL, seen.SessionID, seen.FirstSeen, seen.LastSeen,
+			seen.FirstVersion, seen.LastVersion, seen.Announced,
+		)
+		return err
+	})
+}
+
+// GetSessionHistory lists the sessions seen at the source's notification URL, oldest first
+func (repo PostgresRepository) GetSessionHistory(source persist.NRTMSource) ([]persist.SessionSeen, error) {
+	start := time.Now()
+	sessions := []persist.SessionSeen{}
+	defer func() { repo.logSlow("GetSessionHistory", &source, start, len(sessions)) }()
+	err := repo.readTransaction(func(tx pgx.Tx) error {
+		rows, err := tx.Query(context.Background(), `
+			SELECT session_id, first_seen, last_seen, first_version, last_version, announced
+			FROM nrtm_session_history
+			WHERE source = $1 AND notification_url = $2
+			ORDER BY first_seen, session_id`, source.Source, source.NotificationURL,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var seen persist.SessionSeen
+			if err = rows.Scan(&seen.SessionID, &seen.FirstSeen, &seen.LastSeen, &seen.FirstVersion, &seen.LastVersion, &seen.Announced); err != nil {
+				return err
+			}
+			sessions = append(sessions, seen)
+		}
+		return rows.Err()
+	})
+	return sessions, err
+}
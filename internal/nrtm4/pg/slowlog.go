@@ -0,0 +1,32 @@
+package pg
+
+import (
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+// unknownRows is logged when an operation doesn't know how many rows it touched
+const unknownRows = -1
+
+// logSlow logs a repository call which took longer than SlowQueryThreshold, with the number of
+// rows it read or wrote. source can be nil for calls which aren't about one source.
+//
+// Every call which reads or writes the mirror's data uses it. Connection handling (Initialize,
+// Ping, Close) and the startup and health checks against PostgreSQL itself (GetSchemaVersion,
+// RecordClientVersion, IsStandby, IsIdle, Promote) don't, since they only look at PostgreSQL's
+// catalog and status, or the record of client versions, rather than the mirror's data.
+func (repo PostgresRepository) logSlow(operation string, source *persist.NRTMSource, start time.Time, rows int) {
+	if repo.SlowQueryThreshold <= 0 {
+		return
+	}
+	elapsed := time.Since(start)
+	if elapsed < repo.SlowQueryThreshold {
+		return
+	}
+	attrs := []any{"operation", operation, "elapsed", elapsed, "rows", rows}
+	if source != nil {
+		attrs = append(attrs, "source", source.Source, "label", source.Label)
+	}
+	logger.Warn("Slow repository call", attrs...)
+}
@@ -0,0 +1,36 @@
+package pg
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+func TestLogSlow(t *testing.T) {
+	var buf bytes.Buffer
+	saved := logger
+	logger = slog.New(slog.NewTextHandler(&buf, nil))
+	defer func() { logger = saved }()
+
+	source := &persist.NRTMSource{Source: "EXAMPLE"}
+	PostgresRepository{}.logSlow("GetSources", nil, time.Now().Add(-time.Hour), 3)
+	if buf.Len() > 0 {
+		t.Error("Expected nothing to be logged when there's no threshold", buf.String())
+	}
+	repo := PostgresRepository{SlowQueryThreshold: time.Second}
+	repo.logSlow("AddModifyObject", source, time.Now(), 1)
+	if buf.Len() > 0 {
+		t.Error("Expected fast call not to be logged", buf.String())
+	}
+	repo.logSlow("GetObjectChanges", source, time.Now().Add(-2*time.Second), 42)
+	line := buf.String()
+	for _, expected := range []string{"operation=GetObjectChanges", "rows=42", "source=EXAMPLE"} {
+		if !strings.Contains(line, expected) {
+			t.Error("Expected", expected, "in", line)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// SaveSnapshotRefs records the snapshots a notification advertised. A snapshot which was seen
+// before keeps its first_seen time, and its url is updated in case the server moved it. A
+// session and version mustn't be in refs twice.
+func (repo PostgresRepository) SaveSnapshotRefs(source persist.NRTMSource, refs []persist.SnapshotRef) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	start := time.Now()
+	defer func() { repo.logSlow("SaveSnapshotRefs", &source, start, len(refs)) }()
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		sessions := make([]string, len(refs))
+		versions := make([]int64, len(refs))
+		urls := make([]string, len(refs))
+		hashes := make([]string, len(refs))
+		firstSeen := make([]time.Time, len(refs))
+		lastSeen := make([]time.Time, len(refs))
+		for i, ref := range refs {
+			sessions[i] = ref.SessionID
+			versions[i] = int64(ref.Version)
+			urls[i] = ref.URL
+			hashes[i] = ref.Hash
+			firstSeen[i] = ref.FirstSeen
+			lastSeen[i] = ref.LastSeen
+		}
+		_, err := tx.Exec(context.Background(), `
+			INSERT INTO nrtm_snapshot_ref (nrtm_source_id, session_id, version, url, hash, first_seen, last_seen)
+			SELECT $1, s, v, u, h, f, l
+			FROM UNNEST($2::text[], $3::integer[], $4::text[], $5::text[], $6::timestamp[], $7::timestamp[]) AS q(s, v, u, h, f, l)
+			ON CONFLICT (nrtm_source_id, session_id, version)
+			DO UPDATE SET url = EXCLUDED.url, last_seen = EXCLUDED.last_seen`,
+			source.ID, sessions, versions, urls, hashes, firstSeen, lastSeen,
+		)
+		return err
+	})
+}
+
+// GetSnapshotRefs lists the snapshots recorded for a source, the most recently advertised first
+func (repo PostgresRepository) GetSnapshotRefs(source persist.NRTMSource) ([]persist.SnapshotRef, error) {
+	start := time.Now()
+	refs := []persist.SnapshotRef{}
+	defer func() { repo.logSlow("GetSnapshotRefs", &source, start, len(refs)) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		rows, err := tx.Query(context.Background(), `
+			SELECT session_id, version, url, hash, first_seen, last_seen
+			FROM nrtm_snapshot_ref
+			WHERE nrtm_source_id = $1
+			ORDER BY last_seen DESC, version DESC`, source.ID,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var ref persist.SnapshotRef
+			if err = rows.Scan(&ref.SessionID, &ref.Version, &ref.URL, &ref.Hash, &ref.FirstSeen, &ref.LastSeen); err != nil {
+				return err
+			}
+			refs = append(refs, ref)
+		}
+		return rows.Err()
+	})
+	return refs, err
+}
@@ -0,0 +1,59 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// SquashHistory collapses a source's history before baseline. Object versions which ended at or
+// before baseline can't be seen from baseline on, so they're removed, along with their entries
+// in the optional indexes. The versions left which started before baseline are moved to start
+// at it, so the source's history looks as if it was connected with a snapshot at baseline.
+func (repo PostgresRepository) SquashHistory(source persist.NRTMSource, baseline uint32) (persist.SquashedHistory, error) {
+	squashed := persist.SquashedHistory{Baseline: baseline}
+	start := time.Now()
+	defer func() { repo.logSlow("SquashHistory", &source, start, int(squashed.Removed+squashed.Rebased)) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		for _, idx := range optionalIndexes {
+			if _, err := tx.Exec(context.Background(), fmt.Sprintf(`
+				DELETE FROM %v i
+				USING nrtm_rpslobject r
+				WHERE i.rpslobject_id = r.id
+					AND r.nrtm_source_id = $1
+					AND r.to_version <> 0
+					AND r.to_version <= $2`, idx.table), source.ID, baseline,
+			); err != nil {
+				return err
+			}
+		}
+		tag, err := tx.Exec(context.Background(), `
+			DELETE FROM nrtm_rpslobject
+			WHERE nrtm_source_id = $1
+				AND to_version <> 0
+				AND to_version <= $2`, source.ID, baseline,
+		)
+		if err != nil {
+			return err
+		}
+		squashed.Removed = tag.RowsAffected()
+		tag, err = tx.Exec(context.Background(), `
+			UPDATE nrtm_rpslobject
+			SET from_version = $2
+			WHERE nrtm_source_id = $1
+				AND from_version < $2`, source.ID, baseline,
+		)
+		if err != nil {
+			return err
+		}
+		squashed.Rebased = tag.RowsAffected()
+		_, err = tx.Exec(context.Background(), `
+			UPDATE nrtm_source SET baseline = GREATEST(baseline, $2) WHERE id = $1`, source.ID, baseline)
+		return err
+	})
+	return squashed, err
+}
@@ -0,0 +1,36 @@
+package pg
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// promoteWaitSeconds is how long pg_promote waits for the standby to become a primary
+const promoteWaitSeconds = 60
+
+// IsStandby is true when the database is a read-only replica of another one
+func (repo PostgresRepository) IsStandby() (bool, error) {
+	var standby bool
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		return tx.QueryRow(context.Background(), `SELECT pg_is_in_recovery()`).Scan(&standby)
+	})
+	return standby, err
+}
+
+// Promote turns a standby database into a primary, so it can be written to
+func (repo PostgresRepository) Promote() error {
+	var promoted bool
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		return tx.QueryRow(context.Background(), `SELECT pg_promote(true, $1)`, promoteWaitSeconds).Scan(&promoted)
+	})
+	if err != nil {
+		return err
+	}
+	if !promoted {
+		return errors.New("database was not promoted within the time limit")
+	}
+	return nil
+}
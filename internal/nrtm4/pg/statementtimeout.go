@@ -0,0 +1,43 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// queryCanceled is the SQLSTATE of a statement cancelled by statement_timeout
+const queryCanceled = "57014"
+
+// WithStatementTimeout implementation of the Repository interface
+func (repo PostgresRepository) WithStatementTimeout(timeout time.Duration) persist.Repository {
+	repo.StatementTimeout = timeout
+	return repo
+}
+
+// readTransaction runs fn in a transaction whose statements the database cancels after
+// StatementTimeout, for the reads the web API's clients can ask for
+func (repo PostgresRepository) readTransaction(fn db.TxFn) error {
+	if repo.StatementTimeout <= 0 {
+		return db.WithTransaction(fn)
+	}
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		// SET doesn't take parameters. The timeout is at least a millisecond, since 0 turns it off.
+		ms := max(repo.StatementTimeout.Milliseconds(), 1)
+		if _, err := tx.Exec(context.Background(), fmt.Sprintf("SET LOCAL statement_timeout = %d", ms)); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == queryCanceled {
+		return fmt.Errorf("%w: %v", persist.ErrStatementTimeout, repo.StatementTimeout)
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// SaveSyncRun records the outcome of an update
+func (repo PostgresRepository) SaveSyncRun(source persist.NRTMSource, run persist.SyncRun) error {
+	start := time.Now()
+	defer func() { repo.logSlow("SaveSyncRun", &source, start, 1) }()
+	var lag *int64
+	if run.Lag > 0 {
+		ms := run.Lag.Milliseconds()
+		lag = &ms
+	}
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `
+			INSERT INTO nrtm_sync_run (id, nrtm_source_id, run_id, started, duration_ms, from_version, to_version,
+				changes, lag_ms, warnings, success, failure, reason)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+			uint64(db.NextID()), source.ID, run.RunID, run.Started.UTC(), run.Duration.Milliseconds(), run.FromVersion, run.ToVersion,
+			run.Changes, lag, run.Warnings, run.Success, run.Failure, run.Reason,
+		)
+		return err
+	})
+}
+
+// GetSyncRuns returns the updates of a source which started in [from, to), oldest first
+func (repo PostgresRepository) GetSyncRuns(source persist.NRTMSource, from, to time.Time) ([]persist.SyncRun, error) {
+	runs := []persist.SyncRun{}
+	start := time.Now()
+	defer func() { repo.logSlow("GetSyncRuns", &source, start, len(runs)) }()
+	err := repo.readTransaction(func(tx pgx.Tx) error {
+		rows, err := tx.Query(context.Background(), `
+			SELECT run_id, started, duration_ms, from_version, to_version, changes, COALESCE(lag_ms, 0),
+				warnings, success, failure, reason
+			FROM nrtm_sync_run
+			WHERE nrtm_source_id = $1 AND started >= $2 AND started < $3
+			ORDER BY started`, source.ID, from.UTC(), to.UTC(),
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var run persist.SyncRun
+			var durationMs, lagMs int64
+			if err = rows.Scan(&run.RunID, &run.Started, &durationMs, &run.FromVersion, &run.ToVersion, &run.Changes, &lagMs,
+				&run.Warnings, &run.Success, &run.Failure, &run.Reason); err != nil {
+				return err
+			}
+			run.Duration = time.Duration(durationMs) * time.Millisecond
+			run.Lag = time.Duration(lagMs) * time.Millisecond
+			runs = append(runs, run)
+		}
+		return rows.Err()
+	})
+	return runs, err
+}
+
+// PruneSyncRuns removes the records of updates which started before a point in time
+func (repo PostgresRepository) PruneSyncRuns(before time.Time) (int64, error) {
+	start := time.Now()
+	var removed int64
+	defer func() { repo.logSlow("PruneSyncRuns", nil, start, int(removed)) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		tag, err := tx.Exec(context.Background(), `DELETE FROM nrtm_sync_run WHERE started < $1`, before.UTC())
+		removed = tag.RowsAffected()
+		return err
+	})
+	return removed, err
+}
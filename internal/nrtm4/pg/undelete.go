@@ -0,0 +1,87 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+	pgpersist "github.com/petchells/nrtm4client/internal/nrtm4/pg/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// UndeleteObject restores the last version of an object which was deleted after a point in
+// time. It's added again at the source's current version, marked as restored so it isn't taken
+// for a change the server made, and the history still shows when it was deleted. It returns
+// persist.ErrNoDeletedObject if the object exists, or if it wasn't deleted in that time.
+func (repo PostgresRepository) UndeleteObject(
+	source persist.NRTMSource,
+	objectType string,
+	primaryKey string,
+	since time.Time,
+) (persist.ObjectVersion, error) {
+	var restored persist.ObjectVersion
+	start := time.Now()
+	defer func() { repo.logSlow("UndeleteObject", &source, start, 1) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		baseline, err := versionAt(tx, source, since)
+		if err != nil {
+			return err
+		}
+		deleted := new(pgpersist.RPSLObject)
+		desc := db.GetDescriptor(deleted)
+		sql := fmt.Sprintf(`
+			SELECT %v
+			FROM %v r
+			WHERE r.nrtm_source_id = $1
+				AND r.primary_key = UPPER($2)
+				AND r.object_type = UPPER($3)
+				AND r.to_version > $4
+				AND NOT EXISTS (
+					SELECT 1 FROM nrtm_rpslobject nxt
+					WHERE nxt.nrtm_source_id = r.nrtm_source_id
+						AND nxt.object_type = r.object_type
+						AND nxt.primary_key = r.primary_key
+						AND (nxt.from_version = r.to_version OR nxt.to_version = 0)
+				)
+			ORDER BY r.to_version DESC
+			LIMIT 1`,
+			desc.ColumnNamesCommaSeparated(),
+			desc.TableName(),
+		)
+		err = tx.QueryRow(context.Background(), sql, source.ID, primaryKey, objectType, baseline).Scan(db.SelectValues(deleted)...)
+		if err == pgx.ErrNoRows {
+			return persist.ErrNoDeletedObject
+		} else if err != nil {
+			return err
+		}
+		row := &pgpersist.RPSLObject{
+			ID:           db.NextID(),
+			ObjectType:   deleted.ObjectType,
+			PrimaryKey:   deleted.PrimaryKey,
+			NRTMSourceID: source.ID,
+			FromVersion:  source.Version,
+			RPSL:         deleted.RPSL,
+		}
+		if err = db.Create(tx, row); err != nil {
+			return err
+		}
+		restored = persist.ObjectVersion{
+			ObjectType:  row.ObjectType,
+			PrimaryKey:  row.PrimaryKey,
+			FromVersion: row.FromVersion,
+			RPSL:        row.RPSL,
+			Restored:    util.AppClock.Now(),
+		}
+		if _, err = tx.Exec(context.Background(), `
+			UPDATE nrtm_rpslobject SET restored = $2 WHERE id = $1`, row.ID, restored.Restored); err != nil {
+			return err
+		}
+		file := protocol.NrtmFileJSON{Source: source.Source, SessionID: source.SessionID, Version: source.Version}
+		return repo.objectChanged(tx, source, nil, rowImage(row), file)
+	})
+	return restored, err
+}
@@ -0,0 +1,175 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+)
+
+// visibleAt is the condition for a row of nrtm_rpslobject being one of its source's objects at
+// the version in parameter $n. Rows aren't deleted when an object is modified or deleted, they
+// get a to_version instead, so any version which is still in the history can be read.
+func visibleAt(n int) string {
+	return fmt.Sprintf("from_version <= $%[1]d AND (to_version = 0 OR to_version > $%[1]d)", n)
+}
+
+// readVersion is the version of a source that readers see: version, or when it's 0, the latest
+// version which has been completely applied. Readers never see part of a delta, however long it
+// takes to apply, and they don't have to wait for it. Versions before the source's baseline,
+// which is the version it was connected at, or the one its history was squashed to, can't be
+// read.
+func readVersion(tx pgx.Tx, source persist.NRTMSource, version uint32) (uint32, error) {
+	var applied, baseline uint32
+	err := tx.QueryRow(context.Background(), `
+		SELECT applied_version, baseline FROM nrtm_source WHERE id = $1`, source.ID).Scan(&applied, &baseline)
+	if err != nil {
+		return 0, err
+	}
+	if version == 0 {
+		return applied, nil
+	}
+	if version > applied {
+		return 0, fmt.Errorf("%w: %d, the latest is %d", persist.ErrVersionNotApplied, version, applied)
+	}
+	if version < baseline {
+		return 0, fmt.Errorf("%w: %d, the earliest is %d", persist.ErrVersionNotInRepo, version, baseline)
+	}
+	return version, nil
+}
+
+// SetAppliedVersion makes a version visible to readers, once every change in it has been saved.
+// The source's version is moved on in the same statement, so it's never ahead of what readers see.
+func (repo PostgresRepository) SetAppliedVersion(source persist.NRTMSource, version uint32) error {
+	start := time.Now()
+	defer func() { repo.logSlow("SetAppliedVersion", &source, start, 1) }()
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `
+			UPDATE nrtm_source SET version = $2, applied_version = $2 WHERE id = $1`, source.ID, version)
+		return err
+	})
+}
+
+// SetAppliedVersionWithScript makes a version visible to readers and runs an SQL script in the
+// same transaction, so readers see the version and whatever the script refreshes together
+func (repo PostgresRepository) SetAppliedVersionWithScript(source persist.NRTMSource, version uint32, script string) error {
+	start := time.Now()
+	defer func() { repo.logSlow("SetAppliedVersionWithScript", &source, start, unknownRows) }()
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `
+			UPDATE nrtm_source SET version = $2, applied_version = $2 WHERE id = $1`, source.ID, version)
+		if err != nil {
+			return err
+		}
+		// Without arguments the script is sent as a simple query, so it can have many statements
+		_, err = tx.Exec(context.Background(), script)
+		return err
+	})
+}
+
+// GetAppliedVersions reads the versions readers see of several sources in one statement, so
+// they're the versions the sources were all at at the same moment. A source which has gone has 0.
+func (repo PostgresRepository) GetAppliedVersions(sources []persist.NRTMSource) ([]uint32, error) {
+	start := time.Now()
+	ids := make([]uint64, len(sources))
+	for i, source := range sources {
+		ids[i] = source.ID
+	}
+	applied := map[uint64]uint32{}
+	defer func() { repo.logSlow("GetAppliedVersions", nil, start, len(applied)) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		rows, err := tx.Query(context.Background(), `
+			SELECT id, applied_version FROM nrtm_source WHERE id = ANY($1)`, ids)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id uint64
+			var version uint32
+			if err = rows.Scan(&id, &version); err != nil {
+				return err
+			}
+			applied[id] = version
+		}
+		return rows.Err()
+	})
+	versions := make([]uint32, len(sources))
+	for i, id := range ids {
+		versions[i] = applied[id]
+	}
+	return versions, err
+}
+
+// RunScript runs the statements in an SQL script in a transaction
+func (repo PostgresRepository) RunScript(script string) error {
+	start := time.Now()
+	defer func() { repo.logSlow("RunScript", nil, start, unknownRows) }()
+	return db.WithTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), script)
+		return err
+	})
+}
+
+// DiscardUnappliedChanges rolls a source back to its applied version, throwing away what was
+// saved from a delta which wasn't completely applied. Objects it added or replaced are deleted,
+// along with their entries in the optional indexes, the versions it replaced or deleted are
+// current again, and the source's version goes back so the delta is applied again by the next
+// update. Tables kept by ObjectHooks aren't rolled back. It returns the number of object rows
+// changed.
+func (repo PostgresRepository) DiscardUnappliedChanges(source persist.NRTMSource) (int64, error) {
+	start := time.Now()
+	var rows int64
+	defer func() { repo.logSlow("DiscardUnappliedChanges", &source, start, int(rows)) }()
+	err := db.WithTransaction(func(tx pgx.Tx) error {
+		rows = 0
+		var applied uint32
+		err := tx.QueryRow(context.Background(), `
+			SELECT applied_version FROM nrtm_source WHERE id = $1 FOR UPDATE`, source.ID).Scan(&applied)
+		if err != nil {
+			return err
+		}
+		for _, idx := range optionalIndexes {
+			if _, err = tx.Exec(context.Background(), fmt.Sprintf(`
+				DELETE FROM %v i
+				USING nrtm_rpslobject r
+				WHERE i.rpslobject_id = r.id
+					AND r.nrtm_source_id = $1
+					AND r.from_version > $2`, idx.table), source.ID, applied,
+			); err != nil {
+				return err
+			}
+		}
+		tag, err := tx.Exec(context.Background(), `
+			DELETE FROM nrtm_rpslobject WHERE nrtm_source_id = $1 AND from_version > $2`, source.ID, applied)
+		if err != nil {
+			return err
+		}
+		rows += tag.RowsAffected()
+		var firstRestored *int64
+		if err = tx.QueryRow(context.Background(), `
+			SELECT MIN(id) FROM nrtm_rpslobject WHERE nrtm_source_id = $1 AND to_version > $2`, source.ID, applied,
+		).Scan(&firstRestored); err != nil {
+			return err
+		}
+		// The versions which are current again lost their index entries when they were replaced
+		if firstRestored != nil {
+			if err = rewindIndexBuilds(tx, uint64(*firstRestored)); err != nil {
+				return err
+			}
+		}
+		tag, err = tx.Exec(context.Background(), `
+			UPDATE nrtm_rpslobject SET to_version = 0 WHERE nrtm_source_id = $1 AND to_version > $2`, source.ID, applied)
+		if err != nil {
+			return err
+		}
+		rows += tag.RowsAffected()
+		_, err = tx.Exec(context.Background(), `
+			UPDATE nrtm_source SET version = $2 WHERE id = $1`, source.ID, applied)
+		return err
+	})
+	return rows, err
+}
@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// FileRefJSON json model of a file reference in a Notification file
+type FileRefJSON struct {
+	Version uint32 `json:"version"`
+	URL     string `json:"url"`
+	Hash    string `json:"hash"`
+	// Expires is not in the spec, but some servers say when a file stops being valid
+	Expires string `json:"expires,omitempty"`
+}
+
+// NrtmFileJSON json model of fields common to all NRTM4 files
+type NrtmFileJSON struct {
+	NrtmVersion uint   `json:"nrtm_version"`
+	Type        string `json:"type"`
+	Source      string `json:"source"`
+	SessionID   string `json:"session_id"`
+	Version     uint32 `json:"version"`
+	// Raw is the record exactly as the server sent it
+	Raw []byte `json:"-"`
+}
+
+// DeltaJSON json model of a change record in a DeltaFile
+type DeltaJSON struct {
+	Action      string  `json:"action"`
+	Object      *string `json:"object"`
+	ObjectClass *string `json:"object_class"`
+	PrimaryKey  *string `json:"primary_key"`
+}
+
+// NotificationJSON json model of an NRTM4 notification
+type NotificationJSON struct {
+	NrtmFileJSON
+	Timestamp      string        `json:"timestamp"`
+	NextSigningKey *string       `json:"next_signing_key"`
+	SnapshotRef    FileRefJSON   `json:"snapshot"`
+	DeltaRefs      []FileRefJSON `json:"deltas"`
+	// NextSession is not in the spec, but some servers use it to announce a rotation
+	NextSession *NextSessionJSON `json:"next_session,omitempty"`
+	// Expires is not in the spec, but some servers say when the notification stops being valid
+	Expires string `json:"expires,omitempty"`
+	// PrevSnapshots is not in the spec, but some servers list the snapshots before the current one
+	PrevSnapshots SnapshotRefsJSON `json:"prev_snapshot,omitempty"`
+}
+
+// SnapshotRefsJSON is a list of snapshot references, which a server may send as a single one
+type SnapshotRefsJSON []FileRefJSON
+
+// UnmarshalJSON reads an array of references, or a single one
+func (r *SnapshotRefsJSON) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var ref FileRefJSON
+		if err := json.Unmarshal(data, &ref); err != nil {
+			return err
+		}
+		*r = SnapshotRefsJSON{ref}
+		return nil
+	}
+	var refs []FileRefJSON
+	if err := json.Unmarshal(data, &refs); err != nil {
+		return err
+	}
+	*r = refs
+	return nil
+}
+
+// NextSessionJSON json model of a planned session rotation
+type NextSessionJSON struct {
+	// SessionID of the next session, if the server knows it in advance
+	SessionID *string `json:"session_id,omitempty"`
+	// Timestamp when the rotation is planned to happen
+	Timestamp string `json:"timestamp"`
+}
+
+// DeltaFileJSON json model of an NRTM4 delta file
+type DeltaFileJSON struct {
+	NrtmFileJSON
+}
+
+// SnapshotFileJSON json model of an NRTM4 snapshot
+type SnapshotFileJSON struct {
+	NrtmFileJSON
+}
+
+// SnapshotObjectJSON json model of an object record in a SnapshotFile
+type SnapshotObjectJSON struct {
+	Object string `json:"object"`
+}
@@ -0,0 +1,28 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUnmarshalPrevSnapshots(t *testing.T) {
+	for _, doc := range []string{
+		`{"prev_snapshot": {"version": 3, "url": "s3.json", "hash": "abc"}}`,
+		`{"prev_snapshot": [{"version": 3, "url": "s3.json", "hash": "abc"}]}`,
+	} {
+		var n NotificationJSON
+		if err := json.Unmarshal([]byte(doc), &n); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		if len(n.PrevSnapshots) != 1 || n.PrevSnapshots[0].Version != 3 || n.PrevSnapshots[0].Hash != "abc" {
+			t.Error("Unexpected previous snapshots", n.PrevSnapshots, "from", doc)
+		}
+	}
+	var n NotificationJSON
+	if err := json.Unmarshal([]byte(`{"version": 1}`), &n); err != nil || n.PrevSnapshots != nil {
+		t.Error("Expected no previous snapshots", n.PrevSnapshots, err)
+	}
+	if err := json.Unmarshal([]byte(`{"prev_snapshot": "s3.json"}`), &n); err == nil {
+		t.Error("Expected an error for a string")
+	}
+}
@@ -0,0 +1,199 @@
+// Package protocol has the JSON models of NRTMv4 files, and reads their records as the spec
+// revision the server follows.
+//
+// The *JSON types use the field names of the current revision. A revision which names
+// things differently lists its names in Fields and RefFields, and records detected as that
+// revision have their fields renamed before they're decoded. Supporting a new revision means
+// adding it to revisions, not changing the code which uses the records.
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// ErrInvalidRevision a revision has no name or isn't an NRTMv4 revision
+var ErrInvalidRevision = errors.New("spec revision must have a name and nrtm_version 4")
+
+// Revision is a revision of the NRTMv4 spec, and how its field names differ from Current's
+type Revision struct {
+	Name        string `json:"name"`
+	NrtmVersion uint   `json:"nrtm_version"`
+	// Fields maps the revision's names for record fields to Current's, where they're different
+	Fields map[string]string `json:"fields"`
+	// RefFields is the same for the fields of the snapshot and delta references in a notification
+	RefFields map[string]string `json:"ref_fields"`
+}
+
+// Current is the revision the *JSON types follow
+var Current = Revision{Name: util.SpecRevision, NrtmVersion: 4}
+
+// revisions are the revisions which can be detected, in order of preference
+var (
+	revisions   = []Revision{Current}
+	revisionsMu sync.RWMutex
+)
+
+// refKeys are the notification fields which hold file references, after renaming
+var refKeys = []string{"snapshot", "deltas", "prev_snapshot"}
+
+// Revisions lists the revisions the client can read
+func Revisions() []Revision {
+	revisionsMu.RLock()
+	defer revisionsMu.RUnlock()
+	return slices.Clone(revisions)
+}
+
+// Register adds a revision which servers may follow, e.g. from the spec_revisions in the config
+// file. It replaces a registered revision with the same name, and is preferred after the ones
+// registered before it.
+func Register(rev Revision) error {
+	if len(rev.Name) == 0 || rev.NrtmVersion != Current.NrtmVersion {
+		return ErrInvalidRevision
+	}
+	revisionsMu.Lock()
+	defer revisionsMu.Unlock()
+	if i := slices.IndexFunc(revisions, func(r Revision) bool { return r.Name == rev.Name }); i >= 0 {
+		revisions[i] = rev
+		return nil
+	}
+	revisions = append(revisions, rev)
+	return nil
+}
+
+// Detect finds the revision of a notification or header record. It's the revision with the
+// record's nrtm_version which has the most of its own field names in the record, or Current
+// when none match, so records which aren't NRTMv4 at all are left for validation to reject.
+func Detect(record []byte) Revision {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(record, &fields); err != nil {
+		return Current
+	}
+	var version uint
+	json.Unmarshal(fields["nrtm_version"], &version)
+	best, bestScore := Current, -1
+	revisionsMu.RLock()
+	defer revisionsMu.RUnlock()
+	for _, rev := range revisions {
+		if rev.NrtmVersion != version {
+			continue
+		}
+		score := 0
+		for name := range rev.Fields {
+			if _, ok := fields[name]; ok {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = rev, score
+		}
+	}
+	return best
+}
+
+// DecodeNotification reads a notification file. Raw is set to the file as it was sent.
+func DecodeNotification(raw []byte) (NotificationJSON, Revision, error) {
+	var notification NotificationJSON
+	rev := Detect(raw)
+	record, err := rev.canonical(raw, true)
+	if err == nil {
+		err = json.Unmarshal(record, &notification)
+	}
+	notification.Raw = raw
+	return notification, rev, err
+}
+
+// DecodeHeader reads the first record of a snapshot or delta file. Raw is set to a copy of the
+// record, since jsonseq records are only valid until the next one is read.
+func DecodeHeader(record []byte) (NrtmFileJSON, Revision, error) {
+	var header NrtmFileJSON
+	rev := Detect(record)
+	canonical, err := rev.canonical(record, false)
+	if err == nil {
+		err = json.Unmarshal(canonical, &header)
+	}
+	header.Raw = bytes.Clone(record)
+	return header, rev, err
+}
+
+// DecodeChange reads a change record of a delta file of the revision
+func (rev Revision) DecodeChange(record []byte) (DeltaJSON, error) {
+	var change DeltaJSON
+	canonical, err := rev.canonical(record, false)
+	if err != nil {
+		return change, err
+	}
+	err = json.Unmarshal(canonical, &change)
+	return change, err
+}
+
+// Canonical renames the revision's fields in a snapshot object record to Current's, so it can
+// be decoded as a SnapshotObjectJSON. Records of revisions with the same names are returned as
+// they are, without being decoded.
+func (rev Revision) Canonical(record []byte) ([]byte, error) {
+	return rev.canonical(record, false)
+}
+
+// canonical renames the revision's fields in a record to Current's. Records of revisions with
+// the same names are returned as they are.
+func (rev Revision) canonical(record []byte, notification bool) ([]byte, error) {
+	if len(rev.Fields) == 0 && (!notification || len(rev.RefFields) == 0) {
+		return record, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(record, &fields); err != nil {
+		return nil, err
+	}
+	fields = renamed(fields, rev.Fields)
+	if notification && len(rev.RefFields) > 0 {
+		for _, key := range refKeys {
+			value, ok := fields[key]
+			if !ok {
+				continue
+			}
+			var err error
+			if fields[key], err = renameRefs(value, rev.RefFields); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// renameRefs renames the fields of a reference, or of each one in an array of them
+func renameRefs(value json.RawMessage, names map[string]string) (json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(value)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var refs []map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &refs); err != nil {
+			return nil, err
+		}
+		for i, ref := range refs {
+			refs[i] = renamed(ref, names)
+		}
+		return json.Marshal(refs)
+	}
+	var ref map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &ref); err != nil || ref == nil {
+		// Not an object, so it's left for the *JSON type to reject
+		return value, nil
+	}
+	return json.Marshal(renamed(ref, names))
+}
+
+func renamed(fields map[string]json.RawMessage, names map[string]string) map[string]json.RawMessage {
+	for from, to := range names {
+		if value, ok := fields[from]; ok {
+			delete(fields, from)
+			if _, exists := fields[to]; !exists {
+				fields[to] = value
+			}
+		}
+	}
+	return fields
+}
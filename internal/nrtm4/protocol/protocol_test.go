@@ -0,0 +1,109 @@
+package protocol
+
+import (
+	"testing"
+)
+
+// renamingRevision names things differently from Current, as a later revision might
+var renamingRevision = Revision{
+	Name:        "test-renamed",
+	NrtmVersion: 4,
+	Fields:      map[string]string{"session": "session_id", "snapshot_file": "snapshot", "delta_files": "deltas", "class": "object_class"},
+	RefFields:   map[string]string{"location": "url"},
+}
+
+func withRevisions(t *testing.T, revs ...Revision) {
+	saved := revisions
+	revisions = revs
+	t.Cleanup(func() { revisions = saved })
+}
+
+func TestDecodeNotificationCurrent(t *testing.T) {
+	raw := []byte(`{"nrtm_version": 4, "type": "notification", "source": "EXAMPLE", "session_id": "s1", "version": 3,
+		"snapshot": {"version": 2, "url": "https://example.net/s2.json", "hash": "aa"},
+		"deltas": [{"version": 3, "url": "https://example.net/d3.json", "hash": "bb"}]}`)
+	withRevisions(t, Current, renamingRevision)
+	n, rev, err := DecodeNotification(raw)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if rev.Name != Current.Name {
+		t.Error("Expected the current revision but was", rev.Name)
+	}
+	if n.SessionID != "s1" || n.SnapshotRef.URL != "https://example.net/s2.json" || len(n.DeltaRefs) != 1 {
+		t.Error("Unexpected notification", n)
+	}
+	if string(n.Raw) != string(raw) {
+		t.Error("Expected Raw to be the file as it was sent")
+	}
+}
+
+func TestDecodeRenamedRevision(t *testing.T) {
+	withRevisions(t, Current, renamingRevision)
+	raw := []byte(`{"nrtm_version": 4, "type": "notification", "source": "EXAMPLE", "session": "s1", "version": 3,
+		"snapshot_file": {"version": 2, "location": "https://example.net/s2.json", "hash": "aa"},
+		"delta_files": [{"version": 3, "location": "https://example.net/d3.json", "hash": "bb"}],
+		"prev_snapshot": {"version": 1, "location": "https://example.net/s1.json", "hash": "cc"}}`)
+	n, rev, err := DecodeNotification(raw)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if rev.Name != renamingRevision.Name {
+		t.Fatal("Expected the renamed revision but was", rev.Name)
+	}
+	if n.SessionID != "s1" || n.SnapshotRef.URL != "https://example.net/s2.json" {
+		t.Error("Unexpected snapshot", n.SessionID, n.SnapshotRef)
+	}
+	if len(n.DeltaRefs) != 1 || n.DeltaRefs[0].URL != "https://example.net/d3.json" {
+		t.Error("Unexpected deltas", n.DeltaRefs)
+	}
+	if len(n.PrevSnapshots) != 1 || n.PrevSnapshots[0].URL != "https://example.net/s1.json" {
+		t.Error("Unexpected previous snapshots", n.PrevSnapshots)
+	}
+
+	header, rev, err := DecodeHeader([]byte(`{"nrtm_version": 4, "type": "delta", "source": "EXAMPLE", "session": "s1", "version": 3}`))
+	if err != nil || rev.Name != renamingRevision.Name || header.SessionID != "s1" {
+		t.Fatal("Unexpected header", header, rev.Name, err)
+	}
+	change, err := rev.DecodeChange([]byte(`{"action": "delete", "class": "route", "primary_key": "192.0.2.0/24AS64500"}`))
+	if err != nil || change.ObjectClass == nil || *change.ObjectClass != "route" {
+		t.Error("Unexpected change", change, err)
+	}
+}
+
+func TestDetectUnknownVersion(t *testing.T) {
+	withRevisions(t, Current, renamingRevision)
+	for _, record := range []string{`{"nrtm_version": 5, "session": "s1"}`, `not json`} {
+		if rev := Detect([]byte(record)); rev.Name != Current.Name {
+			t.Error("Expected the current revision for", record, "but was", rev.Name)
+		}
+	}
+	if _, _, err := DecodeHeader([]byte(`not json`)); err == nil {
+		t.Error("Expected an error for a header which isn't JSON")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	withRevisions(t, Current)
+	for _, invalid := range []Revision{{NrtmVersion: 4}, {Name: "v3", NrtmVersion: 3}} {
+		if err := Register(invalid); err != ErrInvalidRevision {
+			t.Error("Expected ErrInvalidRevision for", invalid, "but was", err)
+		}
+	}
+	if err := Register(Revision{Name: renamingRevision.Name, NrtmVersion: 4}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err := Register(renamingRevision); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if revs := Revisions(); len(revs) != 2 || len(revs[1].Fields) == 0 {
+		t.Error("Expected the revision to be replaced by the one with the same name", revs)
+	}
+	if rev := Detect([]byte(`{"nrtm_version": 4, "session": "s1"}`)); rev.Name != renamingRevision.Name {
+		t.Error("Expected the registered revision but was", rev.Name)
+	}
+	record, err := Revision{Fields: map[string]string{"rpsl": "object"}}.Canonical([]byte(`{"rpsl": "route: 192.0.2.0/24"}`))
+	if err != nil || string(record) != `{"object":"route: 192.0.2.0/24"}` {
+		t.Error("Expected the snapshot object's fields to be renamed", string(record), err)
+	}
+}
@@ -0,0 +1,50 @@
+package quirks
+
+import (
+	"regexp"
+	"strings"
+)
+
+var sourceAttributeRegexp = regexp.MustCompile(`(?im)^source:`)
+
+func init() {
+	Register(Quirk{
+		Name:        "quoted-values",
+		Description: `Attribute values are wrapped in double quotes, e.g. origin: "AS65000"`,
+		Rewrite:     unquoteValues,
+	})
+	Register(Quirk{
+		Name:        "missing-source",
+		Description: "Objects have no source attribute, so it's taken from the NRTM source",
+		Rewrite:     addMissingSource,
+	})
+}
+
+// unquoteValues removes the double quotes around attribute values which are quoted as a whole
+func unquoteValues(_, text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || len(name) == 0 || strings.ContainsAny(name[:1], " \t+") {
+			continue
+		}
+		value, comment, _ := strings.Cut(value, "#")
+		trimmed := strings.TrimSpace(value)
+		if len(trimmed) < 2 || trimmed[0] != '"' || trimmed[len(trimmed)-1] != '"' {
+			continue
+		}
+		lines[i] = name + ": " + trimmed[1:len(trimmed)-1]
+		if len(comment) > 0 {
+			lines[i] += " #" + comment
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// addMissingSource adds a source attribute with the NRTM source's name, if there isn't one
+func addMissingSource(source, text string) string {
+	if len(source) == 0 || sourceAttributeRegexp.MatchString(text) {
+		return text
+	}
+	return strings.TrimRight(text, "\n") + "\nsource: " + source + "\n"
+}
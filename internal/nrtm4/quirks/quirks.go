@@ -0,0 +1,106 @@
+/*
+Package quirks adjusts how the objects of registries which don't quite follow RPSL are parsed.
+
+Each quirk is a handler compiled into the client and registered under a name. A source's config
+lists the quirks its registry needs, so the parser in package rpsl doesn't collect special cases
+for particular registries. Supporting a new quirk means registering it in an init function, not
+changing the code which parses objects.
+*/
+package quirks
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+// ErrUnknownQuirk the name isn't one of the registered quirks
+var ErrUnknownQuirk = errors.New("unknown quirk")
+
+// Quirk is a way a registry's objects differ from RPSL, and how to read them anyway
+type Quirk struct {
+	Name        string
+	Description string
+	// Rewrite returns an object's text as it should be parsed. source is the name of the NRTM
+	// source the object came from.
+	Rewrite func(source, text string) string
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]Quirk{}
+)
+
+// Register adds a quirk. Names are unique, so it panics if one is registered twice.
+func Register(q Quirk) {
+	mu.Lock()
+	defer mu.Unlock()
+	name := strings.ToLower(q.Name)
+	if _, ok := registry[name]; ok || len(name) == 0 || q.Rewrite == nil {
+		panic("quirk registered twice or without a name or rewrite: " + q.Name)
+	}
+	registry[name] = q
+}
+
+// All lists the registered quirks in order of name
+func All() []Quirk {
+	mu.RLock()
+	defer mu.RUnlock()
+	all := []Quirk{}
+	for _, q := range registry {
+		all = append(all, q)
+	}
+	slices.SortFunc(all, func(a, b Quirk) int { return strings.Compare(a.Name, b.Name) })
+	return all
+}
+
+// Set is the quirks used for one source, in the order they're applied. The zero value parses
+// objects as plain RPSL.
+type Set struct {
+	source string
+	quirks []Quirk
+}
+
+// ForSource returns a set of the named quirks for a source. It returns ErrUnknownQuirk if any
+// of them aren't registered.
+func ForSource(source string, names []string) (Set, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	set := Set{source: source}
+	for _, name := range names {
+		q, ok := registry[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return Set{}, fmt.Errorf("%w: %v", ErrUnknownQuirk, name)
+		}
+		set.quirks = append(set.quirks, q)
+	}
+	return set, nil
+}
+
+// Names lists the quirks in the set
+func (s Set) Names() []string {
+	names := []string{}
+	for _, q := range s.quirks {
+		names = append(names, q.Name)
+	}
+	return names
+}
+
+// Parse rewrites an object's text with each quirk in turn, then parses it. The payload is still
+// the text the server sent, so the mirror keeps objects as they were published.
+func (s Set) Parse(text string) (rpsl.Rpsl, error) {
+	if len(s.quirks) == 0 {
+		return rpsl.ParseFromJSONString(text)
+	}
+	rewritten := text
+	for _, q := range s.quirks {
+		rewritten = q.Rewrite(s.source, rewritten)
+	}
+	obj, err := rpsl.ParseFromJSONString(rewritten)
+	obj.Payload = text
+	return obj, err
+}
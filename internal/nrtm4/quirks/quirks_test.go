@@ -0,0 +1,61 @@
+package quirks
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestForSourceUnknownQuirk(t *testing.T) {
+	if _, err := ForSource("EXAMPLE", []string{"quoted-values", "no-such-quirk"}); !errors.Is(err, ErrUnknownQuirk) {
+		t.Error("Expected ErrUnknownQuirk but was", err)
+	}
+	set, err := ForSource("EXAMPLE", []string{" Quoted-Values", "missing-source"})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if names := set.Names(); len(names) != 2 || names[0] != "quoted-values" || names[1] != "missing-source" {
+		t.Error("Expected the quirks in config order but was", names)
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a quirk registered twice to panic")
+		}
+	}()
+	Register(Quirk{Name: "quoted-values", Rewrite: unquoteValues})
+}
+
+func TestParseQuotedValues(t *testing.T) {
+	text := "route: \"192.0.2.0/24\"\norigin: \"AS65000\" # quoted\ndescr: a \"quoted\" word\nsource: EXAMPLE\n"
+	set, _ := ForSource("EXAMPLE", []string{"quoted-values"})
+	obj, err := set.Parse(text)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if obj.PrimaryKey != "192.0.2.0/24AS65000" {
+		t.Error("Expected the quotes to be left out of the primary key but was", obj.PrimaryKey)
+	}
+	if obj.Payload != text {
+		t.Error("Expected the payload to be the text as it was sent but was", obj.Payload)
+	}
+	if expected := "descr: a \"quoted\" word"; unquoteValues("", expected) != expected {
+		t.Error("Expected quotes inside a value to be kept", unquoteValues("", expected))
+	}
+}
+
+func TestParseMissingSource(t *testing.T) {
+	text := "mntner: EXAMPLE-MNT\nmnt-by: EXAMPLE-MNT\n"
+	if _, err := (Set{}).Parse(text); err == nil {
+		t.Error("Expected an object without a source not to parse without the quirk")
+	}
+	set, _ := ForSource("EXAMPLE", []string{"missing-source"})
+	obj, err := set.Parse(text)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if obj.Source != "EXAMPLE" || obj.PrimaryKey != "EXAMPLE-MNT" {
+		t.Error("Expected the NRTM source's name to be used", obj.Source, obj.PrimaryKey)
+	}
+}
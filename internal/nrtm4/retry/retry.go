@@ -0,0 +1,314 @@
+/*
+Package retry decides when operations which fail are tried again, and when to stop trying.
+
+The HTTP client, the repository's connections and the scheduler each have a Retrier made from
+their part of the config. A Retrier retries with exponential or jittered backoff, within a
+number of attempts and a budget of time spent waiting, and has a circuit breaker for each key,
+e.g. a server's host name, which opens after a run of failures so callers fail straight away
+until it's time to try again. Each Retrier counts its retries and opens, and All reports those
+of the ones with a name.
+*/
+package retry
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+var logger = util.ModuleLogger("retry")
+
+// Policies
+const (
+	// Exponential doubles the delay after each retry
+	Exponential = "exponential"
+	// Jittered is Exponential, but each delay is picked at random from its second half, so
+	// clients which failed together don't all retry together
+	Jittered = "jittered"
+)
+
+var (
+	// ErrCircuitOpen the breaker for the key has opened after too many failures
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+	// ErrInvalidConfig the policy isn't known, a number is negative or a duration can't be parsed
+	ErrInvalidConfig = errors.New("invalid retry config")
+)
+
+// Config is how a component retries, as it's written in the config file. Anything left out
+// is taken from the component's defaults.
+type Config struct {
+	// Policy is exponential or jittered
+	Policy string `json:"policy"`
+	// Attempts is how many times an operation is tried, including the first. One means it
+	// isn't retried.
+	Attempts int `json:"attempts"`
+	// Delay is the wait before the first retry, e.g. "1s"
+	Delay string `json:"delay"`
+	// MaxDelay is the longest wait between retries
+	MaxDelay string `json:"max_delay"`
+	// Budget is the most time an operation can spend waiting to be retried
+	Budget string `json:"budget"`
+	// BreakerFailures is how many operations in a row have to fail for the breaker to open.
+	// Zero means there's no breaker.
+	BreakerFailures int `json:"breaker_failures"`
+	// BreakerOpen is how long the breaker stays open before an operation is tried again
+	BreakerOpen string `json:"breaker_open"`
+}
+
+// Validate checks the policy name, numbers and durations
+func (c Config) Validate() error {
+	_, err := c.Resolve(Policy{})
+	return err
+}
+
+// Resolve returns the config as a Policy, with anything left out taken from defaults
+func (c Config) Resolve(defaults Policy) (Policy, error) {
+	p := defaults
+	switch strings.ToLower(c.Policy) {
+	case "":
+	case Exponential:
+		p.Jitter = false
+	case Jittered:
+		p.Jitter = true
+	default:
+		return p, fmt.Errorf("%w: unknown policy %q", ErrInvalidConfig, c.Policy)
+	}
+	if c.Attempts < 0 || c.BreakerFailures < 0 {
+		return p, fmt.Errorf("%w: attempts and breaker_failures can't be negative", ErrInvalidConfig)
+	}
+	if c.Attempts > 0 {
+		p.Attempts = c.Attempts
+	}
+	if c.BreakerFailures > 0 {
+		p.BreakerFailures = c.BreakerFailures
+	}
+	for _, d := range []struct {
+		value string
+		to    *time.Duration
+	}{{c.Delay, &p.Delay}, {c.MaxDelay, &p.MaxDelay}, {c.Budget, &p.Budget}, {c.BreakerOpen, &p.BreakerOpen}} {
+		if len(d.value) == 0 {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil || parsed < 0 {
+			return p, fmt.Errorf("%w: %q isn't a duration", ErrInvalidConfig, d.value)
+		}
+		*d.to = parsed
+	}
+	return p, nil
+}
+
+// Policy is a Config with its durations parsed
+type Policy struct {
+	Jitter          bool
+	Attempts        int
+	Delay           time.Duration
+	MaxDelay        time.Duration
+	Budget          time.Duration
+	BreakerFailures int
+	BreakerOpen     time.Duration
+}
+
+// Backoff is the wait before retry n, counting from 1
+func (p Policy) Backoff(n int) time.Duration {
+	d := p.Delay
+	for i := 1; i < n && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 {
+		d = min(d, p.MaxDelay)
+	}
+	if p.Jitter && d > 1 {
+		d = d/2 + rand.N(d/2+1)
+	}
+	return d
+}
+
+// Retrier retries operations with a policy. It's safe to use from more than one goroutine, and
+// a nil Retrier tries each operation once.
+type Retrier struct {
+	name     string
+	policy   Policy
+	mu       sync.Mutex
+	breakers map[string]*breaker
+	retries  atomic.Int64
+	opens    atomic.Int64
+}
+
+// breaker counts the operations on a key which have failed in a row. It's open until openUntil.
+type breaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*Retrier{}
+)
+
+// New returns a Retrier with the policy. A Retrier with a name is reported by All, in place of
+// any earlier one with the same name.
+func New(name string, policy Policy) *Retrier {
+	r := &Retrier{name: name, policy: policy, breakers: map[string]*breaker{}}
+	if len(name) > 0 {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		registry[name] = r
+	}
+	return r
+}
+
+// Policy is the Retrier's policy
+func (r *Retrier) Policy() Policy {
+	if r == nil {
+		return Policy{}
+	}
+	return r.policy
+}
+
+// Do runs op, and runs it again while it returns an error which retryable accepts and the
+// policy allows another attempt. attempt counts from zero. It returns ErrCircuitOpen without
+// running op if the breaker for key is open, and op failing after its last attempt counts
+// towards opening it.
+func (r *Retrier) Do(key string, retryable func(error) bool, op func(attempt int) error) error {
+	if r == nil {
+		return op(0)
+	}
+	if err := r.Allow(key); err != nil {
+		return err
+	}
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		err := op(attempt)
+		if err == nil {
+			r.Succeeded(key)
+			return nil
+		}
+		if !retryable(err) {
+			return err
+		}
+		wait, ok := r.Retry(attempt+1, waited)
+		if !ok {
+			r.Failed(key)
+			return err
+		}
+		logger.Info("Retrying", "retrier", r.name, "key", key, "attempt", attempt+1, "wait", wait, "error", err)
+		util.AppClock.Sleep(wait)
+		waited += wait
+	}
+}
+
+// Retry returns the wait before retry n of an operation, counting from 1, which has already
+// waited for `waited`. It returns false if the policy's attempts or budget don't allow it.
+// Allowed retries are counted in the stats, so callers which retry for themselves, like the
+// scheduler, use it instead of Do.
+func (r *Retrier) Retry(n int, waited time.Duration) (time.Duration, bool) {
+	if r == nil || n >= r.policy.Attempts {
+		return 0, false
+	}
+	wait := r.policy.Backoff(n)
+	if r.policy.Budget > 0 && waited+wait > r.policy.Budget {
+		return 0, false
+	}
+	r.retries.Add(1)
+	return wait, true
+}
+
+// Allow returns ErrCircuitOpen if the breaker for key is open. Once it's been open for the
+// policy's BreakerOpen, operations are let through again, but the breaker opens again after the
+// first one which fails.
+func (r *Retrier) Allow(key string) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b, ok := r.breakers[key]; ok && util.AppClock.Now().Before(b.openUntil) {
+		return fmt.Errorf("%w for %v until %v", ErrCircuitOpen, key, b.openUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Failed counts an operation on key which failed, and opens the breaker if there have been
+// BreakerFailures in a row. It returns when the breaker closes again, or zero if it isn't open.
+func (r *Retrier) Failed(key string) time.Time {
+	if r == nil || r.policy.BreakerFailures == 0 {
+		return time.Time{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.breakers[key]
+	if !ok {
+		b = &breaker{}
+		r.breakers[key] = b
+	}
+	b.failures++
+	if b.failures < r.policy.BreakerFailures {
+		return time.Time{}
+	}
+	b.openUntil = util.AppClock.Now().Add(r.policy.BreakerOpen)
+	r.opens.Add(1)
+	logger.Warn("Circuit breaker opened", "retrier", r.name, "key", key, "failures", b.failures, "until", b.openUntil)
+	return b.openUntil
+}
+
+// Succeeded closes the breaker for key, and starts counting its failures again
+func (r *Retrier) Succeeded(key string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.breakers[key]; ok {
+		logger.Info("Circuit breaker closed", "retrier", r.name, "key", key)
+		delete(r.breakers, key)
+	}
+}
+
+// Stats counts a Retrier's retries and the times its breakers opened
+type Stats struct {
+	Name    string `json:"name"`
+	Retries int64  `json:"retries"`
+	Opens   int64  `json:"opens"`
+	// Open lists the keys whose breakers are open now
+	Open []string `json:"open"`
+}
+
+// Stats returns the Retrier's counts
+func (r *Retrier) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := Stats{Name: r.name, Retries: r.retries.Load(), Opens: r.opens.Load(), Open: []string{}}
+	now := util.AppClock.Now()
+	for key, b := range r.breakers {
+		if now.Before(b.openUntil) {
+			stats.Open = append(stats.Open, key)
+		}
+	}
+	slices.Sort(stats.Open)
+	return stats
+}
+
+// All returns the stats of each Retrier with a name, in order of name
+func All() []Stats {
+	registryMu.Lock()
+	retriers := make([]*Retrier, 0, len(registry))
+	for _, r := range registry {
+		retriers = append(retriers, r)
+	}
+	registryMu.Unlock()
+	all := make([]Stats, 0, len(retriers))
+	for _, r := range retriers {
+		all = append(all, r.Stats())
+	}
+	slices.SortFunc(all, func(a, b Stats) int { return cmp.Compare(a.Name, b.Name) })
+	return all
+}
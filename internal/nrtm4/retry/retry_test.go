@@ -0,0 +1,124 @@
+package retry
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+var errTransient = errors.New("try again")
+
+func always(error) bool { return true }
+
+func TestDoRetriesWithBackoff(t *testing.T) {
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	start := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	clock := util.NewManualClock(start)
+	util.AppClock = clock
+
+	r := New("", Policy{Attempts: 4, Delay: time.Second, MaxDelay: 3 * time.Second})
+	attempts := []int{}
+	err := r.Do("host", always, func(attempt int) error {
+		attempts = append(attempts, attempt)
+		if attempt < 3 {
+			return errTransient
+		}
+		return nil
+	})
+	if err != nil || len(attempts) != 4 {
+		t.Error("Expected success on the fourth attempt", attempts, err)
+	}
+	// 1s, 2s, then 4s capped at 3s
+	if waited := clock.Since(start); waited != 6*time.Second {
+		t.Error("Expected to wait 6s but waited", waited)
+	}
+	if stats := r.Stats(); stats.Retries != 3 {
+		t.Error("Expected 3 retries but was", stats)
+	}
+
+	tries := 0
+	err = r.Do("host", func(err error) bool { return err == errTransient }, func(int) error {
+		tries++
+		return errors.New("permanent")
+	})
+	if err == nil || tries != 1 {
+		t.Error("Expected an error which isn't retryable to be returned straight away", tries, err)
+	}
+}
+
+func TestDoWithinBudget(t *testing.T) {
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	util.AppClock = util.NewManualClock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+
+	r := New("", Policy{Attempts: 10, Delay: time.Second, Budget: 5 * time.Second})
+	tries := 0
+	if err := r.Do("", always, func(int) error { tries++; return errTransient }); err != errTransient {
+		t.Error("Expected the last error but was", err)
+	}
+	// 1s and 2s fit in the budget, 4s more doesn't
+	if tries != 3 {
+		t.Error("Expected 3 tries within the budget but was", tries)
+	}
+	var nilRetrier *Retrier
+	tries = 0
+	nilRetrier.Do("", always, func(int) error { tries++; return errTransient })
+	if tries != 1 {
+		t.Error("Expected a nil retrier to try once but was", tries)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	clock := util.NewManualClock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	util.AppClock = clock
+
+	r := New("test-breaker", Policy{Attempts: 1, BreakerFailures: 2, BreakerOpen: time.Minute})
+	fail := func(int) error { return errTransient }
+	r.Do("a", always, fail)
+	if err := r.Do("a", always, fail); err != errTransient {
+		t.Error("Expected the second failure to be returned but was", err)
+	}
+	if err := r.Do("a", always, fail); !errors.Is(err, ErrCircuitOpen) {
+		t.Error("Expected ErrCircuitOpen but was", err)
+	}
+	if err := r.Do("b", always, func(int) error { return nil }); err != nil {
+		t.Error("Expected another key's breaker to be closed", err)
+	}
+	stats := All()
+	if len(stats) == 0 || stats[len(stats)-1].Name != "test-breaker" || stats[len(stats)-1].Opens != 1 || len(stats[len(stats)-1].Open) != 1 {
+		t.Error("Unexpected stats", stats)
+	}
+
+	clock.Advance(time.Minute)
+	if err := r.Do("a", always, fail); err != errTransient {
+		t.Error("Expected an operation to be let through once the breaker's time is up", err)
+	}
+	if err := r.Allow("a"); !errors.Is(err, ErrCircuitOpen) {
+		t.Error("Expected the breaker to open again after one more failure", err)
+	}
+	clock.Advance(time.Minute)
+	r.Do("a", always, func(int) error { return nil })
+	if err := r.Allow("a"); err != nil || r.Stats().Opens != 2 {
+		t.Error("Expected a success to close the breaker", err, r.Stats())
+	}
+}
+
+func TestResolve(t *testing.T) {
+	defaults := Policy{Attempts: 1, Delay: time.Second}
+	p, err := Config{Policy: "Jittered", Attempts: 3, MaxDelay: "30s"}.Resolve(defaults)
+	if err != nil || !p.Jitter || p.Attempts != 3 || p.Delay != time.Second || p.MaxDelay != 30*time.Second {
+		t.Error("Unexpected policy", p, err)
+	}
+	for _, c := range []Config{{Policy: "linear"}, {Attempts: -1}, {Delay: "soon"}, {BreakerOpen: "-1s"}} {
+		if err := c.Validate(); !errors.Is(err, ErrInvalidConfig) {
+			t.Error("Expected ErrInvalidConfig for", c, "but was", err)
+		}
+	}
+	for range 100 {
+		if d := (Policy{Jitter: true, Delay: 10 * time.Second}).Backoff(2); d < 10*time.Second || d > 20*time.Second {
+			t.Error("Expected a jittered delay in the second half of 20s but was", d)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package rpsl
+
+import (
+	"slices"
+	"strings"
+)
+
+// Attribute is one attribute of an RPSL object. Continuation lines are joined to the value with
+// a single space, and comments are removed.
+type Attribute struct {
+	Name  string
+	Value string
+}
+
+// AttributeChange lists the values of an attribute which were removed and added by a change.
+// Values which are in both versions are left out.
+type AttributeChange struct {
+	Name    string
+	Removed []string
+	Added   []string
+}
+
+// Attributes splits an object into its attributes, in the order they appear
+func Attributes(payload string) []Attribute {
+	attrs := []Attribute{}
+	for _, rawLine := range strings.Split(payload, "\n") {
+		if len(strings.TrimSpace(rawLine)) == 0 {
+			continue
+		}
+		isContinuation := strings.ContainsAny(rawLine[:1], " \t+")
+		line := stripComment(rawLine)
+		if isContinuation && len(attrs) > 0 {
+			line = strings.TrimSpace(strings.TrimPrefix(line, "+"))
+			if len(line) > 0 {
+				last := &attrs[len(attrs)-1]
+				last.Value = strings.TrimSpace(last.Value + " " + line)
+			}
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		attrs = append(attrs, Attribute{Name: trimToLower(name), Value: strings.TrimSpace(value)})
+	}
+	return attrs
+}
+
+// DiffAttributes compares the attributes of two versions of an object. Either can be empty, for
+// an object which was created or deleted. Only the attributes in names are compared, or all of
+// them if there are no names. Changes are in the order the attributes first appear.
+func DiffAttributes(before, after []Attribute, names ...string) []AttributeChange {
+	wanted := func(name string) bool {
+		return len(names) == 0 || slices.ContainsFunc(names, func(n string) bool {
+			return strings.EqualFold(n, name)
+		})
+	}
+	order := []string{}
+	values := func(attrs []Attribute) map[string][]string {
+		byName := map[string][]string{}
+		for _, attr := range attrs {
+			if !wanted(attr.Name) {
+				continue
+			}
+			if !slices.Contains(order, attr.Name) {
+				order = append(order, attr.Name)
+			}
+			byName[attr.Name] = append(byName[attr.Name], attr.Value)
+		}
+		return byName
+	}
+	old, cur := values(before), values(after)
+	changes := []AttributeChange{}
+	for _, name := range order {
+		removed := missingFrom(old[name], cur[name])
+		added := missingFrom(cur[name], old[name])
+		if len(removed) > 0 || len(added) > 0 {
+			changes = append(changes, AttributeChange{Name: name, Removed: removed, Added: added})
+		}
+	}
+	return changes
+}
+
+// missingFrom returns the values which aren't in other, counting repeated values separately
+func missingFrom(values, other []string) []string {
+	remaining := slices.Clone(other)
+	missing := []string{}
+	for _, v := range values {
+		if i := slices.Index(remaining, v); i >= 0 {
+			remaining = slices.Delete(remaining, i, i+1)
+		} else {
+			missing = append(missing, v)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,53 @@
+package rpsl
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAttributes(t *testing.T) {
+	str := `route:          192.0.2.0/24
+descr:          Example # comment
+                network
++               in two lines
+origin:         AS65000
+mnt-by:         EXAMPLE-MNT
+mnt-by:         OTHER-MNT
+source:         TEST
+`
+	expected := []Attribute{
+		{"route", "192.0.2.0/24"},
+		{"descr", "Example network in two lines"},
+		{"origin", "AS65000"},
+		{"mnt-by", "EXAMPLE-MNT"},
+		{"mnt-by", "OTHER-MNT"},
+		{"source", "TEST"},
+	}
+	if attrs := Attributes(str); !slices.Equal(attrs, expected) {
+		t.Error("Expected", expected, "but was", attrs)
+	}
+}
+
+func TestDiffAttributes(t *testing.T) {
+	before := Attributes("route: 192.0.2.0/24\norigin: AS65000\nmnt-by: A-MNT\nmnt-by: B-MNT\nsource: TEST")
+	after := Attributes("route: 192.0.2.0/24\norigin: AS65001\nmnt-by: B-MNT\nsource: TEST\nremarks: moved")
+	changes := DiffAttributes(before, after)
+	if len(changes) != 3 {
+		t.Fatal("Expected 3 changes but was", changes)
+	}
+	if c := changes[0]; c.Name != "origin" || !slices.Equal(c.Removed, []string{"AS65000"}) || !slices.Equal(c.Added, []string{"AS65001"}) {
+		t.Error("Unexpected origin change", c)
+	}
+	if c := changes[1]; c.Name != "mnt-by" || !slices.Equal(c.Removed, []string{"A-MNT"}) || len(c.Added) != 0 {
+		t.Error("Unexpected mnt-by change", c)
+	}
+	if c := changes[2]; c.Name != "remarks" || len(c.Removed) != 0 || !slices.Equal(c.Added, []string{"moved"}) {
+		t.Error("Unexpected remarks change", c)
+	}
+	if changes = DiffAttributes(before, after, "ORIGIN"); len(changes) != 1 || changes[0].Name != "origin" {
+		t.Error("Expected only the origin change but was", changes)
+	}
+	if changes = DiffAttributes(nil, after, "origin"); len(changes) != 1 || changes[0].Added[0] != "AS65001" {
+		t.Error("Expected origin to be added but was", changes)
+	}
+}
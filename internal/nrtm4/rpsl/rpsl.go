@@ -8,4 +8,4 @@ package rpsl
 
 import "github.com/petchells/nrtm4client/internal/nrtm4/util"
 
-var logger = util.Logger
+var logger = util.ModuleLogger("rpsl")
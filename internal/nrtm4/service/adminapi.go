@@ -0,0 +1,45 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrInvalidAdminAPI the admin API settings in the config file can't be used
+var ErrInvalidAdminAPI = errors.New("invalid admin_api settings")
+
+// AdminAPIConfig guards nrtm4serve's /api/v1 endpoints, which can start updates, so that
+// orchestration systems can sync sources without running the CLI
+type AdminAPIConfig struct {
+	// TokenFile holds the bearer token clients must send. It's read for each request, so it can
+	// be rotated by a secret store's agent. The API is disabled without it.
+	TokenFile string `json:"token_file"`
+	// Parallel is how many triggered updates run at once. Default is 4.
+	Parallel int `json:"parallel"`
+}
+
+func (c AdminAPIConfig) validate() error {
+	if c.Parallel < 0 {
+		return fmt.Errorf("%w: parallel can't be negative", ErrInvalidAdminAPI)
+	}
+	return nil
+}
+
+// Token reads the bearer token from TokenFile. It's an error for the file to be empty, so a
+// missing secret can't let every client in.
+func (c AdminAPIConfig) Token() (string, error) {
+	if len(c.TokenFile) == 0 {
+		return "", fmt.Errorf("%w: token_file isn't set", ErrInvalidAdminAPI)
+	}
+	bytes, err := os.ReadFile(c.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("reading token_file: %w", err)
+	}
+	token := strings.TrimSpace(string(bytes))
+	if len(token) == 0 {
+		return "", fmt.Errorf("%w: token_file is empty", ErrInvalidAdminAPI)
+	}
+	return token, nil
+}
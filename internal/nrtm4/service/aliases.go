@@ -0,0 +1,29 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidAlias an alias needs a one-word name and a command, which isn't another alias
+var ErrInvalidAlias = errors.New("invalid command alias")
+
+// validateAliases checks the aliases in the config file. An alias can't run another alias, so
+// expanding one never loops.
+func validateAliases(aliases map[string]string) error {
+	for name, command := range aliases {
+		fields := strings.Fields(command)
+		if len(name) == 0 || strings.HasPrefix(name, "-") || strings.ContainsFunc(name, unicode.IsSpace) {
+			return fmt.Errorf("%w: name %q must be one word", ErrInvalidAlias, name)
+		}
+		if len(fields) == 0 {
+			return fmt.Errorf("%w: %v has no command", ErrInvalidAlias, name)
+		}
+		if _, ok := aliases[fields[0]]; ok {
+			return fmt.Errorf("%w: %v runs another alias, %v", ErrInvalidAlias, name, fields[0])
+		}
+	}
+	return nil
+}
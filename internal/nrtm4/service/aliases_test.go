@@ -0,0 +1,26 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateAliases(t *testing.T) {
+	if err := validateAliases(map[string]string{"up": "update --catch-up deltas", "ls": "list"}); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	if err := validateAliases(nil); err != nil {
+		t.Error("Unexpected error for no aliases", err)
+	}
+	for _, aliases := range []map[string]string{
+		{"": "list"},
+		{"two words": "list"},
+		{"--up": "update"},
+		{"up": "  "},
+		{"up": "update", "u": "up --source RIPE"},
+	} {
+		if err := validateAliases(aliases); !errors.Is(err, ErrInvalidAlias) {
+			t.Error("Expected ErrInvalidAlias for", aliases, "but was", err)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package service
+
+import "time"
+
+// defaultAnalyzeAfter is how many delta changes an update applies before the planner statistics
+// are refreshed, when analyze_after_changes isn't set
+const defaultAnalyzeAfter = 100000
+
+// analyzeAfter is the number of changes which trigger ANALYZE, or 0 if it's turned off
+func (c AppConfig) analyzeAfter() int {
+	if c.AnalyzeAfter < 0 {
+		return 0
+	}
+	if c.AnalyzeAfter > 0 {
+		return c.AnalyzeAfter
+	}
+	return defaultAnalyzeAfter
+}
+
+// analyzeObjects refreshes the planner statistics after a snapshot is loaded or a lot of deltas
+// are applied. A failure is only logged, since the sync has already succeeded.
+func (p NRTMProcessor) analyzeObjects(sourceName, reason string, changes int64) {
+	start := time.Now()
+	if err := p.repo.AnalyzeObjects(); err != nil {
+		logger.Warn("Failed to refresh planner statistics", "source", sourceName, "error", err)
+		p.warnings.add(WarningBookkeeping, 0, "planner statistics weren't refreshed: %v", err)
+		return
+	}
+	logger.Info("Refreshed planner statistics", "source", sourceName, "after", reason, "changes", changes, "took", time.Since(start))
+}
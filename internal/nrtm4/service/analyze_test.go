@@ -0,0 +1,39 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+type analyzeRepo struct {
+	persist.Repository
+	err   error
+	calls *int
+}
+
+func (r analyzeRepo) AnalyzeObjects() error {
+	*r.calls++
+	return r.err
+}
+
+func TestAnalyzeAfter(t *testing.T) {
+	for configured, expected := range map[int]int{0: defaultAnalyzeAfter, 5000: 5000, -1: 0} {
+		if n := (AppConfig{AnalyzeAfter: configured}).analyzeAfter(); n != expected {
+			t.Error("Expected", expected, "for", configured, "but was", n)
+		}
+	}
+}
+
+func TestAnalyzeObjectsFailureIsAWarning(t *testing.T) {
+	calls := 0
+	p := NRTMProcessor{repo: analyzeRepo{err: errors.New("permission denied"), calls: &calls}, warnings: &syncWarnings{}}
+	p.analyzeObjects("TEST", "snapshot", 0)
+	if calls != 1 {
+		t.Error("Expected ANALYZE to be run once but was", calls)
+	}
+	if all := p.warnings.all(); len(all) != 1 || all[0].Kind != WarningBookkeeping {
+		t.Error("Expected a bookkeeping warning but was", all)
+	}
+}
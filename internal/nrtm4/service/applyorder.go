@@ -0,0 +1,108 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+// ErrInvalidApplyOrder apply_order has no classes, or an unknown transaction grouping
+var ErrInvalidApplyOrder = errors.New("apply_order must list classes, and transaction must be change, class or delta")
+
+const (
+	// transactionPerChange applies each change in its own transaction
+	transactionPerChange = "change"
+	// transactionPerClass applies each run of changes to one class in a transaction
+	transactionPerClass = "class"
+	// transactionPerDelta applies all of a delta's changes in one transaction
+	transactionPerDelta = "delta"
+)
+
+// ApplyOrderConfig orders the changes in each delta file by class before they're applied, for
+// embedders whose hooks keep derived tables which depend on the order, e.g. a maintainer being
+// added before the routes which refer to it. The delta's changes are held in memory until the
+// whole file has been read.
+type ApplyOrderConfig struct {
+	// Classes are applied in this order. Changes to classes which aren't listed are applied
+	// after them, in the order they're in the delta.
+	Classes []string `json:"classes"`
+	// ReverseDeletes applies deletes after the adds and modifies, in the reverse class order
+	ReverseDeletes bool `json:"reverse_deletes"`
+	// Transaction groups the changes into transactions: change (default), class or delta
+	Transaction string `json:"transaction"`
+}
+
+func (c *ApplyOrderConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Classes) == 0 || !slices.Contains([]string{"", transactionPerChange, transactionPerClass, transactionPerDelta}, c.Transaction) {
+		return fmt.Errorf("%w: %v", ErrInvalidApplyOrder, c.Transaction)
+	}
+	return nil
+}
+
+// rank is the position of a change in the apply order. Classes which aren't listed come after
+// the ones which are, and deletes are reversed when ReverseDeletes is set.
+func (c *ApplyOrderConfig) rank(change persist.DeltaChange) int {
+	rank := slices.IndexFunc(c.Classes, func(class string) bool {
+		return strings.EqualFold(class, change.Object.ObjectType)
+	})
+	if rank < 0 {
+		rank = len(c.Classes)
+	}
+	if c.ReverseDeletes && change.Action == persist.DeltaDeleteAction {
+		return 2*len(c.Classes) + 1 - rank
+	}
+	return rank
+}
+
+// order sorts a delta's changes by class. When an object is changed more than once in the
+// delta, all its changes are ranked with the first, so they're still applied in the delta's order.
+func (c *ApplyOrderConfig) order(changes []persist.DeltaChange) []persist.DeltaChange {
+	type rankedChange struct {
+		rank   int
+		change persist.DeltaChange
+	}
+	ranks := map[string]int{}
+	ranked := make([]rankedChange, len(changes))
+	for i, change := range changes {
+		key := strings.ToUpper(change.Object.ObjectType + " " + change.Object.PrimaryKey)
+		rank, ok := ranks[key]
+		if !ok {
+			rank = c.rank(change)
+			ranks[key] = rank
+		}
+		ranked[i] = rankedChange{rank, change}
+	}
+	slices.SortStableFunc(ranked, func(a, b rankedChange) int { return a.rank - b.rank })
+	ordered := make([]persist.DeltaChange, len(ranked))
+	for i, r := range ranked {
+		ordered[i] = r.change
+	}
+	return ordered
+}
+
+// groups splits ordered changes into the ones applied in each transaction
+func (c *ApplyOrderConfig) groups(changes []persist.DeltaChange) [][]persist.DeltaChange {
+	groups := [][]persist.DeltaChange{}
+	for i, change := range changes {
+		switch {
+		case i == 0 || c.Transaction == "" || c.Transaction == transactionPerChange:
+			groups = append(groups, []persist.DeltaChange{change})
+		case c.Transaction == transactionPerClass && !sameApplyClass(changes[i-1], change):
+			groups = append(groups, []persist.DeltaChange{change})
+		default:
+			groups[len(groups)-1] = append(groups[len(groups)-1], change)
+		}
+	}
+	return groups
+}
+
+func sameApplyClass(a, b persist.DeltaChange) bool {
+	return strings.EqualFold(a.Object.ObjectType, b.Object.ObjectType) &&
+		(a.Action == persist.DeltaDeleteAction) == (b.Action == persist.DeltaDeleteAction)
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/quirks"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+type orderedDeltaRepo struct {
+	saveSourceRepo
+	applied *[]string
+	groups  *[]int
+}
+
+func (r orderedDeltaRepo) AddModifyObject(source persist.NRTMSource, obj rpsl.Rpsl, file protocol.NrtmFileJSON) error {
+	*r.applied = append(*r.applied, "+"+obj.PrimaryKey)
+	*r.groups = append(*r.groups, 1)
+	return nil
+}
+
+func (r orderedDeltaRepo) DeleteObject(source persist.NRTMSource, objectType, primaryKey string, file protocol.NrtmFileJSON) error {
+	*r.applied = append(*r.applied, "-"+primaryKey)
+	*r.groups = append(*r.groups, 1)
+	return nil
+}
+
+func (r orderedDeltaRepo) ApplyDeltaChanges(source persist.NRTMSource, changes []persist.DeltaChange, file protocol.NrtmFileJSON) ([]persist.DeltaChange, error) {
+	for _, change := range changes {
+		prefix := "+"
+		if change.Action == persist.DeltaDeleteAction {
+			prefix = "-"
+		}
+		*r.applied = append(*r.applied, prefix+change.Object.PrimaryKey)
+	}
+	*r.groups = append(*r.groups, len(changes))
+	return nil, nil
+}
+
+func TestApplyOrder(t *testing.T) {
+	sessionID := "ca128382-78d9-41d1-8927-1ecef15275be"
+	source := persist.NRTMSource{Source: "EXAMPLE", SessionID: sessionID, Version: 2}
+	records := []string{
+		`{"nrtm_version": 4, "type": "delta", "source": "EXAMPLE", "session_id": "` + sessionID + `", "version": 3}`,
+		`{"action": "add_modify", "object": "route: 192.0.2.0/24\norigin: AS65000\nmnt-by: NEW-MNT\nsource: EXAMPLE\n"}`,
+		`{"action": "delete", "object_class": "mntner", "primary_key": "OLD-MNT"}`,
+		`{"action": "add_modify", "object": "aut-num: AS65000\nmnt-by: NEW-MNT\nsource: EXAMPLE\n"}`,
+		`{"action": "delete", "object_class": "route", "primary_key": "198.51.100.0/24AS65000"}`,
+		`{"action": "add_modify", "object": "mntner: NEW-MNT\nsource: EXAMPLE\n"}`,
+		`{"action": "add_modify", "object": "route: 203.0.113.0/24\norigin: AS65000\nmnt-by: NEW-MNT\nsource: EXAMPLE\n"}`,
+	}
+	for _, tc := range []struct {
+		order   *ApplyOrderConfig
+		applied []string
+		groups  []int
+	}{
+		{
+			nil,
+			[]string{"+192.0.2.0/24AS65000", "-OLD-MNT", "+AS65000", "-198.51.100.0/24AS65000", "+NEW-MNT", "+203.0.113.0/24AS65000"},
+			[]int{1, 1, 1, 1, 1, 1},
+		},
+		{
+			&ApplyOrderConfig{Classes: []string{"mntner", "route"}},
+			[]string{"-OLD-MNT", "+NEW-MNT", "+192.0.2.0/24AS65000", "-198.51.100.0/24AS65000", "+203.0.113.0/24AS65000", "+AS65000"},
+			[]int{1, 1, 1, 1, 1, 1},
+		},
+		{
+			&ApplyOrderConfig{Classes: []string{"mntner", "route"}, ReverseDeletes: true, Transaction: transactionPerClass},
+			[]string{"+NEW-MNT", "+192.0.2.0/24AS65000", "+203.0.113.0/24AS65000", "+AS65000", "-198.51.100.0/24AS65000", "-OLD-MNT"},
+			[]int{1, 2, 1, 1, 1},
+		},
+		{
+			&ApplyOrderConfig{Classes: []string{"mntner"}, Transaction: transactionPerDelta},
+			[]string{"-OLD-MNT", "+NEW-MNT", "+192.0.2.0/24AS65000", "+AS65000", "-198.51.100.0/24AS65000", "+203.0.113.0/24AS65000"},
+			[]int{6},
+		},
+	} {
+		applied, groups := []string{}, []int{}
+		repo := orderedDeltaRepo{applied: &applied, groups: &groups}
+		fn := applyDeltaFunc(repo, source, nil, tc.order, StrictnessStandard, quirks.Set{}, protocol.NotificationJSON{}, protocol.FileRefJSON{Version: 3}, deltaEventSink{}, new(protocol.DeltaFileJSON), &syncWarnings{})
+		for i, record := range records {
+			var err error
+			if i == len(records)-1 {
+				err = io.EOF
+			}
+			if err = fn([]byte(record), err); err != nil {
+				t.Fatal("Unexpected error", err)
+			}
+		}
+		if !slices.Equal(applied, tc.applied) {
+			t.Error("Expected changes to be applied in order", tc.applied, "but was", applied)
+		}
+		if !slices.Equal(groups, tc.groups) {
+			t.Error("Expected transactions", tc.groups, "but was", groups)
+		}
+	}
+}
+
+func TestApplyOrderKeepsChangesToAnObjectInOrder(t *testing.T) {
+	order := &ApplyOrderConfig{Classes: []string{"mntner", "route"}, ReverseDeletes: true}
+	changes := []persist.DeltaChange{
+		{Action: persist.DeltaDeleteAction, Object: rpsl.Rpsl{ObjectType: "mntner", PrimaryKey: "EXAMPLE-MNT"}},
+		{Action: persist.DeltaAddModifyAction, Object: rpsl.Rpsl{ObjectType: "ROUTE", PrimaryKey: "192.0.2.0/24AS65000"}},
+		{Action: persist.DeltaAddModifyAction, Object: rpsl.Rpsl{ObjectType: "MNTNER", PrimaryKey: "EXAMPLE-MNT"}},
+	}
+	ordered := order.order(changes)
+	if ordered[0].Action != persist.DeltaAddModifyAction || ordered[1].Action != persist.DeltaDeleteAction || ordered[2].Action != persist.DeltaAddModifyAction || ordered[2].Object.ObjectType != "MNTNER" {
+		t.Error("Expected the maintainer to be deleted then added, after the route, but was", ordered)
+	}
+	if err := (&ApplyOrderConfig{Classes: []string{"mntner"}, Transaction: "run"}).validate(); err == nil {
+		t.Error("Expected an unknown transaction grouping to be invalid")
+	}
+}
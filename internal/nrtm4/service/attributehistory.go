@@ -0,0 +1,94 @@
+package service
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+// AttributeHistoryEntry is a change to the attributes of the objects with a key, at a version.
+// Applied is when the client applied the version, or zero if it doesn't know.
+type AttributeHistoryEntry struct {
+	Version    uint32
+	Applied    time.Time
+	ObjectType string
+	Changes    []rpsl.AttributeChange
+}
+
+// AttributeHistory reports when the attributes of the objects with a primary key changed, and
+// what their values were before. Only the attributes in attrs are compared, or all of them if
+// there are none. A route prefix without an origin follows all the routes for the prefix, so
+// a change of origin shows up as one.
+func (p NRTMProcessor) AttributeHistory(sourceName, label, key string, attrs []string) ([]AttributeHistoryEntry, error) {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return nil, ErrSourceNotFound
+	}
+	history, err := p.repo.GetObjectHistory(*source, key)
+	if err != nil {
+		return nil, err
+	}
+	return diffObjectHistory(history, attrs), nil
+}
+
+// diffObjectHistory works through the versions at which objects of each type were created,
+// replaced or deleted, comparing the attributes of all the objects which existed before and
+// after each one
+func diffObjectHistory(history []persist.ObjectVersion, attrs []string) []AttributeHistoryEntry {
+	byType := map[string][]persist.ObjectVersion{}
+	types := []string{}
+	for _, ov := range history {
+		if _, ok := byType[ov.ObjectType]; !ok {
+			types = append(types, ov.ObjectType)
+		}
+		byType[ov.ObjectType] = append(byType[ov.ObjectType], ov)
+	}
+	entries := []AttributeHistoryEntry{}
+	for _, objectType := range types {
+		versions := byType[objectType]
+		applied := map[uint32]time.Time{}
+		points := []uint32{}
+		for _, ov := range versions {
+			points = append(points, ov.FromVersion)
+			applied[ov.FromVersion] = ov.FromTime
+			if ov.ToVersion > 0 {
+				points = append(points, ov.ToVersion)
+				applied[ov.ToVersion] = ov.ToTime
+			}
+		}
+		slices.Sort(points)
+		points = slices.Compact(points)
+		before := []rpsl.Attribute{}
+		for _, version := range points {
+			after := attributesAt(versions, version)
+			if changes := rpsl.DiffAttributes(before, after, attrs...); len(changes) > 0 {
+				entries = append(entries, AttributeHistoryEntry{
+					Version:    version,
+					Applied:    applied[version],
+					ObjectType: objectType,
+					Changes:    changes,
+				})
+			}
+			before = after
+		}
+	}
+	slices.SortStableFunc(entries, func(a, b AttributeHistoryEntry) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return entries
+}
+
+// attributesAt is the attributes of every object which existed at a version
+func attributesAt(versions []persist.ObjectVersion, version uint32) []rpsl.Attribute {
+	attrs := []rpsl.Attribute{}
+	for _, ov := range versions {
+		if ov.FromVersion <= version && (ov.ToVersion == 0 || ov.ToVersion > version) {
+			attrs = append(attrs, rpsl.Attributes(ov.RPSL)...)
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+func TestDiffObjectHistoryFollowsRoutePrefix(t *testing.T) {
+	history := []persist.ObjectVersion{
+		{
+			ObjectType: "ROUTE", PrimaryKey: "192.0.2.0/24AS65000", FromVersion: 10, ToVersion: 20,
+			RPSL: "route: 192.0.2.0/24\norigin: AS65000\nmnt-by: A-MNT\nsource: TEST",
+		},
+		{
+			ObjectType: "ROUTE", PrimaryKey: "192.0.2.0/24AS65000", FromVersion: 20, ToVersion: 30,
+			RPSL: "route: 192.0.2.0/24\norigin: AS65000\nmnt-by: B-MNT\nsource: TEST",
+		},
+		{
+			ObjectType: "ROUTE", PrimaryKey: "192.0.2.0/24AS65001", FromVersion: 30,
+			RPSL: "route: 192.0.2.0/24\norigin: AS65001\nmnt-by: B-MNT\nsource: TEST",
+		},
+	}
+	entries := diffObjectHistory(history, []string{"origin"})
+	if len(entries) != 2 {
+		t.Fatal("Expected 2 changes to origin but was", entries)
+	}
+	if e := entries[0]; e.Version != 10 || !slices.Equal(e.Changes[0].Added, []string{"AS65000"}) {
+		t.Error("Expected origin to be set at version 10 but was", e)
+	}
+	c := entries[1].Changes[0]
+	if entries[1].Version != 30 || !slices.Equal(c.Removed, []string{"AS65000"}) || !slices.Equal(c.Added, []string{"AS65001"}) {
+		t.Error("Expected origin to change at version 30 but was", entries[1])
+	}
+
+	entries = diffObjectHistory(history, []string{"mnt-by"})
+	if len(entries) != 2 || entries[1].Version != 20 || entries[1].Changes[0].Removed[0] != "A-MNT" {
+		t.Error("Expected mnt-by to change at version 20 but was", entries)
+	}
+}
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+var (
+	// ErrAuditLogNotConfigured there is no audit log path in the config
+	ErrAuditLogNotConfigured = errors.New("audit log is not configured")
+	// ErrAuditKeyInvalid the key file does not hold a hex-encoded ed25519 seed
+	ErrAuditKeyInvalid = errors.New("audit key must be a hex-encoded 32 byte ed25519 seed")
+)
+
+// AuditConfig where the audit log is written, and the key it is signed with
+type AuditConfig struct {
+	Path string `json:"path"`
+	// KeyFile holds a hex-encoded ed25519 seed. Entries are not signed if it's empty.
+	KeyFile string `json:"key_file"`
+}
+
+// AuditEntry records one file applied to the repo. Hash covers the previous entry's hash,
+// so changing or removing an entry breaks the chain from there on.
+type AuditEntry struct {
+	Timestamp string `json:"timestamp"`
+	Source    string `json:"source"`
+	Label     string `json:"label"`
+	SessionID string `json:"session_id"`
+	Version   uint32 `json:"version"`
+	FileType  string `json:"file_type"`
+	FileHash  string `json:"file_hash"`
+	PrevHash  string `json:"prev_hash"`
+	Hash      string `json:"hash"`
+	Signature string `json:"signature,omitempty"`
+}
+
+func (e AuditEntry) calcHash() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		e.PrevHash,
+		e.Timestamp,
+		e.Source,
+		e.Label,
+		e.SessionID,
+		fmt.Sprint(e.Version),
+		e.FileType,
+		e.FileHash,
+	}, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func readAuditKey(path string) (ed25519.PrivateKey, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(bytes)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, ErrAuditKeyInvalid
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func readAuditEntries(path string) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return entries, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// auditHeadSuffix names the file next to the audit log which holds its size and last hash, so
+// an append doesn't have to read the whole log
+const auditHeadSuffix = ".head"
+
+// lastAuditHash is the hash of the log's last entry. It's read from the head file when that
+// was written at the log's current size, otherwise from the log, e.g. after it's been rotated.
+func lastAuditHash(path string) (string, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if head, err := os.ReadFile(path + auditHeadSuffix); err == nil {
+		var size int64
+		var hash string
+		if _, err = fmt.Sscan(string(head), &size, &hash); err == nil && size == info.Size() {
+			return hash, nil
+		}
+	}
+	entries, err := readAuditEntries(path)
+	if err != nil || len(entries) == 0 {
+		return "", err
+	}
+	return entries[len(entries)-1].Hash, nil
+}
+
+// appendAuditEntry chains a new entry onto the log, and signs it if there's a key
+func appendAuditEntry(config AuditConfig, entry AuditEntry) error {
+	prevHash, err := lastAuditHash(config.Path)
+	if err != nil {
+		return err
+	}
+	entry.PrevHash = prevHash
+	entry.Hash = entry.calcHash()
+	if len(config.KeyFile) > 0 {
+		key, err := readAuditKey(config.KeyFile)
+		if err != nil {
+			return err
+		}
+		entry.Signature = hex.EncodeToString(ed25519.Sign(key, []byte(entry.Hash)))
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err == nil {
+		err = os.WriteFile(config.Path+auditHeadSuffix, fmt.Appendf(nil, "%d %s\n", info.Size(), entry.Hash), 0600)
+	}
+	if err != nil {
+		// The next append reads the hash from the log instead
+		logger.Warn("Failed to write audit log head", "path", config.Path+auditHeadSuffix, "error", err)
+	}
+	return nil
+}
+
+// verifyAuditEntries checks the hash chain and, if a public key is given, the signatures.
+// It returns the number of entries which were verified before an error was found.
+func verifyAuditEntries(entries []AuditEntry, publicKey ed25519.PublicKey) (int, error) {
+	prevHash := ""
+	for i, entry := range entries {
+		if entry.PrevHash != prevHash {
+			return i, fmt.Errorf("audit entry %d does not follow the previous entry", i+1)
+		}
+		if entry.calcHash() != entry.Hash {
+			return i, fmt.Errorf("audit entry %d has been modified", i+1)
+		}
+		if publicKey != nil {
+			sig, err := hex.DecodeString(entry.Signature)
+			if err != nil || !ed25519.Verify(publicKey, []byte(entry.Hash), sig) {
+				return i, fmt.Errorf("audit entry %d has an invalid signature", i+1)
+			}
+		}
+		prevHash = entry.Hash
+	}
+	return len(entries), nil
+}
+
+func (p NRTMProcessor) auditAppliedFile(source persist.NRTMSource, sessionID string, fileType persist.NTRMFileType, ref protocol.FileRefJSON) {
+	if len(p.config.Audit.Path) == 0 {
+		return
+	}
+	entry := AuditEntry{
+		Timestamp: util.AppClock.Now().Format(time.RFC3339),
+		Source:    source.Source,
+		Label:     source.Label,
+		SessionID: sessionID,
+		Version:   ref.Version,
+		FileType:  fileType.String(),
+		FileHash:  ref.Hash,
+	}
+	if err := appendAuditEntry(p.config.Audit, entry); err != nil {
+		logger.Error("Failed to write audit log entry", "path", p.config.Audit.Path, "error", err)
+	}
+}
+
+// VerifyAuditLog checks the audit log hasn't been tampered with and returns the number of
+// entries in it
+func (p NRTMProcessor) VerifyAuditLog() (int, error) {
+	if len(p.config.Audit.Path) == 0 {
+		return 0, ErrAuditLogNotConfigured
+	}
+	entries, err := readAuditEntries(p.config.Audit.Path)
+	if err != nil {
+		return 0, err
+	}
+	var publicKey ed25519.PublicKey
+	if len(p.config.Audit.KeyFile) > 0 {
+		key, err := readAuditKey(p.config.Audit.KeyFile)
+		if err != nil {
+			return 0, err
+		}
+		publicKey = key.Public().(ed25519.PublicKey)
+	}
+	return verifyAuditEntries(entries, publicKey)
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+func TestAuditLogChain(t *testing.T) {
+	dir, err := os.MkdirTemp("", "nrtm4audit")
+	if err != nil {
+		t.Fatal("Failed to create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "audit.key")
+	seed := strings.Repeat("ab", ed25519.SeedSize)
+	if err = os.WriteFile(keyFile, []byte(seed+"\n"), 0600); err != nil {
+		t.Fatal("Failed to write key", err)
+	}
+	p := NRTMProcessor{config: AppConfig{Audit: AuditConfig{Path: filepath.Join(dir, "audit.log"), KeyFile: keyFile}}}
+	source := persist.NRTMSource{Source: "EXAMPLE"}
+	for v := uint32(1); v <= 3; v++ {
+		p.auditAppliedFile(source, "ca128382-78d9-41d1-8927-1ecef15275be", persist.DeltaFile, protocol.FileRefJSON{Version: v, Hash: "abcd"})
+	}
+	n, err := p.VerifyAuditLog()
+	if err != nil || n != 3 {
+		t.Fatal("Expected 3 verified entries but was", n, err)
+	}
+
+	entries, _ := readAuditEntries(p.config.Audit.Path)
+	entries[1].Version = 7
+	if n, err = verifyAuditEntries(entries, nil); err == nil || n != 1 {
+		t.Error("Expected modified entry to be detected", n, err)
+	}
+	entries, _ = readAuditEntries(p.config.Audit.Path)
+	entries = append(entries[:1], entries[2:]...)
+	if n, err = verifyAuditEntries(entries, nil); err == nil || n != 1 {
+		t.Error("Expected removed entry to be detected", n, err)
+	}
+	entries, _ = readAuditEntries(p.config.Audit.Path)
+	otherKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	if _, err = verifyAuditEntries(entries, otherKey.Public().(ed25519.PublicKey)); err == nil {
+		t.Error("Expected signature check to fail with another key")
+	}
+}
+
+func TestAuditLogHead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	config := AuditConfig{Path: path}
+	for v := uint32(1); v <= 2; v++ {
+		if err := appendAuditEntry(config, AuditEntry{Source: "EXAMPLE", Version: v}); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	entries, _ := readAuditEntries(path)
+	head, err := os.ReadFile(path + auditHeadSuffix)
+	if err != nil || !strings.HasSuffix(strings.TrimSpace(string(head)), entries[1].Hash) {
+		t.Fatal("Expected the head to have the last hash", string(head), err)
+	}
+
+	// A head which doesn't match the log's size is ignored
+	if err = os.WriteFile(path+auditHeadSuffix, []byte("1 stale\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = appendAuditEntry(config, AuditEntry{Source: "EXAMPLE", Version: 3}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	// So is a missing one
+	os.Remove(path + auditHeadSuffix)
+	if err = appendAuditEntry(config, AuditEntry{Source: "EXAMPLE", Version: 4}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	entries, _ = readAuditEntries(path)
+	if n, err := verifyAuditEntries(entries, nil); err != nil || n != 4 {
+		t.Error("Expected the chain to be intact but was", n, err)
+	}
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/jsonseq"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+// CatchUpMode says how an update applies a backlog of deltas
+type CatchUpMode string
+
+const (
+	// CatchUpAuto loads the latest snapshot instead of the deltas when it's estimated to be faster
+	CatchUpAuto CatchUpMode = "auto"
+	// CatchUpDeltas always applies each delta
+	CatchUpDeltas CatchUpMode = "deltas"
+	// CatchUpSnapshot loads the latest snapshot whenever it's newer than the source
+	CatchUpSnapshot CatchUpMode = "snapshot"
+)
+
+// ErrInvalidCatchUpMode the catch-up mode isn't one of auto, deltas or snapshot
+var ErrInvalidCatchUpMode = errors.New("catch-up mode must be auto, deltas or snapshot")
+
+// defaultCatchUpWindow is how many deltas can be pending before loading the snapshot is
+// considered
+const defaultCatchUpWindow = 100
+
+// snapshotShortcutMargin is how much faster loading the snapshot has to be estimated to be. The
+// deltas are preferred when it's close, since the shortcut loses the versions in between.
+const snapshotShortcutMargin = 0.8
+
+// ParseCatchUpMode reads a catch-up mode. An empty string is CatchUpAuto.
+func ParseCatchUpMode(s string) (CatchUpMode, error) {
+	switch mode := CatchUpMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return CatchUpAuto, nil
+	case CatchUpAuto, CatchUpDeltas, CatchUpSnapshot:
+		return mode, nil
+	}
+	return CatchUpAuto, fmt.Errorf("%w: %v", ErrInvalidCatchUpMode, s)
+}
+
+// catchUpEstimate is how much each way of catching up downloads, and how long it's expected
+// to take
+type catchUpEstimate struct {
+	Pending       int
+	DeltaBytes    int64
+	DeltaTime     time.Duration
+	SnapshotBytes int64
+	SnapshotTime  time.Duration
+}
+
+// estimateCatchUp estimates how long it takes to apply pending deltas, and to load the snapshot
+// then apply the deltas published after it, from the throughput of recent files. Notification
+// files don't give file sizes, so the mean size of recent deltas and the size of the last
+// snapshot stand in for them. It returns false if there's no history to estimate from.
+func estimateCatchUp(pending, afterSnapshot int, stats persist.ApplyStats) (catchUpEstimate, bool) {
+	est := catchUpEstimate{Pending: pending}
+	if stats.Deltas == 0 || stats.DeltaBytes <= 0 || stats.DeltaTime <= 0 ||
+		stats.SnapshotBytes <= 0 || stats.SnapshotTime <= 0 {
+		return est, false
+	}
+	deltaRate := float64(stats.DeltaBytes) / stats.DeltaTime.Seconds()
+	snapshotRate := float64(stats.SnapshotBytes) / stats.SnapshotTime.Seconds()
+	meanDelta := float64(stats.DeltaBytes) / float64(stats.Deltas)
+	est.DeltaBytes = int64(float64(pending) * meanDelta)
+	est.DeltaTime = seconds(float64(est.DeltaBytes) / deltaRate)
+	est.SnapshotBytes = stats.SnapshotBytes + int64(float64(afterSnapshot)*meanDelta)
+	est.SnapshotTime = seconds(float64(stats.SnapshotBytes)/snapshotRate + float64(afterSnapshot)*meanDelta/deltaRate)
+	return est, true
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// useSnapshotShortcut decides whether an update loads the notification's snapshot instead of
+// applying all the deltas since the source's version
+func (p NRTMProcessor) useSnapshotShortcut(notification protocol.NotificationJSON, source persist.NRTMSource) bool {
+	snapshotVersion := notification.SnapshotRef.Version
+	if p.catchUp == CatchUpDeltas || snapshotVersion <= source.Version {
+		return false
+	}
+	if p.catchUp == CatchUpAuto && p.checkFileExpiry(notification.SnapshotRef) != nil {
+		logger.Info("Not loading the snapshot, since it has expired", "source", source.Source, "expires", notification.SnapshotRef.Expires)
+		return false
+	}
+	if p.catchUp == CatchUpSnapshot {
+		return true
+	}
+	pending := int(notification.Version - source.Version)
+	window := p.config.CatchUpWindow
+	if window <= 0 {
+		window = defaultCatchUpWindow
+	}
+	if pending <= window {
+		return false
+	}
+	if cfg := p.config.sourceConfig(source.Source).Publish; cfg != nil && len(cfg.URL) > 0 {
+		logger.Info("Not loading the snapshot, since delta events are published", "source", source.Source, "pending", pending)
+		return false
+	}
+	stats, err := p.repo.GetApplyStats(source)
+	if err != nil {
+		logger.Warn("Cannot read apply times", "source", source.Source, "error", err)
+		return false
+	}
+	est, ok := estimateCatchUp(pending, int(notification.Version-snapshotVersion), stats)
+	if !ok {
+		logger.Info("Not enough history to estimate catch-up time", "source", source.Source, "pending", pending)
+		return false
+	}
+	shortcut := est.SnapshotTime.Seconds() < est.DeltaTime.Seconds()*snapshotShortcutMargin
+	logger.Info("Estimated catch-up time",
+		"source", source.Source,
+		"pending", pending,
+		"deltaBytes", est.DeltaBytes,
+		"deltaTime", est.DeltaTime.Round(time.Second),
+		"snapshotBytes", est.SnapshotBytes,
+		"snapshotTime", est.SnapshotTime.Round(time.Second),
+		"useSnapshot", shortcut,
+	)
+	return shortcut
+}
+
+// catchUpFromSnapshot loads the notification's snapshot over the source's objects. Deltas after
+// the snapshot are applied as usual by syncDeltas.
+func (p NRTMProcessor) catchUpFromSnapshot(notification protocol.NotificationJSON, source persist.NRTMSource) (persist.NRTMSource, error) {
+	ref := notification.SnapshotRef
+	logger.Info("Catching up from snapshot", "source", source.Source, "from", source.Version, "to", ref.Version)
+	start := time.Now()
+	if err := p.checkFileExpiry(ref); err != nil {
+		return source, err
+	}
+	fm := fileManager{client: p.client, warnings: p.warnings, maxRecordSize: p.config.maxObjectSize(), progress: p.progress, maxFileSize: p.config.MaxFileSize}
+	snapshotURL, err := resolveFileURL(source.NotificationURL, ref.URL, p.config.StrictFileURLs)
+	if err != nil {
+		return source, err
+	}
+	p.progress.stage(StageDownload, snapshotURL, ref.Version, 0, 1)
+	file, err := fm.fetchFileAndCheckHash(snapshotURL, ref, p.config.NRTMFilePath, p.config.TempDir)
+	if err != nil {
+		return source, err
+	}
+	defer file.Close()
+	readRecords := func(fn jsonseq.RecordReaderFunc) error {
+		return fm.readJSONSeqRecords(file, p.quarantineOversized(source, ref.Version, fn))
+	}
+	p.progress.stage(StageSnapshot, snapshotURL, ref.Version, 0, 1)
+	header := new(protocol.SnapshotFileJSON)
+	changes, err := p.repo.ApplySnapshot(source, ref.Version, snapshotLoader(readRecords, ref.Version, header, p.sourceFilter(source), p.config.sourceQuirks(source.Source)))
+	if err != nil {
+		return source, err
+	}
+	logger.Info("Caught up from snapshot", "source", source.Source, "version", ref.Version,
+		"added", changes.Added, "modified", changes.Modified, "deleted", changes.Deleted)
+	if p.config.analyzeAfter() > 0 {
+		p.analyzeObjects(source.Source, "snapshot", int64(changes.Added+changes.Modified+changes.Deleted))
+	}
+	p.warnings.add(WarningSnapshotShortcut, ref.Version, "loaded the snapshot instead of applying deltas %d to %d",
+		source.Version+1, ref.Version)
+	source.Version = ref.Version
+	if source, err = p.repo.SaveSource(source, notification); err != nil {
+		return source, err
+	}
+	p.auditAppliedFile(source, notification.SessionID, persist.SnapshotFile, ref)
+	if err = p.repo.SaveFile(&persist.NRTMFile{
+		Version:      ref.Version,
+		Type:         persist.SnapshotFile,
+		URL:          snapshotURL,
+		FileName:     filepath.Base(file.Name()),
+		Hash:         ref.Hash,
+		Header:       header.Raw,
+		Size:         fileSize(file),
+		ApplyTime:    time.Since(start),
+		NrtmSourceID: source.ID,
+		RunID:        p.runID,
+	}); err != nil {
+		logger.Warn("Failed to record snapshot file", "error", err)
+		p.warnings.add(WarningBookkeeping, ref.Version, "snapshot file was loaded but not recorded: %v", err)
+	}
+	return source, p.journalChanges(source, ref.Version, ref.Version)
+}
+
+// fileSize is zero if the file can't be stat'ed, which means the size wasn't measured
+func fileSize(file *os.File) int64 {
+	info, err := file.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/pg"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/quirks"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+	"github.com/petchells/nrtm4client/internal/nrtm4/testresources"
+)
+
+type catchUpRepo struct {
+	persist.Repository
+	stats persist.ApplyStats
+}
+
+func (r catchUpRepo) GetApplyStats(source persist.NRTMSource) (persist.ApplyStats, error) {
+	return r.stats, nil
+}
+
+// keyHook records the type and key of each object the repository gives the hooks
+type keyHook struct {
+	keys *[]string
+}
+
+func (h keyHook) SnapshotObjectsSaved(tx pgx.Tx, source persist.NRTMSource, objects []rpsl.Rpsl, file protocol.NrtmFileJSON) error {
+	for _, obj := range objects {
+		*h.keys = append(*h.keys, obj.ObjectType+" "+obj.PrimaryKey)
+	}
+	return nil
+}
+
+func (h keyHook) ObjectAdded(tx pgx.Tx, source persist.NRTMSource, object rpsl.Rpsl, file protocol.NrtmFileJSON) error {
+	*h.keys = append(*h.keys, object.ObjectType+" "+object.PrimaryKey)
+	return nil
+}
+
+func (h keyHook) ObjectDeleted(tx pgx.Tx, source persist.NRTMSource, objectType, primaryKey string, file protocol.NrtmFileJSON) error {
+	*h.keys = append(*h.keys, objectType+" "+primaryKey)
+	return nil
+}
+
+func TestApplySnapshotWithQuirks(t *testing.T) {
+	testresources.SetEnvVarsFromEnvTestFile(t)
+	keys := []string{}
+	repo := pg.PostgresRepository{Hooks: []pg.ObjectHook{keyHook{&keys}}}
+	if err := repo.Initialize(os.Getenv("PG_DATABASE_URL")); err != nil {
+		t.Fatal("Failed to initialize repository", err)
+	}
+	testresources.TruncateDatabase(t)
+	source, err := repo.SaveSource(persist.NRTMSource{
+		Source:          "EXAMPLE",
+		SessionID:       "ca128382-78d9-41d1-8927-1ecef15275be",
+		Version:         1,
+		NotificationURL: "https://example.net/nrtmv4/EXAMPLE/update-notification-file.json",
+	}, protocol.NotificationJSON{})
+	if err != nil {
+		t.Fatal("Failed to save source", err)
+	}
+	objectQuirks, _ := quirks.ForSource("EXAMPLE", []string{"quoted-values", "missing-source"})
+	load := snapshotLoader(snapshotJSONSeq("3", `route: \"192.0.2.0/24\"\norigin: \"AS65000\"\n`), 3, new(protocol.SnapshotFileJSON), nil, objectQuirks)
+	changes, err := repo.ApplySnapshot(source, 3, load)
+	if err != nil {
+		t.Fatal("Failed to apply snapshot", err)
+	}
+	if changes.Added != 1 || len(keys) != 1 || keys[0] != "ROUTE 192.0.2.0/24AS65000" {
+		t.Error("Expected the hook to be given the key the quirks parsed", changes, keys)
+	}
+}
+
+func TestParseCatchUpMode(t *testing.T) {
+	for s, expect := range map[string]CatchUpMode{"": CatchUpAuto, "auto": CatchUpAuto, "Deltas": CatchUpDeltas, " snapshot": CatchUpSnapshot} {
+		if mode, err := ParseCatchUpMode(s); err != nil || mode != expect {
+			t.Error("Expected", expect, "for", s, "but was", mode, err)
+		}
+	}
+	if _, err := ParseCatchUpMode("fastest"); !errors.Is(err, ErrInvalidCatchUpMode) {
+		t.Error("Expected ErrInvalidCatchUpMode but was", err)
+	}
+}
+
+func TestEstimateCatchUp(t *testing.T) {
+	// 100 deltas of 10kB took 50s, a 200MB snapshot took 100s
+	stats := persist.ApplyStats{
+		Deltas:        100,
+		DeltaBytes:    1_000_000,
+		DeltaTime:     50 * time.Second,
+		SnapshotBytes: 200_000_000,
+		SnapshotTime:  100 * time.Second,
+	}
+	est, ok := estimateCatchUp(1000, 10, stats)
+	if !ok {
+		t.Fatal("Expected an estimate")
+	}
+	if est.DeltaBytes != 10_000_000 || est.DeltaTime != 500*time.Second {
+		t.Error("Unexpected delta estimate", est.DeltaBytes, est.DeltaTime)
+	}
+	if est.SnapshotBytes != 200_100_000 || est.SnapshotTime != 105*time.Second {
+		t.Error("Unexpected snapshot estimate", est.SnapshotBytes, est.SnapshotTime)
+	}
+	stats.SnapshotTime = 0
+	if _, ok = estimateCatchUp(1000, 10, stats); ok {
+		t.Error("Expected no estimate without a snapshot time")
+	}
+}
+
+func TestUseSnapshotShortcut(t *testing.T) {
+	stats := persist.ApplyStats{
+		Deltas:        100,
+		DeltaBytes:    1_000_000,
+		DeltaTime:     50 * time.Second,
+		SnapshotBytes: 200_000_000,
+		SnapshotTime:  100 * time.Second,
+	}
+	p := NRTMProcessor{repo: catchUpRepo{stats: stats}, catchUp: CatchUpAuto}
+	source := persist.NRTMSource{Source: "TEST", Version: 1000}
+	notification := protocol.NotificationJSON{
+		NrtmFileJSON: protocol.NrtmFileJSON{Version: 2000},
+		SnapshotRef:  protocol.FileRefJSON{Version: 1990},
+	}
+	if !p.useSnapshotShortcut(notification, source) {
+		t.Error("Expected the snapshot to be used for 1000 pending deltas")
+	}
+	notification.Version = 1050
+	notification.SnapshotRef.Version = 1040
+	if p.useSnapshotShortcut(notification, source) {
+		t.Error("Expected the deltas to be used inside the catch-up window")
+	}
+	p.catchUp = CatchUpSnapshot
+	if !p.useSnapshotShortcut(notification, source) {
+		t.Error("Expected the snapshot to be used when it's forced")
+	}
+	notification.SnapshotRef.Version = 900
+	if p.useSnapshotShortcut(notification, source) {
+		t.Error("Expected the deltas to be used when the snapshot is older than the source")
+	}
+
+	p.catchUp = CatchUpAuto
+	notification.Version = 2000
+	notification.SnapshotRef.Version = 1990
+	p.config.Sources = map[string]SourceConfig{"test": {Publish: &PublishConfig{URL: "nats://localhost:4222"}}}
+	if p.useSnapshotShortcut(notification, source) {
+		t.Error("Expected the deltas to be used when delta events are published")
+	}
+	p.config.Sources = nil
+	p.catchUp = CatchUpDeltas
+	if p.useSnapshotShortcut(notification, source) {
+		t.Error("Expected the deltas to be used when they're forced")
+	}
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// Measures of a source's rate of change
+const (
+	DeltasPerHour   = "deltas per hour"
+	ObjectsPerDelta = "objects per delta"
+)
+
+var (
+	// changeRateBaseline is how much history a source's normal rate of change is learned from
+	changeRateBaseline = 7 * 24 * time.Hour
+	// changeRateWindow is the recent period whose delta rate is compared with the baseline
+	changeRateWindow = time.Hour
+	// changeRateThreshold is how many standard deviations above normal counts as anomalous
+	changeRateThreshold = 4.0
+	// changeRateMinDeltas is how many deltas the baseline needs before anything is flagged
+	changeRateMinDeltas = 50
+)
+
+// ChangeAnomaly is a burst of changes well above what a source normally publishes, which can
+// mean an incident upstream or a flurry of hijack-related churn
+type ChangeAnomaly struct {
+	Measure  string
+	Version  uint32
+	Observed float64
+	Expected float64
+	// Score is how many standard deviations Observed is above Expected
+	Score float64
+}
+
+func (a ChangeAnomaly) String() string {
+	return fmt.Sprintf("%v at version %v: %.1f, normally %.1f (%.1f standard deviations)",
+		a.Measure, a.Version, a.Observed, a.Expected, a.Score)
+}
+
+// checkChangeRate compares the deltas just applied to a source with its history, and tells the
+// operators when there's an unusual burst of changes. source is as it was before the update.
+func (p NRTMProcessor) checkChangeRate(source persist.NRTMSource) {
+	activity, err := p.repo.GetDeltaActivity(source, util.AppClock.Now().Add(-changeRateBaseline))
+	if err != nil {
+		logger.Warn("Cannot check rate of change", "source", source.Source, "label", source.Label, "error", err)
+		return
+	}
+	anomalies := detectChangeAnomalies(activity, source.Version)
+	if len(anomalies) == 0 {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Source : %v\n\n", sourceDisplayName(source))
+	for _, a := range anomalies {
+		logger.Warn("Unusual rate of change", "source", source.Source, "label", source.Label,
+			"measure", a.Measure, "version", a.Version, "observed", a.Observed, "expected", a.Expected, "score", a.Score)
+		p.warnings.add(WarningAnomaly, a.Version, "%v", a)
+		fmt.Fprintln(&b, a)
+	}
+	subject := fmt.Sprintf("NRTMv4 unusual rate of change for %v", sourceDisplayName(source))
+	if err = p.newNotifier().Notify(subject, b.String()); err != nil {
+		logger.Warn("Failed to send rate of change notification", "source", source.Source, "error", err)
+	}
+}
+
+// detectChangeAnomalies looks for an unusual number of deltas in the last changeRateWindow,
+// and for deltas after fromVersion which changed an unusual number of objects
+func detectChangeAnomalies(activity persist.DeltaActivity, fromVersion uint32) []ChangeAnomaly {
+	anomalies := []ChangeAnomaly{}
+	if a := deltaRateAnomaly(activity.Notifications); a != nil {
+		anomalies = append(anomalies, *a)
+	}
+	if a := deltaSizeAnomaly(activity.Changes, fromVersion); a != nil {
+		anomalies = append(anomalies, *a)
+	}
+	return anomalies
+}
+
+// deltaRateAnomaly treats deltas as arriving at the baseline's average rate, so the number in
+// the window is roughly Poisson distributed. Timestamps come from the server, so a client which
+// was offline for a while sees a long window rather than a burst.
+func deltaRateAnomaly(notifications []persist.VersionTimestamp) *ChangeAnomaly {
+	if len(notifications) < 3 {
+		return nil
+	}
+	current := notifications[len(notifications)-1]
+	windowStart := current.Timestamp.Add(-changeRateWindow)
+	i := len(notifications) - 2
+	for i > 0 && notifications[i].Timestamp.After(windowStart) {
+		i--
+	}
+	first, start := notifications[0], notifications[i]
+	baseHours := start.Timestamp.Sub(first.Timestamp).Hours()
+	baseDeltas := float64(start.Version) - float64(first.Version)
+	if i == 0 || baseHours <= 0 || baseDeltas < float64(changeRateMinDeltas) {
+		return nil
+	}
+	rate := baseDeltas / baseHours
+	hours := current.Timestamp.Sub(start.Timestamp).Hours()
+	if hours <= 0 {
+		return nil
+	}
+	observed := float64(current.Version) - float64(start.Version)
+	expected := rate * hours
+	score := (observed - expected) / math.Sqrt(math.Max(expected, 1))
+	if score <= changeRateThreshold {
+		return nil
+	}
+	return &ChangeAnomaly{
+		Measure:  DeltasPerHour,
+		Version:  current.Version,
+		Observed: observed / hours,
+		Expected: rate,
+		Score:    score,
+	}
+}
+
+// deltaSizeAnomaly compares the largest delta after fromVersion with the ones before it. The
+// median and median absolute deviation are used so a past burst doesn't hide the next one.
+func deltaSizeAnomaly(changes map[uint32]int, fromVersion uint32) *ChangeAnomaly {
+	baseline := []float64{}
+	var largest uint32
+	for version, count := range changes {
+		if version <= fromVersion {
+			baseline = append(baseline, float64(count))
+		} else if largest == 0 || count > changes[largest] {
+			largest = version
+		}
+	}
+	if largest == 0 || len(baseline) < changeRateMinDeltas {
+		return nil
+	}
+	med := median(baseline)
+	deviations := make([]float64, len(baseline))
+	for i, b := range baseline {
+		deviations[i] = math.Abs(b - med)
+	}
+	// 1.4826 scales the MAD to a standard deviation for normally distributed data
+	sigma := 1.4826 * math.Max(median(deviations), 1)
+	observed := float64(changes[largest])
+	score := (observed - med) / sigma
+	if score <= changeRateThreshold {
+		return nil
+	}
+	return &ChangeAnomaly{
+		Measure:  ObjectsPerDelta,
+		Version:  largest,
+		Observed: observed,
+		Expected: med,
+		Score:    score,
+	}
+}
+
+func median(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+// steadyActivity has a notification every 10 minutes for a day, with one delta a minute which
+// changes 8 to 12 objects
+func steadyActivity() persist.DeltaActivity {
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	activity := persist.DeltaActivity{Changes: map[uint32]int{}}
+	version := uint32(1000)
+	for i := 0; i <= 24*6; i++ {
+		activity.Notifications = append(activity.Notifications, persist.VersionTimestamp{
+			Version:   version,
+			Timestamp: start.Add(time.Duration(i) * 10 * time.Minute),
+		})
+		for j := 0; j < 10; j++ {
+			version++
+			activity.Changes[version] = 8 + int(version%5)
+		}
+	}
+	return activity
+}
+
+func lastNotification(activity persist.DeltaActivity) persist.VersionTimestamp {
+	return activity.Notifications[len(activity.Notifications)-1]
+}
+
+func TestNoAnomalyInSteadyActivity(t *testing.T) {
+	activity := steadyActivity()
+	if anomalies := detectChangeAnomalies(activity, lastNotification(activity).Version-10); len(anomalies) > 0 {
+		t.Error("Expected no anomalies but found", anomalies)
+	}
+}
+
+func TestBurstOfDeltas(t *testing.T) {
+	activity := steadyActivity()
+	last := lastNotification(activity)
+	activity.Notifications = append(activity.Notifications, persist.VersionTimestamp{
+		Version:   last.Version + 300,
+		Timestamp: last.Timestamp.Add(10 * time.Minute),
+	})
+	anomalies := detectChangeAnomalies(activity, last.Version)
+	if len(anomalies) != 1 || anomalies[0].Measure != DeltasPerHour {
+		t.Fatal("Expected a deltas per hour anomaly but found", anomalies)
+	}
+	if a := anomalies[0]; a.Version != last.Version+300 || a.Expected != 60 {
+		t.Error("Unexpected anomaly", a)
+	}
+}
+
+func TestCatchingUpIsNotABurst(t *testing.T) {
+	activity := steadyActivity()
+	last := lastNotification(activity)
+	activity.Notifications = append(activity.Notifications, persist.VersionTimestamp{
+		Version:   last.Version + 600,
+		Timestamp: last.Timestamp.Add(10 * time.Hour),
+	})
+	if anomalies := detectChangeAnomalies(activity, last.Version); len(anomalies) > 0 {
+		t.Error("Expected no anomalies after a long gap but found", anomalies)
+	}
+}
+
+func TestLargeDelta(t *testing.T) {
+	activity := steadyActivity()
+	last := lastNotification(activity)
+	activity.Changes[last.Version+3] = 5000
+	anomalies := detectChangeAnomalies(activity, last.Version)
+	if len(anomalies) != 1 || anomalies[0].Measure != ObjectsPerDelta {
+		t.Fatal("Expected an objects per delta anomaly but found", anomalies)
+	}
+	if a := anomalies[0]; a.Version != last.Version+3 || a.Observed != 5000 || a.Expected != 10 {
+		t.Error("Unexpected anomaly", a)
+	}
+}
+
+func TestShortHistoryIsNotJudged(t *testing.T) {
+	activity := persist.DeltaActivity{
+		Notifications: []persist.VersionTimestamp{
+			{Version: 1, Timestamp: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)},
+			{Version: 2, Timestamp: time.Date(2025, 3, 1, 1, 0, 0, 0, time.UTC)},
+			{Version: 500, Timestamp: time.Date(2025, 3, 1, 2, 0, 0, 0, time.UTC)},
+		},
+		Changes: map[uint32]int{2: 10, 3: 10000},
+	}
+	if anomalies := detectChangeAnomalies(activity, 2); len(anomalies) > 0 {
+		t.Error("Expected no anomalies without enough history but found", anomalies)
+	}
+}
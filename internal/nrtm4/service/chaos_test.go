@@ -0,0 +1,91 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/faults"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+// Chaos tests inject faults into real HTTP downloads and check the client recovers from them
+
+func chaosServer() (*httptest.Server, protocol.FileRefJSON) {
+	body := bytes.Repeat([]byte("And they went to sea in a Sieve. "), 100)
+	sum := sha256.Sum256(body)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"sieve"`)
+		http.ServeContent(w, r, "sieve.json", time.Time{}, bytes.NewReader(body))
+	}))
+	return server, protocol.FileRefJSON{Version: 1, Hash: hex.EncodeToString(sum[:])}
+}
+
+func fetchWithFaults(t *testing.T, injected ...faults.Fault) (*syncWarnings, error) {
+	downloadRetryDelay = time.Millisecond
+	server, ref := chaosServer()
+	defer server.Close()
+	dir := t.TempDir()
+	faults.Enable(injected...)
+	defer faults.Disable()
+	warnings := &syncWarnings{}
+	fm := fileManager{client: HTTPClient{}, warnings: warnings}
+	f, err := fm.fetchFileAndCheckHash(server.URL+"/sieve.json", ref, dir, "")
+	if err == nil {
+		f.Close()
+	}
+	return warnings, err
+}
+
+func TestChaosTruncatedDownloadIsResumed(t *testing.T) {
+	warnings, err := fetchWithFaults(t, faults.Fault{Kind: faults.Truncate, Point: faults.FileBody, After: 500, Times: 2})
+	if err != nil {
+		t.Fatal("Expected the download to be resumed but was", err)
+	}
+	if w := warnings.all(); len(w) != 2 || w[0].Kind != WarningRetry {
+		t.Error("Expected two retry warnings but was", w)
+	}
+}
+
+func TestChaosCorruptDownloadIsFetchedAgain(t *testing.T) {
+	warnings, err := fetchWithFaults(t, faults.Fault{Kind: faults.Corrupt, Point: faults.FileBody, After: 100, Times: 1})
+	if err != nil {
+		t.Fatal("Expected the download to be fetched again but was", err)
+	}
+	if w := warnings.all(); len(w) != 1 || w[0].Kind != WarningRetry {
+		t.Error("Expected a retry warning but was", w)
+	}
+}
+
+func TestChaosPersistentCorruptionFails(t *testing.T) {
+	_, err := fetchWithFaults(t, faults.Fault{Kind: faults.Corrupt, Point: faults.FileBody})
+	if !errors.Is(err, ErrHashMismatch) {
+		t.Error("Expected ErrHashMismatch but was", err)
+	}
+}
+
+func TestChaosFailedDownloadLeavesNoFiles(t *testing.T) {
+	downloadRetryDelay = time.Millisecond
+	server, _ := chaosServer()
+	defer server.Close()
+	dir := t.TempDir()
+	faults.Enable(faults.Fault{Kind: faults.Truncate, Point: faults.FileBody, After: 500})
+	defer faults.Disable()
+	fm := fileManager{client: HTTPClient{}, warnings: &syncWarnings{}}
+	if _, err := fm.downloadToTempFile(server.URL+"/sieve.json", dir, false); !errors.Is(err, ErrTruncatedDownload) {
+		t.Error("Expected ErrTruncatedDownload but was", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Error("Expected the partial download to be removed but found", entries)
+	}
+	faults.Enable(faults.Fault{Kind: faults.Error, Point: faults.FileRequest})
+	if _, err := fm.downloadToTempFile(server.URL+"/sieve.json", dir, false); !errors.Is(err, faults.ErrInjected) {
+		t.Error("Expected ErrInjected but was", err)
+	}
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+// ErrInvalidFullEvery old versions can only be stored as diffs if some of them are left in full
+var ErrInvalidFullEvery = errors.New("full-every must be at least 2")
+
+// CompactHistory stores old versions of a source's objects as diffs against the version which
+// replaced them, keeping every fullEvery-th version of an object in full. Queries give the same
+// results as before; the text of a compacted version is rebuilt when it's read.
+func (p NRTMProcessor) CompactHistory(sourceName, label string, fullEvery int) (persist.CompactedHistory, error) {
+	if err := p.requirePrimary(); err != nil {
+		return persist.CompactedHistory{}, err
+	}
+	if fullEvery < 2 {
+		return persist.CompactedHistory{}, fmt.Errorf("%w: %d", ErrInvalidFullEvery, fullEvery)
+	}
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return persist.CompactedHistory{}, ErrSourceNotFound
+	}
+	compacted, err := p.repo.CompactHistory(*source, fullEvery)
+	if err != nil {
+		return compacted, err
+	}
+	logger.Info("Compacted history", "source", sourceDisplayName(*source), "full_every", fullEvery,
+		"compacted", compacted.Compacted, "bytes_before", compacted.BytesBefore, "bytes_after", compacted.BytesAfter)
+	return compacted, nil
+}
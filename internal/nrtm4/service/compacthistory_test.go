@@ -0,0 +1,49 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+type compactRepo struct {
+	persist.Repository
+	calls *[]int
+}
+
+func (r compactRepo) IsStandby() (bool, error) {
+	return false, nil
+}
+
+func (r compactRepo) GetSources() ([]persist.NRTMSource, error) {
+	return []persist.NRTMSource{{ID: 1, Source: "EXAMPLE", Version: 100}}, nil
+}
+
+func (r compactRepo) CompactHistory(source persist.NRTMSource, fullEvery int) (persist.CompactedHistory, error) {
+	*r.calls = append(*r.calls, fullEvery)
+	return persist.CompactedHistory{FullEvery: fullEvery, Compacted: 3, BytesBefore: 3000, BytesAfter: 300}, nil
+}
+
+func TestCompactHistory(t *testing.T) {
+	calls := []int{}
+	p := NRTMProcessor{repo: compactRepo{calls: &calls}}
+	compacted, err := p.CompactHistory("EXAMPLE", "", 10)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if compacted.FullEvery != 10 || compacted.Compacted != 3 {
+		t.Error("Unexpected result", compacted)
+	}
+	for _, fullEvery := range []int{-1, 0, 1} {
+		if _, err = p.CompactHistory("EXAMPLE", "", fullEvery); !errors.Is(err, ErrInvalidFullEvery) {
+			t.Error("Expected ErrInvalidFullEvery for", fullEvery, "but was", err)
+		}
+	}
+	if _, err = p.CompactHistory("OTHER", "", 10); err != ErrSourceNotFound {
+		t.Error("Expected ErrSourceNotFound but was", err)
+	}
+	if len(calls) != 1 {
+		t.Error("Expected one compaction but was", calls)
+	}
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/quirks"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// SourceConfig holds settings which only apply to one source
+type SourceConfig struct {
+	Publish *PublishConfig `json:"publish"`
+	Verify  *VerifyConfig  `json:"verify"`
+	// TermsURL is the license or terms of use for the source's data. It overrides any terms
+	// the server links to.
+	TermsURL string `json:"terms_url"`
+	// DelegatedStats is the RIR stats file the source's resources are cross-referenced with
+	DelegatedStats *DelegatedStatsConfig `json:"delegated_stats"`
+	// Filter keeps only the objects of some maintainers or organisations
+	Filter *FilterConfig `json:"filter"`
+	// ArchiveSnapshots records the snapshots the server advertises, so older ones can be found
+	ArchiveSnapshots bool `json:"archive_snapshots"`
+	// Upstream is the registry's whois server, which compare-upstream fetches objects from
+	Upstream *UpstreamConfig `json:"upstream"`
+	// ApplyOrder applies the changes in each delta in order of their class
+	ApplyOrder *ApplyOrderConfig `json:"apply_order"`
+	// PostSync runs an SQL script or a program after each sync which changes the source
+	PostSync *PostSyncConfig `json:"post_sync"`
+	// Contact is sent in the User-Agent when the source's files are fetched, instead of the
+	// network config's
+	Contact string `json:"contact"`
+	// Strictness is strict, standard or lenient. See Strictness.
+	Strictness Strictness `json:"strictness"`
+	// Journal writes each applied delta to an NRTMv3 journal file
+	Journal *JournalConfig `json:"journal"`
+	// ObjectTTL is how long, as a Go duration, an object can go unmodified before stale-objects
+	// reports it
+	ObjectTTL string `json:"object_ttl"`
+	// Quirks are the names of the quirks the registry's objects need to be parsed. See package
+	// quirks for the ones there are.
+	Quirks []string `json:"quirks"`
+	// ObjectCount is the range the number of objects is expected to stay in after an update
+	ObjectCount *ObjectCountConfig `json:"object_count"`
+	// Digest sends the source's change digest with the notifier every day or week, while
+	// nrtm4serve runs
+	Digest *DigestConfig `json:"digest"`
+}
+
+// PublishConfig tells the client where to publish changes applied from delta files
+type PublishConfig struct {
+	// URL of the message broker, e.g. nats://localhost:4222
+	URL string `json:"url"`
+	// Subject (or topic) the events are published to
+	Subject string `json:"subject"`
+}
+
+// NotifyConfig tells the notifier where to send messages
+type NotifyConfig struct {
+	SMTP *SMTPConfig `json:"smtp"`
+}
+
+// SMTPConfig settings for sending notifications by email
+type SMTPConfig struct {
+	// Address of the mail server, host:port
+	Address  string   `json:"address"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+type configFileJSON struct {
+	AllowedClockSkew string                   `json:"allowed_clock_skew"`
+	StrictFileURLs   bool                     `json:"strict_file_urls"`
+	TempDir          string                   `json:"temp_dir"`
+	SnapshotWriters  int                      `json:"snapshot_writers"`
+	SlowQuery        string                   `json:"slow_query_threshold"`
+	UndeleteWindow   string                   `json:"undelete_window"`
+	SessionRetention string                   `json:"session_retention"`
+	SyncRunRetention string                   `json:"sync_run_retention"`
+	CatchUpWindow    int                      `json:"catch_up_window"`
+	MaxObjectSize    int                      `json:"max_object_size"`
+	AnalyzeAfter     int                      `json:"analyze_after_changes"`
+	LogLevels        map[string]string        `json:"log_levels"`
+	Contexts         map[string]ContextConfig `json:"contexts"`
+	CurrentContext   string                   `json:"current_context"`
+	Audit            AuditConfig              `json:"audit"`
+	Network          NetworkConfig            `json:"network"`
+	Notify           NotifyConfig             `json:"notify"`
+	Indexes          IndexConfig              `json:"indexes"`
+	Telemetry        TelemetryConfig          `json:"telemetry"`
+	Sources          map[string]SourceConfig  `json:"sources"`
+	Groups           map[string][]string      `json:"groups"`
+	DefaultSource    string                   `json:"default_source"`
+	DefaultLabel     string                   `json:"default_label"`
+	Aliases          map[string]string        `json:"aliases"`
+	Federation       FederationConfig         `json:"federation"`
+	Database         DatabaseConfig           `json:"database"`
+	URLVariables     map[string]string        `json:"url_variables"`
+	QueryLimits      QueryLimitsConfig        `json:"query_limits"`
+	Retry            RetryConfig              `json:"retry"`
+	AdminAPI         AdminAPIConfig           `json:"admin_api"`
+	SpecRevisions    []protocol.Revision      `json:"spec_revisions"`
+}
+
+// ReadConfigFile reads a JSON configuration file into config
+func ReadConfigFile(path string, config *AppConfig) error {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cf configFileJSON
+	if err = json.Unmarshal(bytes, &cf); err != nil {
+		return err
+	}
+	if len(cf.AllowedClockSkew) > 0 {
+		if config.AllowedClockSkew, err = time.ParseDuration(cf.AllowedClockSkew); err != nil {
+			return err
+		}
+	}
+	if len(cf.SlowQuery) > 0 {
+		if config.SlowQueryThreshold, err = time.ParseDuration(cf.SlowQuery); err != nil {
+			return err
+		}
+	}
+	if len(cf.UndeleteWindow) > 0 {
+		if config.UndeleteWindow, err = time.ParseDuration(cf.UndeleteWindow); err != nil {
+			return err
+		}
+	}
+	if len(cf.SessionRetention) > 0 {
+		if config.SessionRetention, err = time.ParseDuration(cf.SessionRetention); err != nil {
+			return err
+		}
+	}
+	if len(cf.SyncRunRetention) > 0 {
+		if config.SyncRunRetention, err = time.ParseDuration(cf.SyncRunRetention); err != nil {
+			return err
+		}
+	}
+	if _, err = util.ParseLogLevels(cf.LogLevels); err != nil {
+		return err
+	}
+	if err = cf.Network.validate(); err != nil {
+		return err
+	}
+	if err = cf.Indexes.validate(); err != nil {
+		return err
+	}
+	if err = validateAliases(cf.Aliases); err != nil {
+		return err
+	}
+	if err = cf.Federation.validate(); err != nil {
+		return err
+	}
+	if err = validateURLVariables(cf.URLVariables); err != nil {
+		return err
+	}
+	if err = cf.Database.validate(); err != nil {
+		return err
+	}
+	if err = cf.QueryLimits.validate(); err != nil {
+		return err
+	}
+	if err = cf.Retry.validate(); err != nil {
+		return err
+	}
+	if err = cf.AdminAPI.validate(); err != nil {
+		return err
+	}
+	for _, rev := range cf.SpecRevisions {
+		if err = protocol.Register(rev); err != nil {
+			return fmt.Errorf("spec revision %q: %w", rev.Name, err)
+		}
+	}
+	for name, sc := range cf.Sources {
+		if err = sc.Filter.validate(); err != nil {
+			return fmt.Errorf("source %v: %w", name, err)
+		}
+		if err = sc.Publish.validate(); err != nil {
+			return fmt.Errorf("source %v: %w", name, err)
+		}
+		if err = sc.Upstream.validate(); err != nil {
+			return fmt.Errorf("source %v: %w", name, err)
+		}
+		if err = sc.ApplyOrder.validate(); err != nil {
+			return fmt.Errorf("source %v: %w", name, err)
+		}
+		if err = sc.PostSync.validate(); err != nil {
+			return fmt.Errorf("source %v: %w", name, err)
+		}
+		if err = validateContact(sc.Contact); err != nil {
+			return fmt.Errorf("source %v: %w", name, err)
+		}
+		if err = sc.Strictness.validate(); err != nil {
+			return fmt.Errorf("source %v: %w", name, err)
+		}
+		if err = sc.Journal.validate(); err != nil {
+			return fmt.Errorf("source %v: %w", name, err)
+		}
+		if err = validateObjectTTL(sc.ObjectTTL); err != nil {
+			return fmt.Errorf("source %v: %w", name, err)
+		}
+		if _, err = quirks.ForSource(name, sc.Quirks); err != nil {
+			return fmt.Errorf("source %v: %w", name, err)
+		}
+		if err = sc.ObjectCount.validate(); err != nil {
+			return fmt.Errorf("source %v: %w", name, err)
+		}
+		if err = sc.Digest.validate(); err != nil {
+			return fmt.Errorf("source %v: %w", name, err)
+		}
+	}
+	config.StrictFileURLs = cf.StrictFileURLs
+	config.TempDir = cf.TempDir
+	config.SnapshotWriters = cf.SnapshotWriters
+	config.CatchUpWindow = cf.CatchUpWindow
+	config.MaxObjectSize = cf.MaxObjectSize
+	config.AnalyzeAfter = cf.AnalyzeAfter
+	config.LogLevels = cf.LogLevels
+	config.Audit = cf.Audit
+	config.Network = cf.Network
+	config.Notify = cf.Notify
+	config.Indexes = cf.Indexes
+	config.Telemetry = cf.Telemetry
+	config.Sources = cf.Sources
+	config.Groups = cf.Groups
+	config.Contexts = cf.Contexts
+	config.CurrentContext = cf.CurrentContext
+	config.DefaultSource = cf.DefaultSource
+	config.DefaultLabel = cf.DefaultLabel
+	config.Aliases = cf.Aliases
+	config.Federation = cf.Federation
+	config.Database = cf.Database
+	config.URLVariables = cf.URLVariables
+	config.QueryLimits = cf.QueryLimits
+	config.Retry = cf.Retry
+	config.AdminAPI = cf.AdminAPI
+	return nil
+}
+
+func (c AppConfig) sourceConfig(sourceName string) SourceConfig {
+	for name, sc := range c.Sources {
+		if strings.EqualFold(name, sourceName) {
+			return sc
+		}
+	}
+	return SourceConfig{}
+}
+
+// sourceQuirks is the set of quirks in a source's config. The names were checked when the config
+// was read.
+func (c AppConfig) sourceQuirks(sourceName string) quirks.Set {
+	set, _ := quirks.ForSource(sourceName, c.sourceConfig(sourceName).Quirks)
+	return set
+}
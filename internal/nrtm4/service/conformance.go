@@ -0,0 +1,301 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// CheckStatus is the outcome of a conformance check
+type CheckStatus string
+
+// CheckSeverity says how serious it is when a check fails
+type CheckSeverity string
+
+const (
+	// CheckPassed the server does what the spec says
+	CheckPassed CheckStatus = "pass"
+	// CheckFailed the server doesn't do what the spec says
+	CheckFailed CheckStatus = "fail"
+	// CheckSkipped the check couldn't be run because an earlier one failed, or wasn't asked for
+	CheckSkipped CheckStatus = "skipped"
+
+	// SeverityError a client can't mirror the server
+	SeverityError CheckSeverity = "error"
+	// SeverityWarning a client can mirror the server but something isn't right
+	SeverityWarning CheckSeverity = "warning"
+
+	// Exit codes for the validate command. 1 is left for usage errors.
+
+	// ExitConformant every check passed
+	ExitConformant = 0
+	// ExitWarnings only checks with warning severity failed
+	ExitWarnings = 2
+	// ExitErrors at least one check with error severity failed
+	ExitErrors = 3
+
+	specName             = "draft-ietf-grow-nrtm-v4"
+	specNotificationFile = specName + ", Update Notification File"
+	specSnapshotFile     = specName + ", Snapshot File"
+	specDeltaFile        = specName + ", Delta File"
+
+	orderingDescription = "Changes inside each delta file are consistent"
+)
+
+var (
+	uuidRe = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+	// e.g. nrtm-delta.350194.RIPE.db44e038-1f07-4d54-a307-1b32339f141a.153f49c6f125fd7e943f416aca69ba7a.json
+	fileNameRe = regexp.MustCompile(`^nrtm-(snapshot|delta)\.([0-9]+)\.([^.]+)\.([^.]+)\.([0-9a-fA-F]+)\.json(\.gz)?$`)
+)
+
+// ConformanceCheck is the result of one check
+type ConformanceCheck struct {
+	ID          string        `json:"id"`
+	Description string        `json:"description"`
+	Status      CheckStatus   `json:"status"`
+	Severity    CheckSeverity `json:"severity"`
+	SpecSection string        `json:"spec_section"`
+	Message     string        `json:"message,omitempty"`
+}
+
+// ConformanceReport lists the checks made against a server's notification file
+type ConformanceReport struct {
+	NotificationURL string             `json:"notification_url"`
+	Checked         string             `json:"checked"`
+	Checks          []ConformanceCheck `json:"checks"`
+	ExitCode        int                `json:"exit_code"`
+}
+
+func (r *ConformanceReport) add(id string, severity CheckSeverity, section, description string, err error) bool {
+	check := ConformanceCheck{
+		ID:          id,
+		Description: description,
+		Status:      CheckPassed,
+		Severity:    severity,
+		SpecSection: section,
+	}
+	if err != nil {
+		check.Status = CheckFailed
+		check.Message = err.Error()
+		if severity == SeverityError {
+			r.ExitCode = ExitErrors
+		} else if r.ExitCode == ExitConformant {
+			r.ExitCode = ExitWarnings
+		}
+	}
+	r.Checks = append(r.Checks, check)
+	return err == nil
+}
+
+func (r *ConformanceReport) skip(id string, severity CheckSeverity, section, description, reason string) {
+	r.Checks = append(r.Checks, ConformanceCheck{
+		ID:          id,
+		Description: description,
+		Status:      CheckSkipped,
+		Severity:    severity,
+		SpecSection: section,
+		Message:     reason,
+	})
+}
+
+// CheckConformance fetches a notification file and checks it against the spec. If checkFiles
+// is true the snapshot and delta files are downloaded and their hashes checked too. File names
+// which don't follow the spec's naming convention are warnings, or errors if strictFileNames
+// is true. Unless ordering is OrderingOff the changes inside each delta file are checked as
+// they're downloaded.
+func (p NRTMProcessor) CheckConformance(notificationURL string, checkFiles, strictFileNames bool, ordering DeltaOrdering) ConformanceReport {
+	now := util.AppClock.Now()
+	report := ConformanceReport{NotificationURL: notificationURL, Checked: now.Format(util.RFC3339Milli)}
+	notification, header, err := p.client.getUpdateNotification(notificationURL)
+	if !report.add("notification.fetch", SeverityError, specNotificationFile,
+		"Notification file can be fetched and parsed", err) {
+		return report
+	}
+	report.add("notification.nrtm_version", SeverityError, specNotificationFile,
+		"nrtm_version is 4", expect(notification.NrtmVersion == 4, "nrtm_version is %v", notification.NrtmVersion))
+	report.add("notification.type", SeverityError, specNotificationFile,
+		"type is notification", expect(notification.Type == "notification", "type is '%v'", notification.Type))
+	report.add("notification.source", SeverityError, specNotificationFile,
+		"source is present", expect(len(notification.Source) > 0, "source is empty"))
+	report.add("notification.session_id", SeverityError, specNotificationFile,
+		"session_id is a UUID", expect(uuidRe.MatchString(notification.SessionID), "session_id is '%v'", notification.SessionID))
+	report.add("notification.version", SeverityError, specNotificationFile,
+		"version is positive", expect(notification.Version > 0, "version is %v", notification.Version))
+	allowedSkew := p.config.AllowedClockSkew
+	if allowedSkew <= 0 {
+		allowedSkew = defaultAllowedClockSkew
+	}
+	tsErr := validateNotificationTimestamp(notification, estimateClockSkew(header, now), now, allowedSkew)
+	report.add("notification.timestamp", SeverityError, specNotificationFile,
+		"timestamp is valid and not in the future", notErr(tsErr, ErrNRTM4StaleNotification))
+	report.add("notification.freshness", SeverityWarning, specNotificationFile,
+		fmt.Sprintf("Notification file is less than %v old", notificationMaxAge), onlyErr(tsErr, ErrNRTM4StaleNotification))
+	report.add("snapshot.ref", SeverityError, specNotificationFile,
+		"Snapshot reference has a version, url and hash", checkFileRef(notification.SnapshotRef))
+	deltasOK := report.add("deltas.sequence", SeverityError, specNotificationFile,
+		"Deltas are contiguous and end at the notification version", validateDeltaSequence(notification))
+	for _, ref := range notification.DeltaRefs {
+		if err = checkFileRef(ref); err != nil {
+			deltasOK = report.add("deltas.ref", SeverityError, specNotificationFile,
+				"Delta references have a version, url and hash", err)
+			break
+		}
+	}
+	nameSeverity := SeverityWarning
+	if strictFileNames {
+		nameSeverity = SeverityError
+	}
+	report.add("snapshot.file_name", nameSeverity, specSnapshotFile,
+		"Snapshot file name has the version, source, session and a random part",
+		checkFileName(persist.SnapshotFile, notification.SnapshotRef, notification))
+	var nameErr error
+	for _, ref := range notification.DeltaRefs {
+		if nameErr = checkFileName(persist.DeltaFile, ref, notification); nameErr != nil {
+			break
+		}
+	}
+	report.add("deltas.file_name", nameSeverity, specDeltaFile,
+		"Delta file names have the version, source, session and a random part", nameErr)
+	if !checkFiles {
+		report.skip("snapshot.hash", SeverityError, specSnapshotFile, "Snapshot file matches its hash", "files were not downloaded")
+		report.skip("deltas.hash", SeverityError, specDeltaFile, "Delta files match their hashes", "files were not downloaded")
+		report.skip("deltas.ordering", SeverityError, specDeltaFile, orderingDescription, "files were not downloaded")
+		return report
+	}
+	report.add("snapshot.hash", SeverityError, specSnapshotFile,
+		"Snapshot file matches its hash", p.checkRemoteHash(notificationURL, notification.SnapshotRef))
+	if !deltasOK {
+		report.skip("deltas.hash", SeverityError, specDeltaFile, "Delta files match their hashes", "delta references are not valid")
+		report.skip("deltas.ordering", SeverityError, specDeltaFile, orderingDescription, "delta references are not valid")
+		return report
+	}
+	var deltaErr error
+	violations := []string{}
+	for _, ref := range notification.DeltaRefs {
+		var inspect func(io.Reader)
+		if ordering != OrderingOff {
+			inspect = func(r io.Reader) {
+				found, err := checkDeltaOrdering(r, ordering, p.config.sourceQuirks(notification.Source))
+				if err != nil {
+					found = append(found, fmt.Sprintf("not a JSON text sequence: %v", err))
+				}
+				for _, v := range found {
+					violations = append(violations, fmt.Sprintf("delta %d: %v", ref.Version, v))
+				}
+			}
+		}
+		if deltaErr = p.checkRemoteFile(notificationURL, ref, inspect); deltaErr != nil {
+			break
+		}
+	}
+	report.add("deltas.hash", SeverityError, specDeltaFile, "Delta files match their hashes", deltaErr)
+	if ordering == OrderingOff {
+		report.skip("deltas.ordering", SeverityError, specDeltaFile, orderingDescription, "ordering was not asked for")
+	} else {
+		report.add("deltas.ordering", SeverityError, specDeltaFile, orderingDescription, orderingError(violations))
+	}
+	return report
+}
+
+func expect(ok bool, format string, args ...any) error {
+	if ok {
+		return nil
+	}
+	return fmt.Errorf(format, args...)
+}
+
+// notErr returns err unless it's the one which is reported by another check
+func notErr(err, other error) error {
+	if err == other {
+		return nil
+	}
+	return err
+}
+
+func onlyErr(err, target error) error {
+	if err == target {
+		return err
+	}
+	return nil
+}
+
+func checkFileRef(ref protocol.FileRefJSON) error {
+	if ref.Version == 0 {
+		return fmt.Errorf("%v has no version", ref.URL)
+	}
+	if len(ref.URL) == 0 {
+		return fmt.Errorf("version %v has no url", ref.Version)
+	}
+	if len(ref.Hash) != sha256.Size*2 {
+		return fmt.Errorf("%v does not have a SHA-256 hash", ref.URL)
+	}
+	return nil
+}
+
+// checkFileName checks that the name of a snapshot or delta file is
+// nrtm-<type>.<version>.<source>.<session_id>.<random>.json, with .gz for a compressed file.
+// The random part stops a cache serving an old file with the same version.
+func checkFileName(fileType persist.NTRMFileType, ref protocol.FileRefJSON, notification protocol.NotificationJSON) error {
+	name := ref.URL
+	if u, err := url.Parse(ref.URL); err == nil {
+		name = u.Path
+	}
+	name = path.Base(name)
+	m := fileNameRe.FindStringSubmatch(name)
+	if m == nil {
+		return fmt.Errorf("%v does not follow the naming convention", name)
+	}
+	if m[1] != fileType.String() {
+		return fmt.Errorf("%v is named as a %v file", name, m[1])
+	}
+	if v, err := strconv.ParseUint(m[2], 10, 32); err != nil || uint32(v) != ref.Version {
+		return fmt.Errorf("%v is named for version %v but is version %v", name, m[2], ref.Version)
+	}
+	if !strings.EqualFold(m[3], notification.Source) {
+		return fmt.Errorf("%v is named for source %v but the source is %v", name, m[3], notification.Source)
+	}
+	if !strings.EqualFold(m[4], notification.SessionID) {
+		return fmt.Errorf("%v is named for session %v but the session is %v", name, m[4], notification.SessionID)
+	}
+	return nil
+}
+
+// checkRemoteHash downloads a file without saving it and compares its hash with the reference
+func (p NRTMProcessor) checkRemoteHash(notificationURL string, ref protocol.FileRefJSON) error {
+	return p.checkRemoteFile(notificationURL, ref, nil)
+}
+
+// checkRemoteFile is checkRemoteHash, and when inspect isn't nil it reads the file as it's
+// downloaded. Whatever inspect doesn't read is still hashed.
+func (p NRTMProcessor) checkRemoteFile(notificationURL string, ref protocol.FileRefJSON, inspect func(io.Reader)) error {
+	fURL, err := resolveFileURL(notificationURL, ref.URL, p.config.StrictFileURLs)
+	if err != nil {
+		return err
+	}
+	reader, err := p.client.getResponseBody(fURL)
+	if err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	body := io.TeeReader(reader, hasher)
+	if inspect != nil {
+		inspect(body)
+	}
+	if _, err = io.Copy(io.Discard, body); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hasher.Sum(nil)); sum != ref.Hash {
+		return fmt.Errorf("%w: %v", ErrHashMismatch, fURL)
+	}
+	return nil
+}
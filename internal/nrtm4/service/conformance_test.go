@@ -0,0 +1,116 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+func TestCheckConformance(t *testing.T) {
+	okHash := "2689367b205c16ce32ed4200942b8b8b1e262dfc70d9bc9fbc77c49699a4f1df"
+	notification := protocol.NotificationJSON{
+		NrtmFileJSON: protocol.NrtmFileJSON{
+			NrtmVersion: 4,
+			Type:        "notification",
+			Source:      "EXAMPLE",
+			SessionID:   "db44e038-1f07-4d54-a307-1b32339f141a",
+			Version:     3,
+		},
+		Timestamp:   util.AppClock.Now().Format(time.RFC3339),
+		SnapshotRef: protocol.FileRefJSON{URL: "nrtm-snapshot.2.EXAMPLE.db44e038-1f07-4d54-a307-1b32339f141a.1c4e.json.gz", Version: 2, Hash: okHash},
+		DeltaRefs: []protocol.FileRefJSON{
+			{URL: "nrtm-delta.2.EXAMPLE.db44e038-1f07-4d54-a307-1b32339f141a.7a2f.json", Version: 2, Hash: okHash},
+			{URL: "https://example.com/nrtm4/nrtm-delta.3.EXAMPLE.db44e038-1f07-4d54-a307-1b32339f141a.e90b.json", Version: 3, Hash: okHash},
+		},
+	}
+	url := "https://example.com/nrtm4/notification.json"
+	statuses := func(report ConformanceReport) map[string]CheckStatus {
+		m := map[string]CheckStatus{}
+		for _, c := range report.Checks {
+			m[c.ID] = c.Status
+		}
+		return m
+	}
+	{
+		p := NRTMProcessor{client: stubDeltaClient{notification: notification, responseBody: "ok"}}
+		report := p.CheckConformance(url, true, false, OrderingOff)
+		if report.ExitCode != ExitConformant {
+			t.Error("Expected conformant report but was", report.Checks)
+		}
+		report = p.CheckConformance(url, false, false, OrderingOff)
+		if s := statuses(report)["snapshot.hash"]; s != CheckSkipped {
+			t.Error("Expected snapshot hash check to be skipped but was", s)
+		}
+	}
+	{
+		p := NRTMProcessor{client: stubDeltaClient{notification: notification, responseBody: "not ok"}}
+		report := p.CheckConformance(url, true, false, OrderingOff)
+		if report.ExitCode != ExitErrors || statuses(report)["deltas.hash"] != CheckFailed {
+			t.Error("Expected hash checks to fail", report.Checks)
+		}
+	}
+	{
+		stale := notification
+		stale.Timestamp = util.AppClock.Now().Add(-48 * time.Hour).Format(time.RFC3339)
+		p := NRTMProcessor{client: stubDeltaClient{notification: stale}}
+		report := p.CheckConformance(url, false, false, OrderingOff)
+		if report.ExitCode != ExitWarnings || statuses(report)["notification.freshness"] != CheckFailed {
+			t.Error("Expected only a freshness warning", report.Checks)
+		}
+	}
+	{
+		broken := notification
+		broken.SessionID = "not-a-uuid"
+		broken.DeltaRefs = notification.DeltaRefs[:1]
+		p := NRTMProcessor{client: stubDeltaClient{notification: broken}}
+		report := p.CheckConformance(url, false, false, OrderingOff)
+		s := statuses(report)
+		if report.ExitCode != ExitErrors || s["notification.session_id"] != CheckFailed || s["deltas.sequence"] != CheckFailed {
+			t.Error("Expected session id and delta sequence checks to fail", report.Checks)
+		}
+	}
+	{
+		renamed := notification
+		renamed.SnapshotRef.URL = "snapshot.json.gz"
+		renamed.DeltaRefs = []protocol.FileRefJSON{notification.DeltaRefs[0], notification.DeltaRefs[0]}
+		renamed.DeltaRefs[1].Version = 3
+		p := NRTMProcessor{client: stubDeltaClient{notification: renamed}}
+		report := p.CheckConformance(url, false, false, OrderingOff)
+		s := statuses(report)
+		if report.ExitCode != ExitWarnings || s["snapshot.file_name"] != CheckFailed || s["deltas.file_name"] != CheckFailed {
+			t.Error("Expected file name warnings", report.Checks)
+		}
+		if report = p.CheckConformance(url, false, true, OrderingOff); report.ExitCode != ExitErrors {
+			t.Error("Expected file name errors with strict file names", report.Checks)
+		}
+	}
+}
+
+func TestCheckFileName(t *testing.T) {
+	notification := protocol.NotificationJSON{NrtmFileJSON: protocol.NrtmFileJSON{Source: "RIPE", SessionID: "db44e038-1f07-4d54-a307-1b32339f141a"}}
+	type expectation struct {
+		fileType persist.NTRMFileType
+		url      string
+		version  uint32
+		ok       bool
+	}
+	expectations := []expectation{
+		{persist.DeltaFile, "nrtm-delta.350194.RIPE.db44e038-1f07-4d54-a307-1b32339f141a.153f49c6f125fd7e943f416aca69ba7a.json", 350194, true},
+		{persist.SnapshotFile, "https://example.com/RIPE/nrtm-snapshot.4.ripe.DB44E038-1F07-4D54-A307-1B32339F141A.db51.json.gz?x=1", 4, true},
+		{persist.DeltaFile, "nrtm-delta.350194.RIPE.db44e038-1f07-4d54-a307-1b32339f141a.153f.json", 350195, false},
+		{persist.DeltaFile, "nrtm-snapshot.4.RIPE.db44e038-1f07-4d54-a307-1b32339f141a.153f.json", 4, false},
+		{persist.DeltaFile, "nrtm-delta.4.ARIN.db44e038-1f07-4d54-a307-1b32339f141a.153f.json", 4, false},
+		{persist.DeltaFile, "nrtm-delta.4.RIPE.17db6715-18ae-410f-973e-47981b52f023.153f.json", 4, false},
+		{persist.DeltaFile, "nrtm-delta.4.RIPE.db44e038-1f07-4d54-a307-1b32339f141a.json", 4, false},
+		{persist.DeltaFile, "delta-4.json", 4, false},
+	}
+	for _, exp := range expectations {
+		err := checkFileName(exp.fileType, protocol.FileRefJSON{URL: exp.url, Version: exp.version}, notification)
+		if (err == nil) != exp.ok {
+			t.Error("Unexpected result for", exp.url, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidConnectList the list of sources to connect can't be used
+var ErrInvalidConnectList = errors.New("invalid list of sources to connect")
+
+// defaultConnectParallel is how many sources are connected at once when it isn't given
+const defaultConnectParallel = 4
+
+// ConnectRequest is one source to connect
+type ConnectRequest struct {
+	URL   string `json:"url"`
+	Label string `json:"label"`
+	// Vars fill in the {name}s in URL, along with the config's url_variables
+	Vars map[string]string `json:"vars,omitempty"`
+}
+
+// ConnectResult says whether a source was connected. Err is nil when it was.
+type ConnectResult struct {
+	ConnectRequest
+	Sync SyncResult
+	Err  error
+}
+
+// ReadConnectRequests reads a JSON array of sources to connect, each with a `url` and an optional
+// `label` and `vars`
+func ReadConnectRequests(r io.Reader) ([]ConnectRequest, error) {
+	var reqs []ConnectRequest
+	if err := json.NewDecoder(r).Decode(&reqs); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConnectList, err)
+	}
+	seen := map[string]bool{}
+	for i, req := range reqs {
+		if len(strings.TrimSpace(req.URL)) == 0 {
+			return nil, fmt.Errorf("%w: entry %d has no url", ErrInvalidConnectList, i+1)
+		}
+		if err := validateURLVariables(req.Vars); err != nil {
+			return nil, fmt.Errorf("%w: entry %d: %v", ErrInvalidConnectList, i+1, err)
+		}
+		// fmt sorts the vars by name, so the same template is a duplicate only with the same vars
+		key := strings.ToLower(req.URL) + " " + strings.ToLower(strings.TrimSpace(req.Label)) + " " + fmt.Sprint(req.Vars)
+		if seen[key] {
+			return nil, fmt.Errorf("%w: %v is listed more than once with label '%v'", ErrInvalidConnectList, req.URL, req.Label)
+		}
+		seen[key] = true
+	}
+	return reqs, nil
+}
+
+// ConnectAll connects each source in its own goroutine, at most parallel at a time. A source
+// which fails doesn't stop the others. Results are in the same order as reqs.
+func (p NRTMProcessor) ConnectAll(reqs []ConnectRequest, parallel int) []ConnectResult {
+	if parallel <= 0 {
+		parallel = defaultConnectParallel
+	}
+	results := make([]ConnectResult, len(reqs))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			url, err := p.expandURL(req.URL, req.Vars)
+			if err != nil {
+				logger.Warn("Connect failed", "url", req.URL, "label", req.Label, "error", err)
+				results[i] = ConnectResult{ConnectRequest: req, Err: err}
+				return
+			}
+			req.URL = url
+			logger.Info("Connecting", "url", req.URL, "label", req.Label)
+			synced, err := p.connectIsolated(req)
+			if err != nil {
+				logger.Warn("Connect failed", "url", req.URL, "label", req.Label, "error", err)
+			} else {
+				logger.Info("Connected", "url", req.URL, "label", req.Label)
+			}
+			results[i] = ConnectResult{ConnectRequest: req, Sync: synced, Err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// connectIsolated turns a panic while connecting into an error, so it only fails one source
+func (p NRTMProcessor) connectIsolated(req ConnectRequest) (result SyncResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("connect panicked: %v", r)
+		}
+	}()
+	return p.Connect(req.URL, req.Label)
+}
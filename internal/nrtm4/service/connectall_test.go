@@ -0,0 +1,93 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+type connectAllRepo struct {
+	persist.Repository
+	sourcesErr error
+}
+
+func (r connectAllRepo) IsStandby() (bool, error) {
+	return false, nil
+}
+
+func (r connectAllRepo) GetSources() ([]persist.NRTMSource, error) {
+	return []persist.NRTMSource{{ID: 1, Source: "TEST", NotificationURL: "https://nrtm.example.net/notification.json"}}, r.sourcesErr
+}
+
+func TestReadConnectRequests(t *testing.T) {
+	reqs, err := ReadConnectRequests(strings.NewReader(`[
+		{"url": "https://nrtm.example.net/notification.json"},
+		{"url": "https://nrtm.example.net/notification.json", "label": "test"}
+	]`))
+	if err != nil {
+		t.Fatal("Failed to read requests", err)
+	}
+	if len(reqs) != 2 || reqs[1].Label != "test" {
+		t.Error("Unexpected requests", reqs)
+	}
+	if reqs, err = ReadConnectRequests(strings.NewReader(`[
+		{"url": "https://{host}/{source}/notification.json", "vars": {"source": "ripe"}},
+		{"url": "https://{host}/{source}/notification.json", "vars": {"source": "arin"}}
+	]`)); err != nil || reqs[1].Vars["source"] != "arin" {
+		t.Error("Expected a template with different vars not to be a duplicate", reqs, err)
+	}
+	for _, bad := range []string{
+		`{"url": "https://nrtm.example.net/notification.json"}`,
+		`[{"label": "test"}]`,
+		`[{"url": "https://a.example/n.json"}, {"url": "https://A.example/n.json", "label": " "}]`,
+		`[{"url": "https://a.example/{x}/n.json", "vars": {"x": "1"}}, {"url": "https://a.example/{x}/n.json", "vars": {"x": "1"}}]`,
+		`[{"url": "https://a.example/{x}/n.json", "vars": {"x/y": "1"}}]`,
+	} {
+		if _, err = ReadConnectRequests(strings.NewReader(bad)); !errors.Is(err, ErrInvalidConnectList) {
+			t.Error("Expected ErrInvalidConnectList for", bad, "but was", err)
+		}
+	}
+}
+
+func TestConnectAllIsolatesFailures(t *testing.T) {
+	p := NRTMProcessor{repo: connectAllRepo{}}
+	reqs := []ConnectRequest{
+		{URL: "https://nrtm.example.net/notification.json"},
+		{URL: "not a url"},
+		{URL: "https://nrtm.example.net/notification.json", Label: "bad label!"},
+	}
+	results := p.ConnectAll(reqs, 2)
+	if len(results) != len(reqs) {
+		t.Fatal("Expected a result for each request but was", results)
+	}
+	for i, result := range results {
+		if result.URL != reqs[i].URL || result.Err == nil {
+			t.Error("Expected request", i, "to fail in order but was", result)
+		}
+	}
+	if !strings.Contains(results[0].Err.Error(), "already exists") {
+		t.Error("Expected source to exist already but was", results[0].Err)
+	}
+
+	p.repo = connectAllRepo{sourcesErr: errors.New("database has gone away")}
+	results = p.ConnectAll(reqs[:1], 1)
+	if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "panicked") {
+		t.Error("Expected a panic to fail only its own source but was", results[0].Err)
+	}
+}
+
+func TestConnectAllExpandsURLs(t *testing.T) {
+	p := NRTMProcessor{repo: connectAllRepo{}, config: AppConfig{URLVariables: map[string]string{"host": "nrtm.example.org"}}}
+	results := p.ConnectAll([]ConnectRequest{
+		{URL: "https://{host}/notification.json", Vars: map[string]string{"host": "nrtm.example.net"}},
+		{URL: "https://{host}/{source}/notification.json"},
+	}, 1)
+	if results[0].URL != "https://nrtm.example.net/notification.json" || !strings.Contains(results[0].Err.Error(), "already exists") {
+		t.Error("Expected the request's vars to replace the config's but was", results[0].URL, results[0].Err)
+	}
+	if !errors.Is(results[1].Err, ErrUndefinedURLVariable) {
+		t.Error("Expected ErrUndefinedURLVariable but was", results[1].Err)
+	}
+}
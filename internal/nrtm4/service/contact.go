@@ -0,0 +1,34 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidContact a contact can't have control characters or parentheses, since it's sent
+// in a comment in the User-Agent header
+var ErrInvalidContact = errors.New("contact must not have control characters or parentheses")
+
+// contactClient is a Client which can identify the deployment differently for each source
+type contactClient interface {
+	withContact(string) Client
+}
+
+func validateContact(contact string) error {
+	if strings.ContainsFunc(contact, func(r rune) bool { return unicode.IsControl(r) || r == '(' || r == ')' }) {
+		return fmt.Errorf("%w: %q", ErrInvalidContact, contact)
+	}
+	return nil
+}
+
+// sourceClient returns the client which fetches a source's files. It identifies the deployment
+// with the source's contact, if it has one, instead of the one in the network config.
+func (p NRTMProcessor) sourceClient(sourceName string) Client {
+	contact := p.config.sourceConfig(sourceName).Contact
+	if cc, ok := p.client.(contactClient); ok && len(contact) > 0 {
+		return cc.withContact(contact)
+	}
+	return p.client
+}
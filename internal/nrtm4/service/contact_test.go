@@ -0,0 +1,40 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+func TestContact(t *testing.T) {
+	headers := make(chan http.Header, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	p := NRTMProcessor{client: NewHTTPClient(NetworkConfig{Contact: "noc@example.net"})}
+	p.config.Sources = map[string]SourceConfig{"test": {Contact: "mirror-team, AS65000"}}
+
+	if _, _, err := p.sourceClient("OTHER").getUpdateNotification(server.URL); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	header := <-headers
+	if header.Get("User-Agent") != util.UserAgent()+" (noc@example.net)" || header.Get("From") != "noc@example.net" {
+		t.Error("Expected the network config's contact but was", header)
+	}
+	if _, err := p.sourceClient("TEST").getResponseBody(server.URL); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	header = <-headers
+	if !strings.HasSuffix(header.Get("User-Agent"), " (mirror-team, AS65000)") || len(header.Get("From")) > 0 {
+		t.Error("Expected the source's contact but was", header)
+	}
+	if err := (NetworkConfig{Contact: "noc@example.net\r\nX-Other: 1"}).validate(); !errors.Is(err, ErrInvalidContact) {
+		t.Error("Expected ErrInvalidContact but was", err)
+	}
+}
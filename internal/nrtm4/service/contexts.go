@@ -0,0 +1,87 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrContextNotFound there's no context with the given name in the config file
+var ErrContextNotFound = errors.New("no context with that name in the config file")
+
+// ContextConfig is a named environment, such as a staging or production mirror. Settings which
+// are empty are taken from the environment variables instead.
+type ContextConfig struct {
+	// DatabaseURL replaces PG_DATABASE_URL
+	DatabaseURL string `json:"database_url"`
+	// FilePath replaces NRTM4_FILE_PATH
+	FilePath string `json:"file_path"`
+	TempDir  string `json:"temp_dir"`
+	// DefaultSource and DefaultLabel replace the top-level ones
+	DefaultSource string `json:"default_source"`
+	DefaultLabel  string `json:"default_label"`
+}
+
+// UseContext applies the settings of the named context to the config. When name is empty the
+// config file's current_context is used, if it has one.
+func (c *AppConfig) UseContext(name string) error {
+	if len(name) == 0 {
+		name = c.CurrentContext
+	}
+	if len(name) == 0 {
+		return nil
+	}
+	ctx, ok := c.Contexts[name]
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrContextNotFound, name)
+	}
+	if len(ctx.DatabaseURL) > 0 {
+		c.PgDatabaseURL = ctx.DatabaseURL
+	}
+	if len(ctx.FilePath) > 0 {
+		c.NRTMFilePath = ctx.FilePath
+	}
+	if len(ctx.TempDir) > 0 {
+		c.TempDir = ctx.TempDir
+	}
+	if len(ctx.DefaultSource) > 0 {
+		c.DefaultSource = ctx.DefaultSource
+		c.DefaultLabel = ctx.DefaultLabel
+	}
+	c.CurrentContext = name
+	return nil
+}
+
+// SetCurrentContext writes current_context to the config file at path. The rest of the file is
+// kept, but it's rewritten with its keys sorted.
+func SetCurrentContext(path, name string) error {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cf map[string]json.RawMessage
+	if err = json.Unmarshal(bytes, &cf); err != nil {
+		return err
+	}
+	contexts := map[string]ContextConfig{}
+	if raw, ok := cf["contexts"]; ok {
+		if err = json.Unmarshal(raw, &contexts); err != nil {
+			return err
+		}
+	}
+	if _, ok := contexts[name]; !ok {
+		return fmt.Errorf("%w: %v", ErrContextNotFound, name)
+	}
+	if cf["current_context"], err = json.Marshal(name); err != nil {
+		return err
+	}
+	if bytes, err = json.MarshalIndent(cf, "", "  "); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(bytes, '\n'), info.Mode().Perm())
+}
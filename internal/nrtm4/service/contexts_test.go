@@ -0,0 +1,69 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUseContext(t *testing.T) {
+	config := AppConfig{
+		PgDatabaseURL: "postgres://env/nrtm4",
+		NRTMFilePath:  "/env/files",
+		Contexts: map[string]ContextConfig{
+			"staging": {DatabaseURL: "postgres://staging/nrtm4"},
+			"prod":    {DatabaseURL: "postgres://prod/nrtm4", FilePath: "/prod/files", TempDir: "/prod/tmp", DefaultSource: "RIPE"},
+		},
+		CurrentContext: "staging",
+		DefaultSource:  "ARIN",
+		DefaultLabel:   "test",
+	}
+	current := config
+	if err := current.UseContext(""); err != nil {
+		t.Fatal("Failed to use current context", err)
+	}
+	if current.PgDatabaseURL != "postgres://staging/nrtm4" || current.NRTMFilePath != "/env/files" {
+		t.Error("Expected staging database and files from the environment but was", current.PgDatabaseURL, current.NRTMFilePath)
+	}
+	if current.DefaultSource != "ARIN" || current.DefaultLabel != "test" {
+		t.Error("Expected the top-level default source but was", current.DefaultSource, current.DefaultLabel)
+	}
+	prod := config
+	if err := prod.UseContext("prod"); err != nil {
+		t.Fatal("Failed to use prod context", err)
+	}
+	if prod.PgDatabaseURL != "postgres://prod/nrtm4" || prod.NRTMFilePath != "/prod/files" || prod.TempDir != "/prod/tmp" || prod.CurrentContext != "prod" {
+		t.Error("Expected prod settings but was", prod)
+	}
+	if prod.DefaultSource != "RIPE" || prod.DefaultLabel != "" {
+		t.Error("Expected the prod default source without a label but was", prod.DefaultSource, prod.DefaultLabel)
+	}
+	if err := config.UseContext("dev"); !errors.Is(err, ErrContextNotFound) {
+		t.Error("Expected ErrContextNotFound but was", err)
+	}
+}
+
+func TestSetCurrentContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	contents := `{"contexts": {"staging": {"file_path": "/staging"}, "prod": {"file_path": "/prod"}}, "groups": {"core": ["RIPE"]}}`
+	if err := os.WriteFile(path, []byte(contents), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetCurrentContext(path, "dev"); !errors.Is(err, ErrContextNotFound) {
+		t.Error("Expected ErrContextNotFound but was", err)
+	}
+	if err := SetCurrentContext(path, "prod"); err != nil {
+		t.Fatal("Failed to set context", err)
+	}
+	var config AppConfig
+	if err := ReadConfigFile(path, &config); err != nil {
+		t.Fatal("Failed to read config file", err)
+	}
+	if config.CurrentContext != "prod" || len(config.Groups["core"]) != 1 || config.Contexts["staging"].FilePath != "/staging" {
+		t.Error("Expected the current context to be set and everything else kept but was", config)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Error("Expected the file mode to be kept", info, err)
+	}
+}
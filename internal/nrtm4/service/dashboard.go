@@ -0,0 +1,106 @@
+package service
+
+import (
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// DashboardPoint is a source's history over one step of a time series, for graphing in e.g.
+// Grafana. Version is the highest version reached, LagSeconds the largest lag behind the
+// server, or nil if it isn't known, and DurationSeconds the longest update. Changes, Failures
+// and Warnings are totals over the updates in the step.
+type DashboardPoint struct {
+	Time            time.Time `json:"time"`
+	Updates         int       `json:"updates"`
+	Version         uint32    `json:"version"`
+	LagSeconds      *float64  `json:"lag_seconds"`
+	Changes         int       `json:"changes"`
+	Failures        int       `json:"failures"`
+	Warnings        int       `json:"warnings"`
+	DurationSeconds float64   `json:"duration_seconds"`
+}
+
+// Dashboard returns a time series of a source's updates which started in [from, to). With a
+// step the updates are summed into a point for each step which has any, otherwise there's a
+// point for each update.
+func (p NRTMProcessor) Dashboard(sourceName, label string, from, to time.Time, step time.Duration) ([]DashboardPoint, error) {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return nil, ErrSourceNotFound
+	}
+	runs, err := p.repo.GetSyncRuns(*source, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.query.read(len(runs)); err != nil {
+		return nil, err
+	}
+	return dashboardPoints(runs, from, step), nil
+}
+
+func dashboardPoints(runs []persist.SyncRun, from time.Time, step time.Duration) []DashboardPoint {
+	points := []DashboardPoint{}
+	for _, run := range runs {
+		at := run.Started
+		if step > 0 {
+			at = from.Add(run.Started.Sub(from).Truncate(step))
+		}
+		if len(points) == 0 || !points[len(points)-1].Time.Equal(at) {
+			points = append(points, DashboardPoint{Time: at})
+		}
+		point := &points[len(points)-1]
+		point.Updates++
+		point.Version = max(point.Version, run.ToVersion)
+		if run.Lag > 0 {
+			lag := run.Lag.Seconds()
+			if point.LagSeconds == nil || lag > *point.LagSeconds {
+				point.LagSeconds = &lag
+			}
+		}
+		point.Changes += run.Changes
+		if !run.Success {
+			point.Failures++
+		}
+		point.Warnings += run.Warnings
+		point.DurationSeconds = max(point.DurationSeconds, run.Duration.Seconds())
+	}
+	return points
+}
+
+// recordSyncRun saves the outcome of an update for the dashboard, and removes records older
+// than the retention period. A failure is only logged, so it never affects the update.
+func (p NRTMProcessor) recordSyncRun(source persist.NRTMSource, result SyncResult, updateErr error, started time.Time) {
+	duration := util.AppClock.Since(started)
+	report := newTelemetryReport(result, updateErr, duration)
+	run := persist.SyncRun{
+		RunID:       p.runID,
+		Started:     started,
+		Duration:    duration,
+		FromVersion: result.FromVersion,
+		ToVersion:   result.ToVersion,
+		Warnings:    len(result.Warnings),
+		Success:     report.Success,
+		Failure:     report.Failure,
+		Reason:      report.Reason,
+	}
+	if p.changes != nil {
+		run.Changes = int(p.changes.Load())
+	}
+	if notifications, err := p.repo.GetNotificationHistory(source, result.ToVersion, result.ToVersion); err == nil && len(notifications) > 0 {
+		if ts, err := time.Parse(time.RFC3339, notifications[0].Payload.Timestamp); err == nil {
+			run.Lag = max(started.Add(duration).Sub(ts), 0)
+		}
+	}
+	if err := p.repo.SaveSyncRun(source, run); err != nil {
+		logger.Warn("Failed to record update", "source", source.Source, "error", err)
+		return
+	}
+	if p.config.SyncRunRetention > 0 {
+		if _, err := p.repo.PruneSyncRuns(util.AppClock.Now().Add(-p.config.SyncRunRetention)); err != nil {
+			logger.Warn("Failed to remove old update records", "error", err)
+		}
+	}
+}
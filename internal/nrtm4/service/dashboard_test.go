@@ -0,0 +1,92 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+type syncRunRepo struct {
+	persist.Repository
+	runs   []persist.SyncRun
+	pruned []time.Time
+}
+
+func (r *syncRunRepo) GetSources() ([]persist.NRTMSource, error) {
+	return []persist.NRTMSource{{ID: 1, Source: "TEST"}}, nil
+}
+
+func (r *syncRunRepo) GetNotificationHistory(source persist.NRTMSource, from, to uint32) ([]persist.Notification, error) {
+	return []persist.Notification{{Version: to, Payload: protocol.NotificationJSON{NrtmFileJSON: protocol.NrtmFileJSON{Version: to}, Timestamp: "2026-10-15T12:00:00Z"}}}, nil
+}
+
+func (r *syncRunRepo) SaveSyncRun(source persist.NRTMSource, run persist.SyncRun) error {
+	r.runs = append(r.runs, run)
+	return nil
+}
+
+func (r *syncRunRepo) GetSyncRuns(source persist.NRTMSource, from, to time.Time) ([]persist.SyncRun, error) {
+	return r.runs, nil
+}
+
+func (r *syncRunRepo) PruneSyncRuns(before time.Time) (int64, error) {
+	r.pruned = append(r.pruned, before)
+	return 0, nil
+}
+
+func TestRecordSyncRun(t *testing.T) {
+	repo := &syncRunRepo{}
+	p := NRTMProcessor{repo: repo, runID: 7}
+	p.config.SyncRunRetention = 24 * time.Hour
+	source := persist.NRTMSource{ID: 1, Source: "TEST"}
+	started := time.Date(2026, 10, 15, 12, 5, 0, 0, time.UTC)
+
+	p.recordSyncRun(source, SyncResult{Source: "TEST", FromVersion: 3, ToVersion: 5}, nil, started)
+	p.recordSyncRun(source, SyncResult{Source: "TEST", FromVersion: 5, ToVersion: 5}, ErrNRTM4SourceMismatch, started)
+	if len(repo.runs) != 2 {
+		t.Fatal("Expected 2 runs to be saved but was", len(repo.runs))
+	}
+	ok, failed := repo.runs[0], repo.runs[1]
+	if !ok.Success || ok.RunID != 7 || ok.ToVersion != 5 || ok.Lag < 5*time.Minute {
+		t.Error("Unexpected run", ok)
+	}
+	if failed.Success || failed.Failure != FailureProtocol || len(failed.Reason) == 0 {
+		t.Error("Expected a protocol failure but was", failed)
+	}
+	if len(repo.pruned) != 2 {
+		t.Error("Expected old runs to be pruned after each run")
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	from := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	repo := &syncRunRepo{runs: []persist.SyncRun{
+		{Started: from.Add(10 * time.Minute), ToVersion: 10, Changes: 4, Lag: 2 * time.Minute, Success: true, Duration: time.Second},
+		{Started: from.Add(40 * time.Minute), ToVersion: 10, Success: false, Warnings: 1, Duration: 3 * time.Second},
+		{Started: from.Add(70 * time.Minute), ToVersion: 12, Changes: 6, Success: true},
+	}}
+	p := NRTMProcessor{repo: repo}
+
+	points, err := p.Dashboard("TEST", "", from, from.Add(2*time.Hour), 0)
+	if err != nil || len(points) != 3 || points[1].LagSeconds != nil || points[1].Failures != 1 {
+		t.Error("Expected a point for each update but was", points, err)
+	}
+	points, err = p.Dashboard("TEST", "", from, from.Add(2*time.Hour), time.Hour)
+	if err != nil || len(points) != 2 {
+		t.Fatal("Expected a point for each hour but was", points, err)
+	}
+	first := points[0]
+	if !first.Time.Equal(from) || first.Updates != 2 || first.Version != 10 || first.Changes != 4 || first.Failures != 1 ||
+		first.Warnings != 1 || first.DurationSeconds != 3 || first.LagSeconds == nil || *first.LagSeconds != 120 {
+		t.Error("Unexpected first hour", first)
+	}
+	if !points[1].Time.Equal(from.Add(time.Hour)) || points[1].Version != 12 {
+		t.Error("Unexpected second hour", points[1])
+	}
+	if _, err = p.Dashboard("OTHER", "", from, from, 0); !errors.Is(err, ErrSourceNotFound) {
+		t.Error("Expected ErrSourceNotFound but was", err)
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// ErrInvalidDatabase the database settings in the config file can't be used
+var ErrInvalidDatabase = errors.New("invalid database settings")
+
+// defaultPasswordTTL is how long a password from password_command is used for. IAM tokens are
+// typically valid for 15 minutes.
+const defaultPasswordTTL = 10 * time.Minute
+
+// DatabaseConfig connects to PostgreSQL without a password in PG_DATABASE_URL: through a unix
+// socket with peer authentication, or with a password read from a file or printed by a
+// command, such as a cloud provider's IAM token generator or a secret store's client.
+type DatabaseConfig struct {
+	// Socket is the directory of the server's unix socket. It's used when PG_DATABASE_URL
+	// isn't set, and without a password the server can use peer authentication.
+	Socket string `json:"socket"`
+	// Name and User are the database and role when connecting through Socket. The default for
+	// both is the operating system user's name.
+	Name string `json:"name"`
+	User string `json:"user"`
+	// PasswordFile is read for each new connection, so it can be rotated by a secret store's
+	// agent
+	PasswordFile string `json:"password_file"`
+	// PasswordCommand and its arguments print the password. It's run again when the password
+	// is older than PasswordTTL.
+	PasswordCommand []string `json:"password_command"`
+	PasswordTTL     string   `json:"password_ttl"`
+	// CapacityMiB is the space the database can grow to, for forecast. It can't be read from
+	// the server, which may well be on another host.
+	CapacityMiB int64 `json:"capacity_mib"`
+}
+
+func (c DatabaseConfig) validate() error {
+	if len(c.PasswordFile) > 0 && len(c.PasswordCommand) > 0 {
+		return fmt.Errorf("%w: password_file and password_command can't both be set", ErrInvalidDatabase)
+	}
+	if len(c.PasswordTTL) > 0 {
+		if _, err := time.ParseDuration(c.PasswordTTL); err != nil {
+			return fmt.Errorf("%w: password_ttl: %v", ErrInvalidDatabase, err)
+		}
+	}
+	if c.CapacityMiB < 0 {
+		return fmt.Errorf("%w: capacity_mib can't be negative", ErrInvalidDatabase)
+	}
+	if len(c.Socket) > 0 && !strings.HasPrefix(c.Socket, "/") {
+		return fmt.Errorf("%w: socket must be an absolute path: %v", ErrInvalidDatabase, c.Socket)
+	}
+	return nil
+}
+
+// DatabaseURL is PG_DATABASE_URL, or a connection string for the database settings' socket
+// when it isn't set
+func (c AppConfig) DatabaseURL() string {
+	if len(c.PgDatabaseURL) > 0 || len(c.Database.Socket) == 0 {
+		return c.PgDatabaseURL
+	}
+	dsn := []string{"host=" + quoteDSN(c.Database.Socket)}
+	if len(c.Database.Name) > 0 {
+		dsn = append(dsn, "dbname="+quoteDSN(c.Database.Name))
+	}
+	if len(c.Database.User) > 0 {
+		dsn = append(dsn, "user="+quoteDSN(c.Database.User))
+	}
+	return strings.Join(dsn, " ")
+}
+
+// quoteDSN quotes a value in a key=value connection string
+func quoteDSN(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// Password returns the function which gets the password for each new connection, or nil when
+// the password, if there is one, is in the URL
+func (c DatabaseConfig) Password() func(context.Context) (string, error) {
+	switch {
+	case len(c.PasswordFile) > 0:
+		return func(context.Context) (string, error) {
+			bytes, err := os.ReadFile(c.PasswordFile)
+			if err != nil {
+				return "", fmt.Errorf("reading password_file: %w", err)
+			}
+			return strings.TrimRight(string(bytes), "\r\n"), nil
+		}
+	case len(c.PasswordCommand) > 0:
+		ttl := defaultPasswordTTL
+		if d, err := time.ParseDuration(c.PasswordTTL); err == nil {
+			ttl = d
+		}
+		cmd := &passwordCommand{args: c.PasswordCommand, ttl: ttl}
+		return cmd.password
+	}
+	return nil
+}
+
+// passwordCommand runs a command for a password, and keeps it for ttl
+type passwordCommand struct {
+	args    []string
+	ttl     time.Duration
+	mu      sync.Mutex
+	current string
+	expires time.Time
+}
+
+func (pc *passwordCommand) password(ctx context.Context) (string, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if len(pc.current) > 0 && util.AppClock.Now().Before(pc.expires) {
+		return pc.current, nil
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, pc.args[0], pc.args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		logger.Error("Database password command failed", "command", pc.args[0], "error", err, "stderr", stderr.String())
+		return "", fmt.Errorf("password_command: %w", err)
+	}
+	pc.current = strings.TrimRight(stdout.String(), "\r\n")
+	pc.expires = util.AppClock.Now().Add(pc.ttl)
+	logger.Debug("Database password refreshed", "command", pc.args[0], "ttl", pc.ttl)
+	return pc.current, nil
+}
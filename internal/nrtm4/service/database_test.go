@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+func TestDatabaseURL(t *testing.T) {
+	config := AppConfig{Database: DatabaseConfig{Socket: "/var/run/postgresql", Name: "nrtm4", User: "o'brien"}}
+	if url := config.DatabaseURL(); url != `host='/var/run/postgresql' dbname='nrtm4' user='o\'brien'` {
+		t.Error("Unexpected connection string", url)
+	}
+	config.PgDatabaseURL = "postgres://nrtm4@db/nrtm4"
+	if url := config.DatabaseURL(); url != config.PgDatabaseURL {
+		t.Error("Expected PG_DATABASE_URL to be used when it's set but was", url)
+	}
+	if url := (AppConfig{}).DatabaseURL(); len(url) > 0 {
+		t.Error("Expected no URL but was", url)
+	}
+}
+
+func TestDatabaseConfigValidate(t *testing.T) {
+	for _, c := range []DatabaseConfig{
+		{PasswordFile: "/run/secrets/pg", PasswordCommand: []string{"vault"}},
+		{PasswordCommand: []string{"vault"}, PasswordTTL: "soon"},
+		{Socket: "run/postgresql"},
+	} {
+		if err := c.validate(); !errors.Is(err, ErrInvalidDatabase) {
+			t.Error("Expected ErrInvalidDatabase for", c, "but was", err)
+		}
+	}
+	if err := (DatabaseConfig{Socket: "/tmp", PasswordCommand: []string{"vault"}, PasswordTTL: "5m"}).validate(); err != nil {
+		t.Error("Unexpected error", err)
+	}
+}
+
+func TestDatabasePasswordFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal("Failed to write password file", err)
+	}
+	password := DatabaseConfig{PasswordFile: path}.Password()
+	if pw, err := password(context.Background()); err != nil || pw != "s3cret" {
+		t.Error("Expected the file's password but was", pw, err)
+	}
+	os.WriteFile(path, []byte("rotated"), 0600)
+	if pw, _ := password(context.Background()); pw != "rotated" {
+		t.Error("Expected the file to be read again but was", pw)
+	}
+	if (DatabaseConfig{}).Password() != nil {
+		t.Error("Expected no password function without a file or command")
+	}
+}
+
+func TestDatabasePasswordCommand(t *testing.T) {
+	runs := filepath.Join(t.TempDir(), "runs")
+	cmd := []string{"sh", "-c", "echo run >> " + runs + "; echo token"}
+	password := DatabaseConfig{PasswordCommand: cmd}.Password()
+	for range 2 {
+		if pw, err := password(context.Background()); err != nil || pw != "token" {
+			t.Fatal("Expected the command's password but was", pw, err)
+		}
+	}
+	if bytes, _ := os.ReadFile(runs); strings.Count(string(bytes), "run") != 1 {
+		t.Error("Expected the password to be kept between connections but the command ran", string(bytes))
+	}
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	clock := util.NewManualClock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	util.AppClock = clock
+	password = DatabaseConfig{PasswordCommand: cmd, PasswordTTL: "10m"}.Password()
+	password(context.Background())
+	clock.Advance(9 * time.Minute)
+	password(context.Background())
+	if bytes, _ := os.ReadFile(runs); strings.Count(string(bytes), "run") != 2 {
+		t.Error("Expected the password to be kept until the TTL but the command ran", string(bytes))
+	}
+	clock.Advance(time.Minute)
+	password(context.Background())
+	if bytes, _ := os.ReadFile(runs); strings.Count(string(bytes), "run") != 3 {
+		t.Error("Expected the command to run again after the TTL but it ran", string(bytes))
+	}
+	password = DatabaseConfig{PasswordCommand: cmd, PasswordTTL: "0s"}.Password()
+	password(context.Background())
+	password(context.Background())
+	if bytes, _ := os.ReadFile(runs); strings.Count(string(bytes), "run") != 5 {
+		t.Error("Expected the command to run for each connection with a TTL of 0 but it ran", string(bytes))
+	}
+	failing := DatabaseConfig{PasswordCommand: []string{"false"}}.Password()
+	if _, err := failing(context.Background()); err == nil {
+		t.Error("Expected an error when the command fails")
+	}
+}
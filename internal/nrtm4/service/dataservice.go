@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
 )
 
 // ErrNRTMServiceError is when sth is wrong with the NRTM server
@@ -54,7 +55,7 @@ func (ds NrtmDataService) getSourceByNameAndLabel(name string, label string) *pe
 	return nil
 }
 
-func (ds NrtmDataService) deleteSource(source persist.NRTMSource) error {
+func (ds NrtmDataService) deleteSource(source persist.NRTMSource) (persist.RemovedRows, error) {
 	return ds.Repository.RemoveSource(source)
 }
 
@@ -66,6 +67,6 @@ func (ds NrtmDataService) getNotifications(src persist.NRTMSource, from, to uint
 	return ds.Repository.GetNotificationHistory(src, from, to)
 }
 
-func (ds NrtmDataService) saveNewSource(source persist.NRTMSource, notification persist.NotificationJSON) (persist.NRTMSource, error) {
+func (ds NrtmDataService) saveNewSource(source persist.NRTMSource, notification protocol.NotificationJSON) (persist.NRTMSource, error) {
 	return ds.Repository.SaveSource(source, notification)
 }
@@ -0,0 +1,271 @@
+package service
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+var (
+	// ErrDelegatedStatsNotConfigured there's no stats file for the source in the config or on the command line
+	ErrDelegatedStatsNotConfigured = errors.New("no delegated stats file for source")
+	// ErrInvalidDelegatedStats the stats file isn't in the RIR statistics exchange format
+	ErrInvalidDelegatedStats = errors.New("invalid delegated stats file")
+
+	// delegatedObjectTypes are the objects which are checked against the stats file
+	delegatedObjectTypes = []string{"INETNUM", "INET6NUM", "AUT-NUM"}
+)
+
+// DelegatedStatsConfig points to an RIR's delegated-extended stats file, e.g.
+// https://ftp.ripe.net/pub/stats/ripencc/delegated-ripencc-extended-latest
+type DelegatedStatsConfig struct {
+	// URL or path of the stats file
+	URL string `json:"url"`
+	// Registry only uses records for this registry, e.g. ripencc, when the file has several
+	Registry string `json:"registry"`
+}
+
+// DelegationReport lists a source's inetnum, inet6num and aut-num objects for resources the
+// stats file doesn't show as allocated or assigned
+type DelegationReport struct {
+	StatsFile   string
+	Registry    string
+	Checked     int
+	Unparsed    int
+	Undelegated []UndelegatedObject
+}
+
+// UndelegatedObject is an object for resources which weren't delegated, or only partly
+// delegated, to the registry
+type UndelegatedObject struct {
+	ObjectType string
+	PrimaryKey string
+	Partly     bool
+}
+
+type span[T any] struct {
+	first, last T
+}
+
+// delegations are the merged ranges of delegated addresses and AS numbers
+type delegations struct {
+	addrs []span[netip.Addr]
+	asns  []span[uint32]
+}
+
+// CheckDelegations cross-references a source's objects with an RIR's delegated stats. The stats
+// file comes from the source's `delegated_stats` config unless statsFile is given.
+func (p NRTMProcessor) CheckDelegations(sourceName, label, statsFile, registry string) (DelegationReport, error) {
+	report := DelegationReport{Undelegated: []UndelegatedObject{}}
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return report, ErrSourceNotFound
+	}
+	if cfg := p.config.sourceConfig(source.Source).DelegatedStats; cfg != nil {
+		statsFile = cmp.Or(statsFile, cfg.URL)
+		registry = cmp.Or(registry, cfg.Registry)
+	}
+	if len(statsFile) == 0 {
+		return report, fmt.Errorf("%w %v", ErrDelegatedStatsNotConfigured, source.Source)
+	}
+	report.StatsFile, report.Registry = statsFile, registry
+	reader, err := p.openFileOrURL(statsFile)
+	if err != nil {
+		return report, err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	delegated, err := readDelegatedStats(reader, registry)
+	if err != nil {
+		return report, err
+	}
+	err = p.repo.GetCurrentObjects(*source, 0, delegatedObjectTypes, func(obj rpsl.Rpsl) error {
+		report.Checked++
+		covered, overlaps, ok := delegated.check(obj)
+		if !ok {
+			logger.Debug("Cannot parse resource", "type", obj.ObjectType, "key", obj.PrimaryKey)
+			report.Unparsed++
+		} else if !covered {
+			report.Undelegated = append(report.Undelegated, UndelegatedObject{
+				ObjectType: obj.ObjectType,
+				PrimaryKey: obj.PrimaryKey,
+				Partly:     overlaps,
+			})
+		}
+		return nil
+	})
+	return report, err
+}
+
+// openFileOrURL opens a local file, or fetches the body of an http(s) URL
+func (p NRTMProcessor) openFileOrURL(location string) (io.Reader, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return p.client.getResponseBody(location)
+	}
+	return os.Open(location)
+}
+
+// readDelegatedStats reads the allocated and assigned records from a file in the RIR statistics
+// exchange format. If registry is given, other registries' records are ignored.
+func readDelegatedStats(r io.Reader, registry string) (delegations, error) {
+	var d delegations
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		if _, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			// version line
+			continue
+		}
+		if len(fields) >= 6 && fields[5] == "summary" {
+			continue
+		}
+		if len(fields) < 7 {
+			return d, fmt.Errorf("%w: line %d has %d fields", ErrInvalidDelegatedStats, lineNo, len(fields))
+		}
+		if len(registry) > 0 && !strings.EqualFold(fields[0], registry) {
+			continue
+		}
+		if status := fields[6]; status != "allocated" && status != "assigned" {
+			continue
+		}
+		if err := d.add(fields[2], fields[3], fields[4]); err != nil {
+			return d, fmt.Errorf("%w: line %d: %v", ErrInvalidDelegatedStats, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return d, err
+	}
+	d.addrs = mergeSpans(d.addrs, netip.Addr.Compare, netip.Addr.Next)
+	d.asns = mergeSpans(d.asns, cmp.Compare[uint32], func(n uint32) uint32 { return n + 1 })
+	return d, nil
+}
+
+// add a record. For ipv4 and asn the value is a count, for ipv6 it's a prefix length.
+func (d *delegations) add(resourceType, start, value string) error {
+	switch resourceType {
+	case "asn":
+		first, err := strconv.ParseUint(start, 10, 32)
+		if err != nil {
+			return err
+		}
+		count, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || count == 0 {
+			return fmt.Errorf("invalid asn count %q", value)
+		}
+		d.asns = append(d.asns, span[uint32]{uint32(first), uint32(first + count - 1)})
+	case "ipv4":
+		first, err := netip.ParseAddr(start)
+		if err != nil || !first.Is4() {
+			return fmt.Errorf("invalid ipv4 address %q", start)
+		}
+		count, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || count == 0 {
+			return fmt.Errorf("invalid ipv4 count %q", value)
+		}
+		a4 := first.As4()
+		last := binary.BigEndian.Uint32(a4[:]) + uint32(count-1)
+		binary.BigEndian.PutUint32(a4[:], last)
+		d.addrs = append(d.addrs, span[netip.Addr]{first, netip.AddrFrom4(a4)})
+	case "ipv6":
+		first, err := netip.ParseAddr(start)
+		if err != nil || !first.Is6() {
+			return fmt.Errorf("invalid ipv6 address %q", start)
+		}
+		bits, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		prefix, err := first.Prefix(bits)
+		if err != nil {
+			return err
+		}
+		d.addrs = append(d.addrs, span[netip.Addr]{prefix.Addr(), lastAddr(prefix)})
+	}
+	return nil
+}
+
+// check says whether an object's resources are covered by delegations, and if not, whether
+// some of them are. ok is false if the primary key can't be parsed.
+func (d delegations) check(obj rpsl.Rpsl) (covered, overlaps, ok bool) {
+	switch obj.ObjectType {
+	case "INETNUM":
+		lo, hi, found := strings.Cut(obj.PrimaryKey, "-")
+		first, err1 := netip.ParseAddr(strings.TrimSpace(lo))
+		last, err2 := netip.ParseAddr(strings.TrimSpace(hi))
+		if !found || err1 != nil || err2 != nil || last.Less(first) {
+			return false, false, false
+		}
+		covered, overlaps = coverage(d.addrs, span[netip.Addr]{first, last}, netip.Addr.Compare)
+	case "INET6NUM":
+		prefix, err := netip.ParsePrefix(obj.PrimaryKey)
+		if err != nil {
+			return false, false, false
+		}
+		prefix = prefix.Masked()
+		covered, overlaps = coverage(d.addrs, span[netip.Addr]{prefix.Addr(), lastAddr(prefix)}, netip.Addr.Compare)
+	case "AUT-NUM":
+		asn, err := strconv.ParseUint(strings.TrimPrefix(obj.PrimaryKey, "AS"), 10, 32)
+		if err != nil {
+			return false, false, false
+		}
+		covered, overlaps = coverage(d.asns, span[uint32]{uint32(asn), uint32(asn)}, cmp.Compare[uint32])
+	default:
+		return false, false, false
+	}
+	return covered, overlaps, true
+}
+
+// lastAddr is the highest address in a prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// mergeSpans sorts spans and joins the ones which overlap or are next to each other
+func mergeSpans[T any](spans []span[T], compare func(T, T) int, next func(T) T) []span[T] {
+	slices.SortFunc(spans, func(a, b span[T]) int { return compare(a.first, b.first) })
+	merged := []span[T]{}
+	for _, s := range spans {
+		if n := len(merged); n > 0 {
+			prev := &merged[n-1]
+			if compare(s.first, prev.last) <= 0 || compare(s.first, next(prev.last)) == 0 {
+				if compare(s.last, prev.last) > 0 {
+					prev.last = s.last
+				}
+				continue
+			}
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// coverage says whether s is inside one of the merged spans, or at least overlaps them
+func coverage[T any](merged []span[T], s span[T], compare func(T, T) int) (covered, overlaps bool) {
+	// the first span which ends at or after the start of s
+	i, _ := slices.BinarySearchFunc(merged, s.first, func(m span[T], t T) int { return compare(m.last, t) })
+	if i == len(merged) || compare(merged[i].first, s.last) > 0 {
+		return false, false
+	}
+	return compare(merged[i].first, s.first) <= 0 && compare(merged[i].last, s.last) >= 0, true
+}
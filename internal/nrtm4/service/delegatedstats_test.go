@@ -0,0 +1,57 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+var delegatedStatsExample = `# comment
+2|ripencc|1700000000|6|19830705|20250301|+0100
+ripencc|*|ipv4|*|3|summary
+ripencc|*|ipv6|*|1|summary
+ripencc|*|asn|*|2|summary
+ripencc|NL|ipv4|192.0.2.0|128|20100101|allocated|abc
+ripencc|NL|ipv4|192.0.2.128|128|20100101|assigned|abc
+ripencc|DE|ipv4|198.51.100.0|256|20100101|available||
+ripencc|NL|ipv6|2001:db8::|32|20100101|allocated|abc
+ripencc|NL|asn|65000|10|20100101|assigned|abc
+arin|US|asn|65010|10|20100101|assigned|def
+`
+
+func TestCheckDelegatedResources(t *testing.T) {
+	d, err := readDelegatedStats(strings.NewReader(delegatedStatsExample), "ripencc")
+	if err != nil {
+		t.Fatal("Failed to read stats", err)
+	}
+	expected := map[rpsl.Rpsl][2]bool{
+		{ObjectType: "INETNUM", PrimaryKey: "192.0.2.0 - 192.0.2.255"}:       {true, true},
+		{ObjectType: "INETNUM", PrimaryKey: "192.0.2.64 - 192.0.2.127"}:      {true, true},
+		{ObjectType: "INETNUM", PrimaryKey: "192.0.2.0 - 192.0.3.255"}:       {false, true},
+		{ObjectType: "INETNUM", PrimaryKey: "198.51.100.0 - 198.51.100.255"}: {false, false},
+		{ObjectType: "INET6NUM", PrimaryKey: "2001:DB8:1234::/48"}:           {true, true},
+		{ObjectType: "INET6NUM", PrimaryKey: "2001:DB9::/32"}:                {false, false},
+		{ObjectType: "AUT-NUM", PrimaryKey: "AS65009"}:                       {true, true},
+		{ObjectType: "AUT-NUM", PrimaryKey: "AS65010"}:                       {false, false},
+	}
+	for obj, exp := range expected {
+		covered, overlaps, ok := d.check(obj)
+		if !ok || covered != exp[0] || overlaps != exp[1] {
+			t.Error("Unexpected result for", obj.PrimaryKey, covered, overlaps, ok)
+		}
+	}
+	if _, _, ok := d.check(rpsl.Rpsl{ObjectType: "AUT-NUM", PrimaryKey: "ASX"}); ok {
+		t.Error("Expected an invalid key not to be parsed")
+	}
+}
+
+func TestReadInvalidDelegatedStats(t *testing.T) {
+	if _, err := readDelegatedStats(strings.NewReader("ripencc|NL|ipv4|192.0.2.0\n"), ""); !errors.Is(err, ErrInvalidDelegatedStats) {
+		t.Error("Expected ErrInvalidDelegatedStats but was", err)
+	}
+	if _, err := readDelegatedStats(strings.NewReader("ripencc|NL|ipv4|nowhere|256|20100101|allocated\n"), ""); !errors.Is(err, ErrInvalidDelegatedStats) {
+		t.Error("Expected ErrInvalidDelegatedStats but was", err)
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/jsonseq"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/quirks"
+)
+
+// DeltaOrdering is how strictly validate checks the order of the changes inside each delta file
+type DeltaOrdering string
+
+const (
+	// OrderingOff the changes inside delta files aren't checked
+	OrderingOff DeltaOrdering = ""
+	// OrderingStrict an object may not have the same action twice in a delta, nor be deleted
+	// after an add_modify
+	OrderingStrict DeltaOrdering = "strict"
+	// OrderingAllowAddDelete is OrderingStrict, but an object may be deleted after an add_modify
+	// in the same delta, e.g. when it was created and removed between two versions
+	OrderingAllowAddDelete DeltaOrdering = "allow-add-delete"
+)
+
+// ErrInvalidDeltaOrdering the ordering isn't one validate knows
+var ErrInvalidDeltaOrdering = errors.New("ordering must be strict or allow-add-delete")
+
+// ParseDeltaOrdering reads the validate command's --ordering option. The empty string is
+// OrderingOff.
+func ParseDeltaOrdering(s string) (DeltaOrdering, error) {
+	switch ordering := DeltaOrdering(strings.ToLower(strings.TrimSpace(s))); ordering {
+	case OrderingOff, OrderingStrict, OrderingAllowAddDelete:
+		return ordering, nil
+	}
+	return OrderingOff, fmt.Errorf("%w: %v", ErrInvalidDeltaOrdering, s)
+}
+
+// orderedChange is the last change to an object in a delta, and the record it's in
+type orderedChange struct {
+	action string
+	record int
+}
+
+// checkDeltaOrdering reads the changes of a delta file and lists each one which breaks the
+// ordering rules. Objects are parsed with objectQuirks, and records which can't be parsed are
+// left for lint-delta to report.
+func checkDeltaOrdering(r io.Reader, ordering DeltaOrdering, objectQuirks quirks.Set) ([]string, error) {
+	violations := []string{}
+	revision := protocol.Current
+	last := map[string]orderedChange{}
+	n := 0
+	err := jsonseq.ReadRecords(bufio.NewReaderSize(r, jsonSeqReadBufferSize), func(record []byte, err error) error {
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(record) == 0 {
+			return nil
+		}
+		n++
+		if n == 1 {
+			_, revision, _ = protocol.DecodeHeader(record)
+			return nil
+		}
+		change, err := revision.DecodeChange(record)
+		if err != nil {
+			return nil
+		}
+		key, ok := deltaChangeKey(change, objectQuirks)
+		if !ok {
+			return nil
+		}
+		if prev, seen := last[key]; seen {
+			if v := orderingViolation(key, prev, orderedChange{change.Action, n}, ordering); len(v) > 0 {
+				violations = append(violations, v)
+			}
+		}
+		last[key] = orderedChange{change.Action, n}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return violations, err
+	}
+	return violations, nil
+}
+
+// orderingViolation describes what's wrong with a change to an object which was already
+// changed in the delta, or returns the empty string if it's allowed
+func orderingViolation(key string, prev, cur orderedChange, ordering DeltaOrdering) string {
+	switch {
+	case prev.action == cur.action:
+		return fmt.Sprintf("%v has %v in records %d and %d", key, cur.action, prev.record, cur.record)
+	case prev.action == persist.DeltaAddModifyAction && cur.action == persist.DeltaDeleteAction && ordering != OrderingAllowAddDelete:
+		return fmt.Sprintf("%v is deleted in record %d after add_modify in record %d", key, cur.record, prev.record)
+	}
+	return ""
+}
+
+// deltaChangeKey is the object type and primary key a change is for
+func deltaChangeKey(change protocol.DeltaJSON, objectQuirks quirks.Set) (string, bool) {
+	switch change.Action {
+	case persist.DeltaAddModifyAction:
+		if change.Object == nil {
+			return "", false
+		}
+		obj, err := objectQuirks.Parse(*change.Object)
+		if err != nil {
+			return "", false
+		}
+		return obj.ObjectType + " " + obj.PrimaryKey, true
+	case persist.DeltaDeleteAction:
+		if change.ObjectClass == nil || change.PrimaryKey == nil {
+			return "", false
+		}
+		return strings.ToUpper(*change.ObjectClass) + " " + strings.ToUpper(*change.PrimaryKey), true
+	}
+	return "", false
+}
+
+// orderingError summarizes the violations found in the delta files, or is nil if there were none
+func orderingError(violations []string) error {
+	switch len(violations) {
+	case 0:
+		return nil
+	case 1:
+		return errors.New(violations[0])
+	}
+	return fmt.Errorf("%v, and %d more", violations[0], len(violations)-1)
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/quirks"
+)
+
+var disorderedDelta = deltaSeq(lintHeader,
+	`{"action": "add_modify", "object": "mntner: A-MNT\nsource: EXAMPLE\n"}`,
+	`{"action": "delete", "object_class": "mntner", "primary_key": "a-mnt"}`,
+	`{"action": "delete", "object_class": "mntner", "primary_key": "B-MNT"}`,
+	`{"action": "delete", "object_class": "MNTNER", "primary_key": "B-MNT"}`,
+	`{"action": "add_modify", "object": "mntner: C-MNT\nsource: EXAMPLE\n"}`,
+	`{"action": "delete", "object_class": "mntner", "primary_key": "C-MNT"}`,
+	`{"action": "add_modify", "object": "mntner: C-MNT\nsource: EXAMPLE\n"}`,
+)
+
+func TestCheckDeltaOrdering(t *testing.T) {
+	violations, err := checkDeltaOrdering(strings.NewReader(disorderedDelta), OrderingStrict, quirks.Set{})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	expected := []string{
+		"MNTNER A-MNT is deleted in record 3 after add_modify in record 2",
+		"MNTNER B-MNT has delete in records 4 and 5",
+		"MNTNER C-MNT is deleted in record 7 after add_modify in record 6",
+	}
+	if strings.Join(violations, "\n") != strings.Join(expected, "\n") {
+		t.Error("Expected", expected, "but was", violations)
+	}
+	violations, _ = checkDeltaOrdering(strings.NewReader(disorderedDelta), OrderingAllowAddDelete, quirks.Set{})
+	if len(violations) != 1 || !strings.Contains(violations[0], "B-MNT") {
+		t.Error("Expected only the repeated delete but was", violations)
+	}
+}
+
+func TestCheckDeltaOrderingWithQuirks(t *testing.T) {
+	delta := deltaSeq(lintHeader,
+		`{"action": "add_modify", "object": "mntner: \"A-MNT\"\n"}`,
+		`{"action": "delete", "object_class": "mntner", "primary_key": "A-MNT"}`,
+	)
+	if violations, _ := checkDeltaOrdering(strings.NewReader(delta), OrderingStrict, quirks.Set{}); len(violations) != 0 {
+		t.Error("Expected an object which can't be parsed to be skipped but was", violations)
+	}
+	objectQuirks, _ := quirks.ForSource("EXAMPLE", []string{"quoted-values", "missing-source"})
+	violations, _ := checkDeltaOrdering(strings.NewReader(delta), OrderingStrict, objectQuirks)
+	if len(violations) != 1 || !strings.Contains(violations[0], "MNTNER A-MNT is deleted") {
+		t.Error("Expected the object to be parsed with the quirks but was", violations)
+	}
+}
+
+func TestParseDeltaOrdering(t *testing.T) {
+	for s, expected := range map[string]DeltaOrdering{"": OrderingOff, "Strict": OrderingStrict, "allow-add-delete": OrderingAllowAddDelete} {
+		if ordering, err := ParseDeltaOrdering(s); err != nil || ordering != expected {
+			t.Error("Expected", expected, "for", s, "but was", ordering, err)
+		}
+	}
+	if _, err := ParseDeltaOrdering("loose"); !errors.Is(err, ErrInvalidDeltaOrdering) {
+		t.Error("Expected ErrInvalidDeltaOrdering but was", err)
+	}
+}
+
+func TestConformanceDeltaOrdering(t *testing.T) {
+	sum := sha256.Sum256([]byte(disorderedDelta))
+	hash := hex.EncodeToString(sum[:])
+	sessionID := "ca128382-78d9-41d1-8927-1ecef15275be"
+	notification := protocol.NotificationJSON{
+		NrtmFileJSON: protocol.NrtmFileJSON{NrtmVersion: 4, Type: "notification", Source: "EXAMPLE", SessionID: sessionID, Version: 3},
+		SnapshotRef:  protocol.FileRefJSON{URL: "nrtm-snapshot.2.EXAMPLE." + sessionID + ".1c4e.json.gz", Version: 2, Hash: hash},
+		DeltaRefs:    []protocol.FileRefJSON{{URL: "nrtm-delta.3.EXAMPLE." + sessionID + ".7a2f.json", Version: 3, Hash: hash}},
+	}
+	p := NRTMProcessor{client: stubDeltaClient{notification: notification, responseBody: disorderedDelta}}
+	find := func(report ConformanceReport) ConformanceCheck {
+		for _, c := range report.Checks {
+			if c.ID == "deltas.ordering" {
+				return c
+			}
+		}
+		return ConformanceCheck{}
+	}
+	if check := find(p.CheckConformance("https://example.com/notification.json", true, false, OrderingOff)); check.Status != CheckSkipped {
+		t.Error("Expected the ordering check to be skipped but was", check)
+	}
+	report := p.CheckConformance("https://example.com/notification.json", true, false, OrderingStrict)
+	check := find(report)
+	if check.Status != CheckFailed || report.ExitCode != ExitErrors {
+		t.Fatal("Expected the ordering check to fail but was", check, report.ExitCode)
+	}
+	if !strings.HasPrefix(check.Message, "delta 3: MNTNER A-MNT") || !strings.HasSuffix(check.Message, "and 2 more") {
+		t.Error("Unexpected message", check.Message)
+	}
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// ErrInvalidDigestPeriod the digest period is not one we know about
+var ErrInvalidDigestPeriod = errors.New("digest period must be 'daily' or 'weekly'")
+
+// DigestConfig schedules a source's change digest
+type DigestConfig struct {
+	// Period is daily or weekly. Daily digests are sent at midnight UTC, and weekly ones at
+	// midnight UTC on Monday.
+	Period string `json:"period"`
+}
+
+func (c *DigestConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	_, err := DigestPeriodStart(c.Period)
+	return err
+}
+
+// ChangeDigest is a summary of changes to a source over a period. Violations are the updates
+// which failed because the server broke the protocol.
+type ChangeDigest struct {
+	Source persist.NRTMSource
+	Period string
+	Since  time.Time
+	Until  time.Time
+	persist.ChangeSummary
+	Violations []persist.SyncRun
+}
+
+// DigestPeriodStart returns the start of a daily or weekly period which ends now
+func DigestPeriodStart(period string) (time.Time, error) {
+	now := util.AppClock.Now()
+	switch strings.ToLower(period) {
+	case "daily":
+		return now.Add(-24 * time.Hour), nil
+	case "weekly":
+		return now.Add(-7 * 24 * time.Hour), nil
+	}
+	return now, ErrInvalidDigestPeriod
+}
+
+// Digest summarizes the changes to a source over a period
+func (p NRTMProcessor) Digest(sourceName, label, period string) (ChangeDigest, error) {
+	var digest ChangeDigest
+	since, err := DigestPeriodStart(period)
+	if err != nil {
+		return digest, err
+	}
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return digest, ErrSourceNotFound
+	}
+	summary, err := p.repo.GetChangeSummary(*source, since)
+	if err != nil {
+		return digest, err
+	}
+	until := util.AppClock.Now()
+	runs, err := p.repo.GetSyncRuns(*source, since, until)
+	if err != nil {
+		return digest, err
+	}
+	violations := []persist.SyncRun{}
+	for _, run := range runs {
+		// Reason is only set for protocol errors
+		if !run.Success && len(run.Reason) > 0 {
+			violations = append(violations, run)
+		}
+	}
+	return ChangeDigest{
+		Source:        *source,
+		Period:        strings.ToLower(period),
+		Since:         since,
+		Until:         until,
+		ChangeSummary: summary,
+		Violations:    violations,
+	}, nil
+}
+
+// nextDigest is when the digests of a period are next due after now: midnight UTC for daily
+// ones, and midnight UTC on Monday for weekly ones
+func nextDigest(period string, now time.Time) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	if strings.EqualFold(period, "weekly") {
+		for next.Weekday() != time.Monday {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	return next
+}
+
+// RunDigests sends the digest of each source with a digest in the config when it's due, until
+// ctx is done. A digest which is due while nothing is running isn't sent later, so digests are
+// never sent twice; run digest --send from cron when every one is needed.
+func (p NRTMProcessor) RunDigests(ctx context.Context) {
+	periods := map[string]bool{}
+	for _, sc := range p.config.Sources {
+		if sc.Digest != nil {
+			periods[strings.ToLower(sc.Digest.Period)] = true
+		}
+	}
+	if len(periods) == 0 {
+		return
+	}
+	for {
+		now := util.AppClock.Now()
+		var due time.Time
+		for period := range periods {
+			if next := nextDigest(period, now); due.IsZero() || next.Before(due) {
+				due = next
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-util.AppClock.After(due.Sub(now)):
+		}
+		for period := range periods {
+			if nextDigest(period, due.Add(-time.Second)).Equal(due) {
+				p.sendDigests(period)
+			}
+		}
+	}
+}
+
+// sendDigests sends the digest of each current source whose config has the period. A failure
+// is logged, and the other sources' digests are still sent.
+func (p NRTMProcessor) sendDigests(period string) {
+	sources, err := NrtmDataService{Repository: p.repo}.getSources()
+	if err != nil {
+		logger.Error("Cannot list sources for digests", "period", period, "error", err)
+		return
+	}
+	for _, source := range sources {
+		cfg := p.config.sourceConfig(source.Source).Digest
+		if source.Superseded != nil || cfg == nil || !strings.EqualFold(cfg.Period, period) {
+			continue
+		}
+		digest, err := p.Digest(source.Source, source.Label, period)
+		if err == nil {
+			err = p.SendDigest(digest)
+		}
+		if err != nil {
+			logger.Error("Failed to send digest", "source", source.Source, "label", source.Label, "period", period, "error", err)
+			continue
+		}
+		logger.Info("Sent digest", "source", source.Source, "label", source.Label, "period", period)
+	}
+}
+
+// SendDigest sends a digest with the configured notifier
+func (p NRTMProcessor) SendDigest(digest ChangeDigest) error {
+	return p.newNotifier().Notify(digest.Subject(), digest.String())
+}
+
+// Subject is a one-liner for the digest
+func (d ChangeDigest) Subject() string {
+	return fmt.Sprintf("NRTMv4 %v digest for %v", d.Period, sourceDisplayName(d.Source))
+}
+
+func (d ChangeDigest) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Source       : %v\n", sourceDisplayName(d.Source))
+	fmt.Fprintf(&b, "Period       : %v to %v\n", d.Since.Format(time.RFC3339), d.Until.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Versions     : %v to %v\n", d.FromVersion, d.ToVersion)
+	fmt.Fprintf(&b, "Added        : %v\n", d.Added)
+	fmt.Fprintf(&b, "Modified     : %v\n", d.Modified)
+	fmt.Fprintf(&b, "Deleted      : %v\n", d.Deleted)
+	if len(d.TopMaintainers) > 0 {
+		b.WriteString("Top maintainers by change count\n")
+		for _, mc := range d.TopMaintainers {
+			fmt.Fprintf(&b, "  %-30v %v\n", mc.Maintainer, mc.Changes)
+		}
+	}
+	if len(d.Violations) > 0 {
+		b.WriteString("Protocol violations\n")
+		for _, run := range d.Violations {
+			fmt.Fprintf(&b, "  %v version %v: %v\n", run.Started.Format(time.RFC3339), run.FromVersion, run.Failure)
+		}
+	}
+	return b.String()
+}
+
+func sourceDisplayName(source persist.NRTMSource) string {
+	if len(source.Label) > 0 {
+		return source.Source + " (" + source.Label + ")"
+	}
+	return source.Source
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+func TestDigestPeriodStart(t *testing.T) {
+	if _, err := DigestPeriodStart("monthly"); err != ErrInvalidDigestPeriod {
+		t.Error("Expected ErrInvalidDigestPeriod but was", err)
+	}
+	daily, err := DigestPeriodStart("Daily")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	weekly, err := DigestPeriodStart("weekly")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if d := daily.Sub(weekly).Round(time.Hour); d != 6*24*time.Hour {
+		t.Error("Expected six days between daily and weekly starts but was", d)
+	}
+}
+
+func TestDigestString(t *testing.T) {
+	digest := ChangeDigest{
+		Source: persist.NRTMSource{Source: "EXAMPLE", Label: "main"},
+		Period: "daily",
+		ChangeSummary: persist.ChangeSummary{
+			Added:          3,
+			TopMaintainers: []persist.MaintainerCount{{Maintainer: "EXAMPLE-MNT", Changes: 3}},
+		},
+	}
+	if digest.Subject() != "NRTMv4 daily digest for EXAMPLE (main)" {
+		t.Error("Unexpected subject", digest.Subject())
+	}
+	if !strings.Contains(digest.String(), "EXAMPLE-MNT") {
+		t.Error("Expected maintainer in digest", digest.String())
+	}
+}
+
+func TestSMTPNotifierFormat(t *testing.T) {
+	n := smtpNotifier{SMTPConfig{From: "nrtm@example.com", To: []string{"a@example.com", "b@example.com"}}}
+	mail := string(n.formatMail("Subject line", "line 1\nline 2"))
+	expected := "From: nrtm@example.com\r\nTo: a@example.com, b@example.com\r\nSubject: Subject line\r\n"
+	if !strings.HasPrefix(mail, expected) {
+		t.Error("Unexpected mail headers", mail)
+	}
+	if !strings.HasSuffix(mail, "line 1\r\nline 2") {
+		t.Error("Expected CRLF line endings in body", mail)
+	}
+}
+
+func TestNextDigest(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2026, 10, 14, 13, 30, 0, 0, time.UTC)
+	if next := nextDigest("daily", now); !next.Equal(time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Expected the daily digest at midnight but was", next)
+	}
+	if next := nextDigest("Weekly", now); !next.Equal(time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Expected the weekly digest at midnight on Monday but was", next)
+	}
+	if next := nextDigest("weekly", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)); !next.Equal(time.Date(2026, 10, 26, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Expected the next weekly digest a week later but was", next)
+	}
+}
+
+// digestRepo has two labels of one source, and an update which the server's protocol error
+// failed
+type digestRepo struct {
+	persist.Repository
+}
+
+func (r digestRepo) GetSources() ([]persist.NRTMSource, error) {
+	old := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []persist.NRTMSource{
+		{ID: 1, Source: "EXAMPLE", Label: "prod"},
+		{ID: 2, Source: "EXAMPLE", Label: "test"},
+		{ID: 3, Source: "EXAMPLE", Label: "prod 0a1b2c3d", Superseded: &old},
+		{ID: 4, Source: "OTHER"},
+	}, nil
+}
+
+func (r digestRepo) GetChangeSummary(source persist.NRTMSource, since time.Time) (persist.ChangeSummary, error) {
+	return persist.ChangeSummary{Added: 1}, nil
+}
+
+func (r digestRepo) GetSyncRuns(source persist.NRTMSource, from, to time.Time) ([]persist.SyncRun, error) {
+	return []persist.SyncRun{
+		{FromVersion: 4, ToVersion: 5, Success: true},
+		{FromVersion: 5, Failure: "network is unreachable"},
+		{FromVersion: 5, Failure: "hash mismatch: delta 6", Reason: "hash mismatch"},
+	}, nil
+}
+
+func TestDigestViolations(t *testing.T) {
+	p := NRTMProcessor{repo: digestRepo{}}
+	digest, err := p.Digest("EXAMPLE", "prod", "daily")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(digest.Violations) != 1 || digest.Violations[0].Reason != "hash mismatch" {
+		t.Error("Expected only the protocol error to be a violation but was", digest.Violations)
+	}
+	if !strings.Contains(digest.String(), "Protocol violations") || !strings.Contains(digest.String(), "hash mismatch: delta 6") {
+		t.Error("Expected the violation in the digest", digest.String())
+	}
+}
+
+func TestSendDigests(t *testing.T) {
+	alerts := &alertRecorder{}
+	p := NRTMProcessor{repo: digestRepo{}, notifier: alerts}
+	p.config.Sources = map[string]SourceConfig{"example": {Digest: &DigestConfig{Period: "weekly"}}}
+	p.sendDigests("daily")
+	if sent := alerts.take(); len(sent) != 0 {
+		t.Error("Expected no daily digests but was", sent)
+	}
+	p.sendDigests("weekly")
+	sent := alerts.take()
+	if len(sent) != 2 || sent[0] != "NRTMv4 weekly digest for EXAMPLE (prod)" || sent[1] != "NRTMv4 weekly digest for EXAMPLE (test)" {
+		t.Error("Expected a digest for each current label of the source but was", sent)
+	}
+	if err := (&DigestConfig{Period: "monthly"}).validate(); err != ErrInvalidDigestPeriod {
+		t.Error("Expected ErrInvalidDigestPeriod but was", err)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || freebsd)
+
+package service
+
+func freeSpace(path string) (uint64, error) {
+	return 0, errFreeSpaceNotSupported
+}
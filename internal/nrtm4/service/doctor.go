@@ -0,0 +1,308 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// minFreeSpace is the least free space on the file path before doctor warns. Snapshots of the
+// larger registries are a few hundred megabytes.
+const minFreeSpace = 2 << 30
+
+// proxyDialTimeout is how long doctor waits for a proxy to accept a connection
+var proxyDialTimeout = 5 * time.Second
+
+// errFreeSpaceNotSupported free space can't be checked on this platform
+var errFreeSpaceNotSupported = errors.New("free space can't be checked on this platform")
+
+// DoctorCheck is the result of one self-check, with a suggested fix when it fails
+type DoctorCheck struct {
+	ID          string        `json:"id"`
+	Description string        `json:"description"`
+	Status      CheckStatus   `json:"status"`
+	Severity    CheckSeverity `json:"severity"`
+	Message     string        `json:"message,omitempty"`
+	Fix         string        `json:"fix,omitempty"`
+}
+
+// DoctorReport lists the checks made on this installation. ExitCode uses the same values as the
+// validate command.
+type DoctorReport struct {
+	Checks   []DoctorCheck `json:"checks"`
+	ExitCode int           `json:"exit_code"`
+}
+
+func (r *DoctorReport) add(id string, severity CheckSeverity, description string, err error, fix string) bool {
+	check := DoctorCheck{
+		ID:          id,
+		Description: description,
+		Status:      CheckPassed,
+		Severity:    severity,
+	}
+	if err != nil {
+		check.Status = CheckFailed
+		check.Message = err.Error()
+		check.Fix = fix
+		if severity == SeverityError {
+			r.ExitCode = ExitErrors
+		} else if r.ExitCode == ExitConformant {
+			r.ExitCode = ExitWarnings
+		}
+	}
+	r.Checks = append(r.Checks, check)
+	return err == nil
+}
+
+func (r *DoctorReport) skip(id string, severity CheckSeverity, description, reason string) {
+	r.Checks = append(r.Checks, DoctorCheck{
+		ID:          id,
+		Description: description,
+		Status:      CheckSkipped,
+		Severity:    severity,
+		Message:     reason,
+	})
+}
+
+// Doctor checks the configuration, the database, the file path, that each source's server can
+// be reached, and the local clock. Each failed check comes with a suggested fix.
+func (p NRTMProcessor) Doctor() DoctorReport {
+	var report DoctorReport
+	p.checkFilePath(&report)
+	p.checkAuditConfig(&report)
+	dbOK := report.add("database.connection", SeverityError, "Database accepts connections",
+		p.repo.Ping(),
+		"Check PG_DATABASE_URL or the database settings in the config file, and that PostgreSQL is running and accepts connections from this host")
+	if !dbOK {
+		for _, id := range []string{"database.schema", "config.sources", "sources.proxy", "sources.reachable", "clock.skew"} {
+			report.skip(id, SeverityError, "Needs the database", "no database connection")
+		}
+		return report
+	}
+	p.checkSchema(&report)
+	sources, err := p.repo.GetSources()
+	if !report.add("database.sources", SeverityError, "Sources can be read", err,
+		"Check the database user has SELECT permission on the nrtm_ tables") {
+		return report
+	}
+	p.checkConfiguredSources(&report, sources)
+	p.checkSourcesReachable(&report, sources)
+	return report
+}
+
+func (p NRTMProcessor) checkFilePath(report *DoctorReport) {
+	fix := "Create the directory and give the user running nrtm4client write permission, or set NRTM4_FILE_PATH to another directory"
+	if !report.add("files.path", SeverityError, "NRTM4_FILE_PATH is a writable directory",
+		writableDir(p.config.NRTMFilePath), fix) {
+		return
+	}
+	if len(p.config.TempDir) > 0 {
+		report.add("files.temp_dir", SeverityError, "temp_dir is writable and on the same filesystem as NRTM4_FILE_PATH",
+			renameAcross(p.config.TempDir, p.config.NRTMFilePath),
+			"Set temp_dir to a directory on the same filesystem as NRTM4_FILE_PATH, or remove it from the config file")
+	}
+	free, err := freeSpace(p.config.NRTMFilePath)
+	if errors.Is(err, errFreeSpaceNotSupported) {
+		report.skip("files.space", SeverityWarning, "There's enough free space for snapshot files", err.Error())
+		return
+	}
+	if err == nil && free < minFreeSpace {
+		err = fmt.Errorf("%d MiB free, at least %d MiB is recommended", free>>20, minFreeSpace>>20)
+	}
+	report.add("files.space", SeverityWarning, "There's enough free space for snapshot files", err,
+		"Free some space, run verify-cache --delete to remove files which aren't needed, or set NRTM4_FILE_PATH to a bigger filesystem")
+}
+
+// writableDir creates dir if it doesn't exist, and checks a file can be written to it
+func writableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, ".nrtm4doctor")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// renameAcross checks that a file written to from can be renamed into to, which is how
+// downloads are moved into place
+func renameAcross(from, to string) error {
+	if err := writableDir(from); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(from, ".nrtm4doctor")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	target := filepath.Join(to, filepath.Base(file.Name()))
+	if err = os.Rename(file.Name(), target); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Remove(target)
+}
+
+func (p NRTMProcessor) checkAuditConfig(report *DoctorReport) {
+	audit := p.config.Audit
+	if len(audit.Path) == 0 {
+		return
+	}
+	report.add("config.audit.path", SeverityError, "Audit log directory is writable",
+		writableDir(filepath.Dir(audit.Path)),
+		"Create the directory of audit.path and give the user running nrtm4client write permission")
+	if len(audit.KeyFile) > 0 {
+		_, err := readAuditKey(audit.KeyFile)
+		report.add("config.audit.key_file", SeverityError, "Audit key file holds an ed25519 seed", err,
+			"Write a hex-encoded seed to audit.key_file, e.g. openssl rand -hex 32 > audit.key")
+	}
+}
+
+func (p NRTMProcessor) checkSchema(report *DoctorReport) {
+	version, err := p.repo.GetSchemaVersion()
+	if err == nil && version.Current != version.Supported {
+		err = fmt.Errorf("schema is version %d and nrtm4client %v needs version %d",
+			version.Current, util.ClientVersion, version.Supported)
+	}
+	fix := "Run the migrations in third_party/tern with tern migrate"
+	if version.Current > version.Supported {
+		fix = fmt.Sprintf("Upgrade to nrtm4client %v, which was last used with this database", version.LastClientVersion)
+	}
+	report.add("database.schema", SeverityError, "Database schema matches this client", err, fix)
+}
+
+// checkConfiguredSources finds config entries for sources which aren't connected, which are
+// usually typos, since the settings are silently ignored
+func (p NRTMProcessor) checkConfiguredSources(report *DoctorReport, sources []persist.NRTMSource) {
+	known := map[string]bool{}
+	for _, source := range sources {
+		known[strings.ToUpper(source.Source)] = true
+		known[strings.ToUpper(source.Source+"/"+source.Label)] = true
+	}
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(p.config.Sources)) {
+		sc := p.config.Sources[name]
+		if !known[strings.ToUpper(name)] {
+			errs = append(errs, fmt.Errorf("sources has settings for %v, which isn't connected", name))
+		}
+		if sc.Publish != nil && len(sc.Publish.URL) > 0 {
+			if err := checkPublishURL(sc.Publish.URL); err != nil {
+				errs = append(errs, fmt.Errorf("sources.%v.publish: %w", name, err))
+			}
+		}
+	}
+	for _, group := range slices.Sorted(maps.Keys(p.config.Groups)) {
+		for _, member := range p.config.Groups[group] {
+			if !known[strings.ToUpper(member)] {
+				errs = append(errs, fmt.Errorf("group %v has %v, which isn't connected", group, member))
+			}
+		}
+	}
+	report.add("config.sources", SeverityWarning, "Sources in the config file are connected", errors.Join(errs...),
+		"Check the source names with the list command, then correct the config file")
+}
+
+func checkPublishURL(publishURL string) error {
+	u, err := url.Parse(publishURL)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(u.Scheme, "nats") {
+		return fmt.Errorf("%w: %v", ErrPublisherSchemeNotSupported, u.Scheme)
+	}
+	return nil
+}
+
+// checkProxy reports which proxy, if any, is used for a source's notification file, and that the
+// proxy accepts connections. It returns the proxy, or nil for a direct connection.
+func (p NRTMProcessor) checkProxy(report *DoctorReport, source persist.NRTMSource, u *url.URL) *url.URL {
+	fix := "Set HTTPS_PROXY, NO_PROXY and network.proxy to the proxy your network requires, or to 'direct' in network.hosts for servers which aren't behind it"
+	proxy, err := p.config.Network.proxyFor(u)
+	if err != nil {
+		report.add("sources.proxy", SeverityError,
+			fmt.Sprintf("%v has a valid proxy setting for %v", sourceDisplayName(source), u.Host), err, fix)
+		return nil
+	}
+	if proxy == nil {
+		report.add("sources.proxy", SeverityWarning,
+			fmt.Sprintf("%v connects to %v directly, without a proxy", sourceDisplayName(source), u.Host), nil, "")
+		return nil
+	}
+	port := proxy.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[proxy.Scheme]
+		if port == "" {
+			port = "1080"
+		}
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(proxy.Hostname(), port), proxyDialTimeout)
+	if err == nil {
+		conn.Close()
+	}
+	report.add("sources.proxy", SeverityError,
+		fmt.Sprintf("%v connects to %v through the proxy %v", sourceDisplayName(source), u.Host, proxy.Redacted()), err, fix)
+	return proxy
+}
+
+// checkSourcesReachable fetches each source's notification file, and uses the server's Date
+// header to check the local clock
+func (p NRTMProcessor) checkSourcesReachable(report *DoctorReport, sources []persist.NRTMSource) {
+	allowedSkew := p.config.AllowedClockSkew
+	if allowedSkew <= 0 {
+		allowedSkew = defaultAllowedClockSkew
+	}
+	var worstSkew time.Duration
+	var skewHost string
+	compared := false
+	for _, source := range sources {
+		if source.Superseded != nil {
+			continue
+		}
+		host := source.NotificationURL
+		fix := "Check that outbound HTTPS to " + host + " is allowed, the HTTPS_PROXY environment variable if there's a proxy, and the network settings in the config file"
+		if u, err := url.Parse(source.NotificationURL); err == nil {
+			host = u.Host
+			if proxy := p.checkProxy(report, source, u); proxy != nil {
+				fix = "Check that the proxy " + proxy.Redacted() + " allows HTTPS to " + host + ", or add " + u.Hostname() + " to NO_PROXY if it should be reached directly"
+			} else {
+				fix = "Check that outbound HTTPS to " + host + " is allowed without a proxy, or set HTTPS_PROXY or network.proxy if there's a proxy"
+			}
+		}
+		_, header, err := p.client.getUpdateNotification(source.NotificationURL)
+		report.add("sources.reachable", SeverityError,
+			fmt.Sprintf("%v notification file can be fetched from %v", sourceDisplayName(source), host), err, fix)
+		if err != nil {
+			continue
+		}
+		if len(header.Get("Date")) == 0 {
+			continue
+		}
+		compared = true
+		if skew := estimateClockSkew(header, util.AppClock.Now()); skew.Abs() >= worstSkew.Abs() {
+			worstSkew = skew
+			skewHost = host
+		}
+	}
+	if !compared {
+		report.skip("clock.skew", SeverityWarning, "Local clock agrees with the servers", "no server Date headers to compare with")
+		return
+	}
+	var err error
+	if worstSkew.Abs() > allowedSkew {
+		err = fmt.Errorf("local clock is %v off from %v", worstSkew.Round(time.Second), skewHost)
+	}
+	report.add("clock.skew", SeverityWarning, "Local clock agrees with the servers", err,
+		"Synchronize the clock with NTP, e.g. enable systemd-timesyncd or chrony")
+}
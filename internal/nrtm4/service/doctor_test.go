@@ -0,0 +1,109 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+type doctorRepo struct {
+	persist.Repository
+	pingErr error
+	schema  persist.SchemaVersion
+}
+
+func (r doctorRepo) Ping() error {
+	return r.pingErr
+}
+
+func (r doctorRepo) GetSchemaVersion() (persist.SchemaVersion, error) {
+	return r.schema, nil
+}
+
+func (r doctorRepo) GetSources() ([]persist.NRTMSource, error) {
+	return []persist.NRTMSource{{ID: 1, Source: "TEST", NotificationURL: "https://nrtm.example.net/notification.json"}}, nil
+}
+
+type doctorClient struct {
+	Client
+	skew time.Duration
+}
+
+func (c doctorClient) getUpdateNotification(url string) (protocol.NotificationJSON, http.Header, error) {
+	header := http.Header{}
+	header.Set("Date", util.AppClock.Now().Add(-c.skew).Format(http.TimeFormat))
+	return protocol.NotificationJSON{}, header, nil
+}
+
+func findCheck(report DoctorReport, id string) DoctorCheck {
+	for _, check := range report.Checks {
+		if check.ID == id {
+			return check
+		}
+	}
+	return DoctorCheck{}
+}
+
+func TestDoctor(t *testing.T) {
+	config := AppConfig{
+		NRTMFilePath: t.TempDir(),
+		Sources:      map[string]SourceConfig{"TEST": {}, "TSET": {}},
+		Groups:       map[string][]string{"all": {"TEST"}},
+	}
+	repo := doctorRepo{schema: persist.SchemaVersion{Current: 10, Supported: 10}}
+	p := NRTMProcessor{config: config, repo: repo, client: doctorClient{}}
+	report := p.Doctor()
+	if report.ExitCode != ExitWarnings {
+		t.Error("Expected warnings but exit code was", report.ExitCode, report.Checks)
+	}
+	for _, id := range []string{"files.path", "database.connection", "database.schema", "sources.reachable", "clock.skew"} {
+		if check := findCheck(report, id); check.Status != CheckPassed {
+			t.Error("Expected", id, "to pass but was", check)
+		}
+	}
+	sources := findCheck(report, "config.sources")
+	if sources.Status != CheckFailed || !strings.Contains(sources.Message, "TSET") || len(sources.Fix) == 0 {
+		t.Error("Expected a typo in the config to be found", sources)
+	}
+
+	p.client = doctorClient{skew: time.Hour}
+	p.repo = doctorRepo{schema: persist.SchemaVersion{Current: 9, Supported: 10}}
+	report = p.Doctor()
+	if report.ExitCode != ExitErrors {
+		t.Error("Expected errors but exit code was", report.ExitCode)
+	}
+	if check := findCheck(report, "database.schema"); check.Status != CheckFailed || !strings.Contains(check.Fix, "tern") {
+		t.Error("Expected the schema check to fail with a fix", check)
+	}
+	if check := findCheck(report, "clock.skew"); check.Status != CheckFailed {
+		t.Error("Expected the clock check to fail", check)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Cannot listen", err)
+	}
+	p.config.Network = NetworkConfig{Proxy: ln.Addr().String()}
+	report = p.Doctor()
+	if check := findCheck(report, "sources.proxy"); check.Status != CheckPassed || !strings.Contains(check.Description, ln.Addr().String()) {
+		t.Error("Expected the proxy to be reported", check)
+	}
+	ln.Close()
+	report = p.Doctor()
+	if check := findCheck(report, "sources.proxy"); check.Status != CheckFailed || len(check.Fix) == 0 {
+		t.Error("Expected a proxy which refuses connections to fail", check)
+	}
+
+	p.repo = doctorRepo{pingErr: errors.New("connection refused")}
+	report = p.Doctor()
+	if check := findCheck(report, "sources.reachable"); check.Status != CheckSkipped {
+		t.Error("Expected source checks to be skipped without a database", check)
+	}
+}
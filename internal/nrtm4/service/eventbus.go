@@ -0,0 +1,271 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+var (
+	// ErrPublisherSchemeNotSupported the broker URL scheme is not one we can publish to
+	ErrPublisherSchemeNotSupported = errors.New("publish url scheme is not supported")
+	// ErrPublisherKafkaNotSupported kafka is recognized but there's no producer for it. Events
+	// can reach Kafka through a NATS to Kafka bridge.
+	ErrPublisherKafkaNotSupported = errors.New("publishing to kafka is not supported, publish to nats instead")
+
+	publisherDialTimeout = 10 * time.Second
+)
+
+// DeltaEvent is published for every change applied from a delta file. Object is the object after
+// the change and Previous before it, so each is left out when there's no such version.
+type DeltaEvent struct {
+	Source      string  `json:"source"`
+	Label       string  `json:"label"`
+	SessionID   string  `json:"session_id"`
+	Version     uint32  `json:"version"`
+	Action      string  `json:"action"`
+	ObjectClass string  `json:"object_class"`
+	PrimaryKey  string  `json:"primary_key"`
+	Object      *string `json:"object,omitempty"`
+	Previous    *string `json:"previous,omitempty"`
+}
+
+type eventPublisher interface {
+	publish(subject string, payload []byte) error
+	close() error
+}
+
+// validate rejects a broker URL which can't be published to, so it's found when the config is
+// loaded rather than at each sync
+func (c *PublishConfig) validate() error {
+	if c == nil || len(c.URL) == 0 {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "nats":
+		return nil
+	case "kafka":
+		return ErrPublisherKafkaNotSupported
+	}
+	return fmt.Errorf("%w: %v", ErrPublisherSchemeNotSupported, u.Scheme)
+}
+
+func newEventPublisher(cfg PublishConfig) (eventPublisher, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	return dialNATS(u.Host)
+}
+
+// deltaEventSink publishes events for one source, it's a no-op when publishing isn't configured.
+// Events are held until flush, so they're only published once their changes have been applied.
+type deltaEventSink struct {
+	publisher eventPublisher
+	subject   string
+	pending   *[]DeltaEvent
+}
+
+func newDeltaEventSink(config AppConfig, source persist.NRTMSource) deltaEventSink {
+	cfg := config.sourceConfig(source.Source).Publish
+	if cfg == nil || len(cfg.URL) == 0 {
+		return deltaEventSink{}
+	}
+	publisher, err := newEventPublisher(*cfg)
+	if err != nil {
+		logger.Warn("Cannot publish delta events", "source", source.Source, "url", cfg.URL, "error", err)
+		return deltaEventSink{}
+	}
+	subject := cfg.Subject
+	if len(subject) == 0 {
+		subject = "nrtm4." + source.Source
+	}
+	return deltaEventSink{publisher: publisher, subject: subject, pending: new([]DeltaEvent)}
+}
+
+// send holds an event until flush
+func (s deltaEventSink) send(event DeltaEvent) {
+	if s.publisher == nil {
+		return
+	}
+	*s.pending = append(*s.pending, event)
+}
+
+// flush publishes the events sent since the last flush, once their changes have been applied
+func (s deltaEventSink) flush() {
+	if s.publisher == nil {
+		return
+	}
+	events := *s.pending
+	*s.pending = nil
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			logger.Warn("Failed to marshal delta event", "error", err)
+			continue
+		}
+		if err = s.publisher.publish(s.subject, payload); err != nil {
+			logger.Warn("Failed to publish delta event", "subject", s.subject, "error", err)
+		}
+	}
+}
+
+// discard drops the events sent since the last flush, when their changes weren't applied
+func (s deltaEventSink) discard() {
+	if s.publisher == nil {
+		return
+	}
+	if n := len(*s.pending); n > 0 {
+		logger.Info("Dropped the events of changes which weren't applied", "subject", s.subject, "events", n)
+	}
+	*s.pending = nil
+}
+
+func (s deltaEventSink) close() {
+	if s.publisher == nil {
+		return
+	}
+	s.discard()
+	if err := s.publisher.close(); err != nil {
+		logger.Warn("Failed to close event publisher", "error", err)
+	}
+}
+
+// natsPublisher speaks just enough of the NATS text protocol to publish messages. The server
+// PINGs its clients and drops those which don't answer, so the PONGs are sent by a goroutine
+// which reads from the server while messages are published.
+type natsPublisher struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// mu serializes writes, so a PONG can't land in the middle of a message
+	mu    sync.Mutex
+	pongs chan struct{}
+	done  chan struct{}
+	// serverErr is the last -ERR from the server
+	serverErr error
+}
+
+func dialNATS(address string) (*natsPublisher, error) {
+	conn, err := net.DialTimeout("tcp", address, publisherDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	p := &natsPublisher{conn: conn, reader: bufio.NewReader(conn), pongs: make(chan struct{}, 1), done: make(chan struct{})}
+	conn.SetReadDeadline(time.Now().Add(publisherDialTimeout))
+	line, err := p.reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if line = strings.TrimSpace(line); !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting from nats server: %v", line)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if err = p.write([]byte("CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"nrtm4client\"}\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go p.serve()
+	return p, nil
+}
+
+// serve reads from the server until the connection is closed, answering its PINGs
+func (p *natsPublisher) serve() {
+	defer close(p.done)
+	for {
+		line, err := p.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PING":
+			if err = p.write([]byte("PONG\r\n")); err != nil {
+				return
+			}
+		case line == "PONG":
+			select {
+			case p.pongs <- struct{}{}:
+			default:
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			p.mu.Lock()
+			p.serverErr = errors.New("nats server error: " + line)
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *natsPublisher) write(b []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(publisherDialTimeout))
+	_, err := p.conn.Write(b)
+	return err
+}
+
+func (p *natsPublisher) publish(subject string, payload []byte) error {
+	msg := fmt.Appendf(nil, "PUB %v %d\r\n", subject, len(payload))
+	msg = append(append(msg, payload...), '\r', '\n')
+	return p.write(msg)
+}
+
+// close sends a PING and waits for the PONG, so we know the server has processed everything
+func (p *natsPublisher) close() error {
+	defer func() {
+		p.conn.Close()
+		<-p.done
+	}()
+	if err := p.write([]byte("PING\r\n")); err != nil {
+		return err
+	}
+	select {
+	case <-p.pongs:
+	case <-p.done:
+		return errors.New("nats server closed the connection")
+	case <-time.After(publisherDialTimeout):
+		return errors.New("no PONG from nats server")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.serverErr
+}
+
+func newDeltaEvent(source persist.NRTMSource, file protocol.NrtmFileJSON, action, objectClass, primaryKey string, object, previous *string) DeltaEvent {
+	return DeltaEvent{
+		Source:      source.Source,
+		Label:       source.Label,
+		SessionID:   file.SessionID,
+		Version:     file.Version,
+		Action:      action,
+		ObjectClass: objectClass,
+		PrimaryKey:  primaryKey,
+		Object:      object,
+		Previous:    previous,
+	}
+}
+
+// payloadOf is an object's text, or nil without an object
+func payloadOf(object *rpsl.Rpsl) *string {
+	if object == nil {
+		return nil
+	}
+	return &object.Payload
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNATSPublisher(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Cannot listen", err)
+	}
+	defer ln.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// The server PINGs straight away, and drops clients which don't answer
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\"}\r\nPING\r\n")
+		lines := []string{}
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimSpace(line)
+			if line == "PING" {
+				// The client closes the connection once it has the PONG
+				fmt.Fprint(conn, "PONG\r\n")
+				continue
+			}
+			lines = append(lines, line)
+		}
+		received <- lines
+	}()
+
+	cfg := PublishConfig{URL: "nats://" + ln.Addr().String()}
+	pub, err := newEventPublisher(cfg)
+	if err != nil {
+		t.Fatal("Failed to connect to test server", err)
+	}
+	if err = pub.publish("nrtm4.EXAMPLE", []byte(`{"action":"delete"}`)); err != nil {
+		t.Fatal("Failed to publish", err)
+	}
+	if err = pub.close(); err != nil {
+		t.Fatal("Failed to close", err)
+	}
+	lines := <-received
+	if i := slices.Index(lines, "PONG"); i < 0 {
+		t.Error("Expected the server's PING to be answered", lines)
+	} else {
+		lines = slices.Delete(lines, i, i+1)
+	}
+	expected := []string{"PUB nrtm4.EXAMPLE 19", `{"action":"delete"}`}
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "CONNECT ") {
+		t.Fatal("Unexpected protocol lines", lines)
+	}
+	for i, exp := range expected {
+		if lines[i+1] != exp {
+			t.Error("Expected", exp, "but was", lines[i+1])
+		}
+	}
+}
+
+func TestNewEventPublisherSchemes(t *testing.T) {
+	if _, err := newEventPublisher(PublishConfig{URL: "kafka://localhost:9092"}); !errors.Is(err, ErrPublisherKafkaNotSupported) {
+		t.Error("Expected ErrPublisherKafkaNotSupported but was", err)
+	}
+	if _, err := newEventPublisher(PublishConfig{URL: "amqp://localhost"}); !errors.Is(err, ErrPublisherSchemeNotSupported) {
+		t.Error("Expected ErrPublisherSchemeNotSupported but was", err)
+	}
+	if err := (&PublishConfig{URL: "kafka://localhost:9092"}).validate(); !errors.Is(err, ErrPublisherKafkaNotSupported) {
+		t.Error("Expected a kafka url to be rejected with the config but was", err)
+	}
+}
+
+func TestDeltaEventSinkDiscard(t *testing.T) {
+	published := []DeltaEvent{}
+	sink := deltaEventSink{publisher: recordingPublisher{&published}, subject: "nrtm4.EXAMPLE", pending: new([]DeltaEvent)}
+	sink.send(DeltaEvent{})
+	sink.discard()
+	sink.flush()
+	if len(published) != 0 || len(*sink.pending) != 0 {
+		t.Fatal("Expected the discarded event not to be published but was", published)
+	}
+	sink.send(DeltaEvent{})
+	sink.flush()
+	if len(published) != 1 {
+		t.Error("Expected the event sent after the discard to be published but was", published)
+	}
+}
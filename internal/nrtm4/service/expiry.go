@@ -0,0 +1,78 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+var (
+	// ErrNRTM4NotificationExpired the server said the notification file is no longer valid
+	ErrNRTM4NotificationExpired = errors.New("notification file has expired")
+	// ErrNRTM4FileExpired the server said a snapshot or delta file is no longer valid
+	ErrNRTM4FileExpired = errors.New("file has expired")
+)
+
+// checkNotificationExpiry stops a notification file being used after the time the server said it
+// expires, e.g. when a cache keeps serving it
+func (p NRTMProcessor) checkNotificationExpiry(notification protocol.NotificationJSON) error {
+	return p.checkExpiry(notification.Expires, notification.Version, ErrNRTM4NotificationExpired)
+}
+
+// checkFileExpiry stops a snapshot or delta being applied after the time the server said it
+// expires
+func (p NRTMProcessor) checkFileExpiry(ref protocol.FileRefJSON) error {
+	return p.checkExpiry(ref.Expires, ref.Version, ErrNRTM4FileExpired)
+}
+
+// checkExpiry returns errExpired if expires has passed, allowing for the clock skew in the
+// config. An expiry which isn't RFC3339 is ignored with a warning.
+func (p NRTMProcessor) checkExpiry(expires string, version uint32, errExpired error) error {
+	if len(expires) == 0 {
+		return nil
+	}
+	ts, err := time.Parse(time.RFC3339, expires)
+	if err != nil {
+		logger.Warn("Ignoring expiry which isn't a valid timestamp", "version", version, "expires", expires)
+		p.warnings.add(WarningServer, version, "expiry %v isn't a valid timestamp", expires)
+		return nil
+	}
+	allowedSkew := p.config.AllowedClockSkew
+	if allowedSkew <= 0 {
+		allowedSkew = defaultAllowedClockSkew
+	}
+	if util.AppClock.Now().Add(-allowedSkew).After(ts) {
+		return fmt.Errorf("%w: version %d expired at %v", errExpired, version, expires)
+	}
+	return nil
+}
+
+// upcomingExpiries lists the expiry times in a notification which are after now, soonest first.
+// Ones which can't be parsed are left out.
+func upcomingExpiries(notification protocol.NotificationJSON, now time.Time) []persist.Expiry {
+	expiries := []persist.Expiry{}
+	add := func(fileType persist.NTRMFileType, version uint32, expires string) {
+		if len(expires) == 0 {
+			return
+		}
+		ts, err := time.Parse(time.RFC3339, expires)
+		if err != nil || !ts.After(now) {
+			return
+		}
+		expiries = append(expiries, persist.Expiry{File: fileType.String(), Version: version, Expires: ts})
+	}
+	add(persist.NotificationFile, notification.Version, notification.Expires)
+	add(persist.SnapshotFile, notification.SnapshotRef.Version, notification.SnapshotRef.Expires)
+	for _, ref := range notification.DeltaRefs {
+		add(persist.DeltaFile, ref.Version, ref.Expires)
+	}
+	sort.SliceStable(expiries, func(i, j int) bool {
+		return expiries[i].Expires.Before(expiries[j].Expires)
+	})
+	return expiries
+}
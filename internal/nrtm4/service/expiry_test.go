@@ -0,0 +1,69 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+func TestCheckFileExpiry(t *testing.T) {
+	now := util.AppClock.Now()
+	p := NRTMProcessor{warnings: &syncWarnings{}}
+	type expectation struct {
+		expires  string
+		expected error
+	}
+	expectations := []expectation{
+		{"", nil},
+		{now.Add(time.Hour).Format(time.RFC3339), nil},
+		// Within the default allowed clock skew
+		{now.Add(-time.Minute).Format(time.RFC3339), nil},
+		{now.Add(-time.Hour).Format(time.RFC3339), ErrNRTM4FileExpired},
+		{"next tuesday", nil},
+	}
+	for _, exp := range expectations {
+		err := p.checkFileExpiry(protocol.FileRefJSON{Version: 4, Expires: exp.expires})
+		if !errors.Is(err, exp.expected) || (exp.expected == nil && err != nil) {
+			t.Error("Expected", exp.expected, "for", exp.expires, "but was", err)
+		}
+	}
+	if warnings := p.warnings.all(); len(warnings) != 1 || warnings[0].Kind != WarningServer {
+		t.Error("Expected a warning about the invalid expiry but was", warnings)
+	}
+	notification := protocol.NotificationJSON{Expires: now.Add(-time.Hour).Format(time.RFC3339)}
+	if err := p.checkNotificationExpiry(notification); !errors.Is(err, ErrNRTM4NotificationExpired) {
+		t.Error("Expected ErrNRTM4NotificationExpired but was", err)
+	}
+}
+
+func TestUpcomingExpiries(t *testing.T) {
+	now := time.Date(2025, 1, 20, 12, 0, 0, 0, time.UTC)
+	notification := protocol.NotificationJSON{
+		NrtmFileJSON: protocol.NrtmFileJSON{Version: 12},
+		Expires:      "2025-01-20T12:05:00Z",
+		SnapshotRef:  protocol.FileRefJSON{Version: 10, Expires: "2025-01-27T00:00:00Z"},
+		DeltaRefs: []protocol.FileRefJSON{
+			{Version: 10, Expires: "2025-01-20T11:00:00Z"},
+			{Version: 11, Expires: "2025-01-21T11:00:00Z"},
+			{Version: 12},
+		},
+	}
+	expiries := upcomingExpiries(notification, now)
+	expected := []persist.Expiry{
+		{File: "notification", Version: 12, Expires: time.Date(2025, 1, 20, 12, 5, 0, 0, time.UTC)},
+		{File: "delta", Version: 11, Expires: time.Date(2025, 1, 21, 11, 0, 0, 0, time.UTC)},
+		{File: "snapshot", Version: 10, Expires: time.Date(2025, 1, 27, 0, 0, 0, 0, time.UTC)},
+	}
+	if len(expiries) != len(expected) {
+		t.Fatal("Expected", expected, "but was", expiries)
+	}
+	for i, exp := range expected {
+		if expiries[i].File != exp.File || expiries[i].Version != exp.Version || !expiries[i].Expires.Equal(exp.Expires) {
+			t.Error("Expected", exp, "but was", expiries[i])
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+// RPSLDiffFormat writes each version as NRTMv3-style ADD and DEL sections
+const RPSLDiffFormat = "rpsl-diff"
+
+var (
+	// ErrExportFormatNotSupported the export format is not one we can write
+	ErrExportFormatNotSupported = errors.New("export format is not supported")
+	// ErrInvalidVersionRange the versions to export are out of order, or the source doesn't have them
+	ErrInvalidVersionRange = errors.New("invalid version range")
+)
+
+// ExportDeltas writes a file for each version of a source from fromVersion to toVersion, and
+// returns their paths. toVersion defaults to the source's current version when it's zero.
+func (p NRTMProcessor) ExportDeltas(sourceName, label string, fromVersion, toVersion uint32, format string, encoding OutputEncoding, dir string) ([]string, error) {
+	if format != RPSLDiffFormat {
+		return nil, ErrExportFormatNotSupported
+	}
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return nil, ErrSourceNotFound
+	}
+	if toVersion == 0 {
+		toVersion = source.Version
+	}
+	if fromVersion == 0 || fromVersion > toVersion || toVersion > source.Version {
+		return nil, fmt.Errorf("%w: %d to %d, source is at version %d", ErrInvalidVersionRange, fromVersion, toVersion, source.Version)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	versions := make([]uint32, 0, toVersion-fromVersion+1)
+	for version := fromVersion; version <= toVersion; version++ {
+		versions = append(versions, version)
+	}
+	provenance, err := p.repo.GetProvenance(*source, versions)
+	if err != nil {
+		return paths, err
+	}
+	for i, version := range versions {
+		path := filepath.Join(dir, fmt.Sprintf("%v.%d.%v", source.Source, version, RPSLDiffFormat))
+		changes := func(fn func(persist.ObjectChange) error) error {
+			return p.repo.GetObjectChanges(*source, version, version, fn)
+		}
+		if err = writeFileAtomically(path, func(w io.Writer) error {
+			ew := NewEncodingWriter(w, encoding)
+			if err := writeRPSLDiff(ew, *source, version, changes, provenance[i]); err != nil {
+				return err
+			}
+			return ew.Close()
+		}); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// writeFileAtomically streams what write writes to a temporary file, which is renamed to path
+// when it's complete, so a failed export doesn't leave a partial file behind
+func writeFileAtomically(path string, write func(io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	bw := bufio.NewWriter(f)
+	if err = write(bw); err == nil {
+		err = bw.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// writeRPSLDiff writes changes in the format of an NRTMv3 version 1 response, so tools which
+// read NRTMv3 streams can read it. The source's terms and the version's provenance go in
+// comments at the top. The changes are written as changes calls back with them.
+func writeRPSLDiff(w io.Writer, source persist.NRTMSource, version uint32, changes func(func(persist.ObjectChange) error) error, prov persist.Provenance) error {
+	if len(source.TermsURL) > 0 {
+		fmt.Fprintf(w, "%% Terms and conditions: %v\n", source.TermsURL)
+	}
+	writeProvenanceComment(w, prov)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%%START Version: 1 %v %d-%d\n\n", source.Source, version, version)
+	err := changes(func(change persist.ObjectChange) error {
+		action := "ADD"
+		if change.Deleted {
+			action = "DEL"
+		}
+		_, err := fmt.Fprintf(w, "%v\n\n%v\n\n", action, strings.TrimRight(change.RPSL, "\n"))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%%END %v\n", source.Source)
+	return err
+}
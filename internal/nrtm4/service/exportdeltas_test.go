@@ -0,0 +1,149 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+type objectChangesRepo struct {
+	persist.Repository
+	sources []persist.NRTMSource
+	changes []persist.ObjectChange
+}
+
+func (r objectChangesRepo) GetSources() ([]persist.NRTMSource, error) {
+	return r.sources, nil
+}
+
+func (r objectChangesRepo) GetObjectChanges(source persist.NRTMSource, fromVersion, toVersion uint32, fn func(persist.ObjectChange) error) error {
+	for _, c := range r.changes {
+		if c.Version >= fromVersion && c.Version <= toVersion {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func changeList(changes ...persist.ObjectChange) func(func(persist.ObjectChange) error) error {
+	return func(fn func(persist.ObjectChange) error) error {
+		for _, c := range changes {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (r objectChangesRepo) GetProvenance(source persist.NRTMSource, versions []uint32) ([]persist.Provenance, error) {
+	provenance := []persist.Provenance{}
+	for _, v := range versions {
+		provenance = append(provenance, persist.Provenance{Source: source.Source, SessionID: source.SessionID, Version: v})
+	}
+	return provenance, nil
+}
+
+func TestWriteRPSLDiff(t *testing.T) {
+	var b strings.Builder
+	prov := persist.Provenance{
+		Source:    "EXAMPLE",
+		SessionID: "ca128382-78d9-41d1-8927-1ecef15275be",
+		Version:   7,
+		FileType:  "delta",
+		URL:       "https://example.com/nrtm/delta.7.json",
+		Hash:      "abc",
+		RunID:     42,
+	}
+	err := writeRPSLDiff(&b, persist.NRTMSource{Source: "EXAMPLE", TermsURL: "https://example.com/terms"}, 7, changeList(
+		persist.ObjectChange{Version: 7, RPSL: "mntner: NEW-MNT\nsource: EXAMPLE\n"},
+		persist.ObjectChange{Version: 7, Deleted: true, RPSL: "mntner: OLD-MNT\nsource: EXAMPLE\n"},
+	), prov)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	expected := `% Terms and conditions: https://example.com/terms
+% Provenance: source EXAMPLE session ca128382-78d9-41d1-8927-1ecef15275be version 7 delta https://example.com/nrtm/delta.7.json sha256 abc run 42
+
+%START Version: 1 EXAMPLE 7-7
+
+ADD
+
+mntner: NEW-MNT
+source: EXAMPLE
+
+DEL
+
+mntner: OLD-MNT
+source: EXAMPLE
+
+%END EXAMPLE
+`
+	if b.String() != expected {
+		t.Errorf("Unexpected diff\n%v", b.String())
+	}
+}
+
+func TestExportDeltas(t *testing.T) {
+	repo := objectChangesRepo{
+		sources: []persist.NRTMSource{{Source: "EXAMPLE", Version: 9}},
+		changes: []persist.ObjectChange{
+			{Version: 8, RPSL: "mntner: A-MNT\n"},
+			{Version: 9, Deleted: true, RPSL: "mntner: B-MNT\n"},
+		},
+	}
+	p := NRTMProcessor{repo: repo}
+	dir := t.TempDir()
+	paths, err := p.ExportDeltas("EXAMPLE", "", 8, 0, RPSLDiffFormat, EncodingUTF8, dir)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(paths) != 2 || paths[1] != filepath.Join(dir, "EXAMPLE.9.rpsl-diff") {
+		t.Fatal("Unexpected paths", paths)
+	}
+	bytes, err := os.ReadFile(paths[1])
+	if err != nil || !strings.Contains(string(bytes), "DEL\n\nmntner: B-MNT\n") {
+		t.Error("Expected deletion in file", string(bytes), err)
+	}
+	if !strings.Contains(string(bytes), "% Provenance: source EXAMPLE session  version 9\n") {
+		t.Error("Expected provenance in file", string(bytes))
+	}
+	if _, err = p.ExportDeltas("EXAMPLE", "", 8, 10, RPSLDiffFormat, EncodingUTF8, dir); !errors.Is(err, ErrInvalidVersionRange) {
+		t.Error("Expected ErrInvalidVersionRange but was", err)
+	}
+	if _, err = p.ExportDeltas("EXAMPLE", "", 8, 9, "json", EncodingUTF8, dir); err != ErrExportFormatNotSupported {
+		t.Error("Expected ErrExportFormatNotSupported but was", err)
+	}
+}
+
+type failingChangesRepo struct {
+	objectChangesRepo
+}
+
+func (r failingChangesRepo) GetObjectChanges(source persist.NRTMSource, fromVersion, toVersion uint32, fn func(persist.ObjectChange) error) error {
+	if err := r.objectChangesRepo.GetObjectChanges(source, fromVersion, toVersion, fn); err != nil {
+		return err
+	}
+	return errors.New("connection lost")
+}
+
+func TestExportDeltasLeavesNoPartialFile(t *testing.T) {
+	repo := failingChangesRepo{objectChangesRepo{
+		sources: []persist.NRTMSource{{Source: "EXAMPLE", Version: 9}},
+		changes: []persist.ObjectChange{{Version: 9, RPSL: "mntner: A-MNT\n"}},
+	}}
+	p := NRTMProcessor{repo: repo}
+	dir := t.TempDir()
+	if _, err := p.ExportDeltas("EXAMPLE", "", 9, 9, RPSLDiffFormat, EncodingUTF8, dir); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Error("Expected no files but found", entries)
+	}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"io"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+// ExportObjects writes a source's objects of the given types, or all of them when there are no
+// types, to w as RPSL separated by blank lines. They're exported as they were at version, or at
+// the latest applied version when it's 0. Objects are written as they're read from the database,
+// so exporting a big source doesn't need much memory. It returns how many objects were written.
+func (p NRTMProcessor) ExportObjects(w io.Writer, sourceName, label string, version uint32, objectTypes []string) (int, error) {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return 0, ErrSourceNotFound
+	}
+	return p.exportObjects(w, *source, version, objectTypes)
+}
+
+// ObjectsReader returns a reader of the objects ExportObjects would write. They're read from the
+// database as the reader is read, and closing it before the end stops the export.
+func (p NRTMProcessor) ObjectsReader(sourceName, label string, version uint32, objectTypes []string) (io.ReadCloser, error) {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return nil, ErrSourceNotFound
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := p.exportObjects(pw, *source, version, objectTypes)
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+func (p NRTMProcessor) exportObjects(w io.Writer, source persist.NRTMSource, version uint32, objectTypes []string) (int, error) {
+	types := make([]string, 0, len(objectTypes))
+	for _, t := range objectTypes {
+		if t = strings.ToUpper(strings.TrimSpace(t)); len(t) > 0 {
+			types = append(types, t)
+		}
+	}
+	count := 0
+	err := p.repo.GetCurrentObjects(source, version, types, func(obj rpsl.Rpsl) error {
+		if err := p.query.read(1); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, strings.TrimRight(obj.Payload, "\n")+"\n\n"); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+type currentObjectsRepo struct {
+	persist.Repository
+	sources []persist.NRTMSource
+	objects []rpsl.Rpsl
+	types   *[]string
+	version *uint32
+}
+
+func (r currentObjectsRepo) GetSources() ([]persist.NRTMSource, error) {
+	return r.sources, nil
+}
+
+func (r currentObjectsRepo) GetCurrentObjects(source persist.NRTMSource, version uint32, objectTypes []string, fn func(rpsl.Rpsl) error) error {
+	*r.types = objectTypes
+	*r.version = version
+	for _, obj := range r.objects {
+		if len(objectTypes) > 0 && !slices.Contains(objectTypes, obj.ObjectType) {
+			continue
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newCurrentObjectsRepo() currentObjectsRepo {
+	return currentObjectsRepo{
+		sources: []persist.NRTMSource{{Source: "EXAMPLE"}},
+		objects: []rpsl.Rpsl{
+			{ObjectType: "MNTNER", PrimaryKey: "A-MNT", Payload: "mntner: A-MNT\nsource: EXAMPLE\n"},
+			{ObjectType: "ROUTE", PrimaryKey: "192.0.2.0/24AS64496", Payload: "route: 192.0.2.0/24\norigin: AS64496\n"},
+		},
+		types:   &[]string{},
+		version: new(uint32),
+	}
+}
+
+func TestExportObjects(t *testing.T) {
+	repo := newCurrentObjectsRepo()
+	p := NRTMProcessor{repo: repo}
+	var b strings.Builder
+	n, err := p.ExportObjects(&b, "example", "", 7, []string{" route", ""})
+	if err != nil || n != 1 {
+		t.Fatal("Expected one object", n, err)
+	}
+	if *repo.version != 7 {
+		t.Error("Expected objects at version 7 but was", *repo.version)
+	}
+	if !slices.Equal(*repo.types, []string{"ROUTE"}) {
+		t.Error("Expected the types to be normalized but was", *repo.types)
+	}
+	if b.String() != "route: 192.0.2.0/24\norigin: AS64496\n\n" {
+		t.Errorf("Unexpected export\n%v", b.String())
+	}
+	if _, err = p.ExportObjects(&b, "OTHER", "", 0, nil); err != ErrSourceNotFound {
+		t.Error("Expected ErrSourceNotFound but was", err)
+	}
+}
+
+func TestObjectsReader(t *testing.T) {
+	p := NRTMProcessor{repo: newCurrentObjectsRepo()}
+	r, err := p.ObjectsReader("EXAMPLE", "", 0, nil)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	bytes, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if strings.Count(string(bytes), "\n\n") != 2 || !strings.HasPrefix(string(bytes), "mntner: A-MNT\n") {
+		t.Errorf("Unexpected export\n%v", string(bytes))
+	}
+
+	// Closing early stops the export instead of blocking it
+	r, _ = p.ObjectsReader("EXAMPLE", "", 0, nil)
+	if err = r.Close(); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	if _, err = r.Read(make([]byte, 10)); !errors.Is(err, io.ErrClosedPipe) {
+		t.Error("Expected io.ErrClosedPipe but was", err)
+	}
+	if _, err = p.ObjectsReader("OTHER", "", 0, nil); err != ErrSourceNotFound {
+		t.Error("Expected ErrSourceNotFound but was", err)
+	}
+}
@@ -0,0 +1,361 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/retry"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// defaultFederationTimeout is how long the federation status waits for each peer
+const defaultFederationTimeout = 10 * time.Second
+
+// ErrInvalidFederation a federation peer has no name, a duplicate name or a URL which isn't http(s)
+var ErrInvalidFederation = errors.New("federation peers need a unique name and an http or https url")
+
+// FederationConfig lists the other nrtm4serve instances, e.g. regional mirrors, whose status is
+// combined with this one's at /admin/federation
+type FederationConfig struct {
+	// Name is this instance's name in the combined status. Default is the host name.
+	Name  string           `json:"name"`
+	Peers []FederationPeer `json:"peers"`
+	// Timeout is how long to wait for each peer, e.g. "5s"
+	Timeout string `json:"timeout"`
+}
+
+// FederationPeer is another instance. URL is where its nrtm4serve listens, e.g.
+// http://mirror-eu:8080, and its status is read from /admin/status under it.
+type FederationPeer struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// TokenFile holds the peer's admin API token. Default is this instance's admin_api token.
+	TokenFile string `json:"token_file"`
+}
+
+// token is the bearer token /admin/status needs on the peer
+func (peer FederationPeer) token(admin AdminAPIConfig) (string, error) {
+	if len(peer.TokenFile) > 0 {
+		return AdminAPIConfig{TokenFile: peer.TokenFile}.Token()
+	}
+	return admin.Token()
+}
+
+func (c FederationConfig) validate() error {
+	names := map[string]bool{}
+	for _, peer := range c.Peers {
+		name := strings.TrimSpace(peer.Name)
+		if len(name) == 0 || names[name] {
+			return fmt.Errorf("%w: %q", ErrInvalidFederation, peer.Name)
+		}
+		names[name] = true
+		u, err := url.Parse(peer.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("%w: %v", ErrInvalidFederation, peer.URL)
+		}
+	}
+	if len(c.Timeout) > 0 {
+		if _, err := time.ParseDuration(c.Timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c FederationConfig) timeout() time.Duration {
+	if d, err := time.ParseDuration(c.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultFederationTimeout
+}
+
+func (c FederationConfig) name() string {
+	if len(c.Name) > 0 {
+		return c.Name
+	}
+	if host, err := os.Hostname(); err == nil {
+		return host
+	}
+	return "local"
+}
+
+// InstanceStatus is the state of one instance's sources, served at /admin/status for other
+// instances to combine
+type InstanceStatus struct {
+	Name          string         `json:"name"`
+	ClientVersion string         `json:"client_version"`
+	Time          time.Time      `json:"time"`
+	Sources       []SourceStatus `json:"sources"`
+	// Syncs are the latest connect or update of each source started by the server
+	Syncs []Progress `json:"syncs"`
+	// Retries count the retries and breaker opens of the HTTP client, repository and scheduler
+	Retries []retry.Stats `json:"retries"`
+}
+
+// SourceStatus is where a source has got to. Updated is when its version was reached.
+type SourceStatus struct {
+	Source      string     `json:"source"`
+	Label       string     `json:"label"`
+	SessionID   string     `json:"session_id"`
+	Version     uint32     `json:"version"`
+	Paused      bool       `json:"paused"`
+	Quarantined bool       `json:"quarantined"`
+	Updated     *time.Time `json:"updated"`
+}
+
+// FederationStatus combines the status of this instance and its peers. Up and Down count the
+// instances whose status could and couldn't be read.
+type FederationStatus struct {
+	Time      time.Time           `json:"time"`
+	Up        int                 `json:"up"`
+	Down      int                 `json:"down"`
+	Instances []FederatedInstance `json:"instances"`
+	Sources   []FederatedSource   `json:"sources"`
+}
+
+// FederatedInstance is one instance's status, or the error reading it
+type FederatedInstance struct {
+	Name   string          `json:"name"`
+	URL    string          `json:"url,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Status *InstanceStatus `json:"status,omitempty"`
+}
+
+// FederatedSource compares the instances which mirror a source and label. The latest session is
+// the one of the instance with the highest version, and Behind is how many versions each
+// instance in it is behind that one.
+type FederatedSource struct {
+	Source        string         `json:"source"`
+	Label         string         `json:"label"`
+	LatestSession string         `json:"latest_session"`
+	LatestVersion uint32         `json:"latest_version"`
+	Mirrors       []SourceMirror `json:"mirrors"`
+}
+
+// SourceMirror is a source on one instance. OtherSession is set when it isn't on the latest
+// session, so its versions can't be compared.
+type SourceMirror struct {
+	Instance     string `json:"instance"`
+	SessionID    string `json:"session_id"`
+	Version      uint32 `json:"version"`
+	Behind       uint32 `json:"behind"`
+	OtherSession bool   `json:"other_session,omitempty"`
+	Paused       bool   `json:"paused,omitempty"`
+	Quarantined  bool   `json:"quarantined,omitempty"`
+}
+
+// Status returns the state of this instance's live sources
+func (p NRTMProcessor) Status() (InstanceStatus, error) {
+	status := InstanceStatus{
+		Name:          p.config.Federation.name(),
+		ClientVersion: util.GetBuildInfo().Version,
+		Time:          util.AppClock.Now(),
+		Sources:       []SourceStatus{},
+		Syncs:         p.Progress("", ""),
+		Retries:       p.RetryStats(),
+	}
+	ds := NrtmDataService{Repository: p.repo}
+	sources, err := ds.getSources()
+	if err != nil {
+		return status, err
+	}
+	for _, src := range sources {
+		if src.Superseded != nil {
+			continue
+		}
+		ss := SourceStatus{
+			Source:      src.Source,
+			Label:       src.Label,
+			SessionID:   src.SessionID,
+			Version:     src.Version,
+			Paused:      src.Paused,
+			Quarantined: src.Quarantine != nil,
+		}
+		notifs, err := p.repo.GetNotificationHistory(src, src.Version, src.Version)
+		if err != nil {
+			return status, err
+		}
+		if len(notifs) > 0 {
+			ss.Updated = &notifs[0].Created
+		}
+		status.Sources = append(status.Sources, ss)
+	}
+	return status, nil
+}
+
+// FederationStatus reads the status of each configured peer, at the same time, and combines it
+// with this instance's. A peer which can't be read is counted as down and doesn't stop the rest.
+func (p NRTMProcessor) FederationStatus() FederationStatus {
+	cfg := p.config.Federation
+	instances := make([]FederatedInstance, len(cfg.Peers)+1)
+	instances[0] = FederatedInstance{Name: cfg.name()}
+	if status, err := p.Status(); err != nil {
+		instances[0].Error = err.Error()
+	} else {
+		instances[0].Status = &status
+	}
+	client := cfg.httpClient(p.config.Network)
+	var wg sync.WaitGroup
+	for i, peer := range cfg.Peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instance := FederatedInstance{Name: peer.Name, URL: peer.URL}
+			if status, err := fetchInstanceStatus(client, peer, p.config.AdminAPI); err != nil {
+				logger.Warn("Failed to read peer status", "peer", peer.Name, "url", peer.URL, "error", err)
+				instance.Error = err.Error()
+			} else {
+				instance.Status = &status
+			}
+			instances[i+1] = instance
+		}()
+	}
+	wg.Wait()
+	return combineInstances(util.AppClock.Now(), instances)
+}
+
+func (c FederationConfig) httpClient(network NetworkConfig) *http.Client {
+	client := *network.httpClient()
+	client.Timeout = c.timeout()
+	return &client
+}
+
+func fetchInstanceStatus(client *http.Client, peer FederationPeer, admin AdminAPIConfig) (InstanceStatus, error) {
+	var status InstanceStatus
+	token, err := peer.token(admin)
+	if err != nil {
+		return status, err
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(peer.URL, "/")+"/admin/status", nil)
+	if err != nil {
+		return status, err
+	}
+	req.Header.Set("User-Agent", util.UserAgent())
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return status, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("status endpoint returned %v", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	return status, err
+}
+
+// combineInstances groups the instances' sources by source and label, in name order
+func combineInstances(now time.Time, instances []FederatedInstance) FederationStatus {
+	fs := FederationStatus{Time: now, Instances: instances, Sources: []FederatedSource{}}
+	bySource := map[string]*FederatedSource{}
+	for _, instance := range instances {
+		if instance.Status == nil {
+			fs.Down++
+			continue
+		}
+		fs.Up++
+		for _, ss := range instance.Status.Sources {
+			key := strings.ToUpper(ss.Source) + "\x00" + ss.Label
+			fsrc := bySource[key]
+			if fsrc == nil {
+				fsrc = &FederatedSource{Source: ss.Source, Label: ss.Label}
+				bySource[key] = fsrc
+			}
+			if ss.Version > fsrc.LatestVersion || len(fsrc.LatestSession) == 0 {
+				fsrc.LatestSession, fsrc.LatestVersion = ss.SessionID, ss.Version
+			}
+			fsrc.Mirrors = append(fsrc.Mirrors, SourceMirror{
+				Instance:    instance.Name,
+				SessionID:   ss.SessionID,
+				Version:     ss.Version,
+				Paused:      ss.Paused,
+				Quarantined: ss.Quarantined,
+			})
+		}
+	}
+	for _, fsrc := range bySource {
+		for i, m := range fsrc.Mirrors {
+			if m.SessionID != fsrc.LatestSession {
+				fsrc.Mirrors[i].OtherSession = true
+			} else {
+				fsrc.Mirrors[i].Behind = fsrc.LatestVersion - m.Version
+			}
+		}
+		fs.Sources = append(fs.Sources, *fsrc)
+	}
+	slices.SortFunc(fs.Sources, func(a, b FederatedSource) int {
+		if c := strings.Compare(a.Source, b.Source); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Label, b.Label)
+	})
+	return fs
+}
+
+// metricLabels escapes label values in the Prometheus text format
+var metricLabels = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteMetrics writes the combined status in the Prometheus text format, so mirrors which fall
+// behind can be alerted on. Each instance is up or down, and each source on each instance has
+// its version and how many versions it's behind, which is left out when it's on another
+// session.
+func (fs FederationStatus) WriteMetrics(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# HELP nrtm4_federation_instances Instances whose status could and couldn't be read.\n")
+	b.WriteString("# TYPE nrtm4_federation_instances gauge\n")
+	fmt.Fprintf(&b, "nrtm4_federation_instances{state=\"up\"} %d\n", fs.Up)
+	fmt.Fprintf(&b, "nrtm4_federation_instances{state=\"down\"} %d\n", fs.Down)
+	b.WriteString("# HELP nrtm4_federation_instance_up Whether the instance's status could be read.\n")
+	b.WriteString("# TYPE nrtm4_federation_instance_up gauge\n")
+	for _, instance := range fs.Instances {
+		up := 0
+		if instance.Status != nil {
+			up = 1
+		}
+		fmt.Fprintf(&b, "nrtm4_federation_instance_up{instance=\"%s\"} %d\n", metricLabels.Replace(instance.Name), up)
+	}
+	gauges := []struct {
+		name, help string
+		value      func(SourceMirror) (uint32, bool)
+	}{
+		{"nrtm4_federation_source_version", "The source's version on the instance.",
+			func(m SourceMirror) (uint32, bool) { return m.Version, true }},
+		{"nrtm4_federation_source_behind", "How many versions the source is behind the most up to date instance.",
+			func(m SourceMirror) (uint32, bool) { return m.Behind, !m.OtherSession }},
+		{"nrtm4_federation_source_other_session", "Whether the source is on another session than the most up to date instance.",
+			func(m SourceMirror) (uint32, bool) { return boolMetric(m.OtherSession), true }},
+		{"nrtm4_federation_source_paused", "Whether the source is paused on the instance.",
+			func(m SourceMirror) (uint32, bool) { return boolMetric(m.Paused), true }},
+		{"nrtm4_federation_source_quarantined", "Whether the source is quarantined on the instance.",
+			func(m SourceMirror) (uint32, bool) { return boolMetric(m.Quarantined), true }},
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+		for _, src := range fs.Sources {
+			for _, m := range src.Mirrors {
+				if v, ok := g.value(m); ok {
+					fmt.Fprintf(&b, "%s{source=\"%s\",label=\"%s\",instance=\"%s\"} %d\n", g.name,
+						metricLabels.Replace(src.Source), metricLabels.Replace(src.Label), metricLabels.Replace(m.Instance), v)
+				}
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func boolMetric(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
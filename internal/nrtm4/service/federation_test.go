@@ -0,0 +1,140 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+type federationRepo struct {
+	persist.Repository
+}
+
+func (r federationRepo) GetSources() ([]persist.NRTMSource, error) {
+	superseded := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	return []persist.NRTMSource{
+		{ID: 1, Source: "RIPE", Label: "prod", SessionID: "s1", Version: 90},
+		{ID: 2, Source: "RIPE", Label: "prod", SessionID: "s0", Version: 400, Superseded: &superseded},
+		{ID: 3, Source: "APNIC", SessionID: "a1", Version: 7, Paused: true},
+	}, nil
+}
+
+func (r federationRepo) GetNotificationHistory(source persist.NRTMSource, from, to uint32) ([]persist.Notification, error) {
+	return []persist.Notification{{Version: to, Created: time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)}}, nil
+}
+
+func TestFederationStatus(t *testing.T) {
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/status" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(InstanceStatus{
+			Name: "eu",
+			Sources: []SourceStatus{
+				{Source: "RIPE", Label: "prod", SessionID: "s1", Version: 100},
+				{Source: "APNIC", SessionID: "a2", Version: 3},
+			},
+		})
+	}))
+	defer peer.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	defer down.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal("Failed to write token file", err)
+	}
+	p := NRTMProcessor{repo: federationRepo{}, board: newProgressBoard()}
+	p.config.AdminAPI = AdminAPIConfig{TokenFile: tokenFile}
+	p.config.Federation = FederationConfig{
+		Name:  "local",
+		Peers: []FederationPeer{{Name: "eu", URL: peer.URL + "/"}, {Name: "us", URL: down.URL}},
+	}
+
+	status := p.FederationStatus()
+	if status.Up != 2 || status.Down != 1 {
+		t.Fatal("Expected 2 instances up and 1 down but was", status.Up, status.Down)
+	}
+	if status.Instances[2].Name != "us" || len(status.Instances[2].Error) == 0 {
+		t.Error("Expected an error for the peer which is down but was", status.Instances[2])
+	}
+	if len(status.Sources) != 2 || status.Sources[0].Source != "APNIC" || status.Sources[1].Source != "RIPE" {
+		t.Fatal("Expected APNIC and RIPE but was", status.Sources)
+	}
+	ripe := status.Sources[1]
+	if ripe.LatestVersion != 100 || len(ripe.Mirrors) != 2 {
+		t.Fatal("Unexpected RIPE status", ripe)
+	}
+	if local := ripe.Mirrors[0]; local.Instance != "local" || local.Version != 90 || local.Behind != 10 {
+		t.Error("Expected local to be 10 versions behind but was", local)
+	}
+	apnic := status.Sources[0]
+	if apnic.LatestSession != "a1" || !apnic.Mirrors[0].Paused || !apnic.Mirrors[1].OtherSession || apnic.Mirrors[1].Behind != 0 {
+		t.Error("Expected eu's APNIC to be on another session but was", apnic)
+	}
+}
+
+func TestFederationMetrics(t *testing.T) {
+	status := combineInstances(time.Now(), []FederatedInstance{
+		{Name: "local", Status: &InstanceStatus{Sources: []SourceStatus{
+			{Source: "RIPE", Label: "prod", SessionID: "s1", Version: 90},
+			{Source: "APNIC", SessionID: "a1", Version: 7, Paused: true},
+		}}},
+		{Name: `eu "1"`, Status: &InstanceStatus{Sources: []SourceStatus{
+			{Source: "RIPE", Label: "prod", SessionID: "s1", Version: 100},
+			{Source: "APNIC", SessionID: "a2", Version: 3},
+		}}},
+		{Name: "us", Error: "connection refused"},
+	})
+	var sb strings.Builder
+	if err := status.WriteMetrics(&sb); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	metrics := sb.String()
+	for _, line := range []string{
+		`nrtm4_federation_instances{state="up"} 2`,
+		`nrtm4_federation_instances{state="down"} 1`,
+		`nrtm4_federation_instance_up{instance="us"} 0`,
+		`nrtm4_federation_source_version{source="RIPE",label="prod",instance="eu \"1\""} 100`,
+		`nrtm4_federation_source_behind{source="RIPE",label="prod",instance="local"} 10`,
+		`nrtm4_federation_source_other_session{source="APNIC",label="",instance="eu \"1\""} 1`,
+		`nrtm4_federation_source_paused{source="APNIC",label="",instance="local"} 1`,
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Error("Expected metric", line, "in", metrics)
+		}
+	}
+	// Versions on another session can't be compared
+	if strings.Contains(metrics, `nrtm4_federation_source_behind{source="APNIC",label="",instance="eu \"1\""}`) {
+		t.Error("Expected no versions behind for an instance on another session", metrics)
+	}
+}
+
+func TestFederationConfigValidate(t *testing.T) {
+	valid := FederationConfig{Peers: []FederationPeer{{Name: "eu", URL: "https://mirror-eu:8080"}}, Timeout: "5s"}
+	if err := valid.validate(); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	for _, peers := range [][]FederationPeer{
+		{{Name: "", URL: "http://a"}},
+		{{Name: "eu", URL: "http://a"}, {Name: "eu", URL: "http://b"}},
+		{{Name: "eu", URL: "mirror-eu:8080"}},
+		{{Name: "eu", URL: "ftp://mirror-eu"}},
+	} {
+		if err := (FederationConfig{Peers: peers}).validate(); !errors.Is(err, ErrInvalidFederation) {
+			t.Error("Expected ErrInvalidFederation for", peers, "but was", err)
+		}
+	}
+}
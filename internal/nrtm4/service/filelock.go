@@ -0,0 +1,77 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// lockDirName is the directory in NRTMFilePath which holds the lock files
+const lockDirName = ".locks"
+
+// errFileLocked another process or goroutine holds the lock on the file
+var errFileLocked = errors.New("file is locked")
+
+// fileLock is an exclusive lock on a file in NRTMFilePath, so instances which share the
+// directory, e.g. the CLI and nrtm4serve, don't download, check or delete the same file at once
+type fileLock struct {
+	file *os.File
+}
+
+// lockFile waits for the lock on fileName
+func lockFile(fileName string) (*fileLock, error) {
+	return acquireFileLock(fileName, true)
+}
+
+// tryLockFile takes the lock on fileName, or returns errFileLocked if it's held
+func tryLockFile(fileName string) (*fileLock, error) {
+	return acquireFileLock(fileName, false)
+}
+
+// acquireFileLock locks a file in lockDirName named after fileName. The lock file is removed
+// when it's unlocked, so a lock taken on a file which was removed in the meantime is let go and
+// taken again on the new one.
+func acquireFileLock(fileName string, wait bool) (*fileLock, error) {
+	dir := filepath.Join(filepath.Dir(fileName), lockDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, filepath.Base(fileName)+".lock")
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err = flock(file, wait); err != nil {
+			file.Close()
+			return nil, err
+		}
+		opened, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(path); err == nil && os.SameFile(opened, current) {
+			return &fileLock{file: file}, nil
+		}
+		file.Close()
+	}
+}
+
+// unlock removes the lock file and releases the lock
+func (l *fileLock) unlock() {
+	os.Remove(l.file.Name())
+	funlock(l.file)
+	l.file.Close()
+}
+
+// partTarget is the name of the file a temp file made by downloadToTempFile is downloading, or
+// the empty string if name isn't a temp file
+func partTarget(name string) string {
+	if !strings.HasSuffix(name, ".part") {
+		return ""
+	}
+	name = strings.TrimSuffix(name, ".part")
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
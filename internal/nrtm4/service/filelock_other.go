@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || freebsd)
+
+package service
+
+import "os"
+
+// Files can't be locked on this platform, so instances shouldn't share NRTMFilePath
+func flock(file *os.File, wait bool) error {
+	return nil
+}
+
+func funlock(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileLock(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "nrtm-delta.7.EXAMPLE.json")
+	lock, err := lockFile(fileName)
+	if err != nil {
+		t.Fatal("Failed to lock file", err)
+	}
+	if _, err = tryLockFile(fileName); !errors.Is(err, errFileLocked) {
+		t.Error("Expected errFileLocked while the lock is held but was", err)
+	}
+	lock.unlock()
+	if _, err = os.Stat(lock.file.Name()); !errors.Is(err, os.ErrNotExist) {
+		t.Error("Expected the lock file to be removed but was", err)
+	}
+	again, err := tryLockFile(fileName)
+	if err != nil {
+		t.Fatal("Expected the lock to be free but was", err)
+	}
+	again.unlock()
+}
+
+func TestPartTarget(t *testing.T) {
+	for name, expected := range map[string]string{
+		"nrtm-delta.7.EXAMPLE.json.2786941.part": "nrtm-delta.7.EXAMPLE.json",
+		"nrtm-delta.7.EXAMPLE.json":              "",
+	} {
+		if target := partTarget(name); target != expected {
+			t.Error("Expected", expected, "for", name, "but was", target)
+		}
+	}
+}
@@ -2,31 +2,62 @@ package service
 
 import (
 	"bufio"
-	"compress/gzip"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"time"
 
 	"github.com/petchells/nrtm4client/internal/nrtm4/jsonseq"
-	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
 	"github.com/petchells/nrtm4client/internal/nrtm4/util"
 )
 
 var (
 	// ErrHashMismatch when a file downloaded from 'url' does not match its 'hash'
 	ErrHashMismatch = errors.New("hash does not match downloaded file")
+	// ErrTruncatedDownload when the connection closed before the whole file was received
+	ErrTruncatedDownload = errors.New("download was truncated")
+	// ErrFileTooLarge when a download is bigger than the most the client was told to fetch
+	ErrFileTooLarge = errors.New("file is larger than the maximum size")
+	// ErrBadResume when the rest of a download doesn't start where it was cut off, or is from
+	// another version of the file
+	ErrBadResume = errors.New("resumed download doesn't continue the file")
+
+	maxDownloadAttempts = 3
+	downloadRetryDelay  = 2 * time.Second
 )
 
 // GZIPSnapshotExtension extension GZIP files
 var GZIPSnapshotExtension = ".gz"
 
+// jsonSeqReadBufferSize is big enough for nearly every RPSL object, so the jsonseq reader can hand
+// out records without copying them
+const jsonSeqReadBufferSize = 64 * 1024
+
 type fileManager struct {
-	client Client
+	client   Client
+	warnings *syncWarnings
+	// maxRecordSize is the size over which records are passed to readJSONSeqRecords' fn as a
+	// *jsonseq.RecordTooLargeError. 0 means no limit.
+	maxRecordSize int
+	// progress is told how much of each file readJSONSeqRecords has read
+	progress *syncProgress
+	// maxFileSize is the most bytes a download can have. 0 means no limit.
+	maxFileSize int64
+	// strictness gives the profile notification files are checked with, by source name. nil
+	// checks them all with StrictnessStandard.
+	strictness func(string) Strictness
+	// source is the name of the stored source the files are for, which strictness is looked up
+	// by, so a server can't choose its own profile. It's empty when connecting a new source, whose
+	// name is the one in its notification file.
+	source string
 }
 
 func (fm fileManager) ensureDirectoryExists(path string) error {
@@ -42,34 +73,87 @@ func (fm fileManager) ensureDirectoryExists(path string) error {
 	return nil
 }
 
-func (fm fileManager) fetchFileAndCheckHash(unfURL string, fileRef persist.FileRefJSON, path string) (*os.File, error) {
-	fURL := fullURL(unfURL, fileRef.URL)
+// fetchFileAndCheckHash downloads fURL, which has been resolved from fileRef, and checks its hash.
+// The download is written to a temp file in tempDir, which is renamed into path only when the
+// hash matches, so a file found in path by name is always a complete one. The file is locked
+// while it's checked or downloaded.
+func (fm fileManager) fetchFileAndCheckHash(fURL string, fileRef protocol.FileRefJSON, path string, tempDir string) (*os.File, error) {
+	return fm.fetchFile(fURL, fileRef, path, tempDir, false)
+}
+
+// fetchFile is fetchFileAndCheckHash, but when revalidate is true caches are asked to check
+// their copy with the server from the first request
+func (fm fileManager) fetchFile(fURL string, fileRef protocol.FileRefJSON, path string, tempDir string, revalidate bool) (*os.File, error) {
 	if !validateURLString(fURL) {
 		logger.Info("URL in fileRef cannot be parsed", "fURL", fURL)
 		return nil, errors.New("Invalid URL in reference")
 	}
-	var file *os.File
-	_, err := os.Stat(filepath.Join(path, filepath.Base(fURL)))
-	if os.IsNotExist(err) {
-		logger.Info("Downloading file", "url", fURL)
-		if file, err = fm.writeResourceToPath(fURL, path); err != nil {
-			logger.Error("Failed to write file", "url", fURL, "path", path)
+	fileName := filepath.Join(path, filepath.Base(fURL))
+	// Another instance sharing path may be downloading or checking the same file
+	lock, err := lockFile(fileName)
+	if err != nil {
+		logger.Error("Failed to lock file", "file", fileName, "error", err)
+		return nil, err
+	}
+	defer lock.unlock()
+	if _, err := os.Stat(fileName); err == nil {
+		return openAndCheckHash(fileName, fileRef.Hash)
+	}
+	if len(tempDir) == 0 {
+		tempDir = path
+	}
+	// A proxy may have cached a bad copy, so if the hash doesn't match we try once more and
+	// ask it to get the file from the server again.
+	for retried := false; ; retried = true {
+		revalidate = revalidate || retried
+		httpLogger.Info("Downloading file", "url", fURL, "revalidate", revalidate)
+		tmpName, err := fm.downloadToTempFile(fURL, tempDir, revalidate)
+		if err != nil {
+			logger.Error("Failed to write file", "url", fURL, "path", tempDir)
+			return nil, err
+		}
+		file, err := openAndCheckHash(tmpName, fileRef.Hash)
+		if err != nil {
+			if errors.Is(err, ErrHashMismatch) {
+				os.Rename(tmpName+"-BADHASH", fileName+"-BADHASH")
+				if !retried {
+					fm.warnings.add(WarningRetry, fileRef.Version, "hash of %v didn't match, downloaded it again without caches", fURL)
+					continue
+				}
+			} else {
+				os.Remove(tmpName)
+			}
 			return nil, err
 		}
+		file.Close()
+		if err = os.Rename(tmpName, fileName); err != nil {
+			logger.Error("Failed to move download into place. The temp dir must be on the same filesystem", "from", tmpName, "to", fileName, "error", err)
+			os.Remove(tmpName)
+			return nil, err
+		}
+		return os.Open(fileName)
 	}
-	if file, err = os.Open(filepath.Join(path, filepath.Base(fURL))); err != nil {
-		logger.Error("Failed to open file", "url", fURL, "path", path)
+}
+
+// openAndCheckHash opens a file and compares its hash with the expected one. If they
+// don't match the file is renamed with a -BADHASH suffix.
+func openAndCheckHash(fileName string, hash string) (*os.File, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		logger.Error("Failed to open file", "file", fileName)
 		return nil, err
 	}
 	sum, err := calcHash256(file)
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
-	if sum != fileRef.Hash {
-		if err = os.Rename(file.Name(), file.Name()+"-BADHASH"); err != nil {
+	if sum != hash {
+		file.Close()
+		if err = os.Rename(fileName, fileName+"-BADHASH"); err != nil {
 			return nil, err
 		}
-		logger.Warn("Hash does not match the downloaded file. Try again", "file", file.Name(), "hash", fileRef.Hash, "calculated", sum)
+		logger.Warn("Hash does not match the downloaded file. Try again", "file", fileName, "hash", hash, "calculated", sum)
 		return nil, ErrHashMismatch
 	}
 	return file, nil
@@ -87,63 +171,177 @@ func (fm fileManager) readJSONSeqRecords(
 	if reader, err = os.Open(file.Name()); err != nil {
 		return err
 	}
+	if fm.progress != nil {
+		reader = &progressReader{reader: reader, progress: fm.progress, size: fileSize(file)}
+	}
 	var bufioReader *bufio.Reader
 	if file.Name()[len(file.Name())-len(GZIPSnapshotExtension):] == GZIPSnapshotExtension {
-		var gzreader *gzip.Reader
-		if gzreader, err = gzip.NewReader(reader); err != nil {
+		var gzreader *gzipMemberReader
+		if gzreader, err = newGzipMemberReader(reader); err != nil {
 			return err
 		}
-		bufioReader = bufio.NewReader(gzreader)
+		bufioReader = bufio.NewReaderSize(gzreader, jsonSeqReadBufferSize)
 	} else {
-		bufioReader = bufio.NewReader(reader)
+		bufioReader = bufio.NewReaderSize(reader, jsonSeqReadBufferSize)
 	}
-	err = jsonseq.ReadRecords(bufioReader, func(bytes []byte, err error) error {
+	err = jsonseq.ReadRecordsLimit(bufioReader, fm.maxRecordSize, func(bytes []byte, err error) error {
 		return fn(bytes, err)
 	})
 	return err
 }
 
-func (fm fileManager) writeResourceToPath(url string, path string) (*os.File, error) {
-	fileName := filepath.Base(url)
-	if f, err := os.Open(filepath.Join(path, fileName)); err == nil {
-		return f, err
+// downloadToTempFile writes the resource at url to a new temp file in dir and returns its name.
+// A truncated download is resumed from where it stopped if the server sent a validator, otherwise
+// it starts again. The file is removed if anything goes wrong, so a partial download is never
+// left behind.
+func (fm fileManager) downloadToTempFile(url string, dir string, revalidate bool) (string, error) {
+	outFile, err := os.CreateTemp(dir, filepath.Base(url)+".*.part")
+	if err != nil {
+		logger.Error("Failed to open file on disk", "error", err)
+		return "", err
 	}
-	var reader io.Reader
-	var err error
-	if reader, err = fm.client.getResponseBody(url); err != nil {
-		logger.Error("Failed to fetch file", url, err)
-		return nil, err
+	fileName := outFile.Name()
+	if err = fm.downloadTo(outFile, url, revalidate); err != nil {
+		outFile.Close()
+		os.Remove(fileName)
+		return "", err
+	}
+	if err = outFile.Close(); err != nil {
+		os.Remove(fileName)
+		return "", err
+	}
+	return fileName, nil
+}
+
+func (fm fileManager) downloadTo(outFile *os.File, url string, revalidate bool) error {
+	req := fileRequest{Revalidate: revalidate}
+	for attempt := 1; ; attempt++ {
+		resp, err := fm.client.getFile(url, req)
+		if errors.Is(err, ErrBadResume) && attempt < maxDownloadAttempts {
+			// The part we have can't be trusted to be the same file, so start again
+			httpLogger.Warn("Restarting download", "url", url, "error", err)
+			req.Offset, req.IfRange = 0, ""
+			continue
+		}
+		if err != nil {
+			httpLogger.Error("Failed to fetch file", url, err)
+			return err
+		}
+		if !resp.Partial {
+			if err = truncateFile(outFile); err != nil {
+				return err
+			}
+		}
+		body := resp.Body
+		if fm.maxFileSize > 0 {
+			body = &sizeLimitReader{reader: body, remaining: fm.maxFileSize - req.Offset}
+		}
+		err = transferReaderToFile(body, outFile)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrTruncatedDownload) || attempt >= maxDownloadAttempts {
+			logger.Error("writing file:", "error", err)
+			return err
+		}
+		if req.Offset, err = outFile.Seek(0, io.SeekCurrent); err != nil {
+			return err
+		}
+		req.IfRange = resp.Validator
+		httpLogger.Warn("Retrying truncated download", "url", url, "attempt", attempt, "offset", req.Offset, "resume", len(req.IfRange) > 0)
+		fm.warnings.add(WarningRetry, 0, "download of %v was cut off at %d bytes and was retried", url, req.Offset)
+		util.AppClock.Sleep(time.Duration(attempt) * downloadRetryDelay)
 	}
-	return readerToFile(reader, path, fileName)
 }
 
-func (fm fileManager) downloadNotificationFile(url string) (persist.NotificationJSON, error) {
-	var notification persist.NotificationJSON
+// sizeLimitReader fails with ErrFileTooLarge once more than remaining bytes have been read
+type sizeLimitReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *sizeLimitReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, ErrFileTooLarge
+	}
+	return n, err
+}
+
+func truncateFile(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.Seek(0, io.SeekStart)
+	return err
+}
+
+func (fm fileManager) downloadNotificationFile(url string) (protocol.NotificationJSON, http.Header, error) {
+	var notification protocol.NotificationJSON
+	var header http.Header
 	var err error
-	if notification, err = fm.client.getUpdateNotification(url); err != nil {
+	if notification, header, err = fm.client.getUpdateNotification(url); err != nil {
 		logger.Error("fetching notificationFile", "error", err)
-		return notification, err
+		return notification, header, err
+	}
+	err = fm.validateNotificationFile(notification)
+	return notification, header, err
+}
+
+// validateNotificationFile checks a notification file with its source's strictness
+func (fm fileManager) validateNotificationFile(file protocol.NotificationJSON) error {
+	strictness := StrictnessStandard
+	if fm.strictness != nil {
+		name := fm.source
+		if len(name) == 0 {
+			name = file.Source
+		}
+		strictness = fm.strictness(name)
 	}
-	err = validateNotificationFile(notification)
-	return notification, err
+	return validateNotificationFile(file, strictness, fm.warnings)
 }
 
-func validateNotificationFile(file persist.NotificationJSON) error {
+// validateNotificationFile checks a notification file. The irregularities which strictness
+// can tolerate are a short session ID, source name or snapshot URL, and duplicate or missing
+// deltas.
+func validateNotificationFile(file protocol.NotificationJSON, strictness Strictness, warnings *syncWarnings) error {
 	if file.NrtmVersion != 4 {
 		return newNRTMServiceError("notificationFile nrtm version is not v4: '%v'", file.NrtmVersion)
 	}
+	if file.Version < 1 {
+		return newNRTMServiceError("notificationFile version must be positive: '%v'", file.Version)
+	}
+	minor := func(err error) error {
+		return strictness.tolerate(err, warnings, file.Version)
+	}
 	if len(file.SessionID) < 36 {
-		return newNRTMServiceError("notificationFile session ID is not valid: '%v'", file.SessionID)
+		if err := minor(newNRTMServiceError("notificationFile session ID is not valid: '%v'", file.SessionID)); err != nil {
+			return err
+		}
 	}
 	if len(file.Source) < 3 {
-		return newNRTMServiceError("notificationFile source is not valid: '%v'", file.Source)
-	}
-	if file.Version < 1 {
-		return newNRTMServiceError("notificationFile version must be positive: '%v'", file.NrtmVersion)
+		if err := minor(newNRTMServiceError("notificationFile source is not valid: '%v'", file.Source)); err != nil {
+			return err
+		}
 	}
 	if len(file.SnapshotRef.URL) < 10 {
-		return newNRTMServiceError("notificationFile snapshot url is not valid: '%v'", file.SnapshotRef.URL)
+		if err := minor(newNRTMServiceError("notificationFile snapshot url is not valid: '%v'", file.SnapshotRef.URL)); err != nil {
+			return err
+		}
 	}
+	return checkDeltaSequence(file, minor)
+}
+
+// validateDeltaSequence checks the deltas have unique, contiguous versions ending at the
+// notification version
+func validateDeltaSequence(file protocol.NotificationJSON) error {
+	return checkDeltaSequence(file, func(err error) error { return err })
+}
+
+// checkDeltaSequence is validateDeltaSequence, where duplicate and missing versions are passed
+// to minor, which can tolerate them
+func checkDeltaSequence(file protocol.NotificationJSON, minor func(error) error) error {
 	if file.DeltaRefs == nil || len(file.DeltaRefs) == 0 {
 		return ErrNRTM4NoDeltasInNotification
 	}
@@ -154,11 +352,14 @@ func validateNotificationFile(file persist.NotificationJSON) error {
 	versionSet := util.NewSet(versions...)
 	if len(versionSet) != len(versions) {
 		logger.Error("Duplicate delta version found in notification file", "source", file.Source)
-		return ErrNRTM4DuplicateDeltaVersion
+		if err := minor(ErrNRTM4DuplicateDeltaVersion); err != nil {
+			return err
+		}
 	}
 	sort.Slice(versions, func(i, j int) bool {
 		return versions[i] < versions[j]
 	})
+	versions = slices.Compact(versions)
 	lo := versions[0]
 	hi := versions[len(versions)-1]
 	if hi != file.Version {
@@ -166,45 +367,27 @@ func validateNotificationFile(file persist.NotificationJSON) error {
 	}
 	if lo+uint32(len(versions)-1) != hi {
 		logger.Error("Delta version is missing from the notification file", "source", file.Source)
-		return ErrNRTM4NotificationDeltaSequenceBroken
+		return minor(ErrNRTM4NotificationDeltaSequenceBroken)
 	}
 	return nil
 }
 
-func readerToFile(reader io.Reader, path string, fileName string) (*os.File, error) {
-	var outFile *os.File
-	var err error
-	if outFile, err = os.Create(filepath.Join(path, fileName)); err != nil {
-		logger.Error("Failed to open file on disk", "error", err)
-		return nil, err
-	}
-	defer func() {
-		if err := outFile.Close(); err != nil {
-			panic(err)
-		}
-	}()
-	if err = transferReaderToFile(reader, outFile); err != nil {
-		logger.Error("writing file:", "error", err)
-		return nil, err
-	}
-	return outFile, err
-}
-
 func transferReaderToFile(from io.Reader, to *os.File) error {
 	buf := make([]byte, fileWriteBufferLength)
 	for {
 		n, err := from.Read(buf)
-		if err != nil && err != io.EOF {
-			return err
+		if n > 0 {
+			if _, werr := to.Write(buf[:n]); werr != nil {
+				return werr
+			}
 		}
-		if n == 0 {
-			break
+		if err == io.EOF {
+			return nil
 		}
-		if _, err := to.Write(buf[:n]); err != nil {
+		if err != nil {
 			return err
 		}
 	}
-	return nil
 }
 
 func calcHash256(file *os.File) (string, error) {
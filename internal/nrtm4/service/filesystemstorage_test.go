@@ -1,21 +1,31 @@
 package service
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
 	"github.com/petchells/nrtm4client/internal/nrtm4/util"
 )
 
 var testResourcePath = "../testresources/"
 
 func TestSuccess(t *testing.T) {
-	fm := fileManager{dlClientStub{}}
-	_, err := fm.downloadNotificationFile("")
+	fm := fileManager{client: dlClientStub{}}
+	_, _, err := fm.downloadNotificationFile("")
 	if err != nil {
 		t.Error("should not be any errors but found:", err)
 	} else {
@@ -27,9 +37,9 @@ type dlClientStub struct {
 	Client
 }
 
-func (c dlClientStub) getUpdateNotification(string) (persist.NotificationJSON, error) {
-	notification := persist.NotificationJSON{
-		NrtmFileJSON: persist.NrtmFileJSON{
+func (c dlClientStub) getUpdateNotification(string) (protocol.NotificationJSON, http.Header, error) {
+	notification := protocol.NotificationJSON{
+		NrtmFileJSON: protocol.NrtmFileJSON{
 			NrtmVersion: 4,
 			SessionID:   uuid.NewString(),
 			Type:        persist.NotificationFile.String(),
@@ -38,17 +48,17 @@ func (c dlClientStub) getUpdateNotification(string) (persist.NotificationJSON, e
 		},
 		Timestamp:      util.AppClock.Now().Format(time.RFC3339),
 		NextSigningKey: new(string),
-		SnapshotRef: persist.FileRefJSON{
+		SnapshotRef: protocol.FileRefJSON{
 			URL: "https://xxx.xxx.xx/notification.json",
 		},
-		DeltaRefs: []persist.FileRefJSON{
+		DeltaRefs: []protocol.FileRefJSON{
 			{
 				URL:     "https://xxx.xxx.xx/delta-22.json",
 				Version: 22,
 			},
 		},
 	}
-	return notification, nil
+	return notification, nil, nil
 }
 
 func TestGZIPSnapshotReader(t *testing.T) {
@@ -139,10 +149,13 @@ func TestWriteFileToPath(t *testing.T) {
 		client: NewStubClient(t),
 	}
 
-	file, err := fm.writeResourceToPath(stubSnapshot2URL, tmpdir)
-	if file == nil || err != nil {
+	fileName, err := fm.downloadToTempFile(stubSnapshot2URL, tmpdir, false)
+	if len(fileName) == 0 || err != nil {
 		t.Fatal("File was not written:", err)
 	}
+	if filepath.Dir(fileName) != tmpdir {
+		t.Error("Temp file was written outside of the directory", fileName)
+	}
 
 }
 
@@ -164,12 +177,12 @@ func TestValidateURLString(t *testing.T) {
 }
 
 func TestFetchFileAndCheckHash(t *testing.T) {
-	unfURL := "https://wherever.eu/unf.json"
+	fURL := "https://wherever.eu/testtext.txt"
 	body := `{
 	"secretMessage", "Some text in a file"
 	}
 	`
-	ref := persist.FileRefJSON{
+	ref := protocol.FileRefJSON{
 		URL:     "testtext.txt",
 		Hash:    "123456",
 		Version: 3,
@@ -186,17 +199,20 @@ func TestFetchFileAndCheckHash(t *testing.T) {
 		fm := fileManager{
 			client: client,
 		}
-		_, err := fm.fetchFileAndCheckHash(unfURL, ref, dir)
+		_, err := fm.fetchFileAndCheckHash(fURL, ref, dir, "")
 		if err != ErrHashMismatch {
 			t.Fatal("Expected ErrHashMismatch but was:", err)
 		}
+		if _, err = os.Stat(filepath.Join(dir, "testtext.txt")); !os.IsNotExist(err) {
+			t.Error("File with bad hash should not have been moved into place")
+		}
 	}
 	{
 		fm := fileManager{
 			client: client,
 		}
 		ref.Hash = "4d14d44910c1abae9b55b6cc0f722369834b3c1942f3ee4bc0e051b1de10794d"
-		f, err := fm.fetchFileAndCheckHash(unfURL, ref, dir)
+		f, err := fm.fetchFileAndCheckHash(fURL, ref, dir, "")
 		if err != nil {
 			t.Fatal("Unexpected error:", err)
 		}
@@ -209,5 +225,180 @@ func TestFetchFileAndCheckHash(t *testing.T) {
 			t.Error("Got unexpected body", string(bytes))
 		}
 	}
+	{
+		tempDir := filepath.Join(dir, "tmp")
+		if err = os.Mkdir(tempDir, 0755); err != nil {
+			t.Fatal("Failed to create temp dir", err)
+		}
+		fm := fileManager{
+			client: client,
+		}
+		f, err := fm.fetchFileAndCheckHash("https://wherever.eu/other.txt", ref, dir, tempDir)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		defer f.Close()
+		if f.Name() != filepath.Join(dir, "other.txt") {
+			t.Error("File was not moved into place", f.Name())
+		}
+		if entries, _ := os.ReadDir(tempDir); len(entries) > 0 {
+			t.Error("Temp file was left behind", entries[0].Name())
+		}
+	}
+}
+
+type truncatingClient struct {
+	Client
+	body     string
+	failures *int
+}
+
+func (c truncatingClient) getFile(string, fileRequest) (fileResponse, error) {
+	if *c.failures > 0 {
+		*c.failures--
+		return fileResponse{Body: &lengthCheckingReader{
+			body:     io.NopCloser(strings.NewReader(c.body[:10])),
+			expected: int64(len(c.body)),
+		}}, nil
+	}
+	return fileResponse{Body: strings.NewReader(c.body)}, nil
+}
 
+func TestTruncatedDownloadIsRetried(t *testing.T) {
+	downloadRetryDelay = time.Millisecond
+	dir, err := os.MkdirTemp("", "nrtm4test")
+	if err != nil {
+		t.Fatal("Failed to create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	body := "Far and few, far and few are the lands where the Jumblies live."
+	{
+		failures := maxDownloadAttempts
+		fm := fileManager{client: truncatingClient{body: body, failures: &failures}}
+		_, err = fm.downloadToTempFile("https://example.com/truncated.json", dir, false)
+		if err != ErrTruncatedDownload {
+			t.Fatal("Expected ErrTruncatedDownload but was", err)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+			t.Error("Partial file should have been removed", entries[0].Name())
+		}
+	}
+	{
+		failures := maxDownloadAttempts - 1
+		fm := fileManager{client: truncatingClient{body: body, failures: &failures}}
+		fileName, err := fm.downloadToTempFile("https://example.com/retried.json", dir, false)
+		if err != nil {
+			t.Fatal("Expected download to succeed on last attempt but was", err)
+		}
+		bytes, err := os.ReadFile(fileName)
+		if err != nil || string(bytes) != body {
+			t.Error("Unexpected file contents", string(bytes), err)
+		}
+	}
+}
+
+func TestTruncatedDownloadIsResumed(t *testing.T) {
+	downloadRetryDelay = time.Millisecond
+	body := "They went to sea in a Sieve, they did, in a Sieve they went to sea."
+	ranges := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"sieve"`)
+		if r.Header.Get("If-Range") == `"sieve"` && r.Header.Get("Range") == "bytes=10-" {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 10-%d/%d", len(body)-1, len(body)))
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, body[10:])
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		io.WriteString(w, body[:10])
+	}))
+	defer server.Close()
+	dir, err := os.MkdirTemp("", "nrtm4test")
+	if err != nil {
+		t.Fatal("Failed to create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	fm := fileManager{client: HTTPClient{}}
+	fileName, err := fm.downloadToTempFile(server.URL+"/sieve.json", dir, false)
+	if err != nil {
+		t.Fatal("Expected download to be resumed but was", err)
+	}
+	bytes, err := os.ReadFile(fileName)
+	if err != nil || string(bytes) != body {
+		t.Error("Unexpected file contents", string(bytes), err)
+	}
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != "bytes=10-" {
+		t.Error("Unexpected Range headers", ranges)
+	}
+}
+
+func TestBadResumeRestartsDownload(t *testing.T) {
+	downloadRetryDelay = time.Millisecond
+	body := "And every one said, who saw them go, oh won't they be soon upset, you know!"
+	ranges := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"sieve"`)
+		switch len(ranges) {
+		case 1:
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			io.WriteString(w, body[:10])
+		case 2:
+			// Not where the download was cut off
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 5-%d/%d", len(body)-1, len(body)))
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, body[5:])
+		default:
+			io.WriteString(w, body)
+		}
+	}))
+	defer server.Close()
+	fm := fileManager{client: HTTPClient{}}
+	fileName, err := fm.downloadToTempFile(server.URL+"/sieve.json", t.TempDir(), false)
+	if err != nil {
+		t.Fatal("Expected the download to start again but was", err)
+	}
+	if bytes, err := os.ReadFile(fileName); err != nil || string(bytes) != body {
+		t.Error("Unexpected file contents", string(bytes), err)
+	}
+	if len(ranges) != 3 || ranges[1] != "bytes=10-" || ranges[2] != "" {
+		t.Error("Unexpected Range headers", ranges)
+	}
+
+	header := http.Header{"Content-Range": {"bytes 10-99/100"}, "Etag": {`"other"`}}
+	if err = checkResumed(header, fileRequest{Offset: 10, IfRange: `"sieve"`}); !errors.Is(err, ErrBadResume) {
+		t.Error("Expected a resume of another version of the file to be rejected but was", err)
+	}
+}
+
+func TestHashMismatchRevalidatesCachedCopy(t *testing.T) {
+	body := "The water it soon came in, it did, the water it soon came in."
+	cacheControl := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cacheControl = append(cacheControl, r.Header.Get("Cache-Control"))
+		if r.Header.Get("Cache-Control") == "no-cache" {
+			io.WriteString(w, body)
+			return
+		}
+		// A stale copy from a proxy
+		io.WriteString(w, body[:20])
+	}))
+	defer server.Close()
+	dir, err := os.MkdirTemp("", "nrtm4test")
+	if err != nil {
+		t.Fatal("Failed to create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	sum := sha256.Sum256([]byte(body))
+	hash := hex.EncodeToString(sum[:])
+	fm := fileManager{client: HTTPClient{}}
+	f, err := fm.fetchFileAndCheckHash(server.URL+"/water.json", protocol.FileRefJSON{Hash: hash}, dir, "")
+	if err != nil {
+		t.Fatal("Expected revalidated download to succeed but was", err)
+	}
+	f.Close()
+	if len(cacheControl) != 2 || cacheControl[0] != "" || cacheControl[1] != "no-cache" {
+		t.Error("Unexpected Cache-Control headers", cacheControl)
+	}
 }
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+// ErrEmptyFilter a filter has no maintainers or organisations, so it would leave everything out
+var ErrEmptyFilter = errors.New("filter must list at least one maintainer or organisation")
+
+// FilterConfig keeps only the objects of some maintainers or organisations, for a mirror which
+// doesn't need the whole database. An object is kept if any of its mnt-by values is in MntBy or
+// its org is in Org. Everything else in the snapshot and deltas is left out.
+type FilterConfig struct {
+	MntBy []string `json:"mnt_by"`
+	Org   []string `json:"org"`
+	// sampleRate is set for a source connected with a sample. Only objects in the sample are
+	// kept, and if there are no maintainers or organisations every one of them is.
+	sampleRate float64
+}
+
+func (f *FilterConfig) validate() error {
+	if f != nil && len(f.MntBy) == 0 && len(f.Org) == 0 {
+		return ErrEmptyFilter
+	}
+	return nil
+}
+
+// keeps says whether obj passes the filter. A nil filter keeps everything.
+func (f *FilterConfig) keeps(obj rpsl.Rpsl) bool {
+	if f == nil {
+		return true
+	}
+	if f.sampleRate > 0 {
+		if !sampled(obj, f.sampleRate) {
+			return false
+		}
+		if len(f.MntBy) == 0 && len(f.Org) == 0 {
+			return true
+		}
+	}
+	for _, attr := range rpsl.Attributes(obj.Payload) {
+		var allowed []string
+		switch attr.Name {
+		case "mnt-by":
+			allowed = f.MntBy
+		case "org":
+			allowed = f.Org
+		default:
+			continue
+		}
+		for _, v := range strings.Split(attr.Value, ",") {
+			v = strings.TrimSpace(v)
+			if slices.ContainsFunc(allowed, func(a string) bool { return strings.EqualFold(a, v) }) {
+				return true
+			}
+		}
+	}
+	return false
+}
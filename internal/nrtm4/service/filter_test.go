@@ -0,0 +1,120 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/quirks"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+func TestFilterKeeps(t *testing.T) {
+	filter := &FilterConfig{MntBy: []string{"EXAMPLE-MNT"}, Org: []string{"ORG-EX1-TEST"}}
+	expectations := map[string]bool{
+		"route: 192.0.2.0/24\norigin: AS65530\nmnt-by: example-mnt\nsource: EXAMPLE\n":            true,
+		"route: 192.0.2.0/24\norigin: AS65530\nmnt-by: OTHER-MNT, EXAMPLE-MNT\nsource: EXAMPLE\n": true,
+		"aut-num: AS65530\norg: ORG-EX1-TEST\nmnt-by: OTHER-MNT\nsource: EXAMPLE\n":               true,
+		"route: 192.0.2.0/24\norigin: AS65530\nmnt-by: OTHER-MNT\nsource: EXAMPLE\n":              false,
+		"person: A Person\nnic-hdl: AP1-TEST\nsource: EXAMPLE\n":                                  false,
+		"route: 192.0.2.0/24\nremarks: mnt-by: EXAMPLE-MNT\nsource: EXAMPLE\n":                    false,
+	}
+	for payload, expected := range expectations {
+		if filter.keeps(rpsl.Rpsl{Payload: payload}) != expected {
+			t.Error("Expected", expected, "for", payload)
+		}
+	}
+	var none *FilterConfig
+	if !none.keeps(rpsl.Rpsl{Payload: "person: A Person\n"}) {
+		t.Error("Expected no filter to keep everything")
+	}
+	if err := (&FilterConfig{}).validate(); !errors.Is(err, ErrEmptyFilter) {
+		t.Error("Expected ErrEmptyFilter but was", err)
+	}
+}
+
+func TestSnapshotLoaderFilters(t *testing.T) {
+	filter := &FilterConfig{MntBy: []string{"EXAMPLE-MNT"}}
+	load := snapshotLoader(snapshotJSONSeq("3",
+		`mntner: EXAMPLE-MNT\nmnt-by: EXAMPLE-MNT\nsource: EXAMPLE\n`,
+		`mntner: OTHER-MNT\nmnt-by: OTHER-MNT\nsource: EXAMPLE\n`,
+	), 3, new(protocol.SnapshotFileJSON), filter, quirks.Set{})
+	kept := []string{}
+	err := load(func(objs []rpsl.Rpsl) error {
+		for _, obj := range objs {
+			kept = append(kept, obj.PrimaryKey)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(kept) != 1 || kept[0] != "EXAMPLE-MNT" {
+		t.Error("Expected only EXAMPLE-MNT to be kept but was", kept)
+	}
+}
+
+type filteredDeltaRepo struct {
+	saveSourceRepo
+	added   *[]string
+	deleted *[]string
+}
+
+func (r filteredDeltaRepo) AddModifyObject(source persist.NRTMSource, obj rpsl.Rpsl, file protocol.NrtmFileJSON) error {
+	*r.added = append(*r.added, obj.PrimaryKey)
+	return nil
+}
+
+func (r filteredDeltaRepo) DeleteObject(source persist.NRTMSource, objectType, primaryKey string, file protocol.NrtmFileJSON) error {
+	if primaryKey != "192.0.2.0/24AS65530" {
+		return errors.New("no rows in result set")
+	}
+	*r.deleted = append(*r.deleted, primaryKey)
+	return nil
+}
+
+func TestApplyDeltaFuncFilters(t *testing.T) {
+	sessionID := "ca128382-78d9-41d1-8927-1ecef15275be"
+	source := persist.NRTMSource{Source: "EXAMPLE", SessionID: sessionID, Version: 2}
+	added, deleted := []string{}, []string{}
+	repo := filteredDeltaRepo{added: &added, deleted: &deleted}
+	filter := &FilterConfig{MntBy: []string{"EXAMPLE-MNT"}}
+	warnings := &syncWarnings{}
+	fn := applyDeltaFunc(repo, source, filter, nil, StrictnessStandard, quirks.Set{}, protocol.NotificationJSON{}, protocol.FileRefJSON{Version: 3}, deltaEventSink{}, new(protocol.DeltaFileJSON), warnings)
+	records := []string{
+		`{"nrtm_version": 4, "type": "delta", "source": "EXAMPLE", "session_id": "` + sessionID + `", "version": 3}`,
+		`{"action": "add_modify", "object": "route: 192.0.3.0/24\norigin: AS65530\nmnt-by: EXAMPLE-MNT\nsource: EXAMPLE\n"}`,
+		// Was kept until its maintainer changed
+		`{"action": "add_modify", "object": "route: 192.0.2.0/24\norigin: AS65530\nmnt-by: OTHER-MNT\nsource: EXAMPLE\n"}`,
+		// Was never kept
+		`{"action": "add_modify", "object": "route: 198.51.100.0/24\norigin: AS65530\nmnt-by: OTHER-MNT\nsource: EXAMPLE\n"}`,
+		`{"action": "delete", "object_class": "route", "primary_key": "198.51.100.0/24AS65530"}`,
+	}
+	for _, record := range records {
+		if err := fn([]byte(record), nil); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	if len(added) != 1 || added[0] != "192.0.3.0/24AS65530" {
+		t.Error("Expected only the object which passes the filter to be added but was", added)
+	}
+	if len(deleted) != 1 || deleted[0] != "192.0.2.0/24AS65530" {
+		t.Error("Expected the object which no longer passes the filter to be deleted but was", deleted)
+	}
+	if all := warnings.all(); len(all) != 0 {
+		t.Error("Expected deletes of filtered objects not to be warnings but was", all)
+	}
+}
+
+func TestReadConfigFileFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"sources": {"EXAMPLE": {"filter": {"mnt_by": []}}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReadConfigFile(path, &AppConfig{}); !errors.Is(err, ErrEmptyFilter) {
+		t.Error("Expected ErrEmptyFilter but was", err)
+	}
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// defaultForecastWindow is how far back forecast looks for the growth of each source
+const defaultForecastWindow = 30 * 24 * time.Hour
+
+// objectsTable is the table, or the prefix of the partitions, which holds every object version
+const objectsTable = "nrtm_rpslobject"
+
+// maxForecastDays is about as far ahead as a time.Duration reaches
+const maxForecastDays = 100000
+
+// ForecastReport estimates when the filesystem holding the downloaded files and the database
+// will fill up, from how fast each live source grew over the window
+type ForecastReport struct {
+	Generated time.Time `json:"generated"`
+	// WindowDays is how far back the growth was measured, which sync_run_retention can shorten
+	WindowDays float64          `json:"window_days"`
+	Sources    []SourceGrowth   `json:"sources"`
+	Disk       CapacityForecast `json:"disk"`
+	Database   CapacityForecast `json:"database"`
+}
+
+// SourceGrowth is how fast a source grew over the window. Each delta is assumed to be the
+// size of the source's average delta file, and each change to add one object row of the
+// average size. Each new session downloads a snapshot the size of the last one, and keeps the
+// old session's objects in the database unless session_retention removes them.
+type SourceGrowth struct {
+	Source string `json:"source"`
+	Label  string `json:"label"`
+	// Days is how long the source's recorded updates cover, at most the window
+	Days           float64 `json:"days"`
+	VersionsPerDay float64 `json:"versions_per_day"`
+	ChangesPerDay  float64 `json:"changes_per_day"`
+	// SessionsPerDay is how often the source was re-initialized with a new session over the
+	// window
+	SessionsPerDay  float64 `json:"sessions_per_day"`
+	DiskBytesPerDay float64 `json:"disk_bytes_per_day"`
+	DBBytesPerDay   float64 `json:"db_bytes_per_day"`
+}
+
+// CapacityForecast is how much of a store is used and free, how fast it's growing, and when
+// it will be full at that rate. The disk's used bytes are the files under NRTM4_FILE_PATH, and
+// the database's are its tables. FreeBytes is nil when it isn't known, and DaysLeft and Full
+// are nil when either FreeBytes isn't known or the store isn't growing.
+type CapacityForecast struct {
+	UsedBytes   int64      `json:"used_bytes"`
+	FreeBytes   *int64     `json:"free_bytes"`
+	BytesPerDay float64    `json:"bytes_per_day"`
+	DaysLeft    *float64   `json:"days_left"`
+	Full        *time.Time `json:"full"`
+}
+
+// Forecast measures the growth of each live source over window, or the default of 30 days, and
+// estimates when the files and the database will run out of space at that rate. The database's
+// space is only known when database.capacity_mib is set in the config.
+func (p NRTMProcessor) Forecast(window time.Duration) (ForecastReport, error) {
+	if window <= 0 {
+		window = defaultForecastWindow
+	}
+	if p.config.SyncRunRetention > 0 {
+		window = min(window, p.config.SyncRunRetention)
+	}
+	now := util.AppClock.Now()
+	report := ForecastReport{Generated: now, WindowDays: days(window), Sources: []SourceGrowth{}}
+	info, err := p.repo.GetSchemaInfo()
+	if err != nil {
+		return report, err
+	}
+	var objectBytes, objectRows int64
+	for _, table := range info.Tables {
+		report.Database.UsedBytes += table.TotalBytes
+		if strings.HasPrefix(table.Name, objectsTable) {
+			objectBytes += table.TotalBytes
+			objectRows += table.EstimatedRows
+		}
+	}
+	var rowBytes float64
+	if objectRows > 0 {
+		rowBytes = float64(objectBytes) / float64(objectRows)
+	}
+	ds := NrtmDataService{Repository: p.repo}
+	sources, err := ds.getSources()
+	if err != nil {
+		return report, err
+	}
+	newSessions := map[string]int{}
+	for _, source := range sources {
+		if source.Superseded != nil && source.Superseded.After(now.Add(-window)) {
+			newSessions[sessionKey(source.Source, supersededLabel(source.Label))]++
+		}
+	}
+	for _, source := range sources {
+		if source.Superseded != nil {
+			continue
+		}
+		growth, err := p.sourceGrowth(source, now.Add(-window), now, rowBytes)
+		if err != nil {
+			return report, err
+		}
+		sessions := newSessions[sessionKey(source.Source, source.Label)]
+		if err = p.addSessionGrowth(&growth, source, float64(sessions)/days(window), rowBytes); err != nil {
+			return report, err
+		}
+		report.Sources = append(report.Sources, growth)
+		report.Disk.BytesPerDay += growth.DiskBytesPerDay
+		report.Database.BytesPerDay += growth.DBBytesPerDay
+	}
+	if report.Disk.UsedBytes, err = dirSize(p.config.NRTMFilePath); err != nil {
+		return report, err
+	}
+	if free, err := freeSpace(p.config.NRTMFilePath); err == nil {
+		diskFree := int64(free)
+		report.Disk.FreeBytes = &diskFree
+	} else {
+		logger.Warn("Cannot read the free space for downloaded files", "path", p.config.NRTMFilePath, "error", err)
+	}
+	if capacity := p.config.Database.CapacityMiB << 20; capacity > 0 {
+		dbFree := max(capacity-report.Database.UsedBytes, 0)
+		report.Database.FreeBytes = &dbFree
+	}
+	report.Disk.forecast(now)
+	report.Database.forecast(now)
+	return report, nil
+}
+
+// sourceGrowth averages the versions and changes of a source's updates in [from, to) over the
+// time they cover
+func (p NRTMProcessor) sourceGrowth(source persist.NRTMSource, from, to time.Time, rowBytes float64) (SourceGrowth, error) {
+	growth := SourceGrowth{Source: source.Source, Label: source.Label}
+	runs, err := p.repo.GetSyncRuns(source, from, to)
+	if err != nil || len(runs) == 0 {
+		return growth, err
+	}
+	growth.Days = days(to.Sub(runs[0].Started))
+	if growth.Days <= 0 {
+		return growth, nil
+	}
+	versions, changes := 0, 0
+	for _, run := range runs {
+		if run.ToVersion > run.FromVersion {
+			versions += int(run.ToVersion - run.FromVersion)
+		}
+		changes += run.Changes
+	}
+	growth.VersionsPerDay = float64(versions) / growth.Days
+	growth.ChangesPerDay = float64(changes) / growth.Days
+	stats, err := p.repo.GetApplyStats(source)
+	if err != nil {
+		return growth, err
+	}
+	if stats.Deltas > 0 {
+		growth.DiskBytesPerDay = growth.VersionsPerDay * float64(stats.DeltaBytes) / float64(stats.Deltas)
+	}
+	growth.DBBytesPerDay = growth.ChangesPerDay * rowBytes
+	return growth, nil
+}
+
+// addSessionGrowth adds the growth from a source's new sessions, which started sessionsPerDay.
+// Each one downloads a snapshot, and the objects of the session it replaced stay in the database
+// for good when session_retention isn't set. Otherwise they're removed after the retention
+// period, so they don't add to the growth.
+func (p NRTMProcessor) addSessionGrowth(growth *SourceGrowth, source persist.NRTMSource, sessionsPerDay, rowBytes float64) error {
+	if sessionsPerDay <= 0 {
+		return nil
+	}
+	growth.SessionsPerDay = sessionsPerDay
+	stats, err := p.repo.GetApplyStats(source)
+	if err != nil {
+		return err
+	}
+	growth.DiskBytesPerDay += sessionsPerDay * float64(stats.SnapshotBytes)
+	if p.config.SessionRetention > 0 {
+		return nil
+	}
+	objects, err := p.repo.CountCurrentObjects(source)
+	if err != nil {
+		return err
+	}
+	growth.DBBytesPerDay += sessionsPerDay * float64(objects) * rowBytes
+	return nil
+}
+
+// forecast works out when the free space runs out at BytesPerDay. Full is left out when it's
+// too far off to be a time.
+func (c *CapacityForecast) forecast(now time.Time) {
+	if c.FreeBytes == nil || c.BytesPerDay <= 0 {
+		return
+	}
+	left := float64(*c.FreeBytes) / c.BytesPerDay
+	c.DaysLeft = &left
+	if left < maxForecastDays {
+		full := now.Add(time.Duration(left * float64(24*time.Hour)))
+		c.Full = &full
+	}
+}
+
+// dirSize is the total size of the files under dir
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+func days(d time.Duration) float64 {
+	return d.Hours() / 24
+}
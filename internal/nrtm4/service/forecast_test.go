@@ -0,0 +1,175 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+type forecastRepo struct {
+	persist.Repository
+	sources       []persist.NRTMSource
+	runs          map[uint64][]persist.SyncRun
+	snapshotBytes int64
+	objects       int
+}
+
+func (r forecastRepo) GetSources() ([]persist.NRTMSource, error) {
+	return r.sources, nil
+}
+
+func (r forecastRepo) GetSchemaInfo() (persist.SchemaInfo, error) {
+	return persist.SchemaInfo{Tables: []persist.TableInfo{
+		{Name: "nrtm_rpslobject_0", EstimatedRows: 500, TotalBytes: 500 << 10},
+		{Name: "nrtm_rpslobject_1", EstimatedRows: 500, TotalBytes: 500 << 10},
+		{Name: "nrtm_source", EstimatedRows: 2, TotalBytes: 24 << 10},
+	}}, nil
+}
+
+func (r forecastRepo) GetSyncRuns(source persist.NRTMSource, from, to time.Time) ([]persist.SyncRun, error) {
+	runs := []persist.SyncRun{}
+	for _, run := range r.runs[source.ID] {
+		if !run.Started.Before(from) && run.Started.Before(to) {
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+func (r forecastRepo) GetApplyStats(source persist.NRTMSource) (persist.ApplyStats, error) {
+	return persist.ApplyStats{Deltas: 10, DeltaBytes: 10 << 20, SnapshotBytes: r.snapshotBytes}, nil
+}
+
+func (r forecastRepo) CountCurrentObjects(source persist.NRTMSource) (int, error) {
+	return r.objects, nil
+}
+
+func TestForecast(t *testing.T) {
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	util.AppClock = util.NewManualClock(now)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "nrtm-delta.1.json"), make([]byte, 1000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	superseded := now.Add(-time.Hour)
+	repo := forecastRepo{
+		sources: []persist.NRTMSource{
+			{ID: 1, Source: "RIPE"},
+			{ID: 2, Source: "RIPE", Label: "old", Superseded: &superseded},
+			{ID: 3, Source: "ARIN"},
+		},
+		runs: map[uint64][]persist.SyncRun{
+			// 20 versions and 2000 changes over ten days
+			1: {
+				{Started: now.Add(-10 * 24 * time.Hour), FromVersion: 100, ToVersion: 110, Changes: 1000, Success: true},
+				{Started: now.Add(-24 * time.Hour), FromVersion: 110, ToVersion: 120, Changes: 1000, Success: true},
+				{Started: now.Add(-time.Hour), FromVersion: 120, ToVersion: 120, Success: false},
+			},
+			2: {{Started: now.Add(-48 * time.Hour), FromVersion: 1, ToVersion: 1000, Changes: 1000000}},
+			// Updated before the window
+			3: {{Started: now.Add(-60 * 24 * time.Hour), FromVersion: 1, ToVersion: 2, Changes: 5}},
+		},
+	}
+	config := AppConfig{NRTMFilePath: dir, Database: DatabaseConfig{CapacityMiB: 1}}
+	p := NRTMProcessor{repo: repo, config: config}
+	report, err := p.Forecast(0)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if report.WindowDays != 30 || len(report.Sources) != 2 {
+		t.Fatal("Expected the live sources over 30 days but was", report.WindowDays, report.Sources)
+	}
+	ripe := report.Sources[0]
+	if ripe.Days != 10 || ripe.VersionsPerDay != 2 || ripe.ChangesPerDay != 200 {
+		t.Error("Expected 2 versions and 200 changes a day but was", ripe)
+	}
+	// Deltas average 1MiB, and object rows 1KiB
+	if ripe.DiskBytesPerDay != 2<<20 || ripe.DBBytesPerDay != 200<<10 {
+		t.Error("Expected 2MiB of files and 200KiB of rows a day but was", ripe)
+	}
+	if arin := report.Sources[1]; arin.Days != 0 || arin.ChangesPerDay != 0 {
+		t.Error("Expected no growth for a source without updates in the window but was", arin)
+	}
+	if report.Disk.UsedBytes != 1000 || report.Disk.BytesPerDay != 2<<20 {
+		t.Error("Expected the downloaded files and their growth but was", report.Disk)
+	}
+	db := report.Database
+	if db.UsedBytes != 1024<<10 || db.FreeBytes == nil || *db.FreeBytes != 0 || db.DaysLeft == nil || *db.DaysLeft != 0 {
+		t.Error("Expected a full database but was", db)
+	}
+
+	p.config.Database.CapacityMiB = 2
+	p.config.SyncRunRetention = 5 * 24 * time.Hour
+	if report, err = p.Forecast(0); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	// Only the last day's update is in the window
+	if ripe = report.Sources[0]; report.WindowDays != 5 || ripe.Days != 1 || ripe.VersionsPerDay != 10 {
+		t.Error("Expected the window to be cut to the retention period but was", report.WindowDays, ripe)
+	}
+	db = report.Database
+	if db.DaysLeft == nil || *db.DaysLeft != 1.024 || db.Full == nil || !db.Full.Equal(now.Add(time.Duration(1.024*float64(24*time.Hour)))) {
+		t.Error("Expected 1MiB free at 1000KiB a day but was", db)
+	}
+	// Without a capacity the database's space isn't known
+	p.config.Database.CapacityMiB = 0
+	if report, err = p.Forecast(0); err != nil || report.Database.FreeBytes != nil || report.Database.DaysLeft != nil {
+		t.Error("Expected no database forecast without a capacity but was", report.Database, err)
+	}
+}
+
+func TestForecastSessions(t *testing.T) {
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	util.AppClock = util.NewManualClock(now)
+	recent, old := now.Add(-24*time.Hour), now.Add(-40*24*time.Hour)
+	repo := forecastRepo{
+		sources: []persist.NRTMSource{
+			{ID: 1, Source: "RIPE", Label: "test"},
+			{ID: 2, Source: "RIPE", Label: "test 1", Superseded: &recent},
+			{ID: 3, Source: "RIPE", Label: "test 2", Superseded: &recent},
+			// Before the window
+			{ID: 4, Source: "RIPE", Label: "test 3", Superseded: &old},
+			// Another label's session
+			{ID: 5, Source: "RIPE", Label: "other 1", Superseded: &recent},
+		},
+		snapshotBytes: 30 << 20,
+		objects:       3000,
+	}
+	p := NRTMProcessor{repo: repo, config: AppConfig{NRTMFilePath: t.TempDir()}}
+	report, err := p.Forecast(0)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(report.Sources) != 1 {
+		t.Fatal("Expected one live source but was", report.Sources)
+	}
+	// Two sessions in 30 days, each with a 30MiB snapshot and 3000 rows of 1KiB
+	growth := report.Sources[0]
+	if growth.SessionsPerDay != 2.0/30 || growth.DiskBytesPerDay != 2<<20 || growth.DBBytesPerDay != 200<<10 {
+		t.Error("Expected 2MiB of snapshots and 200KiB of old sessions' rows a day but was", growth)
+	}
+
+	// Old sessions are removed after the retention period
+	p.config.SessionRetention = 7 * 24 * time.Hour
+	if report, err = p.Forecast(0); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if growth = report.Sources[0]; growth.DiskBytesPerDay != 2<<20 || growth.DBBytesPerDay != 0 {
+		t.Error("Expected only the snapshots to grow with a session retention but was", growth)
+	}
+}
+
+func TestCapacityForecastFarOff(t *testing.T) {
+	free := int64(1 << 40)
+	c := CapacityForecast{FreeBytes: &free, BytesPerDay: 1}
+	c.forecast(time.Now())
+	if c.DaysLeft == nil || c.Full != nil {
+		t.Error("Expected days left but no time for a store which won't fill up but was", c)
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+var (
+	// ErrGroupNotFound there's no group with the given name in the config file
+	ErrGroupNotFound = errors.New("no group with that name in the config file")
+	// ErrSourcePaused the source has been paused, so it won't be updated
+	ErrSourcePaused = errors.New("source is paused. run resume to update it")
+)
+
+// groupMemberSeparator splits "SOURCE/label" group members. Labels can't contain it.
+const groupMemberSeparator = "/"
+
+// groupSources finds the sources in a group. Members are source names, optionally followed by
+// "/" and a label, so "RIPE" is the RIPE source with an empty label.
+func (p NRTMProcessor) groupSources(group string) ([]persist.NRTMSource, error) {
+	var members []string
+	found := false
+	for name, m := range p.config.Groups {
+		if strings.EqualFold(name, group) {
+			members, found = m, true
+			break
+		}
+	}
+	if !found {
+		return nil, ErrGroupNotFound
+	}
+	ds := NrtmDataService{Repository: p.repo}
+	sources := []persist.NRTMSource{}
+	for _, member := range members {
+		name, label, _ := strings.Cut(member, groupMemberSeparator)
+		source := ds.getSourceByNameAndLabel(name, label)
+		if source == nil {
+			logger.Warn("Group member is not a source", "group", group, "member", member)
+			continue
+		}
+		sources = append(sources, *source)
+	}
+	return sources, nil
+}
+
+// PauseSource stops a source being updated, or lets it be updated again. Resuming a source
+// also releases it from quarantine.
+func (p NRTMProcessor) PauseSource(sourceName, label string, paused bool) error {
+	if err := p.requirePrimary(); err != nil {
+		return err
+	}
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return ErrSourceNotFound
+	}
+	return p.pauseSource(*source, paused)
+}
+
+func (p NRTMProcessor) pauseSource(source persist.NRTMSource, paused bool) error {
+	if err := p.repo.PauseSource(source, paused); err != nil {
+		return err
+	}
+	if !paused && source.Quarantine != nil {
+		return p.repo.SaveQuarantine(source, nil)
+	}
+	return nil
+}
+
+// UpdateGroup updates every source in a group which isn't paused or quarantined, one at a time.
+// A failure doesn't stop the other sources being updated. There's a result for each source which
+// was updated, or tried.
+func (p NRTMProcessor) UpdateGroup(group string, catchUp CatchUpMode) ([]SyncResult, error) {
+	results := []SyncResult{}
+	sources, err := p.groupSources(group)
+	if err != nil {
+		return results, err
+	}
+	logger.Info("Updating group", "group", group, "sources", len(sources))
+	var errs []error
+	for _, outcome := range p.updateSources(sources, 1, catchUp).Results {
+		if outcome.Status == UpdateSkipped {
+			continue
+		}
+		results = append(results, outcome.SyncResult)
+		if outcome.Err != nil {
+			errs = append(errs, fmt.Errorf("%v %v: %w", outcome.Source, outcome.Label, outcome.Err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// PauseGroup pauses or resumes every source in a group
+func (p NRTMProcessor) PauseGroup(group string, paused bool) error {
+	sources, err := p.groupSources(group)
+	if err != nil {
+		return err
+	}
+	if err = p.requirePrimary(); err != nil {
+		return err
+	}
+	var errs []error
+	for _, source := range sources {
+		if err = p.pauseSource(source, paused); err != nil {
+			errs = append(errs, fmt.Errorf("%v %v: %w", source.Source, source.Label, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+type groupRepo struct {
+	persist.Repository
+	sources []persist.NRTMSource
+	paused  map[string]bool
+}
+
+func (r groupRepo) GetSources() ([]persist.NRTMSource, error) {
+	return r.sources, nil
+}
+
+func (r groupRepo) IsStandby() (bool, error) {
+	return false, nil
+}
+
+func (r groupRepo) PauseSource(source persist.NRTMSource, paused bool) error {
+	r.paused[source.Source+"/"+source.Label] = paused
+	return nil
+}
+
+func TestGroupSources(t *testing.T) {
+	repo := groupRepo{
+		sources: []persist.NRTMSource{
+			{Source: "RIPE"},
+			{Source: "RIPE", Label: "test"},
+			{Source: "ARIN", Paused: true},
+		},
+		paused: map[string]bool{},
+	}
+	config := AppConfig{Groups: map[string][]string{
+		"irr-tier1": {"RIPE", "ARIN", "APNIC"},
+		"testing":   {"ripe/test"},
+	}}
+	p := NRTMProcessor{config: config, repo: repo}
+	sources, err := p.groupSources("IRR-tier1")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(sources) != 2 || sources[0].Source != "RIPE" || sources[0].Label != "" || sources[1].Source != "ARIN" {
+		t.Error("Unexpected sources in group", sources)
+	}
+	if sources, _ = p.groupSources("testing"); len(sources) != 1 || sources[0].Label != "test" {
+		t.Error("Expected labelled source in group", sources)
+	}
+	if _, err = p.groupSources("nope"); err != ErrGroupNotFound {
+		t.Error("Expected ErrGroupNotFound but was", err)
+	}
+	if err = p.PauseGroup("irr-tier1", true); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if !repo.paused["RIPE/"] || !repo.paused["ARIN/"] || len(repo.paused) != 2 {
+		t.Error("Expected group to be paused", repo.paused)
+	}
+	if _, err = p.Update("ARIN", "", CatchUpAuto); err != ErrSourcePaused {
+		t.Error("Expected ErrSourcePaused but was", err)
+	}
+}
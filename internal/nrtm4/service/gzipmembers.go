@@ -0,0 +1,72 @@
+package service
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrGzipChecksum when a member of a gzip file doesn't match the CRC-32 or length in its trailer
+	ErrGzipChecksum = errors.New("gzip member is corrupt")
+	// ErrGzipMember when the data after a gzip member isn't another member
+	ErrGzipMember = errors.New("invalid gzip member")
+)
+
+// gzipMemberReader reads all the members of a gzip file, one after another. Some servers write
+// large snapshots as several gzip streams concatenated together. Each member's CRC-32 and length
+// are checked when its end is reached, and errors say which member was bad.
+type gzipMemberReader struct {
+	src    *bufio.Reader
+	gz     *gzip.Reader
+	member int
+}
+
+// newGzipMemberReader reads the header of the first member of r
+func newGzipMemberReader(r io.Reader) (*gzipMemberReader, error) {
+	src, ok := r.(*bufio.Reader)
+	if !ok {
+		src = bufio.NewReader(r)
+	}
+	gz, err := gzip.NewReader(src)
+	if err != nil {
+		return nil, err
+	}
+	// One member at a time, so they can be counted and named in errors
+	gz.Multistream(false)
+	return &gzipMemberReader{src: src, gz: gz, member: 1}, nil
+}
+
+func (r *gzipMemberReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.gz.Read(p)
+		if errors.Is(err, gzip.ErrChecksum) {
+			return n, fmt.Errorf("%w: member %d: %v", ErrGzipChecksum, r.member, err)
+		}
+		if err != io.EOF {
+			return n, err
+		}
+		// The member's trailer matched. Another member may follow.
+		if _, err = r.src.Peek(1); err == io.EOF {
+			logger.Debug("Read gzip file", "members", r.member)
+			return n, io.EOF
+		} else if err != nil {
+			return n, err
+		}
+		if err = r.gz.Reset(r.src); err != nil {
+			return n, fmt.Errorf("%w: member %d: %v", ErrGzipMember, r.member+1, err)
+		}
+		r.gz.Multistream(false)
+		r.member++
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Close closes the gzip reader, but not the file it reads
+func (r *gzipMemberReader) Close() error {
+	return r.gz.Close()
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gzipMembers compresses each part as its own gzip member, one after another
+func gzipMembers(t *testing.T, parts ...string) []byte {
+	var buf bytes.Buffer
+	for _, part := range parts {
+		w := gzip.NewWriter(&buf)
+		if _, err := io.WriteString(w, part); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+	return buf.Bytes()
+}
+
+func TestGzipMemberReaderReadsAllMembers(t *testing.T) {
+	r, err := newGzipMemberReader(bytes.NewReader(gzipMembers(t, "first ", "", "second ", "third")))
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	text, err := io.ReadAll(r)
+	if err != nil || string(text) != "first second third" {
+		t.Error("Expected the text of every member", string(text), err)
+	}
+	if r.member != 4 {
+		t.Error("Expected 4 members but was", r.member)
+	}
+}
+
+func TestGzipMemberReaderErrors(t *testing.T) {
+	first := gzipMembers(t, "first ")
+	second := gzipMembers(t, "second")
+	// The CRC-32 is the first four bytes of the trailer
+	second[len(second)-8] ^= 0xff
+	r, _ := newGzipMemberReader(bytes.NewReader(append(first, second...)))
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrGzipChecksum) || !strings.Contains(err.Error(), "member 2") {
+		t.Error("Expected ErrGzipChecksum for the second member but was", err)
+	}
+
+	r, _ = newGzipMemberReader(bytes.NewReader(append(first, "not gzip"...)))
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrGzipMember) {
+		t.Error("Expected ErrGzipMember for the bytes after the first member but was", err)
+	}
+}
+
+func TestMultiMemberSnapshotReader(t *testing.T) {
+	header := "\x1e{\"nrtm_version\":4,\"type\":\"snapshot\",\"source\":\"TEST\",\"session_id\":\"s\",\"version\":1}\n"
+	object := "\x1e{\"object\":\"mntner: TEST-MNT\\nsource: TEST\\n\"}\n"
+	fileName := filepath.Join(t.TempDir(), "snapshot.jsonseq.gz")
+	if err := os.WriteFile(fileName, gzipMembers(t, header+object, object, object), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records := 0
+	err = fileManager{}.readJSONSeqRecords(file, func(bytes []byte, err error) error {
+		if len(bytes) > 0 {
+			records++
+		}
+		return err
+	})
+	if err != io.EOF || records != 4 {
+		t.Error("Expected the records of every member to be read", records, err)
+	}
+}
@@ -1,12 +1,17 @@
 package service
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/mail"
+	"strings"
 
+	"github.com/petchells/nrtm4client/internal/nrtm4/faults"
 	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/retry"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
 )
 
 // HTTPResponseError is used to model an error response from a http client
@@ -22,44 +27,240 @@ func (cerr HTTPResponseError) Error() string {
 
 // Client fetches things from the NRTM server, or anywhwere, actually
 type Client interface {
-	getUpdateNotification(string) (persist.NotificationJSON, error)
+	getUpdateNotification(string) (protocol.NotificationJSON, http.Header, error)
 	getResponseBody(string) (io.Reader, error)
+	getFile(string, fileRequest) (fileResponse, error)
+}
+
+// fileRequest asks for a snapshot or delta file
+type fileRequest struct {
+	// Offset resumes a download from this byte, but only if the file still matches IfRange
+	Offset  int64
+	IfRange string
+	// Revalidate tells caches between us and the server to check their copy with the server
+	Revalidate bool
+}
+
+// fileResponse is a snapshot or delta file, or the rest of one if Partial is true
+type fileResponse struct {
+	Body    io.Reader
+	Partial bool
+	// Validator can be sent as IfRange to resume the download
+	Validator string
 }
 
 // HTTPClient implementation of Client
-type HTTPClient struct{}
+type HTTPClient struct {
+	client *http.Client
+	// contact tells server operators who runs the client, e.g. an email address
+	contact string
+	// retry retries requests which fail in a way that might not last
+	retry *retry.Retrier
+}
 
-func (cl HTTPClient) getUpdateNotification(url string) (persist.NotificationJSON, error) {
-	var file persist.NotificationJSON
-	if err := cl.getObject(url, &file); err != nil {
-		return file, err
+// NewHTTPClient returns a client which connects to servers as the network config says
+func NewHTTPClient(config NetworkConfig) HTTPClient {
+	return HTTPClient{client: config.httpClient(), contact: config.Contact}
+}
+
+// withContact returns a copy of the client which sends contact instead of its own
+func (cl HTTPClient) withContact(contact string) Client {
+	cl.contact = contact
+	return cl
+}
+
+// identify sets the User-Agent, with the contact after the client version if there is one.
+// A contact which is an email address is sent in From too.
+func (cl HTTPClient) identify(req *http.Request) {
+	userAgent := util.UserAgent()
+	if len(cl.contact) > 0 {
+		userAgent += " (" + cl.contact + ")"
+		if _, err := mail.ParseAddress(cl.contact); err == nil {
+			req.Header.Set("From", cl.contact)
+		}
 	}
-	return file, nil
+	req.Header.Set("User-Agent", userAgent)
 }
 
-func (cl HTTPClient) getResponseBody(url string) (io.Reader, error) {
-	resp, err := http.Get(url)
+func (cl HTTPClient) httpClient() *http.Client {
+	if cl.client == nil {
+		return http.DefaultClient
+	}
+	return cl.client
+}
+
+// do sends a request, and sends it again as the retry policy allows when it can't connect or
+// the server answers 429, 502, 503 or 504. Those responses are returned as an
+// HTTPResponseError. Each host has its own breaker.
+func (cl HTTPClient) do(req *http.Request) (*http.Response, error) {
+	var resp *http.Response
+	err := cl.retry.Do(req.URL.Host, isRetryableHTTPError, func(int) error {
+		var err error
+		if resp, err = cl.httpClient().Do(req.Clone(req.Context())); err != nil {
+			return err
+		}
+		if isRetryableStatus(resp.StatusCode) {
+			httpLogger.Warn("Server said to try again later", "url", req.URL, "status", resp.StatusCode, "retry_after", resp.Header.Get("Retry-After"))
+			resp.Body.Close()
+			return clientErrFromResponse(resp)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode == http.StatusOK {
-		return resp.Body, err
+	return resp, nil
+}
+
+func (cl HTTPClient) getUpdateNotification(url string) (protocol.NotificationJSON, http.Header, error) {
+	file, header, _, err := cl.getChangedNotification(url, nil)
+	return file, header, err
+}
+
+// getChangedNotification sends the ETag of the last notification file, and compares the hash of
+// the body with it, so an unchanged file is neither downloaded again nor parsed
+func (cl HTTPClient) getChangedNotification(url string, last *persist.NotificationFingerprint) (protocol.NotificationJSON, http.Header, persist.NotificationFingerprint, error) {
+	var file protocol.NotificationJSON
+	var fp persist.NotificationFingerprint
+	if err := faults.Check(faults.NotificationRequest); err != nil {
+		return file, nil, fp, err
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return file, nil, fp, err
+	}
+	cl.identify(req)
+	if last != nil && len(last.ETag) > 0 {
+		req.Header.Set("If-None-Match", last.ETag)
+	}
+	resp, err := cl.do(req)
+	if err != nil {
+		return file, nil, fp, err
+	}
+	defer resp.Body.Close()
+	httpLogger.Debug("Notification response", "url", url, "status", resp.StatusCode, "length", resp.ContentLength, "contact", cl.contact)
+	if resp.StatusCode == http.StatusNotModified && last != nil {
+		return file, resp.Header, *last, errNotificationUnchanged
+	}
+	if resp.StatusCode != http.StatusOK {
+		httpLogger.Warn("HTTPClient getUpdateNotification received bad response", "status", resp.StatusCode, "message", resp.Status)
+		return file, resp.Header, fp, clientErrFromResponse(resp)
+	}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return file, resp.Header, fp, err
+	}
+	fp = newNotificationFingerprint(raw, resp.Header)
+	if last != nil && fp.Hash == last.Hash {
+		return file, resp.Header, fp, errNotificationUnchanged
 	}
-	logger.Warn("HTTPClient getResponseBody received bad response", "status", resp.StatusCode, "message", resp.Status)
-	return nil, clientErrFromResponse(resp)
+	file, rev, err := protocol.DecodeNotification(raw)
+	if err != nil {
+		return file, resp.Header, fp, err
+	}
+	httpLogger.Debug("Notification spec revision", "url", url, "revision", rev.Name)
+	return file, resp.Header, fp, nil
 }
 
-func (cl HTTPClient) getObject(url string, obj any) error {
-	var resp *http.Response
-	var err error
-	if resp, err = http.Get(url); err != nil {
-		return err
+func (cl HTTPClient) getResponseBody(url string) (io.Reader, error) {
+	resp, err := cl.getFile(url, fileRequest{})
+	return resp.Body, err
+}
+
+// getFile sends no Cache-Control of its own unless asked to revalidate, so a shared caching
+// proxy can answer from its copy according to the directives the server sent.
+func (cl HTTPClient) getFile(url string, fr fileRequest) (fileResponse, error) {
+	var file fileResponse
+	if err := faults.Check(faults.FileRequest); err != nil {
+		return file, err
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return file, err
+	}
+	cl.identify(req)
+	if fr.Offset > 0 && len(fr.IfRange) > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", fr.Offset))
+		req.Header.Set("If-Range", fr.IfRange)
+	}
+	if fr.Revalidate {
+		req.Header.Set("Cache-Control", "no-cache")
+		req.Header.Set("Pragma", "no-cache")
+	}
+	resp, err := cl.do(req)
+	if err != nil {
+		return file, err
+	}
+	httpLogger.Debug("File response", "url", url, "status", resp.StatusCode, "length", resp.ContentLength, "range", req.Header.Get("Range"), "contact", cl.contact)
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		if age := resp.Header.Get("Age"); len(age) > 0 {
+			httpLogger.Debug("Response came from a cache", "url", url, "age", age)
+		}
+		file.Partial = resp.StatusCode == http.StatusPartialContent
+		file.Validator = rangeValidator(resp.Header)
+		if file.Partial {
+			if err = checkResumed(resp.Header, fr); err != nil {
+				resp.Body.Close()
+				return fileResponse{}, err
+			}
+		}
+		file.Body = &lengthCheckingReader{body: faults.Body(faults.FileBody, resp.Body), expected: resp.ContentLength}
+		return file, nil
+	}
+	httpLogger.Warn("HTTPClient getFile received bad response", "status", resp.StatusCode, "message", resp.Status)
+	resp.Body.Close()
+	return file, clientErrFromResponse(resp)
+}
+
+// checkResumed makes sure a 206 is the rest of the file that was asked for: it starts at the
+// offset, and it's the same version of the file as the part we have
+func checkResumed(header http.Header, fr fileRequest) error {
+	var start, end int64
+	if _, err := fmt.Sscanf(header.Get("Content-Range"), "bytes %d-%d/", &start, &end); err != nil || start != fr.Offset {
+		return fmt.Errorf("%w: asked for bytes from %d, but was sent %q", ErrBadResume, fr.Offset, header.Get("Content-Range"))
+	}
+	if validator := rangeValidator(header); len(validator) > 0 && validator != fr.IfRange {
+		return fmt.Errorf("%w: the file changed from %v to %v", ErrBadResume, fr.IfRange, validator)
+	}
+	return nil
+}
+
+// rangeValidator is the ETag, or Last-Modified if there isn't one. A weak ETag can't be used
+// in If-Range.
+func rangeValidator(header http.Header) string {
+	if etag := header.Get("ETag"); len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+// lengthCheckingReader returns ErrTruncatedDownload if the body ends before Content-Length
+// bytes were read. A negative `expected` means the server didn't say. The body is closed
+// when it has been read, and later reads return the same error.
+type lengthCheckingReader struct {
+	body     io.ReadCloser
+	expected int64
+	received int64
+	err      error
+}
+
+func (r *lengthCheckingReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.body.Read(p)
+	r.received += int64(n)
+	if err == nil {
+		return n, nil
 	}
-	if resp.StatusCode == http.StatusOK {
-		return json.NewDecoder(resp.Body).Decode(&obj)
+	r.body.Close()
+	if err == io.ErrUnexpectedEOF || (err == io.EOF && r.expected >= 0 && r.received < r.expected) {
+		httpLogger.Warn("Download was truncated", "expected", r.expected, "received", r.received)
+		err = ErrTruncatedDownload
 	}
-	logger.Warn("HTTPClient getResponseBody received bad response", "status", resp.StatusCode, "message", resp.Status)
-	return clientErrFromResponse(resp)
+	r.err = err
+	return n, err
 }
 
 func clientErrFromResponse(resp *http.Response) HTTPResponseError {
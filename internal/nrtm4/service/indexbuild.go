@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+const (
+	defaultIndexBatchSize = 5000
+	defaultIndexInterval  = time.Minute
+)
+
+// IndexConfig lists the optional indexes nrtm4serve builds in the background, and how
+type IndexConfig struct {
+	// Build lists the indexes: fulltext, prefix and attributes
+	Build []string `json:"build"`
+	// BatchSize is how many objects are indexed in each transaction
+	BatchSize int `json:"batch_size"`
+	// Interval is how often the database is checked for idle time, e.g. "30s"
+	Interval string `json:"interval"`
+}
+
+func (c IndexConfig) validate() error {
+	for _, name := range c.Build {
+		if indexValues(name) == nil {
+			return fmt.Errorf("%w: %v", persist.ErrUnknownIndex, name)
+		}
+	}
+	if len(c.Interval) > 0 {
+		if _, err := time.ParseDuration(c.Interval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c IndexConfig) batchSize() int {
+	if c.BatchSize > 0 {
+		return c.BatchSize
+	}
+	return defaultIndexBatchSize
+}
+
+func (c IndexConfig) interval() time.Duration {
+	if d, err := time.ParseDuration(c.Interval); err == nil && d > 0 {
+		return d
+	}
+	return defaultIndexInterval
+}
+
+// BuildIndexes builds the optional indexes in the config while the database is idle, a batch
+// of objects at a time, so it never holds up a sync for long. Once an index has caught up it
+// goes on indexing new objects. It returns when ctx is done.
+func (p NRTMProcessor) BuildIndexes(ctx context.Context) {
+	if len(p.config.Indexes.Build) == 0 {
+		return
+	}
+	logger.Info("Building optional indexes in idle time", "indexes", strings.Join(p.config.Indexes.Build, ", "))
+	for {
+		p.buildIndexBatches(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-util.AppClock.After(p.config.Indexes.interval()):
+		}
+	}
+}
+
+// buildIndexBatches indexes batches of objects until every index has caught up, another
+// session uses the database, or ctx is done
+func (p NRTMProcessor) buildIndexBatches(ctx context.Context) {
+	if err := p.requirePrimary(); err != nil {
+		return
+	}
+	batchSize := p.config.Indexes.batchSize()
+	pending := slices.Clone(p.config.Indexes.Build)
+	for len(pending) > 0 && ctx.Err() == nil {
+		idle, err := p.repo.IsIdle()
+		if err != nil {
+			logger.Warn("Cannot tell if the database is idle", "error", err)
+			return
+		}
+		if !idle {
+			return
+		}
+		var unfinished []string
+		for _, name := range pending {
+			n, err := p.repo.BuildIndexBatch(name, batchSize, indexValues(name))
+			if err != nil {
+				logger.Error("Failed to build index", "index", name, "error", err)
+				continue
+			}
+			if n == batchSize {
+				unfinished = append(unfinished, name)
+			}
+		}
+		pending = unfinished
+	}
+}
+
+// IndexProgress returns how far each optional index in the config, or which has been started
+// before, has been built
+func (p NRTMProcessor) IndexProgress() ([]persist.IndexProgress, error) {
+	progress, err := p.repo.GetIndexProgress()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range p.config.Indexes.Build {
+		if !slices.ContainsFunc(progress, func(ip persist.IndexProgress) bool { return ip.Name == name }) {
+			progress = append(progress, persist.IndexProgress{Name: name})
+		}
+	}
+	slices.SortFunc(progress, func(a, b persist.IndexProgress) int { return strings.Compare(a.Name, b.Name) })
+	return progress, nil
+}
+
+// indexValues returns the function which gives the values an optional index stores for an
+// object, or nil if there's no index with that name
+func indexValues(name string) func(rpsl.Rpsl) []string {
+	switch name {
+	case persist.FullTextIndex:
+		return func(obj rpsl.Rpsl) []string { return []string{obj.Payload} }
+	case persist.PrefixIndex:
+		return func(obj rpsl.Rpsl) []string {
+			prefixes := []string{}
+			for _, prefix := range objectPrefixes(obj) {
+				prefixes = append(prefixes, prefix.String())
+			}
+			return prefixes
+		}
+	case persist.AttributeIndex:
+		return func(obj rpsl.Rpsl) []string {
+			attrs := map[string][]string{}
+			for _, attr := range rpsl.Attributes(obj.Payload) {
+				attrs[attr.Name] = append(attrs[attr.Name], attr.Value)
+			}
+			bytes, err := json.Marshal(attrs)
+			if err != nil {
+				return nil
+			}
+			return []string{string(bytes)}
+		}
+	}
+	return nil
+}
+
+// objectPrefixes returns the prefixes an inetnum, inet6num, route or route6 object covers. The
+// range of an inetnum is split into the fewest prefixes which cover it exactly.
+func objectPrefixes(obj rpsl.Rpsl) []netip.Prefix {
+	objectType := strings.ToLower(obj.ObjectType)
+	value := ""
+	for _, attr := range rpsl.Attributes(obj.Payload) {
+		if attr.Name == objectType {
+			value = attr.Value
+			break
+		}
+	}
+	switch objectType {
+	case "inetnum":
+		lo, hi, found := strings.Cut(value, "-")
+		first, err1 := netip.ParseAddr(strings.TrimSpace(lo))
+		last, err2 := netip.ParseAddr(strings.TrimSpace(hi))
+		if !found || err1 != nil || err2 != nil {
+			return nil
+		}
+		return rangePrefixes(first, last)
+	case "inet6num", "route", "route6":
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil
+		}
+		return []netip.Prefix{prefix.Masked()}
+	}
+	return nil
+}
+
+// rangePrefixes splits the range of addresses from first to last into prefixes
+func rangePrefixes(first, last netip.Addr) []netip.Prefix {
+	if first.BitLen() != last.BitLen() || last.Less(first) {
+		return nil
+	}
+	prefixes := []netip.Prefix{}
+	for {
+		// the shortest prefix which starts at first and doesn't go past last
+		bits := first.BitLen()
+		for bits > 0 {
+			wider := netip.PrefixFrom(first, bits-1).Masked()
+			if wider.Addr() != first || last.Less(lastAddr(wider)) {
+				break
+			}
+			bits--
+		}
+		prefix := netip.PrefixFrom(first, bits)
+		prefixes = append(prefixes, prefix)
+		end := lastAddr(prefix)
+		if end == last {
+			return prefixes
+		}
+		first = end.Next()
+	}
+}
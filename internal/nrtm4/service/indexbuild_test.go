@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+type indexBuildRepo struct {
+	persist.Repository
+	// remaining is how many objects each index has left to build
+	remaining map[string]int
+	// idleChecks is how many times the database is idle before it's busy
+	idleChecks int
+	batches    []string
+}
+
+func (r *indexBuildRepo) IsStandby() (bool, error) {
+	return false, nil
+}
+
+func (r *indexBuildRepo) IsIdle() (bool, error) {
+	r.idleChecks--
+	return r.idleChecks >= 0, nil
+}
+
+func (r *indexBuildRepo) BuildIndexBatch(name string, batchSize int, values func(rpsl.Rpsl) []string) (int, error) {
+	r.batches = append(r.batches, name)
+	n := min(batchSize, r.remaining[name])
+	r.remaining[name] -= n
+	return n, nil
+}
+
+func TestBuildIndexBatches(t *testing.T) {
+	repo := &indexBuildRepo{
+		remaining:  map[string]int{persist.FullTextIndex: 25, persist.PrefixIndex: 5},
+		idleChecks: 10,
+	}
+	p := NRTMProcessor{repo: repo}
+	p.config.Indexes = IndexConfig{Build: []string{persist.FullTextIndex, persist.PrefixIndex}, BatchSize: 10}
+	p.buildIndexBatches(context.Background())
+	expected := []string{"fulltext", "prefix", "fulltext", "fulltext"}
+	if !slices.Equal(repo.batches, expected) {
+		t.Error("Expected batches", expected, "but was", repo.batches)
+	}
+
+	repo = &indexBuildRepo{remaining: map[string]int{persist.FullTextIndex: 25}, idleChecks: 1}
+	p.repo = repo
+	p.config.Indexes.Build = []string{persist.FullTextIndex}
+	p.buildIndexBatches(context.Background())
+	if len(repo.batches) != 1 || repo.remaining[persist.FullTextIndex] != 15 {
+		t.Error("Expected the build to stop when the database is busy but was", repo.batches)
+	}
+}
+
+func TestIndexConfigValidate(t *testing.T) {
+	if err := (IndexConfig{Build: []string{"fulltext", "prefix", "attributes"}, Interval: "30s"}).validate(); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	if err := (IndexConfig{Build: []string{"trigram"}}).validate(); !errors.Is(err, persist.ErrUnknownIndex) {
+		t.Error("Expected ErrUnknownIndex but was", err)
+	}
+	if err := (IndexConfig{Interval: "often"}).validate(); err == nil {
+		t.Error("Expected an error for an invalid interval")
+	}
+}
+
+func TestRangePrefixes(t *testing.T) {
+	type expectation struct {
+		first, last string
+		expected    []string
+	}
+	expectations := []expectation{
+		{"192.0.2.0", "192.0.2.255", []string{"192.0.2.0/24"}},
+		{"192.0.2.0", "192.0.3.127", []string{"192.0.2.0/24", "192.0.3.0/25"}},
+		{"10.0.0.1", "10.0.0.6", []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32"}},
+		{"0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}},
+		{"192.0.2.9", "192.0.2.9", []string{"192.0.2.9/32"}},
+		{"192.0.2.9", "192.0.2.1", nil},
+		{"192.0.2.0", "2001:db8::", nil},
+	}
+	for _, exp := range expectations {
+		actual := []string{}
+		for _, prefix := range rangePrefixes(netip.MustParseAddr(exp.first), netip.MustParseAddr(exp.last)) {
+			actual = append(actual, prefix.String())
+		}
+		if exp.expected == nil && len(actual) != 0 || exp.expected != nil && !slices.Equal(actual, exp.expected) {
+			t.Error("Expected", exp.expected, "for", exp.first, "-", exp.last, "but was", actual)
+		}
+	}
+}
+
+func TestIndexValues(t *testing.T) {
+	route6 := rpsl.Rpsl{ObjectType: "ROUTE6", Payload: "route6: 2001:db8:1::/48\norigin: AS65000\nmnt-by: EXAMPLE-MNT\nmnt-by: OTHER-MNT\nsource: TEST\n"}
+	if values := indexValues(persist.PrefixIndex)(route6); !slices.Equal(values, []string{"2001:db8:1::/48"}) {
+		t.Error("Unexpected prefixes", values)
+	}
+	inetnum := rpsl.Rpsl{ObjectType: "INETNUM", Payload: "inetnum: 192.0.2.0 - 192.0.3.255\nsource: TEST\n"}
+	if values := indexValues(persist.PrefixIndex)(inetnum); !slices.Equal(values, []string{"192.0.2.0/23"}) {
+		t.Error("Unexpected prefixes", values)
+	}
+	mntner := rpsl.Rpsl{ObjectType: "MNTNER", Payload: "mntner: EXAMPLE-MNT\nsource: TEST\n"}
+	if values := indexValues(persist.PrefixIndex)(mntner); len(values) != 0 {
+		t.Error("Expected no prefixes for a mntner but was", values)
+	}
+	expected := `{"mnt-by":["EXAMPLE-MNT","OTHER-MNT"],"origin":["AS65000"],"route6":["2001:db8:1::/48"],"source":["TEST"]}`
+	if values := indexValues(persist.AttributeIndex)(route6); !slices.Equal(values, []string{expected}) {
+		t.Error("Expected", expected, "but was", values)
+	}
+	if indexValues("trigram") != nil {
+		t.Error("Expected no values function for an unknown index")
+	}
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+var (
+	// ErrSyncStopped the sync was asked to stop, and did once the delta it was applying had
+	// been applied
+	ErrSyncStopped = errors.New("sync stopped after the last complete delta")
+	// ErrSyncAborted the sync was asked to abort, and the changes it had saved from a delta
+	// which wasn't completely applied were rolled back
+	ErrSyncAborted = errors.New("sync aborted, the incomplete delta was rolled back")
+)
+
+// Interrupt asks the syncs a processor is running to end early, e.g. when the process gets a
+// signal during a long catch-up. A stop lets the delta being applied finish, so the source is
+// left at the last version readers see, and an abort stops straight away and rolls back what
+// was saved from the delta. Either way the next update carries on from the applied version,
+// and a sync which hasn't started yet doesn't start.
+type Interrupt struct {
+	stop  atomic.Bool
+	abort atomic.Bool
+}
+
+// Stop asks syncs to stop once the delta they're applying has been applied
+func (i *Interrupt) Stop() {
+	i.stop.Store(true)
+}
+
+// Abort asks syncs to stop straight away and roll back the delta they're applying
+func (i *Interrupt) Abort() {
+	i.abort.Store(true)
+}
+
+// Clear withdraws a stop or abort, so an embedder can start syncing again
+func (i *Interrupt) Clear() {
+	i.stop.Store(false)
+	i.abort.Store(false)
+}
+
+// stopping is true when syncs should stop before starting another delta
+func (i *Interrupt) stopping() bool {
+	return i != nil && (i.stop.Load() || i.abort.Load())
+}
+
+// aborting is true when syncs should stop before applying another change
+func (i *Interrupt) aborting() bool {
+	return i != nil && i.abort.Load()
+}
+
+// Interrupt is what stops or aborts the processor's syncs. It's shared by copies of the
+// processor, so stopping it stops every sync they're running.
+func (p NRTMProcessor) Interrupt() *Interrupt {
+	return p.interrupt
+}
+
+// rollBackDelta throws away the changes saved from the delta which was being applied when a
+// sync aborted. Readers never saw them, since the applied version wasn't moved on.
+func (p NRTMProcessor) rollBackDelta(source persist.NRTMSource, version uint32) error {
+	rows, err := p.repo.DiscardUnappliedChanges(source)
+	if err != nil {
+		logger.Error("Failed to roll back the aborted delta", "source", source.Source, "version", version, "error", err)
+		return fmt.Errorf("sync aborted, but rolling back delta %d failed: %w", version, err)
+	}
+	logger.Warn("Sync aborted", "source", source.Source, "label", source.Label, "version", version, "rows", rows)
+	return ErrSyncAborted
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+// interruptRepo calls onAdd after each object it saves and onApplied after each version
+// which is made visible
+type interruptRepo struct {
+	pendingDeltasRepo
+	onAdd       func(string)
+	onApplied   func(uint32)
+	discarded   *int
+	discardFail error
+}
+
+func (r interruptRepo) AddModifyObject(source persist.NRTMSource, obj rpsl.Rpsl, file protocol.NrtmFileJSON) error {
+	r.pendingDeltasRepo.AddModifyObject(source, obj, file)
+	r.onAdd(obj.PrimaryKey)
+	return nil
+}
+
+func (r interruptRepo) SetAppliedVersion(source persist.NRTMSource, version uint32) error {
+	r.pendingDeltasRepo.SetAppliedVersion(source, version)
+	r.onApplied(version)
+	return nil
+}
+
+func (r interruptRepo) DiscardUnappliedChanges(source persist.NRTMSource) (int64, error) {
+	*r.discarded++
+	return 1, r.discardFail
+}
+
+// deltaFilesClient serves the files in a map of URLs to their contents
+type deltaFilesClient struct {
+	files map[string]string
+}
+
+func (c deltaFilesClient) getUpdateNotification(string) (protocol.NotificationJSON, http.Header, error) {
+	return protocol.NotificationJSON{}, nil, errors.New("notification should not be fetched")
+}
+
+func (c deltaFilesClient) getResponseBody(url string) (io.Reader, error) {
+	body, ok := c.files[url]
+	if !ok {
+		return nil, fmt.Errorf("no file at %v", url)
+	}
+	return strings.NewReader(body), nil
+}
+
+func (c deltaFilesClient) getFile(url string, _ fileRequest) (fileResponse, error) {
+	reader, err := c.getResponseBody(url)
+	return fileResponse{Body: reader}, err
+}
+
+func TestInterruptSync(t *testing.T) {
+	sessionID := "ca128382-78d9-41d1-8927-1ecef15275be"
+	files := map[string]string{}
+	refs := []protocol.FileRefJSON{}
+	for version := uint32(3); version <= 4; version++ {
+		delta := "\x1e" + fmt.Sprintf(`{"nrtm_version": 4, "type": "delta", "source": "EXAMPLE", "session_id": "%v", "version": %d}`, sessionID, version)
+		for _, origin := range []string{"AS65530", "AS65531"} {
+			delta += "\n\x1e" + fmt.Sprintf(`{"action": "add_modify", "object": "route: 192.0.%d.0/24\norigin: %v\nsource: EXAMPLE\n"}`, version, origin)
+		}
+		delta += "\n"
+		sum := sha256.Sum256([]byte(delta))
+		url := fmt.Sprintf("https://example.com/nrtm/delta.%d.json", version)
+		files[url] = delta
+		refs = append(refs, protocol.FileRefJSON{Version: version, URL: url, Hash: hex.EncodeToString(sum[:])})
+	}
+	notification := protocol.NotificationJSON{NrtmFileJSON: protocol.NrtmFileJSON{Version: 4, SessionID: sessionID, Source: "EXAMPLE"}, DeltaRefs: refs}
+	source := persist.NRTMSource{ID: 1, Source: "EXAMPLE", SessionID: sessionID, Version: 2, NotificationURL: "https://example.com/nrtm/update-notification-file.json"}
+
+	run := func(onAdd func(*Interrupt, string), onApplied func(*Interrupt, uint32), discardFail error) ([]string, int, error) {
+		saved := [][]protocol.FileRefJSON{}
+		applied := []string{}
+		discarded := 0
+		interrupt := new(Interrupt)
+		repo := interruptRepo{
+			pendingDeltasRepo: pendingDeltasRepo{notification: notification, saved: &saved, applied: &applied},
+			onAdd:             func(pk string) { onAdd(interrupt, pk) },
+			onApplied:         func(version uint32) { onApplied(interrupt, version) },
+			discarded:         &discarded,
+			discardFail:       discardFail,
+		}
+		p := NRTMProcessor{
+			repo:      repo,
+			config:    AppConfig{NRTMFilePath: t.TempDir()},
+			client:    deltaFilesClient{files},
+			warnings:  &syncWarnings{},
+			interrupt: interrupt,
+		}
+		err := applyDeltas(p, notification, source, refs)
+		return applied, discarded, err
+	}
+	ignoreAdd := func(*Interrupt, string) {}
+	ignoreApplied := func(*Interrupt, uint32) {}
+
+	applied, discarded, err := run(ignoreAdd, ignoreApplied, nil)
+	if err != nil || len(applied) != 6 || discarded != 0 {
+		t.Fatal("Expected both deltas to be applied but was", applied, discarded, err)
+	}
+
+	// A stop during the first delta lets it finish
+	applied, discarded, err = run(func(i *Interrupt, pk string) { i.Stop() }, ignoreApplied, nil)
+	if !errors.Is(err, ErrSyncStopped) {
+		t.Error("Expected ErrSyncStopped but was", err)
+	}
+	expected := []string{"192.0.3.0/24AS65530", "192.0.3.0/24AS65531", "applied version 3"}
+	if !slices.Equal(applied, expected) || discarded != 0 {
+		t.Error("Expected", expected, "but was", applied, discarded)
+	}
+
+	// An abort during the second delta rolls it back, and the first stays applied
+	abortIn4 := func(i *Interrupt, pk string) {
+		if strings.HasPrefix(pk, "192.0.4.0") {
+			i.Abort()
+		}
+	}
+	applied, discarded, err = run(abortIn4, ignoreApplied, nil)
+	if !errors.Is(err, ErrSyncAborted) {
+		t.Error("Expected ErrSyncAborted but was", err)
+	}
+	expected = []string{"192.0.3.0/24AS65530", "192.0.3.0/24AS65531", "applied version 3", "192.0.4.0/24AS65530"}
+	if !slices.Equal(applied, expected) || discarded != 1 {
+		t.Error("Expected", expected, "and a roll back but was", applied, discarded)
+	}
+	if isProtocolError(err) {
+		t.Error("An aborted sync mustn't quarantine the source")
+	}
+
+	// An abort between deltas has nothing to roll back
+	applied, discarded, err = run(ignoreAdd, func(i *Interrupt, version uint32) { i.Abort() }, nil)
+	if !errors.Is(err, ErrSyncStopped) || len(applied) != 3 || discarded != 0 {
+		t.Error("Expected a stop after the first delta but was", applied, discarded, err)
+	}
+
+	// A failed roll back is reported
+	rollBackErr := errors.New("connection lost")
+	_, _, err = run(abortIn4, ignoreApplied, rollBackErr)
+	if !errors.Is(err, rollBackErr) || errors.Is(err, ErrSyncAborted) {
+		t.Error("Expected the roll back error but was", err)
+	}
+}
+
+func TestInterruptClear(t *testing.T) {
+	var nilInterrupt *Interrupt
+	if nilInterrupt.stopping() || nilInterrupt.aborting() {
+		t.Error("Expected a processor without an interrupt to never stop")
+	}
+	i := new(Interrupt)
+	i.Abort()
+	if !i.stopping() || !i.aborting() {
+		t.Error("Expected an abort to stop too")
+	}
+	i.Clear()
+	if i.stopping() || i.aborting() {
+		t.Error("Expected Clear to withdraw the abort")
+	}
+}
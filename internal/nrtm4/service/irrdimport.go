@@ -0,0 +1,236 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+// ErrIRRdImportVersion the version an IRRd export was taken at can't be continued with the
+// server's deltas
+var ErrIRRdImportVersion = errors.New("export version can't be continued with the server's deltas")
+
+// IRRdImportReport counts what was done with the objects in an IRRd export
+type IRRdImportReport struct {
+	Sync SyncResult `json:"sync"`
+	// Imported objects were saved as the source's objects at the export's version
+	Imported int `json:"imported"`
+	// Filtered objects were left out by the source's filter or sample
+	Filtered int `json:"filtered"`
+	// OtherSources are objects of another source, which IRRd exports of several sources have
+	OtherSources int `json:"other_sources"`
+	// Invalid objects couldn't be parsed
+	Invalid int `json:"invalid"`
+}
+
+// ImportIRRd seeds a new source from an IRRd4 export, then applies the server's deltas, so an
+// operator moving from IRRd mirroring keeps the data it already has instead of loading the
+// server's snapshot. The export is RPSL text, as irrd_export or an IRRd FTP dump writes it, and
+// may be gzipped. version is the NRTMv4 version of the server's session the export was taken
+// at: an IRRd export only has an NRTMv3 serial, so it has to be given.
+func (p NRTMProcessor) ImportIRRd(exportPath, notificationURL, label string, version uint32) (IRRdImportReport, error) {
+	notificationURL, err := p.expandURL(notificationURL, nil)
+	if err != nil {
+		return IRRdImportReport{Sync: SyncResult{Label: strings.TrimSpace(label), Warnings: []Warning{}}}, err
+	}
+	p.warnings = &syncWarnings{}
+	p.runID = newRunID()
+	p.scriptRan = new(atomic.Bool)
+	p.progress = p.board.begin("import-irrd", notificationURL, label, p.runID)
+	report := IRRdImportReport{Sync: SyncResult{Label: strings.TrimSpace(label)}}
+	err = p.importIRRd(exportPath, notificationURL, label, version, &report)
+	if err == nil {
+		p.postSync(report.Sync.Source, report.Sync.Label, 0, report.Sync.ToVersion)
+	}
+	p.progress.finish(err)
+	report.Sync.Warnings = p.warnings.all()
+	return report, err
+}
+
+func (p NRTMProcessor) importIRRd(exportPath, notificationURL, label string, version uint32, report *IRRdImportReport) error {
+	label, err := p.checkNewSource(notificationURL, label)
+	if err != nil {
+		return err
+	}
+	export, err := os.Open(exportPath)
+	if err != nil {
+		return err
+	}
+	defer export.Close()
+	log := logger.With("url", notificationURL)
+	fm := fileManager{client: p.client, warnings: p.warnings, strictness: p.config.strictness}
+	notification, header, err := fm.downloadNotificationFile(notificationURL)
+	if err != nil {
+		return err
+	}
+	if notification, err = p.verifyNotification(notification.Source, notificationURL, notification); err != nil {
+		return err
+	}
+	p.client = p.sourceClient(notification.Source)
+	p.progress.source(notification.Source)
+	if err = p.checkNotificationTimestamp(notification.Source, notification, header); err != nil {
+		return err
+	}
+	if err = p.checkNotificationExpiry(notification); err != nil {
+		return err
+	}
+	if err = checkImportVersion(notification, version); err != nil {
+		return err
+	}
+
+	report.Sync.Source = notification.Source
+	report.Sync.FromVersion = version
+	report.Sync.ToVersion = version
+	source := persist.NewNRTMSource(notification, label, notificationURL)
+	source.Version = version
+	source.TermsURL = p.sourceTermsURL(notification.Source, notificationURL, header)
+	ds := NrtmDataService{Repository: p.repo}
+	if source, err = ds.saveNewSource(source, notification); err != nil {
+		log.Error("There was a problem saving the source. Remove it and import again", "error", err)
+		return err
+	}
+	p.recordSession(source, notification)
+	log.Info("Importing IRRd export", "source", source.Source, "file", exportPath, "version", version)
+	p.progress.stage(StageSnapshot, exportPath, version, 0, 1)
+	if err = p.saveIRRdExport(export, source, version, report); err != nil {
+		log.Error("Cannot import the IRRd export. Remove the source and import again", "error", err)
+		return err
+	}
+	log.Info("Imported IRRd export", "source", source.Source, "imported", report.Imported, "filtered", report.Filtered,
+		"other_sources", report.OtherSources, "invalid", report.Invalid)
+	if err = p.setAppliedVersion(source, version, version == notification.Version); err != nil {
+		return err
+	}
+	if p.config.analyzeAfter() > 0 {
+		p.analyzeObjects(source.Source, "import", 0)
+	}
+	if version == notification.Version {
+		return nil
+	}
+	if err = syncDeltas(p, notification, source); err != nil {
+		return err
+	}
+	report.Sync.ToVersion = notification.Version
+	return nil
+}
+
+// checkImportVersion makes sure the server's deltas carry on from the export's version
+func checkImportVersion(notification protocol.NotificationJSON, version uint32) error {
+	if version > notification.Version {
+		return fmt.Errorf("%w: version %d is after the server's version %d", ErrIRRdImportVersion, version, notification.Version)
+	}
+	if version == notification.Version {
+		return nil
+	}
+	oldest := notification.Version
+	for _, ref := range notification.DeltaRefs {
+		oldest = min(oldest, ref.Version)
+	}
+	if version+1 < oldest {
+		return fmt.Errorf("%w: the server's oldest delta is %d, so the export must be at version %d or later", ErrIRRdImportVersion, oldest, oldest-1)
+	}
+	return nil
+}
+
+// saveIRRdExport saves the source's objects in an export as its objects at version. Objects of
+// other sources are left out, and ones which can't be parsed with the source's quirks are
+// skipped with a warning.
+func (p NRTMProcessor) saveIRRdExport(export io.Reader, source persist.NRTMSource, version uint32, report *IRRdImportReport) error {
+	header := protocol.NrtmFileJSON{
+		NrtmVersion: 4,
+		Type:        persist.SnapshotFile.String(),
+		Source:      source.Source,
+		SessionID:   source.SessionID,
+		Version:     version,
+	}
+	filter := p.sourceFilter(source)
+	objectQuirks := p.config.sourceQuirks(source.Source)
+	batch := make([]rpsl.Rpsl, 0, rpslInsertBatchSize)
+	save := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := p.repo.SaveSnapshotObjects(source, batch, header)
+		batch = batch[:0]
+		return err
+	}
+	err := readRPSLExport(export, func(text string) error {
+		obj, err := objectQuirks.Parse(text)
+		if err != nil {
+			report.Invalid++
+			firstLine, _, _ := strings.Cut(text, "\n")
+			p.warnings.add(WarningSkippedRecord, version, "export object %q can't be parsed: %v", firstLine, err)
+			return nil
+		}
+		if !strings.EqualFold(obj.Source, source.Source) {
+			report.OtherSources++
+			return nil
+		}
+		if !filter.keeps(obj) {
+			report.Filtered++
+			return nil
+		}
+		report.Imported++
+		if batch = append(batch, obj); len(batch) < rpslInsertBatchSize {
+			return nil
+		}
+		return save()
+	})
+	if err != nil {
+		return err
+	}
+	return save()
+}
+
+// readRPSLExport passes each object in an RPSL text export to fn. Objects are separated by blank
+// lines, and comment lines between them, starting with # or %, are left out. The export may be
+// gzipped.
+func readRPSLExport(r io.Reader, fn func(string) error) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := newGzipMemberReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+	var object strings.Builder
+	flush := func() error {
+		if object.Len() == 0 {
+			return nil
+		}
+		text := object.String()
+		object.Reset()
+		return fn(text)
+	}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		trimmed := strings.TrimRight(line, "\r\n")
+		switch {
+		case len(strings.TrimSpace(trimmed)) == 0:
+			if ferr := flush(); ferr != nil {
+				return ferr
+			}
+		case object.Len() == 0 && (strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "%")):
+		default:
+			object.WriteString(trimmed)
+			object.WriteString("\n")
+		}
+		if err == io.EOF {
+			return flush()
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+const irrdExport = `# IRRd export of RIPE
+% generated by irrd_export
+
+mntner:         EXAMPLE-MNT
+source:         RIPE
+
+route:          192.0.2.0/24
+origin:         AS64500
+source:         RIPE
+
+
+aut-num:        AS64500
+source:         RIPE
+`
+
+func TestReadRPSLExport(t *testing.T) {
+	var zipped bytes.Buffer
+	gz := gzip.NewWriter(&zipped)
+	gz.Write([]byte(irrdExport))
+	gz.Close()
+	for name, export := range map[string][]byte{
+		"plain":   []byte(irrdExport),
+		"gzipped": zipped.Bytes(),
+		"crlf":    []byte(strings.ReplaceAll(irrdExport, "\n", "\r\n")),
+	} {
+		var objects []string
+		err := readRPSLExport(bytes.NewReader(export), func(text string) error {
+			objects = append(objects, text)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(name, "Unexpected error", err)
+		}
+		if len(objects) != 3 {
+			t.Fatal(name, "Expected 3 objects but was", len(objects), objects)
+		}
+		if objects[1] != "route:          192.0.2.0/24\norigin:         AS64500\nsource:         RIPE\n" {
+			t.Error(name, "Unexpected object", objects[1])
+		}
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err := readRPSLExport(strings.NewReader(irrdExport), func(string) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Error("Expected the callback's error to stop reading but was", err, calls)
+	}
+}
+
+func TestCheckImportVersion(t *testing.T) {
+	notification := protocol.NotificationJSON{
+		NrtmFileJSON: protocol.NrtmFileJSON{Version: 10},
+		DeltaRefs:    []protocol.FileRefJSON{{Version: 8}, {Version: 9}, {Version: 10}},
+	}
+	for version, ok := range map[uint32]bool{6: false, 7: true, 9: true, 10: true, 11: false} {
+		err := checkImportVersion(notification, version)
+		if ok && err != nil {
+			t.Error("Expected version", version, "to be continued but was", err)
+		}
+		if !ok && !errors.Is(err, ErrIRRdImportVersion) {
+			t.Error("Expected ErrIRRdImportVersion for version", version, "but was", err)
+		}
+	}
+}
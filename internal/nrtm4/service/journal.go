@@ -0,0 +1,215 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+const (
+	// journalSuffix ends the name of each journal file
+	journalSuffix = ".journal"
+	// journalStateSuffix ends the name of the file with the last version in a source's journal
+	journalStateSuffix = ".journal-state"
+)
+
+var (
+	// ErrInvalidJournal the journal config doesn't say where to write the files
+	ErrInvalidJournal = errors.New("journal needs a dir")
+	// ErrJournal the changes which were applied couldn't be written to the journal
+	ErrJournal = errors.New("cannot write journal")
+)
+
+// JournalConfig writes the changes of each applied delta to a file in the NRTMv3 format IRRd
+// keeps its journal in, for pipelines which read journals from disk
+type JournalConfig struct {
+	// Dir the journal files are written to
+	Dir string `json:"dir"`
+	// FirstSerial is the NRTMv3 serial of the first change written to an empty Dir. Default is 1.
+	FirstSerial uint32 `json:"first_serial"`
+}
+
+func (c *JournalConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Dir) == 0 {
+		return ErrInvalidJournal
+	}
+	return nil
+}
+
+// journalChanges writes the changes in versions first to last to the source's journal, if it
+// has one, starting earlier when a failure left versions of the session out of it. The last
+// version written is kept in a state file in the journal's dir, so a version which couldn't be
+// written is tried again by the next sync rather than leaving a gap, and the sync fails until
+// it has been.
+func (p NRTMProcessor) journalChanges(source persist.NRTMSource, first, last uint32) error {
+	cfg := p.config.sourceConfig(source.Source).Journal
+	if cfg == nil {
+		return nil
+	}
+	if journaled, ok := readJournalState(*cfg, source); ok && journaled < first-1 {
+		logger.Info("Writing versions missing from the journal", "source", source.Source, "from", journaled+1, "to", first-1)
+		first = journaled + 1
+	}
+	if first > last {
+		return nil
+	}
+	versions := []uint32{}
+	if err := p.repo.GetObjectChanges(source, first, last, func(change persist.ObjectChange) error {
+		if !slices.Contains(versions, change.Version) {
+			versions = append(versions, change.Version)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("%w: %v", ErrJournal, err)
+	}
+	slices.Sort(versions)
+	for _, version := range versions {
+		path, err := writeJournal(p.repo, *cfg, source, version)
+		if err == nil {
+			err = writeJournalState(*cfg, source, version)
+		}
+		if err != nil {
+			logger.Error("Failed to write journal", "source", source.Source, "version", version, "error", err)
+			return fmt.Errorf("%w: version %d: %v", ErrJournal, version, err)
+		}
+		logger.Debug("Wrote journal", "source", source.Source, "version", version, "path", path)
+	}
+	if err := writeJournalState(*cfg, source, last); err != nil {
+		return fmt.Errorf("%w: %v", ErrJournal, err)
+	}
+	return nil
+}
+
+// journalStatePath is the file holding the session and the last version in a source's journal
+func journalStatePath(cfg JournalConfig, source persist.NRTMSource) string {
+	return filepath.Join(cfg.Dir, journalPrefix(source)+journalStateSuffix)
+}
+
+// readJournalState is the last version in the source's journal. It's false when there's no
+// state, or it's for another session.
+func readJournalState(cfg JournalConfig, source persist.NRTMSource) (uint32, bool) {
+	bytes, err := os.ReadFile(journalStatePath(cfg, source))
+	if err != nil {
+		return 0, false
+	}
+	sessionID, version, ok := strings.Cut(strings.TrimSpace(string(bytes)), " ")
+	if !ok || sessionID != source.SessionID {
+		return 0, false
+	}
+	last, err := strconv.ParseUint(version, 10, 32)
+	return uint32(last), err == nil
+}
+
+func writeJournalState(cfg JournalConfig, source persist.NRTMSource, version uint32) error {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return err
+	}
+	return writeFileAtomically(journalStatePath(cfg, source), func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "%v %d\n", source.SessionID, version)
+		return err
+	})
+}
+
+// writeJournal writes the changes a version made to a file named after the serials of its first
+// and last change, which carry on from the last journal file in the directory. It returns the
+// file's path, or nothing when the version didn't change any objects.
+func writeJournal(repo persist.Repository, cfg JournalConfig, source persist.NRTMSource, version uint32) (string, error) {
+	changes := []persist.ObjectChange{}
+	if err := repo.GetObjectChanges(source, version, version, func(change persist.ObjectChange) error {
+		changes = append(changes, change)
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	if len(changes) == 0 {
+		return "", nil
+	}
+	provenance, err := repo.GetProvenance(source, []uint32{version})
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return "", err
+	}
+	prefix := journalPrefix(source)
+	last, err := lastJournalSerial(cfg.Dir, prefix)
+	if err != nil {
+		return "", err
+	}
+	first := max(cfg.FirstSerial, 1)
+	if last > 0 {
+		first = last + 1
+	}
+	last = first + uint32(len(changes)) - 1
+	path := filepath.Join(cfg.Dir, fmt.Sprintf("%v.%d-%d%v", prefix, first, last, journalSuffix))
+	return path, writeFileAtomically(path, func(w io.Writer) error {
+		return writeJournalFile(w, source, first, changes, provenance[0])
+	})
+}
+
+// journalPrefix starts the name of each of a source's journal files, so sources can share a
+// directory
+func journalPrefix(source persist.NRTMSource) string {
+	if len(source.Label) == 0 {
+		return source.Source
+	}
+	return source.Source + "-" + source.Label
+}
+
+// lastJournalSerial is the serial of the last change in a source's journal files, or 0 when
+// there aren't any
+func lastJournalSerial(dir, prefix string) (uint32, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	last := uint32(0)
+	for _, entry := range entries {
+		serials, ok := strings.CutPrefix(entry.Name(), prefix+".")
+		if !ok {
+			continue
+		}
+		if serials, ok = strings.CutSuffix(serials, journalSuffix); !ok {
+			continue
+		}
+		_, to, ok := strings.Cut(serials, "-")
+		if !ok {
+			continue
+		}
+		serial, err := strconv.ParseUint(to, 10, 32)
+		if err != nil {
+			continue
+		}
+		last = max(last, uint32(serial))
+	}
+	return last, nil
+}
+
+// writeJournalFile writes changes as an NRTMv3 version 3 response, which numbers each ADD and
+// DEL with its serial, starting at first. The version's provenance goes in a comment at the top.
+func writeJournalFile(w io.Writer, source persist.NRTMSource, first uint32, changes []persist.ObjectChange, prov persist.Provenance) error {
+	writeProvenanceComment(w, prov)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%%START Version: 3 %v %d-%d\n\n", source.Source, first, first+uint32(len(changes))-1)
+	for i, change := range changes {
+		action := "ADD"
+		if change.Deleted {
+			action = "DEL"
+		}
+		if _, err := fmt.Fprintf(w, "%v %d\n\n%v\n\n", action, first+uint32(i), strings.TrimRight(change.RPSL, "\n")); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%%END %v\n", source.Source)
+	return err
+}
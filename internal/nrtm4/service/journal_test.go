@@ -0,0 +1,124 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+func TestWriteJournal(t *testing.T) {
+	dir := t.TempDir()
+	source := persist.NRTMSource{Source: "EXAMPLE", SessionID: "ca128382-78d9-41d1-8927-1ecef15275be"}
+	repo := objectChangesRepo{changes: []persist.ObjectChange{
+		{Version: 7, RPSL: "mntner: EXAMPLE-MNT\nsource: EXAMPLE\n"},
+		{Version: 7, RPSL: "person: Example\nnic-hdl: EX1-EXAMPLE\nsource: EXAMPLE\n", Deleted: true},
+		{Version: 8, RPSL: "mntner: EXAMPLE-MNT\ndescr: changed\nsource: EXAMPLE\n"},
+	}}
+	cfg := JournalConfig{Dir: dir, FirstSerial: 100}
+
+	path, err := writeJournal(repo, cfg, source, 7)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if filepath.Base(path) != "EXAMPLE.100-101.journal" {
+		t.Error("Unexpected file name", path)
+	}
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `% Provenance: source EXAMPLE session ca128382-78d9-41d1-8927-1ecef15275be version 7
+
+%START Version: 3 EXAMPLE 100-101
+
+ADD 100
+
+mntner: EXAMPLE-MNT
+source: EXAMPLE
+
+DEL 101
+
+person: Example
+nic-hdl: EX1-EXAMPLE
+source: EXAMPLE
+
+%END EXAMPLE
+`
+	if string(bytes) != expected {
+		t.Errorf("Expected\n%v\nbut was\n%v", expected, string(bytes))
+	}
+
+	// Serials carry on from the last file, whatever first_serial says
+	if path, err = writeJournal(repo, cfg, source, 8); err != nil || filepath.Base(path) != "EXAMPLE.102-102.journal" {
+		t.Error("Expected the serials to carry on but was", path, err)
+	}
+	if path, err = writeJournal(repo, cfg, source, 9); err != nil || len(path) > 0 {
+		t.Error("Expected no file for a version without changes but was", path, err)
+	}
+	labelled := source
+	labelled.Label = "test"
+	if path, err = writeJournal(repo, JournalConfig{Dir: dir}, labelled, 8); err != nil || filepath.Base(path) != "EXAMPLE-test.1-1.journal" {
+		t.Error("Expected a labelled source to have its own serials but was", path, err)
+	}
+}
+
+func TestJournalChangesCatchesUp(t *testing.T) {
+	dir := t.TempDir()
+	source := persist.NRTMSource{Source: "EXAMPLE", SessionID: "ca128382-78d9-41d1-8927-1ecef15275be", Version: 7}
+	repo := objectChangesRepo{changes: []persist.ObjectChange{
+		{Version: 7, RPSL: "mntner: EXAMPLE-MNT\nsource: EXAMPLE\n"},
+		{Version: 8, RPSL: "mntner: EXAMPLE-MNT\ndescr: changed\nsource: EXAMPLE\n"},
+		{Version: 9, RPSL: "mntner: EXAMPLE-MNT\nsource: EXAMPLE\n", Deleted: true},
+	}}
+	config := AppConfig{Sources: map[string]SourceConfig{"EXAMPLE": {Journal: &JournalConfig{Dir: dir}}}}
+	p := NRTMProcessor{repo: repo, config: config}
+	if err := p.journalChanges(source, 7, 7); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	// A version which can't be written fails the sync, and is written before the next one
+	failing := NRTMProcessor{repo: failingChangesRepo{repo}, config: config}
+	if err := failing.journalChanges(source, 8, 8); !errors.Is(err, ErrJournal) {
+		t.Error("Expected ErrJournal but was", err)
+	}
+	if err := p.journalChanges(source, 9, 9); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	expected := []string{"EXAMPLE.1-1.journal", "EXAMPLE.2-2.journal", "EXAMPLE.3-3.journal", "EXAMPLE.journal-state"}
+	if !slices.Equal(names, expected) {
+		t.Error("Expected", expected, "but was", names)
+	}
+	if last, ok := readJournalState(JournalConfig{Dir: dir}, source); !ok || last != 9 {
+		t.Error("Expected version 9 to be the last in the journal but was", last, ok)
+	}
+
+	// Nothing is missing, and another session's state isn't carried on from
+	source.Version = 9
+	if err := p.journalChanges(source, 10, 9); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	next := source
+	next.SessionID = "0dd3a7b7-5e5e-4bd6-9b0a-4e35a1a5b1b2"
+	if _, ok := readJournalState(JournalConfig{Dir: dir}, next); ok {
+		t.Error("Expected no state for another session")
+	}
+}
+
+func TestJournalConfigValidate(t *testing.T) {
+	var cfg *JournalConfig
+	if err := cfg.validate(); err != nil {
+		t.Error("Expected no journal to be valid but was", err)
+	}
+	if err := (&JournalConfig{}).validate(); !errors.Is(err, ErrInvalidJournal) {
+		t.Error("Expected ErrInvalidJournal but was", err)
+	}
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/jsonseq"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/quirks"
+)
+
+// DeltaLintFinding is a problem found in one record of a delta file. Record 1 is the header.
+type DeltaLintFinding struct {
+	Record   int           `json:"record"`
+	ID       string        `json:"id"`
+	Severity CheckSeverity `json:"severity"`
+	Message  string        `json:"message"`
+}
+
+// DeltaLintReport lists the problems found in a delta file. ExitCode uses the same values as
+// the validate command.
+type DeltaLintReport struct {
+	File     string             `json:"file"`
+	Records  int                `json:"records"`
+	Changes  int                `json:"changes"`
+	Findings []DeltaLintFinding `json:"findings"`
+	ExitCode int                `json:"exit_code"`
+}
+
+func (r *DeltaLintReport) add(record int, id string, severity CheckSeverity, format string, args ...any) {
+	r.Findings = append(r.Findings, DeltaLintFinding{
+		Record:   record,
+		ID:       id,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+	if severity == SeverityError {
+		r.ExitCode = ExitErrors
+	} else if r.ExitCode == ExitConformant {
+		r.ExitCode = ExitWarnings
+	}
+}
+
+// LintDelta checks a single delta file, from a path or an http(s) URL, without reference to a
+// notification file or the repo. It's meant for registries to check their files before they're
+// published.
+func (p NRTMProcessor) LintDelta(location string) DeltaLintReport {
+	report := DeltaLintReport{File: location, Findings: []DeltaLintFinding{}}
+	reader, err := p.openFileOrURL(location)
+	if err != nil {
+		report.add(0, "file.open", SeverityError, "%v", err)
+		return report
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	lintDelta(reader, p.config.sourceQuirks, &report)
+	return report
+}
+
+// lintDelta reads the records of a delta, which may be gzipped, into the report. Objects are
+// parsed with the quirks sourceQuirks returns for the source in the header.
+func lintDelta(r io.Reader, sourceQuirks func(string) quirks.Set, report *DeltaLintReport) {
+	br := bufio.NewReaderSize(r, jsonSeqReadBufferSize)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := newGzipMemberReader(br)
+		if err != nil {
+			report.add(0, "file.gzip", SeverityError, "%v", err)
+			return
+		}
+		br = bufio.NewReaderSize(gz, jsonSeqReadBufferSize)
+	}
+	var header protocol.NrtmFileJSON
+	var objectQuirks quirks.Set
+	revision := protocol.Current
+	// seen maps each object type and key to the first record which changed it
+	seen := map[string]int{}
+	err := jsonseq.ReadRecords(br, func(record []byte, err error) error {
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(record) == 0 && err == io.EOF {
+			return nil
+		}
+		report.Records++
+		if report.Records == 1 {
+			header, revision = lintDeltaHeader(record, report)
+			objectQuirks = sourceQuirks(header.Source)
+			return nil
+		}
+		report.Changes++
+		lintDeltaChange(report.Records, record, header, revision, objectQuirks, seen, report)
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		report.add(report.Records+1, "file.jsonseq", SeverityError, "not a JSON text sequence: %v", err)
+		return
+	}
+	if report.Records == 0 {
+		report.add(0, "file.empty", SeverityError, "the file has no records")
+	} else if report.Changes == 0 {
+		report.add(1, "file.no_changes", SeverityWarning, "the delta has a header but no changes")
+	}
+}
+
+func lintDeltaHeader(record []byte, report *DeltaLintReport) (protocol.NrtmFileJSON, protocol.Revision) {
+	header, revision, err := protocol.DecodeHeader(record)
+	if err != nil {
+		report.add(1, "header.json", SeverityError, "header is not a valid JSON object: %v", err)
+		return header, revision
+	}
+	if header.NrtmVersion != 4 {
+		report.add(1, "header.nrtm_version", SeverityError, "nrtm_version is %v, expected 4", header.NrtmVersion)
+	}
+	if header.Type != persist.DeltaFile.String() {
+		report.add(1, "header.type", SeverityError, "type is '%v', expected 'delta'", header.Type)
+	}
+	if len(header.Source) == 0 {
+		report.add(1, "header.source", SeverityError, "source is empty")
+	}
+	if !uuidRe.MatchString(header.SessionID) {
+		report.add(1, "header.session_id", SeverityError, "session_id '%v' is not a UUID", header.SessionID)
+	}
+	if header.Version == 0 {
+		report.add(1, "header.version", SeverityError, "version is missing or 0")
+	}
+	return header, revision
+}
+
+func lintDeltaChange(n int, record []byte, header protocol.NrtmFileJSON, revision protocol.Revision, objectQuirks quirks.Set, seen map[string]int, report *DeltaLintReport) {
+	delta, err := revision.DecodeChange(record)
+	if err != nil {
+		report.add(n, "change.json", SeverityError, "change is not a valid JSON object: %v", err)
+		return
+	}
+	var objectType, primaryKey string
+	switch delta.Action {
+	case persist.DeltaAddModifyAction:
+		if delta.Object == nil {
+			report.add(n, "change.object", SeverityError, "add_modify has no object")
+			return
+		}
+		if delta.ObjectClass != nil || delta.PrimaryKey != nil {
+			report.add(n, "change.extra_fields", SeverityWarning, "add_modify has object_class or primary_key, which only belong in a delete")
+		}
+		obj, err := objectQuirks.Parse(*delta.Object)
+		if err != nil {
+			report.add(n, "change.rpsl", SeverityError, "object can't be parsed: %v", err)
+			return
+		}
+		if len(header.Source) > 0 && !strings.EqualFold(obj.Source, header.Source) {
+			report.add(n, "change.source", SeverityWarning, "%v %v has source %v, but the delta is for %v", obj.ObjectType, obj.PrimaryKey, obj.Source, header.Source)
+		}
+		objectType, primaryKey = obj.ObjectType, obj.PrimaryKey
+	case persist.DeltaDeleteAction:
+		if delta.ObjectClass == nil || len(*delta.ObjectClass) == 0 || delta.PrimaryKey == nil || len(*delta.PrimaryKey) == 0 {
+			report.add(n, "change.delete_key", SeverityError, "delete needs an object_class and a primary_key")
+			return
+		}
+		if delta.Object != nil {
+			report.add(n, "change.extra_fields", SeverityWarning, "delete has an object, which only belongs in an add_modify")
+		}
+		objectType, primaryKey = strings.ToUpper(*delta.ObjectClass), strings.ToUpper(*delta.PrimaryKey)
+	default:
+		report.add(n, "change.action", SeverityError, "action is '%v', expected add_modify or delete", delta.Action)
+		return
+	}
+	key := objectType + " " + primaryKey
+	if first, ok := seen[key]; ok {
+		report.add(n, "change.duplicate", SeverityWarning, "%v was already changed in record %d", key, first)
+		return
+	}
+	seen[key] = n
+}
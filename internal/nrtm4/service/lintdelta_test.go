@@ -0,0 +1,104 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func deltaSeq(records ...string) string {
+	return "\x1e" + strings.Join(records, "\n\x1e") + "\n"
+}
+
+const lintHeader = `{"nrtm_version": 4, "type": "delta", "source": "EXAMPLE", "session_id": "ca128382-78d9-41d1-8927-1ecef15275be", "version": 3}`
+
+func TestLintDeltaClean(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "delta.json")
+	seq := deltaSeq(lintHeader,
+		`{"action": "add_modify", "object": "mntner: A-MNT\nsource: EXAMPLE\n"}`,
+		`{"action": "delete", "object_class": "mntner", "primary_key": "B-MNT"}`,
+	)
+	if err := os.WriteFile(path, []byte(seq), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report := NRTMProcessor{}.LintDelta(path)
+	if report.ExitCode != ExitConformant || len(report.Findings) != 0 || report.Records != 3 || report.Changes != 2 {
+		t.Error("Expected a clean report but was", report)
+	}
+
+	// The same file gzipped
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(seq))
+	w.Close()
+	if err := os.WriteFile(path+".gz", gz.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if report = (NRTMProcessor{}).LintDelta(path + ".gz"); report.ExitCode != ExitConformant || report.Changes != 2 {
+		t.Error("Expected a clean report for the gzipped file but was", report)
+	}
+}
+
+func TestLintDeltaFindings(t *testing.T) {
+	report := DeltaLintReport{Findings: []DeltaLintFinding{}}
+	lintDelta(strings.NewReader(deltaSeq(
+		`{"nrtm_version": 3, "type": "snapshot", "source": "EXAMPLE", "session_id": "abc", "version": 3}`,
+		`{"action": "add_modify", "object": "mntner: A-MNT\nsource: OTHER\n"}`,
+		`{"action": "update", "object": "mntner: A-MNT\nsource: EXAMPLE\n"}`,
+		`{"action": "delete", "object_class": "mntner"}`,
+		`{"action": "delete", "object_class": "MNTNER", "primary_key": "a-mnt"}`,
+		`{"action": "add_modify", "object": "no colon here"}`,
+		`not json`,
+	)), AppConfig{}.sourceQuirks, &report)
+	expected := []struct {
+		record int
+		id     string
+	}{
+		{1, "header.nrtm_version"},
+		{1, "header.type"},
+		{1, "header.session_id"},
+		{2, "change.source"},
+		{3, "change.action"},
+		{4, "change.delete_key"},
+		{5, "change.duplicate"},
+		{6, "change.rpsl"},
+		{7, "change.json"},
+	}
+	if len(report.Findings) != len(expected) {
+		t.Fatal("Unexpected findings", report.Findings)
+	}
+	for i, exp := range expected {
+		if f := report.Findings[i]; f.Record != exp.record || f.ID != exp.id {
+			t.Error("Expected", exp, "but was", f)
+		}
+	}
+	if report.ExitCode != ExitErrors {
+		t.Error("Expected ExitErrors but was", report.ExitCode)
+	}
+
+	report = DeltaLintReport{Findings: []DeltaLintFinding{}}
+	lintDelta(strings.NewReader(deltaSeq(lintHeader)), AppConfig{}.sourceQuirks, &report)
+	if report.ExitCode != ExitWarnings || len(report.Findings) != 1 || report.Findings[0].ID != "file.no_changes" {
+		t.Error("Expected a warning for a delta without changes but was", report)
+	}
+	report = DeltaLintReport{Findings: []DeltaLintFinding{}}
+	lintDelta(strings.NewReader(lintHeader), AppConfig{}.sourceQuirks, &report)
+	if report.ExitCode != ExitErrors || report.Findings[0].ID != "file.jsonseq" {
+		t.Error("Expected an error for a file which isn't a JSON sequence but was", report)
+	}
+}
+
+func TestLintDeltaWithQuirks(t *testing.T) {
+	config := AppConfig{Sources: map[string]SourceConfig{"example": {Quirks: []string{"quoted-values", "missing-source"}}}}
+	report := DeltaLintReport{Findings: []DeltaLintFinding{}}
+	lintDelta(strings.NewReader(deltaSeq(lintHeader,
+		`{"action": "add_modify", "object": "mntner: \"A-MNT\"\n"}`,
+		`{"action": "delete", "object_class": "mntner", "primary_key": "A-MNT"}`,
+	)), config.sourceQuirks, &report)
+	if len(report.Findings) != 1 || report.Findings[0].ID != "change.duplicate" {
+		t.Error("Expected the object to be parsed with the source's quirks but was", report.Findings)
+	}
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+var (
+	// ErrInvalidCursor the cursor is corrupt, or was returned for a different sort or filter
+	ErrInvalidCursor = errors.New("cursor is not valid for this query")
+	// ErrUnknownListField the sort key or a filter names a field the list doesn't have
+	ErrUnknownListField = errors.New("unknown field")
+	// ErrInvalidFilter a filter term isn't in the form field:value
+	ErrInvalidFilter = errors.New("filter terms must be field:value")
+)
+
+// ListQuery selects a page of results. Every list method in the API takes one.
+//
+// Filter is a space separated list of field:value terms which must all match. Values are
+// compared case-insensitively. Sort is the name of a field, prefixed with "-" to sort in
+// descending order. Limit defaults to 50 and can't be more than 500. Cursor is the NextCursor
+// of the previous page, and must be used with the same Filter and Sort.
+type ListQuery struct {
+	Filter string
+	Sort   string
+	Limit  int
+	Cursor string
+}
+
+// Page is one page of results. NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []T
+	NextCursor string
+}
+
+// listCursor holds the sort key and ID of the last item on a page, so the next page starts
+// after it even if items were added or removed in the meantime
+type listCursor struct {
+	Sort   string `json:"s"`
+	Filter string `json:"f"`
+	Key    string `json:"k"`
+	ID     string `json:"i"`
+}
+
+func (c listCursor) encode() string {
+	bytes, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func decodeCursor(s string) (listCursor, error) {
+	var c listCursor
+	bytes, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err = json.Unmarshal(bytes, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// listFields maps field names to functions which return a field's value as a string that
+// sorts in the same order as the value
+type listFields[T any] map[string]func(T) string
+
+// listPage filters, sorts and pages items. id must be unique, it breaks ties between items
+// with the same sort key.
+func listPage[T any](items []T, q ListQuery, fields listFields[T], defaultSort string, id func(T) string) (Page[T], error) {
+	page := Page[T]{Items: []T{}}
+	filtered, err := filterList(items, q.Filter, fields)
+	if err != nil {
+		return page, err
+	}
+	sortName := q.Sort
+	if len(sortName) == 0 {
+		sortName = defaultSort
+	}
+	descending := strings.HasPrefix(sortName, "-")
+	key, ok := fields[strings.ToLower(strings.TrimPrefix(sortName, "-"))]
+	if !ok {
+		return page, fmt.Errorf("%w: sort %v", ErrUnknownListField, sortName)
+	}
+	less := func(a, b T) bool {
+		ka, kb := key(a), key(b)
+		if ka != kb {
+			return (ka < kb) != descending
+		}
+		return (id(a) < id(b)) != descending
+	}
+	sort.SliceStable(filtered, func(i, j int) bool { return less(filtered[i], filtered[j]) })
+	start := 0
+	if len(q.Cursor) > 0 {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil || c.Sort != sortName || c.Filter != q.Filter {
+			return page, ErrInvalidCursor
+		}
+		start = sort.Search(len(filtered), func(i int) bool {
+			k, itemID := key(filtered[i]), id(filtered[i])
+			if descending {
+				return k < c.Key || k == c.Key && itemID < c.ID
+			}
+			return k > c.Key || k == c.Key && itemID > c.ID
+		})
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+	limit = min(limit, maxPageLimit)
+	end := min(start+limit, len(filtered))
+	page.Items = append(page.Items, filtered[start:end]...)
+	if end < len(filtered) {
+		last := filtered[end-1]
+		page.NextCursor = listCursor{Sort: sortName, Filter: q.Filter, Key: key(last), ID: id(last)}.encode()
+	}
+	return page, nil
+}
+
+func filterList[T any](items []T, filter string, fields listFields[T]) ([]T, error) {
+	type term struct {
+		value func(T) string
+		want  string
+	}
+	terms := []term{}
+	for _, t := range strings.Fields(filter) {
+		name, want, found := strings.Cut(t, ":")
+		if !found {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFilter, t)
+		}
+		value, ok := fields[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("%w: filter %v", ErrUnknownListField, name)
+		}
+		terms = append(terms, term{value, want})
+	}
+	filtered := []T{}
+next:
+	for _, item := range items {
+		for _, t := range terms {
+			if !strings.EqualFold(t.value(item), t.want) {
+				continue next
+			}
+		}
+		filtered = append(filtered, item)
+	}
+	return filtered, nil
+}
+
+// sourceListFields are the fields sources can be sorted and filtered by
+var sourceListFields = listFields[persist.NRTMSource]{
+	"source":  func(s persist.NRTMSource) string { return s.Source },
+	"label":   func(s persist.NRTMSource) string { return s.Label },
+	"version": func(s persist.NRTMSource) string { return fmt.Sprintf("%010d", s.Version) },
+	"created": func(s persist.NRTMSource) string { return s.Created.UTC().Format(sortableTimeFormat) },
+	"paused":  func(s persist.NRTMSource) string { return strconv.FormatBool(s.Paused) },
+}
+
+// sortableTimeFormat is fixed width, so times sort in the same order as their strings
+const sortableTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// QuerySources lists a page of sources. It's the same as ListSources, except notification
+// history is only fetched for sources on the page.
+func (p NRTMProcessor) QuerySources(q ListQuery) (Page[persist.NRTMSourceDetails], error) {
+	result := Page[persist.NRTMSourceDetails]{Items: []persist.NRTMSourceDetails{}}
+	ds := NrtmDataService{Repository: p.repo}
+	sources, err := ds.getSources()
+	if err != nil {
+		return result, err
+	}
+	page, err := listPage(sources, q, sourceListFields, "source", func(s persist.NRTMSource) string {
+		return fmt.Sprintf("%020d", s.ID)
+	})
+	if err != nil {
+		return result, err
+	}
+	for _, src := range page.Items {
+		deets, err := p.sourceDetails(src)
+		if err != nil {
+			return result, err
+		}
+		if err = p.query.read(len(deets.Notifications) + len(deets.Sessions)); err != nil {
+			return result, err
+		}
+		result.Items = append(result.Items, deets)
+	}
+	result.NextCursor = page.NextCursor
+	return result, nil
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+func TestListPageCursor(t *testing.T) {
+	sources := []persist.NRTMSource{
+		{ID: 1, Source: "RIPE", Version: 30},
+		{ID: 2, Source: "ARIN", Version: 10},
+		{ID: 3, Source: "APNIC", Version: 20, Paused: true},
+		{ID: 4, Source: "RIPE", Label: "test", Version: 5},
+	}
+	id := func(s persist.NRTMSource) string { return string(rune('0' + s.ID)) }
+	q := ListQuery{Sort: "-version", Limit: 2}
+	page, err := listPage(sources, q, sourceListFields, "source", id)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(page.Items) != 2 || page.Items[0].ID != 1 || page.Items[1].ID != 3 || len(page.NextCursor) == 0 {
+		t.Fatal("Unexpected first page", page)
+	}
+	// A source added before the cursor's position doesn't shift the next page
+	sources = append(sources, persist.NRTMSource{ID: 5, Source: "AFRINIC", Version: 25})
+	q.Cursor = page.NextCursor
+	if page, err = listPage(sources, q, sourceListFields, "source", id); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(page.Items) != 2 || page.Items[0].ID != 2 || page.Items[1].ID != 4 || len(page.NextCursor) != 0 {
+		t.Error("Unexpected last page", page)
+	}
+	q.Sort = "source"
+	if _, err = listPage(sources, q, sourceListFields, "source", id); err != ErrInvalidCursor {
+		t.Error("Expected ErrInvalidCursor when the sort changes but was", err)
+	}
+}
+
+func TestListPageFilter(t *testing.T) {
+	sources := []persist.NRTMSource{
+		{ID: 1, Source: "RIPE"},
+		{ID: 2, Source: "RIPE", Label: "test"},
+		{ID: 3, Source: "ARIN", Paused: true},
+	}
+	id := func(s persist.NRTMSource) string { return string(rune('0' + s.ID)) }
+	page, err := listPage(sources, ListQuery{Filter: "source:ripe label:TEST"}, sourceListFields, "source", id)
+	if err != nil || len(page.Items) != 1 || page.Items[0].ID != 2 {
+		t.Error("Unexpected filter result", page, err)
+	}
+	if page, _ = listPage(sources, ListQuery{Filter: "paused:true"}, sourceListFields, "source", id); len(page.Items) != 1 {
+		t.Error("Expected one paused source", page)
+	}
+	if _, err = listPage(sources, ListQuery{Filter: "colour:blue"}, sourceListFields, "source", id); !errors.Is(err, ErrUnknownListField) {
+		t.Error("Expected ErrUnknownListField but was", err)
+	}
+	if _, err = listPage(sources, ListQuery{Filter: "RIPE"}, sourceListFields, "source", id); !errors.Is(err, ErrInvalidFilter) {
+		t.Error("Expected ErrInvalidFilter but was", err)
+	}
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// ErrLiveTestFilterNeeded e2e-live has to be given a filter, so it doesn't import a whole registry
+var ErrLiveTestFilterNeeded = errors.New("a live test needs a filter with at least one maintainer or organisation")
+
+// liveTestLabel is the start of the label the live test connects its source with, so it never
+// touches a source which is already mirrored
+const liveTestLabel = "e2e-live"
+
+// LiveTestOptions keeps a live test small enough to run against a production feed
+type LiveTestOptions struct {
+	// Filter is the maintainers or organisations whose objects are saved
+	Filter *FilterConfig
+	// MaxFileSize is the most bytes a snapshot or delta file can have. 0 means no limit.
+	MaxFileSize int64
+	// Keep leaves the source and its files behind, instead of removing them
+	Keep bool
+}
+
+// LiveTestStep is the outcome of one step of a live test
+type LiveTestStep struct {
+	ID          string        `json:"id"`
+	Description string        `json:"description"`
+	Status      CheckStatus   `json:"status"`
+	Severity    CheckSeverity `json:"severity"`
+	Seconds     float64       `json:"seconds"`
+	Message     string        `json:"message,omitempty"`
+}
+
+// LiveTestReport lists the steps of a live test. ExitCode uses the same values as the validate
+// command.
+type LiveTestReport struct {
+	NotificationURL string         `json:"notification_url"`
+	Source          string         `json:"source"`
+	Label           string         `json:"label"`
+	Version         uint32         `json:"version"`
+	Objects         int            `json:"objects"`
+	Steps           []LiveTestStep `json:"steps"`
+	ExitCode        int            `json:"exit_code"`
+}
+
+func (r *LiveTestReport) add(id string, severity CheckSeverity, description string, start time.Time, err error) bool {
+	step := LiveTestStep{
+		ID:          id,
+		Description: description,
+		Status:      CheckPassed,
+		Severity:    severity,
+		Seconds:     time.Since(start).Seconds(),
+	}
+	if err != nil {
+		step.Status = CheckFailed
+		step.Message = err.Error()
+		if severity == SeverityError {
+			r.ExitCode = ExitErrors
+		} else if r.ExitCode == ExitConformant {
+			r.ExitCode = ExitWarnings
+		}
+	}
+	r.Steps = append(r.Steps, step)
+	return err == nil
+}
+
+// LiveTest runs the whole pipeline against a real server: it connects the source under a new
+// label, updates it, checks the objects it saved, then removes it again. Only the objects which
+// pass the filter are saved, and files over the size limit aren't downloaded, so it doesn't
+// need a full import. Downloads go to a temporary directory.
+func (p NRTMProcessor) LiveTest(notificationURL string, opts LiveTestOptions) (report LiveTestReport) {
+	report = LiveTestReport{
+		NotificationURL: notificationURL,
+		Label:           liveTestLabel + " " + util.AppClock.Now().Format("20060102T150405"),
+		Steps:           []LiveTestStep{},
+	}
+	start := time.Now()
+	var err error
+	if opts.Filter == nil || opts.Filter.validate() != nil {
+		err = ErrLiveTestFilterNeeded
+	}
+	if !report.add("options", SeverityError, "The test has a filter", start, err) {
+		return report
+	}
+	fm := fileManager{client: p.client}
+	notification, _, err := fm.downloadNotificationFile(notificationURL)
+	if !report.add("notification", SeverityError, "Notification file is fetched and valid", start, err) {
+		return report
+	}
+	report.Source = notification.Source
+	dir, err := os.MkdirTemp(p.config.TempDir, "nrtm4live")
+	if !report.add("files", SeverityError, "Temporary directory is created for the downloads", start, err) {
+		return report
+	}
+	if !opts.Keep {
+		defer os.RemoveAll(dir)
+	}
+	p.config = liveTestConfig(p.config, notification.Source, dir, opts)
+
+	start = time.Now()
+	_, err = p.Connect(notificationURL, report.Label)
+	connected := report.add("connect", SeverityError, "Snapshot and deltas are loaded into a new source", start, err)
+	ds := NrtmDataService{Repository: p.repo}
+	if !opts.Keep {
+		defer func() {
+			if ds.getSourceByNameAndLabel(report.Source, report.Label) == nil {
+				return
+			}
+			start := time.Now()
+			report.add("cleanup", SeverityWarning, "Test source is removed", start, p.RemoveSource(report.Source, report.Label))
+		}()
+	}
+	if !connected {
+		return report
+	}
+	start = time.Now()
+	result, err := p.Update(notification.Source, report.Label, CatchUpDeltas)
+	report.add("update", SeverityError, "Source is updated with the latest deltas", start, err)
+	report.Version = result.ToVersion
+
+	start = time.Now()
+	source := ds.getSourceByNameAndLabel(notification.Source, report.Label)
+	if source == nil {
+		report.add("objects", SeverityError, "Saved objects can be read and pass the filter", start, ErrSourceNotFound)
+		return report
+	}
+	err = p.repo.GetCurrentObjects(*source, 0, nil, func(obj rpsl.Rpsl) error {
+		report.Objects++
+		if !opts.Filter.keeps(obj) {
+			return fmt.Errorf("%v %v doesn't pass the filter", obj.ObjectType, obj.PrimaryKey)
+		}
+		return nil
+	})
+	if !report.add("objects", SeverityError, "Saved objects can be read and pass the filter", start, err) {
+		return report
+	}
+	if report.Objects == 0 {
+		err = errors.New("no objects passed the filter. Filter on a maintainer with objects in the source")
+	}
+	report.add("objects.found", SeverityWarning, "Some objects were saved", start, err)
+	return report
+}
+
+// liveTestConfig is the config for a live test of a source: its objects are filtered, nothing
+// is published, run after syncs or sent as telemetry, and files are downloaded to dir
+func liveTestConfig(config AppConfig, sourceName, dir string, opts LiveTestOptions) AppConfig {
+	sources := map[string]SourceConfig{}
+	for name, sc := range config.Sources {
+		if !strings.EqualFold(name, sourceName) {
+			sources[name] = sc
+		}
+	}
+	sc := config.sourceConfig(sourceName)
+	sc.Filter = opts.Filter
+	sc.Publish = nil
+	sc.PostSync = nil
+	sc.Journal = nil
+	sources[sourceName] = sc
+	config.Sources = sources
+	config.NRTMFilePath = dir
+	config.TempDir = ""
+	config.MaxFileSize = opts.MaxFileSize
+	config.Telemetry = TelemetryConfig{}
+	return config
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestLiveTestNeedsAFilter(t *testing.T) {
+	report := NRTMProcessor{}.LiveTest("https://nrtm.example.net/notification.json", LiveTestOptions{})
+	if report.ExitCode != ExitErrors || len(report.Steps) != 1 || report.Steps[0].Message != ErrLiveTestFilterNeeded.Error() {
+		t.Error("Expected the test to stop without a filter but was", report)
+	}
+}
+
+func TestLiveTestConfig(t *testing.T) {
+	config := AppConfig{
+		NRTMFilePath: "/var/lib/nrtm4",
+		TempDir:      "/var/tmp",
+		Telemetry:    TelemetryConfig{URL: "https://telemetry.example.net"},
+		Sources: map[string]SourceConfig{
+			"ripe":  {Publish: &PublishConfig{URL: "nats://localhost:4222"}, PostSync: &PostSyncConfig{Command: []string{"true"}}, Contact: "noc@example.net"},
+			"APNIC": {Contact: "noc@example.net"},
+		},
+	}
+	filter := &FilterConfig{MntBy: []string{"EXAMPLE-MNT"}}
+	live := liveTestConfig(config, "RIPE", "/tmp/nrtm4live1", LiveTestOptions{Filter: filter, MaxFileSize: 1000})
+	sc := live.sourceConfig("RIPE")
+	if sc.Filter != filter || sc.Publish != nil || sc.PostSync != nil || sc.Contact != "noc@example.net" || len(live.Sources) != 2 {
+		t.Error("Unexpected source config", live.Sources)
+	}
+	if live.NRTMFilePath != "/tmp/nrtm4live1" || live.TempDir != "" || live.MaxFileSize != 1000 || len(live.Telemetry.URL) > 0 {
+		t.Error("Unexpected config", live)
+	}
+	if config.sourceConfig("RIPE").Publish == nil {
+		t.Error("Expected the original config to be unchanged")
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 2000))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	fm := fileManager{client: HTTPClient{}, maxFileSize: 1000}
+	if _, err := fm.downloadToTempFile(server.URL+"/snapshot.json", dir, false); !errors.Is(err, ErrFileTooLarge) {
+		t.Error("Expected ErrFileTooLarge but was", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Error("Expected the partial download to be removed but found", entries)
+	}
+	fm.maxFileSize = 2000
+	if _, err := fm.downloadToTempFile(server.URL+"/snapshot.json", dir, false); err != nil {
+		t.Error("Expected a file at the limit to be downloaded but was", err)
+	}
+}
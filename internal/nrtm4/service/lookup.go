@@ -0,0 +1,98 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+var (
+	// ErrTooManyKeys more keys were asked for than can be looked up at once
+	ErrTooManyKeys = errors.New("too many keys")
+
+	// maxLookupKeys limits the size of one lookup
+	maxLookupKeys = 100000
+)
+
+// LookupResult has the objects found by a lookup, and the keys which weren't found.
+// Provenance says where each object came from.
+type LookupResult struct {
+	Objects    []rpsl.Rpsl
+	Missing    []string
+	Provenance []persist.ObjectProvenance
+}
+
+// Lookup finds the objects for a list of primary keys, as they were at version, or at the latest
+// applied version when it's 0. Keys aren't case sensitive, and duplicates are ignored.
+func (p NRTMProcessor) Lookup(sourceName, label string, version uint32, keys []string) (LookupResult, error) {
+	result := LookupResult{Objects: []rpsl.Rpsl{}, Missing: []string{}, Provenance: []persist.ObjectProvenance{}}
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return result, ErrSourceNotFound
+	}
+	keys = normalizeKeys(keys)
+	if len(keys) > maxLookupKeys {
+		return result, fmt.Errorf("%w: %d, the limit is %d", ErrTooManyKeys, len(keys), maxLookupKeys)
+	}
+	if len(keys) == 0 {
+		return result, nil
+	}
+	objects, err := p.repo.LookupObjects(*source, version, keys)
+	if err != nil {
+		return result, err
+	}
+	if err = p.query.read(len(objects)); err != nil {
+		return result, err
+	}
+	result.Objects = objects
+	found := map[string]bool{}
+	for _, obj := range objects {
+		found[obj.PrimaryKey] = true
+	}
+	foundKeys := []string{}
+	for _, key := range keys {
+		if found[key] {
+			foundKeys = append(foundKeys, key)
+		} else {
+			result.Missing = append(result.Missing, key)
+		}
+	}
+	if len(foundKeys) > 0 {
+		if result.Provenance, err = p.repo.GetObjectProvenance(*source, version, foundKeys); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// ReadLookupKeys reads one key per line. Blank lines and lines starting with # are skipped.
+func ReadLookupKeys(r io.Reader) ([]string, error) {
+	keys := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			keys = append(keys, line)
+		}
+	}
+	return keys, scanner.Err()
+}
+
+func normalizeKeys(keys []string) []string {
+	normalized := make([]string, 0, len(keys))
+	seen := map[string]bool{}
+	for _, key := range keys {
+		key = strings.ToUpper(strings.TrimSpace(key))
+		if len(key) > 0 && !seen[key] {
+			seen[key] = true
+			normalized = append(normalized, key)
+		}
+	}
+	return normalized
+}
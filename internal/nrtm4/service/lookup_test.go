@@ -0,0 +1,77 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+type lookupRepo struct {
+	persist.Repository
+	objects []rpsl.Rpsl
+	asked   [][]string
+}
+
+func (r *lookupRepo) GetSources() ([]persist.NRTMSource, error) {
+	return []persist.NRTMSource{{ID: 1, Source: "TEST"}}, nil
+}
+
+func (r *lookupRepo) LookupObjects(source persist.NRTMSource, version uint32, keys []string) ([]rpsl.Rpsl, error) {
+	r.asked = append(r.asked, keys)
+	found := []rpsl.Rpsl{}
+	for _, obj := range r.objects {
+		if slices.Contains(keys, obj.PrimaryKey) {
+			found = append(found, obj)
+		}
+	}
+	return found, nil
+}
+
+func (r *lookupRepo) GetObjectProvenance(source persist.NRTMSource, version uint32, keys []string) ([]persist.ObjectProvenance, error) {
+	provenance := []persist.ObjectProvenance{}
+	for _, obj := range r.objects {
+		if slices.Contains(keys, obj.PrimaryKey) {
+			provenance = append(provenance, persist.ObjectProvenance{
+				ObjectType: obj.ObjectType,
+				PrimaryKey: obj.PrimaryKey,
+				Provenance: persist.Provenance{Source: source.Source, Version: 3},
+			})
+		}
+	}
+	return provenance, nil
+}
+
+func TestLookup(t *testing.T) {
+	repo := &lookupRepo{objects: []rpsl.Rpsl{
+		{ObjectType: "AUT-NUM", PrimaryKey: "AS65000"},
+		{ObjectType: "AS-SET", PrimaryKey: "AS-EXAMPLE"},
+	}}
+	p := NRTMProcessor{repo: repo}
+	keys, err := ReadLookupKeys(strings.NewReader("as65000\n# comment\n\nAS-EXAMPLE\nAS65000\nAS65001\n"))
+	if err != nil {
+		t.Fatal("Failed to read keys", err)
+	}
+	result, err := p.Lookup("TEST", "", 0, keys)
+	if err != nil {
+		t.Fatal("Lookup failed", err)
+	}
+	if len(repo.asked) != 1 || !slices.Equal(repo.asked[0], []string{"AS65000", "AS-EXAMPLE", "AS65001"}) {
+		t.Error("Expected one query with each key once but was", repo.asked)
+	}
+	if len(result.Objects) != 2 || !slices.Equal(result.Missing, []string{"AS65001"}) {
+		t.Error("Unexpected result", result)
+	}
+	if len(result.Provenance) != 2 || result.Provenance[0].Source != "TEST" || result.Provenance[0].Version != 3 {
+		t.Error("Expected provenance for each object but was", result.Provenance)
+	}
+
+	maxLookupKeys = 2
+	defer func() { maxLookupKeys = 100000 }()
+	if _, err = p.Lookup("TEST", "", 0, keys); !errors.Is(err, ErrTooManyKeys) {
+		t.Error("Expected ErrTooManyKeys but was", err)
+	}
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrInvalidIPVersion ip_version must be empty, "4" or "6"
+var ErrInvalidIPVersion = errors.New("ip_version must be empty, '4' or '6'")
+
+// ErrInvalidProxy a proxy must be "direct" or an http, https or socks5 URL
+var ErrInvalidProxy = errors.New("proxy must be 'direct' or an http, https or socks5 URL")
+
+// directProxy is the proxy setting which connects without a proxy
+const directProxy = "direct"
+
+// envProxy is where proxies come from when the config file doesn't set one. It reads
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY, and their lower case versions.
+var envProxy = http.ProxyFromEnvironment
+
+var dialTimeout = 30 * time.Second
+
+// NetworkConfig controls how server host names are resolved and connected to
+type NetworkConfig struct {
+	// IPVersion forces connections over IPv4 ("4") or IPv6 ("6"). Empty means either.
+	IPVersion string `json:"ip_version"`
+	// Resolvers are DNS servers (host:port) used instead of the system resolver
+	Resolvers []string `json:"resolvers"`
+	// DisableHappyEyeballs stops the dialer racing IPv4 against IPv6
+	DisableHappyEyeballs bool `json:"disable_happy_eyeballs"`
+	// Proxy is used for every server instead of the HTTPS_PROXY and HTTP_PROXY environment
+	// variables. "direct" connects without a proxy.
+	Proxy string `json:"proxy"`
+	// NoProxy lists the hosts which are connected to without Proxy, in the same format as
+	// NO_PROXY. It's only used when Proxy is set.
+	NoProxy string `json:"no_proxy"`
+	// Contact is added to the User-Agent so server operators can reach whoever runs the
+	// client, e.g. an email address. Sources can have their own.
+	Contact string `json:"contact"`
+	// Hosts overrides the settings above for individual server host names
+	Hosts map[string]HostNetworkConfig `json:"hosts"`
+}
+
+// HostNetworkConfig network settings for one host
+type HostNetworkConfig struct {
+	IPVersion string `json:"ip_version"`
+	// Addresses are pinned IP addresses used instead of resolving the host name
+	Addresses []string `json:"addresses"`
+	// Proxy is used for this host instead of any other proxy setting. "direct" connects
+	// without a proxy.
+	Proxy string `json:"proxy"`
+}
+
+func (n NetworkConfig) validate() error {
+	if err := validateContact(n.Contact); err != nil {
+		return err
+	}
+	versions := []string{n.IPVersion}
+	proxies := []string{n.Proxy}
+	for _, hc := range n.Hosts {
+		versions = append(versions, hc.IPVersion)
+		proxies = append(proxies, hc.Proxy)
+	}
+	for _, v := range versions {
+		if v != "" && v != "4" && v != "6" {
+			return ErrInvalidIPVersion
+		}
+	}
+	for _, p := range proxies {
+		if _, err := parseProxy(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n NetworkConfig) isDefault() bool {
+	return n.IPVersion == "" && len(n.Resolvers) == 0 && !n.DisableHappyEyeballs && len(n.Hosts) == 0 &&
+		n.Proxy == ""
+}
+
+// parseProxy parses a proxy setting. Like HTTPS_PROXY, a URL without a scheme is taken to be
+// http. It returns nil for an empty or "direct" setting.
+func parseProxy(proxy string) (*url.URL, error) {
+	if proxy == "" || strings.EqualFold(proxy, directProxy) {
+		return nil, nil
+	}
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || len(u.Host) == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProxy, proxy)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return u, nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrInvalidProxy, proxy)
+}
+
+// proxyFor returns the proxy used to connect to a server, or nil for a direct connection. A
+// host's own proxy comes first, then the config file's proxy unless no_proxy matches the host,
+// then the environment variables. Proxy autoconfig (PAC) files aren't read.
+func (n NetworkConfig) proxyFor(u *url.URL) (*url.URL, error) {
+	if hc := n.hostConfig(u.Hostname()); hc.Proxy != "" {
+		return parseProxy(hc.Proxy)
+	}
+	if n.Proxy != "" {
+		if noProxyMatches(n.NoProxy, u) {
+			return nil, nil
+		}
+		return parseProxy(n.Proxy)
+	}
+	return envProxy(&http.Request{URL: u})
+}
+
+// noProxyMatches reports whether a URL's host is in a NO_PROXY style list. Entries are
+// separated by commas and may have a port. A host name also matches its subdomains, a name
+// starting with a dot (or *.) only matches subdomains, and IP addresses and CIDR ranges match
+// IP addresses. * matches every host.
+func noProxyMatches(noProxy string, u *url.URL) bool {
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(strings.ToLower(noProxy), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		entryHost = strings.TrimSuffix(strings.TrimPrefix(entryHost, "*"), ".")
+		if strings.HasPrefix(entryHost, ".") {
+			if strings.HasSuffix(host, entryHost) {
+				return true
+			}
+		} else if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n NetworkConfig) hostConfig(host string) HostNetworkConfig {
+	for name, hc := range n.Hosts {
+		if strings.EqualFold(name, host) {
+			if hc.IPVersion == "" {
+				hc.IPVersion = n.IPVersion
+			}
+			return hc
+		}
+	}
+	return HostNetworkConfig{IPVersion: n.IPVersion}
+}
+
+func (n NetworkConfig) dialer() *net.Dialer {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if n.DisableHappyEyeballs {
+		dialer.FallbackDelay = -1
+	}
+	if len(n.Resolvers) > 0 {
+		resolvers := n.Resolvers
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var err error
+				var conn net.Conn
+				d := net.Dialer{Timeout: dialTimeout}
+				for _, r := range resolvers {
+					if conn, err = d.DialContext(ctx, network, r); err == nil {
+						return conn, nil
+					}
+				}
+				return nil, err
+			},
+		}
+	}
+	return dialer
+}
+
+// dialContext is used by the http transport to connect to servers
+func (n NetworkConfig) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	hc := n.hostConfig(host)
+	if hc.IPVersion != "" {
+		network = "tcp" + hc.IPVersion
+	}
+	dialer := n.dialer()
+	if len(hc.Addresses) == 0 {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	var conn net.Conn
+	for _, ip := range hc.Addresses {
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip, port)); err == nil {
+			return conn, nil
+		}
+		httpLogger.Warn("Cannot connect to pinned address", "host", host, "address", ip, "error", err)
+	}
+	return nil, err
+}
+
+func (n NetworkConfig) httpClient() *http.Client {
+	if n.isDefault() {
+		return http.DefaultClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = n.dialContext
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return n.proxyFor(req.URL)
+	}
+	return &http.Client{Transport: transport}
+}
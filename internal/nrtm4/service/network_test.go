@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestDialPinnedAddress(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Cannot listen", err)
+	}
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	config := NetworkConfig{
+		IPVersion: "6",
+		Hosts: map[string]HostNetworkConfig{
+			"nrtm.example.invalid": {IPVersion: "4", Addresses: []string{"127.0.0.1"}},
+		},
+	}
+	conn, err := config.dialContext(context.Background(), "tcp", net.JoinHostPort("NRTM.example.invalid", port))
+	if err != nil {
+		t.Fatal("Expected to connect to pinned address but got", err)
+	}
+	conn.Close()
+}
+
+func TestNetworkConfigValidation(t *testing.T) {
+	if err := (NetworkConfig{IPVersion: "4"}).validate(); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	bad := NetworkConfig{Hosts: map[string]HostNetworkConfig{"example.com": {IPVersion: "v6"}}}
+	if err := bad.validate(); err != ErrInvalidIPVersion {
+		t.Error("Expected ErrInvalidIPVersion but was", err)
+	}
+	hc := NetworkConfig{IPVersion: "6", Hosts: map[string]HostNetworkConfig{"example.com": {}}}.hostConfig("example.com")
+	if hc.IPVersion != "6" {
+		t.Error("Host config should inherit ip_version")
+	}
+}
+
+func TestNoProxyMatches(t *testing.T) {
+	noProxy := "localhost, .internal.example, example.org:8443, 10.0.0.0/8, 192.0.2.1"
+	for rawURL, expected := range map[string]bool{
+		"https://localhost/n.json":             true,
+		"https://nrtm.internal.example/n.json": true,
+		"https://internal.example/n.json":      false,
+		"https://example.org:8443/n.json":      true,
+		"https://nrtm.example.org:8443/n.json": true,
+		"https://example.org/n.json":           false,
+		"https://10.1.2.3/n.json":              true,
+		"https://192.0.2.1/n.json":             true,
+		"https://192.0.2.2/n.json":             false,
+		"https://notlocalhost/n.json":          false,
+	} {
+		u, _ := url.Parse(rawURL)
+		if noProxyMatches(noProxy, u) != expected {
+			t.Error("Expected", rawURL, "to match", expected)
+		}
+	}
+	u, _ := url.Parse("https://anything.example/")
+	if !noProxyMatches("*", u) {
+		t.Error("Expected * to match every host")
+	}
+}
+
+func TestProxyFor(t *testing.T) {
+	saved := envProxy
+	defer func() { envProxy = saved }()
+	envProxy = func(*http.Request) (*url.URL, error) {
+		return url.Parse("http://env.proxy.example:3128")
+	}
+	config := NetworkConfig{
+		Proxy:   "proxy.example:8080",
+		NoProxy: ".example.net",
+		Hosts: map[string]HostNetworkConfig{
+			"nrtm.example.org": {Proxy: "direct"},
+			"nrtm.example.net": {Proxy: "socks5://socks.example:1080"},
+		},
+	}
+	for rawURL, expected := range map[string]string{
+		"https://nrtm.example.com/n.json":  "http://proxy.example:8080",
+		"https://nrtm.example.org/n.json":  "",
+		"https://nrtm.example.net/n.json":  "socks5://socks.example:1080",
+		"https://other.example.net/n.json": "",
+	} {
+		u, _ := url.Parse(rawURL)
+		proxy, err := config.proxyFor(u)
+		if err != nil {
+			t.Error("Unexpected error", err)
+		} else if actual := proxyString(proxy); actual != expected {
+			t.Error("Expected proxy for", rawURL, "to be", expected, "but was", actual)
+		}
+	}
+	u, _ := url.Parse("https://nrtm.example.com/n.json")
+	proxy, _ := NetworkConfig{}.proxyFor(u)
+	if proxyString(proxy) != "http://env.proxy.example:3128" {
+		t.Error("Expected the environment proxy when the config has none but was", proxy)
+	}
+	if err := (NetworkConfig{Proxy: "ftp://proxy.example"}).validate(); !errors.Is(err, ErrInvalidProxy) {
+		t.Error("Expected ErrInvalidProxy but was", err)
+	}
+}
+
+func proxyString(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.String()
+}
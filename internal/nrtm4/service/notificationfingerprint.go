@@ -0,0 +1,80 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// notificationRecheckInterval is how long an unchanged notification file is skipped for. After
+// that it's processed in full again, so a server which stops updating its timestamp, or lets
+// the file expire, is still noticed.
+var notificationRecheckInterval = time.Hour
+
+// errNotificationUnchanged the notification file is the same as the last one processed
+var errNotificationUnchanged = errors.New("notification file hasn't changed")
+
+// conditionalClient is a Client which can tell a notification file hasn't changed before it's
+// parsed. It returns errNotificationUnchanged when it's the same as last.
+type conditionalClient interface {
+	getChangedNotification(string, *persist.NotificationFingerprint) (protocol.NotificationJSON, http.Header, persist.NotificationFingerprint, error)
+}
+
+// downloadChangedNotification fetches the notification file, unless it's the same as the last
+// one processed, when it returns errNotificationUnchanged. A fingerprint older than
+// notificationRecheckInterval isn't compared.
+func (fm fileManager) downloadChangedNotification(url string, last *persist.NotificationFingerprint) (protocol.NotificationJSON, http.Header, persist.NotificationFingerprint, error) {
+	if last != nil && util.AppClock.Now().Sub(last.Checked) >= notificationRecheckInterval {
+		last = nil
+	}
+	var notification protocol.NotificationJSON
+	var header http.Header
+	var fp persist.NotificationFingerprint
+	var err error
+	if cc, ok := fm.client.(conditionalClient); ok {
+		notification, header, fp, err = cc.getChangedNotification(url, last)
+	} else {
+		notification, header, err = fm.client.getUpdateNotification(url)
+		fp = newNotificationFingerprint(notification.Raw, header)
+		if err == nil && last != nil && len(fp.Hash) > 0 && fp.Hash == last.Hash {
+			err = errNotificationUnchanged
+		}
+	}
+	if err != nil {
+		if !errors.Is(err, errNotificationUnchanged) {
+			logger.Error("fetching notificationFile", "error", err)
+		}
+		return notification, header, fp, err
+	}
+	err = fm.validateNotificationFile(notification)
+	return notification, header, fp, err
+}
+
+// newNotificationFingerprint identifies the body of a notification file. The hash is empty
+// when there's no body.
+func newNotificationFingerprint(raw []byte, header http.Header) persist.NotificationFingerprint {
+	fp := persist.NotificationFingerprint{ETag: header.Get("ETag"), Checked: util.AppClock.Now()}
+	if len(raw) > 0 {
+		sum := sha256.Sum256(raw)
+		fp.Hash = hex.EncodeToString(sum[:])
+	}
+	return fp
+}
+
+// saveNotificationFingerprint records the notification file which was just processed. It's
+// only bookkeeping, so a failure is a warning.
+func (p NRTMProcessor) saveNotificationFingerprint(source persist.NRTMSource, fp persist.NotificationFingerprint) {
+	if len(fp.Hash) == 0 {
+		return
+	}
+	if err := p.repo.SaveNotificationFingerprint(source, fp); err != nil {
+		logger.Warn("Failed to save notification fingerprint", "source", source.Source, "error", err)
+		p.warnings.add(WarningBookkeeping, 0, "the notification file's hash wasn't saved: %v", err)
+	}
+}
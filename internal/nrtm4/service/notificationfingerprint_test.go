@@ -0,0 +1,79 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+func TestUnchangedNotificationIsSkipped(t *testing.T) {
+	notification, _, _ := dlClientStub{}.getUpdateNotification("")
+	body, err := json.Marshal(notification)
+	if err != nil {
+		t.Fatal("Failed to marshal notification", err)
+	}
+	etag := `"v22"`
+	requests, notModified := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if len(etag) > 0 {
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				notModified++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+	fm := fileManager{client: HTTPClient{}}
+
+	first, _, fp, err := fm.downloadChangedNotification(server.URL, nil)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if first.Version != 22 || len(fp.Hash) == 0 || fp.ETag != etag {
+		t.Fatal("Unexpected notification or fingerprint", first.Version, fp)
+	}
+	if _, _, _, err = fm.downloadChangedNotification(server.URL, &fp); !errors.Is(err, errNotificationUnchanged) {
+		t.Error("Expected errNotificationUnchanged for a matching ETag but was", err)
+	}
+	if notModified != 1 {
+		t.Error("Expected one 304 response but was", notModified)
+	}
+
+	// A server which doesn't send an ETag is compared by hash
+	etag = ""
+	if _, _, _, err = fm.downloadChangedNotification(server.URL, &fp); !errors.Is(err, errNotificationUnchanged) {
+		t.Error("Expected errNotificationUnchanged for a matching hash but was", err)
+	}
+
+	// An old fingerprint isn't trusted, so the file is processed again
+	stale := fp
+	stale.Checked = fp.Checked.Add(-notificationRecheckInterval - time.Minute)
+	again, _, _, err := fm.downloadChangedNotification(server.URL, &stale)
+	if err != nil || again.Version != 22 {
+		t.Error("Expected the notification to be fetched again but was", again.Version, err)
+	}
+	if requests != 4 {
+		t.Error("Expected 4 requests but was", requests)
+	}
+}
+
+func TestUnconditionalClientFingerprint(t *testing.T) {
+	// Stub clients have no body to hash, so the notification is never treated as unchanged
+	fm := fileManager{client: dlClientStub{}}
+	_, _, fp, err := fm.downloadChangedNotification("https://xxx.xxx.xx/notification.json", &persist.NotificationFingerprint{})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(fp.Hash) > 0 {
+		t.Error("Expected no hash but was", fp.Hash)
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+var (
+	// ErrNRTM4NotificationTimestampInvalid the notification timestamp is not RFC3339
+	ErrNRTM4NotificationTimestampInvalid = errors.New("notification timestamp is not valid")
+	// ErrNRTM4StaleNotification the notification file has not been updated for too long
+	ErrNRTM4StaleNotification = errors.New("notification file is stale")
+	// ErrNRTM4NotificationTimestampInFuture the notification timestamp is later than the server time
+	ErrNRTM4NotificationTimestampInFuture = errors.New("notification timestamp is in the future")
+
+	// notificationMaxAge a server must publish a new notification file at least once a day
+	notificationMaxAge = 24 * time.Hour
+	// defaultAllowedClockSkew is used when the config doesn't say otherwise
+	defaultAllowedClockSkew = 5 * time.Minute
+)
+
+// estimateClockSkew returns how far the local clock is ahead of the server's, using the
+// Date header in its response. It's zero if the server didn't send one.
+func estimateClockSkew(header http.Header, localNow time.Time) time.Duration {
+	if header == nil {
+		return 0
+	}
+	serverTime, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return 0
+	}
+	return localNow.Sub(serverTime)
+}
+
+// validateNotificationTimestamp checks the notification's age against the server's clock,
+// allowing for `allowedSkew` in either direction
+func validateNotificationTimestamp(notification protocol.NotificationJSON, skew time.Duration, localNow time.Time, allowedSkew time.Duration) error {
+	ts, err := time.Parse(time.RFC3339, notification.Timestamp)
+	if err != nil {
+		return ErrNRTM4NotificationTimestampInvalid
+	}
+	age := localNow.Add(-skew).Sub(ts)
+	if age > notificationMaxAge+allowedSkew {
+		return ErrNRTM4StaleNotification
+	}
+	if age < -allowedSkew {
+		return ErrNRTM4NotificationTimestampInFuture
+	}
+	return nil
+}
+
+// checkNotificationTimestamp logs a warning if the notification looks stale, or returns an
+// error when the strictness of the source named sourceName is strict. The server's Date header
+// is used to correct for a drifting local clock.
+func (p NRTMProcessor) checkNotificationTimestamp(sourceName string, notification protocol.NotificationJSON, header http.Header) error {
+	allowedSkew := p.config.AllowedClockSkew
+	if allowedSkew <= 0 {
+		allowedSkew = defaultAllowedClockSkew
+	}
+	now := util.AppClock.Now()
+	skew := estimateClockSkew(header, now)
+	if skew > allowedSkew || skew < -allowedSkew {
+		logger.Warn("Local clock differs from the server's clock", "source", notification.Source, "skew", skew.Round(time.Second))
+		p.warnings.add(WarningServer, notification.Version, "local clock differs from the server's by %v", skew.Round(time.Second))
+	}
+	err := validateNotificationTimestamp(notification, skew, now, allowedSkew)
+	if err != nil && p.config.strictness(sourceName) == StrictnessStrict {
+		logger.Error("Notification timestamp check failed", "source", notification.Source, "timestamp", notification.Timestamp, "error", err)
+		return err
+	}
+	if err != nil {
+		logger.Warn("Notification timestamp check failed", "source", notification.Source, "timestamp", notification.Timestamp, "error", err)
+		p.warnings.add(WarningServer, notification.Version, "notification timestamp %v: %v", notification.Timestamp, err)
+	}
+	return nil
+}
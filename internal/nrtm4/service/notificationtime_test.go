@@ -0,0 +1,48 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+func TestEstimateClockSkew(t *testing.T) {
+	now := time.Date(2025, 1, 20, 12, 0, 0, 0, time.UTC)
+	header := http.Header{}
+	if skew := estimateClockSkew(header, now); skew != 0 {
+		t.Error("Expected no skew without a Date header but was", skew)
+	}
+	header.Set("Date", now.Add(-3*time.Minute).Format(http.TimeFormat))
+	if skew := estimateClockSkew(header, now); skew != 3*time.Minute {
+		t.Error("Expected local clock to be 3m ahead but was", skew)
+	}
+}
+
+func TestValidateNotificationTimestamp(t *testing.T) {
+	now := time.Date(2025, 1, 20, 12, 0, 0, 0, time.UTC)
+	notification := protocol.NotificationJSON{}
+	type expectation struct {
+		timestamp string
+		skew      time.Duration
+		expected  error
+	}
+	expectations := []expectation{
+		{"2025-01-20T11:59:00Z", 0, nil},
+		{"not a timestamp", 0, ErrNRTM4NotificationTimestampInvalid},
+		{"2025-01-19T11:50:00Z", 0, ErrNRTM4StaleNotification},
+		// Local clock is an hour fast, so the notification is really only 23h10m old
+		{"2025-01-19T11:50:00Z", time.Hour, nil},
+		{"2025-01-20T12:30:00Z", 0, ErrNRTM4NotificationTimestampInFuture},
+		// Local clock is an hour slow
+		{"2025-01-20T12:30:00Z", -time.Hour, nil},
+	}
+	for _, e := range expectations {
+		notification.Timestamp = e.timestamp
+		err := validateNotificationTimestamp(notification, e.skew, now, 5*time.Minute)
+		if err != e.expected {
+			t.Error("Expected", e.expected, "but was", err, "for", e.timestamp, e.skew)
+		}
+	}
+}
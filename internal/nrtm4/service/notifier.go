@@ -0,0 +1,63 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// Notifier sends messages to operators
+type Notifier interface {
+	Notify(subject string, message string) error
+}
+
+// NewNotifier returns a Notifier which sends email if SMTP is configured, otherwise one
+// that writes messages to the log
+func NewNotifier(config NotifyConfig) Notifier {
+	if config.SMTP != nil && len(config.SMTP.Address) > 0 && len(config.SMTP.To) > 0 {
+		return smtpNotifier{*config.SMTP}
+	}
+	return logNotifier{}
+}
+
+// newNotifier is the processor's notifier, or the one in its config
+func (p NRTMProcessor) newNotifier() Notifier {
+	if p.notifier != nil {
+		return p.notifier
+	}
+	return NewNotifier(p.config.Notify)
+}
+
+type logNotifier struct{}
+
+func (n logNotifier) Notify(subject string, message string) error {
+	logger.Info("Notification", "subject", subject, "message", message)
+	return nil
+}
+
+type smtpNotifier struct {
+	config SMTPConfig
+}
+
+func (n smtpNotifier) Notify(subject string, message string) error {
+	var auth smtp.Auth
+	if len(n.config.Username) > 0 {
+		host, _, err := net.SplitHostPort(n.config.Address)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", n.config.Username, n.config.Password, host)
+	}
+	return smtp.SendMail(n.config.Address, auth, n.config.From, n.config.To, n.formatMail(subject, message))
+}
+
+func (n smtpNotifier) formatMail(subject string, message string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %v\r\n", n.config.From)
+	fmt.Fprintf(&b, "To: %v\r\n", strings.Join(n.config.To, ", "))
+	fmt.Fprintf(&b, "Subject: %v\r\n", subject)
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(message, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -21,4 +21,8 @@ var (
 	ErrNRTM4NotificationVersionDoesNotMatchDelta = errors.New("highest delta version is not the notification version")
 	// ErrNRTM4DuplicateDeltaVersion the highest delta version is not the notification version
 	ErrNRTM4DuplicateDeltaVersion = errors.New("notification file published a duplicate delta file")
+	// ErrNRTM4DeltaAlreadyApplied a delta has the same hash as one which was applied before
+	ErrNRTM4DeltaAlreadyApplied = errors.New("delta file has already been applied")
+	// ErrNRTM4ObjectNotInRepo a delta deleted an object which the repo doesn't have
+	ErrNRTM4ObjectNotInRepo = errors.New("object is not in the repo")
 )
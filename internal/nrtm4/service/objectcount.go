@@ -0,0 +1,79 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+// ErrInvalidObjectCount the object count range has a negative bound, or its max is below its min
+var ErrInvalidObjectCount = errors.New("object_count min and max must be positive, with max at least min")
+
+// ObjectCountConfig is the range a source's number of objects is expected to stay in. A count
+// outside it after an update usually means a mistake upstream, like a truncated snapshot or a
+// mass delete, rather than real change.
+type ObjectCountConfig struct {
+	Min int `json:"min"`
+	// Max is the most objects expected. Zero means there's no upper bound.
+	Max int `json:"max"`
+	// Pause stops the source being updated until it's resumed, so no more changes are applied on
+	// top of the mistake before someone has looked at it
+	Pause bool `json:"pause"`
+}
+
+func (c *ObjectCountConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Min < 0 || c.Max < 0 || (c.Max > 0 && c.Max < c.Min) {
+		return fmt.Errorf("%w: min %d, max %d", ErrInvalidObjectCount, c.Min, c.Max)
+	}
+	return nil
+}
+
+// outside returns a description of how count is out of the range, or "" if it's in it
+func (c ObjectCountConfig) outside(count int) string {
+	switch {
+	case count < c.Min:
+		return fmt.Sprintf("%d objects, fewer than the minimum of %d", count, c.Min)
+	case c.Max > 0 && count > c.Max:
+		return fmt.Sprintf("%d objects, more than the maximum of %d", count, c.Max)
+	}
+	return ""
+}
+
+// checkObjectCount counts a source's objects after an update which changed its version, and when
+// the count is outside the range in its config, tells the operators and pauses it if the config
+// says to. Sources without a range aren't counted.
+func (p NRTMProcessor) checkObjectCount(source persist.NRTMSource, version uint32) {
+	cfg := p.config.sourceConfig(source.Source).ObjectCount
+	if cfg == nil {
+		return
+	}
+	count, err := p.repo.CountCurrentObjects(source)
+	if err != nil {
+		logger.Warn("Cannot count objects", "source", source.Source, "label", source.Label, "error", err)
+		return
+	}
+	problem := cfg.outside(count)
+	if len(problem) == 0 {
+		return
+	}
+	logger.Warn("Object count is out of range", "source", source.Source, "label", source.Label, "version", version,
+		"objects", count, "min", cfg.Min, "max", cfg.Max)
+	p.warnings.add(WarningObjectCount, version, "source has %v", problem)
+	message := fmt.Sprintf("Source  : %v\nVersion : %d\n\nThe source has %v.\n", sourceDisplayName(source), version, problem)
+	if cfg.Pause {
+		if err = p.pauseSource(source, true); err != nil {
+			logger.Error("Failed to pause source", "source", source.Source, "label", source.Label, "error", err)
+		} else {
+			logger.Warn("Source paused", "source", source.Source, "label", source.Label)
+			message += "It has been paused, and won't be updated until it's resumed.\n"
+		}
+	}
+	subject := fmt.Sprintf("NRTMv4 object count out of range for %v", sourceDisplayName(source))
+	if err = p.newNotifier().Notify(subject, message); err != nil {
+		logger.Warn("Failed to send object count notification", "source", source.Source, "error", err)
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+// countRepo has a number of objects, and records whether the source was paused
+type countRepo struct {
+	persist.Repository
+	objects int
+	paused  *bool
+}
+
+func (r countRepo) CountCurrentObjects(persist.NRTMSource) (int, error) {
+	return r.objects, nil
+}
+
+func (r countRepo) PauseSource(_ persist.NRTMSource, paused bool) error {
+	*r.paused = paused
+	return nil
+}
+
+func TestCheckObjectCount(t *testing.T) {
+	source := persist.NRTMSource{Source: "EXAMPLE"}
+	for _, tc := range []struct {
+		objects int
+		config  ObjectCountConfig
+		alert   bool
+		paused  bool
+	}{
+		{500, ObjectCountConfig{Min: 100, Max: 1000, Pause: true}, false, false},
+		{50, ObjectCountConfig{Min: 100, Max: 1000}, true, false},
+		{5000, ObjectCountConfig{Min: 100, Max: 1000, Pause: true}, true, true},
+		{5000, ObjectCountConfig{Min: 100}, false, false},
+	} {
+		paused := false
+		alerts := &alertRecorder{}
+		p := NRTMProcessor{
+			config:   AppConfig{Sources: map[string]SourceConfig{"example": {ObjectCount: &tc.config}}},
+			repo:     countRepo{objects: tc.objects, paused: &paused},
+			warnings: &syncWarnings{},
+			notifier: alerts,
+		}
+		p.checkObjectCount(source, 7)
+		warnings := p.warnings.all()
+		if sent := len(alerts.take()) > 0; sent != tc.alert || (len(warnings) > 0) != tc.alert {
+			t.Error("Expected an alert and warning", tc.alert, "for", tc.objects, "objects in", tc.config, "but was", sent, warnings)
+		}
+		if paused != tc.paused {
+			t.Error("Expected paused to be", tc.paused, "for", tc.objects, "objects in", tc.config)
+		}
+	}
+}
+
+func TestObjectCountConfigValidate(t *testing.T) {
+	for _, c := range []ObjectCountConfig{{Min: -1}, {Max: -1}, {Min: 10, Max: 5}} {
+		if err := c.validate(); !errors.Is(err, ErrInvalidObjectCount) {
+			t.Error("Expected ErrInvalidObjectCount for", c, "but was", err)
+		}
+	}
+	if err := (&ObjectCountConfig{Min: 10}).validate(); err != nil {
+		t.Error("Unexpected error", err)
+	}
+}
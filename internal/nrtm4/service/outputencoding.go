@@ -0,0 +1,176 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// OutputEncoding is how RPSL text is encoded when it's written for other tools. Registries'
+// objects are UTF-8, but some hold characters, or bytes which aren't UTF-8 at all, that older
+// parsers can't read.
+type OutputEncoding string
+
+const (
+	// EncodingUTF8 writes the text as it is stored
+	EncodingUTF8 OutputEncoding = "utf-8"
+	// EncodingLatin1 writes ISO-8859-1. Characters outside it are transliterated, e.g. ł to l,
+	// or replaced with ? when there's nothing close.
+	EncodingLatin1 OutputEncoding = "latin-1"
+	// EncodingEscape writes printable ASCII. Other characters are escaped as \uXXXX, and control
+	// characters and bytes which aren't UTF-8 as \xXX. Tabs and newlines are kept.
+	EncodingEscape OutputEncoding = "escape"
+)
+
+// ErrInvalidOutputEncoding the output encoding isn't one of utf-8, latin-1 or escape
+var ErrInvalidOutputEncoding = errors.New("output encoding must be utf-8, latin-1 or escape")
+
+// transliterations are characters outside Latin-1 which don't decompose into one
+var transliterations = map[rune]string{
+	'Ł': "L", 'ł': "l", 'Đ': "D", 'đ': "d", 'Ħ': "H", 'ħ': "h", 'ı': "i", 'Œ': "OE", 'œ': "oe",
+	'‘': "'", '’': "'", '‚': "'", '“': "\"", '”': "\"", '„': "\"", '–': "-", '—': "-", '‐': "-",
+	'…': "...", '•': "*", '€': "EUR", '™': "TM",
+}
+
+// ParseOutputEncoding reads an output encoding. An empty string is EncodingUTF8.
+func ParseOutputEncoding(s string) (OutputEncoding, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "utf-8", "utf8":
+		return EncodingUTF8, nil
+	case "latin-1", "latin1", "iso-8859-1":
+		return EncodingLatin1, nil
+	case "escape":
+		return EncodingEscape, nil
+	}
+	return EncodingUTF8, fmt.Errorf("%w: %v", ErrInvalidOutputEncoding, s)
+}
+
+// Charset is the name of the encoding for a Content-Type header
+func (e OutputEncoding) Charset() string {
+	switch e {
+	case EncodingLatin1:
+		return "iso-8859-1"
+	case EncodingEscape:
+		return "us-ascii"
+	}
+	return "utf-8"
+}
+
+// Encode converts text to the encoding
+func (e OutputEncoding) Encode(text string) string {
+	if e != EncodingLatin1 && e != EncodingEscape {
+		return text
+	}
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r == utf8.RuneError && size == 1 {
+			if e == EncodingLatin1 {
+				b.WriteByte('?')
+			} else {
+				fmt.Fprintf(&b, "\\x%02X", text[i])
+			}
+		} else if e == EncodingLatin1 {
+			b.WriteString(toLatin1(r))
+		} else {
+			b.WriteString(escapeRune(r))
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// toLatin1 is the ISO-8859-1 byte for r, or its transliteration
+func toLatin1(r rune) string {
+	if r <= 0xFF {
+		return string([]byte{byte(r)})
+	}
+	if t, ok := transliterations[r]; ok {
+		return t
+	}
+	var b strings.Builder
+	for _, d := range norm.NFKD.String(string(r)) {
+		if d <= 0xFF && !unicode.Is(unicode.Mn, d) {
+			b.WriteByte(byte(d))
+		}
+	}
+	if b.Len() == 0 {
+		return "?"
+	}
+	return b.String()
+}
+
+func escapeRune(r rune) string {
+	switch {
+	case r == '\t' || r == '\n' || (r >= 0x20 && r < 0x7F):
+		return string(r)
+	case r < 0x20 || r == 0x7F:
+		return fmt.Sprintf("\\x%02X", r)
+	case r > 0xFFFF:
+		return fmt.Sprintf("\\U%08X", r)
+	}
+	return fmt.Sprintf("\\u%04X", r)
+}
+
+// encodingWriter encodes what's written to it. A UTF-8 sequence split between writes is held
+// back until the rest of it arrives, or the writer is closed.
+type encodingWriter struct {
+	w        io.Writer
+	encoding OutputEncoding
+	partial  []byte
+}
+
+// passThroughWriter writes UTF-8 to w as it is
+type passThroughWriter struct {
+	io.Writer
+}
+
+func (passThroughWriter) Close() error {
+	return nil
+}
+
+// NewEncodingWriter returns a writer which writes to w in the encoding. It must be closed once
+// everything has been written, to write the end of a UTF-8 sequence which was cut off. Closing
+// it doesn't close w.
+func NewEncodingWriter(w io.Writer, encoding OutputEncoding) io.WriteCloser {
+	if encoding != EncodingLatin1 && encoding != EncodingEscape {
+		return passThroughWriter{w}
+	}
+	return &encodingWriter{w: w, encoding: encoding}
+}
+
+func (ew *encodingWriter) Write(p []byte) (int, error) {
+	buf := append(ew.partial, p...)
+	end := len(buf)
+	// Back up over the start of a sequence which isn't complete yet
+	for i := 1; i < utf8.UTFMax && i <= len(buf); i++ {
+		c := buf[len(buf)-i]
+		if utf8.RuneStart(c) {
+			if !utf8.FullRune(buf[len(buf)-i:]) {
+				end = len(buf) - i
+			}
+			break
+		}
+	}
+	ew.partial = append([]byte{}, buf[end:]...)
+	if _, err := io.WriteString(ew.w, ew.encoding.Encode(string(buf[:end]))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes a UTF-8 sequence which was never completed. Its bytes aren't UTF-8, so they're
+// written as ? in Latin-1, or as \xXX when escaping.
+func (ew *encodingWriter) Close() error {
+	if len(ew.partial) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(ew.w, ew.encoding.Encode(string(ew.partial)))
+	ew.partial = nil
+	return err
+}
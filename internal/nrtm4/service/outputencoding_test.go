@@ -0,0 +1,83 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestOutputEncodingEncode(t *testing.T) {
+	text := "descr: Łódź Café\u2019s\tnet\x01\nremarks: \xff 日本\n"
+	tests := []struct {
+		encoding OutputEncoding
+		expected string
+	}{
+		{EncodingUTF8, text},
+		{EncodingLatin1, "descr: L\xf3dz Caf\xe9's\tnet\x01\nremarks: ? ??\n"},
+		{EncodingEscape, "descr: \\u0141\\u00F3d\\u017A Caf\\u00E9\\u2019s\tnet\\x01\nremarks: \\xFF \\u65E5\\u672C\n"},
+	}
+	for _, tt := range tests {
+		if got := tt.encoding.Encode(text); got != tt.expected {
+			t.Errorf("%v: expected %q but was %q", tt.encoding, tt.expected, got)
+		}
+	}
+}
+
+func TestEncodingWriterSplitRune(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewEncodingWriter(&buf, EncodingEscape)
+	text := []byte("descr: Café €\n")
+	// Write a byte at a time so every multi-byte character is split
+	for i := range text {
+		if n, err := w.Write(text[i : i+1]); err != nil || n != 1 {
+			t.Fatal("Write returned", n, err)
+		}
+	}
+	expected := "descr: Caf\\u00E9 \\u20AC\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q but was %q", expected, buf.String())
+	}
+	if w, ok := NewEncodingWriter(&buf, EncodingUTF8).(passThroughWriter); !ok || w.Writer != &buf {
+		t.Error("Expected utf-8 to write straight through")
+	}
+}
+
+func TestEncodingWriterCloseWritesCutOffRune(t *testing.T) {
+	for _, tt := range []struct {
+		encoding OutputEncoding
+		expected string
+	}{
+		{EncodingEscape, "descr: Caf\\xC3"},
+		{EncodingLatin1, "descr: Caf?"},
+	} {
+		var buf bytes.Buffer
+		w := NewEncodingWriter(&buf, tt.encoding)
+		// The é is cut off after its first byte
+		if _, err := w.Write([]byte("descr: Caf\xC3")); err != nil {
+			t.Fatal("Write failed", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal("Close failed", err)
+		}
+		if buf.String() != tt.expected {
+			t.Errorf("%v: expected %q but was %q", tt.encoding, tt.expected, buf.String())
+		}
+	}
+}
+
+func TestParseOutputEncoding(t *testing.T) {
+	for s, expected := range map[string]OutputEncoding{
+		"":           EncodingUTF8,
+		"UTF8":       EncodingUTF8,
+		"latin1":     EncodingLatin1,
+		"ISO-8859-1": EncodingLatin1,
+		"escape":     EncodingEscape,
+	} {
+		if got, err := ParseOutputEncoding(s); err != nil || got != expected {
+			t.Errorf("%q: expected %v but was %v %v", s, expected, got, err)
+		}
+	}
+	if _, err := ParseOutputEncoding("ebcdic"); !errors.Is(err, ErrInvalidOutputEncoding) {
+		t.Error("Expected ErrInvalidOutputEncoding but was", err)
+	}
+}
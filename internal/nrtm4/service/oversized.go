@@ -0,0 +1,99 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/jsonseq"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// defaultMaxObjectSize is the largest snapshot or delta record which is applied when
+// max_object_size isn't set. The largest objects in the big registries are well under this.
+const defaultMaxObjectSize = 8 << 20
+
+func (c AppConfig) maxObjectSize() int {
+	if c.MaxObjectSize > 0 {
+		return c.MaxObjectSize
+	}
+	return defaultMaxObjectSize
+}
+
+// quarantineOversized wraps fn so records which are larger than max_object_size are saved in
+// the repo, with a warning, instead of being passed on. fm must be reading with the same limit.
+// A file's header can't be left out, so an oversized header is an error.
+func (p NRTMProcessor) quarantineOversized(source persist.NRTMSource, version uint32, fn jsonseq.RecordReaderFunc) jsonseq.RecordReaderFunc {
+	header := true
+	return func(record []byte, err error) error {
+		isHeader := header
+		header = false
+		var tooLarge *jsonseq.RecordTooLargeError
+		if !errors.As(err, &tooLarge) {
+			return fn(record, err)
+		}
+		if isHeader {
+			return fmt.Errorf("file header: %w", err)
+		}
+		obj := persist.OversizedObject{
+			Version:     version,
+			Size:        tooLarge.Size,
+			RecordStart: strings.ToValidUTF8(strings.ReplaceAll(string(record), "\x00", ""), ""),
+			Quarantined: util.AppClock.Now(),
+		}
+		obj.ObjectType, obj.PrimaryKey = oversizedObjectKey(record)
+		logger.Warn("Quarantining oversized object", "source", source.Source, "version", version,
+			"type", obj.ObjectType, "key", obj.PrimaryKey, "size", obj.Size, "limit", tooLarge.Limit)
+		p.warnings.add(WarningOversizedObject, version, "%v %v is %d bytes, more than max_object_size %d",
+			obj.ObjectType, obj.PrimaryKey, obj.Size, tooLarge.Limit)
+		if err := p.repo.SaveOversizedObject(source, obj); err != nil {
+			logger.Error("Cannot quarantine oversized object", "source", source.Source, "version", version, "error", err)
+			p.warnings.add(WarningBookkeeping, version, "oversized %v %v wasn't quarantined: %v", obj.ObjectType, obj.PrimaryKey, err)
+		}
+		return nil
+	}
+}
+
+// oversizedObjectKey takes the object type and primary key from the first line of the object in
+// the start of a record. The key is the value of the first attribute, which is the whole key for
+// most object types. Both are empty if the first line isn't in record.
+func oversizedObjectKey(record []byte) (string, string) {
+	const key = `"object"`
+	i := bytes.Index(record, []byte(key))
+	if i < 0 {
+		return "", ""
+	}
+	rest := record[i+len(key):]
+	quote := bytes.IndexByte(rest, '"')
+	if quote < 0 || string(bytes.TrimSpace(rest[:quote])) != ":" {
+		return "", ""
+	}
+	rest = rest[quote+1:]
+	end := bytes.Index(rest, []byte(`\n`))
+	if end < 0 {
+		return "", ""
+	}
+	var line string
+	if err := json.Unmarshal(append(append([]byte{'"'}, rest[:end]...), '"'), &line); err != nil {
+		return "", ""
+	}
+	name, value, ok := strings.Cut(line, ":")
+	if !ok {
+		return "", ""
+	}
+	return strings.ToUpper(strings.TrimSpace(name)), strings.ToUpper(strings.TrimSpace(value))
+}
+
+// GetOversizedObjects lists the records of a source which were quarantined because they were
+// larger than max_object_size
+func (p NRTMProcessor) GetOversizedObjects(sourceName, label string) ([]persist.OversizedObject, error) {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return nil, ErrSourceNotFound
+	}
+	return p.repo.GetOversizedObjects(*source)
+}
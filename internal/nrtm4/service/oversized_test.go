@@ -0,0 +1,75 @@
+package service
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/jsonseq"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/quirks"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+type oversizedRepo struct {
+	saveSourceRepo
+	saved    *[]persist.OversizedObject
+	modified *[]string
+}
+
+func (r oversizedRepo) SaveOversizedObject(source persist.NRTMSource, obj persist.OversizedObject) error {
+	*r.saved = append(*r.saved, obj)
+	return nil
+}
+
+func (r oversizedRepo) AddModifyObject(source persist.NRTMSource, obj rpsl.Rpsl, file protocol.NrtmFileJSON) error {
+	*r.modified = append(*r.modified, obj.PrimaryKey)
+	return nil
+}
+
+func TestQuarantineOversized(t *testing.T) {
+	sessionID := "ca128382-78d9-41d1-8927-1ecef15275be"
+	source := persist.NRTMSource{Source: "EXAMPLE", SessionID: sessionID, Version: 2}
+	big := `{"action": "add_modify", "object": "as-set: AS-BIG\nmembers: ` + strings.Repeat("AS65000, ", 1000) + `\nsource: EXAMPLE"}`
+	small := `{"action": "add_modify", "object": "route: 192.0.2.0/24\norigin: AS65000\nsource: EXAMPLE"}`
+	header := `{"nrtm_version": 4, "type": "delta", "source": "EXAMPLE", "session_id": "` + sessionID + `", "version": 3}`
+	var saved []persist.OversizedObject
+	var modified []string
+	repo := oversizedRepo{saved: &saved, modified: &modified}
+	for _, records := range [][]string{{header, big, small}, {header, small, big}} {
+		saved, modified = nil, nil
+		warnings := &syncWarnings{}
+		p := NRTMProcessor{repo: repo, warnings: warnings}
+		fn := applyDeltaFunc(repo, source, nil, nil, StrictnessStandard, quirks.Set{}, protocol.NotificationJSON{}, protocol.FileRefJSON{Version: 3}, deltaEventSink{}, new(protocol.DeltaFileJSON), warnings)
+		seq := "\x1e" + strings.Join(records, "\n\x1e") + "\n"
+		reader := bufio.NewReaderSize(strings.NewReader(seq), 16)
+		if err := jsonseq.ReadRecordsLimit(reader, 1000, p.quarantineOversized(source, 3, fn)); err != io.EOF {
+			t.Fatal("Expected the oversized record to be quarantined but got", err)
+		}
+		if len(saved) != 1 || saved[0].ObjectType != "AS-SET" || saved[0].PrimaryKey != "AS-BIG" || saved[0].Size < 9000 || len(saved[0].RecordStart) != 1000 {
+			t.Error("Unexpected quarantined object", saved)
+		}
+		if len(modified) != 1 || modified[0] != "192.0.2.0/24AS65000" {
+			t.Error("Expected the small object to be applied", modified)
+		}
+		if all := warnings.all(); len(all) != 1 || all[0].Kind != WarningOversizedObject {
+			t.Error("Expected an oversized_object warning", all)
+		}
+	}
+}
+
+func TestOversizedObjectKey(t *testing.T) {
+	for record, expected := range map[string][2]string{
+		`{"object": "route: 192.0.2.0/24\norigin`:      {"ROUTE", "192.0.2.0/24"},
+		`{"action":"add_modify","object":"mntner: M`:   {"", ""},
+		`{"action":"add_modify","object":"mntner: M\n`: {"MNTNER", "M"},
+		`{"members": "x"`: {"", ""},
+	} {
+		objectType, primaryKey := oversizedObjectKey([]byte(record))
+		if objectType != expected[0] || primaryKey != expected[1] {
+			t.Error("Expected", expected, "from", record, "but was", objectType, primaryKey)
+		}
+	}
+}
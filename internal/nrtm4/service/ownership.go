@@ -0,0 +1,88 @@
+package service
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// CSVFormat is an ownership report export format
+const CSVFormat = "csv"
+
+// The attributes an ownership report counts objects by
+var ownershipAttributes = []string{"mnt-by", "org"}
+
+// OwnershipReport counts a source's objects by maintainer and organisation, for reviewing which
+// maintainers look after most of the registry and which have been busy lately
+type OwnershipReport struct {
+	Source      string       `json:"source"`
+	Label       string       `json:"label"`
+	Since       time.Time    `json:"since"`
+	FromVersion uint32       `json:"from_version"`
+	ToVersion   uint32       `json:"to_version"`
+	Owners      []OwnerCount `json:"owners"`
+}
+
+// OwnerCount is how many current objects reference a maintainer or organisation, and how many
+// changes since the start of the report were made to objects which reference it
+type OwnerCount struct {
+	Attribute string `json:"attribute"`
+	Owner     string `json:"owner"`
+	Objects   int    `json:"objects"`
+	Changes   int    `json:"changes"`
+}
+
+// Ownership counts the source's current objects by their mnt-by and org attributes, with the
+// changes made in the recent period
+func (p NRTMProcessor) Ownership(sourceName, label string, recent time.Duration) (OwnershipReport, error) {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return OwnershipReport{}, ErrSourceNotFound
+	}
+	since := util.AppClock.Now().Add(-recent)
+	counts, err := p.repo.GetOwnerCounts(*source, ownershipAttributes, since)
+	if err != nil {
+		return OwnershipReport{}, err
+	}
+	report := OwnershipReport{
+		Source:      source.Source,
+		Label:       source.Label,
+		Since:       since,
+		FromVersion: counts.FromVersion,
+		ToVersion:   counts.ToVersion,
+		Owners:      make([]OwnerCount, len(counts.Owners)),
+	}
+	for i, oc := range counts.Owners {
+		report.Owners[i] = OwnerCount(oc)
+	}
+	return report, nil
+}
+
+// WriteOwnership writes an ownership report as CSV, one row per owner, or JSON
+func WriteOwnership(w io.Writer, report OwnershipReport, format string) error {
+	switch format {
+	case JSONFormat:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case CSVFormat:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"attribute", "owner", "objects", "changes"}); err != nil {
+			return err
+		}
+		for _, oc := range report.Owners {
+			row := []string{oc.Attribute, oc.Owner, strconv.Itoa(oc.Objects), strconv.Itoa(oc.Changes)}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return ErrExportFormatNotSupported
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+type ownershipRepo struct {
+	persist.Repository
+	attributes []string
+	since      time.Time
+}
+
+func (r *ownershipRepo) GetSources() ([]persist.NRTMSource, error) {
+	return []persist.NRTMSource{{ID: 1, Source: "TEST", Version: 120}}, nil
+}
+
+func (r *ownershipRepo) GetOwnerCounts(source persist.NRTMSource, attributes []string, since time.Time) (persist.OwnerCounts, error) {
+	r.attributes = attributes
+	r.since = since
+	return persist.OwnerCounts{
+		FromVersion: 100,
+		ToVersion:   source.Version,
+		Owners: []persist.OwnerCount{
+			{Attribute: "mnt-by", Owner: "EXAMPLE-MNT", Objects: 12, Changes: 3},
+			{Attribute: "org", Owner: "ORG-EX1-TEST", Objects: 4},
+		},
+	}, nil
+}
+
+func TestOwnership(t *testing.T) {
+	repo := &ownershipRepo{}
+	p := NRTMProcessor{repo: repo}
+	before := util.AppClock.Now()
+	report, err := p.Ownership("test", "", 24*time.Hour)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if !slices.Equal(repo.attributes, []string{"mnt-by", "org"}) {
+		t.Error("Expected mnt-by and org to be counted but was", repo.attributes)
+	}
+	if repo.since.Before(before.Add(-24*time.Hour)) || repo.since.After(util.AppClock.Now().Add(-24*time.Hour)) {
+		t.Error("Expected changes to be counted from a day ago but was", repo.since)
+	}
+	if report.Source != "TEST" || report.FromVersion != 100 || report.ToVersion != 120 || len(report.Owners) != 2 {
+		t.Error("Unexpected report", report)
+	}
+	if _, err = p.Ownership("OTHER", "", time.Hour); !errors.Is(err, ErrSourceNotFound) {
+		t.Error("Expected ErrSourceNotFound but was", err)
+	}
+}
+
+func TestWriteOwnership(t *testing.T) {
+	report := OwnershipReport{
+		Source: "TEST",
+		Owners: []OwnerCount{
+			{Attribute: "mnt-by", Owner: "EXAMPLE-MNT", Objects: 12, Changes: 3},
+			{Attribute: "org", Owner: "ORG-EX1-TEST", Objects: 4},
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteOwnership(&buf, report, CSVFormat); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	expected := "attribute,owner,objects,changes\nmnt-by,EXAMPLE-MNT,12,3\norg,ORG-EX1-TEST,4,0\n"
+	if buf.String() != expected {
+		t.Error("Expected", expected, "but was", buf.String())
+	}
+	if err := WriteOwnership(&buf, report, "xml"); !errors.Is(err, ErrExportFormatNotSupported) {
+		t.Error("Expected ErrExportFormatNotSupported but was", err)
+	}
+}
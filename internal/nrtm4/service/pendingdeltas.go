@@ -0,0 +1,46 @@
+package service
+
+import (
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+// queuePendingDeltas saves the deltas an update is about to apply, so a restart part way through
+// can carry on with them. An empty list clears the queue. Failing to save it only means a
+// restart fetches the notification file again.
+func (p NRTMProcessor) queuePendingDeltas(source persist.NRTMSource, refs []protocol.FileRefJSON) {
+	if err := p.repo.SavePendingDeltas(source, refs); err != nil {
+		logger.Warn("Failed to save pending deltas", "source", source.Source, "error", err)
+		p.warnings.add(WarningBookkeeping, 0, "pending deltas weren't saved: %v", err)
+	}
+}
+
+// resumePendingDeltas applies the deltas which were queued when an update was interrupted,
+// without fetching the notification file again. Deltas at or before the source's version were
+// applied before the interruption, so they're skipped. It returns false if there's nothing to
+// resume.
+func (p NRTMProcessor) resumePendingDeltas(source persist.NRTMSource) (bool, error) {
+	refs, err := p.repo.GetPendingDeltas(source)
+	if err != nil {
+		logger.Warn("Cannot read pending deltas", "source", source.Source, "error", err)
+		return false, nil
+	}
+	if len(refs) == 0 {
+		return false, nil
+	}
+	// The notification is saved with the source as each delta is applied, so the last one is
+	// the one the queue came from
+	notification := p.lastNotification(source)
+	if notification == nil || notification.SessionID != source.SessionID || refs[0].Version != source.Version+1 {
+		logger.Info("Discarding pending deltas which don't follow on from the source", "source", source.Source,
+			"version", source.Version, "pending", refs[0].Version)
+		p.queuePendingDeltas(source, nil)
+		return false, nil
+	}
+	logger.Info("Resuming interrupted update", "source", source.Source, "from", refs[0].Version, "to", refs[len(refs)-1].Version)
+	if err = applyDeltas(p, *notification, source, refs); err != nil {
+		return true, err
+	}
+	p.checkChangeRate(source)
+	return true, nil
+}
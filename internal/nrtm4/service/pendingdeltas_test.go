@@ -0,0 +1,123 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+type pendingDeltasRepo struct {
+	persist.Repository
+	pending      []protocol.FileRefJSON
+	notification protocol.NotificationJSON
+	saved        *[][]protocol.FileRefJSON
+	applied      *[]string
+}
+
+func (r pendingDeltasRepo) GetPendingDeltas(source persist.NRTMSource) ([]protocol.FileRefJSON, error) {
+	refs := []protocol.FileRefJSON{}
+	for _, ref := range r.pending {
+		if ref.Version > source.Version {
+			refs = append(refs, ref)
+		}
+	}
+	return refs, nil
+}
+
+func (r pendingDeltasRepo) SavePendingDeltas(source persist.NRTMSource, refs []protocol.FileRefJSON) error {
+	*r.saved = append(*r.saved, refs)
+	return nil
+}
+
+func (r pendingDeltasRepo) GetNotificationHistory(source persist.NRTMSource, limit, before uint32) ([]persist.Notification, error) {
+	return []persist.Notification{{Version: r.notification.Version, Payload: r.notification}}, nil
+}
+
+func (r pendingDeltasRepo) SaveSource(source persist.NRTMSource, notification protocol.NotificationJSON) (persist.NRTMSource, error) {
+	return source, nil
+}
+
+func (r pendingDeltasRepo) GetFileByHash(source persist.NRTMSource, hash string) (*persist.NRTMFile, error) {
+	return nil, nil
+}
+
+func (r pendingDeltasRepo) SaveFile(file *persist.NRTMFile) error {
+	return nil
+}
+
+func (r pendingDeltasRepo) AddModifyObject(source persist.NRTMSource, obj rpsl.Rpsl, file protocol.NrtmFileJSON) error {
+	*r.applied = append(*r.applied, obj.PrimaryKey)
+	return nil
+}
+
+func (r pendingDeltasRepo) SetAppliedVersion(source persist.NRTMSource, version uint32) error {
+	*r.applied = append(*r.applied, fmt.Sprint("applied version ", version))
+	return nil
+}
+
+func (r pendingDeltasRepo) GetDeltaActivity(source persist.NRTMSource, since time.Time) (persist.DeltaActivity, error) {
+	return persist.DeltaActivity{}, nil
+}
+
+// noNotificationClient fails if the notification file is fetched
+type noNotificationClient struct {
+	stubDeltaClient
+}
+
+func (c noNotificationClient) getUpdateNotification(string) (protocol.NotificationJSON, http.Header, error) {
+	return protocol.NotificationJSON{}, nil, errors.New("notification should not be fetched")
+}
+
+func TestResumePendingDeltas(t *testing.T) {
+	sessionID := "ca128382-78d9-41d1-8927-1ecef15275be"
+	delta := "\x1e" + `{"nrtm_version": 4, "type": "delta", "source": "EXAMPLE", "session_id": "` + sessionID + `", "version": 4}` +
+		"\n\x1e" + `{"action": "add_modify", "object": "route: 192.0.2.0/24\norigin: AS65530\nsource: EXAMPLE\n"}` + "\n"
+	sum := sha256.Sum256([]byte(delta))
+	saved := [][]protocol.FileRefJSON{}
+	applied := []string{}
+	repo := pendingDeltasRepo{
+		pending: []protocol.FileRefJSON{
+			{Version: 3, URL: "https://example.com/nrtm/delta.3.json"},
+			{Version: 4, URL: "https://example.com/nrtm/delta.4.json", Hash: hex.EncodeToString(sum[:])},
+		},
+		notification: protocol.NotificationJSON{NrtmFileJSON: protocol.NrtmFileJSON{Version: 4, SessionID: sessionID, Source: "EXAMPLE"}},
+		saved:        &saved,
+		applied:      &applied,
+	}
+	p := NRTMProcessor{
+		repo:     repo,
+		config:   AppConfig{NRTMFilePath: t.TempDir()},
+		client:   noNotificationClient{stubDeltaClient{responseBody: delta}},
+		warnings: &syncWarnings{},
+	}
+	// Version 3 was applied before the restart
+	source := persist.NRTMSource{ID: 1, Source: "EXAMPLE", SessionID: sessionID, Version: 3, NotificationURL: "https://example.com/nrtm/update-notification-file.json"}
+	if err := p.update(source); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if !slices.Equal(applied, []string{"192.0.2.0/24AS65530", "applied version 4"}) {
+		t.Error("Expected the pending delta to be applied, then made visible, but was", applied)
+	}
+	if len(saved) != 1 || len(saved[0]) != 0 {
+		t.Error("Expected the queue to be cleared but was", saved)
+	}
+
+	// A queue which doesn't follow on from the source is thrown away
+	saved = saved[:0]
+	source.Version = 1
+	if resumed, err := p.resumePendingDeltas(source); resumed || err != nil {
+		t.Error("Expected nothing to be resumed but was", resumed, err)
+	}
+	if len(saved) != 1 || len(saved[0]) != 0 {
+		t.Error("Expected the queue to be cleared but was", saved)
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+// defaultPostSyncTimeout is how long a post_sync command may run before it's killed
+const defaultPostSyncTimeout = 10 * time.Minute
+
+// ErrInvalidPostSync post_sync has neither an SQL file nor a command, the SQL file can't be read
+// or the timeout isn't a duration
+var ErrInvalidPostSync = errors.New("post_sync must have a readable sql file or a command, and a valid timeout")
+
+// PostSyncConfig runs an SQL script, a program or both after each sync which changes a source,
+// e.g. to refresh materialized views which other applications read
+type PostSyncConfig struct {
+	// SQL is the path of a file of SQL statements
+	SQL string `json:"sql"`
+	// InTransaction runs the SQL in the transaction which makes the last delta of the sync
+	// visible to readers, so they never see the new version without whatever the script
+	// refreshes. When catch-up loads a snapshot instead, the SQL runs in a transaction of its own.
+	InTransaction bool `json:"in_transaction"`
+	// Command and its arguments, run after the SQL. NRTM4_SOURCE, NRTM4_LABEL,
+	// NRTM4_FROM_VERSION and NRTM4_VERSION are added to its environment.
+	Command []string `json:"command"`
+	// Timeout is how long the command may run before it's killed, e.g. "2m". Default is 10 minutes.
+	Timeout string `json:"timeout"`
+}
+
+func (c *PostSyncConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.SQL) == 0 && len(c.Command) == 0 {
+		return ErrInvalidPostSync
+	}
+	if len(c.SQL) > 0 {
+		if _, err := os.Stat(c.SQL); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPostSync, err)
+		}
+	}
+	if len(c.Timeout) > 0 {
+		if d, err := time.ParseDuration(c.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("%w: %v", ErrInvalidPostSync, c.Timeout)
+		}
+	}
+	return nil
+}
+
+func (c PostSyncConfig) timeout() time.Duration {
+	if d, err := time.ParseDuration(c.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultPostSyncTimeout
+}
+
+// setAppliedVersion makes a version visible to readers. When it's the last version of a sync,
+// and the source's post_sync script runs in the transaction, it's run along with it. If the
+// script fails the version is made visible without it, so a broken script can't stop the mirror.
+func (p NRTMProcessor) setAppliedVersion(source persist.NRTMSource, version uint32, last bool) error {
+	cfg := p.config.sourceConfig(source.Source).PostSync
+	if !last || cfg == nil || !cfg.InTransaction || len(cfg.SQL) == 0 {
+		return p.repo.SetAppliedVersion(source, version)
+	}
+	if p.scriptRan != nil {
+		p.scriptRan.Store(true)
+	}
+	script, err := os.ReadFile(cfg.SQL)
+	if err == nil {
+		if err = p.repo.SetAppliedVersionWithScript(source, version, string(script)); err == nil {
+			logger.Info("Ran post-sync script", "source", source.Source, "version", version, "script", cfg.SQL)
+			return nil
+		}
+	}
+	logger.Warn("Post-sync script failed", "source", source.Source, "script", cfg.SQL, "error", err)
+	p.warnings.add(WarningPostSync, version, "post_sync script failed, so the version was applied without it: %v", err)
+	return p.repo.SetAppliedVersion(source, version)
+}
+
+// postSync runs the source's post_sync script, unless it already ran in the sync's last
+// transaction, then its command. Failures are warnings, since the sync itself succeeded.
+func (p NRTMProcessor) postSync(sourceName, label string, from, to uint32) {
+	cfg := p.config.sourceConfig(sourceName).PostSync
+	if cfg == nil {
+		return
+	}
+	if len(cfg.SQL) > 0 && (p.scriptRan == nil || !p.scriptRan.Load()) {
+		script, err := os.ReadFile(cfg.SQL)
+		if err == nil {
+			err = p.repo.RunScript(string(script))
+		}
+		if err != nil {
+			logger.Warn("Post-sync script failed", "source", sourceName, "script", cfg.SQL, "error", err)
+			p.warnings.add(WarningPostSync, to, "post_sync script failed: %v", err)
+		} else {
+			logger.Info("Ran post-sync script", "source", sourceName, "version", to, "script", cfg.SQL)
+		}
+	}
+	if len(cfg.Command) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout())
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, cfg.Command[0], cfg.Command[1:]...)
+	// Children of the command may hold stderr open after it's killed
+	cmd.WaitDelay = time.Second
+	cmd.Env = append(os.Environ(),
+		"NRTM4_SOURCE="+sourceName,
+		"NRTM4_LABEL="+label,
+		"NRTM4_FROM_VERSION="+strconv.FormatUint(uint64(from), 10),
+		"NRTM4_VERSION="+strconv.FormatUint(uint64(to), 10),
+	)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("killed after %v: %w", cfg.timeout(), err)
+		}
+		logger.Warn("Post-sync command failed", "source", sourceName, "command", cfg.Command[0], "error", err, "stderr", stderr.String())
+		p.warnings.add(WarningPostSync, to, "post_sync command failed: %v", err)
+		return
+	}
+	logger.Info("Ran post-sync command", "source", sourceName, "version", to, "command", cfg.Command[0])
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+type postSyncRepo struct {
+	persist.Repository
+	applied []string
+	scripts []string
+	err     error
+}
+
+func (r *postSyncRepo) SetAppliedVersion(source persist.NRTMSource, version uint32) error {
+	r.applied = append(r.applied, "plain")
+	return nil
+}
+
+func (r *postSyncRepo) SetAppliedVersionWithScript(source persist.NRTMSource, version uint32, script string) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.applied = append(r.applied, "script")
+	r.scripts = append(r.scripts, script)
+	return nil
+}
+
+func (r *postSyncRepo) RunScript(script string) error {
+	r.scripts = append(r.scripts, script)
+	return r.err
+}
+
+func TestPostSync(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "refresh.sql")
+	if err := os.WriteFile(script, []byte("REFRESH MATERIALIZED VIEW route_origins;"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	cfg := &PostSyncConfig{SQL: script, InTransaction: true, Command: []string{"sh", "-c", `echo "$NRTM4_SOURCE $NRTM4_LABEL $NRTM4_FROM_VERSION $NRTM4_VERSION" > ` + out}}
+	repo := &postSyncRepo{}
+	p := NRTMProcessor{repo: repo, warnings: &syncWarnings{}, scriptRan: new(atomic.Bool)}
+	p.config.Sources = map[string]SourceConfig{"test": {PostSync: cfg}}
+	source := persist.NRTMSource{Source: "TEST", Label: "prod"}
+
+	for _, version := range []uint32{4, 5} {
+		if err := p.setAppliedVersion(source, version, version == 5); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	p.postSync("TEST", "prod", 3, 5)
+	if strings.Join(repo.applied, ",") != "plain,script" || len(repo.scripts) != 1 {
+		t.Error("Expected the script to run once, with the last version", repo.applied, repo.scripts)
+	}
+	if b, err := os.ReadFile(out); err != nil || strings.TrimSpace(string(b)) != "TEST prod 3 5" {
+		t.Error("Unexpected command environment", string(b), err)
+	}
+
+	cfg.InTransaction = false
+	p.scriptRan = new(atomic.Bool)
+	p.postSync("TEST", "prod", 5, 6)
+	if len(repo.scripts) != 2 {
+		t.Error("Expected the script to run after the sync", repo.scripts)
+	}
+
+	repo.err = errors.New("relation does not exist")
+	cfg.InTransaction = true
+	cfg.Command = []string{"false"}
+	if err := p.setAppliedVersion(source, 7, true); err != nil || repo.applied[len(repo.applied)-1] != "plain" {
+		t.Error("Expected the version to be applied without the script", repo.applied, err)
+	}
+	p.postSync("TEST", "prod", 6, 7)
+	if warnings := p.warnings.all(); len(warnings) != 2 || warnings[0].Kind != WarningPostSync || warnings[1].Kind != WarningPostSync {
+		t.Error("Expected post_sync warnings but was", warnings)
+	}
+	if err := (&PostSyncConfig{InTransaction: true}).validate(); !errors.Is(err, ErrInvalidPostSync) {
+		t.Error("Expected ErrInvalidPostSync but was", err)
+	}
+	if err := (&PostSyncConfig{Command: []string{"true"}, Timeout: "soon"}).validate(); !errors.Is(err, ErrInvalidPostSync) {
+		t.Error("Expected ErrInvalidPostSync for the timeout but was", err)
+	}
+}
+
+func TestPostSyncCommandTimeout(t *testing.T) {
+	cfg := &PostSyncConfig{Command: []string{"sleep", "30"}, Timeout: "100ms"}
+	p := NRTMProcessor{repo: &postSyncRepo{}, warnings: &syncWarnings{}}
+	p.config.Sources = map[string]SourceConfig{"test": {PostSync: cfg}}
+
+	start := time.Now()
+	p.postSync("TEST", "prod", 3, 4)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Error("Expected the command to be killed after its timeout but it ran for", elapsed)
+	}
+	if warnings := p.warnings.all(); len(warnings) != 1 || !strings.Contains(warnings[0].Message, "killed") {
+		t.Error("Expected a post_sync warning for the killed command but was", warnings)
+	}
+}
@@ -2,13 +2,20 @@ package service
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync/atomic"
+	"time"
 
-	"github.com/jackc/pgx/v5"
 	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
-	"github.com/petchells/nrtm4client/internal/nrtm4/pg/db"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/retry"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
 )
 
 var (
@@ -22,23 +29,73 @@ var (
 	// ErrSourceAlreadyExists a source with the given label already exists
 	ErrSourceAlreadyExists = errors.New("a source with the given label already exists")
 
+	// ErrRelativeFileURL a file reference in the notification file is not an absolute URL
+	ErrRelativeFileURL = errors.New("file reference url is not absolute")
+
 	fileWriteBufferLength = 1024 * 8
 	rpslInsertBatchSize   = 1000
 )
 
 // AppConfig application configuration object
 type AppConfig struct {
-	NRTMFilePath     string
-	PgDatabaseURL    string
-	BoltDatabasePath string
+	NRTMFilePath       string
+	TempDir            string
+	PgDatabaseURL      string
+	BoltDatabasePath   string
+	AllowedClockSkew   time.Duration
+	StrictFileURLs     bool
+	SnapshotWriters    int
+	SlowQueryThreshold time.Duration
+	UndeleteWindow     time.Duration
+	SessionRetention   time.Duration
+	SyncRunRetention   time.Duration
+	CatchUpWindow      int
+	MaxObjectSize      int
+	MaxFileSize        int64
+	AnalyzeAfter       int
+	LogLevels          map[string]string
+	Audit              AuditConfig
+	Network            NetworkConfig
+	Notify             NotifyConfig
+	Indexes            IndexConfig
+	Telemetry          TelemetryConfig
+	Sources            map[string]SourceConfig
+	Groups             map[string][]string
+	Contexts           map[string]ContextConfig
+	CurrentContext     string
+	// DefaultSource and DefaultLabel are used by commands which need a source when none is given
+	DefaultSource string
+	DefaultLabel  string
+	// Aliases are extra command names, each with the command line it runs
+	Aliases map[string]string
+	// Federation lists the other instances whose status nrtm4serve combines with its own
+	Federation FederationConfig
+	// Database connects without a password in PgDatabaseURL
+	Database DatabaseConfig
+	// URLVariables fill in the {name}s in notification URL templates
+	URLVariables map[string]string
+	// QueryLimits limit the queries nrtm4serve runs for its web API's clients
+	QueryLimits QueryLimitsConfig
+	// Retry is how the HTTP client, the repository and the scheduler retry what fails
+	Retry RetryConfig
+	// AdminAPI guards the nrtm4serve endpoints which trigger updates
+	AdminAPI AdminAPIConfig
 }
 
 // NewNRTMProcessor injects repo and client into service and return a new instance
 func NewNRTMProcessor(config AppConfig, repo persist.Repository, client Client) NRTMProcessor {
+	if cl, ok := client.(HTTPClient); ok {
+		cl.retry = newRetrier("http", config.Retry.HTTP)
+		client = cl
+	}
 	return NRTMProcessor{
-		config: config,
-		repo:   repo,
-		client: client,
+		config:         config,
+		repo:           repo,
+		client:         client,
+		board:          newProgressBoard(),
+		interrupt:      new(Interrupt),
+		queries:        newQueryGate(config.QueryLimits),
+		schedulerRetry: newRetrier("scheduler", config.Retry.Scheduler),
 	}
 }
 
@@ -47,6 +104,30 @@ type NRTMProcessor struct {
 	config AppConfig
 	repo   persist.Repository
 	client Client
+	// warnings is set for the duration of a sync
+	warnings *syncWarnings
+	// catchUp is set for the duration of an update
+	catchUp CatchUpMode
+	// runID identifies a connect or update in the files it applies
+	runID uint64
+	// changes counts the delta changes applied by an update
+	changes *atomic.Int64
+	// scriptRan is set when a sync ran the post_sync script in its last transaction
+	scriptRan *atomic.Bool
+	// board holds the progress of each source's latest sync
+	board *progressBoard
+	// progress is set for the duration of a sync
+	progress *syncProgress
+	// notifier replaces the one in the config, e.g. to collect a simulation's alerts
+	notifier Notifier
+	// interrupt stops or aborts syncs part way through
+	interrupt *Interrupt
+	// queries admits the web API's queries within the limits
+	queries *queryGate
+	// query is set for the duration of a web API query
+	query *Query
+	// schedulerRetry is given to the processor's schedulers
+	schedulerRetry *retry.Retrier
 }
 
 const charsAllowedInLabel = "A-Za-z0-9 :._-"
@@ -54,76 +135,311 @@ const charsAllowedInLabel = "A-Za-z0-9 :._-"
 // Must have a letter or digit in there somewhere
 var labelRe = regexp.MustCompile("^[" + charsAllowedInLabel + "]*[A-Za-z0-9][" + charsAllowedInLabel + "]*$")
 
-// Connect stores details about a connection
-func (p NRTMProcessor) Connect(notificationURL string, label string) error {
+// Connect stores details about a connection, loads the snapshot and applies the deltas since
+func (p NRTMProcessor) Connect(notificationURL string, label string) (SyncResult, error) {
+	return p.ConnectSample(notificationURL, label, Sample{})
+}
+
+// ConnectSample is Connect, but only the objects in a sample of the snapshot are loaded, and
+// only changes to objects in the sample are applied from deltas
+func (p NRTMProcessor) ConnectSample(notificationURL string, label string, sample Sample) (SyncResult, error) {
+	notificationURL, err := p.expandURL(notificationURL, nil)
+	if err != nil {
+		return SyncResult{Label: strings.TrimSpace(label), Warnings: []Warning{}}, err
+	}
+	p.warnings = &syncWarnings{}
+	p.runID = newRunID()
+	p.scriptRan = new(atomic.Bool)
+	p.progress = p.board.begin("connect", notificationURL, label, p.runID)
+	result := SyncResult{Label: strings.TrimSpace(label)}
+	err = p.connect(notificationURL, label, nil, sample, &result)
+	if err == nil {
+		p.postSync(result.Source, result.Label, 0, result.ToVersion)
+	}
+	p.progress.finish(err)
+	result.Warnings = p.warnings.all()
+	return result, err
+}
+
+// checkNewSource checks a source can be added with the URL and label, and returns the label
+// with the spaces trimmed
+func (p NRTMProcessor) checkNewSource(notificationURL string, label string) (string, error) {
+	if err := p.requirePrimary(); err != nil {
+		return label, err
+	}
 	if !validateURLString(notificationURL) {
-		return errors.New("parameter does not parse into a URL")
+		return label, errors.New("parameter does not parse into a URL")
 	}
 	label = strings.TrimSpace(label)
 	if len(label) > 0 && !labelRe.MatchString(label) {
-		return errors.New("label contains invalid characters. only allowed characters are: " + charsAllowedInLabel)
+		return label, errors.New("label contains invalid characters. only allowed characters are: " + charsAllowedInLabel)
 	}
 	ds := NrtmDataService{Repository: p.repo}
 	if ds.getSourceByURLAndLabel(notificationURL, label) != nil {
-		return errors.New("source already exists")
+		return label, errors.New("source already exists")
+	}
+	return label, nil
+}
+
+// connect saves a new source and loads its snapshot. When previous is given, the source is
+// replacing it after a session change, and the snapshot is applied as changes to its objects.
+// The new source keeps previous's sample, otherwise sample says how much of the snapshot to load.
+func (p NRTMProcessor) connect(notificationURL string, label string, previous *persist.NRTMSource, sample Sample, result *SyncResult) error {
+	label, err := p.checkNewSource(notificationURL, label)
+	if err != nil {
+		return err
 	}
-	logger.Info("Fetching notification")
-	fm := fileManager{p.client}
-	notification, err := fm.downloadNotificationFile(notificationURL)
+	// Sources can be connected in parallel, so progress messages say which one they're about
+	log := logger.With("url", notificationURL)
+	ds := NrtmDataService{Repository: p.repo}
+	log.Info("Fetching notification", "client", util.ClientVersion, "commit", util.GetBuildInfo().Commit, "run", p.runID)
+	fm := fileManager{client: p.client, warnings: p.warnings, maxRecordSize: p.config.maxObjectSize(), progress: p.progress, maxFileSize: p.config.MaxFileSize, strictness: p.config.strictness}
+	if previous != nil {
+		fm.source = previous.Source
+	}
+	notification, header, err := fm.downloadNotificationFile(notificationURL)
 	if err != nil {
 		return err
 	}
+	sourceName := notification.Source
+	if previous != nil {
+		sourceName = previous.Source
+	}
+	if notification, err = p.verifyNotification(sourceName, notificationURL, notification); err != nil {
+		return err
+	}
+	// The source isn't known until its notification file has been read
+	p.client = p.sourceClient(notification.Source)
+	fm.client = p.client
+	p.progress.source(notification.Source)
+	if err = p.checkNotificationTimestamp(sourceName, notification, header); err != nil {
+		return err
+	}
+	if err = p.checkNotificationExpiry(notification); err != nil {
+		return err
+	}
+	if err = p.checkFileExpiry(notification.SnapshotRef); err != nil {
+		return err
+	}
 	err = fm.ensureDirectoryExists(p.config.NRTMFilePath)
 	if err != nil {
 		return err
 	}
+	if len(p.config.TempDir) > 0 {
+		if err = fm.ensureDirectoryExists(p.config.TempDir); err != nil {
+			return err
+		}
+	}
 	// Download snapshot
-	logger.Info("Fetching snapshot file...")
-	snapshotFile, err := fm.fetchFileAndCheckHash(notificationURL, notification.SnapshotRef, p.config.NRTMFilePath)
+	log.Info("Fetching snapshot file...")
+	snapshotURL, err := resolveFileURL(notificationURL, notification.SnapshotRef.URL, p.config.StrictFileURLs)
+	if err != nil {
+		log.Error("Cannot resolve snapshot url", "ref", notification.SnapshotRef.URL, "error", err)
+		return err
+	}
+	snapshotStart := time.Now()
+	p.progress.stage(StageDownload, snapshotURL, notification.SnapshotRef.Version, 0, 1)
+	snapshotFile, err := fm.fetchFileAndCheckHash(snapshotURL, notification.SnapshotRef, p.config.NRTMFilePath, p.config.TempDir)
 	if err != nil {
 		return err
 	}
-	logger.Info("Snapshot file downloaded")
+	log.Info("Snapshot file downloaded")
 	defer snapshotFile.Close()
 
-	logger.Info("Saving new source", "source", notification.Source)
+	result.Source = notification.Source
+	result.FromVersion = notification.SnapshotRef.Version
+	result.ToVersion = notification.SnapshotRef.Version
+	log.Info("Saving new source", "source", notification.Source)
 	source := persist.NewNRTMSource(notification, label, notificationURL)
+	source.TermsURL = p.sourceTermsURL(notification.Source, notificationURL, header)
+	if previous != nil {
+		source.SampleRate = previous.SampleRate
+	} else if !sample.IsZero() {
+		if source.SampleRate, err = snapshotSampleRate(fm, snapshotFile, sample); err != nil {
+			log.Error("Cannot count the snapshot's objects", "error", err)
+			return err
+		}
+		log.Info("Loading a sample of the snapshot", "sample", sample, "rate", source.SampleRate)
+	}
 	if source, err = ds.saveNewSource(source, notification); err != nil {
-		logger.Error("There was a problem saving the source. Remove it and restart sync", "error", err)
+		log.Error("There was a problem saving the source. Remove it and restart sync", "error", err)
 		return err
 	}
-	logger.Info("Inserting snapshot objects", "source", notification.Source)
-	if err := fm.readJSONSeqRecords(snapshotFile, snapshotObjectInsertFunc(p.repo, source, notification)); err != io.EOF {
-		logger.Error("Invalid snapshot. Remove Source and restart sync", "error", err)
+	p.recordSession(source, notification)
+	p.archiveSnapshotRefs(source, notificationURL, notification)
+	log.Info("Inserting snapshot objects", "source", notification.Source)
+	p.progress.stage(StageSnapshot, snapshotURL, notification.SnapshotRef.Version, 0, 1)
+	snapshotHeader := new(protocol.SnapshotFileJSON)
+	if previous != nil {
+		if err = p.applySessionSnapshot(fm, snapshotFile, source, *previous, notification.SnapshotRef.Version, snapshotHeader); err != nil {
+			log.Error("Cannot apply the new session's snapshot", "error", err)
+			return err
+		}
+	} else {
+		insert := snapshotObjectInsertFunc(p.repo, source, p.sourceFilter(source), p.config.sourceQuirks(source.Source), notification, snapshotHeader, p.warnings)
+		if err := fm.readJSONSeqRecords(snapshotFile, p.quarantineOversized(source, notification.SnapshotRef.Version, insert)); err != io.EOF {
+			log.Error("Invalid snapshot. Remove Source and restart sync", "error", err)
+			return err
+		}
+	}
+	if err = p.setAppliedVersion(source, notification.SnapshotRef.Version, notification.SnapshotRef.Version == notification.Version); err != nil {
 		return err
 	}
-	return syncDeltas(p, notification, source)
+	if p.config.analyzeAfter() > 0 {
+		p.analyzeObjects(source.Source, "snapshot", 0)
+	}
+	if err = p.repo.SaveFile(&persist.NRTMFile{
+		Version:      notification.SnapshotRef.Version,
+		Type:         persist.SnapshotFile,
+		URL:          snapshotURL,
+		FileName:     filepath.Base(snapshotFile.Name()),
+		Hash:         notification.SnapshotRef.Hash,
+		Header:       snapshotHeader.Raw,
+		Size:         fileSize(snapshotFile),
+		ApplyTime:    time.Since(snapshotStart),
+		NrtmSourceID: source.ID,
+		RunID:        p.runID,
+	}); err != nil {
+		log.Warn("Failed to record snapshot file", "error", err)
+		p.warnings.add(WarningBookkeeping, notification.SnapshotRef.Version, "snapshot file was loaded but not recorded: %v", err)
+	}
+	p.auditAppliedFile(source, notification.SessionID, persist.SnapshotFile, notification.SnapshotRef)
+	if err = syncDeltas(p, notification, source); err != nil {
+		return err
+	}
+	result.ToVersion = notification.Version
+	return nil
 }
 
-// Update brings the local mirror up to date
-func (p NRTMProcessor) Update(sourceName string, label string) error {
+// Update brings the local mirror up to date. catchUp says whether a backlog of deltas can be
+// skipped by loading the latest snapshot. The result lists anything which went wrong but didn't
+// stop the update.
+func (p NRTMProcessor) Update(sourceName string, label string, catchUp CatchUpMode) (SyncResult, error) {
+	result := SyncResult{Source: sourceName, Label: label, Warnings: []Warning{}}
+	if err := p.requirePrimary(); err != nil {
+		return result, err
+	}
 	ds := NrtmDataService{Repository: p.repo}
 	source := ds.getSourceByNameAndLabel(sourceName, label)
 	if source == nil {
 		logger.Warn("No source with given name and label", "name", sourceName, "label", label)
-		return ErrSourceNotFound
+		return result, ErrSourceNotFound
+	}
+	result.Source = source.Source
+	result.FromVersion = source.Version
+	result.ToVersion = source.Version
+	if source.Paused {
+		return result, ErrSourcePaused
+	}
+	if err := checkQuarantine(*source, util.AppClock.Now()); err != nil {
+		return result, err
+	}
+	if p.interrupt.stopping() {
+		return result, ErrSyncStopped
+	}
+	p.warnings = &syncWarnings{}
+	p.catchUp = catchUp
+	p.runID = newRunID()
+	p.changes = new(atomic.Int64)
+	p.scriptRan = new(atomic.Bool)
+	p.client = p.sourceClient(source.Source)
+	p.progress = p.board.begin("update", source.NotificationURL, source.Label, p.runID)
+	p.progress.source(source.Source)
+	logger.Info("Updating source", "source", source.Source, "label", source.Label, "version", source.Version,
+		"client", util.ClientVersion, "commit", util.GetBuildInfo().Commit, "run", p.runID)
+	started := util.AppClock.Now()
+	err := p.update(*source)
+	p.updateQuarantine(*source, err)
+	if err == nil {
+		if updated := ds.getSourceByNameAndLabel(source.Source, source.Label); updated != nil {
+			result.ToVersion = updated.Version
+		}
+		if result.ToVersion != result.FromVersion {
+			p.checkObjectCount(*source, result.ToVersion)
+			p.postSync(source.Source, source.Label, result.FromVersion, result.ToVersion)
+		}
+		if changes := p.changes.Load(); p.config.analyzeAfter() > 0 && changes >= int64(p.config.analyzeAfter()) {
+			p.analyzeObjects(source.Source, "deltas", changes)
+		}
+		if p.config.SessionRetention > 0 {
+			if _, gcErr := p.CleanupSessions(source.Source, p.config.SessionRetention, false); gcErr != nil {
+				logger.Warn("Failed to clean up superseded sessions", "source", source.Source, "error", gcErr)
+				p.warnings.add(WarningBookkeeping, 0, "superseded sessions weren't cleaned up: %v", gcErr)
+			}
+		}
+	}
+	p.progress.finish(err)
+	result.Warnings = p.warnings.all()
+	p.sendTelemetry(result, err, util.AppClock.Since(started))
+	p.recordSyncRun(*source, result, err, started)
+	return result, err
+}
+
+func (p NRTMProcessor) update(source persist.NRTMSource) error {
+	if resumed, err := p.resumePendingDeltas(source); resumed || err != nil {
+		return err
+	}
+	fm := fileManager{client: p.client, warnings: p.warnings, strictness: p.config.strictness, source: source.Source}
+	notification, header, fingerprint, err := fm.downloadChangedNotification(source.NotificationURL, source.LastNotification)
+	if errors.Is(err, errNotificationUnchanged) {
+		logger.Info("Notification file hasn't changed", "source", source.Source, "version", source.Version)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err = p.updateFromNotification(source, notification, header); err != nil {
+		return err
 	}
-	fm := fileManager{p.client}
-	notification, err := fm.downloadNotificationFile(source.NotificationURL)
+	p.saveNotificationFingerprint(source, fingerprint)
+	return nil
+}
+
+// updateFromNotification applies what's new in a notification file which has been downloaded
+func (p NRTMProcessor) updateFromNotification(source persist.NRTMSource, notification protocol.NotificationJSON, header http.Header) error {
+	notification, err := p.verifyNotification(source.Source, source.NotificationURL, notification)
 	if err != nil {
 		return err
 	}
+	if err = p.checkNotificationTimestamp(source.Source, notification, header); err != nil {
+		return err
+	}
+	if err = p.checkNotificationExpiry(notification); err != nil {
+		return err
+	}
+	source = p.saveTermsURL(source, header)
+	p.recordSession(source, notification)
 	if notification.SessionID != source.SessionID {
-		return errors.New("server has a new mirror session")
+		if last := p.lastNotification(source); last != nil && rotationAnnounced(*last, notification) {
+			return p.reinitialize(source)
+		}
+		return fmt.Errorf("%w: server has a new mirror session", ErrNRTM4SourceMismatch)
+	}
+	logAnnouncedRotation(notification)
+	p.archiveSnapshotRefs(source, source.NotificationURL, notification)
+	// Versions which a failure left out of the journal are written before any more are applied
+	if err = p.journalChanges(source, source.Version+1, source.Version); err != nil {
+		return err
 	}
 	if notification.Version < source.Version {
-		return errors.New("server has old version")
+		return fmt.Errorf("%w: server has old version", ErrNRTM4FileVersionInconsistency)
 	}
 	if notification.Version == source.Version {
 		logger.Info("Already at latest version")
 		return nil
 	}
-	return syncDeltas(p, notification, *source)
+	if p.useSnapshotShortcut(notification, source) {
+		if source, err = p.catchUpFromSnapshot(notification, source); err != nil {
+			return err
+		}
+		if notification.Version == source.Version {
+			return nil
+		}
+	}
+	if err = syncDeltas(p, notification, source); err != nil {
+		return err
+	}
+	p.checkChangeRate(source)
+	return nil
 }
 
 // ListSources shows all sources
@@ -135,22 +451,39 @@ func (p NRTMProcessor) ListSources() ([]persist.NRTMSourceDetails, error) {
 		return deets, err
 	}
 	for _, src := range sources {
-		to := src.Version
-		from := src.Version - 99
-		if src.Version <= 99 {
-			from = 1
-		}
-		notifs, err := p.repo.GetNotificationHistory(src, from, to)
+		details, err := p.sourceDetails(src)
 		if err != nil {
 			return deets, err
 		}
-		deets = append(deets, persist.NRTMSourceDetails{NRTMSource: src, Notifications: notifs})
+		deets = append(deets, details)
 	}
 	return deets, nil
 }
 
+// sourceDetails adds the last 100 notifications to a source
+func (p NRTMProcessor) sourceDetails(src persist.NRTMSource) (persist.NRTMSourceDetails, error) {
+	to := src.Version
+	from := src.Version - 99
+	if src.Version <= 99 {
+		from = 1
+	}
+	notifs, err := p.repo.GetNotificationHistory(src, from, to)
+	details := persist.NRTMSourceDetails{NRTMSource: src, Notifications: notifs, Expiries: []persist.Expiry{}}
+	if err != nil {
+		return details, err
+	}
+	if len(notifs) > 0 {
+		details.Expiries = upcomingExpiries(notifs[0].Payload, util.AppClock.Now())
+	}
+	details.Sessions, err = p.repo.GetSessionHistory(src)
+	return details, err
+}
+
 // ReplaceLabel replaces a label name
 func (p NRTMProcessor) ReplaceLabel(src, fromLabel, toLabel string) (*persist.NRTMSource, error) {
+	if err := p.requirePrimary(); err != nil {
+		return nil, err
+	}
 	ds := NrtmDataService{Repository: p.repo}
 	possDupe := ds.getSourceByNameAndLabel(src, toLabel)
 	if possDupe != nil {
@@ -160,31 +493,57 @@ func (p NRTMProcessor) ReplaceLabel(src, fromLabel, toLabel string) (*persist.NR
 	if target == nil {
 		return nil, ErrSourceNotFound
 	}
+	if err := p.repo.SaveLabel(*target, toLabel); err != nil {
+		return nil, err
+	}
 	target.Label = toLabel
-	return target, db.WithTransaction(func(tx pgx.Tx) error {
-		return db.Update(tx, target)
-	})
+	return target, nil
 }
 
 // RemoveSource removes a source from the repo
 func (p NRTMProcessor) RemoveSource(src, label string) error {
+	if err := p.requirePrimary(); err != nil {
+		return err
+	}
 	ds := NrtmDataService{Repository: p.repo}
 	target := ds.getSourceByNameAndLabel(src, label)
 	if target == nil {
 		return ErrSourceNotFound
 	}
-	return ds.deleteSource(*target)
+	_, err := ds.deleteSource(*target)
+	return err
+}
+
+// SchemaInfo describes the tables in the repository
+func (p NRTMProcessor) SchemaInfo() (persist.SchemaInfo, error) {
+	return p.repo.GetSchemaInfo()
 }
 
-func fullURL(base, relpath string) string {
-	idx := strings.LastIndex(base, "/")
-	if idx < 0 {
-		logger.Error("fullURL called with a path that does not contain '/'", "base", base)
-		return ""
+// PartitionObjects splits the objects table into partitions by a hash of the primary key
+func (p NRTMProcessor) PartitionObjects(partitions int) error {
+	if err := p.requirePrimary(); err != nil {
+		return err
 	}
-	sepIdx := 1
-	if strings.HasPrefix(relpath, "/") {
-		sepIdx = 0
+	return p.repo.PartitionObjects(partitions)
+}
+
+// resolveFileURL returns the URL a snapshot or delta is downloaded from. Absolute URLs are used
+// as they are. Relative ones are resolved against the notification URL as RFC 3986 says, unless
+// strict is set.
+func resolveFileURL(notificationURL, ref string, strict bool) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	if u.IsAbs() {
+		return ref, nil
+	}
+	if strict {
+		return "", ErrRelativeFileURL
+	}
+	base, err := url.Parse(notificationURL)
+	if err != nil {
+		return "", err
 	}
-	return base[:idx+sepIdx] + relpath
+	return base.ResolveReference(u).String(), nil
 }
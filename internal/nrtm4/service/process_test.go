@@ -9,6 +9,7 @@ import (
 
 	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
 	"github.com/petchells/nrtm4client/internal/nrtm4/pg"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
 	"github.com/petchells/nrtm4client/internal/nrtm4/testresources"
 )
 
@@ -47,7 +48,7 @@ func TestLabelRegex(t *testing.T) {
 }
 
 func TestFileRefSorter(t *testing.T) {
-	refs := []persist.FileRefJSON{
+	refs := []protocol.FileRefJSON{
 		{
 			Version: 4,
 			URL:     "https://xxx.xxx.xxx/4",
@@ -92,7 +93,7 @@ func TestE2EConnect(t *testing.T) {
 		NRTMFilePath: tmpDir,
 	}
 	processor := NewNRTMProcessor(conf, pgTestRepo, stubClient)
-	if err = processor.Connect(stubNotificationURL, ""); err != nil {
+	if _, err = processor.Connect(stubNotificationURL, ""); err != nil {
 		t.Fatal("Failed to Connect", err)
 	}
 	sources, err := processor.ListSources()
@@ -116,7 +117,7 @@ func TestE2EConnect(t *testing.T) {
 
 func TestFindUpdatesSuccess(t *testing.T) {
 
-	var notification persist.NotificationJSON
+	var notification protocol.NotificationJSON
 	testresources.ReadTestJSONToPtr(t, "ripe-notification-file.json", &notification)
 	source := stubsource()
 
@@ -132,7 +133,7 @@ func TestFindUpdatesSuccess(t *testing.T) {
 
 func TestValidateNotificationErrors(t *testing.T) {
 
-	var notification persist.NotificationJSON
+	var notification protocol.NotificationJSON
 	{
 		testresources.ReadTestJSONToPtr(t, "ripe-notification-file.json", &notification)
 		refs := notification.DeltaRefs
@@ -141,7 +142,7 @@ func TestValidateNotificationErrors(t *testing.T) {
 
 		expect := ErrNRTM4NotificationDeltaSequenceBroken
 
-		err := validateNotificationFile(notification)
+		err := validateNotificationFile(notification, StrictnessStandard, nil)
 
 		if err != expect {
 			t.Errorf("Expected error %v but was %v", expect, err)
@@ -155,7 +156,7 @@ func TestValidateNotificationErrors(t *testing.T) {
 
 		expect := ErrNRTM4NotificationVersionDoesNotMatchDelta
 
-		err := validateNotificationFile(notification)
+		err := validateNotificationFile(notification, StrictnessStandard, nil)
 		if err != expect {
 			t.Errorf("Expected error %v but was %v", expect, err)
 		}
@@ -168,56 +169,49 @@ func TestValidateNotificationErrors(t *testing.T) {
 
 		expect := ErrNRTM4DuplicateDeltaVersion
 
-		err := validateNotificationFile(notification)
+		err := validateNotificationFile(notification, StrictnessStandard, nil)
 		if err != expect {
 			t.Errorf("Expected error %v but was %v", expect, err)
 		}
 	}
 	{
 		testresources.ReadTestJSONToPtr(t, "ripe-notification-file.json", &notification)
-		dr := []persist.FileRefJSON{}
+		dr := []protocol.FileRefJSON{}
 		notification.DeltaRefs = dr
 
 		expect := ErrNRTM4NoDeltasInNotification
 
-		err := validateNotificationFile(notification)
+		err := validateNotificationFile(notification, StrictnessStandard, nil)
 		if err != expect {
 			t.Errorf("Expected error %v but was %v", expect, err)
 		}
 	}
 }
 
-func TestFullURLFunction(t *testing.T) {
+func TestResolveFileURL(t *testing.T) {
 	base := "https://nrtm.example.eu/path/to/nrtm4/notification-file.json"
-	{
-		rel := "source/r2d1-65535.EXAMPLE.json"
-		expected := "https://nrtm.example.eu/path/to/nrtm4/source/r2d1-65535.EXAMPLE.json"
-
-		result := fullURL(base, rel)
-
-		if result != expected {
-			t.Error("fullURL returned wrong url expected:", expected, "but was:", result)
-		}
+	tests := []struct {
+		ref      string
+		strict   bool
+		expected string
+		err      error
+	}{
+		{"https://cdn.example.eu/nrtm4/snapshot.json.gz", false, "https://cdn.example.eu/nrtm4/snapshot.json.gz", nil},
+		{"https://cdn.example.eu/nrtm4/snapshot.json.gz", true, "https://cdn.example.eu/nrtm4/snapshot.json.gz", nil},
+		{"nrtm-delta.1.json", false, "https://nrtm.example.eu/path/to/nrtm4/nrtm-delta.1.json", nil},
+		{"/nrtm-delta.1.json", false, "https://nrtm.example.eu/nrtm-delta.1.json", nil},
+		{"../deltas/nrtm-delta.1.json", false, "https://nrtm.example.eu/path/to/deltas/nrtm-delta.1.json", nil},
+		{"nrtm-delta.1.json?token=abc", false, "https://nrtm.example.eu/path/to/nrtm4/nrtm-delta.1.json?token=abc", nil},
+		{"//cdn.example.eu/nrtm4/nrtm-delta.1.json", false, "https://cdn.example.eu/nrtm4/nrtm-delta.1.json", nil},
+		{"nrtm-delta.1.json", true, "", ErrRelativeFileURL},
 	}
-	{
-		rel := "/source/r2d1-65535.EXAMPLE.json"
-		expected := "https://nrtm.example.eu/path/to/nrtm4/source/r2d1-65535.EXAMPLE.json"
-
-		result := fullURL(base, rel)
-
-		if result != expected {
-			t.Error("fullURL returned wrong url expected:", expected, "but was:", result)
+	for _, tt := range tests {
+		result, err := resolveFileURL(base, tt.ref, tt.strict)
+		if err != tt.err {
+			t.Error("Expected error", tt.err, "but was", err, "for", tt.ref)
 		}
-	}
-	{
-		base = "nrtm.example.eu"
-		rel := "/source/r2d1-65535.EXAMPLE.json"
-		expected := ""
-
-		result := fullURL(base, rel)
-
-		if result != expected {
-			t.Error("fullURL returned wrong url expected:", expected, "but was:", result)
+		if result != tt.expected {
+			t.Error("Expected", tt.expected, "but was", result, "for", tt.ref)
 		}
 	}
 }
@@ -251,3 +245,25 @@ func stubsource() persist.NRTMSource {
 	}
 	return src
 }
+
+func TestRotationAnnounced(t *testing.T) {
+	nextID := "f0b2e0a6-5ef6-4b7c-9a57-2c1a8de2d0b1"
+	last := protocol.NotificationJSON{NrtmFileJSON: protocol.NrtmFileJSON{SessionID: "ca128382-78d9-41d1-8927-1ecef15275be"}}
+	current := protocol.NotificationJSON{NrtmFileJSON: protocol.NrtmFileJSON{SessionID: nextID}}
+	if rotationAnnounced(last, current) {
+		t.Error("Rotation was not announced")
+	}
+	last.NextSession = &protocol.NextSessionJSON{Timestamp: "2025-02-01T00:00:00Z"}
+	if !rotationAnnounced(last, current) {
+		t.Error("Rotation was announced without a session id")
+	}
+	otherID := "00000000-0000-0000-0000-000000000000"
+	last.NextSession.SessionID = &otherID
+	if rotationAnnounced(last, current) {
+		t.Error("A different session was announced")
+	}
+	last.NextSession.SessionID = &nextID
+	if !rotationAnnounced(last, current) {
+		t.Error("Rotation was announced with a matching session id")
+	}
+}
@@ -1,47 +1,149 @@
 package service
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/petchells/nrtm4client/internal/nrtm4/jsonseq"
 	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/quirks"
 	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
 )
 
-func syncDeltas(p NRTMProcessor, notification persist.NotificationJSON, source persist.NRTMSource) error {
+func syncDeltas(p NRTMProcessor, notification protocol.NotificationJSON, source persist.NRTMSource) error {
 	deltaRefs, err := findUpdates(notification, source)
 	if err != nil {
 		return err
 	}
 	sort.Sort(fileRefsByVersion(deltaRefs))
-	fm := fileManager{p.client}
-	for _, deltaRef := range deltaRefs {
+	p.queuePendingDeltas(source, deltaRefs)
+	return applyDeltas(p, notification, source, deltaRefs)
+}
+
+// applyDeltas downloads and applies deltaRefs in order. The queue of pending deltas is cleared
+// when it returns, so it's only left behind when the process stops part way through.
+func applyDeltas(p NRTMProcessor, notification protocol.NotificationJSON, source persist.NRTMSource, deltaRefs []protocol.FileRefJSON) error {
+	defer p.queuePendingDeltas(source, nil)
+	var err error
+	fm := fileManager{client: p.client, warnings: p.warnings, maxRecordSize: p.config.maxObjectSize(), progress: p.progress, maxFileSize: p.config.MaxFileSize}
+	events := newDeltaEventSink(p.config, source)
+	defer events.close()
+	for i, deltaRef := range deltaRefs {
+		if p.interrupt.stopping() {
+			logger.Warn("Sync stopped", "source", source.Source, "label", source.Label, "version", deltaRef.Version-1)
+			return ErrSyncStopped
+		}
 		logger.Info("Processing delta", "delta", deltaRef.Version, "url", deltaRef.URL)
-		file, err := fm.fetchFileAndCheckHash(source.NotificationURL, deltaRef, p.config.NRTMFilePath)
+		if err = checkDeltaNotApplied(p.repo, source, deltaRef); err != nil {
+			logger.Error("Server republished a delta", "version", deltaRef.Version, "url", deltaRef.URL, "error", err)
+			return err
+		}
+		if err = p.checkFileExpiry(deltaRef); err != nil {
+			logger.Error("Delta has expired", "version", deltaRef.Version, "expires", deltaRef.Expires)
+			return err
+		}
+		deltaURL, err := resolveFileURL(source.NotificationURL, deltaRef.URL, p.config.StrictFileURLs)
+		if err != nil {
+			logger.Error("Cannot resolve delta url", "url", deltaRef.URL, "error", err)
+			return err
+		}
+		p.progress.stage(StageDeltas, deltaURL, deltaRef.Version, i, len(deltaRefs))
+		start := time.Now()
+		file, err := fm.fetchDeltaFile(source, deltaURL, deltaRef, p.config.NRTMFilePath, p.config.TempDir)
 		if err != nil {
 			return err
 		}
 		defer file.Close()
-		if err := fm.readJSONSeqRecords(file, applyDeltaFunc(p.repo, source, notification, deltaRef)); err != io.EOF {
+		header := new(protocol.DeltaFileJSON)
+		sc := p.config.sourceConfig(source.Source)
+		apply := applyDeltaFunc(p.repo, source, p.sourceFilter(source), sc.ApplyOrder, p.config.strictness(source.Source), p.config.sourceQuirks(source.Source), notification, deltaRef, events, header, p.warnings)
+		records := int64(0)
+		counted := func(bytes []byte, err error) error {
+			if p.interrupt.aborting() {
+				return ErrSyncAborted
+			}
+			if len(bytes) > 0 {
+				records++
+			}
+			return apply(bytes, err)
+		}
+		if err := fm.readJSONSeqRecords(file, p.quarantineOversized(source, deltaRef.Version, counted)); err != io.EOF {
+			// The delta wasn't applied, so the events of its changes are dropped
+			events.discard()
+			if errors.Is(err, ErrSyncAborted) {
+				return p.rollBackDelta(source, deltaRef.Version)
+			}
 			logger.Warn("Failed to apply delta", "source", source, "error", err)
 			return err
 		}
+		if p.changes != nil && records > 0 {
+			// Leave out the header
+			p.changes.Add(records - 1)
+		}
+		// Readers only see the delta's changes once they've all been saved
+		if err = p.setAppliedVersion(source, deltaRef.Version, i == len(deltaRefs)-1); err != nil {
+			return err
+		}
+		source.Version = deltaRef.Version
+		events.flush()
+		p.auditAppliedFile(source, notification.SessionID, persist.DeltaFile, deltaRef)
+		if err = p.repo.SaveFile(&persist.NRTMFile{
+			Version:      deltaRef.Version,
+			Type:         persist.DeltaFile,
+			URL:          deltaURL,
+			FileName:     filepath.Base(file.Name()),
+			Hash:         deltaRef.Hash,
+			Header:       header.Raw,
+			Size:         fileSize(file),
+			ApplyTime:    time.Since(start),
+			NrtmSourceID: source.ID,
+			RunID:        p.runID,
+		}); err != nil {
+			logger.Warn("Failed to record applied delta", "version", deltaRef.Version, "error", err)
+			p.warnings.add(WarningBookkeeping, deltaRef.Version, "delta file was applied but not recorded: %v", err)
+		}
+		if err = p.journalChanges(source, deltaRef.Version, deltaRef.Version); err != nil {
+			return err
+		}
 	}
 	logger.Info("Finished syncing deltas")
 	return nil
 }
 
-func findUpdates(notification persist.NotificationJSON, source persist.NRTMSource) ([]persist.FileRefJSON, error) {
+// checkDeltaNotApplied stops a delta being applied twice when a server republishes it, with
+// the same contents, under another URL
+func checkDeltaNotApplied(repo persist.Repository, source persist.NRTMSource, deltaRef protocol.FileRefJSON) error {
+	if len(deltaRef.Hash) == 0 {
+		return nil
+	}
+	applied, err := repo.GetFileByHash(source, deltaRef.Hash)
+	if err != nil {
+		return err
+	}
+	if applied == nil || applied.Type != persist.DeltaFile {
+		return nil
+	}
+	return fmt.Errorf(
+		"%w: version %d at %v has the same hash as version %d at %v",
+		ErrNRTM4DeltaAlreadyApplied, deltaRef.Version, deltaRef.URL, applied.Version, applied.URL,
+	)
+}
+
+func findUpdates(notification protocol.NotificationJSON, source persist.NRTMSource) ([]protocol.FileRefJSON, error) {
 
 	if notification.DeltaRefs == nil || len(notification.DeltaRefs) == 0 {
 		return nil, ErrNRTM4NoDeltasInNotification
 	}
 
-	deltaRefs := []persist.FileRefJSON{}
+	deltaRefs := []protocol.FileRefJSON{}
 	versions := make([]uint32, len(notification.DeltaRefs))
 	for i, deltaRef := range notification.DeltaRefs {
 		versions[i] = deltaRef.Version
@@ -52,59 +154,217 @@ func findUpdates(notification persist.NotificationJSON, source persist.NRTMSourc
 	if len(deltaRefs) == 0 {
 		log.Panic("Check the notification version before calling this function")
 	}
-	sort.Slice(deltaRefs, func(r1, r2 int) bool {
+	sort.SliceStable(deltaRefs, func(r1, r2 int) bool {
 		return deltaRefs[r1].Version < deltaRefs[r2].Version
 	})
 	if source.Version+1 < deltaRefs[0].Version {
 		return nil, ErrNextConsecutiveDeltaUnavaliable
 	}
+	// A lenient strictness lets a notification file repeat or miss out versions, so the deltas
+	// which are applied still have to be checked
+	deltaRefs = slices.CompactFunc(deltaRefs, func(r1, r2 protocol.FileRefJSON) bool {
+		return r1.Version == r2.Version
+	})
+	for i := 1; i < len(deltaRefs); i++ {
+		if deltaRefs[i].Version != deltaRefs[i-1].Version+1 {
+			return nil, ErrNRTM4NotificationDeltaSequenceBroken
+		}
+	}
 	logger.Info("Found deltas", "source", notification.Source, "numdeltas", len(deltaRefs))
 	return deltaRefs, nil
 }
 
-func applyDeltaFunc(repo persist.Repository, source persist.NRTMSource, notification persist.NotificationJSON, deltaRef persist.FileRefJSON) jsonseq.RecordReaderFunc {
-	var header *persist.DeltaFileJSON
+// applyDeltaFunc applies the records in a delta file. The first record is read into header.
+// Deletes of objects which aren't in the repo are added to warnings, unless there's a filter,
+// which makes them expected. With an apply order the changes are applied in that order once
+// the whole file has been read, otherwise each one is applied as it's read. The header is
+// checked with strictness, and objects are parsed with objectQuirks.
+func applyDeltaFunc(
+	repo persist.Repository,
+	source persist.NRTMSource,
+	filter *FilterConfig,
+	order *ApplyOrderConfig,
+	strictness Strictness,
+	objectQuirks quirks.Set,
+	notification protocol.NotificationJSON,
+	deltaRef protocol.FileRefJSON,
+	events deltaEventSink,
+	header *protocol.DeltaFileJSON,
+	warnings *syncWarnings,
+) jsonseq.RecordReaderFunc {
+	expectHeader := true
+	revision := protocol.Current
+	applier := deltaApplier{repo, source, filter, deltaRef, events, header, warnings}
+	changes := []persist.DeltaChange{}
+	flush := func() error {
+		if order == nil {
+			return nil
+		}
+		for _, group := range order.groups(order.order(changes)) {
+			if err := applier.applyGroup(group); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	return func(bytes []byte, err error) error {
 		if err == nil || err == io.EOF {
-			if header == nil {
-				deltaHeader := new(persist.DeltaFileJSON)
-				if err = json.Unmarshal(bytes, deltaHeader); err != nil {
+			eof := err == io.EOF
+			if expectHeader {
+				expectHeader = false
+				if header.NrtmFileJSON, revision, err = protocol.DecodeHeader(bytes); err != nil {
 					return err
 				}
-				if err = validateDeltaHeader(deltaHeader.NrtmFileJSON, source, deltaRef); err != nil {
+				if err = validateDeltaHeader(header.NrtmFileJSON, source, deltaRef, strictness, warnings); err != nil {
 					return err
 				}
-				header = deltaHeader
-				source.Version = deltaRef.Version
+				// The source's version is moved on with the applied version, once the delta's
+				// changes have all been saved
 				_, err = repo.SaveSource(source, notification)
 				return err
 			}
-			delta := new(persist.DeltaJSON)
-			if err = json.Unmarshal(bytes, delta); err != nil {
+			if len(bytes) == 0 && eof {
+				// The last record was quarantined
+				return flush()
+			}
+			change, err := parseDeltaChange(revision, objectQuirks, bytes)
+			if err != nil {
 				return err
 			}
-			if delta.Action == persist.DeltaAddModifyAction {
-				rpsl, err := rpsl.ParseFromJSONString(*delta.Object)
-				if err != nil {
-					return err
-				}
-				err = repo.AddModifyObject(source, rpsl, header.NrtmFileJSON)
-				if err != nil {
-					logger.Error("Delta AddModifyO0bject failed", "rpsl", rpsl, "error", err)
-					return err
-				}
-			} else if delta.Action == persist.DeltaDeleteAction {
-				repo.DeleteObject(source, *delta.ObjectClass, *delta.PrimaryKey, header.NrtmFileJSON)
-			} else {
-				return errors.New("no delta action available: " + delta.Action)
+			if order == nil {
+				return applier.applyGroup([]persist.DeltaChange{change})
+			}
+			changes = append(changes, change)
+			if eof {
+				return flush()
+			}
+			return nil
+		}
+		return err
+	}
+}
+
+// parseDeltaChange reads a change record from a delta file of the revision, parsing the object
+// with objectQuirks
+func parseDeltaChange(revision protocol.Revision, objectQuirks quirks.Set, bytes []byte) (persist.DeltaChange, error) {
+	delta, err := revision.DecodeChange(bytes)
+	if err != nil {
+		return persist.DeltaChange{}, err
+	}
+	if delta.Action == persist.DeltaAddModifyAction {
+		obj, err := objectQuirks.Parse(*delta.Object)
+		if err != nil {
+			return persist.DeltaChange{}, err
+		}
+		return persist.DeltaChange{Action: delta.Action, Object: obj}, nil
+	} else if delta.Action == persist.DeltaDeleteAction {
+		return persist.DeltaChange{
+			Action: delta.Action,
+			Object: rpsl.Rpsl{ObjectType: *delta.ObjectClass, PrimaryKey: *delta.PrimaryKey},
+		}, nil
+	}
+	return persist.DeltaChange{}, errors.New("no delta action available: " + delta.Action)
+}
+
+// deltaApplier saves a delta's changes to the repo and sends their events
+type deltaApplier struct {
+	repo     persist.Repository
+	source   persist.NRTMSource
+	filter   *FilterConfig
+	deltaRef protocol.FileRefJSON
+	events   deltaEventSink
+	header   *protocol.DeltaFileJSON
+	warnings *syncWarnings
+}
+
+// apply applies one change in its own transaction. The repo doesn't return the version of the
+// object it replaced, so its event has no previous version. A delete which fails has no event.
+func (a deltaApplier) apply(change persist.DeltaChange) error {
+	file := a.header.NrtmFileJSON
+	obj := change.Object
+	if change.Action == persist.DeltaAddModifyAction {
+		if !a.filter.keeps(obj) {
+			// The object may have passed the filter before this change, in which case
+			// it's no longer wanted
+			if err := a.repo.DeleteObject(a.source, obj.ObjectType, obj.PrimaryKey, file); err == nil {
+				logger.Info("Removed object which no longer passes the filter", "type", obj.ObjectType, "key", obj.PrimaryKey)
+				a.events.send(newDeltaEvent(a.source, file, persist.DeltaDeleteAction, obj.ObjectType, obj.PrimaryKey, nil, nil))
 			}
 			return nil
 		}
+		if err := a.repo.AddModifyObject(a.source, obj, file); err != nil {
+			logger.Error("Delta AddModifyO0bject failed", "rpsl", obj, "error", err)
+			return err
+		}
+		a.events.send(newDeltaEvent(a.source, file, change.Action, obj.ObjectType, obj.PrimaryKey, &obj.Payload, nil))
+		return nil
+	}
+	if err := a.repo.DeleteObject(a.source, obj.ObjectType, obj.PrimaryKey, file); err != nil {
+		if a.filter == nil {
+			a.toleratedDelete(obj, err)
+		}
+		// Nothing was deleted, most likely an object the filter left out
+		return nil
+	}
+	a.events.send(newDeltaEvent(a.source, file, change.Action, obj.ObjectType, obj.PrimaryKey, nil, nil))
+	return nil
+}
+
+// applyGroup applies changes in one transaction, then sends their events with the version of
+// each object before and after its change. Deletes of objects which weren't in the repo have no
+// event. A single change is applied on its own when there
+// are no events to send.
+func (a deltaApplier) applyGroup(changes []persist.DeltaChange) error {
+	if len(changes) == 1 && a.events.publisher == nil {
+		return a.apply(changes[0])
+	}
+	file := a.header.NrtmFileJSON
+	kept := make([]persist.DeltaChange, len(changes))
+	filtered := map[int]bool{}
+	for i, change := range changes {
+		kept[i] = change
+		if change.Action == persist.DeltaAddModifyAction && !a.filter.keeps(change.Object) {
+			// The object may have passed the filter before this change
+			kept[i].Action = persist.DeltaDeleteAction
+			filtered[i] = true
+		}
+	}
+	missing, err := a.repo.ApplyDeltaChanges(a.source, kept, file)
+	if err != nil {
+		logger.Error("Delta changes failed", "changes", len(changes), "error", err)
 		return err
 	}
+	for i, change := range kept {
+		obj := change.Object
+		if slices.ContainsFunc(missing, func(m persist.DeltaChange) bool {
+			return m.Object.ObjectType == obj.ObjectType && m.Object.PrimaryKey == obj.PrimaryKey
+		}) && change.Action == persist.DeltaDeleteAction {
+			if a.filter == nil {
+				a.toleratedDelete(obj, ErrNRTM4ObjectNotInRepo)
+			}
+			continue
+		}
+		previous := payloadOf(change.Previous)
+		if filtered[i] {
+			logger.Info("Removed object which no longer passes the filter", "type", obj.ObjectType, "key", obj.PrimaryKey)
+			a.events.send(newDeltaEvent(a.source, file, persist.DeltaDeleteAction, obj.ObjectType, obj.PrimaryKey, nil, previous))
+		} else if change.Action == persist.DeltaAddModifyAction {
+			a.events.send(newDeltaEvent(a.source, file, change.Action, obj.ObjectType, obj.PrimaryKey, &obj.Payload, previous))
+		} else {
+			a.events.send(newDeltaEvent(a.source, file, change.Action, obj.ObjectType, obj.PrimaryKey, nil, previous))
+		}
+	}
+	return nil
 }
 
-func validateDeltaHeader(file persist.NrtmFileJSON, source persist.NRTMSource, deltaRef persist.FileRefJSON) error {
+func (a deltaApplier) toleratedDelete(obj rpsl.Rpsl, err error) {
+	logger.Warn("Delta deleted an object which can't be deleted", "type", obj.ObjectType, "key", obj.PrimaryKey, "error", err)
+	a.warnings.add(WarningToleratedMismatch, a.deltaRef.Version, "delete of %v %v: %v", obj.ObjectType, obj.PrimaryKey, err)
+}
+
+// validateDeltaHeader checks a delta belongs to the source and is the version its reference
+// says. A source name which only differs in case is tolerated by a lenient strictness.
+func validateDeltaHeader(file protocol.NrtmFileJSON, source persist.NRTMSource, deltaRef protocol.FileRefJSON, strictness Strictness, warnings *syncWarnings) error {
 	if file.NrtmVersion != 4 {
 		return ErrNRTM4VersionMismatch
 	}
@@ -112,7 +372,13 @@ func validateDeltaHeader(file persist.NrtmFileJSON, source persist.NRTMSource, d
 		return ErrNRTM4SourceMismatch
 	}
 	if file.Source != source.Source {
-		return ErrNRTM4SourceNameMismatch
+		if !strings.EqualFold(file.Source, source.Source) {
+			return ErrNRTM4SourceNameMismatch
+		}
+		err := fmt.Errorf("%w: delta has %v, notification has %v", ErrNRTM4SourceNameMismatch, file.Source, source.Source)
+		if err = strictness.tolerate(err, warnings, deltaRef.Version); err != nil {
+			return err
+		}
 	}
 	if file.Version != deltaRef.Version {
 		return ErrNRTM4FileVersionMismatch
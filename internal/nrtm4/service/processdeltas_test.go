@@ -1,12 +1,19 @@
 package service
 
 import (
+	"encoding/json"
+	"errors"
 	"io"
+	"net/http"
 	"os"
 	"strings"
 	"testing"
 
+	"github.com/petchells/nrtm4client/internal/nrtm4/mem"
 	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/quirks"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
 	"github.com/petchells/nrtm4client/internal/nrtm4/testresources"
 )
 
@@ -40,15 +47,15 @@ func TestApplyDeltas(t *testing.T) {
 		config: config,
 		client: client,
 	}
-	deltas := []persist.FileRefJSON{
+	deltas := []protocol.FileRefJSON{
 		{
 			URL:     "n3.json",
 			Version: 3,
 			Hash:    "6e938ff1642485a651bf7cf14cd31c44eca17515909d8ddd9ed01efc840a61b1",
 		},
 	}
-	notification := persist.NotificationJSON{
-		NrtmFileJSON: persist.NrtmFileJSON{
+	notification := protocol.NotificationJSON{
+		NrtmFileJSON: protocol.NrtmFileJSON{
 			NrtmVersion: uint(4),
 			Version:     uint32(3),
 		},
@@ -71,16 +78,243 @@ func TestApplyDeltas(t *testing.T) {
 	}
 }
 
+func TestApplyDeltasToMemoryRepository(t *testing.T) {
+	repo := mem.NewRepository()
+	f := testresources.OpenFile(t, "nrtm-delta.multiple-ops-same-pk.jsonseq")
+	if f == nil {
+		t.Fatal("Could not open delta file")
+	}
+	defer f.Close()
+	bytes, _ := io.ReadAll(f)
+	p := NRTMProcessor{
+		repo:   repo,
+		config: AppConfig{NRTMFilePath: t.TempDir()},
+		client: stubDeltaClient{responseBody: string(bytes)},
+	}
+	notification := protocol.NotificationJSON{
+		NrtmFileJSON: protocol.NrtmFileJSON{NrtmVersion: uint(4), Version: uint32(3)},
+		DeltaRefs: []protocol.FileRefJSON{{
+			URL:     "n3.json",
+			Version: 3,
+			Hash:    "6e938ff1642485a651bf7cf14cd31c44eca17515909d8ddd9ed01efc840a61b1",
+		}},
+	}
+	source, err := repo.SaveSource(persist.NRTMSource{
+		Version:         uint32(2),
+		SessionID:       "db44e038-1f07-4d54-a307-1b32339f141a",
+		Source:          "RIPE",
+		NotificationURL: "http://test.test.test/unf.json",
+	}, notification)
+	if err != nil {
+		t.Fatal("Could not save source", err)
+	}
+	if err = syncDeltas(p, notification, source); err != nil {
+		t.Fatal("Failed to apply deltas", err)
+	}
+	objects, err := repo.LookupObjects(source, 0, []string{"5.134.117.150 - 5.134.117.150"})
+	if err != nil {
+		t.Fatal("Failed to look up object", err)
+	}
+	if len(objects) != 1 || !strings.Contains(objects[0].Payload, "BBBNET") {
+		t.Error("Expected the last version of the object but was", objects)
+	}
+}
+
 type stubDeltaClient struct {
-	notification persist.NotificationJSON
+	notification protocol.NotificationJSON
 	responseBody string
 }
 
-func (c stubDeltaClient) getUpdateNotification(string) (persist.NotificationJSON, error) {
-	return c.notification, nil
+func (c stubDeltaClient) getUpdateNotification(string) (protocol.NotificationJSON, http.Header, error) {
+	return c.notification, nil, nil
 }
 
 func (c stubDeltaClient) getResponseBody(string) (io.Reader, error) {
 	rdr := strings.NewReader(c.responseBody)
 	return rdr, nil
 }
+
+func (c stubDeltaClient) getFile(url string, _ fileRequest) (fileResponse, error) {
+	reader, err := c.getResponseBody(url)
+	return fileResponse{Body: reader}, err
+}
+
+type appliedFilesRepo struct {
+	persist.Repository
+	files []persist.NRTMFile
+}
+
+func (r appliedFilesRepo) GetFileByHash(source persist.NRTMSource, hash string) (*persist.NRTMFile, error) {
+	for _, f := range r.files {
+		if f.NrtmSourceID == source.ID && f.Hash == hash {
+			return &f, nil
+		}
+	}
+	return nil, nil
+}
+
+func TestCheckDeltaNotApplied(t *testing.T) {
+	repo := appliedFilesRepo{files: []persist.NRTMFile{
+		{Version: 4, Type: persist.DeltaFile, URL: "https://example.com/delta.4.json", Hash: "abc", NrtmSourceID: 1},
+		{Version: 1, Type: persist.SnapshotFile, URL: "https://example.com/snapshot.json", Hash: "def", NrtmSourceID: 1},
+	}}
+	source := persist.NRTMSource{ID: 1}
+	republished := protocol.FileRefJSON{Version: 5, URL: "https://example.com/delta.5.json", Hash: "abc"}
+	if err := checkDeltaNotApplied(repo, source, republished); !errors.Is(err, ErrNRTM4DeltaAlreadyApplied) {
+		t.Error("Expected ErrNRTM4DeltaAlreadyApplied but was", err)
+	}
+	if err := checkDeltaNotApplied(repo, persist.NRTMSource{ID: 2}, republished); err != nil {
+		t.Error("Files from another source should not match", err)
+	}
+	for _, hash := range []string{"xyz", "def", ""} {
+		ref := protocol.FileRefJSON{Version: 5, URL: "https://example.com/delta.5.json", Hash: hash}
+		if err := checkDeltaNotApplied(repo, source, ref); err != nil {
+			t.Error("Unexpected error for hash", hash, err)
+		}
+	}
+}
+
+type saveSourceRepo struct {
+	persist.Repository
+}
+
+func (r saveSourceRepo) SaveSource(source persist.NRTMSource, notification protocol.NotificationJSON) (persist.NRTMSource, error) {
+	return source, nil
+}
+
+func TestApplyDeltaFuncKeepsRawHeader(t *testing.T) {
+	sessionID := "ca128382-78d9-41d1-8927-1ecef15275be"
+	source := persist.NRTMSource{Source: "EXAMPLE", SessionID: sessionID, Version: 2}
+	raw := `{"nrtm_version": 4, "type": "delta", "source": "EXAMPLE", "session_id": "` + sessionID + `", "version": 3}`
+	header := new(protocol.DeltaFileJSON)
+	fn := applyDeltaFunc(saveSourceRepo{}, source, nil, nil, StrictnessStandard, quirks.Set{}, protocol.NotificationJSON{}, protocol.FileRefJSON{Version: 3}, deltaEventSink{}, header, nil)
+	if err := fn([]byte(raw), io.EOF); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if string(header.Raw) != raw || header.Version != 3 {
+		t.Error("Expected header to be kept verbatim", string(header.Raw))
+	}
+}
+
+type missingObjectRepo struct {
+	saveSourceRepo
+}
+
+func (r missingObjectRepo) DeleteObject(source persist.NRTMSource, objectType, primaryKey string, file protocol.NrtmFileJSON) error {
+	return errors.New("no rows in result set")
+}
+
+func TestDeleteOfMissingObjectIsAWarning(t *testing.T) {
+	sessionID := "ca128382-78d9-41d1-8927-1ecef15275be"
+	source := persist.NRTMSource{Source: "EXAMPLE", SessionID: sessionID, Version: 2}
+	header := new(protocol.DeltaFileJSON)
+	warnings := &syncWarnings{}
+	fn := applyDeltaFunc(missingObjectRepo{}, source, nil, nil, StrictnessStandard, quirks.Set{}, protocol.NotificationJSON{}, protocol.FileRefJSON{Version: 3}, deltaEventSink{}, header, warnings)
+	records := []string{
+		`{"nrtm_version": 4, "type": "delta", "source": "EXAMPLE", "session_id": "` + sessionID + `", "version": 3}`,
+		`{"action": "delete", "object_class": "route", "primary_key": "192.0.2.0/24AS65000"}`,
+	}
+	for _, record := range records {
+		if err := fn([]byte(record), nil); err != nil {
+			t.Fatal("Expected delete of a missing object not to fail the delta", err)
+		}
+	}
+	all := warnings.all()
+	if len(all) != 1 || all[0].Kind != WarningToleratedMismatch || all[0].Version != 3 {
+		t.Error("Expected one tolerated mismatch warning but was", all)
+	}
+	events := []DeltaEvent{}
+	sink := deltaEventSink{publisher: recordingPublisher{&events}, subject: "nrtm4.EXAMPLE", pending: new([]DeltaEvent)}
+	applier := deltaApplier{repo: missingObjectRepo{}, source: source, events: sink, header: header, warnings: warnings}
+	if err := applier.apply(persist.DeltaChange{Action: persist.DeltaDeleteAction, Object: rpsl.Rpsl{ObjectType: "ROUTE", PrimaryKey: "192.0.2.0/24AS65000"}}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	sink.flush()
+	if len(events) != 0 {
+		t.Error("Expected no event for a delete which failed but was", events)
+	}
+	var none *syncWarnings
+	none.add(WarningRetry, 0, "ignored")
+	if len(none.all()) != 0 {
+		t.Error("Expected a nil collector to ignore warnings")
+	}
+}
+
+// imagesRepo keeps the current objects and sets the version each change replaces
+type imagesRepo struct {
+	saveSourceRepo
+	objects map[string]rpsl.Rpsl
+}
+
+func (r imagesRepo) ApplyDeltaChanges(source persist.NRTMSource, changes []persist.DeltaChange, file protocol.NrtmFileJSON) ([]persist.DeltaChange, error) {
+	var missing []persist.DeltaChange
+	for i, change := range changes {
+		key := change.Object.PrimaryKey
+		if previous, ok := r.objects[key]; ok {
+			changes[i].Previous = &previous
+		} else if change.Action == persist.DeltaDeleteAction {
+			missing = append(missing, change)
+		}
+		if change.Action == persist.DeltaDeleteAction {
+			delete(r.objects, key)
+		} else {
+			r.objects[key] = change.Object
+		}
+	}
+	return missing, nil
+}
+
+type recordingPublisher struct {
+	events *[]DeltaEvent
+}
+
+func (p recordingPublisher) publish(subject string, payload []byte) error {
+	var event DeltaEvent
+	err := json.Unmarshal(payload, &event)
+	*p.events = append(*p.events, event)
+	return err
+}
+
+func (p recordingPublisher) close() error {
+	return nil
+}
+
+func TestDeltaEventsHavePreviousVersion(t *testing.T) {
+	sessionID := "ca128382-78d9-41d1-8927-1ecef15275be"
+	source := persist.NRTMSource{Source: "EXAMPLE", SessionID: sessionID, Version: 2}
+	repo := imagesRepo{objects: map[string]rpsl.Rpsl{}}
+	events := []DeltaEvent{}
+	sink := deltaEventSink{publisher: recordingPublisher{&events}, subject: "nrtm4.EXAMPLE", pending: new([]DeltaEvent)}
+	fn := applyDeltaFunc(repo, source, nil, nil, StrictnessStandard, quirks.Set{}, protocol.NotificationJSON{}, protocol.FileRefJSON{Version: 3}, sink, new(protocol.DeltaFileJSON), &syncWarnings{})
+	records := []string{
+		`{"nrtm_version": 4, "type": "delta", "source": "EXAMPLE", "session_id": "` + sessionID + `", "version": 3}`,
+		`{"action": "add_modify", "object": "route: 192.0.2.0/24\norigin: AS65000\ndescr: first\nsource: EXAMPLE\n"}`,
+		`{"action": "add_modify", "object": "route: 192.0.2.0/24\norigin: AS65000\ndescr: second\nsource: EXAMPLE\n"}`,
+		`{"action": "delete", "object_class": "route", "primary_key": "192.0.2.0/24AS65000"}`,
+	}
+	for _, record := range records {
+		if err := fn([]byte(record), nil); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	if len(events) != 0 {
+		t.Fatal("Expected no events to be published before the delta is applied but was", events)
+	}
+	sink.flush()
+	if len(events) != 3 {
+		t.Fatal("Expected an event for each change but was", events)
+	}
+	describe := func(payload *string) string {
+		if payload == nil {
+			return "none"
+		}
+		_, descr, _ := strings.Cut(*payload, "descr:")
+		return strings.Fields(descr)[0]
+	}
+	expected := [][2]string{{"none", "first"}, {"first", "second"}, {"second", "none"}}
+	for i, event := range events {
+		if images := [2]string{describe(event.Previous), describe(event.Object)}; images != expected[i] {
+			t.Error("Expected previous and object", expected[i], "but was", images)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package service
 
 import (
-	"encoding/json"
 	"io"
 	"log"
 	"sync"
@@ -9,21 +8,27 @@ import (
 
 	"github.com/petchells/nrtm4client/internal/nrtm4/jsonseq"
 	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/quirks"
 	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
 	"github.com/petchells/nrtm4client/internal/nrtm4/util"
 )
 
-type rpslObjectParser struct{}
+// rpslObjectParser parses snapshot records of a spec revision with a source's quirks
+type rpslObjectParser struct {
+	quirks   quirks.Set
+	revision protocol.Revision
+}
 
 type rpslParserPool struct {
 	Parsers chan rpslObjectParser
 }
 
-func newParserPool(limit int) *rpslParserPool {
+func newParserPool(limit int, objectQuirks quirks.Set) *rpslParserPool {
 	pool := rpslParserPool{}
 	pool.Parsers = make(chan rpslObjectParser, limit)
 	for range limit {
-		pool.Parsers <- rpslObjectParser{}
+		pool.Parsers <- rpslObjectParser{quirks: objectQuirks}
 	}
 	return &pool
 }
@@ -41,12 +46,16 @@ func (pool *rpslParserPool) Close() {
 }
 
 func (p *rpslObjectParser) bytesToRPSL(bytes []byte) *rpsl.Rpsl {
-	so := new(persist.SnapshotObjectJSON)
-	if err := json.Unmarshal(bytes, so); err != nil {
+	so := new(protocol.SnapshotObjectJSON)
+	record, err := p.revision.Canonical(bytes)
+	if err == nil {
+		err = decodeSnapshotObject(record, so)
+	}
+	if err != nil {
 		logger.Warn("Failed to unmarshal RPSL string from", "so.Object", so.Object, "error", err)
 		return nil
 	}
-	rpsl, err := rpsl.ParseFromJSONString(so.Object)
+	rpsl, err := p.quirks.Parse(so.Object)
 	if err != nil {
 		logger.Warn("Failed to parse rpsl.Rpsl from", "so.Object", so.Object, "error", err)
 	}
@@ -65,11 +74,23 @@ const (
 	FAILURE
 	// REPORT tells the counter to print sth
 	REPORT
+	// FILTERED tells the counter to add one to the count of objects left out by a filter
+	FILTERED
 )
 
-func snapshotObjectInsertFunc(repo persist.Repository, source persist.NRTMSource, notification persist.NotificationJSON) jsonseq.RecordReaderFunc {
+// snapshotObjectInsertFunc saves the objects in a snapshot file. The first record is read into
+// snapshotHeader. Objects are parsed with objectQuirks, and ones which don't pass filter are
+// left out.
+func snapshotObjectInsertFunc(
+	repo persist.Repository,
+	source persist.NRTMSource,
+	filter *FilterConfig,
+	objectQuirks quirks.Set,
+	notification protocol.NotificationJSON,
+	snapshotHeader *protocol.SnapshotFileJSON,
+	warnings *syncWarnings,
+) jsonseq.RecordReaderFunc {
 
-	var snapshotHeader *persist.SnapshotFileJSON
 	var wg sync.WaitGroup
 
 	objectList := util.NewLockingList[rpsl.Rpsl](rpslInsertBatchSize * 2)
@@ -77,6 +98,7 @@ func snapshotObjectInsertFunc(repo persist.Repository, source persist.NRTMSource
 	expectHeader := true
 	successCount := 0
 	failureCount := 0
+	filteredCount := 0
 
 	ticker := time.NewTicker(1 * time.Minute)
 	go func() {
@@ -90,8 +112,10 @@ func snapshotObjectInsertFunc(repo persist.Repository, source persist.NRTMSource
 					successCount++
 				case FAILURE:
 					failureCount++
+				case FILTERED:
+					filteredCount++
 				case REPORT:
-					logger.Info("Parsing snapshot file", "objects", successCount, "failed", failureCount)
+					logger.Info("Parsing snapshot file", "objects", successCount, "failed", failureCount, "filtered", filteredCount)
 				case STOP:
 					ticker.Stop()
 					return
@@ -100,9 +124,12 @@ func snapshotObjectInsertFunc(repo persist.Repository, source persist.NRTMSource
 		}
 	}()
 
-	parserPool := newParserPool(4)
+	parserPool := newParserPool(4, objectQuirks)
+	revision := protocol.Current
 	incrementCounters := func(res *rpsl.Rpsl) {
-		if obj := res; obj != nil {
+		if obj := res; obj != nil && !filter.keeps(*obj) {
+			counterMsgChan <- FILTERED
+		} else if obj != nil {
 			objectList.Add(*obj)
 			counterMsgChan <- SUCCESS
 		} else {
@@ -119,15 +146,22 @@ func snapshotObjectInsertFunc(repo persist.Repository, source persist.NRTMSource
 
 	return func(bytes []byte, err error) error {
 		if err == io.EOF {
-			// Expected error reading to end of snapshot objects
-			parser := parserPool.Acquire()
-			incrementCounters(parser.bytesToRPSL(bytes))
-			parserPool.Release(parser)
+			// Expected error reading to end of snapshot objects. There's no last record if it
+			// was quarantined.
+			if len(bytes) > 0 {
+				parser := parserPool.Acquire()
+				parser.revision = revision
+				incrementCounters(parser.bytesToRPSL(bytes))
+				parserPool.Release(parser)
+			}
 			wg.Wait()
 			parserPool.Close()
 			counterMsgChan <- STOP
 			close(counterMsgChan)
-			logger.Info("Closed snapshot file", "numFailures", failureCount, "numSuccess", successCount)
+			logger.Info("Closed snapshot file", "numFailures", failureCount, "numSuccess", successCount, "numFiltered", filteredCount)
+			if failureCount > 0 {
+				warnings.add(WarningSkippedRecord, snapshotHeader.Version, "%d snapshot records couldn't be parsed and were left out", failureCount)
+			}
 			rpslObjects := objectList.GetAll()
 			err = repo.SaveSnapshotObjects(source, rpslObjects, snapshotHeader.NrtmFileJSON)
 			if err != nil {
@@ -142,28 +176,30 @@ func snapshotObjectInsertFunc(repo persist.Repository, source persist.NRTMSource
 		} else if expectHeader {
 			// First record is the Snapshot header
 			expectHeader = false
-			sf := new(persist.SnapshotFileJSON)
-			if err = json.Unmarshal(bytes, sf); err != nil {
+			if snapshotHeader.NrtmFileJSON, revision, err = protocol.DecodeHeader(bytes); err != nil {
 				counterMsgChan <- FAILURE
 				counterMsgChan <- STOP
 				close(counterMsgChan)
 				logger.Warn("error unmarshalling JSON. Expected SnapshotFile", "error", err)
 				return err
 			}
-			if sf.Version != notification.SnapshotRef.Version {
+			if snapshotHeader.Version != notification.SnapshotRef.Version {
 				return ErrNRTM4FileVersionMismatch
 			}
-			snapshotHeader = sf
 			counterMsgChan <- SUCCESS
 			return nil
 		} else {
-			// Subsequent records are objects
+			// Subsequent records are objects. They're parsed after this returns, so the
+			// record has to be copied out of the reader's buffer.
+			record := jsonseq.Retain(bytes)
 			parser := parserPool.Acquire()
+			parser.revision = revision
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
 				defer parserPool.Release(parser)
-				incrementCounters(parser.bytesToRPSL(bytes))
+				defer jsonseq.Release(record)
+				incrementCounters(parser.bytesToRPSL(*record))
 			}()
 			return nil
 		}
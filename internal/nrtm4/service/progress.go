@@ -0,0 +1,202 @@
+package service
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// Stages of a sync, in the order they happen
+const (
+	// StageNotification the notification file is being fetched and checked
+	StageNotification = "notification"
+	// StageDownload a snapshot is being downloaded
+	StageDownload = "download"
+	// StageSnapshot a snapshot is being loaded
+	StageSnapshot = "snapshot"
+	// StageDeltas delta files are being downloaded and applied
+	StageDeltas = "deltas"
+	// StageDone the sync succeeded
+	StageDone = "done"
+	// StageFailed the sync stopped with an error
+	StageFailed = "failed"
+)
+
+// Progress is how far a connect or update has got, so dashboards can show it while it runs.
+// Percent and ETA are for the current stage, and are worked out from the bytes of its files
+// which have been read. The last sync of each source is kept after it finishes.
+type Progress struct {
+	Source       string     `json:"source"`
+	Label        string     `json:"label"`
+	URL          string     `json:"url"`
+	Operation    string     `json:"operation"`
+	RunID        uint64     `json:"run_id"`
+	Stage        string     `json:"stage"`
+	CurrentFile  string     `json:"current_file"`
+	Version      uint32     `json:"version"`
+	FilesDone    int        `json:"files_done"`
+	FilesTotal   int        `json:"files_total"`
+	Percent      float64    `json:"percent"`
+	ETA          *time.Time `json:"eta"`
+	Started      time.Time  `json:"started"`
+	StageStarted time.Time  `json:"stage_started"`
+	Updated      time.Time  `json:"updated"`
+	Error        string     `json:"error,omitempty"`
+}
+
+// progressBoard holds the progress of each source's latest sync. It's shared by all the
+// copies of a processor, so syncs started by the RPC API can be watched from the admin API.
+type progressBoard struct {
+	mu   sync.Mutex
+	runs map[string]*Progress
+}
+
+func newProgressBoard() *progressBoard {
+	return &progressBoard{runs: map[string]*Progress{}}
+}
+
+// begin starts tracking a sync, replacing the previous one of the same source
+func (b *progressBoard) begin(operation, url, label string, runID uint64) *syncProgress {
+	if b == nil {
+		return nil
+	}
+	now := util.AppClock.Now()
+	key := url + "\x00" + strings.TrimSpace(label)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.runs[key] = &Progress{
+		Label:        strings.TrimSpace(label),
+		URL:          url,
+		Operation:    operation,
+		RunID:        runID,
+		Stage:        StageNotification,
+		Started:      now,
+		StageStarted: now,
+		Updated:      now,
+	}
+	return &syncProgress{board: b, key: key}
+}
+
+// syncProgress updates the progress of one sync. A nil one does nothing, so code which also
+// runs outside a sync doesn't have to check.
+type syncProgress struct {
+	board *progressBoard
+	key   string
+}
+
+func (s *syncProgress) update(fn func(*Progress)) {
+	if s == nil {
+		return
+	}
+	s.board.mu.Lock()
+	defer s.board.mu.Unlock()
+	if run, ok := s.board.runs[s.key]; ok {
+		fn(run)
+		run.Updated = util.AppClock.Now()
+	}
+}
+
+// source sets the name of the source, which connect only knows after the notification is read
+func (s *syncProgress) source(name string) {
+	s.update(func(run *Progress) { run.Source = name })
+}
+
+// stage moves the sync on to a file, in a new stage or the same one
+func (s *syncProgress) stage(stage, file string, version uint32, filesDone, filesTotal int) {
+	s.update(func(run *Progress) {
+		if run.Stage != stage {
+			run.Stage = stage
+			run.StageStarted = util.AppClock.Now()
+			run.ETA = nil
+		}
+		run.CurrentFile = file
+		run.Version = version
+		run.FilesDone = filesDone
+		run.FilesTotal = filesTotal
+		run.setPercent(0)
+	})
+}
+
+// read records how much of the current file has been read
+func (s *syncProgress) read(n, size int64) {
+	if size <= 0 {
+		return
+	}
+	s.update(func(run *Progress) { run.setPercent(min(1, float64(n)/float64(size))) })
+}
+
+// finish records how the sync ended
+func (s *syncProgress) finish(err error) {
+	s.update(func(run *Progress) {
+		run.CurrentFile = ""
+		run.ETA = nil
+		if err != nil {
+			run.Stage = StageFailed
+			run.Error = err.Error()
+			return
+		}
+		run.Stage = StageDone
+		run.Percent = 100
+	})
+}
+
+// setPercent works out the percentage and ETA of the stage from the files which are done and
+// the part of the current one which has been read
+func (run *Progress) setPercent(fileRead float64) {
+	total := max(run.FilesTotal, 1)
+	run.Percent = min(100, (float64(run.FilesDone)+fileRead)*100/float64(total))
+	if run.Percent <= 0 {
+		run.ETA = nil
+		return
+	}
+	elapsed := util.AppClock.Now().Sub(run.StageStarted)
+	eta := run.StageStarted.Add(time.Duration(float64(elapsed) * 100 / run.Percent))
+	run.ETA = &eta
+}
+
+// all returns a copy of the progress of each source's latest sync, oldest first
+func (b *progressBoard) all() []Progress {
+	runs := []Progress{}
+	if b == nil {
+		return runs
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, run := range b.runs {
+		runs = append(runs, *run)
+	}
+	slices.SortFunc(runs, func(a, b Progress) int { return a.Started.Compare(b.Started) })
+	return runs
+}
+
+// progressReader tells a sync's progress how much of a file has been read
+type progressReader struct {
+	reader   io.Reader
+	progress *syncProgress
+	size     int64
+	read     int64
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	r.read += int64(n)
+	r.progress.read(r.read, r.size)
+	return n, err
+}
+
+// Progress returns the progress of the latest sync of each source, or of one source if
+// sourceName is given, for dashboards to show while syncs run
+func (p NRTMProcessor) Progress(sourceName, label string) []Progress {
+	runs := []Progress{}
+	for _, run := range p.board.all() {
+		if len(sourceName) > 0 && (!strings.EqualFold(run.Source, sourceName) || run.Label != strings.TrimSpace(label)) {
+			continue
+		}
+		runs = append(runs, run)
+	}
+	return runs
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+func TestProgress(t *testing.T) {
+	clock := util.AppClock
+	defer func() { util.AppClock = clock }()
+	now := util.NewManualClock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	util.AppClock = now
+
+	p := NRTMProcessor{board: newProgressBoard()}
+	progress := p.board.begin("update", "https://nrtm.example.net/notification.json", "prod ", 7)
+	progress.source("TEST")
+	progress.stage(StageDeltas, "https://nrtm.example.net/delta.3.json", 3, 1, 4)
+	now.Advance(time.Minute)
+	reader := &progressReader{reader: bytes.NewReader(make([]byte, 100)), progress: progress, size: 100}
+	buf := make([]byte, 50)
+	if _, err := reader.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	runs := p.Progress("test", "prod")
+	if len(runs) != 1 {
+		t.Fatal("Expected the update's progress but was", runs)
+	}
+	run := runs[0]
+	if run.Stage != StageDeltas || run.Version != 3 || run.Percent != 37.5 || run.RunID != 7 || run.ETA == nil {
+		t.Fatal("Unexpected progress", run)
+	}
+	if expected := run.StageStarted.Add(160 * time.Second); !run.ETA.Equal(expected) {
+		t.Error("Expected ETA", expected, "but was", run.ETA)
+	}
+	io.Copy(io.Discard, reader)
+	progress.finish(errors.New("delta has expired"))
+	if runs = p.Progress("", ""); len(runs) != 1 || runs[0].Stage != StageFailed || len(runs[0].Error) == 0 {
+		t.Error("Expected the update to have failed but was", runs)
+	}
+	if runs = p.Progress("TEST", ""); len(runs) != 0 {
+		t.Error("Expected no progress for another label but was", runs)
+	}
+	var none *syncProgress
+	none.stage(StageSnapshot, "", 0, 0, 1)
+	if runs = (NRTMProcessor{}).Progress("", ""); len(runs) != 0 {
+		t.Error("Expected no progress without a board")
+	}
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+// newRunID makes an ID for a connect or update. It's kept with each file the run applies, so
+// objects can be traced back to the run which stored them.
+func newRunID() uint64 {
+	// Positive, so it fits in a bigint
+	return rand.Uint64() >> 1
+}
+
+// writeProvenanceComment writes where a version came from as an RPSL comment
+func writeProvenanceComment(w io.Writer, prov persist.Provenance) {
+	fmt.Fprintf(w, "%% Provenance: source %v session %v version %d", prov.Source, prov.SessionID, prov.Version)
+	if len(prov.URL) > 0 {
+		fmt.Fprintf(w, " %v %v", prov.FileType, prov.URL)
+	}
+	if len(prov.Hash) > 0 {
+		fmt.Fprintf(w, " sha256 %v", prov.Hash)
+	}
+	if prov.RunID > 0 {
+		fmt.Fprintf(w, " run %d", prov.RunID)
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+var (
+	// publicationRaceRetries is how many more times a delta which isn't found yet is requested
+	publicationRaceRetries = 4
+	// publicationRaceBackoff is the wait before the first retry. It doubles after each one.
+	publicationRaceBackoff = 2 * time.Second
+)
+
+// fetchDeltaFile downloads a delta file. A server can publish its notification file a moment
+// before the deltas it lists, so a delta which isn't found is requested again with backoff
+// instead of failing the update. Retries ask caches to revalidate, so a cached 404 isn't
+// served again.
+func (fm fileManager) fetchDeltaFile(source persist.NRTMSource, fURL string, deltaRef protocol.FileRefJSON, path string, tempDir string) (*os.File, error) {
+	wait := publicationRaceBackoff
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		file, err := fm.fetchFile(fURL, deltaRef, path, tempDir, attempt > 0)
+		if err == nil {
+			if attempt > 0 {
+				logger.Warn("Delta was published after the notification file which lists it",
+					"source", sourceDisplayName(source),
+					"version", deltaRef.Version,
+					"url", fURL,
+					"retries", attempt,
+					"waited", waited,
+				)
+				fm.warnings.add(WarningRetry, deltaRef.Version, "%v was not found until %v after the notification file listed it", fURL, waited)
+			}
+			return file, nil
+		}
+		if !isNotFound(err) || attempt >= publicationRaceRetries {
+			return nil, err
+		}
+		logger.Info("Delta not found yet, retrying", "version", deltaRef.Version, "url", fURL, "wait", wait)
+		util.AppClock.Sleep(wait)
+		waited += wait
+		wait *= 2
+	}
+}
+
+func isNotFound(err error) bool {
+	var respErr HTTPResponseError
+	return errors.As(err, &respErr) && respErr.Status == http.StatusNotFound
+}
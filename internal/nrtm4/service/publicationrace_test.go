@@ -0,0 +1,70 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+func TestDeltaPublishedLateIsRetried(t *testing.T) {
+	body := "Not yet, not yet, the delta is coming."
+	requests := 0
+	cacheControl := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		cacheControl = append(cacheControl, r.Header.Get("Cache-Control"))
+		if requests < 3 {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	start := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	clock := util.NewManualClock(start)
+	util.AppClock = clock
+
+	sum := sha256.Sum256([]byte(body))
+	ref := protocol.FileRefJSON{Version: 7, Hash: hex.EncodeToString(sum[:])}
+	fm := fileManager{client: HTTPClient{}}
+	f, err := fm.fetchDeltaFile(persist.NRTMSource{Source: "TEST"}, server.URL+"/delta.7.json", ref, t.TempDir(), "")
+	if err != nil {
+		t.Fatal("Expected delta to be fetched after retrying but was", err)
+	}
+	f.Close()
+	if len(cacheControl) != 3 || cacheControl[0] != "" || cacheControl[1] != "no-cache" || cacheControl[2] != "no-cache" {
+		t.Error("Expected retries to revalidate", cacheControl)
+	}
+	if waited := clock.Since(start); waited != 3*publicationRaceBackoff {
+		t.Error("Expected to back off for", 3*publicationRaceBackoff, "but waited", waited)
+	}
+}
+
+func TestDeltaRetriesAreBounded(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	util.AppClock = util.NewManualClock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+
+	fm := fileManager{client: HTTPClient{}}
+	_, err := fm.fetchDeltaFile(persist.NRTMSource{Source: "TEST"}, server.URL+"/delta.8.json", protocol.FileRefJSON{Version: 8}, t.TempDir(), "")
+	if !isNotFound(err) {
+		t.Error("Expected not found but was", err)
+	}
+	if requests != publicationRaceRetries+1 {
+		t.Error("Expected", publicationRaceRetries+1, "requests but was", requests)
+	}
+}
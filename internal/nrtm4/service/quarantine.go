@@ -0,0 +1,99 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+var (
+	// ErrSourceQuarantined the source failed with a protocol error and won't be retried yet
+	ErrSourceQuarantined = errors.New("source is quarantined")
+
+	// quarantineFirstDelay is how long a source waits after its first protocol error. It
+	// doubles with each failure, up to quarantineMaxDelay.
+	quarantineFirstDelay = 5 * time.Minute
+	quarantineMaxDelay   = 24 * time.Hour
+
+	// protocolErrors mean the server published something we can't use. Trying again
+	// straight away won't help.
+	protocolErrors = []error{
+		ErrNRTM4VersionMismatch,
+		ErrNRTM4SourceMismatch,
+		ErrNRTM4SourceNameMismatch,
+		ErrNRTM4FileVersionMismatch,
+		ErrNRTM4FileVersionInconsistency,
+		ErrNRTM4NoDeltasInNotification,
+		ErrNRTM4NotificationDeltaSequenceBroken,
+		ErrNRTM4NotificationVersionDoesNotMatchDelta,
+		ErrNRTM4DuplicateDeltaVersion,
+		ErrNRTM4DeltaAlreadyApplied,
+		ErrNextConsecutiveDeltaUnavaliable,
+		ErrHashMismatch,
+		ErrSignatureInvalid,
+		ErrGzipChecksum,
+		ErrGzipMember,
+	}
+)
+
+func isProtocolError(err error) bool {
+	var serviceErr ErrNRTMServiceError
+	if errors.As(err, &serviceErr) {
+		return true
+	}
+	for _, pe := range protocolErrors {
+		if errors.Is(err, pe) {
+			return true
+		}
+	}
+	return false
+}
+
+// quarantineDelay is how long to wait before the next attempt after `failures` protocol errors
+func quarantineDelay(failures int) time.Duration {
+	delay := quarantineFirstDelay
+	for i := 1; i < failures && delay < quarantineMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, quarantineMaxDelay)
+}
+
+// checkQuarantine returns an error if the source is quarantined and it's not time to retry
+func checkQuarantine(source persist.NRTMSource, now time.Time) error {
+	q := source.Quarantine
+	if q == nil || !now.Before(q.Until) {
+		return nil
+	}
+	return fmt.Errorf("%w until %v after %d failures: %v", ErrSourceQuarantined, q.Until.Format(time.RFC3339), q.Failures, q.Reason)
+}
+
+// updateQuarantine quarantines a source after a protocol error, extending the quarantine if it
+// was already in one, and releases it after a successful update
+func (p NRTMProcessor) updateQuarantine(source persist.NRTMSource, updateErr error) {
+	var q *persist.Quarantine
+	switch {
+	case updateErr == nil && source.Quarantine == nil:
+		return
+	case updateErr == nil:
+		logger.Info("Source released from quarantine", "source", source.Source, "label", source.Label)
+	case !isProtocolError(updateErr):
+		return
+	default:
+		failures := 1
+		if source.Quarantine != nil {
+			failures = source.Quarantine.Failures + 1
+		}
+		q = &persist.Quarantine{
+			Failures: failures,
+			Reason:   updateErr.Error(),
+			Until:    util.AppClock.Now().Add(quarantineDelay(failures)),
+		}
+		logger.Warn("Source quarantined", "source", source.Source, "label", source.Label, "until", q.Until, "failures", failures, "reason", q.Reason)
+	}
+	if err := p.repo.SaveQuarantine(source, q); err != nil {
+		logger.Error("Failed to save quarantine", "source", source.Source, "error", err)
+	}
+}
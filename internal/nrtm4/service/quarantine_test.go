@@ -0,0 +1,77 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+type quarantineRepo struct {
+	persist.Repository
+	saved map[string]*persist.Quarantine
+}
+
+func (r quarantineRepo) SaveQuarantine(source persist.NRTMSource, q *persist.Quarantine) error {
+	r.saved[source.Source] = q
+	return nil
+}
+
+func TestQuarantineDelay(t *testing.T) {
+	expected := map[int]time.Duration{
+		1:  5 * time.Minute,
+		2:  10 * time.Minute,
+		4:  40 * time.Minute,
+		9:  1280 * time.Minute,
+		10: 24 * time.Hour,
+		50: 24 * time.Hour,
+	}
+	for failures, exp := range expected {
+		if d := quarantineDelay(failures); d != exp {
+			t.Error("Expected delay", exp, "after", failures, "failures but was", d)
+		}
+	}
+}
+
+func TestCheckQuarantine(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	source := persist.NRTMSource{Source: "RIPE"}
+	if err := checkQuarantine(source, now); err != nil {
+		t.Error("Expected no error for source without quarantine", err)
+	}
+	source.Quarantine = &persist.Quarantine{Failures: 1, Reason: "bad hash", Until: now.Add(time.Minute)}
+	if err := checkQuarantine(source, now); !errors.Is(err, ErrSourceQuarantined) {
+		t.Error("Expected ErrSourceQuarantined but was", err)
+	}
+	if err := checkQuarantine(source, now.Add(time.Minute)); err != nil {
+		t.Error("Expected quarantine to have ended", err)
+	}
+}
+
+func TestUpdateQuarantine(t *testing.T) {
+	repo := quarantineRepo{saved: map[string]*persist.Quarantine{}}
+	p := NRTMProcessor{repo: repo}
+	source := persist.NRTMSource{Source: "RIPE"}
+
+	p.updateQuarantine(source, errors.New("connection refused"))
+	if _, ok := repo.saved["RIPE"]; ok {
+		t.Error("Network errors should not quarantine a source")
+	}
+	p.updateQuarantine(source, fmt.Errorf("%w: snapshot.json", ErrHashMismatch))
+	q := repo.saved["RIPE"]
+	if q == nil || q.Failures != 1 {
+		t.Fatal("Expected source to be quarantined", q)
+	}
+	source.Quarantine = q
+	p.updateQuarantine(source, ErrNRTMServiceError{Message: "500"})
+	if q = repo.saved["RIPE"]; q == nil || q.Failures != 2 {
+		t.Fatal("Expected quarantine to be extended", q)
+	}
+	source.Quarantine = q
+	p.updateQuarantine(source, nil)
+	if q, ok := repo.saved["RIPE"]; !ok || q != nil {
+		t.Error("Expected quarantine to be cleared", q)
+	}
+}
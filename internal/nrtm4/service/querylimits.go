@@ -0,0 +1,176 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMaxConcurrentQueries is how many web API queries run at once when the limit isn't set
+	defaultMaxConcurrentQueries = 4
+	// maxRememberedCosts is how many kinds of query the costs of are remembered, so clients can't
+	// grow the memory used without bound by asking for different ones
+	maxRememberedCosts = 1000
+)
+
+var (
+	// ErrQueryBusy as many queries as the limit allows are running already
+	ErrQueryBusy = errors.New("too many queries are running, try again later")
+	// ErrQueryTooExpensive a query would read more objects than the limit allows
+	ErrQueryTooExpensive = errors.New("query reads too many objects")
+	// ErrQueryTimeout a query ran for longer than the limit allows
+	ErrQueryTimeout = errors.New("query took too long")
+	// ErrInvalidQueryLimits a limit is negative, or the timeout isn't a duration
+	ErrInvalidQueryLimits = errors.New("query limits must be positive and the timeout a duration")
+)
+
+// QueryLimitsConfig limits the queries nrtm4serve's web API runs for its clients, so expensive
+// ones can't use the database connections and time the syncs need. Zero leaves a limit off.
+type QueryLimitsConfig struct {
+	// MaxRows is the most objects one query may read
+	MaxRows int `json:"max_rows"`
+	// Timeout is how long one query may run, e.g. "30s"
+	Timeout string `json:"timeout"`
+	// MaxConcurrent is how many queries may run at once. Default is 4.
+	MaxConcurrent int `json:"max_concurrent"`
+}
+
+func (c QueryLimitsConfig) validate() error {
+	if c.MaxRows < 0 || c.MaxConcurrent < 0 {
+		return ErrInvalidQueryLimits
+	}
+	if len(c.Timeout) > 0 {
+		if d, err := time.ParseDuration(c.Timeout); err != nil || d < 0 {
+			return fmt.Errorf("%w: %v", ErrInvalidQueryLimits, c.Timeout)
+		}
+	}
+	return nil
+}
+
+func (c QueryLimitsConfig) timeout() time.Duration {
+	d, _ := time.ParseDuration(c.Timeout)
+	return d
+}
+
+func (c QueryLimitsConfig) maxConcurrent() int {
+	if c.MaxConcurrent > 0 {
+		return c.MaxConcurrent
+	}
+	return defaultMaxConcurrentQueries
+}
+
+// queryGate admits web API queries within the limits. Copies of a processor share it.
+type queryGate struct {
+	limits QueryLimitsConfig
+	slots  chan struct{}
+	mu     sync.Mutex
+	// costs are the number of objects each kind of query read the last time it ran, which is
+	// its estimated cost the next time
+	costs map[string]int
+}
+
+func newQueryGate(limits QueryLimitsConfig) *queryGate {
+	return &queryGate{
+		limits: limits,
+		slots:  make(chan struct{}, limits.maxConcurrent()),
+		costs:  map[string]int{},
+	}
+}
+
+// Query is a web API query which has been admitted. It holds one of the slots until End is
+// called.
+type Query struct {
+	gate     *queryGate
+	key      string
+	deadline time.Time
+	rows     int
+	stopped  bool
+}
+
+// BeginQuery admits a query from the web API if its estimated cost is within the limits and
+// another query may run. key identifies the kind of query, such as an export of a source's
+// objects, and estimate is the number of objects it's expected to read. When the same kind of
+// query has run before, the number it read then is used if that's more. It returns
+// ErrQueryTooExpensive or ErrQueryBusy without running the query, and the caller must End it
+// otherwise.
+func (p NRTMProcessor) BeginQuery(key string, estimate int) (*Query, error) {
+	g := p.queries
+	if g == nil {
+		return nil, nil
+	}
+	if maxRows := g.limits.MaxRows; maxRows > 0 {
+		g.mu.Lock()
+		estimate = max(estimate, g.costs[key])
+		g.mu.Unlock()
+		if estimate > maxRows {
+			logger.Info("Rejected expensive query", "query", key, "estimate", estimate, "max_rows", maxRows)
+			return nil, fmt.Errorf("%w: about %d objects, the limit is %d", ErrQueryTooExpensive, estimate, maxRows)
+		}
+	}
+	select {
+	case g.slots <- struct{}{}:
+	default:
+		logger.Info("Rejected query, too many are running", "query", key, "max_concurrent", cap(g.slots))
+		return nil, ErrQueryBusy
+	}
+	q := &Query{gate: g, key: key}
+	if timeout := g.limits.timeout(); timeout > 0 {
+		q.deadline = time.Now().Add(timeout)
+	}
+	return q, nil
+}
+
+// WithQuery returns a copy of the processor whose reads count towards the query's limits. The
+// database cancels its statements when the query's time is up, with persist.ErrStatementTimeout.
+func (p NRTMProcessor) WithQuery(q *Query) NRTMProcessor {
+	p.query = q
+	if q != nil && !q.deadline.IsZero() {
+		p.repo = p.repo.WithStatementTimeout(max(time.Until(q.deadline), time.Millisecond))
+	}
+	return p
+}
+
+// End frees the query's slot, and remembers how many objects it read
+func (q *Query) End() {
+	if q == nil {
+		return
+	}
+	g := q.gate
+	<-g.slots
+	if g.limits.MaxRows == 0 {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.costs) >= maxRememberedCosts {
+		clear(g.costs)
+	}
+	if q.stopped {
+		// It didn't finish, so it would have read at least as many as last time
+		g.costs[q.key] = max(g.costs[q.key], q.rows)
+	} else {
+		g.costs[q.key] = q.rows
+	}
+}
+
+// read counts objects read by the query, and returns an error if it has read more than the
+// limit or run out of time. The objects counted are those the query returns, not the rows the
+// database scanned for them. It does nothing for a nil query, e.g. from the CLI.
+func (q *Query) read(n int) error {
+	if q == nil {
+		return nil
+	}
+	q.rows += n
+	limits := q.gate.limits
+	if limits.MaxRows > 0 && q.rows > limits.MaxRows {
+		q.stopped = true
+		return fmt.Errorf("%w: the limit is %d", ErrQueryTooExpensive, limits.MaxRows)
+	}
+	if !q.deadline.IsZero() && time.Now().After(q.deadline) {
+		q.stopped = true
+		return fmt.Errorf("%w: the limit is %v", ErrQueryTimeout, limits.Timeout)
+	}
+	return nil
+}
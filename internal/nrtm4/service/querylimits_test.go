@@ -0,0 +1,132 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+// objectsRepo has one source with a number of current objects
+type objectsRepo struct {
+	persist.Repository
+	objects int
+	timeout time.Duration
+}
+
+func (r objectsRepo) WithStatementTimeout(timeout time.Duration) persist.Repository {
+	r.timeout = timeout
+	return r
+}
+
+func (r objectsRepo) GetSources() ([]persist.NRTMSource, error) {
+	return []persist.NRTMSource{{ID: 1, Source: "EXAMPLE"}}, nil
+}
+
+func (r objectsRepo) GetCurrentObjects(source persist.NRTMSource, version uint32, objectTypes []string, fn func(rpsl.Rpsl) error) error {
+	for range r.objects {
+		if err := fn(rpsl.Rpsl{Payload: "mntner: EXAMPLE-MNT\n"}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestQueryConcurrencyLimit(t *testing.T) {
+	p := NewNRTMProcessor(AppConfig{QueryLimits: QueryLimitsConfig{MaxConcurrent: 1}}, objectsRepo{}, nil)
+	q, err := p.BeginQuery("lookup", 1)
+	if err != nil {
+		t.Fatal("Expected the first query to be admitted", err)
+	}
+	if _, err = p.BeginQuery("lookup", 1); !errors.Is(err, ErrQueryBusy) {
+		t.Error("Expected ErrQueryBusy but was", err)
+	}
+	q.End()
+	q, err = p.BeginQuery("lookup", 1)
+	if err != nil {
+		t.Error("Expected a query to be admitted after the first ended", err)
+	}
+	q.End()
+}
+
+func TestQueryRowLimit(t *testing.T) {
+	p := NewNRTMProcessor(AppConfig{QueryLimits: QueryLimitsConfig{MaxRows: 10}}, objectsRepo{objects: 20}, nil)
+	if _, err := p.BeginQuery("lookup", 11); !errors.Is(err, ErrQueryTooExpensive) {
+		t.Error("Expected an estimate over the limit to be rejected but was", err)
+	}
+	q, err := p.BeginQuery("export EXAMPLE", 0)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	var sb strings.Builder
+	n, err := p.WithQuery(q).ExportObjects(&sb, "EXAMPLE", "", 0, nil)
+	q.End()
+	if !errors.Is(err, ErrQueryTooExpensive) || n != 10 {
+		t.Error("Expected the export to stop at the limit", n, err)
+	}
+	if _, err = p.BeginQuery("export EXAMPLE", 0); !errors.Is(err, ErrQueryTooExpensive) {
+		t.Error("Expected the next export to be rejected from its last cost but was", err)
+	}
+	if _, err = p.ExportObjects(&sb, "EXAMPLE", "", 0, nil); err != nil {
+		t.Error("Expected exports outside the web API not to be limited", err)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	p := NewNRTMProcessor(AppConfig{QueryLimits: QueryLimitsConfig{Timeout: "1ns"}}, objectsRepo{objects: 5}, nil)
+	q, err := p.BeginQuery("export EXAMPLE", 0)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	defer q.End()
+	var sb strings.Builder
+	if _, err = p.WithQuery(q).ExportObjects(&sb, "EXAMPLE", "", 0, nil); !errors.Is(err, ErrQueryTimeout) {
+		t.Error("Expected ErrQueryTimeout but was", err)
+	}
+}
+
+func TestQueryStatementTimeout(t *testing.T) {
+	p := NewNRTMProcessor(AppConfig{QueryLimits: QueryLimitsConfig{Timeout: "30s"}}, objectsRepo{}, nil)
+	q, err := p.BeginQuery("lookup", 1)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	defer q.End()
+	if timeout := p.WithQuery(q).repo.(objectsRepo).timeout; timeout <= 0 || timeout > 30*time.Second {
+		t.Error("Expected the repository's statements to time out with the query but was", timeout)
+	}
+	if timeout := p.repo.(objectsRepo).timeout; timeout != 0 {
+		t.Error("Expected the processor's own repository to have no timeout but was", timeout)
+	}
+}
+
+func TestQueryLookupRowLimit(t *testing.T) {
+	repo := &lookupRepo{objects: []rpsl.Rpsl{{ObjectType: "MNTNER", PrimaryKey: "A-MNT"}, {ObjectType: "MNTNER", PrimaryKey: "B-MNT"}}}
+	p := NewNRTMProcessor(AppConfig{QueryLimits: QueryLimitsConfig{MaxRows: 1}}, repo, nil)
+	q, err := p.BeginQuery("lookup", 1)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	_, err = p.WithQuery(q).Lookup("TEST", "", 0, []string{"A-MNT", "B-MNT"})
+	q.End()
+	if !errors.Is(err, ErrQueryTooExpensive) {
+		t.Error("Expected a lookup returning more objects than the limit to fail but was", err)
+	}
+	if _, err = p.Lookup("TEST", "", 0, []string{"A-MNT", "B-MNT"}); err != nil {
+		t.Error("Expected lookups outside the web API not to be limited", err)
+	}
+}
+
+func TestQueryLimitsValidate(t *testing.T) {
+	for _, limits := range []QueryLimitsConfig{{MaxRows: -1}, {MaxConcurrent: -1}, {Timeout: "soon"}} {
+		if err := limits.validate(); !errors.Is(err, ErrInvalidQueryLimits) {
+			t.Error("Expected ErrInvalidQueryLimits for", limits, "but was", err)
+		}
+	}
+	if err := (QueryLimitsConfig{MaxRows: 1000, Timeout: "30s", MaxConcurrent: 2}).validate(); err != nil {
+		t.Error("Unexpected error", err)
+	}
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// ErrNoRDAPType RDAP has no lookup for the object type
+var ErrNoRDAPType = errors.New("RDAP can't look up objects of the type")
+
+// maxRDAPResponse is the most that's read from an RDAP server for one lookup
+const maxRDAPResponse = 4 << 20
+
+// knownRDAPServers are used for sources without a whois server, or an RDAP server in the config
+// file
+var knownRDAPServers = map[string]string{
+	"ARIN":   "https://rdap.arin.net/registry",
+	"LACNIC": "https://rdap.lacnic.net/rdap",
+}
+
+// rdapObject is the part of an RDAP response which has an RPSL attribute
+type rdapObject struct {
+	Handle       string  `json:"handle"`
+	Name         string  `json:"name"`
+	Country      string  `json:"country"`
+	StartAutnum  *uint32 `json:"startAutnum"`
+	StartAddress string  `json:"startAddress"`
+	EndAddress   string  `json:"endAddress"`
+	LdhName      string  `json:"ldhName"`
+	VCardArray   []any   `json:"vcardArray"`
+}
+
+// rdapPath is the RDAP lookup for an object. RDAP looks up networks by address, so an inetnum or
+// inet6num is looked up by its first address, and the server returns the most specific network
+// with it.
+func rdapPath(objectType, key string) (string, error) {
+	switch objectType {
+	case "AUT-NUM":
+		return "autnum/" + url.PathEscape(strings.TrimPrefix(key, "AS")), nil
+	case "INETNUM":
+		start, _, _ := strings.Cut(key, "-")
+		return "ip/" + url.PathEscape(strings.TrimSpace(start)), nil
+	case "INET6NUM":
+		prefix, err := netip.ParsePrefix(key)
+		if err != nil {
+			return "", err
+		}
+		return "ip/" + url.PathEscape(prefix.Addr().String()), nil
+	case "DOMAIN":
+		return "domain/" + url.PathEscape(key), nil
+	case "PERSON", "ROLE", "ORGANISATION", "MNTNER":
+		return "entity/" + url.PathEscape(key), nil
+	}
+	return "", fmt.Errorf("%w: %v", ErrNoRDAPType, objectType)
+}
+
+// queryRDAP looks an object up on an RDAP server and returns it as RPSL, with only the
+// attributes RDAP has a field for. It's empty if the server doesn't have the object.
+func (p NRTMProcessor) queryRDAP(server, sourceName, objectType, key string) (string, error) {
+	path, err := rdapPath(objectType, key)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), whoisTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(server, "/")+"/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+	req.Header.Set("User-Agent", util.UserAgent())
+	httpLogger.Debug("Querying RDAP", "url", req.URL)
+	resp, err := p.config.Network.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("RDAP server returned %v", resp.Status)
+	}
+	var obj rdapObject
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxRDAPResponse)).Decode(&obj); err != nil {
+		return "", err
+	}
+	return obj.rpsl(objectType, sourceName), nil
+}
+
+// rpsl writes the object's fields as the attributes of an RPSL object of objectType
+func (obj rdapObject) rpsl(objectType, sourceName string) string {
+	var lines [][2]string
+	add := func(name, value string) {
+		if len(value) > 0 {
+			lines = append(lines, [2]string{name, value})
+		}
+	}
+	switch objectType {
+	case "AUT-NUM":
+		if obj.StartAutnum != nil {
+			add("aut-num", fmt.Sprintf("AS%d", *obj.StartAutnum))
+		}
+		add("as-name", obj.Name)
+	case "INETNUM":
+		if len(obj.StartAddress) > 0 {
+			add("inetnum", obj.StartAddress+" - "+obj.EndAddress)
+		}
+		add("netname", obj.Name)
+	case "INET6NUM":
+		add("inet6num", addressRangePrefix(obj.StartAddress, obj.EndAddress))
+		add("netname", obj.Name)
+	case "DOMAIN":
+		add("domain", strings.TrimSuffix(obj.LdhName, "."))
+	case "PERSON", "ROLE":
+		add(strings.ToLower(objectType), obj.fullName())
+		add("nic-hdl", obj.Handle)
+	case "ORGANISATION":
+		add("organisation", obj.Handle)
+		add("org-name", obj.fullName())
+	case "MNTNER":
+		add("mntner", obj.Handle)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	if objectType == "INETNUM" || objectType == "INET6NUM" {
+		add("country", obj.Country)
+	}
+	add("source", strings.ToUpper(sourceName))
+	var sb strings.Builder
+	for _, line := range lines {
+		fmt.Fprintf(&sb, "%-16s%s\n", line[0]+":", line[1])
+	}
+	return sb.String()
+}
+
+// fullName is the fn property of the object's jCard
+func (obj rdapObject) fullName() string {
+	if len(obj.VCardArray) != 2 {
+		return ""
+	}
+	properties, _ := obj.VCardArray[1].([]any)
+	for _, property := range properties {
+		values, _ := property.([]any)
+		if len(values) == 4 && values[0] == "fn" {
+			name, _ := values[3].(string)
+			return name
+		}
+	}
+	return ""
+}
+
+// addressRangePrefix is the prefix which starts at start and ends at end, or empty if there's
+// none
+func addressRangePrefix(start, end string) string {
+	first, err := netip.ParseAddr(start)
+	if err != nil {
+		return ""
+	}
+	last, err := netip.ParseAddr(end)
+	if err != nil {
+		return ""
+	}
+	for bits := 0; bits <= first.BitLen(); bits++ {
+		prefix := netip.PrefixFrom(first, bits)
+		if prefix.Masked().Addr() == first && lastAddress(prefix) == last {
+			return prefix.String()
+		}
+	}
+	return ""
+}
+
+func lastAddress(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
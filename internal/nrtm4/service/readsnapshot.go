@@ -0,0 +1,158 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+var (
+	// ErrInvalidReadSnapshot the read snapshot token can't be parsed
+	ErrInvalidReadSnapshot = errors.New("invalid read snapshot")
+	// ErrSourceNotPinned the source isn't one of the read snapshot's sources
+	ErrSourceNotPinned = errors.New("source is not in the read snapshot")
+	// ErrPinnedSessionGone the source has been re-initialized with a new session since the read
+	// snapshot was taken, so its versions aren't the ones that were pinned
+	ErrPinnedSessionGone = errors.New("the source's session has changed since the read snapshot was taken")
+)
+
+// ReadSnapshot is the versions several sources were at at the same moment. Reading each source
+// at its pinned version, e.g. with ExportObjects or Lookup, gives a consistent view of them all,
+// however many deltas are applied while the reads take place.
+type ReadSnapshot struct {
+	Taken   time.Time      `json:"taken"`
+	Sources []PinnedSource `json:"sources"`
+}
+
+// PinnedSource is the session and version a source was at when a ReadSnapshot was taken
+type PinnedSource struct {
+	Source    string `json:"source"`
+	Label     string `json:"label"`
+	SessionID string `json:"session_id"`
+	Version   uint32 `json:"version"`
+}
+
+// PinSources takes a ReadSnapshot of the sources named by members, which are written as group
+// members are. Every source which has an applied version is pinned when there are no members.
+// The versions readers see are read in one statement, so no sync can make a version visible
+// between reading one source and the next.
+func (p NRTMProcessor) PinSources(members []string) (ReadSnapshot, error) {
+	all, err := p.repo.GetSources()
+	if err != nil {
+		return ReadSnapshot{}, err
+	}
+	sources := all
+	if len(members) > 0 {
+		sources = make([]persist.NRTMSource, 0, len(members))
+		for _, member := range members {
+			name, label, _ := strings.Cut(member, groupMemberSeparator)
+			i := slices.IndexFunc(all, func(source persist.NRTMSource) bool {
+				return strings.EqualFold(source.Source, name) && strings.EqualFold(source.Label, label)
+			})
+			if i < 0 {
+				return ReadSnapshot{}, fmt.Errorf("%w: %v", ErrSourceNotFound, member)
+			}
+			sources = append(sources, all[i])
+		}
+	}
+	taken := util.AppClock.Now()
+	versions, err := p.repo.GetAppliedVersions(sources)
+	if err != nil {
+		return ReadSnapshot{}, err
+	}
+	snapshot := ReadSnapshot{Taken: taken, Sources: []PinnedSource{}}
+	for i, source := range sources {
+		if versions[i] == 0 {
+			// 0 reads the latest version, so a source still loading its snapshot can't be pinned
+			if len(members) == 0 {
+				continue
+			}
+			return ReadSnapshot{}, fmt.Errorf("%w: %v %q has no applied version", persist.ErrVersionNotApplied, source.Source, source.Label)
+		}
+		snapshot.Sources = append(snapshot.Sources, PinnedSource{Source: source.Source, Label: source.Label, SessionID: source.SessionID, Version: versions[i]})
+	}
+	return snapshot, nil
+}
+
+// PinGroup takes a ReadSnapshot of the sources in a group
+func (p NRTMProcessor) PinGroup(group string) (ReadSnapshot, error) {
+	for name, members := range p.config.Groups {
+		if strings.EqualFold(name, group) {
+			return p.PinSources(members)
+		}
+	}
+	return ReadSnapshot{}, ErrGroupNotFound
+}
+
+// Token writes the pinned versions as SOURCE[/label]=VERSION@SESSION, separated by commas, so a
+// ReadSnapshot can be passed to later commands and requests, e.g.
+// RIPE=1234@ca128382-78d9-41d1-8927-1ecef15275be,ARIN/prod=567@0dd3a7b7-5e5e-4bd6-9b0a-4e35a1a5b1b2
+func (s ReadSnapshot) Token() string {
+	pins := make([]string, 0, len(s.Sources))
+	for _, pinned := range s.Sources {
+		member := pinned.Source
+		if len(pinned.Label) > 0 {
+			member += groupMemberSeparator + pinned.Label
+		}
+		pins = append(pins, fmt.Sprintf("%v=%d@%v", member, pinned.Version, pinned.SessionID))
+	}
+	return strings.Join(pins, ",")
+}
+
+// ParseReadSnapshot reads the pinned versions from a Token. The time it was taken isn't in the
+// token, so it's zero.
+func ParseReadSnapshot(token string) (ReadSnapshot, error) {
+	snapshot := ReadSnapshot{Sources: []PinnedSource{}}
+	for _, pin := range strings.Split(token, ",") {
+		member, v, ok := strings.Cut(strings.TrimSpace(pin), "=")
+		name, label, _ := strings.Cut(member, groupMemberSeparator)
+		v, sessionID, hasSession := strings.Cut(v, "@")
+		if !ok || len(name) == 0 || !hasSession || len(sessionID) == 0 {
+			return ReadSnapshot{}, fmt.Errorf("%w: %q isn't SOURCE=VERSION@SESSION", ErrInvalidReadSnapshot, pin)
+		}
+		version, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || version == 0 {
+			return ReadSnapshot{}, fmt.Errorf("%w: %q has no version", ErrInvalidReadSnapshot, pin)
+		}
+		snapshot.Sources = append(snapshot.Sources, PinnedSource{Source: name, Label: label, SessionID: sessionID, Version: uint32(version)})
+	}
+	return snapshot, nil
+}
+
+// Version is the version a source is pinned at
+func (s ReadSnapshot) Version(sourceName, label string) (uint32, error) {
+	for _, pinned := range s.Sources {
+		if strings.EqualFold(pinned.Source, sourceName) && strings.EqualFold(pinned.Label, label) {
+			return pinned.Version, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %v %q", ErrSourceNotPinned, sourceName, label)
+}
+
+// PinnedVersion is the version a source is pinned at, as long as the source is still in the
+// session it was pinned in. Reading a version from before the source's baseline, e.g. after its
+// history has been squashed, fails with persist.ErrVersionNotInRepo.
+func (p NRTMProcessor) PinnedVersion(snapshot ReadSnapshot, sourceName, label string) (uint32, error) {
+	i := slices.IndexFunc(snapshot.Sources, func(pinned PinnedSource) bool {
+		return strings.EqualFold(pinned.Source, sourceName) && strings.EqualFold(pinned.Label, label)
+	})
+	if i < 0 {
+		return 0, fmt.Errorf("%w: %v %q", ErrSourceNotPinned, sourceName, label)
+	}
+	pinned := snapshot.Sources[i]
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return 0, ErrSourceNotFound
+	}
+	if !strings.EqualFold(source.SessionID, pinned.SessionID) {
+		return 0, fmt.Errorf("%w: %v %q is in session %v", ErrPinnedSessionGone, sourceName, label, source.SessionID)
+	}
+	return pinned.Version, nil
+}
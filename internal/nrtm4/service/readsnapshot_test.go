@@ -0,0 +1,95 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+type appliedVersionsRepo struct {
+	persist.Repository
+	sources []persist.NRTMSource
+	applied map[uint64]uint32
+}
+
+func (r appliedVersionsRepo) GetSources() ([]persist.NRTMSource, error) {
+	return r.sources, nil
+}
+
+func (r appliedVersionsRepo) GetAppliedVersions(sources []persist.NRTMSource) ([]uint32, error) {
+	versions := []uint32{}
+	for _, source := range sources {
+		versions = append(versions, r.applied[source.ID])
+	}
+	return versions, nil
+}
+
+func TestPinSources(t *testing.T) {
+	repo := appliedVersionsRepo{
+		sources: []persist.NRTMSource{
+			{ID: 1, Source: "RIPE", SessionID: "s1", Version: 120},
+			{ID: 2, Source: "ARIN", Label: "prod", SessionID: "s2", Version: 7},
+			{ID: 3, Source: "APNIC", SessionID: "s3", Version: 50},
+		},
+		// RIPE is applying a delta, and APNIC is loading its snapshot
+		applied: map[uint64]uint32{1: 119, 2: 7},
+	}
+	p := NRTMProcessor{repo: repo, config: AppConfig{Groups: map[string][]string{"tier1": {"ripe", "ARIN/prod"}}}}
+
+	snapshot, err := p.PinGroup("TIER1")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if token := snapshot.Token(); token != "RIPE=119@s1,ARIN/prod=7@s2" {
+		t.Error("Expected the applied versions to be pinned but was", token)
+	}
+	if all, err := p.PinSources(nil); err != nil || all.Token() != "RIPE=119@s1,ARIN/prod=7@s2" {
+		t.Error("Expected a source without an applied version to be left out but was", all.Token(), err)
+	}
+	if _, err = p.PinSources([]string{"APNIC"}); !errors.Is(err, persist.ErrVersionNotApplied) {
+		t.Error("Expected ErrVersionNotApplied but was", err)
+	}
+	if _, err = p.PinSources([]string{"ARIN"}); !errors.Is(err, ErrSourceNotFound) {
+		t.Error("Expected ErrSourceNotFound but was", err)
+	}
+	if _, err = p.PinGroup("tier2"); !errors.Is(err, ErrGroupNotFound) {
+		t.Error("Expected ErrGroupNotFound but was", err)
+	}
+
+	if v, err := p.PinnedVersion(snapshot, "ripe", ""); err != nil || v != 119 {
+		t.Error("Expected RIPE at 119 but was", v, err)
+	}
+	// ARIN has since been re-initialized with a new session
+	repo.sources[1].SessionID = "s4"
+	if _, err := p.PinnedVersion(snapshot, "ARIN", "prod"); !errors.Is(err, ErrPinnedSessionGone) {
+		t.Error("Expected ErrPinnedSessionGone but was", err)
+	}
+	if _, err := p.PinnedVersion(snapshot, "APNIC", ""); !errors.Is(err, ErrSourceNotPinned) {
+		t.Error("Expected ErrSourceNotPinned but was", err)
+	}
+}
+
+func TestParseReadSnapshot(t *testing.T) {
+	snapshot, err := ParseReadSnapshot("RIPE=119@s1, ARIN/prod:1=7@s2")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if v, err := snapshot.Version("ripe", ""); err != nil || v != 119 {
+		t.Error("Expected RIPE at 119 but was", v, err)
+	}
+	if snapshot.Sources[0].SessionID != "s1" {
+		t.Error("Expected RIPE's session to be read but was", snapshot.Sources[0])
+	}
+	if v, err := snapshot.Version("ARIN", "prod:1"); err != nil || v != 7 {
+		t.Error("Expected ARIN/prod:1 at 7 but was", v, err)
+	}
+	if _, err := snapshot.Version("ARIN", ""); !errors.Is(err, ErrSourceNotPinned) {
+		t.Error("Expected ErrSourceNotPinned but was", err)
+	}
+	for _, invalid := range []string{"", "RIPE", "RIPE=", "=12@s1", "RIPE=x@s1", "RIPE=0@s1", "RIPE=1", "RIPE=1@", "RIPE=1@s1,,ARIN=2@s2"} {
+		if _, err := ParseReadSnapshot(invalid); !errors.Is(err, ErrInvalidReadSnapshot) {
+			t.Error("Expected ErrInvalidReadSnapshot for", invalid, "but was", err)
+		}
+	}
+}
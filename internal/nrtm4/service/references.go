@@ -0,0 +1,211 @@
+package service
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// referenceAttributes maps each attribute which names other objects to the types the objects
+// can be. Sets are typed by their names instead, see setType.
+var referenceAttributes = map[string][]string{
+	"mnt-by":      {"MNTNER"},
+	"mnt-lower":   {"MNTNER"},
+	"mnt-routes":  {"MNTNER"},
+	"mnt-domains": {"MNTNER"},
+	"mnt-ref":     {"MNTNER"},
+	"admin-c":     {"PERSON", "ROLE"},
+	"tech-c":      {"PERSON", "ROLE"},
+	"zone-c":      {"PERSON", "ROLE"},
+	"abuse-c":     {"PERSON", "ROLE"},
+	"org":         {"ORGANISATION"},
+	"mnt-irt":     {"IRT"},
+}
+
+// setTypes are the types of set which members and member-of refer to
+var setTypes = []string{"AS-SET", "ROUTE-SET", "RTR-SET"}
+
+// ReferenceReport lists the references in a source's objects to objects the source doesn't
+// have, at the version it was checked at
+type ReferenceReport struct {
+	Source  string `json:"source"`
+	Label   string `json:"label"`
+	Version uint32 `json:"version"`
+	// Checked is the number of objects, and References the references in them
+	Checked    int `json:"checked"`
+	References int `json:"references"`
+	// ByAttribute counts the dangling references in each attribute
+	ByAttribute map[string]int      `json:"by_attribute"`
+	Dangling    []DanglingReference `json:"dangling"`
+}
+
+// DanglingReference is an attribute of an object which names an object the source doesn't have
+type DanglingReference struct {
+	ObjectType string `json:"object_type"`
+	PrimaryKey string `json:"primary_key"`
+	Attribute  string `json:"attribute"`
+	Reference  string `json:"reference"`
+	// Types are the object types the reference could be
+	Types []string `json:"types"`
+}
+
+// reference is an object an attribute names, which can be any of types
+type reference struct {
+	attribute string
+	key       string
+	types     []string
+}
+
+// CheckReferences finds the references in a source's objects to maintainers, contacts,
+// organisations, IRTs, key-certs and sets which the source doesn't have, such as a route whose
+// mnt-by names a deleted mntner, or an as-set member which isn't defined. The objects are read
+// twice at the same version: once to index the keys of the objects which can be referred to,
+// then to check each reference against the index. Sets qualified with another registry's
+// source, e.g. RADB::AS-EXAMPLE, and members which are ASNs or prefixes aren't checked.
+func (p NRTMProcessor) CheckReferences(sourceName, label string) (ReferenceReport, error) {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return ReferenceReport{}, ErrSourceNotFound
+	}
+	versions, err := p.repo.GetAppliedVersions([]persist.NRTMSource{*source})
+	if err != nil {
+		return ReferenceReport{}, err
+	}
+	report := ReferenceReport{
+		Source:      source.Source,
+		Label:       source.Label,
+		Version:     versions[0],
+		ByAttribute: map[string]int{},
+		Dangling:    []DanglingReference{},
+	}
+	targets := slices.Clone(setTypes)
+	for _, types := range referenceAttributes {
+		for _, t := range types {
+			if !slices.Contains(targets, t) {
+				targets = append(targets, t)
+			}
+		}
+	}
+	targets = append(targets, "KEY-CERT")
+	slices.Sort(targets)
+	keys := map[string]util.Set[string]{}
+	for _, t := range targets {
+		keys[t] = util.NewSet[string]()
+	}
+	err = p.repo.GetCurrentObjects(*source, report.Version, targets, func(obj rpsl.Rpsl) error {
+		if set, ok := keys[obj.ObjectType]; ok {
+			set.Add(obj.PrimaryKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return ReferenceReport{}, err
+	}
+	err = p.repo.GetCurrentObjects(*source, report.Version, nil, func(obj rpsl.Rpsl) error {
+		report.Checked++
+		for _, ref := range objectReferences(obj) {
+			report.References++
+			if slices.ContainsFunc(ref.types, func(t string) bool { return keys[t].Contains(ref.key) }) {
+				continue
+			}
+			report.ByAttribute[ref.attribute]++
+			report.Dangling = append(report.Dangling, DanglingReference{
+				ObjectType: obj.ObjectType,
+				PrimaryKey: obj.PrimaryKey,
+				Attribute:  ref.attribute,
+				Reference:  ref.key,
+				Types:      ref.types,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return ReferenceReport{}, err
+	}
+	return report, nil
+}
+
+// objectReferences lists the objects an object's attributes name
+func objectReferences(obj rpsl.Rpsl) []reference {
+	refs := []reference{}
+	isSet := slices.Contains(setTypes, obj.ObjectType)
+	for _, attr := range rpsl.Attributes(obj.Payload) {
+		switch {
+		case attr.Name == "auth" && obj.ObjectType == "MNTNER":
+			// Only key-cert auth schemes name another object
+			key := strings.ToUpper(strings.TrimSpace(attr.Value))
+			if strings.HasPrefix(key, "PGPKEY-") || strings.HasPrefix(key, "X509-") {
+				refs = append(refs, reference{attr.Name, key, []string{"KEY-CERT"}})
+			}
+		case attr.Name == "member-of" || (isSet && (attr.Name == "members" || attr.Name == "mp-members")):
+			for _, name := range strings.Split(attr.Value, ",") {
+				name, _, _ = strings.Cut(strings.ToUpper(strings.TrimSpace(name)), "^")
+				if t := setType(name); len(t) > 0 {
+					refs = append(refs, reference{attr.Name, name, []string{t}})
+				}
+			}
+		default:
+			types, ok := referenceAttributes[attr.Name]
+			if !ok {
+				continue
+			}
+			for _, value := range strings.Split(attr.Value, ",") {
+				// mnt-routes can be followed by the prefixes it applies to
+				fields := strings.Fields(value)
+				if len(fields) > 0 {
+					refs = append(refs, reference{attr.Name, strings.ToUpper(fields[0]), types})
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// setType is the type of set a name is, from the prefix of its last component, or empty if it
+// isn't a set or is qualified with another registry's source
+func setType(name string) string {
+	if strings.Contains(name, "::") {
+		return ""
+	}
+	last := name[strings.LastIndex(name, ":")+1:]
+	switch {
+	case strings.HasPrefix(last, "AS-"):
+		return "AS-SET"
+	case strings.HasPrefix(last, "RS-"):
+		return "ROUTE-SET"
+	case strings.HasPrefix(last, "RTRS-"):
+		return "RTR-SET"
+	}
+	return ""
+}
+
+// WriteReferenceReport writes the dangling references in a report as CSV, one row per
+// reference, or the whole report as JSON
+func WriteReferenceReport(w io.Writer, report ReferenceReport, format string) error {
+	switch format {
+	case JSONFormat:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case CSVFormat:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"object_type", "primary_key", "attribute", "reference", "types"}); err != nil {
+			return err
+		}
+		for _, ref := range report.Dangling {
+			if err := cw.Write([]string{ref.ObjectType, ref.PrimaryKey, ref.Attribute, ref.Reference, strings.Join(ref.Types, "|")}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return ErrExportFormatNotSupported
+}
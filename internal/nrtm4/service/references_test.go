@@ -0,0 +1,87 @@
+package service
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+type referencesRepo struct {
+	currentObjectsRepo
+}
+
+func (r referencesRepo) GetAppliedVersions(sources []persist.NRTMSource) ([]uint32, error) {
+	return []uint32{42}, nil
+}
+
+func TestCheckReferences(t *testing.T) {
+	repo := referencesRepo{newCurrentObjectsRepo()}
+	repo.objects = []rpsl.Rpsl{
+		{ObjectType: "MNTNER", PrimaryKey: "A-MNT", Payload: "mntner: A-MNT\nadmin-c: AB1-EXAMPLE\nauth: PGPKEY-0123ABCD\nauth: MD5-PW $1$x\nmnt-by: A-MNT\n"},
+		{ObjectType: "ROLE", PrimaryKey: "AB1-EXAMPLE", Payload: "role: Ops\nnic-hdl: AB1-EXAMPLE\nmnt-by: A-MNT\n"},
+		{ObjectType: "ROUTE", PrimaryKey: "192.0.2.0/24AS64496", Payload: "route: 192.0.2.0/24\norigin: AS64496\nmnt-by: A-MNT, GONE-MNT\nmnt-routes: A-MNT {192.0.2.0/24^+}\nmember-of: RS-EXAMPLE\n"},
+		{ObjectType: "AS-SET", PrimaryKey: "AS-EXAMPLE", Payload: "as-set: AS-EXAMPLE\nmembers: AS64496, AS-MISSING, AS64496:AS-CUSTOMERS\nmembers: RADB::AS-ELSEWHERE\nmnt-by: A-MNT\norg: ORG-NONE\n"},
+		{ObjectType: "AS-SET", PrimaryKey: "AS64496:AS-CUSTOMERS", Payload: "as-set: AS64496:AS-CUSTOMERS\nmnt-by: A-MNT\n"},
+		{ObjectType: "ROUTE-SET", PrimaryKey: "RS-EXAMPLE", Payload: "route-set: RS-EXAMPLE\nmembers: 192.0.2.0/24, RS-OTHER^+\nmnt-by: A-MNT\ntech-c: AB1-EXAMPLE\n"},
+	}
+	p := NRTMProcessor{repo: repo}
+	report, err := p.CheckReferences("EXAMPLE", "")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if report.Version != 42 || *repo.version != 42 {
+		t.Error("Expected the objects to be read at the applied version but was", report.Version, *repo.version)
+	}
+	if report.Checked != 6 || report.References != 16 {
+		t.Error("Expected 6 objects with 16 references but was", report.Checked, report.References)
+	}
+	dangling := []string{}
+	for _, ref := range report.Dangling {
+		dangling = append(dangling, ref.PrimaryKey+" "+ref.Attribute+" "+ref.Reference)
+	}
+	expected := []string{
+		"A-MNT auth PGPKEY-0123ABCD",
+		"192.0.2.0/24AS64496 mnt-by GONE-MNT",
+		"AS-EXAMPLE members AS-MISSING",
+		"AS-EXAMPLE org ORG-NONE",
+		"RS-EXAMPLE members RS-OTHER",
+	}
+	if !slices.Equal(dangling, expected) {
+		t.Error("Expected", expected, "but was", dangling)
+	}
+	if report.ByAttribute["members"] != 2 || report.ByAttribute["mnt-by"] != 1 {
+		t.Error("Expected the dangling references to be counted by attribute but was", report.ByAttribute)
+	}
+
+	var b strings.Builder
+	if err = WriteReferenceReport(&b, report, CSVFormat); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 6 || lines[2] != "ROUTE,192.0.2.0/24AS64496,mnt-by,GONE-MNT,MNTNER" {
+		t.Error("Unexpected CSV", lines)
+	}
+	if _, err = p.CheckReferences("OTHER", ""); err != ErrSourceNotFound {
+		t.Error("Expected ErrSourceNotFound but was", err)
+	}
+}
+
+func TestSetType(t *testing.T) {
+	for name, expected := range map[string]string{
+		"AS-EXAMPLE":           "AS-SET",
+		"AS64496:AS-CUSTOMERS": "AS-SET",
+		"RS-EXAMPLE":           "ROUTE-SET",
+		"AS64496:RS-ROUTES":    "ROUTE-SET",
+		"RTRS-EDGE":            "RTR-SET",
+		"RADB::AS-EXAMPLE":     "",
+		"AS64496":              "",
+		"192.0.2.0/24":         "",
+	} {
+		if setType(name) != expected {
+			t.Error("Expected", name, "to be", expected, "but was", setType(name))
+		}
+	}
+}
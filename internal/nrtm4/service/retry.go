@@ -0,0 +1,70 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/retry"
+)
+
+// defaultRetryPolicy tries each operation once, without a breaker. Downloads still resume and
+// wait for deltas which aren't published yet, since they know what went wrong.
+var defaultRetryPolicy = retry.Policy{Attempts: 1, Delay: time.Second, MaxDelay: time.Minute, BreakerOpen: time.Minute}
+
+// RetryConfig is how each component retries what fails. See package retry.
+type RetryConfig struct {
+	// HTTP retries requests which can't connect, or which the server answers with 429, 502, 503
+	// or 504. Each host has its own breaker.
+	HTTP retry.Config `json:"http"`
+	// Repository retries connecting to the database
+	Repository retry.Config `json:"repository"`
+	// Scheduler runs a job again sooner than its interval after an update fails, other than
+	// with a protocol error, which quarantines the source. Each job has its own breaker.
+	Scheduler retry.Config `json:"scheduler"`
+}
+
+func (c RetryConfig) validate() error {
+	for name, rc := range map[string]retry.Config{"http": c.HTTP, "repository": c.Repository, "scheduler": c.Scheduler} {
+		if err := rc.Validate(); err != nil {
+			return fmt.Errorf("retry %v: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// RepositoryRetrier returns the retrier for the repository's connections
+func (c RetryConfig) RepositoryRetrier() *retry.Retrier {
+	return newRetrier("repository", c.Repository)
+}
+
+func newRetrier(name string, c retry.Config) *retry.Retrier {
+	// The config was validated when it was read
+	policy, _ := c.Resolve(defaultRetryPolicy)
+	return retry.New(name, policy)
+}
+
+// RetryStats returns the retries and breaker opens of each component
+func (p NRTMProcessor) RetryStats() []retry.Stats {
+	return retry.All()
+}
+
+// isRetryableHTTPError is a failure to connect or a response which says to try again later
+func isRetryableHTTPError(err error) bool {
+	var respErr HTTPResponseError
+	if errors.As(err, &respErr) {
+		return isRetryableStatus(respErr.Status)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/retry"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+func TestHTTPClientRetriesUnavailable(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "file")
+	}))
+	defer server.Close()
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	util.AppClock = util.NewManualClock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+
+	cl := HTTPClient{retry: retry.New("", retry.Policy{Attempts: 3, Delay: time.Second, BreakerFailures: 1, BreakerOpen: time.Minute})}
+	resp, err := cl.getFile(server.URL+"/snapshot.json", fileRequest{})
+	if err != nil {
+		t.Fatal("Expected the third attempt to succeed but was", err)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "file" || requests != 3 {
+		t.Error("Unexpected response", string(body), requests)
+	}
+
+	cl.retry = retry.New("", retry.Policy{Attempts: 2, Delay: time.Second, BreakerFailures: 1, BreakerOpen: time.Minute})
+	var respErr HTTPResponseError
+	if _, err = cl.getFile(server.URL+"/snapshot.json", fileRequest{}); !errors.As(err, &respErr) || respErr.Status != http.StatusServiceUnavailable {
+		t.Error("Expected the last 503 to be returned but was", err)
+	}
+	if _, err = cl.getFile(server.URL+"/delta.json", fileRequest{}); !errors.Is(err, retry.ErrCircuitOpen) || requests != 5 {
+		t.Error("Expected the host's breaker to be open", err, requests)
+	}
+}
+
+func TestRetryConfigValidate(t *testing.T) {
+	if err := (RetryConfig{Scheduler: retry.Config{Policy: "linear"}}).validate(); !errors.Is(err, retry.ErrInvalidConfig) {
+		t.Error("Expected ErrInvalidConfig but was", err)
+	}
+}
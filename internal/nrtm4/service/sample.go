@@ -0,0 +1,123 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/jsonseq"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+// ErrInvalidSample the sample isn't a percentage or a number of objects
+var ErrInvalidSample = errors.New("sample must be a percentage up to 100%, e.g. 1%, or a number of objects")
+
+// sampleBuckets is how finely objects are divided between the sample and the rest
+const sampleBuckets = 1_000_000
+
+// Sample is how much of a snapshot connect imports, for trying the client on a large registry.
+// Either Percent or Objects is set. The zero Sample imports everything.
+type Sample struct {
+	Percent float64
+	Objects int
+}
+
+// ParseSample reads connect's --sample option: a percentage such as 1% or 0.5%, or a number of
+// objects. The empty string is the zero Sample.
+func ParseSample(s string) (Sample, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return Sample{}, nil
+	}
+	if pct, ok := strings.CutSuffix(s, "%"); ok {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return Sample{}, fmt.Errorf("%w: %v", ErrInvalidSample, s)
+		}
+		return Sample{Percent: percent}, nil
+	}
+	objects, err := strconv.Atoi(s)
+	if err != nil || objects <= 0 {
+		return Sample{}, fmt.Errorf("%w: %v", ErrInvalidSample, s)
+	}
+	return Sample{Objects: objects}, nil
+}
+
+// IsZero says whether the sample is the whole snapshot
+func (s Sample) IsZero() bool {
+	return s.Percent <= 0 && s.Objects <= 0
+}
+
+func (s Sample) String() string {
+	if s.Objects > 0 {
+		return fmt.Sprintf("%d objects", s.Objects)
+	}
+	return strconv.FormatFloat(s.Percent, 'f', -1, 64) + "%"
+}
+
+// rate is the fraction of objects the sample keeps from a snapshot of the given size
+func (s Sample) rate(objects int) float64 {
+	if s.Percent > 0 {
+		return s.Percent / 100
+	}
+	if objects <= s.Objects {
+		return 1
+	}
+	return float64(s.Objects) / float64(objects)
+}
+
+// sampled says whether an object is in a sample of the given rate. It depends only on the
+// object's type and primary key, so an object is either always in the sample or never, whether
+// it's in a snapshot or a delta.
+func sampled(obj rpsl.Rpsl, rate float64) bool {
+	h := fnv.New64a()
+	io.WriteString(h, strings.ToUpper(obj.ObjectType)+" "+strings.ToUpper(obj.PrimaryKey))
+	return float64(h.Sum64()%sampleBuckets) < rate*sampleBuckets
+}
+
+// sourceFilter is the filter for a source's objects: the one in its config, and its sample
+func (p NRTMProcessor) sourceFilter(source persist.NRTMSource) *FilterConfig {
+	filter := p.config.sourceConfig(source.Source).Filter
+	if source.SampleRate <= 0 {
+		return filter
+	}
+	sampleFilter := FilterConfig{sampleRate: source.SampleRate}
+	if filter != nil {
+		sampleFilter.MntBy, sampleFilter.Org = filter.MntBy, filter.Org
+	}
+	return &sampleFilter
+}
+
+// snapshotSampleRate turns a sample into the rate which is saved with the source. A number of
+// objects is a fraction of the snapshot's records, so the snapshot is counted first.
+func snapshotSampleRate(fm fileManager, snapshotFile *os.File, sample Sample) (float64, error) {
+	if sample.Objects <= 0 {
+		return sample.rate(0), nil
+	}
+	records := 0
+	err := fm.readJSONSeqRecords(snapshotFile, func(bytes []byte, err error) error {
+		var tooLarge *jsonseq.RecordTooLargeError
+		if errors.As(err, &tooLarge) {
+			// It's quarantined when the snapshot is loaded, but it's still an object
+			records++
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(bytes) > 0 {
+			records++
+		}
+		return err
+	})
+	if err != io.EOF {
+		return 0, err
+	}
+	// The first record is the header
+	return sample.rate(records - 1), nil
+}
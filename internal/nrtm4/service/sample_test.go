@@ -0,0 +1,88 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+func TestParseSample(t *testing.T) {
+	for s, expected := range map[string]Sample{"": {}, "1%": {Percent: 1}, " 0.5% ": {Percent: 0.5}, "100%": {Percent: 100}, "5000": {Objects: 5000}} {
+		if sample, err := ParseSample(s); err != nil || sample != expected {
+			t.Error("Expected", expected, "for", s, "but was", sample, err)
+		}
+	}
+	for _, s := range []string{"0%", "101%", "-1", "0", "a lot", "1.5"} {
+		if _, err := ParseSample(s); !errors.Is(err, ErrInvalidSample) {
+			t.Error("Expected ErrInvalidSample for", s, "but was", err)
+		}
+	}
+}
+
+func TestSampledIsDeterministic(t *testing.T) {
+	kept := 0
+	for i := range 10000 {
+		obj := rpsl.Rpsl{ObjectType: "ROUTE", PrimaryKey: fmt.Sprintf("192.0.%d.%d/32AS65530", i/256, i%256)}
+		in := sampled(obj, 0.1)
+		lower := rpsl.Rpsl{ObjectType: "route", PrimaryKey: fmt.Sprintf("192.0.%d.%d/32as65530", i/256, i%256)}
+		if sampled(lower, 0.1) != in {
+			t.Fatal("Expected the sample not to depend on case", obj.PrimaryKey)
+		}
+		if in {
+			kept++
+		}
+	}
+	if kept < 900 || kept > 1100 {
+		t.Error("Expected about 10% to be sampled but was", kept)
+	}
+	if !sampled(rpsl.Rpsl{ObjectType: "MNTNER", PrimaryKey: "A-MNT"}, 1) {
+		t.Error("Expected a rate of 1 to keep everything")
+	}
+}
+
+func TestSourceFilterSample(t *testing.T) {
+	p := NRTMProcessor{config: AppConfig{Sources: map[string]SourceConfig{"EXAMPLE": {Filter: &FilterConfig{MntBy: []string{"EXAMPLE-MNT"}}}}}}
+	if f := p.sourceFilter(persist.NRTMSource{Source: "OTHER"}); f != nil {
+		t.Error("Expected no filter for a source without a filter or sample but was", f)
+	}
+	obj := rpsl.Rpsl{ObjectType: "MNTNER", PrimaryKey: "EXAMPLE-MNT", Payload: "mntner: EXAMPLE-MNT\nmnt-by: EXAMPLE-MNT\nsource: EXAMPLE\n"}
+	other := rpsl.Rpsl{ObjectType: "MNTNER", PrimaryKey: "OTHER-MNT", Payload: "mntner: OTHER-MNT\nmnt-by: OTHER-MNT\nsource: EXAMPLE\n"}
+	if f := p.sourceFilter(persist.NRTMSource{Source: "EXAMPLE", SampleRate: 1}); !f.keeps(obj) || f.keeps(other) {
+		t.Error("Expected the config's filter to apply to a sampled source")
+	}
+	if f := p.sourceFilter(persist.NRTMSource{Source: "OTHER", SampleRate: 1}); !f.keeps(other) {
+		t.Error("Expected a sample on its own to keep objects in it")
+	}
+	if f := p.sourceFilter(persist.NRTMSource{Source: "OTHER", SampleRate: 1e-9}); f.keeps(other) {
+		t.Error("Expected a tiny sample to leave the object out")
+	}
+}
+
+func TestSnapshotSampleRate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	seq := deltaSeq(`{"nrtm_version": 4, "type": "snapshot", "source": "EXAMPLE", "session_id": "ca128382-78d9-41d1-8927-1ecef15275be", "version": 3}`,
+		`{"object": "mntner: A-MNT\nsource: EXAMPLE\n"}`,
+		`{"object": "mntner: B-MNT\nsource: EXAMPLE\n"}`,
+		`{"object": "mntner: C-MNT\nsource: EXAMPLE\n"}`,
+		`{"object": "mntner: D-MNT\nsource: EXAMPLE\n"}`,
+	)
+	if err := os.WriteFile(path, []byte(seq), 0644); err != nil {
+		t.Fatal("Failed to write snapshot", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal("Failed to open snapshot", err)
+	}
+	defer file.Close()
+	fm := fileManager{}
+	for sample, expected := range map[Sample]float64{{Objects: 1}: 0.25, {Objects: 10}: 1, {Percent: 2}: 0.02} {
+		if rate, err := snapshotSampleRate(fm, file, sample); err != nil || rate != expected {
+			t.Error("Expected a rate of", expected, "for", sample, "but was", rate, err)
+		}
+	}
+}
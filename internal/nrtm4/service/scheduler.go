@@ -0,0 +1,352 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/retry"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+var (
+	// ErrJobExists the scheduler already has a job for the source
+	ErrJobExists = errors.New("the source already has a job")
+	// ErrInvalidSchedulePolicy the policy's interval isn't positive
+	ErrInvalidSchedulePolicy = errors.New("schedule interval must be positive")
+)
+
+// SchedulePolicy says how a Scheduler updates a source
+type SchedulePolicy struct {
+	// Interval is how long after one update of the source ends the next one starts
+	Interval time.Duration
+	// CatchUp is passed to Update. See CatchUpMode.
+	CatchUp CatchUpMode
+}
+
+// ScheduledJob is the state of one of a Scheduler's jobs. Next is zero while it's running.
+type ScheduledJob struct {
+	Source  string
+	Label   string
+	Policy  SchedulePolicy
+	Next    time.Time
+	Running bool
+}
+
+// ScheduledRun is the outcome of one run of a job, and when the job will run next
+type ScheduledRun struct {
+	Result SyncResult
+	Err    error
+	Next   time.Time
+}
+
+// Scheduler runs Update for each of its jobs, at the interval in the job's policy, so
+// applications which embed the client can drive syncing themselves instead of running it from
+// cron. A source which has been quarantined isn't tried again until its quarantine ends, so it
+// backs off as it does for update --all. Other failures are retried sooner than the interval as
+// the scheduler's retry config allows, and a job whose breaker opens waits for it to close.
+// When a source's server has announced a session rotation, its job runs just after the rotation
+// is due, so it's re-initialized straight away rather than at the next interval. An update
+// which panics fails like any other. Each job's updates run one at a time, and no more than
+// parallel updates run at once. Times come from util.AppClock, so a ManualClock can drive it.
+type Scheduler struct {
+	update          func(string, string, CatchUpMode) (SyncResult, error)
+	quarantineUntil func(string, string) time.Time
+	rotationDue     func(string, string) time.Time
+	sourceExists    func(string, string) bool
+	onRun           func(ScheduledJob, ScheduledRun)
+	parallel        chan struct{}
+	retry           *retry.Retrier
+
+	mu        sync.Mutex
+	ctx       context.Context
+	jobs      map[string]*scheduledJob
+	triggered map[string]*TriggeredSync
+	// triggerOrder is the IDs in triggered, oldest first
+	triggerOrder []string
+	// removed is closed by the goroutine of a removed job when it returns, so a job added again
+	// for the same source doesn't start until the removed one's update has finished
+	removed map[string]chan struct{}
+	running sync.WaitGroup
+}
+
+type scheduledJob struct {
+	ScheduledJob
+	stop chan struct{}
+	// done is closed when the job's goroutine returns
+	done chan struct{}
+	// wake is sent to when an update is triggered, so the job doesn't wait until it's due
+	wake chan struct{}
+	// trigger is the triggered update waiting to run, if there is one
+	trigger *TriggeredSync
+	// once is set for a job which Trigger added for a source without one. It's removed after
+	// its update has run.
+	once bool
+	// failures is how many updates in a row have failed, and waited how long they've waited to
+	// be retried
+	failures int
+	waited   time.Duration
+}
+
+// NewScheduler returns a Scheduler with no jobs. onRun, which may be nil, is called after each
+// run of a job, from the job's goroutine.
+func (p NRTMProcessor) NewScheduler(parallel int, onRun func(ScheduledJob, ScheduledRun)) *Scheduler {
+	if parallel < 1 {
+		parallel = defaultUpdateParallel
+	}
+	return &Scheduler{
+		update:          p.Update,
+		quarantineUntil: p.quarantineUntil,
+		rotationDue:     p.rotationDue,
+		sourceExists:    p.sourceExists,
+		onRun:           onRun,
+		parallel:        make(chan struct{}, parallel),
+		retry:           p.schedulerRetry,
+		jobs:            map[string]*scheduledJob{},
+		triggered:       map[string]*TriggeredSync{},
+		removed:         map[string]chan struct{}{},
+	}
+}
+
+// quarantineUntil is when a source's quarantine ends, or zero if it isn't quarantined
+func (p NRTMProcessor) quarantineUntil(sourceName, label string) time.Time {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil || source.Quarantine == nil {
+		return time.Time{}
+	}
+	return source.Quarantine.Until
+}
+
+func jobKey(sourceName, label string) string {
+	return strings.ToUpper(sourceName) + groupMemberSeparator + strings.ToLower(label)
+}
+
+// AddJob updates the source with the policy. The first update runs straight away once the
+// scheduler is running.
+func (s *Scheduler) AddJob(sourceName, label string, policy SchedulePolicy) error {
+	if policy.Interval <= 0 {
+		return ErrInvalidSchedulePolicy
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := jobKey(sourceName, label)
+	if _, ok := s.jobs[key]; ok {
+		return fmt.Errorf("%w: %v %q", ErrJobExists, sourceName, label)
+	}
+	s.jobs[key] = newScheduledJob(sourceName, label, policy)
+	if s.ctx != nil {
+		s.start(s.jobs[key])
+	}
+	return nil
+}
+
+func newScheduledJob(sourceName, label string, policy SchedulePolicy) *scheduledJob {
+	return &scheduledJob{
+		ScheduledJob: ScheduledJob{Source: sourceName, Label: label, Policy: policy, Next: util.AppClock.Now()},
+		stop:         make(chan struct{}),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// RemoveJob stops updating the source. An update which is running is allowed to finish, and a
+// job added for the source again waits for it. It returns false if the scheduler had no job for
+// the source.
+func (s *Scheduler) RemoveJob(sourceName, label string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := jobKey(sourceName, label)
+	job, ok := s.jobs[key]
+	if ok {
+		close(job.stop)
+		delete(s.jobs, key)
+		if job.done != nil {
+			s.removed[key] = job.done
+		}
+		if job.trigger != nil {
+			job.trigger.finish(SyncResult{}, ErrJobRemoved)
+		}
+	}
+	return ok
+}
+
+// Jobs lists the scheduler's jobs in order of when they run next
+func (s *Scheduler) Jobs() []ScheduledJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]ScheduledJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job.ScheduledJob)
+	}
+	slices.SortFunc(jobs, func(a, b ScheduledJob) int {
+		if c := a.Next.Compare(b.Next); c != 0 {
+			return c
+		}
+		return strings.Compare(jobKey(a.Source, a.Label), jobKey(b.Source, b.Label))
+	})
+	return jobs
+}
+
+// Run runs the jobs until ctx is done, then waits for any updates which are running to finish.
+// Jobs can be added and removed while it runs.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	for _, job := range s.jobs {
+		s.start(job)
+	}
+	s.mu.Unlock()
+	<-ctx.Done()
+	s.running.Wait()
+	s.mu.Lock()
+	s.ctx = nil
+	for key, job := range s.jobs {
+		if job.trigger != nil {
+			job.trigger.finish(SyncResult{}, ErrSchedulerStopped)
+			job.trigger = nil
+		}
+		if job.once {
+			delete(s.jobs, key)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// start runs a job in its own goroutine, once the goroutine of a job which was removed for the
+// same source has returned. The caller holds the lock.
+func (s *Scheduler) start(job *scheduledJob) {
+	key := jobKey(job.Source, job.Label)
+	previous, done := s.removed[key], make(chan struct{})
+	job.done = done
+	s.running.Add(1)
+	go func(ctx context.Context) {
+		defer s.running.Done()
+		defer func() {
+			s.mu.Lock()
+			if s.removed[key] == done {
+				delete(s.removed, key)
+			}
+			s.mu.Unlock()
+			close(done)
+		}()
+		if previous != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-previous:
+			}
+		}
+		for s.wait(ctx, job) {
+			s.mu.Lock()
+			job.Running, job.Next = true, time.Time{}
+			trigger := job.trigger
+			job.trigger = nil
+			if trigger != nil {
+				trigger.begin()
+			}
+			s.mu.Unlock()
+			result, err := s.runUpdate(job)
+			<-s.parallel
+			next := s.next(job, err)
+			// A rotation the server has announced is picked up as soon as it happens
+			if due := s.rotationDue(job.Source, job.Label); due.After(util.AppClock.Now()) && due.Before(next) {
+				logger.Info("Updating at the announced session rotation", "source", job.Source, "label", job.Label, "at", due)
+				next = due
+			}
+			if until := s.quarantineUntil(job.Source, job.Label); until.After(next) {
+				next = until
+			}
+			s.mu.Lock()
+			job.Running, job.Next = false, next
+			if trigger != nil {
+				trigger.finish(result, err)
+			}
+			state := job.ScheduledJob
+			once := job.once && job.trigger == nil
+			if once && s.jobs[key] == job {
+				delete(s.jobs, key)
+			}
+			s.mu.Unlock()
+			if s.onRun != nil {
+				s.onRun(state, ScheduledRun{Result: result, Err: err, Next: next})
+			}
+			if once {
+				return
+			}
+		}
+	}(s.ctx)
+}
+
+// runUpdate runs one update of a job. A panic fails the update rather than taking the program
+// down with it, as it does for update --all.
+func (s *Scheduler) runUpdate(job *scheduledJob) (result SyncResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Scheduled update panicked", "source", job.Source, "label", job.Label, "panic", r)
+			err = fmt.Errorf("update panicked: %v", r)
+		}
+	}()
+	return s.update(job.Source, job.Label, job.Policy.CatchUp)
+}
+
+// next is when a job runs again after an update which returned err. A failure which doesn't
+// quarantine the source is retried after the retry policy's backoff if that's sooner than the
+// interval, or after the job's breaker closes if it has opened. Only the job's goroutine calls
+// it.
+func (s *Scheduler) next(job *scheduledJob, err error) time.Time {
+	now := util.AppClock.Now()
+	next := now.Add(job.Policy.Interval)
+	key := jobKey(job.Source, job.Label)
+	if err == nil || isProtocolError(err) {
+		if err == nil {
+			s.retry.Succeeded(key)
+		}
+		job.failures, job.waited = 0, 0
+		return next
+	}
+	job.failures++
+	if wait, ok := s.retry.Retry(job.failures, job.waited); ok && wait < job.Policy.Interval {
+		logger.Info("Retrying failed update", "source", job.Source, "label", job.Label, "failures", job.failures, "wait", wait, "error", err)
+		job.waited += wait
+		next = now.Add(wait)
+	}
+	if until := s.retry.Failed(key); until.After(next) {
+		next = until
+	}
+	return next
+}
+
+// wait blocks until the job is due, or an update of it has been triggered, and there's room for
+// it to run. It returns false when the job has been removed or the scheduler has stopped.
+func (s *Scheduler) wait(ctx context.Context, job *scheduledJob) bool {
+	for due := false; !due; {
+		s.mu.Lock()
+		next := job.Next
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-job.stop:
+			return false
+		case <-job.wake:
+			// The trigger may already have been run, by an update which was waiting for room
+			s.mu.Lock()
+			due = job.trigger != nil
+			s.mu.Unlock()
+		case <-util.AppClock.After(next.Sub(util.AppClock.Now())):
+			due = true
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-job.stop:
+		return false
+	case s.parallel <- struct{}{}:
+		return true
+	}
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/mem"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/retry"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+func TestScheduler(t *testing.T) {
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := util.NewManualClock(start)
+	util.AppClock = clock
+
+	runs := make(chan ScheduledRun)
+	s := NRTMProcessor{}.NewScheduler(1, func(job ScheduledJob, run ScheduledRun) { runs <- run })
+	quarantined := time.Time{}
+	s.update = func(source, label string, catchUp CatchUpMode) (SyncResult, error) {
+		if catchUp != CatchUpDeltas {
+			t.Error("Expected the policy's catch-up mode but was", catchUp)
+		}
+		return SyncResult{Source: source, Label: label}, nil
+	}
+	s.quarantineUntil = func(string, string) time.Time { return quarantined }
+	s.rotationDue = func(string, string) time.Time { return time.Time{} }
+
+	if err := s.AddJob("RIPE", "", SchedulePolicy{}); !errors.Is(err, ErrInvalidSchedulePolicy) {
+		t.Error("Expected ErrInvalidSchedulePolicy but was", err)
+	}
+	if err := s.AddJob("RIPE", "", SchedulePolicy{Interval: time.Hour, CatchUp: CatchUpDeltas}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err := s.AddJob("ripe", "", SchedulePolicy{Interval: time.Hour}); !errors.Is(err, ErrJobExists) {
+		t.Error("Expected ErrJobExists but was", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	if run := <-runs; run.Result.Source != "RIPE" || !run.Next.Equal(start.Add(time.Hour)) {
+		t.Error("Expected the first run straight away, and the next an hour later, but was", run)
+	}
+	quarantined = start.Add(5 * time.Hour)
+	clock.Advance(time.Hour)
+	if run := <-runs; !run.Next.Equal(quarantined) {
+		t.Error("Expected the next run to wait for the quarantine to end but was", run.Next)
+	}
+	if jobs := s.Jobs(); len(jobs) != 1 || !jobs[0].Next.Equal(quarantined) || jobs[0].Running {
+		t.Error("Unexpected jobs", jobs)
+	}
+
+	// A job added while the scheduler runs starts straight away
+	if err := s.AddJob("ARIN", "prod", SchedulePolicy{Interval: 30 * time.Minute, CatchUp: CatchUpDeltas}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if run := <-runs; run.Result.Source != "ARIN" || run.Result.Label != "prod" {
+		t.Error("Expected the new job to run but was", run)
+	}
+	if !s.RemoveJob("arin", "PROD") || s.RemoveJob("ARIN", "prod") {
+		t.Error("Expected the job to be removed once")
+	}
+	if jobs := s.Jobs(); len(jobs) != 1 || jobs[0].Source != "RIPE" {
+		t.Error("Expected only RIPE to be left but was", jobs)
+	}
+	cancel()
+	<-done
+}
+
+func TestSchedulerRetriesFailedUpdates(t *testing.T) {
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := util.NewManualClock(start)
+	util.AppClock = clock
+
+	runs := make(chan ScheduledRun)
+	p := NRTMProcessor{schedulerRetry: retry.New("", retry.Policy{Attempts: 3, Delay: time.Minute, BreakerFailures: 4, BreakerOpen: 6 * time.Hour})}
+	s := p.NewScheduler(1, func(job ScheduledJob, run ScheduledRun) { runs <- run })
+	var updateErr error
+	s.update = func(source, label string, _ CatchUpMode) (SyncResult, error) {
+		return SyncResult{Source: source}, updateErr
+	}
+	s.quarantineUntil = func(string, string) time.Time { return time.Time{} }
+	s.rotationDue = func(string, string) time.Time { return time.Time{} }
+	s.AddJob("RIPE", "", SchedulePolicy{Interval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	updateErr = errors.New("connection refused")
+	expected := []time.Duration{time.Minute, 2 * time.Minute, time.Hour, 6 * time.Hour}
+	for _, wait := range expected {
+		run := <-runs
+		if next := run.Next.Sub(clock.Now()); next != wait {
+			t.Error("Expected the next run after", wait, "but was", next)
+		}
+		clock.Advance(wait)
+	}
+	updateErr = nil
+	if run := <-runs; !run.Next.Equal(clock.Now().Add(time.Hour)) {
+		t.Error("Expected the interval after a success but was", run.Next)
+	}
+}
+
+func TestSchedulerRunsAtAnnouncedRotation(t *testing.T) {
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	util.AppClock = util.NewManualClock(start)
+
+	runs := make(chan ScheduledRun)
+	s := NRTMProcessor{}.NewScheduler(1, func(job ScheduledJob, run ScheduledRun) { runs <- run })
+	s.update = func(source, label string, _ CatchUpMode) (SyncResult, error) {
+		return SyncResult{Source: source}, nil
+	}
+	s.quarantineUntil = func(string, string) time.Time { return time.Time{} }
+	rotation := start.Add(20 * time.Minute)
+	s.rotationDue = func(string, string) time.Time { return rotation }
+	s.AddJob("RIPE", "", SchedulePolicy{Interval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	if run := <-runs; !run.Next.Equal(rotation) {
+		t.Error("Expected the next run at the announced rotation but was", run.Next)
+	}
+	cancel()
+	<-done
+}
+
+func TestSchedulerRecoversAndWaitsForRemovedJob(t *testing.T) {
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := util.NewManualClock(start)
+	util.AppClock = clock
+
+	runs := make(chan ScheduledRun)
+	s := NRTMProcessor{}.NewScheduler(2, func(job ScheduledJob, run ScheduledRun) { runs <- run })
+	updating := make(chan int32)
+	release := make(chan struct{})
+	var active, calls atomic.Int32
+	s.update = func(source, label string, _ CatchUpMode) (SyncResult, error) {
+		defer active.Add(-1)
+		updating <- active.Add(1)
+		<-release
+		if calls.Add(1) == 1 {
+			panic("assignment to entry in nil map")
+		}
+		return SyncResult{Source: source}, nil
+	}
+	s.quarantineUntil = func(string, string) time.Time { return time.Time{} }
+	s.rotationDue = func(string, string) time.Time { return time.Time{} }
+	s.AddJob("RIPE", "", SchedulePolicy{Interval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	<-updating
+	release <- struct{}{}
+	if run := <-runs; run.Err == nil || !strings.Contains(run.Err.Error(), "panicked") {
+		t.Error("Expected the panic to fail the update but was", run.Err)
+	}
+
+	// A job added again while the removed one's update runs waits for it to finish
+	clock.Advance(time.Hour)
+	<-updating
+	s.RemoveJob("RIPE", "")
+	s.AddJob("RIPE", "", SchedulePolicy{Interval: time.Hour})
+	select {
+	case n := <-updating:
+		t.Fatal("Expected the new job to wait, but updates running were", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+	release <- struct{}{}
+	<-runs
+	if n := <-updating; n != 1 {
+		t.Error("Expected one update to run but was", n)
+	}
+	release <- struct{}{}
+	<-runs
+	cancel()
+	<-done
+}
+
+func TestRotationDue(t *testing.T) {
+	repo := mem.NewRepository()
+	next := "2026-03-01T12:00:00Z"
+	notification := protocol.NotificationJSON{
+		NrtmFileJSON: protocol.NrtmFileJSON{Source: "RIPE", SessionID: "old", Version: 3},
+		NextSession:  &protocol.NextSessionJSON{Timestamp: next},
+	}
+	source, err := repo.SaveSource(persist.NRTMSource{Source: "RIPE", SessionID: "old", Version: 3}, notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A new source's notification is saved with its first update
+	if source, err = repo.SaveSource(source, notification); err != nil {
+		t.Fatal(err)
+	}
+	p := NRTMProcessor{repo: repo}
+	at, _ := time.Parse(time.RFC3339, next)
+	if due := p.rotationDue(source.Source, source.Label); !due.Equal(at.Add(rotationWindowDelay)) {
+		t.Error("Expected the rotation to be due just after its time but was", due)
+	}
+	if due := p.rotationDue("OTHER", ""); !due.IsZero() {
+		t.Error("Expected no rotation for an unknown source but was", due)
+	}
+}
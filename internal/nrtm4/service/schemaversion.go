@@ -0,0 +1,44 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+var (
+	// ErrSchemaNewerThanClient the database has been migrated by a newer version of the client
+	ErrSchemaNewerThanClient = errors.New("database schema is newer than this client")
+	// ErrSchemaOlderThanClient the database needs migrating before this client can use it
+	ErrSchemaOlderThanClient = errors.New("database schema is older than this client")
+)
+
+// CheckSchemaVersion refuses to work with a database schema this client wasn't built for.
+// allowNewer lets read-only commands run against a newer schema, on the assumption that
+// migrations only add to it.
+func (p NRTMProcessor) CheckSchemaVersion(allowNewer bool) error {
+	version, err := p.repo.GetSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version.Current < version.Supported {
+		return fmt.Errorf(
+			"%w: schema is version %d and nrtm4client %v needs version %d. Run the migrations in third_party/tern",
+			ErrSchemaOlderThanClient, version.Current, util.ClientVersion, version.Supported,
+		)
+	}
+	if version.Current > version.Supported {
+		if allowNewer {
+			logger.Warn("Database schema is newer than this client", "schema", version.Current, "supported", version.Supported)
+			return nil
+		}
+		return fmt.Errorf(
+			"%w: schema is version %d and nrtm4client %v only supports up to version %d. "+
+				"It was last used by nrtm4client %v, upgrade to that version. "+
+				"Read-only commands can be run with -allow-forward-compat",
+			ErrSchemaNewerThanClient, version.Current, util.ClientVersion, version.Supported, version.LastClientVersion,
+		)
+	}
+	return p.repo.RecordClientVersion(util.ClientVersion)
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+type schemaVersionRepo struct {
+	persist.Repository
+	version  persist.SchemaVersion
+	recorded *string
+}
+
+func (r schemaVersionRepo) GetSchemaVersion() (persist.SchemaVersion, error) {
+	return r.version, nil
+}
+
+func (r schemaVersionRepo) RecordClientVersion(clientVersion string) error {
+	*r.recorded = clientVersion
+	return nil
+}
+
+func TestCheckSchemaVersion(t *testing.T) {
+	tests := []struct {
+		current    int
+		allowNewer bool
+		expected   error
+		recorded   bool
+	}{
+		{4, false, nil, true},
+		{3, false, ErrSchemaOlderThanClient, false},
+		{3, true, ErrSchemaOlderThanClient, false},
+		{5, false, ErrSchemaNewerThanClient, false},
+		{5, true, nil, false},
+	}
+	for _, tt := range tests {
+		recorded := ""
+		repo := schemaVersionRepo{
+			version:  persist.SchemaVersion{Current: tt.current, Supported: 4, LastClientVersion: "v2"},
+			recorded: &recorded,
+		}
+		p := NRTMProcessor{repo: repo}
+		err := p.CheckSchemaVersion(tt.allowNewer)
+		if !errors.Is(err, tt.expected) {
+			t.Error("Expected", tt.expected, "but was", err, "for schema", tt.current)
+		}
+		if (len(recorded) > 0) != tt.recorded {
+			t.Error("Expected client version to be recorded:", tt.recorded, "for schema", tt.current)
+		}
+	}
+}
@@ -2,4 +2,7 @@ package service
 
 import "github.com/petchells/nrtm4client/internal/nrtm4/util"
 
-var logger = util.Logger
+var logger = util.ModuleLogger("service")
+
+// httpLogger is for requests to NRTM servers, so the network can be debugged on its own
+var httpLogger = util.ModuleLogger("http")
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// SessionCleanupReport lists the superseded sessions which were removed, or would be removed
+// on a dry run
+type SessionCleanupReport struct {
+	Sessions []RemovedSession
+	DryRun   bool
+}
+
+// RemovedSession is a superseded session, with the rows which were deleted with it
+type RemovedSession struct {
+	Source persist.NRTMSource
+	Rows   persist.RemovedRows
+}
+
+// Total is the number of rows deleted over all sessions
+func (r SessionCleanupReport) Total() persist.RemovedRows {
+	var total persist.RemovedRows
+	for _, s := range r.Sessions {
+		total.Objects += s.Rows.Objects
+		total.Notifications += s.Rows.Notifications
+		total.Files += s.Rows.Files
+	}
+	return total
+}
+
+// CleanupSessions removes sessions which were superseded when a source was re-initialized, and
+// longer ago than olderThan. An empty sourceName cleans up every source. When dryRun is true the
+// sessions are only listed.
+func (p NRTMProcessor) CleanupSessions(sourceName string, olderThan time.Duration, dryRun bool) (SessionCleanupReport, error) {
+	report := SessionCleanupReport{Sessions: []RemovedSession{}, DryRun: dryRun}
+	if !dryRun {
+		if err := p.requirePrimary(); err != nil {
+			return report, err
+		}
+	}
+	ds := NrtmDataService{Repository: p.repo}
+	sources, err := ds.getSources()
+	if err != nil {
+		return report, err
+	}
+	cutoff := util.AppClock.Now().Add(-olderThan)
+	for _, source := range supersededSessions(sources, sourceName, cutoff) {
+		session := RemovedSession{Source: source}
+		if !dryRun {
+			if session.Rows, err = ds.deleteSource(source); err != nil {
+				return report, err
+			}
+			logger.Info("Removed superseded session", "source", source.Source, "label", source.Label,
+				"sessionID", source.SessionID, "objects", session.Rows.Objects,
+				"notifications", session.Rows.Notifications, "files", session.Rows.Files)
+		}
+		report.Sessions = append(report.Sessions, session)
+	}
+	return report, nil
+}
+
+// supersededSessions are the sources whose session was superseded before cutoff. A session is
+// only removed while its source and label have a newer session, so history is never lost
+// completely.
+func supersededSessions(sources []persist.NRTMSource, sourceName string, cutoff time.Time) []persist.NRTMSource {
+	live := map[string]bool{}
+	for _, source := range sources {
+		if source.Superseded == nil {
+			live[sessionKey(source.Source, source.Label)] = true
+		}
+	}
+	var found []persist.NRTMSource
+	for _, source := range sources {
+		if source.Superseded == nil || source.Superseded.After(cutoff) {
+			continue
+		}
+		if len(sourceName) > 0 && !strings.EqualFold(source.Source, sourceName) {
+			continue
+		}
+		if !live[sessionKey(source.Source, supersededLabel(source.Label))] {
+			continue
+		}
+		found = append(found, source)
+	}
+	return found
+}
+
+func sessionKey(source, label string) string {
+	return strings.ToUpper(source) + groupMemberSeparator + strings.ToUpper(label)
+}
+
+// supersededLabel is the label a superseded session had before archiveLabel added its session
+// to it
+func supersededLabel(archived string) string {
+	if i := strings.LastIndex(archived, " "); i >= 0 {
+		return archived[:i]
+	}
+	return ""
+}
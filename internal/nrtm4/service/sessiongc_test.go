@@ -0,0 +1,77 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+type sessionRepo struct {
+	persist.Repository
+	sources []persist.NRTMSource
+	removed []uint64
+}
+
+func (r *sessionRepo) IsStandby() (bool, error) {
+	return false, nil
+}
+
+func (r *sessionRepo) GetSources() ([]persist.NRTMSource, error) {
+	return r.sources, nil
+}
+
+func (r *sessionRepo) RemoveSource(source persist.NRTMSource) (persist.RemovedRows, error) {
+	r.removed = append(r.removed, source.ID)
+	return persist.RemovedRows{Objects: 100, Notifications: 10, Files: 5}, nil
+}
+
+func TestCleanupSessions(t *testing.T) {
+	now := util.AppClock.Now()
+	old := now.Add(-60 * 24 * time.Hour)
+	recent := now.Add(-24 * time.Hour)
+	repo := &sessionRepo{sources: []persist.NRTMSource{
+		{ID: 1, Source: "TEST", Label: ""},
+		{ID: 2, Source: "TEST", Label: "0a1b2c3d", Superseded: &old},
+		{ID: 3, Source: "TEST", Label: "4e5f6a7b", Superseded: &recent},
+		{ID: 4, Source: "OTHER", Label: "8c9d0e1f", Superseded: &old},
+	}}
+	p := NRTMProcessor{repo: repo}
+
+	report, err := p.CleanupSessions("", 30*24*time.Hour, true)
+	if err != nil {
+		t.Fatal("Dry run failed", err)
+	}
+	if len(repo.removed) != 0 {
+		t.Error("Expected nothing to be removed on a dry run but was", repo.removed)
+	}
+	// OTHER has no live session, so its last session is kept
+	if len(report.Sessions) != 1 || report.Sessions[0].Source.ID != 2 {
+		t.Error("Expected session 2 to be listed but was", report.Sessions)
+	}
+
+	report, err = p.CleanupSessions("test", 0, false)
+	if err != nil {
+		t.Fatal("Cleanup failed", err)
+	}
+	if len(repo.removed) != 2 || repo.removed[0] != 2 || repo.removed[1] != 3 {
+		t.Error("Expected sessions 2 and 3 to be removed but was", repo.removed)
+	}
+	if total := report.Total(); total.Objects != 200 || total.Notifications != 20 || total.Files != 10 {
+		t.Error("Unexpected total", total)
+	}
+}
+
+func TestSupersededSessionsByLabel(t *testing.T) {
+	old := util.AppClock.Now().Add(-60 * 24 * time.Hour)
+	sources := []persist.NRTMSource{
+		{ID: 1, Source: "TEST", Label: "prod"},
+		{ID: 2, Source: "TEST", Label: "prod 0a1b2c3d", Superseded: &old},
+		// The test label's only session has been superseded
+		{ID: 3, Source: "TEST", Label: "test 4e5f6a7b", Superseded: &old},
+	}
+	if found := supersededSessions(sources, "", util.AppClock.Now()); len(found) != 1 || found[0].ID != 2 {
+		t.Error("Expected only the session superseded by a live one with its label but was", found)
+	}
+}
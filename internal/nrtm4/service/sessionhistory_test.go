@@ -0,0 +1,55 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+type sessionHistoryRepo struct {
+	persist.Repository
+	last  protocol.NotificationJSON
+	saved []persist.SessionSeen
+	err   error
+}
+
+func (r *sessionHistoryRepo) GetNotificationHistory(source persist.NRTMSource, from, to uint32) ([]persist.Notification, error) {
+	return []persist.Notification{{Version: r.last.Version, Payload: r.last}}, nil
+}
+
+func (r *sessionHistoryRepo) SaveSessionSeen(source persist.NRTMSource, seen persist.SessionSeen) error {
+	r.saved = append(r.saved, seen)
+	return r.err
+}
+
+func TestRecordSession(t *testing.T) {
+	oldID, newID := "b3a52c14-4d2b-4a55-9d0e-5a1f6e0d2c11", "0f6e7a29-8c4d-4b1e-a5a3-2f7d9b6c4e88"
+	repo := &sessionHistoryRepo{last: protocol.NotificationJSON{NrtmFileJSON: protocol.NrtmFileJSON{SessionID: oldID, Version: 9}}}
+	p := NRTMProcessor{repo: repo, warnings: &syncWarnings{}}
+	source := persist.NRTMSource{Source: "TEST", SessionID: oldID, Version: 9}
+
+	p.recordSession(source, protocol.NotificationJSON{NrtmFileJSON: protocol.NrtmFileJSON{SessionID: oldID, Version: 10}})
+	p.recordSession(source, protocol.NotificationJSON{NrtmFileJSON: protocol.NrtmFileJSON{SessionID: newID, Version: 1}})
+	repo.last.NextSession = &protocol.NextSessionJSON{Timestamp: "2026-10-15T00:00:00Z", SessionID: &newID}
+	p.recordSession(source, protocol.NotificationJSON{NrtmFileJSON: protocol.NrtmFileJSON{SessionID: newID, Version: 2}})
+	if len(repo.saved) != 3 {
+		t.Fatal("Expected 3 sessions to be saved but was", len(repo.saved))
+	}
+	if seen := repo.saved[0]; seen.SessionID != oldID || seen.FirstVersion != 10 || seen.LastVersion != 10 || seen.Announced {
+		t.Error("Unexpected session", seen)
+	}
+	if seen := repo.saved[1]; seen.SessionID != newID || seen.Announced {
+		t.Error("Expected an unannounced rotation but was", seen)
+	}
+	if seen := repo.saved[2]; !seen.Announced || seen.LastVersion != 2 {
+		t.Error("Expected an announced rotation but was", seen)
+	}
+
+	repo.err = errors.New("no table")
+	p.recordSession(source, protocol.NotificationJSON{NrtmFileJSON: protocol.NrtmFileJSON{SessionID: oldID, Version: 11}})
+	if warnings := p.warnings.all(); len(warnings) != 1 || warnings[0].Kind != WarningBookkeeping {
+		t.Error("Expected a bookkeeping warning but was", warnings)
+	}
+}
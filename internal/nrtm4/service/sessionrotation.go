@@ -0,0 +1,235 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/jsonseq"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// rotationAnnounced is true when the last notification we saw announced the session that
+// the server is now publishing
+func rotationAnnounced(last protocol.NotificationJSON, current protocol.NotificationJSON) bool {
+	if last.NextSession == nil || last.SessionID == current.SessionID {
+		return false
+	}
+	next := last.NextSession.SessionID
+	return next == nil || strings.EqualFold(*next, current.SessionID)
+}
+
+// rotationWindowDelay is how long after an announced rotation's time the scheduler updates the
+// source, so the server has had time to publish the new session
+const rotationWindowDelay = time.Minute
+
+// rotationDue is when the scheduler should update a source to re-initialize it after a session
+// rotation its server has announced, or zero if none is announced
+func (p NRTMProcessor) rotationDue(sourceName, label string) time.Time {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return time.Time{}
+	}
+	last := p.lastNotification(*source)
+	if last == nil || last.NextSession == nil || last.SessionID != source.SessionID {
+		return time.Time{}
+	}
+	at, err := time.Parse(time.RFC3339, last.NextSession.Timestamp)
+	if err != nil {
+		logger.Debug("Cannot read the time of the announced rotation", "source", sourceName, "timestamp", last.NextSession.Timestamp)
+		return time.Time{}
+	}
+	return at.Add(rotationWindowDelay)
+}
+
+// archiveLabel is the label the history of a source's old session is kept under
+func archiveLabel(source persist.NRTMSource) string {
+	session := strings.TrimSpace(source.SessionID)
+	if len(session) > 8 {
+		session = session[:8]
+	}
+	if len(session) == 0 {
+		session = fmt.Sprintf("v%d", source.Version)
+	}
+	return strings.TrimSpace(source.Label + " " + session)
+}
+
+func (p NRTMProcessor) lastNotification(source persist.NRTMSource) *protocol.NotificationJSON {
+	notifs, err := p.repo.GetNotificationHistory(source, 1, math.MaxUint32)
+	if err != nil {
+		logger.Warn("Cannot read notification history", "source", source.Source, "error", err)
+		return nil
+	}
+	if len(notifs) == 0 {
+		return nil
+	}
+	return &notifs[0].Payload
+}
+
+// reinitialize keeps the history of the old session under a new label, then connects the
+// source again with its original label, so updates continue as if nothing happened. It's done
+// in two phases: the new session's snapshot is loaded, then the differences from the old
+// session's objects are applied as adds, modifies and deletes, so anything following the
+// source's changes sees a diff rather than every object being removed and added again. The old
+// session is marked as superseded, so it can be removed by CleanupSessions later. If the new
+// session can't be connected, it's removed and the old one gets its label back, so the source
+// is never left without one.
+func (p NRTMProcessor) reinitialize(source persist.NRTMSource) error {
+	label := archiveLabel(source)
+	logger.Info("Re-initializing source after announced session rotation", "source", source.Source, "archiveLabel", label)
+	archived, err := p.ReplaceLabel(source.Source, source.Label, label)
+	if err != nil {
+		return err
+	}
+	var result SyncResult
+	if err = p.connect(source.NotificationURL, source.Label, archived, Sample{}, &result); err != nil {
+		p.restoreSession(source, label)
+		return err
+	}
+	if err = p.repo.SupersedeSource(*archived, util.AppClock.Now()); err != nil {
+		logger.Warn("Failed to mark the old session as superseded", "source", source.Source, "label", label, "error", err)
+		p.warnings.add(WarningBookkeeping, source.Version, "the old session wasn't marked as superseded: %v", err)
+	}
+	return nil
+}
+
+// restoreSession undoes a re-initialization which failed: the new session, if it was saved, is
+// removed, and the old one is given back its label
+func (p NRTMProcessor) restoreSession(source persist.NRTMSource, archiveLabel string) {
+	ds := NrtmDataService{Repository: p.repo}
+	if replacement := ds.getSourceByNameAndLabel(source.Source, source.Label); replacement != nil && replacement.ID != source.ID {
+		if _, err := ds.deleteSource(*replacement); err != nil {
+			logger.Error("Failed to remove the new session after re-initialization failed", "source", source.Source, "error", err)
+			return
+		}
+	}
+	if _, err := p.ReplaceLabel(source.Source, archiveLabel, source.Label); err != nil {
+		logger.Error("Failed to restore the old session's label. Rename it back with the rename command", "source", source.Source,
+			"label", archiveLabel, "error", err)
+		return
+	}
+	logger.Info("Restored the old session after re-initialization failed", "source", source.Source, "label", source.Label)
+}
+
+// applySessionSnapshot loads the first snapshot of a new session into source as changes to the
+// objects of the session it replaces, and publishes them as delta events
+func (p NRTMProcessor) applySessionSnapshot(
+	fm fileManager,
+	file *os.File,
+	source persist.NRTMSource,
+	previous persist.NRTMSource,
+	version uint32,
+	header *protocol.SnapshotFileJSON,
+) error {
+	readRecords := func(fn jsonseq.RecordReaderFunc) error {
+		return fm.readJSONSeqRecords(file, p.quarantineOversized(source, version, fn))
+	}
+	load := snapshotLoader(readRecords, version, header, p.sourceFilter(source), p.config.sourceQuirks(source.Source))
+	changes, err := p.repo.ApplySessionSnapshot(source, previous, version, load)
+	if err != nil {
+		return err
+	}
+	logger.Info("Applied new session's snapshot", "source", source.Source, "version", version, "carried", changes.Carried,
+		"added", changes.Added, "modified", changes.Modified, "deleted", changes.Deleted)
+	if err = p.journalChanges(source, version, version); err != nil {
+		return err
+	}
+	events := newDeltaEventSink(p.config, source)
+	defer events.close()
+	if events.publisher == nil {
+		return nil
+	}
+	sessionEvents, err := p.sessionSnapshotEvents(source, previous, version)
+	if err != nil {
+		logger.Warn("Failed to publish the new session's changes", "source", source.Source, "error", err)
+		p.warnings.add(WarningBookkeeping, version, "the new session's changes weren't published: %v", err)
+		return nil
+	}
+	for _, event := range sessionEvents {
+		events.send(event)
+	}
+	events.flush()
+	return nil
+}
+
+// sessionSnapshotEvents are the delta events for the changes a new session's snapshot made to the
+// objects of the session it replaced. A modified object's previous version is the old session's
+// latest one, and an added object has none.
+func (p NRTMProcessor) sessionSnapshotEvents(source, previous persist.NRTMSource, version uint32) ([]DeltaEvent, error) {
+	var changes []persist.ObjectChange
+	keys := util.NewSet[string]()
+	if err := p.repo.GetObjectChanges(source, version, version, func(change persist.ObjectChange) error {
+		changes = append(changes, change)
+		if !change.Deleted {
+			keys.Add(change.PrimaryKey)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	previousObjects := map[string]string{}
+	if len(keys) > 0 {
+		objects, err := p.repo.LookupObjects(previous, 0, keys.Members())
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objects {
+			previousObjects[obj.ObjectType+"\x00"+obj.PrimaryKey] = obj.Payload
+		}
+	}
+	sessionFile := protocol.NrtmFileJSON{SessionID: source.SessionID, Version: version}
+	events := make([]DeltaEvent, 0, len(changes))
+	for _, change := range changes {
+		if change.Deleted {
+			events = append(events, newDeltaEvent(source, sessionFile, persist.DeltaDeleteAction, change.ObjectType, change.PrimaryKey, nil, &change.RPSL))
+			continue
+		}
+		var prev *string
+		if payload, ok := previousObjects[change.ObjectType+"\x00"+change.PrimaryKey]; ok {
+			prev = &payload
+		}
+		events = append(events, newDeltaEvent(source, sessionFile, persist.DeltaAddModifyAction, change.ObjectType, change.PrimaryKey, &change.RPSL, prev))
+	}
+	return events, nil
+}
+
+// recordSession adds the notification's session to the history of sessions seen at the
+// source's notification URL, or updates when and at which version it was last seen
+func (p NRTMProcessor) recordSession(source persist.NRTMSource, notification protocol.NotificationJSON) {
+	now := util.AppClock.Now()
+	seen := persist.SessionSeen{
+		SessionID:    notification.SessionID,
+		FirstSeen:    now,
+		LastSeen:     now,
+		FirstVersion: notification.Version,
+		LastVersion:  notification.Version,
+	}
+	if len(source.SessionID) > 0 && notification.SessionID != source.SessionID {
+		last := p.lastNotification(source)
+		seen.Announced = last != nil && rotationAnnounced(*last, notification)
+	}
+	if err := p.repo.SaveSessionSeen(source, seen); err != nil {
+		logger.Warn("Failed to record session", "source", source.Source, "session", notification.SessionID, "error", err)
+		p.warnings.add(WarningBookkeeping, notification.Version, "session %v wasn't recorded: %v", notification.SessionID, err)
+	}
+}
+
+func logAnnouncedRotation(notification protocol.NotificationJSON) {
+	if notification.NextSession == nil {
+		return
+	}
+	nextID := ""
+	if notification.NextSession.SessionID != nil {
+		nextID = *notification.NextSession.SessionID
+	}
+	logger.Warn("Server announced a session rotation. The source will be re-initialized when it happens",
+		"source", notification.Source,
+		"timestamp", notification.NextSession.Timestamp,
+		"nextSessionID", nextID,
+	)
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/mem"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+type sessionSnapshotRepo struct {
+	persist.Repository
+	previous *persist.NRTMSource
+	objects  *[]rpsl.Rpsl
+}
+
+func (r sessionSnapshotRepo) ApplySessionSnapshot(source, previous persist.NRTMSource, version uint32, load persist.SnapshotLoader) (persist.SnapshotChanges, error) {
+	*r.previous = previous
+	err := load(func(objects []rpsl.Rpsl) error {
+		*r.objects = append(*r.objects, objects...)
+		return nil
+	})
+	return persist.SnapshotChanges{Version: version, Carried: 2, Added: len(*r.objects)}, err
+}
+
+func TestApplySessionSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nrtm-snapshot.5.json")
+	seq := "\x1e" + `{"nrtm_version":4,"type":"snapshot","source":"EXAMPLE","session_id":"new","version":5}` + "\n" +
+		"\x1e" + `{"object":"mntner: TEST-MNT\nsource: EXAMPLE\n"}` + "\n"
+	if err := os.WriteFile(path, []byte(seq), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var previous persist.NRTMSource
+	objects := []rpsl.Rpsl{}
+	p := NRTMProcessor{repo: sessionSnapshotRepo{previous: &previous, objects: &objects}}
+	source := persist.NRTMSource{ID: 2, Source: "EXAMPLE", SessionID: "new", Version: 5}
+	old := persist.NRTMSource{ID: 1, Source: "EXAMPLE", SessionID: "old", Label: "old", Version: 90}
+	header := new(protocol.SnapshotFileJSON)
+	if err = p.applySessionSnapshot(fileManager{}, file, source, old, 5, header); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if previous.ID != 1 {
+		t.Error("Expected the old session to be carried over but was", previous)
+	}
+	if len(objects) != 1 || objects[0].PrimaryKey != "TEST-MNT" {
+		t.Error("Expected the snapshot's object to be loaded but was", objects)
+	}
+	if header.Version != 5 || header.SessionID != "new" {
+		t.Error("Expected the snapshot header to be read but was", header)
+	}
+}
+
+func TestArchiveLabel(t *testing.T) {
+	for _, tc := range []struct {
+		source   persist.NRTMSource
+		expected string
+	}{
+		{persist.NRTMSource{Label: "prod", SessionID: "db44e038-1f07-4d54-a307-1b32339f141a"}, "prod db44e038"},
+		{persist.NRTMSource{SessionID: "abc"}, "abc"},
+		{persist.NRTMSource{Label: "prod", Version: 12}, "prod v12"},
+	} {
+		if label := archiveLabel(tc.source); label != tc.expected {
+			t.Errorf("Expected %q but was %q", tc.expected, label)
+		}
+	}
+}
+
+type sessionChangesRepo struct {
+	persist.Repository
+	changes []persist.ObjectChange
+	old     []rpsl.Rpsl
+}
+
+func (r sessionChangesRepo) GetObjectChanges(source persist.NRTMSource, from, to uint32, fn func(persist.ObjectChange) error) error {
+	for _, change := range r.changes {
+		if err := fn(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r sessionChangesRepo) LookupObjects(source persist.NRTMSource, version uint32, primaryKeys []string) ([]rpsl.Rpsl, error) {
+	if source.ID != 1 || version != 0 {
+		return nil, errors.New("expected the old session's latest objects to be looked up")
+	}
+	return r.old, nil
+}
+
+func TestSessionSnapshotEvents(t *testing.T) {
+	p := NRTMProcessor{repo: sessionChangesRepo{
+		changes: []persist.ObjectChange{
+			{Version: 5, ObjectType: "MNTNER", PrimaryKey: "TEST-MNT", RPSL: "mntner: TEST-MNT\nremarks: new\n"},
+			{Version: 5, ObjectType: "PERSON", PrimaryKey: "NEW-TEST", RPSL: "person: New\nnic-hdl: NEW-TEST\n"},
+			{Version: 5, Deleted: true, ObjectType: "ROLE", PrimaryKey: "GONE-TEST", RPSL: "role: Gone\nnic-hdl: GONE-TEST\n"},
+		},
+		old: []rpsl.Rpsl{{ObjectType: "MNTNER", PrimaryKey: "TEST-MNT", Payload: "mntner: TEST-MNT\nremarks: old\n"}},
+	}}
+	source := persist.NRTMSource{ID: 2, Source: "EXAMPLE", SessionID: "new", Version: 5}
+	old := persist.NRTMSource{ID: 1, Source: "EXAMPLE", SessionID: "old", Label: "old", Version: 90}
+	events, err := p.sessionSnapshotEvents(source, old, 5)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(events) != 3 {
+		t.Fatal("Expected 3 events but was", events)
+	}
+	if events[0].Previous == nil || *events[0].Previous != "mntner: TEST-MNT\nremarks: old\n" {
+		t.Error("Expected the modified object's old session version but was", events[0].Previous)
+	}
+	if events[1].Previous != nil {
+		t.Error("Expected no previous version of an added object but was", *events[1].Previous)
+	}
+	if events[2].Action != persist.DeltaDeleteAction || events[2].Previous == nil {
+		t.Error("Expected a delete with the deleted object but was", events[2])
+	}
+}
+
+// sessionFilesClient serves a notification file and the files it lists by their URL
+type sessionFilesClient struct {
+	notification protocol.NotificationJSON
+	files        map[string]string
+}
+
+func (c sessionFilesClient) getUpdateNotification(string) (protocol.NotificationJSON, http.Header, error) {
+	return c.notification, nil, nil
+}
+
+func (c sessionFilesClient) getResponseBody(url string) (io.Reader, error) {
+	body, ok := c.files[url]
+	if !ok {
+		return nil, HTTPResponseError{Status: http.StatusNotFound, URL: url}
+	}
+	return strings.NewReader(body), nil
+}
+
+func (c sessionFilesClient) getFile(url string, _ fileRequest) (fileResponse, error) {
+	reader, err := c.getResponseBody(url)
+	return fileResponse{Body: reader}, err
+}
+
+func TestReinitializeMemoryRepository(t *testing.T) {
+	repo := mem.NewRepository()
+	url := "https://example.com/nrtmv4/notification.json"
+	oldSession, newSession := "3a0c8d61-6f1e-4b1c-9d2a-7b1e2f3c4d5e", "8f14e45f-ceea-467a-9575-1b2c3d4e5f60"
+	old, err := repo.SaveSource(persist.NRTMSource{Source: "EXAMPLE", SessionID: oldSession, Version: 3, NotificationURL: url, Label: "prod"},
+		protocol.NotificationJSON{NrtmFileJSON: protocol.NrtmFileJSON{NrtmVersion: 4, Source: "EXAMPLE", SessionID: oldSession, Version: 3}})
+	if err != nil {
+		t.Fatal("Could not save source", err)
+	}
+	snapshot := "\x1e" + `{"nrtm_version":4,"type":"snapshot","source":"EXAMPLE","session_id":"` + newSession + `","version":4}` + "\n" +
+		"\x1e" + `{"object":"mntner: TEST-MNT\nsource: EXAMPLE\n"}` + "\n"
+	delta := "\x1e" + `{"nrtm_version":4,"type":"delta","source":"EXAMPLE","session_id":"` + newSession + `","version":5}` + "\n" +
+		"\x1e" + `{"action":"add_modify","object":"mntner: NEW-MNT\nsource: EXAMPLE\n"}` + "\n"
+	notification := protocol.NotificationJSON{
+		NrtmFileJSON: protocol.NrtmFileJSON{NrtmVersion: 4, Type: "notification", Source: "EXAMPLE", SessionID: newSession, Version: 5},
+		SnapshotRef:  protocol.FileRefJSON{URL: "nrtm-snapshot.4.json", Version: 4, Hash: "0000"},
+		DeltaRefs:    []protocol.FileRefJSON{{URL: "nrtm-delta.5.json", Version: 5, Hash: sha256Hex(delta)}},
+	}
+	files := map[string]string{
+		"https://example.com/nrtmv4/nrtm-snapshot.4.json": snapshot,
+		"https://example.com/nrtmv4/nrtm-delta.5.json":    delta,
+	}
+	p := NRTMProcessor{repo: repo, config: AppConfig{NRTMFilePath: t.TempDir()}}
+
+	// A snapshot which doesn't match its hash can't be loaded, so the old session keeps the label
+	p.client = sessionFilesClient{notification: notification, files: files}
+	if err = p.reinitialize(old); !errors.Is(err, ErrHashMismatch) {
+		t.Fatal("Expected the new session's snapshot to fail its hash check but was", err)
+	}
+	sources, err := repo.GetSources()
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(sources) != 1 || sources[0].ID != old.ID || sources[0].Label != "prod" {
+		t.Fatal("Expected the old session to be restored but was", sources)
+	}
+
+	notification.SnapshotRef.Hash = sha256Hex(snapshot)
+	p.client = sessionFilesClient{notification: notification, files: files}
+	if err = p.reinitialize(old); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	ds := NrtmDataService{Repository: repo}
+	current := ds.getSourceByNameAndLabel("EXAMPLE", "prod")
+	if current == nil || current.SessionID != newSession || current.Version != 5 {
+		t.Fatal("Expected the new session under the label but was", current)
+	}
+	archived := ds.getSourceByNameAndLabel("EXAMPLE", "prod 3a0c8d61")
+	if archived == nil || archived.ID != old.ID || archived.Superseded == nil {
+		t.Error("Expected the old session to be archived and superseded but was", archived)
+	}
+
+	// Renaming to a label in use fails
+	if _, err = p.ReplaceLabel("EXAMPLE", "prod 3a0c8d61", "prod"); !errors.Is(err, ErrSourceAlreadyExists) {
+		t.Error("Expected ErrSourceAlreadyExists but was", err)
+	}
+	renamed, err := p.ReplaceLabel("EXAMPLE", "prod 3a0c8d61", "archive")
+	if err != nil || renamed.Label != "archive" || ds.getSourceByNameAndLabel("EXAMPLE", "archive") == nil {
+		t.Error("Expected the old session to be renamed but was", renamed, err)
+	}
+}
+
+func sha256Hex(s string) string {
+	hash := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(hash[:])
+}
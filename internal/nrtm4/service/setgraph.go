@@ -0,0 +1,266 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+// Set graph export formats
+const (
+	DOTFormat  = "dot"
+	JSONFormat = "json"
+)
+
+// Kinds of node in a set graph
+const (
+	ASSetNode       = "as-set"
+	RouteSetNode    = "route-set"
+	ASNNode         = "asn"
+	PrefixNode      = "prefix"
+	ExternalSetNode = "external-set"
+)
+
+var (
+	// ErrSetNotFound the root set isn't one of the source's as-sets or route-sets
+	ErrSetNotFound = errors.New("set not found")
+
+	asnPattern = regexp.MustCompile(`^AS[0-9]+$`)
+)
+
+// SetGraph is the membership graph of a source's as-sets and route-sets. Cycles lists each group
+// of sets which contain each other, directly or through other sets.
+type SetGraph struct {
+	Nodes  []SetGraphNode `json:"nodes"`
+	Edges  []SetGraphEdge `json:"edges"`
+	Cycles [][]string     `json:"cycles"`
+}
+
+// SetGraphNode is a set, or a member of one. Members which are sets not defined in the source are
+// external-set nodes.
+type SetGraphNode struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+}
+
+// SetGraphEdge goes from a set to one of its members
+type SetGraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// SetGraph builds the membership graph of a source's as-sets and route-sets from their members
+// and mp-members. If root is given, only the sets reachable from it are included.
+func (p NRTMProcessor) SetGraph(sourceName, label, root string) (SetGraph, error) {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return SetGraph{}, ErrSourceNotFound
+	}
+	members := map[string][]string{}
+	kinds := map[string]string{}
+	err := p.repo.GetCurrentObjects(*source, 0, []string{"AS-SET", "ROUTE-SET"}, func(obj rpsl.Rpsl) error {
+		kinds[obj.PrimaryKey] = strings.ToLower(obj.ObjectType)
+		members[obj.PrimaryKey] = setMembers(obj.Payload)
+		return nil
+	})
+	if err != nil {
+		return SetGraph{}, err
+	}
+	if len(root) > 0 {
+		root = strings.ToUpper(root)
+		if _, ok := kinds[root]; !ok {
+			return SetGraph{}, fmt.Errorf("%w: %v", ErrSetNotFound, root)
+		}
+		members = reachableSets(members, root)
+	}
+	return buildSetGraph(members, kinds), nil
+}
+
+// setMembers lists the members of a set, without any range operators
+func setMembers(payload string) []string {
+	names := []string{}
+	for _, attr := range rpsl.Attributes(payload) {
+		if attr.Name != "members" && attr.Name != "mp-members" {
+			continue
+		}
+		for _, m := range strings.Split(attr.Value, ",") {
+			m, _, _ = strings.Cut(strings.TrimSpace(m), "^")
+			if len(m) > 0 {
+				names = append(names, strings.ToUpper(m))
+			}
+		}
+	}
+	return names
+}
+
+// reachableSets keeps the sets which can be reached from root
+func reachableSets(members map[string][]string, root string) map[string][]string {
+	reached := map[string][]string{}
+	queue := []string{root}
+	for len(queue) > 0 {
+		set := queue[0]
+		queue = queue[1:]
+		if _, done := reached[set]; done {
+			continue
+		}
+		ms, ok := members[set]
+		if !ok {
+			continue
+		}
+		reached[set] = ms
+		queue = append(queue, ms...)
+	}
+	return reached
+}
+
+func buildSetGraph(members map[string][]string, kinds map[string]string) SetGraph {
+	graph := SetGraph{Nodes: []SetGraphNode{}, Edges: []SetGraphEdge{}, Cycles: [][]string{}}
+	sets := make([]string, 0, len(members))
+	for set := range members {
+		sets = append(sets, set)
+	}
+	slices.Sort(sets)
+	seen := map[string]bool{}
+	addNode := func(id string) {
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		graph.Nodes = append(graph.Nodes, SetGraphNode{ID: id, Kind: memberKind(id, kinds)})
+	}
+	for _, set := range sets {
+		addNode(set)
+		for _, m := range members[set] {
+			addNode(m)
+			graph.Edges = append(graph.Edges, SetGraphEdge{From: set, To: m})
+		}
+	}
+	graph.Cycles = findSetCycles(sets, members)
+	return graph
+}
+
+func memberKind(id string, kinds map[string]string) string {
+	if kind, ok := kinds[id]; ok {
+		return kind
+	}
+	if strings.Contains(id, "/") {
+		return PrefixNode
+	}
+	if asnPattern.MatchString(id) {
+		return ASNNode
+	}
+	return ExternalSetNode
+}
+
+// findSetCycles finds the strongly connected components of the graph with Tarjan's algorithm.
+// A component with more than one set, or a set which is a member of itself, is a cycle.
+func findSetCycles(sets []string, members map[string][]string) [][]string {
+	index := map[string]int{}
+	lowLink := map[string]int{}
+	onStack := map[string]bool{}
+	stack := []string{}
+	cycles := [][]string{}
+	var connect func(string)
+	connect = func(set string) {
+		index[set] = len(index)
+		lowLink[set] = index[set]
+		stack = append(stack, set)
+		onStack[set] = true
+		for _, m := range members[set] {
+			if _, isSet := members[m]; !isSet {
+				continue
+			}
+			if _, visited := index[m]; !visited {
+				connect(m)
+				lowLink[set] = min(lowLink[set], lowLink[m])
+			} else if onStack[m] {
+				lowLink[set] = min(lowLink[set], index[m])
+			}
+		}
+		if lowLink[set] != index[set] {
+			return
+		}
+		component := []string{}
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == set {
+				break
+			}
+		}
+		if len(component) > 1 || slices.Contains(members[set], set) {
+			slices.Sort(component)
+			cycles = append(cycles, component)
+		}
+	}
+	for _, set := range sets {
+		if _, visited := index[set]; !visited {
+			connect(set)
+		}
+	}
+	slices.SortFunc(cycles, func(a, b []string) int { return strings.Compare(a[0], b[0]) })
+	return cycles
+}
+
+// WriteSetGraph writes a set graph as Graphviz DOT or JSON. In DOT, sets which are part of a
+// cycle are drawn in red.
+func WriteSetGraph(w io.Writer, graph SetGraph, format string) error {
+	switch format {
+	case JSONFormat:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(graph)
+	case DOTFormat:
+		return writeSetGraphDOT(w, graph)
+	}
+	return ErrExportFormatNotSupported
+}
+
+var dotNodeShapes = map[string]string{
+	ASSetNode:       "box",
+	RouteSetNode:    "box",
+	ASNNode:         "ellipse",
+	PrefixNode:      "note",
+	ExternalSetNode: "box",
+}
+
+func writeSetGraphDOT(w io.Writer, graph SetGraph) error {
+	// cycle numbers start at 1, so 0 means a set isn't in one
+	cycleOf := map[string]int{}
+	for i, cycle := range graph.Cycles {
+		for _, set := range cycle {
+			cycleOf[set] = i + 1
+		}
+	}
+	var b strings.Builder
+	b.WriteString("digraph sets {\n")
+	for _, n := range graph.Nodes {
+		attrs := fmt.Sprintf("shape=%v", dotNodeShapes[n.Kind])
+		if n.Kind == ExternalSetNode {
+			attrs += ", style=dashed"
+		}
+		if cycleOf[n.ID] > 0 {
+			attrs += ", color=red"
+		}
+		fmt.Fprintf(&b, "  %q [%v];\n", n.ID, attrs)
+	}
+	for _, e := range graph.Edges {
+		attrs := ""
+		if c := cycleOf[e.From]; c > 0 && c == cycleOf[e.To] {
+			attrs = " [color=red]"
+		}
+		fmt.Fprintf(&b, "  %q -> %q%v;\n", e.From, e.To, attrs)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
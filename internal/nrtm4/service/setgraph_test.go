@@ -0,0 +1,55 @@
+package service
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSetMembers(t *testing.T) {
+	payload := "route-set: RS-EXAMPLE\nmembers: 192.0.2.0/24^+, RS-OTHER\nmp-members: 2001:db8::/32^48\n  , as-foo\nsource: TEST"
+	expected := []string{"192.0.2.0/24", "RS-OTHER", "2001:DB8::/32", "AS-FOO"}
+	if members := setMembers(payload); !slices.Equal(members, expected) {
+		t.Error("Expected", expected, "but was", members)
+	}
+}
+
+func TestSetGraphCycles(t *testing.T) {
+	members := map[string][]string{
+		"AS-A":    {"AS-B", "AS65000"},
+		"AS-B":    {"AS-C", "AS-REMOTE"},
+		"AS-C":    {"AS-A"},
+		"AS-SELF": {"AS-SELF"},
+		"AS-D":    {"AS-A", "AS65001"},
+	}
+	kinds := map[string]string{"AS-A": ASSetNode, "AS-B": ASSetNode, "AS-C": ASSetNode, "AS-SELF": ASSetNode, "AS-D": ASSetNode}
+	graph := buildSetGraph(members, kinds)
+	expected := [][]string{{"AS-A", "AS-B", "AS-C"}, {"AS-SELF"}}
+	if !slices.EqualFunc(graph.Cycles, expected, slices.Equal) {
+		t.Error("Expected cycles", expected, "but was", graph.Cycles)
+	}
+	if len(graph.Nodes) != 8 || len(graph.Edges) != 8 {
+		t.Error("Unexpected graph size", len(graph.Nodes), len(graph.Edges))
+	}
+	for _, n := range graph.Nodes {
+		if n.ID == "AS-REMOTE" && n.Kind != ExternalSetNode {
+			t.Error("Expected AS-REMOTE to be external but was", n.Kind)
+		}
+	}
+
+	reached := reachableSets(members, "AS-B")
+	if len(reached) != 3 {
+		t.Error("Expected AS-B, AS-C and AS-A to be reachable but was", reached)
+	}
+
+	var b strings.Builder
+	if err := WriteSetGraph(&b, graph, DOTFormat); err != nil {
+		t.Fatal("Failed to write DOT", err)
+	}
+	if !strings.Contains(b.String(), `"AS-C" -> "AS-A" [color=red];`) || strings.Contains(b.String(), `"AS-D" -> "AS-A" [color=red]`) {
+		t.Error("Unexpected DOT output", b.String())
+	}
+	if err := WriteSetGraph(&b, graph, "svg"); err != ErrExportFormatNotSupported {
+		t.Error("Expected ErrExportFormatNotSupported but was", err)
+	}
+}
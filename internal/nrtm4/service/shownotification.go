@@ -0,0 +1,48 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"math"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+// ErrNotificationNotFound there's no stored notification with the given version
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// GetNotification returns a stored notification. If version is zero the most recent one is
+// returned.
+func (p NRTMProcessor) GetNotification(sourceName, label string, version uint32) (persist.Notification, error) {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return persist.Notification{}, ErrSourceNotFound
+	}
+	from, to := version, version
+	if version == 0 {
+		from, to = 1, math.MaxUint32
+	}
+	notifs, err := ds.getNotifications(*source, from, to)
+	if err != nil {
+		return persist.Notification{}, err
+	}
+	if len(notifs) == 0 {
+		return persist.Notification{}, ErrNotificationNotFound
+	}
+	return notifs[0], nil
+}
+
+// FetchRawNotification downloads the source's notification file and returns it unaltered
+func (p NRTMProcessor) FetchRawNotification(sourceName, label string) ([]byte, error) {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return nil, ErrSourceNotFound
+	}
+	reader, err := p.client.getResponseBody(source.NotificationURL)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+var (
+	// ErrSignatureInvalid the notification file signature was not made with the expected key
+	ErrSignatureInvalid = errors.New("notification file signature is not valid")
+	// ErrVerifyConfigInvalid a source's verify config needs either a public key or a command
+	ErrVerifyConfigInvalid = errors.New("verify config must have one of public_key or command")
+)
+
+// signatureFileSuffix is appended to the notification URL to get its detached signature
+const signatureFileSuffix = ".sig"
+
+// VerifyConfig says how a source's notification file signature is checked. Either the
+// public key is given, or a command which does the verification, e.g. with a KMS or HSM.
+type VerifyConfig struct {
+	// PublicKey hex-encoded Ed25519 public key
+	PublicKey string `json:"public_key"`
+	// Command and its arguments. "{message}" and "{signature}" are replaced with the paths
+	// of files holding the notification file and its signature. Exit status 0 means valid.
+	Command []string `json:"command"`
+}
+
+// SignatureVerifier checks a signature over a message
+type SignatureVerifier interface {
+	Verify(message, signature []byte) error
+}
+
+// NewSignatureVerifier returns the verifier described by the config
+func NewSignatureVerifier(cfg VerifyConfig) (SignatureVerifier, error) {
+	hasKey, hasCommand := len(cfg.PublicKey) > 0, len(cfg.Command) > 0
+	if hasKey == hasCommand {
+		return nil, ErrVerifyConfigInvalid
+	}
+	if hasCommand {
+		return commandVerifier{command: cfg.Command}, nil
+	}
+	key, err := hex.DecodeString(cfg.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, ErrVerifyConfigInvalid
+	}
+	return ed25519Verifier{key: ed25519.PublicKey(key)}, nil
+}
+
+type ed25519Verifier struct {
+	key ed25519.PublicKey
+}
+
+// Verify accepts the signature hex or base64 encoded
+func (v ed25519Verifier) Verify(message, signature []byte) error {
+	encoded := strings.TrimSpace(string(signature))
+	sig, err := hex.DecodeString(encoded)
+	if err != nil {
+		if sig, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return ErrSignatureInvalid
+		}
+	}
+	if !ed25519.Verify(v.key, message, sig) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+// commandVerifier hands verification to an external program
+type commandVerifier struct {
+	command []string
+}
+
+func (v commandVerifier) Verify(message, signature []byte) error {
+	dir, err := os.MkdirTemp("", "nrtm4verify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	messageFile, signatureFile := filepath.Join(dir, "message"), filepath.Join(dir, "signature")
+	if err = os.WriteFile(messageFile, message, 0600); err != nil {
+		return err
+	}
+	if err = os.WriteFile(signatureFile, signature, 0600); err != nil {
+		return err
+	}
+	replacer := strings.NewReplacer("{message}", messageFile, "{signature}", signatureFile)
+	args := make([]string, len(v.command))
+	for i, arg := range v.command {
+		args[i] = replacer.Replace(arg)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			logger.Warn("Signature verification command failed", "command", v.command[0], "stderr", stderr.String())
+			return ErrSignatureInvalid
+		}
+		return err
+	}
+	return nil
+}
+
+// verifyNotification checks the signature of the source's notification file, if the source is
+// configured with a verifier. sourceName is the stored source's name, or the notification's
+// for a source which is being connected, so a server can't pick another source's key by naming
+// it. The file is fetched again with its signature, and the signed copy is returned so that
+// what's processed is exactly what was verified.
+func (p NRTMProcessor) verifyNotification(sourceName, notificationURL string, notification protocol.NotificationJSON) (protocol.NotificationJSON, error) {
+	if notification.Source != sourceName {
+		return notification, fmt.Errorf("%w: notification has %v, source is %v", ErrNRTM4SourceNameMismatch, notification.Source, sourceName)
+	}
+	cfg := p.config.sourceConfig(sourceName).Verify
+	if cfg == nil {
+		return notification, nil
+	}
+	verifier, err := NewSignatureVerifier(*cfg)
+	if err != nil {
+		return notification, err
+	}
+	message, err := p.readAll(notificationURL)
+	if err != nil {
+		return notification, err
+	}
+	signature, err := p.readAll(notificationURL + signatureFileSuffix)
+	if err != nil {
+		return notification, err
+	}
+	if err = verifier.Verify(message, signature); err != nil {
+		logger.Error("Notification file signature check failed", "source", notification.Source, "error", err)
+		return notification, err
+	}
+	verified, _, err := protocol.DecodeNotification(message)
+	if err != nil {
+		return notification, err
+	}
+	if verified.Source != sourceName {
+		return notification, fmt.Errorf("%w: notification has %v, source is %v", ErrNRTM4SourceNameMismatch, verified.Source, sourceName)
+	}
+	if err = validateNotificationFile(verified, p.config.strictness(sourceName), p.warnings); err != nil {
+		return notification, err
+	}
+	logger.Info("Notification file signature verified", "source", verified.Source)
+	verified.Raw = message
+	return verified, nil
+}
+
+func (p NRTMProcessor) readAll(url string) ([]byte, error) {
+	reader, err := p.client.getResponseBody(url)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
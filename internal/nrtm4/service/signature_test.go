@@ -0,0 +1,115 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+type urlBodyClient struct {
+	Client
+	bodies map[string]string
+}
+
+func (c urlBodyClient) getResponseBody(url string) (io.Reader, error) {
+	body, ok := c.bodies[url]
+	if !ok {
+		return nil, HTTPResponseError{Status: http.StatusNotFound, URL: url}
+	}
+	return strings.NewReader(body), nil
+}
+
+func TestEd25519Verifier(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal("Failed to generate key", err)
+	}
+	verifier, err := NewSignatureVerifier(VerifyConfig{PublicKey: hex.EncodeToString(pub)})
+	if err != nil {
+		t.Fatal("Failed to create verifier", err)
+	}
+	message := []byte(`{"source":"EXAMPLE"}`)
+	signature := hex.EncodeToString(ed25519.Sign(priv, message))
+	if err = verifier.Verify(message, []byte(signature+"\n")); err != nil {
+		t.Error("Expected signature to verify but was", err)
+	}
+	if err = verifier.Verify([]byte(`{"source":"OTHER"}`), []byte(signature)); err != ErrSignatureInvalid {
+		t.Error("Expected ErrSignatureInvalid but was", err)
+	}
+}
+
+func TestCommandVerifier(t *testing.T) {
+	verifier, err := NewSignatureVerifier(VerifyConfig{
+		Command: []string{"sh", "-c", `test "$(cat "$1")" = "$(cat "$2")"`, "verify", "{message}", "{signature}"},
+	})
+	if err != nil {
+		t.Fatal("Failed to create verifier", err)
+	}
+	if err = verifier.Verify([]byte("same"), []byte("same")); err != nil {
+		t.Error("Expected command to accept signature but was", err)
+	}
+	if err = verifier.Verify([]byte("same"), []byte("different")); err != ErrSignatureInvalid {
+		t.Error("Expected ErrSignatureInvalid but was", err)
+	}
+}
+
+func TestNewSignatureVerifierConfig(t *testing.T) {
+	for _, cfg := range []VerifyConfig{
+		{},
+		{PublicKey: "abcd"},
+		{PublicKey: strings.Repeat("00", ed25519.PublicKeySize), Command: []string{"true"}},
+	} {
+		if _, err := NewSignatureVerifier(cfg); err != ErrVerifyConfigInvalid {
+			t.Error("Expected ErrVerifyConfigInvalid but was", err, "for", cfg)
+		}
+	}
+}
+
+func TestVerifyNotification(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal("Failed to generate key", err)
+	}
+	url := "https://example.com/nrtm4/update-notification-file.json"
+	signed := notificationExample
+	var notification protocol.NotificationJSON
+	notification.Source = "EXAMPLE"
+	config := AppConfig{Sources: map[string]SourceConfig{
+		"example": {Verify: &VerifyConfig{PublicKey: hex.EncodeToString(pub)}},
+	}}
+	{
+		client := urlBodyClient{bodies: map[string]string{
+			url:          signed,
+			url + ".sig": hex.EncodeToString(ed25519.Sign(priv, []byte(signed))),
+		}}
+		p := NRTMProcessor{config: config, client: client}
+		verified, err := p.verifyNotification("EXAMPLE", url, notification)
+		if err != nil {
+			t.Fatal("Expected notification to verify but was", err)
+		}
+		if verified.SessionID == notification.SessionID {
+			t.Error("Expected the signed notification to be returned")
+		}
+	}
+	{
+		client := urlBodyClient{bodies: map[string]string{
+			url:          signed,
+			url + ".sig": hex.EncodeToString(ed25519.Sign(priv, []byte("something else"))),
+		}}
+		p := NRTMProcessor{config: config, client: client}
+		if _, err = p.verifyNotification("EXAMPLE", url, notification); err != ErrSignatureInvalid {
+			t.Error("Expected ErrSignatureInvalid but was", err)
+		}
+		// A server can't name a source without a key to skip the check
+		notification.Source = "OTHER"
+		if _, err = p.verifyNotification("EXAMPLE", url, notification); !errors.Is(err, ErrNRTM4SourceNameMismatch) {
+			t.Error("Expected ErrNRTM4SourceNameMismatch but was", err)
+		}
+	}
+}
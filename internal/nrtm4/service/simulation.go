@@ -0,0 +1,383 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// ErrInvalidScenario the simulation's scenario can't be used
+var ErrInvalidScenario = errors.New("invalid simulation scenario")
+
+const (
+	// scenarioFileName is the file in a scenario's directory which lists its steps
+	scenarioFileName = "scenario.json"
+	// simulationLabel is the start of the label a simulation connects its source with, so it
+	// never touches a source which is already mirrored
+	simulationLabel = "simulation"
+	// simulationURL is the notification URL of a scenario which doesn't give one. The .invalid
+	// domain never resolves, so nothing can be fetched from a real server by mistake.
+	simulationURL = "https://simulation.invalid/notification.json"
+	// defaultSimulationInterval is how often the simulated scheduler runs update
+	defaultSimulationInterval = time.Hour
+)
+
+// scenarioJSON is the format of scenario.json
+type scenarioJSON struct {
+	NotificationURL string `json:"notification_url"`
+	Start           string `json:"start"`
+	Interval        string `json:"interval"`
+	Until           string `json:"until"`
+	Steps           []struct {
+		At           string `json:"at"`
+		Notification string `json:"notification"`
+		Unavailable  bool   `json:"unavailable"`
+	} `json:"steps"`
+}
+
+// Scenario is a recorded sequence of notification files, which a simulation replays in virtual
+// time. It's read from scenario.json in a directory which also has the notification, snapshot
+// and delta files.
+type Scenario struct {
+	Dir             string
+	NotificationURL string
+	// Start is the virtual time the simulation starts at. The default is the timestamp of the
+	// first notification file.
+	Start time.Time
+	// Interval is how often the simulated scheduler runs update
+	Interval time.Duration
+	// Until is how long after Start the simulation ends. The default is an interval after the
+	// last step.
+	Until time.Duration
+	Steps []ScenarioStep
+}
+
+// ScenarioStep is the server publishing a notification file At after the start of the
+// scenario. An Unavailable server answers every request with 503 until the next step.
+type ScenarioStep struct {
+	At           time.Duration
+	Notification string
+	Unavailable  bool
+}
+
+// ReadScenario reads the scenario in dir
+func ReadScenario(dir string) (Scenario, error) {
+	scenario := Scenario{Dir: dir, NotificationURL: simulationURL, Interval: defaultSimulationInterval}
+	bytes, err := os.ReadFile(filepath.Join(dir, scenarioFileName))
+	if err != nil {
+		return scenario, err
+	}
+	var sj scenarioJSON
+	if err = json.Unmarshal(bytes, &sj); err != nil {
+		return scenario, fmt.Errorf("%w: %v", ErrInvalidScenario, err)
+	}
+	if len(sj.NotificationURL) > 0 {
+		if !validateURLString(sj.NotificationURL) {
+			return scenario, fmt.Errorf("%w: notification_url is not a URL: %v", ErrInvalidScenario, sj.NotificationURL)
+		}
+		scenario.NotificationURL = sj.NotificationURL
+	}
+	if len(sj.Interval) > 0 {
+		if scenario.Interval, err = time.ParseDuration(sj.Interval); err != nil || scenario.Interval <= 0 {
+			return scenario, fmt.Errorf("%w: interval must be a positive duration: %v", ErrInvalidScenario, sj.Interval)
+		}
+	}
+	for i, s := range sj.Steps {
+		step := ScenarioStep{Notification: s.Notification, Unavailable: s.Unavailable}
+		if step.At, err = time.ParseDuration(s.At); err != nil {
+			return scenario, fmt.Errorf("%w: step %d: at: %v", ErrInvalidScenario, i+1, err)
+		}
+		if i == 0 && (step.At != 0 || step.Unavailable) {
+			return scenario, fmt.Errorf("%w: the first step must publish a notification file at 0s", ErrInvalidScenario)
+		}
+		if i > 0 && step.At <= scenario.Steps[i-1].At {
+			return scenario, fmt.Errorf("%w: step %d isn't after the one before", ErrInvalidScenario, i+1)
+		}
+		if step.Unavailable == (len(step.Notification) > 0) {
+			return scenario, fmt.Errorf("%w: step %d must have either a notification file or unavailable", ErrInvalidScenario, i+1)
+		}
+		if step.Unavailable {
+			scenario.Steps = append(scenario.Steps, step)
+			continue
+		}
+		if !filepath.IsLocal(step.Notification) {
+			return scenario, fmt.Errorf("%w: step %d: notification file must be in the scenario's directory: %v", ErrInvalidScenario, i+1, step.Notification)
+		}
+		if _, err = os.Stat(filepath.Join(dir, step.Notification)); err != nil {
+			return scenario, fmt.Errorf("%w: step %d: %v", ErrInvalidScenario, i+1, err)
+		}
+		scenario.Steps = append(scenario.Steps, step)
+	}
+	if len(scenario.Steps) == 0 {
+		return scenario, fmt.Errorf("%w: there are no steps", ErrInvalidScenario)
+	}
+	if scenario.Start, err = scenario.startTime(sj.Start); err != nil {
+		return scenario, err
+	}
+	scenario.Until = scenario.Steps[len(scenario.Steps)-1].At + scenario.Interval
+	if len(sj.Until) > 0 {
+		if scenario.Until, err = time.ParseDuration(sj.Until); err != nil || scenario.Until <= 0 {
+			return scenario, fmt.Errorf("%w: until must be a positive duration: %v", ErrInvalidScenario, sj.Until)
+		}
+	}
+	return scenario, nil
+}
+
+// startTime parses start, or reads the timestamp of the first notification file when it's empty
+func (s Scenario) startTime(start string) (time.Time, error) {
+	if len(start) == 0 {
+		notification, err := s.readNotification(s.Steps[0])
+		if err != nil {
+			return time.Time{}, err
+		}
+		start = notification.Timestamp
+	}
+	ts, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return ts, fmt.Errorf("%w: start isn't a valid timestamp: %v", ErrInvalidScenario, start)
+	}
+	return ts.UTC(), nil
+}
+
+// stepAt is the step the scenario is at, elapsed after its start
+func (s Scenario) stepAt(elapsed time.Duration) ScenarioStep {
+	current := s.Steps[0]
+	for _, step := range s.Steps[1:] {
+		if step.At > elapsed {
+			break
+		}
+		current = step
+	}
+	return current
+}
+
+func (s Scenario) readNotification(step ScenarioStep) (protocol.NotificationJSON, error) {
+	raw, err := os.ReadFile(filepath.Join(s.Dir, step.Notification))
+	if err != nil {
+		return protocol.NotificationJSON{}, err
+	}
+	notification, _, err := protocol.DecodeNotification(raw)
+	return notification, err
+}
+
+// scenarioClient is a Client which serves a scenario's files from its directory, as its server
+// would have at the time on util.AppClock
+type scenarioClient struct {
+	scenario Scenario
+}
+
+func (c scenarioClient) step() ScenarioStep {
+	return c.scenario.stepAt(util.AppClock.Since(c.scenario.Start))
+}
+
+func (c scenarioClient) getUpdateNotification(url string) (protocol.NotificationJSON, http.Header, error) {
+	header := http.Header{"Date": []string{util.AppClock.Now().Format(http.TimeFormat)}}
+	step := c.step()
+	if step.Unavailable {
+		return protocol.NotificationJSON{}, header, unavailableError(url)
+	}
+	notification, err := c.scenario.readNotification(step)
+	return notification, header, err
+}
+
+func (c scenarioClient) getResponseBody(url string) (io.Reader, error) {
+	raw, err := c.read(url)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(raw), nil
+}
+
+func (c scenarioClient) getFile(url string, req fileRequest) (fileResponse, error) {
+	raw, err := c.read(url)
+	if err != nil {
+		return fileResponse{}, err
+	}
+	if req.Offset > int64(len(raw)) {
+		return fileResponse{}, HTTPResponseError{Status: http.StatusRequestedRangeNotSatisfiable, Message: "416 Requested Range Not Satisfiable", URL: url}
+	}
+	return fileResponse{Body: bytes.NewReader(raw[req.Offset:]), Partial: req.Offset > 0}, nil
+}
+
+// read returns a file in the scenario's directory. URLs beside the notification file map to
+// paths relative to the directory, and other URLs to their file name.
+func (c scenarioClient) read(url string) ([]byte, error) {
+	step := c.step()
+	if step.Unavailable {
+		return nil, unavailableError(url)
+	}
+	name := step.Notification
+	if url != c.scenario.NotificationURL {
+		base := c.scenario.NotificationURL[:strings.LastIndex(c.scenario.NotificationURL, "/")+1]
+		var ok bool
+		if name, ok = strings.CutPrefix(url, base); !ok {
+			name = path.Base(url)
+		}
+	}
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return nil, HTTPResponseError{Status: http.StatusNotFound, Message: "404 Not Found", URL: url}
+	}
+	raw, err := os.ReadFile(filepath.Join(c.scenario.Dir, filepath.FromSlash(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, HTTPResponseError{Status: http.StatusNotFound, Message: "404 Not Found", URL: url}
+	}
+	return raw, err
+}
+
+func unavailableError(url string) error {
+	return HTTPResponseError{Status: http.StatusServiceUnavailable, Message: "503 Service Unavailable", URL: url}
+}
+
+// alertRecorder is a Notifier which keeps the subjects of the messages it's sent
+type alertRecorder struct {
+	mu       sync.Mutex
+	subjects []string
+}
+
+func (a *alertRecorder) Notify(subject string, message string) error {
+	logger.Info("Simulated alert", "subject", subject, "message", message)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.subjects = append(a.subjects, subject)
+	return nil
+}
+
+// take returns the subjects sent since it was last called
+func (a *alertRecorder) take() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	subjects := a.subjects
+	a.subjects = nil
+	return subjects
+}
+
+// SimulationOptions change how a simulation runs
+type SimulationOptions struct {
+	// Keep leaves the source and its files behind, instead of removing them
+	Keep bool
+}
+
+// SimulationEvent is the outcome of one run of the simulated scheduler
+type SimulationEvent struct {
+	At           time.Time `json:"at"`
+	Command      string    `json:"command"`
+	Notification string    `json:"notification,omitempty"`
+	Version      uint32    `json:"version"`
+	Warnings     []Warning `json:"warnings,omitempty"`
+	Alerts       []string  `json:"alerts,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// SimulationReport lists what happened at each run of the simulated scheduler. ExitCode uses
+// the same values as the validate command: ExitErrors when a sync failed, otherwise
+// ExitWarnings when one had warnings.
+type SimulationReport struct {
+	Scenario string            `json:"scenario"`
+	Source   string            `json:"source"`
+	Label    string            `json:"label"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Events   []SimulationEvent `json:"events"`
+	ExitCode int               `json:"exit_code"`
+}
+
+func (r *SimulationReport) add(at time.Time, command string, step ScenarioStep, result SyncResult, err error, alerts *alertRecorder) {
+	event := SimulationEvent{
+		At:           at,
+		Command:      command,
+		Notification: step.Notification,
+		Version:      result.ToVersion,
+		Warnings:     result.Warnings,
+		Alerts:       alerts.take(),
+	}
+	if err != nil {
+		event.Error = strings.TrimSpace(err.Error())
+		r.ExitCode = ExitErrors
+	} else if len(result.Warnings) > 0 && r.ExitCode == ExitConformant {
+		r.ExitCode = ExitWarnings
+	}
+	r.Events = append(r.Events, event)
+}
+
+// Simulate replays a scenario against the repo in virtual time, as a scheduler would run the
+// client: the source is connected under a new label at the start, then updated every interval
+// until the end. The clock only moves between runs and while the client backs off, so days of a
+// server's life take as long as the syncs do. Quarantine, retention, staleness and change rate
+// alerts all see the virtual time. util.AppClock is replaced while it runs, so nothing else
+// should run in the process.
+func (p NRTMProcessor) Simulate(scenario Scenario, opts SimulationOptions) (SimulationReport, error) {
+	report := SimulationReport{
+		Scenario: scenario.Dir,
+		Label:    simulationLabel + " " + util.AppClock.Now().Format("20060102T150405"),
+		Start:    scenario.Start,
+		Events:   []SimulationEvent{},
+	}
+	dir, err := os.MkdirTemp(p.config.TempDir, "nrtm4sim")
+	if err != nil {
+		return report, err
+	}
+	if !opts.Keep {
+		defer os.RemoveAll(dir)
+	}
+	clock := util.NewManualClock(scenario.Start)
+	defer func(real util.Clock) { util.AppClock = real }(util.AppClock)
+	util.AppClock = clock
+
+	client := scenarioClient{scenario}
+	notification, _, err := client.getUpdateNotification(scenario.NotificationURL)
+	if err != nil {
+		return report, err
+	}
+	report.Source = notification.Source
+	alerts := &alertRecorder{}
+	p.client = client
+	p.notifier = alerts
+	sc := p.config.sourceConfig(notification.Source)
+	p.config = liveTestConfig(p.config, notification.Source, dir, LiveTestOptions{Filter: sc.Filter, MaxFileSize: p.config.MaxFileSize})
+
+	logger.Info("Simulating", "scenario", scenario.Dir, "source", report.Source, "label", report.Label, "start", scenario.Start, "until", scenario.Until)
+	result, err := p.Connect(scenario.NotificationURL, report.Label)
+	report.add(scenario.Start, "connect", client.step(), result, err, alerts)
+	ds := NrtmDataService{Repository: p.repo}
+	if !opts.Keep {
+		defer func() {
+			if ds.getSourceByNameAndLabel(report.Source, report.Label) == nil {
+				return
+			}
+			if err := p.RemoveSource(report.Source, report.Label); err != nil {
+				logger.Warn("Failed to remove the simulated source", "source", report.Source, "label", report.Label, "error", err)
+			}
+		}()
+	}
+	if err != nil {
+		report.End = clock.Now()
+		return report, nil
+	}
+	for next := scenario.Start; ; {
+		next = next.Add(scenario.Interval)
+		if next.Sub(scenario.Start) > scenario.Until {
+			break
+		}
+		if next.Before(clock.Now()) {
+			// Like a ticker, a run which was due while a sync was backing off is skipped
+			continue
+		}
+		clock.Advance(next.Sub(clock.Now()))
+		result, err = p.Update(report.Source, report.Label, CatchUpAuto)
+		report.add(next, "update", client.step(), result, err, alerts)
+	}
+	report.End = clock.Now()
+	return report, nil
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/testresources"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// writeScenario makes a scenario directory with the RIPE notification file and scenario.json
+func writeScenario(t *testing.T, scenarioJSON string) string {
+	dir := t.TempDir()
+	f := testresources.OpenFile(t, "ripe-notification-file.json")
+	defer f.Close()
+	notification, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string][]byte{
+		"notification.1.json":    notification,
+		"notification.2.json":    notification,
+		"nrtm-delta.350194.json": []byte("delta"),
+		scenarioFileName:         []byte(scenarioJSON),
+	} {
+		if err = os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestReadScenario(t *testing.T) {
+	dir := writeScenario(t, `{"steps": [
+		{"at": "0s", "notification": "notification.1.json"},
+		{"at": "6h", "unavailable": true},
+		{"at": "8h", "notification": "notification.2.json"}
+	]}`)
+	scenario, err := ReadScenario(dir)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if expected := time.Date(2025, 1, 4, 23, 1, 0, 0, time.UTC); !scenario.Start.Equal(expected) {
+		t.Error("Expected the start to be the notification's timestamp but was", scenario.Start)
+	}
+	if scenario.Interval != defaultSimulationInterval || scenario.Until != 9*time.Hour || scenario.NotificationURL != simulationURL {
+		t.Error("Unexpected defaults", scenario.Interval, scenario.Until, scenario.NotificationURL)
+	}
+	for elapsed, expected := range map[time.Duration]ScenarioStep{
+		5 * time.Hour: scenario.Steps[0],
+		6 * time.Hour: scenario.Steps[1],
+		9 * time.Hour: scenario.Steps[2],
+	} {
+		if step := scenario.stepAt(elapsed); step != expected {
+			t.Error("Expected step", expected, "at", elapsed, "but was", step)
+		}
+	}
+
+	for _, invalid := range []string{
+		`{"steps": []}`,
+		`{"steps": [{"at": "1h", "notification": "notification.1.json"}]}`,
+		`{"steps": [{"at": "0s", "notification": "notification.1.json"}, {"at": "0s", "notification": "notification.2.json"}]}`,
+		`{"steps": [{"at": "0s", "notification": "../notification.1.json"}]}`,
+		`{"steps": [{"at": "0s", "notification": "missing.json"}]}`,
+		`{"interval": "-1h", "steps": [{"at": "0s", "notification": "notification.1.json"}]}`,
+		`{"steps": [{"at": "0s", "notification": "notification.1.json"}, {"at": "1h", "notification": "notification.2.json", "unavailable": true}]}`,
+	} {
+		if _, err := ReadScenario(writeScenario(t, invalid)); !errors.Is(err, ErrInvalidScenario) {
+			t.Error("Expected ErrInvalidScenario for", invalid, "but was", err)
+		}
+	}
+}
+
+func TestScenarioClient(t *testing.T) {
+	dir := writeScenario(t, `{"notification_url": "https://nrtm.example.net/nrtmv4/notification.json", "steps": [
+		{"at": "0s", "notification": "notification.1.json"},
+		{"at": "2h", "unavailable": true}
+	]}`)
+	scenario, err := ReadScenario(dir)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	clock := util.NewManualClock(scenario.Start)
+	util.AppClock = clock
+	client := scenarioClient{scenario}
+
+	notification, header, err := client.getUpdateNotification(scenario.NotificationURL)
+	if err != nil || notification.Source != "RIPE" {
+		t.Fatal("Expected the first notification file but was", notification.Source, err)
+	}
+	if estimateClockSkew(header, clock.Now()) != 0 {
+		t.Error("Expected the server's Date to be the virtual time", header)
+	}
+	file, err := client.getFile("https://nrtm.example.net/nrtmv4/nrtm-delta.350194.json", fileRequest{Offset: 2})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if body, _ := io.ReadAll(file.Body); string(body) != "lta" || !file.Partial {
+		t.Error("Expected the rest of the delta but was", string(body), file.Partial)
+	}
+	if _, err = client.getFile("https://nrtm.example.net/nrtmv4/missing.json", fileRequest{}); !isNotFound(err) {
+		t.Error("Expected a missing file to be not found but was", err)
+	}
+
+	clock.Advance(2 * time.Hour)
+	var respErr HTTPResponseError
+	if _, _, err = client.getUpdateNotification(scenario.NotificationURL); !errors.As(err, &respErr) || respErr.Status != http.StatusServiceUnavailable {
+		t.Error("Expected the server to be unavailable but was", err)
+	}
+}
+
+func TestAlertRecorder(t *testing.T) {
+	alerts := &alertRecorder{}
+	p := NRTMProcessor{notifier: alerts}
+	p.newNotifier().Notify("NRTMv4 unusual rate of change for RIPE", "message")
+	if subjects := alerts.take(); len(subjects) != 1 || subjects[0] != "NRTMv4 unusual rate of change for RIPE" {
+		t.Error("Expected the alert to be recorded but was", subjects)
+	}
+	if subjects := alerts.take(); len(subjects) != 0 {
+		t.Error("Expected the alerts to be taken but was", subjects)
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"encoding/json"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+// decodeSnapshotObject unmarshals a snapshot object record. Building with -tags fastjson swaps it
+// for fastDecodeSnapshotObject.
+var decodeSnapshotObject = stdDecodeSnapshotObject
+
+func stdDecodeSnapshotObject(bytes []byte, so *protocol.SnapshotObjectJSON) error {
+	return json.Unmarshal(bytes, so)
+}
+
+// fastDecodeSnapshotObject decodes records which are exactly {"object": "..."} without going
+// through reflection. Anything else, e.g. extra keys, surrogate pairs or invalid UTF-8, is left to
+// encoding/json, so the result is always the same as stdDecodeSnapshotObject.
+func fastDecodeSnapshotObject(bytes []byte, so *protocol.SnapshotObjectJSON) error {
+	if obj, ok := scanSnapshotObject(bytes); ok {
+		so.Object = obj
+		return nil
+	}
+	return stdDecodeSnapshotObject(bytes, so)
+}
+
+// scanSnapshotObject returns the object string from {"object": "..."}, or false if the record
+// has any other shape
+func scanSnapshotObject(b []byte) (string, bool) {
+	i := skipSpace(b, 0)
+	if i >= len(b) || b[i] != '{' {
+		return "", false
+	}
+	i = skipSpace(b, i+1)
+	const key = `"object"`
+	if len(b)-i < len(key) || string(b[i:i+len(key)]) != key {
+		return "", false
+	}
+	i = skipSpace(b, i+len(key))
+	if i >= len(b) || b[i] != ':' {
+		return "", false
+	}
+	i = skipSpace(b, i+1)
+	if i >= len(b) || b[i] != '"' {
+		return "", false
+	}
+	obj, end, ok := scanString(b, i+1)
+	if !ok {
+		return "", false
+	}
+	i = skipSpace(b, end)
+	if i >= len(b) || b[i] != '}' {
+		return "", false
+	}
+	if skipSpace(b, i+1) != len(b) {
+		return "", false
+	}
+	return obj, true
+}
+
+// scanString unquotes the JSON string starting after the opening quote at start. It returns the
+// index after the closing quote.
+func scanString(b []byte, start int) (string, int, bool) {
+	// Most of the string is usually plain text between \n escapes, so copy it in runs
+	var out []byte
+	run := start
+	for i := start; i < len(b); {
+		c := b[i]
+		switch {
+		case c == '"':
+			if out == nil {
+				if !utf8.Valid(b[start:i]) {
+					return "", 0, false
+				}
+				return string(b[start:i]), i + 1, true
+			}
+			out = append(out, b[run:i]...)
+			if !utf8.Valid(out) {
+				return "", 0, false
+			}
+			return string(out), i + 1, true
+		case c < 0x20:
+			return "", 0, false
+		case c == '\\':
+			if i+1 >= len(b) {
+				return "", 0, false
+			}
+			if out == nil {
+				out = make([]byte, 0, len(b)-start)
+			}
+			out = append(out, b[run:i]...)
+			switch b[i+1] {
+			case 'n':
+				out = append(out, '\n')
+			case 't':
+				out = append(out, '\t')
+			case 'r':
+				out = append(out, '\r')
+			case '"', '\\', '/':
+				out = append(out, b[i+1])
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case 'u':
+				if i+6 > len(b) {
+					return "", 0, false
+				}
+				r, err := strconv.ParseUint(string(b[i+2:i+6]), 16, 16)
+				if err != nil || utf8.RuneLen(rune(r)) < 0 {
+					// Surrogate halves are left to encoding/json
+					return "", 0, false
+				}
+				out = utf8.AppendRune(out, rune(r))
+				i += 4
+			default:
+				return "", 0, false
+			}
+			i += 2
+			run = i
+		default:
+			i++
+		}
+	}
+	return "", 0, false
+}
+
+func skipSpace(b []byte, i int) int {
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t' || b[i] == '\n' || b[i] == '\r') {
+		i++
+	}
+	return i
+}
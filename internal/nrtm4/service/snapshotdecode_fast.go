@@ -0,0 +1,7 @@
+//go:build fastjson
+
+package service
+
+func init() {
+	decodeSnapshotObject = fastDecodeSnapshotObject
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+func TestFastDecodeSnapshotObject(t *testing.T) {
+	records := []string{
+		`{"object": "route: 192.0.2.0/24\norigin: AS65530\nsource: EXAMPLE"}`,
+		` { "object" : "plain" } `,
+		`{"object": "quote \" slash \/ backslash \\ tab \t cr \r"}`,
+		`{"object": "descr: München €"}`,
+		`{"object": "descr: ` + "Zürich" + `"}`,
+		`{"object": "surrogate 😀"}`,
+		`{"object": "bad utf8 ` + "\xff" + `"}`,
+		`{"object": ""}`,
+		`{"Object": "other case"}`,
+		`{"object": "first", "extra": 1}`,
+		`{"object": null}`,
+		`{"object": "unterminated}`,
+		`{"object": "bad escape \x"}`,
+		`[]`,
+		``,
+	}
+	for _, record := range records {
+		std := protocol.SnapshotObjectJSON{}
+		stdErr := stdDecodeSnapshotObject([]byte(record), &std)
+		fast := protocol.SnapshotObjectJSON{}
+		fastErr := fastDecodeSnapshotObject([]byte(record), &fast)
+		if (stdErr == nil) != (fastErr == nil) || std.Object != fast.Object {
+			t.Errorf("Decoding %q: expected %q, %v but was %q, %v", record, std.Object, stdErr, fast.Object, fastErr)
+		}
+	}
+}
+
+func TestBytesToRPSLRevision(t *testing.T) {
+	parser := rpslObjectParser{revision: protocol.Revision{Name: "renamed", NrtmVersion: 4, Fields: map[string]string{"rpsl": "object"}}}
+	obj := parser.bytesToRPSL([]byte(`{"rpsl": "route: 192.0.2.0/24\norigin: AS65530\nsource: EXAMPLE"}`))
+	if obj == nil || obj.PrimaryKey != "192.0.2.0/24AS65530" {
+		t.Error("Expected the object to be read with the revision's field names but was", obj)
+	}
+}
+
+var benchmarkRecord = []byte(`{"object": "` + strings.Repeat(`route: 192.0.2.0/24\ndescr: An example route\norigin: AS65530\nmnt-by: EXAMPLE-MNT\n`, 4) + `source: EXAMPLE"}`)
+
+func BenchmarkStdDecodeSnapshotObject(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkRecord)))
+	b.ReportAllocs()
+	for range b.N {
+		so := protocol.SnapshotObjectJSON{}
+		if err := stdDecodeSnapshotObject(benchmarkRecord, &so); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFastDecodeSnapshotObject(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkRecord)))
+	b.ReportAllocs()
+	for range b.N {
+		so := protocol.SnapshotObjectJSON{}
+		if err := fastDecodeSnapshotObject(benchmarkRecord, &so); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBytesToRPSL(b *testing.B) {
+	parser := rpslObjectParser{}
+	b.SetBytes(int64(len(benchmarkRecord)))
+	b.ReportAllocs()
+	for range b.N {
+		if parser.bytesToRPSL(benchmarkRecord) == nil {
+			b.Fatal("Expected an object")
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// ErrSnapshotNotArchived no snapshot with the version has been recorded for the source
+var ErrSnapshotNotArchived = errors.New("snapshot has not been recorded for the source")
+
+// archiveSnapshotRefs records the current and previous snapshots a notification advertises, if
+// the source's config asks for it. Relative URLs are resolved, so a snapshot can be fetched
+// without the notification. Failing to record them doesn't stop the sync.
+func (p NRTMProcessor) archiveSnapshotRefs(source persist.NRTMSource, notificationURL string, notification protocol.NotificationJSON) {
+	if !p.config.sourceConfig(source.Source).ArchiveSnapshots {
+		return
+	}
+	now := util.AppClock.Now()
+	refs := []persist.SnapshotRef{}
+	seen := map[uint32]bool{}
+	for _, ref := range append([]protocol.FileRefJSON{notification.SnapshotRef}, notification.PrevSnapshots...) {
+		if ref.Version == 0 || seen[ref.Version] {
+			continue
+		}
+		url, err := resolveFileURL(notificationURL, ref.URL, p.config.StrictFileURLs)
+		if err != nil {
+			logger.Warn("Not recording snapshot with an invalid url", "source", source.Source, "version", ref.Version, "url", ref.URL, "error", err)
+			continue
+		}
+		seen[ref.Version] = true
+		refs = append(refs, persist.SnapshotRef{
+			SessionID: notification.SessionID,
+			Version:   ref.Version,
+			URL:       url,
+			Hash:      ref.Hash,
+			FirstSeen: now,
+			LastSeen:  now,
+		})
+	}
+	if err := p.repo.SaveSnapshotRefs(source, refs); err != nil {
+		logger.Warn("Failed to record snapshot lineage", "source", source.Source, "error", err)
+		p.warnings.add(WarningBookkeeping, notification.Version, "snapshot lineage wasn't recorded: %v", err)
+	}
+}
+
+// SnapshotLineage lists the snapshots recorded for a source, the most recently advertised first
+func (p NRTMProcessor) SnapshotLineage(sourceName, label string) ([]persist.SnapshotRef, error) {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return nil, ErrSourceNotFound
+	}
+	return p.repo.GetSnapshotRefs(*source)
+}
+
+// FetchArchivedSnapshot downloads a recorded snapshot to dir, checks its hash and returns its
+// path. Versions start again in a new session, so the source's current session is preferred,
+// then the session which advertised the version most recently. The server may have removed the
+// file since.
+func (p NRTMProcessor) FetchArchivedSnapshot(sourceName, label string, version uint32, dir string) (string, error) {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return "", ErrSourceNotFound
+	}
+	refs, err := p.repo.GetSnapshotRefs(*source)
+	if err != nil {
+		return "", err
+	}
+	var found *persist.SnapshotRef
+	for i, ref := range refs {
+		if ref.Version == version && (found == nil || ref.SessionID == source.SessionID) {
+			found = &refs[i]
+		}
+	}
+	if found == nil {
+		return "", fmt.Errorf("%w: version %d", ErrSnapshotNotArchived, version)
+	}
+	fm := fileManager{client: p.client, warnings: &syncWarnings{}}
+	if err = fm.ensureDirectoryExists(dir); err != nil {
+		return "", err
+	}
+	file, err := fm.fetchFileAndCheckHash(found.URL, protocol.FileRefJSON{Version: found.Version, URL: found.URL, Hash: found.Hash}, dir, p.config.TempDir)
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+	return file.Name(), nil
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+type snapshotRefRepo struct {
+	persist.Repository
+	refs *[]persist.SnapshotRef
+}
+
+func (r snapshotRefRepo) GetSources() ([]persist.NRTMSource, error) {
+	return []persist.NRTMSource{{ID: 1, Source: "EXAMPLE", SessionID: "s1", Version: 9}}, nil
+}
+
+func (r snapshotRefRepo) SaveSnapshotRefs(source persist.NRTMSource, refs []persist.SnapshotRef) error {
+	*r.refs = append(*r.refs, refs...)
+	return nil
+}
+
+func (r snapshotRefRepo) GetSnapshotRefs(source persist.NRTMSource) ([]persist.SnapshotRef, error) {
+	return *r.refs, nil
+}
+
+func TestArchiveSnapshotRefs(t *testing.T) {
+	repo := snapshotRefRepo{refs: &[]persist.SnapshotRef{}}
+	p := NRTMProcessor{repo: repo}
+	source := persist.NRTMSource{ID: 1, Source: "EXAMPLE"}
+	notification := protocol.NotificationJSON{
+		NrtmFileJSON: protocol.NrtmFileJSON{SessionID: "s1", Version: 9},
+		SnapshotRef:  protocol.FileRefJSON{Version: 8, URL: "snapshot.8.json", Hash: "h8"},
+		PrevSnapshots: protocol.SnapshotRefsJSON{
+			{Version: 4, URL: "https://archive.example.com/snapshot.4.json", Hash: "h4"},
+			{Version: 8, URL: "snapshot.8.json", Hash: "h8"},
+		},
+	}
+	p.archiveSnapshotRefs(source, "https://example.com/nrtm/notification.json", notification)
+	if len(*repo.refs) != 0 {
+		t.Fatal("Expected nothing to be recorded unless the source is configured to", *repo.refs)
+	}
+	p.config.Sources = map[string]SourceConfig{"example": {ArchiveSnapshots: true}}
+	p.archiveSnapshotRefs(source, "https://example.com/nrtm/notification.json", notification)
+	refs := *repo.refs
+	if len(refs) != 2 {
+		t.Fatal("Expected the current and previous snapshot but was", refs)
+	}
+	if refs[0].Version != 8 || refs[0].URL != "https://example.com/nrtm/snapshot.8.json" || refs[0].SessionID != "s1" {
+		t.Error("Expected the current snapshot with its url resolved but was", refs[0])
+	}
+	if refs[1].Version != 4 || refs[1].Hash != "h4" || refs[1].FirstSeen.IsZero() {
+		t.Error("Unexpected previous snapshot", refs[1])
+	}
+}
+
+func TestFetchArchivedSnapshot(t *testing.T) {
+	body := "\x1e{}\n"
+	sum := sha256.Sum256([]byte(body))
+	repo := snapshotRefRepo{refs: &[]persist.SnapshotRef{
+		{SessionID: "s1", Version: 4, URL: "https://example.com/nrtm/snapshot.4.json", Hash: hex.EncodeToString(sum[:])},
+		{SessionID: "s0", Version: 4, URL: "https://example.com/old/snapshot.4.json", Hash: "h0"},
+	}}
+	p := NRTMProcessor{repo: repo, client: stubDeltaClient{responseBody: body}}
+	dir := t.TempDir()
+	path, err := p.FetchArchivedSnapshot("EXAMPLE", "", 4, dir)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	// The hash would fail for the snapshot from the old session
+	if bytes, err := os.ReadFile(path); err != nil || string(bytes) != body {
+		t.Error("Expected the snapshot of the current session to be downloaded", path, err)
+	}
+	if _, err = p.FetchArchivedSnapshot("EXAMPLE", "", 3, dir); !errors.Is(err, ErrSnapshotNotArchived) {
+		t.Error("Expected ErrSnapshotNotArchived but was", err)
+	}
+	lineage, err := p.SnapshotLineage("EXAMPLE", "")
+	if err != nil || len(lineage) != 2 {
+		t.Error("Expected two snapshots", lineage, err)
+	}
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+// SquashHistory collapses the history of a source before a version into a baseline at that
+// version, to reclaim the space taken by old versions of objects. Queries at the baseline or
+// later give the same results as before, but earlier versions are gone.
+func (p NRTMProcessor) SquashHistory(sourceName, label string, before uint32) (persist.SquashedHistory, error) {
+	if err := p.requirePrimary(); err != nil {
+		return persist.SquashedHistory{}, err
+	}
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return persist.SquashedHistory{}, ErrSourceNotFound
+	}
+	if before == 0 || before > source.Version {
+		return persist.SquashedHistory{}, fmt.Errorf("%w: can't squash before %d, source is at version %d", ErrInvalidVersionRange, before, source.Version)
+	}
+	squashed, err := p.repo.SquashHistory(*source, before)
+	if err != nil {
+		return squashed, err
+	}
+	logger.Info("Squashed history", "source", sourceDisplayName(*source), "baseline", before,
+		"removed", squashed.Removed, "rebased", squashed.Rebased)
+	return squashed, nil
+}
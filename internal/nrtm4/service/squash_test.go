@@ -0,0 +1,49 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+type squashRepo struct {
+	persist.Repository
+	baselines *[]uint32
+}
+
+func (r squashRepo) IsStandby() (bool, error) {
+	return false, nil
+}
+
+func (r squashRepo) GetSources() ([]persist.NRTMSource, error) {
+	return []persist.NRTMSource{{ID: 1, Source: "EXAMPLE", Version: 100}}, nil
+}
+
+func (r squashRepo) SquashHistory(source persist.NRTMSource, baseline uint32) (persist.SquashedHistory, error) {
+	*r.baselines = append(*r.baselines, baseline)
+	return persist.SquashedHistory{Baseline: baseline, Removed: 5, Rebased: 20}, nil
+}
+
+func TestSquashHistory(t *testing.T) {
+	baselines := []uint32{}
+	p := NRTMProcessor{repo: squashRepo{baselines: &baselines}}
+	squashed, err := p.SquashHistory("EXAMPLE", "", 90)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if squashed.Baseline != 90 || squashed.Removed != 5 || squashed.Rebased != 20 {
+		t.Error("Unexpected result", squashed)
+	}
+	for _, before := range []uint32{0, 101} {
+		if _, err = p.SquashHistory("EXAMPLE", "", before); !errors.Is(err, ErrInvalidVersionRange) {
+			t.Error("Expected ErrInvalidVersionRange for", before, "but was", err)
+		}
+	}
+	if _, err = p.SquashHistory("OTHER", "", 90); err != ErrSourceNotFound {
+		t.Error("Expected ErrSourceNotFound but was", err)
+	}
+	if len(baselines) != 1 {
+		t.Error("Expected one squash but was", baselines)
+	}
+}
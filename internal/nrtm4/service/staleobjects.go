@@ -0,0 +1,149 @@
+package service
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+var (
+	// ErrNoObjectTTL no age was given for objects to be stale, and the source has no object_ttl
+	ErrNoObjectTTL = errors.New("no object age given, and the source has no object_ttl")
+	// ErrInvalidObjectTTL the object_ttl can't be parsed, or isn't positive
+	ErrInvalidObjectTTL = errors.New("object_ttl must be a positive duration")
+)
+
+// StaleObjectsReport lists a source's objects which haven't been modified for longer than MaxAge,
+// oldest first, to help find objects which have been abandoned
+type StaleObjectsReport struct {
+	Source  string        `json:"source"`
+	Label   string        `json:"label"`
+	MaxAge  string        `json:"max_age"`
+	Cutoff  time.Time     `json:"cutoff"`
+	Checked int           `json:"checked"`
+	Undated int           `json:"undated"`
+	Stale   []StaleObject `json:"stale"`
+}
+
+// StaleObject is an object which was last modified before the report's cutoff
+type StaleObject struct {
+	ObjectType   string    `json:"object_type"`
+	PrimaryKey   string    `json:"primary_key"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+func validateObjectTTL(ttl string) error {
+	if len(ttl) == 0 {
+		return nil
+	}
+	if d, err := time.ParseDuration(ttl); err != nil || d <= 0 {
+		return fmt.Errorf("%w: %q", ErrInvalidObjectTTL, ttl)
+	}
+	return nil
+}
+
+// StaleObjects finds the source's current objects of the given types, or of every type, which
+// were last modified longer than maxAge ago, or than the source's object_ttl when maxAge is 0.
+// When an object was last modified comes from its last-modified attribute, or failing that the
+// latest date in its changed attributes. Objects with neither are counted as undated.
+func (p NRTMProcessor) StaleObjects(sourceName, label string, maxAge time.Duration, objectTypes []string) (StaleObjectsReport, error) {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return StaleObjectsReport{}, ErrSourceNotFound
+	}
+	if maxAge <= 0 {
+		ttl := p.config.sourceConfig(source.Source).ObjectTTL
+		if len(ttl) == 0 {
+			return StaleObjectsReport{}, ErrNoObjectTTL
+		}
+		// The config has been validated
+		maxAge, _ = time.ParseDuration(ttl)
+	}
+	types := []string{}
+	for _, t := range objectTypes {
+		if t = strings.ToUpper(strings.TrimSpace(t)); len(t) > 0 {
+			types = append(types, t)
+		}
+	}
+	report := StaleObjectsReport{
+		Source: source.Source,
+		Label:  source.Label,
+		MaxAge: maxAge.String(),
+		Cutoff: util.AppClock.Now().Add(-maxAge),
+		Stale:  []StaleObject{},
+	}
+	err := p.repo.GetCurrentObjects(*source, 0, types, func(obj rpsl.Rpsl) error {
+		report.Checked++
+		modified, ok := lastModified(obj.Payload)
+		if !ok {
+			report.Undated++
+			return nil
+		}
+		if modified.Before(report.Cutoff) {
+			report.Stale = append(report.Stale, StaleObject{ObjectType: obj.ObjectType, PrimaryKey: obj.PrimaryKey, LastModified: modified})
+		}
+		return nil
+	})
+	if err != nil {
+		return StaleObjectsReport{}, err
+	}
+	slices.SortStableFunc(report.Stale, func(a, b StaleObject) int {
+		return a.LastModified.Compare(b.LastModified)
+	})
+	return report, nil
+}
+
+// lastModified is when an object was last modified, from its last-modified attribute, or from the
+// latest date in its changed attributes, which are "email [YYYYMMDD]" in older registries
+func lastModified(payload string) (time.Time, bool) {
+	var changed time.Time
+	for _, attr := range rpsl.Attributes(payload) {
+		switch attr.Name {
+		case "last-modified":
+			if t, err := time.Parse(time.RFC3339, attr.Value); err == nil {
+				return t.UTC(), true
+			}
+		case "changed":
+			fields := strings.Fields(attr.Value)
+			if len(fields) < 2 {
+				continue
+			}
+			if t, err := time.Parse("20060102", fields[len(fields)-1]); err == nil && t.After(changed) {
+				changed = t
+			}
+		}
+	}
+	return changed, !changed.IsZero()
+}
+
+// WriteStaleObjects writes a stale objects report as CSV, one row per object, or JSON
+func WriteStaleObjects(w io.Writer, report StaleObjectsReport, format string) error {
+	switch format {
+	case JSONFormat:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case CSVFormat:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"object_type", "primary_key", "last_modified"}); err != nil {
+			return err
+		}
+		for _, obj := range report.Stale {
+			if err := cw.Write([]string{obj.ObjectType, obj.PrimaryKey, obj.LastModified.Format(time.RFC3339)}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return ErrExportFormatNotSupported
+}
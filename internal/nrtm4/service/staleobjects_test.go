@@ -0,0 +1,84 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+func TestLastModified(t *testing.T) {
+	for payload, expected := range map[string]string{
+		"mntner: A-MNT\nlast-modified: 2019-03-04T10:11:12Z\nchanged: a@example.net 20240101\n": "2019-03-04T10:11:12Z",
+		"mntner: A-MNT\nchanged: a@example.net 20050101\nchanged: b@example.net 20070809\n":     "2007-08-09T00:00:00Z",
+		"mntner: A-MNT\nlast-modified: yesterday\nchanged: a@example.net 20050101\n":            "2005-01-01T00:00:00Z",
+		"mntner: A-MNT\nchanged: a@example.net\n":                                               "",
+		"mntner: A-MNT\nsource: EXAMPLE\n":                                                      "",
+	} {
+		modified, ok := lastModified(payload)
+		if len(expected) == 0 {
+			if ok {
+				t.Error("Expected no date for", payload, "but was", modified)
+			}
+			continue
+		}
+		if !ok || modified.Format(time.RFC3339) != expected {
+			t.Error("Expected", expected, "for", payload, "but was", modified, ok)
+		}
+	}
+}
+
+func TestStaleObjects(t *testing.T) {
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	util.AppClock = util.NewManualClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	repo := newCurrentObjectsRepo()
+	repo.objects = []rpsl.Rpsl{
+		{ObjectType: "MNTNER", PrimaryKey: "NEW-MNT", Payload: "mntner: NEW-MNT\nlast-modified: 2025-06-01T00:00:00Z\n"},
+		{ObjectType: "MNTNER", PrimaryKey: "OLD-MNT", Payload: "mntner: OLD-MNT\nlast-modified: 2020-06-01T00:00:00Z\n"},
+		{ObjectType: "ROUTE", PrimaryKey: "192.0.2.0/24AS64496", Payload: "route: 192.0.2.0/24\nchanged: a@example.net 20010101\n"},
+		{ObjectType: "PERSON", PrimaryKey: "EX1-EXAMPLE", Payload: "person: Example\nnic-hdl: EX1-EXAMPLE\n"},
+	}
+	p := NRTMProcessor{repo: repo}
+	if _, err := p.StaleObjects("EXAMPLE", "", 0, nil); !errors.Is(err, ErrNoObjectTTL) {
+		t.Error("Expected ErrNoObjectTTL but was", err)
+	}
+	p.config.Sources = map[string]SourceConfig{"example": {ObjectTTL: "17520h"}}
+	report, err := p.StaleObjects("EXAMPLE", "", 0, nil)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if report.Checked != 4 || report.Undated != 1 || len(report.Stale) != 2 {
+		t.Fatal("Unexpected report", report)
+	}
+	if report.Stale[0].PrimaryKey != "192.0.2.0/24AS64496" || report.Stale[1].PrimaryKey != "OLD-MNT" {
+		t.Error("Expected the stale objects oldest first but was", report.Stale)
+	}
+	if report, err = p.StaleObjects("EXAMPLE", "", 24*time.Hour, []string{"mntner"}); err != nil || len(report.Stale) != 2 || *repo.types == nil || (*repo.types)[0] != "MNTNER" {
+		t.Error("Expected both maintainers to be stale after a day but was", report.Stale, err)
+	}
+
+	var b strings.Builder
+	if err = WriteStaleObjects(&b, report, CSVFormat); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	expected := "object_type,primary_key,last_modified\nMNTNER,OLD-MNT,2020-06-01T00:00:00Z\nMNTNER,NEW-MNT,2025-06-01T00:00:00Z\n"
+	if b.String() != expected {
+		t.Errorf("Expected\n%v\nbut was\n%v", expected, b.String())
+	}
+}
+
+func TestValidateObjectTTL(t *testing.T) {
+	for _, ttl := range []string{"", "720h"} {
+		if err := validateObjectTTL(ttl); err != nil {
+			t.Error("Expected", ttl, "to be valid but was", err)
+		}
+	}
+	for _, ttl := range []string{"2y", "-1h", "0s"} {
+		if err := validateObjectTTL(ttl); !errors.Is(err, ErrInvalidObjectTTL) {
+			t.Error("Expected ErrInvalidObjectTTL for", ttl, "but was", err)
+		}
+	}
+}
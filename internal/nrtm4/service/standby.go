@@ -0,0 +1,39 @@
+package service
+
+import "errors"
+
+var (
+	// ErrStandby the database is a replica of another instance's, so it can't be written to
+	ErrStandby = errors.New("this instance is a standby. run promote to make it the primary")
+	// ErrNotStandby promote was run on an instance which is already the primary
+	ErrNotStandby = errors.New("this instance is not a standby")
+)
+
+// requirePrimary stops a standby from fetching from the upstream server or changing the repo.
+// A standby follows the primary by PostgreSQL replication of its database.
+func (p NRTMProcessor) requirePrimary() error {
+	standby, err := p.repo.IsStandby()
+	if err != nil {
+		return err
+	}
+	if standby {
+		return ErrStandby
+	}
+	return nil
+}
+
+// Promote makes a standby instance the primary, so it can update from the upstream server
+func (p NRTMProcessor) Promote() error {
+	standby, err := p.repo.IsStandby()
+	if err != nil {
+		return err
+	}
+	if !standby {
+		return ErrNotStandby
+	}
+	if err = p.repo.Promote(); err != nil {
+		return err
+	}
+	logger.Info("Promoted to primary")
+	return nil
+}
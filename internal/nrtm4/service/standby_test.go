@@ -0,0 +1,44 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+type standbyRepo struct {
+	persist.Repository
+	standby  bool
+	promoted *bool
+}
+
+func (r standbyRepo) IsStandby() (bool, error) {
+	return r.standby, nil
+}
+
+func (r standbyRepo) Promote() error {
+	*r.promoted = true
+	return nil
+}
+
+func TestStandbyRefusesUpdates(t *testing.T) {
+	promoted := false
+	p := NRTMProcessor{repo: standbyRepo{standby: true, promoted: &promoted}}
+	if _, err := p.Update("EXAMPLE", "", CatchUpAuto); err != ErrStandby {
+		t.Error("Expected ErrStandby from Update but was", err)
+	}
+	if _, err := p.Connect("https://example.com/notification.json", ""); err != ErrStandby {
+		t.Error("Expected ErrStandby from Connect but was", err)
+	}
+	if err := p.Promote(); err != nil || !promoted {
+		t.Error("Expected standby to be promoted", err)
+	}
+}
+
+func TestPromotePrimary(t *testing.T) {
+	promoted := false
+	p := NRTMProcessor{repo: standbyRepo{standby: false, promoted: &promoted}}
+	if err := p.Promote(); err != ErrNotStandby || promoted {
+		t.Error("Expected ErrNotStandby but was", err)
+	}
+}
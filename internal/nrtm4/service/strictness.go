@@ -0,0 +1,59 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidStrictness the strictness isn't one of the profiles
+var ErrInvalidStrictness = errors.New("strictness must be strict, standard or lenient")
+
+// Strictness is how closely a source's server is held to the minor rules of the protocol. Some
+// experimental servers break them, but their data is still wanted.
+type Strictness string
+
+const (
+	// StrictnessStandard fails on broken rules, and warns about a notification file which is
+	// stale or badly dated. It's the default.
+	StrictnessStandard Strictness = "standard"
+	// StrictnessStrict also fails when the notification file's timestamp is stale, in the
+	// future or invalid
+	StrictnessStrict Strictness = "strict"
+	// StrictnessLenient warns about minor irregularities instead of failing, as long as the
+	// deltas which are needed can still be applied in order
+	StrictnessLenient Strictness = "lenient"
+)
+
+// ParseStrictness reads a strictness profile. The empty string is StrictnessStandard.
+func ParseStrictness(s string) (Strictness, error) {
+	switch strictness := Strictness(strings.ToLower(strings.TrimSpace(s))); strictness {
+	case "":
+		return StrictnessStandard, nil
+	case StrictnessStandard, StrictnessStrict, StrictnessLenient:
+		return strictness, nil
+	}
+	return StrictnessStandard, fmt.Errorf("%w: %v", ErrInvalidStrictness, s)
+}
+
+func (s Strictness) validate() error {
+	_, err := ParseStrictness(string(s))
+	return err
+}
+
+// strictness is the profile in a source's config
+func (c AppConfig) strictness(sourceName string) Strictness {
+	strictness, _ := ParseStrictness(string(c.sourceConfig(sourceName).Strictness))
+	return strictness
+}
+
+// tolerate is given a minor irregularity. It's returned unless the profile is lenient, when
+// it's added to warnings instead.
+func (s Strictness) tolerate(err error, warnings *syncWarnings, version uint32) error {
+	if s != StrictnessLenient {
+		return err
+	}
+	logger.Warn("Tolerating irregularity", "version", version, "error", err)
+	warnings.add(WarningIrregularity, version, "%v", err)
+	return nil
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/testresources"
+)
+
+func TestParseStrictness(t *testing.T) {
+	for s, expected := range map[string]Strictness{
+		"":         StrictnessStandard,
+		"standard": StrictnessStandard,
+		"Strict":   StrictnessStrict,
+		" lenient": StrictnessLenient,
+	} {
+		strictness, err := ParseStrictness(s)
+		if err != nil || strictness != expected {
+			t.Errorf("%q should be %v but was %v, %v", s, expected, strictness, err)
+		}
+	}
+	if _, err := ParseStrictness("relaxed"); !errors.Is(err, ErrInvalidStrictness) {
+		t.Error("Expected ErrInvalidStrictness but was", err)
+	}
+}
+
+func TestLenientNotificationWarnsAboutIrregularities(t *testing.T) {
+	var notification protocol.NotificationJSON
+	testresources.ReadTestJSONToPtr(t, "ripe-notification-file.json", &notification)
+	notification.SessionID = "exp-1"
+	refs := notification.DeltaRefs
+	// A repeated delta and a missing one, both before the source's version
+	notification.DeltaRefs = append(append([]protocol.FileRefJSON{refs[0]}, refs[:5]...), refs[6:]...)
+
+	if err := validateNotificationFile(notification, StrictnessStandard, nil); err == nil {
+		t.Error("Expected a short session ID to fail with the standard strictness")
+	}
+	warnings := &syncWarnings{}
+	if err := validateNotificationFile(notification, StrictnessLenient, warnings); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(warnings.all()) != 3 {
+		t.Fatal("Expected three warnings but got", warnings.all())
+	}
+	for _, w := range warnings.all() {
+		if w.Kind != WarningIrregularity {
+			t.Error("Expected an irregularity but was", w)
+		}
+	}
+
+	source := stubsource()
+	deltaRefs, err := findUpdates(notification, source)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(deltaRefs) != int(notification.Version-source.Version) {
+		t.Error("Unexpected number of deltas", len(deltaRefs))
+	}
+	source.Version = refs[3].Version
+	if _, err = findUpdates(notification, source); !errors.Is(err, ErrNRTM4NotificationDeltaSequenceBroken) {
+		t.Error("Expected the missing delta to be needed but was", err)
+	}
+}
+
+func TestLenientDeltaHeaderSourceCase(t *testing.T) {
+	source := stubsource()
+	deltaRef := protocol.FileRefJSON{Version: source.Version + 1}
+	header := protocol.NrtmFileJSON{NrtmVersion: 4, SessionID: source.SessionID, Source: "test_src", Version: deltaRef.Version}
+
+	if err := validateDeltaHeader(header, source, deltaRef, StrictnessStandard, nil); !errors.Is(err, ErrNRTM4SourceNameMismatch) {
+		t.Error("Expected ErrNRTM4SourceNameMismatch but was", err)
+	}
+	warnings := &syncWarnings{}
+	if err := validateDeltaHeader(header, source, deltaRef, StrictnessLenient, warnings); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	if len(warnings.all()) != 1 {
+		t.Error("Expected a warning but got", warnings.all())
+	}
+	header.Source = "OTHER"
+	if err := validateDeltaHeader(header, source, deltaRef, StrictnessLenient, warnings); !errors.Is(err, ErrNRTM4SourceNameMismatch) {
+		t.Error("Expected ErrNRTM4SourceNameMismatch but was", err)
+	}
+}
+
+func TestStrictNotificationTimestamp(t *testing.T) {
+	var notification protocol.NotificationJSON
+	testresources.ReadTestJSONToPtr(t, "ripe-notification-file.json", &notification)
+
+	p := NRTMProcessor{warnings: &syncWarnings{}}
+	if err := p.checkNotificationTimestamp(notification.Source, notification, nil); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	if len(p.warnings.all()) != 1 {
+		t.Error("Expected a stale notification warning but got", p.warnings.all())
+	}
+
+	p.config.Sources = map[string]SourceConfig{"ripe": {Strictness: StrictnessStrict}}
+	if err := p.checkNotificationTimestamp(notification.Source, notification, nil); !errors.Is(err, ErrNRTM4StaleNotification) {
+		t.Error("Expected ErrNRTM4StaleNotification but was", err)
+	}
+}
+
+func TestStrictnessIsTheStoredSources(t *testing.T) {
+	var notification protocol.NotificationJSON
+	testresources.ReadTestJSONToPtr(t, "ripe-notification-file.json", &notification)
+	notification.SessionID = "exp-1"
+	// The server names a source which is configured to be lenient
+	notification.Source = "LAX"
+	config := AppConfig{Sources: map[string]SourceConfig{"lax": {Strictness: StrictnessLenient}}}
+	fm := fileManager{warnings: &syncWarnings{}, strictness: config.strictness, source: "RIPE"}
+	if err := fm.validateNotificationFile(notification); err == nil {
+		t.Error("Expected the stored source's strictness to be used")
+	}
+	fm.source = ""
+	if err := fm.validateNotificationFile(notification); err != nil {
+		t.Error("Expected a new source to be checked with its own strictness but was", err)
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"net/http"
 	"strings"
 	"testing"
 
 	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
 	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
 )
 
@@ -27,7 +29,7 @@ func (r stubRepo) GetSources() []persist.NRTMSource {
 	return []persist.NRTMSource{}
 }
 
-func (r *stubRepo) SaveSource(src persist.NRTMSource, notification persist.NotificationJSON) (persist.NRTMSource, error) {
+func (r *stubRepo) SaveSource(src persist.NRTMSource, notification protocol.NotificationJSON) (persist.NRTMSource, error) {
 	return persist.NRTMSource{}, nil
 }
 
@@ -49,11 +51,15 @@ func (r *stubRepo) SaveFile(nrtmFile *persist.NRTMFile) error {
 	return nil
 }
 
-func (r *stubRepo) AddModifyObject(src persist.NRTMSource, rpsl rpsl.Rpsl, file persist.NrtmFileJSON) error {
+func (r *stubRepo) SetAppliedVersion(src persist.NRTMSource, version uint32) error {
 	return nil
 }
 
-func (r *stubRepo) DeleteObject(src persist.NRTMSource, objectType string, primaryKey string, file persist.NrtmFileJSON) error {
+func (r *stubRepo) AddModifyObject(src persist.NRTMSource, rpsl rpsl.Rpsl, file protocol.NrtmFileJSON) error {
+	return nil
+}
+
+func (r *stubRepo) DeleteObject(src persist.NRTMSource, objectType string, primaryKey string, file protocol.NrtmFileJSON) error {
 	return nil
 }
 
@@ -65,14 +71,14 @@ func NewStubClient(t *testing.T) Client {
 	return stubClient{t}
 }
 
-func (c stubClient) getUpdateNotification(url string) (persist.NotificationJSON, error) {
-	var file persist.NotificationJSON
+func (c stubClient) getUpdateNotification(url string) (protocol.NotificationJSON, http.Header, error) {
+	var file protocol.NotificationJSON
 	if url == stubNotificationURL {
 		json.Unmarshal([]byte(notificationExample), &file)
-		return file, nil
+		return file, nil, nil
 	}
 	c.t.Error("unexpected notification url")
-	return file, errors.New("unexpected notification url")
+	return file, nil, errors.New("unexpected notification url")
 }
 
 func (c stubClient) getResponseBody(url string) (io.Reader, error) {
@@ -93,6 +99,11 @@ func (c stubClient) getResponseBody(url string) (io.Reader, error) {
 	return reader, nil
 }
 
+func (c stubClient) getFile(url string, _ fileRequest) (fileResponse, error) {
+	reader, err := c.getResponseBody(url)
+	return fileResponse{Body: reader}, err
+}
+
 var notificationExample = `
 {
 	"nrtm_version": 4,
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// telemetryTimeout is how long an update waits for the telemetry endpoint
+var telemetryTimeout = 5 * time.Second
+
+// Failure categories in telemetry reports
+const (
+	FailureProtocol = "protocol"
+	FailureOther    = "other"
+)
+
+// TelemetryConfig opts in to sending a report to URL after each update. Nothing is sent unless
+// URL is set.
+type TelemetryConfig struct {
+	URL string `json:"url"`
+}
+
+// TelemetryReport is sent after each update. It doesn't include anything which identifies the
+// mirror: no URLs, labels, host names or error messages. Source is the name the registry
+// publishes its data under, e.g. RIPE.
+type TelemetryReport struct {
+	ClientVersion string `json:"client_version"`
+	SpecRevision  string `json:"spec_revision"`
+	Platform      string `json:"platform"`
+	Backend       string `json:"backend"`
+	Source        string `json:"source"`
+	Success       bool   `json:"success"`
+	// Failure is protocol, when the server published something the client can't use, or other
+	Failure string `json:"failure,omitempty"`
+	// Reason is the kind of protocol error, e.g. "hash mismatch", without any details
+	Reason     string `json:"reason,omitempty"`
+	Versions   uint32 `json:"versions"`
+	Warnings   int    `json:"warnings"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// newTelemetryReport describes the outcome of an update
+func newTelemetryReport(result SyncResult, updateErr error, duration time.Duration) TelemetryReport {
+	info := util.GetBuildInfo()
+	report := TelemetryReport{
+		ClientVersion: info.Version,
+		SpecRevision:  info.SpecRevision,
+		Platform:      info.Platform,
+		Backend:       info.Backends[0],
+		Source:        result.Source,
+		Success:       updateErr == nil,
+		Versions:      result.ToVersion - result.FromVersion,
+		Warnings:      len(result.Warnings),
+		DurationMs:    duration.Milliseconds(),
+	}
+	if updateErr == nil {
+		return report
+	}
+	report.Failure = FailureOther
+	if isProtocolError(updateErr) {
+		report.Failure = FailureProtocol
+		for _, pe := range protocolErrors {
+			if errors.Is(updateErr, pe) {
+				report.Reason = pe.Error()
+				break
+			}
+		}
+	}
+	return report
+}
+
+// sendTelemetry posts a report of an update to the telemetry endpoint, if one is configured.
+// A failure to send is only logged, so it never affects the update.
+func (p NRTMProcessor) sendTelemetry(result SyncResult, updateErr error, duration time.Duration) {
+	url := p.config.Telemetry.URL
+	if len(url) == 0 {
+		return
+	}
+	if err := postTelemetry(p.config.Network, url, newTelemetryReport(result, updateErr, duration)); err != nil {
+		logger.Warn("Failed to send telemetry", "url", url, "error", err)
+	}
+}
+
+func postTelemetry(network NetworkConfig, url string, report TelemetryReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", util.UserAgent())
+	client := *network.httpClient()
+	client.Timeout = telemetryTimeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("telemetry endpoint returned %v", resp.Status)
+	}
+	return nil
+}
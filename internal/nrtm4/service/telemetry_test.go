@@ -0,0 +1,49 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewTelemetryReport(t *testing.T) {
+	result := SyncResult{Source: "RIPE", Label: "secret", FromVersion: 10, ToVersion: 14, Warnings: []Warning{{Kind: WarningRetry}}}
+	report := newTelemetryReport(result, nil, 1500*time.Millisecond)
+	if !report.Success || report.Versions != 4 || report.Warnings != 1 || report.DurationMs != 1500 || report.Source != "RIPE" {
+		t.Error("Unexpected report", report)
+	}
+	err := fmt.Errorf("%w: https://mirror.example.net/delta.json", ErrHashMismatch)
+	report = newTelemetryReport(SyncResult{Source: "RIPE"}, err, time.Second)
+	if report.Success || report.Failure != FailureProtocol || report.Reason != ErrHashMismatch.Error() {
+		t.Error("Expected a protocol failure without details but was", report)
+	}
+	report = newTelemetryReport(SyncResult{Source: "RIPE"}, fmt.Errorf("dial tcp 192.0.2.1:443: connection refused"), time.Second)
+	if report.Failure != FailureOther || len(report.Reason) > 0 {
+		t.Error("Expected another failure without a reason but was", report)
+	}
+}
+
+func TestSendTelemetry(t *testing.T) {
+	reports := []TelemetryReport{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report TelemetryReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Error("Cannot decode report", err)
+		}
+		reports = append(reports, report)
+	}))
+	defer server.Close()
+	p := NRTMProcessor{}
+	p.sendTelemetry(SyncResult{Source: "RIPE"}, nil, time.Second)
+	if len(reports) != 0 {
+		t.Error("Expected nothing to be sent without opting in")
+	}
+	p.config.Telemetry.URL = server.URL
+	p.sendTelemetry(SyncResult{Source: "RIPE", FromVersion: 1, ToVersion: 2}, nil, time.Second)
+	if len(reports) != 1 || !reports[0].Success || reports[0].Versions != 1 || reports[0].Backend != "postgresql" {
+		t.Error("Unexpected reports", reports)
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+)
+
+// sourceTermsURL is the terms URL configured for a source, or else the one the server links to
+// from the notification file with a `Link: <url>; rel="license"` header
+func (p NRTMProcessor) sourceTermsURL(sourceName, notificationURL string, header http.Header) string {
+	if terms := p.config.sourceConfig(sourceName).TermsURL; len(terms) > 0 {
+		return terms
+	}
+	return licenseLink(notificationURL, header)
+}
+
+// saveTermsURL saves the source's terms URL if the notification file changed it, so it's kept up to
+// date whether or not there's anything new to apply
+func (p NRTMProcessor) saveTermsURL(source persist.NRTMSource, header http.Header) persist.NRTMSource {
+	terms := p.sourceTermsURL(source.Source, source.NotificationURL, header)
+	if terms == source.TermsURL {
+		return source
+	}
+	if err := p.repo.SaveTermsURL(source, terms); err != nil {
+		logger.Warn("Failed to save terms URL", "source", source.Source, "error", err)
+		p.warnings.add(WarningBookkeeping, 0, "the terms URL wasn't saved: %v", err)
+		return source
+	}
+	source.TermsURL = terms
+	return source
+}
+
+// licenseLink finds the target of a Link header with the "license" relation (RFC 8288),
+// resolved against the URL it was fetched from
+func licenseLink(baseURL string, header http.Header) string {
+	for _, value := range header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			target, params, found := strings.Cut(strings.TrimSpace(link), ";")
+			if !found || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			if !hasLicenseRel(params) {
+				continue
+			}
+			ref, err := url.Parse(strings.Trim(target, "<>"))
+			if err != nil {
+				continue
+			}
+			base, err := url.Parse(baseURL)
+			if err != nil {
+				return ref.String()
+			}
+			return base.ResolveReference(ref).String()
+		}
+	}
+	return ""
+}
+
+func hasLicenseRel(params string) bool {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if !strings.EqualFold(name, "rel") {
+			continue
+		}
+		for _, rel := range strings.Fields(strings.Trim(value, `"`)) {
+			if strings.EqualFold(rel, "license") {
+				return true
+			}
+		}
+	}
+	return false
+}
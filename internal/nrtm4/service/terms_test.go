@@ -0,0 +1,66 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/mem"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+func TestLicenseLink(t *testing.T) {
+	tests := []struct {
+		links    []string
+		expected string
+	}{
+		{nil, ""},
+		{[]string{`<https://example.com/terms>; rel="license"`}, "https://example.com/terms"},
+		{[]string{`</terms.html>; rel="license"`}, "https://nrtm.example.com/terms.html"},
+		{[]string{`<https://example.com/next>; rel="next", <https://example.com/tc>; rel="copyright license"`}, "https://example.com/tc"},
+		{[]string{`<https://example.com/next>; rel="next"`, `<https://example.com/tc>;rel=license`}, "https://example.com/tc"},
+		{[]string{`https://example.com/tc; rel="license"`}, ""},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		for _, l := range test.links {
+			header.Add("Link", l)
+		}
+		if got := licenseLink("https://nrtm.example.com/nrtmv4/notification.json", header); got != test.expected {
+			t.Error("Expected", test.expected, "but was", got, "for", test.links)
+		}
+	}
+}
+
+func TestSourceTermsURLPrefersConfig(t *testing.T) {
+	header := http.Header{"Link": {`<https://example.com/terms>; rel="license"`}}
+	p := NRTMProcessor{config: AppConfig{Sources: map[string]SourceConfig{
+		"ripe": {TermsURL: "https://example.com/our-agreement"},
+	}}}
+	if got := p.sourceTermsURL("RIPE", "https://example.com/notification.json", header); got != "https://example.com/our-agreement" {
+		t.Error("Expected configured terms but was", got)
+	}
+	if got := p.sourceTermsURL("ARIN", "https://example.com/notification.json", header); got != "https://example.com/terms" {
+		t.Error("Expected linked terms but was", got)
+	}
+}
+
+func TestSaveTermsURL(t *testing.T) {
+	repo := mem.NewRepository()
+	source, err := repo.SaveSource(persist.NRTMSource{Source: "RIPE", TermsURL: "https://example.com/old"}, protocol.NotificationJSON{})
+	if err != nil {
+		t.Fatal("Failed to save source", err)
+	}
+	p := NRTMProcessor{repo: repo, warnings: &syncWarnings{}}
+	header := http.Header{"Link": {`<https://example.com/new>; rel="license"`}}
+	if source = p.saveTermsURL(source, header); source.TermsURL != "https://example.com/new" {
+		t.Error("Expected the new terms URL but was", source.TermsURL)
+	}
+	sources, err := repo.GetSources()
+	if err != nil {
+		t.Fatal("Failed to get sources", err)
+	}
+	if sources[0].TermsURL != "https://example.com/new" {
+		t.Error("Expected the new terms URL to be saved but was", sources[0].TermsURL)
+	}
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+var (
+	// ErrSchedulerStopped the scheduler isn't running, so it can't start an update
+	ErrSchedulerStopped = errors.New("the scheduler isn't running")
+	// ErrJobRemoved the job was removed before its triggered update ran
+	ErrJobRemoved = errors.New("the job was removed")
+)
+
+// maxTriggeredSyncs is how many triggered updates Scheduler.Triggered remembers
+const maxTriggeredSyncs = 100
+
+// The states of a TriggeredSync
+const (
+	TriggerQueued  = "queued"
+	TriggerRunning = "running"
+	TriggerDone    = "done"
+	TriggerFailed  = "failed"
+)
+
+// TriggeredSync is an update which was asked for with Scheduler.Trigger rather than run on its
+// job's schedule. Result is set once it's done, and Error if it failed.
+type TriggeredSync struct {
+	ID        string      `json:"id"`
+	Source    string      `json:"source"`
+	Label     string      `json:"label"`
+	State     string      `json:"state"`
+	Requested time.Time   `json:"requested"`
+	Started   *time.Time  `json:"started,omitempty"`
+	Finished  *time.Time  `json:"finished,omitempty"`
+	Result    *SyncResult `json:"result,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// begin and finish are called with the scheduler's lock held
+func (t *TriggeredSync) begin() {
+	now := util.AppClock.Now()
+	t.State, t.Started = TriggerRunning, &now
+}
+
+func (t *TriggeredSync) finish(result SyncResult, err error) {
+	now := util.AppClock.Now()
+	t.Finished = &now
+	if err != nil {
+		t.State, t.Error = TriggerFailed, err.Error()
+		return
+	}
+	t.State, t.Result = TriggerDone, &result
+}
+
+// sourceExists is true if the source has been connected
+func (p NRTMProcessor) sourceExists(sourceName, label string) bool {
+	ds := NrtmDataService{Repository: p.repo}
+	return ds.getSourceByNameAndLabel(sourceName, label) != nil
+}
+
+// Trigger updates a source as soon as there's room, without waiting for its job to be due, and
+// returns the update's ID for Triggered. A source the scheduler has no job for is updated once,
+// with the default catch-up mode. If an update of the source has been triggered and is still
+// queued, that one is returned rather than queueing another.
+func (s *Scheduler) Trigger(sourceName, label string) (TriggeredSync, error) {
+	if !s.sourceExists(sourceName, label) {
+		return TriggeredSync{}, ErrSourceNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx == nil {
+		return TriggeredSync{}, ErrSchedulerStopped
+	}
+	key := jobKey(sourceName, label)
+	job, ok := s.jobs[key]
+	if !ok {
+		job = newScheduledJob(sourceName, label, SchedulePolicy{})
+		job.once = true
+		s.jobs[key] = job
+		s.start(job)
+	}
+	if job.trigger == nil {
+		job.trigger = &TriggeredSync{
+			ID:        fmt.Sprintf("%016x", newRunID()),
+			Source:    job.Source,
+			Label:     job.Label,
+			State:     TriggerQueued,
+			Requested: util.AppClock.Now(),
+		}
+		s.triggered[job.trigger.ID] = job.trigger
+		s.triggerOrder = append(s.triggerOrder, job.trigger.ID)
+		if len(s.triggerOrder) > maxTriggeredSyncs {
+			delete(s.triggered, s.triggerOrder[0])
+			s.triggerOrder = s.triggerOrder[1:]
+		}
+		logger.Info("Update triggered", "source", job.Source, "label", job.Label, "id", job.trigger.ID)
+	}
+	select {
+	case job.wake <- struct{}{}:
+	default:
+	}
+	return *job.trigger, nil
+}
+
+// Triggered returns the state of a triggered update. Only the latest updates are remembered.
+func (s *Scheduler) Triggered(id string) (TriggeredSync, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.triggered[id]
+	if !ok {
+		return TriggeredSync{}, false
+	}
+	return *t, true
+}
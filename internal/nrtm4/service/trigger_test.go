@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+func TestTrigger(t *testing.T) {
+	defer func(clock util.Clock) { util.AppClock = clock }(util.AppClock)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := util.NewManualClock(start)
+	util.AppClock = clock
+
+	runs := make(chan ScheduledRun)
+	s := NRTMProcessor{}.NewScheduler(1, func(job ScheduledJob, run ScheduledRun) { runs <- run })
+	updating := make(chan string)
+	release := make(chan error)
+	s.update = func(source, label string, _ CatchUpMode) (SyncResult, error) {
+		updating <- source
+		return SyncResult{Source: source, Label: label, ToVersion: 2}, <-release
+	}
+	s.quarantineUntil = func(string, string) time.Time { return time.Time{} }
+	s.rotationDue = func(string, string) time.Time { return time.Time{} }
+	s.sourceExists = func(source, _ string) bool { return source != "OTHER" }
+
+	if _, err := s.Trigger("RIPE", ""); !errors.Is(err, ErrSchedulerStopped) {
+		t.Error("Expected ErrSchedulerStopped but was", err)
+	}
+	s.AddJob("ARIN", "", SchedulePolicy{Interval: 24 * time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	<-updating
+	release <- nil
+	<-runs
+
+	if _, err := s.Trigger("OTHER", ""); !errors.Is(err, ErrSourceNotFound) {
+		t.Error("Expected ErrSourceNotFound but was", err)
+	}
+
+	// A source without a job is updated once
+	first, err := s.Trigger("RIPE", "prod")
+	if err != nil || first.State != TriggerQueued || len(first.ID) == 0 {
+		t.Fatal("Expected a queued update", first, err)
+	}
+	if again, _ := s.Trigger("ripe", "prod"); again.ID != first.ID {
+		t.Error("Expected a queued update to be returned again but was", again.ID)
+	}
+	<-updating
+	if running, ok := s.Triggered(first.ID); !ok || running.State != TriggerRunning || running.Started == nil {
+		t.Error("Expected the update to be running", running)
+	}
+	second, _ := s.Trigger("RIPE", "prod")
+	if second.ID == first.ID || second.State != TriggerQueued {
+		t.Error("Expected another update to be queued while the first runs", second)
+	}
+	release <- nil
+	<-runs
+	if finished, _ := s.Triggered(first.ID); finished.State != TriggerDone || finished.Result == nil || finished.Result.ToVersion != 2 {
+		t.Error("Expected the update to be done", finished)
+	}
+	<-updating
+	release <- errors.New("connection refused")
+	<-runs
+	if failed, _ := s.Triggered(second.ID); failed.State != TriggerFailed || failed.Error != "connection refused" {
+		t.Error("Expected the second update to have failed", failed)
+	}
+	if jobs := s.Jobs(); len(jobs) != 1 || jobs[0].Source != "ARIN" {
+		t.Error("Expected the one-off job to be removed but was", jobs)
+	}
+
+	// A job's update runs straight away, and it's next due after its interval
+	clock.Advance(time.Hour)
+	triggered, _ := s.Trigger("ARIN", "")
+	if source := <-updating; source != "ARIN" {
+		t.Error("Expected ARIN to be updated but was", source)
+	}
+	release <- nil
+	if run := <-runs; !run.Next.Equal(clock.Now().Add(24 * time.Hour)) {
+		t.Error("Expected the job's interval to start again but was", run.Next)
+	}
+	if finished, _ := s.Triggered(triggered.ID); finished.State != TriggerDone {
+		t.Error("Expected the update to be done", finished)
+	}
+	if _, ok := s.Triggered("unknown"); ok {
+		t.Error("Expected an unknown ID not to be found")
+	}
+}
+
+func TestAdminAPIToken(t *testing.T) {
+	if _, err := (AdminAPIConfig{}).Token(); !errors.Is(err, ErrInvalidAdminAPI) {
+		t.Error("Expected ErrInvalidAdminAPI without a token file but was", err)
+	}
+	path := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(path, []byte(" \n"), 0600)
+	if _, err := (AdminAPIConfig{TokenFile: path}).Token(); !errors.Is(err, ErrInvalidAdminAPI) {
+		t.Error("Expected ErrInvalidAdminAPI for an empty token file but was", err)
+	}
+	os.WriteFile(path, []byte("s3cret\n"), 0600)
+	if token, err := (AdminAPIConfig{TokenFile: path}).Token(); err != nil || token != "s3cret" {
+		t.Error("Expected the token from the file", token, err)
+	}
+	if err := (AdminAPIConfig{Parallel: -1}).validate(); !errors.Is(err, ErrInvalidAdminAPI) {
+		t.Error("Expected ErrInvalidAdminAPI for a negative parallel but was", err)
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// defaultUndeleteWindow is how far back deleted objects can be restored when the config doesn't
+// say otherwise
+const defaultUndeleteWindow = 7 * 24 * time.Hour
+
+// UndeleteObject restores an object which was deleted by a delta within the undelete window. The
+// mirror differs from the server until the object is changed upstream.
+func (p NRTMProcessor) UndeleteObject(sourceName, label, objectType, primaryKey string) (persist.ObjectVersion, error) {
+	if err := p.requirePrimary(); err != nil {
+		return persist.ObjectVersion{}, err
+	}
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return persist.ObjectVersion{}, ErrSourceNotFound
+	}
+	window := p.config.UndeleteWindow
+	if window <= 0 {
+		window = defaultUndeleteWindow
+	}
+	since := util.AppClock.Now().Add(-window)
+	restored, err := p.repo.UndeleteObject(*source, strings.TrimSpace(objectType), strings.TrimSpace(primaryKey), since)
+	if err != nil {
+		return restored, err
+	}
+	logger.Info("Undeleted object", "source", sourceDisplayName(*source), "type", restored.ObjectType,
+		"key", restored.PrimaryKey, "version", restored.FromVersion)
+	return restored, nil
+}
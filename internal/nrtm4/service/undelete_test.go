@@ -0,0 +1,58 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+type undeleteRepo struct {
+	persist.Repository
+	standby bool
+	since   time.Time
+}
+
+func (r *undeleteRepo) IsStandby() (bool, error) {
+	return r.standby, nil
+}
+
+func (r *undeleteRepo) GetSources() ([]persist.NRTMSource, error) {
+	return []persist.NRTMSource{{ID: 1, Source: "TEST", Version: 12}}, nil
+}
+
+func (r *undeleteRepo) UndeleteObject(source persist.NRTMSource, objectType, primaryKey string, since time.Time) (persist.ObjectVersion, error) {
+	r.since = since
+	if primaryKey != "AS65000" {
+		return persist.ObjectVersion{}, persist.ErrNoDeletedObject
+	}
+	return persist.ObjectVersion{ObjectType: "AUT-NUM", PrimaryKey: primaryKey, FromVersion: source.Version}, nil
+}
+
+func TestUndeleteObject(t *testing.T) {
+	repo := &undeleteRepo{}
+	p := NRTMProcessor{repo: repo, config: AppConfig{UndeleteWindow: 48 * time.Hour}}
+	before := util.AppClock.Now()
+	restored, err := p.UndeleteObject("TEST", "", "aut-num", " AS65000 ")
+	if err != nil {
+		t.Fatal("UndeleteObject failed", err)
+	}
+	if restored.FromVersion != 12 {
+		t.Error("Expected object to be restored at the current version but was", restored.FromVersion)
+	}
+	if window := before.Sub(repo.since); window < 47*time.Hour || window > 48*time.Hour {
+		t.Error("Expected window of 48h but was", window)
+	}
+	if _, err = p.UndeleteObject("TEST", "", "aut-num", "AS65001"); !errors.Is(err, persist.ErrNoDeletedObject) {
+		t.Error("Expected ErrNoDeletedObject but was", err)
+	}
+	if _, err = p.UndeleteObject("NOPE", "", "aut-num", "AS65000"); err != ErrSourceNotFound {
+		t.Error("Expected ErrSourceNotFound but was", err)
+	}
+	repo.standby = true
+	if _, err = p.UndeleteObject("TEST", "", "aut-num", "AS65000"); err != ErrStandby {
+		t.Error("Expected ErrStandby but was", err)
+	}
+}
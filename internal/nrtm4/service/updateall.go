@@ -0,0 +1,156 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultUpdateParallel is how many sources are updated at once when it isn't given
+const defaultUpdateParallel = 4
+
+// ExitAllFailed every source which was tried failed to update. The other exit codes of
+// update --all are the same as the validate command's.
+const ExitAllFailed = 4
+
+// UpdateStatus is how the update of one source turned out
+type UpdateStatus string
+
+// Update statuses
+const (
+	UpdateSucceeded UpdateStatus = "succeeded"
+	UpdateWarnings  UpdateStatus = "warnings"
+	UpdateFailed    UpdateStatus = "failed"
+	UpdateSkipped   UpdateStatus = "skipped"
+)
+
+// UpdateOutcome is the result of updating one source. Failure is protocol, when the server
+// published something the client can't use, or other, and Error says what it was. Reason says
+// why a source was skipped.
+type UpdateOutcome struct {
+	SyncResult
+	Status  UpdateStatus `json:"status"`
+	Failure string       `json:"failure,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Reason  string       `json:"reason,omitempty"`
+	Err     error        `json:"-"`
+}
+
+// UpdateSummary has an outcome for each source, in the order they were listed, and counts of
+// each status. ExitCode is 0 when every source was updated or skipped, ExitWarnings when some
+// had warnings, ExitErrors when some failed and ExitAllFailed when every one tried failed.
+type UpdateSummary struct {
+	Results   []UpdateOutcome `json:"results"`
+	Succeeded int             `json:"succeeded"`
+	Warned    int             `json:"warned"`
+	Failed    int             `json:"failed"`
+	Skipped   int             `json:"skipped"`
+	ExitCode  int             `json:"exit_code"`
+}
+
+// UpdateAll updates every live source, at most parallel at a time. Sources which are paused or
+// quarantined are skipped, and a source which fails doesn't stop the others.
+func (p NRTMProcessor) UpdateAll(parallel int, catchUp CatchUpMode) (UpdateSummary, error) {
+	ds := NrtmDataService{Repository: p.repo}
+	sources, err := ds.getSources()
+	if err != nil {
+		return UpdateSummary{Results: []UpdateOutcome{}}, err
+	}
+	live := []persist.NRTMSource{}
+	for _, source := range sources {
+		if source.Superseded == nil {
+			live = append(live, source)
+		}
+	}
+	return p.updateSources(live, parallel, catchUp), nil
+}
+
+// updateSources updates each source in its own goroutine. Every goroutine returns nil, since a
+// failure is recorded in its source's outcome rather than cancelling the rest.
+func (p NRTMProcessor) updateSources(sources []persist.NRTMSource, parallel int, catchUp CatchUpMode) UpdateSummary {
+	if parallel <= 0 {
+		parallel = defaultUpdateParallel
+	}
+	results := make([]UpdateOutcome, len(sources))
+	var g errgroup.Group
+	g.SetLimit(parallel)
+	for i, source := range sources {
+		g.Go(func() error {
+			results[i] = p.updateOutcome(source, catchUp)
+			return nil
+		})
+	}
+	g.Wait()
+	return newUpdateSummary(results)
+}
+
+// newUpdateSummary counts the outcomes and sets the exit code from them
+func newUpdateSummary(results []UpdateOutcome) UpdateSummary {
+	summary := UpdateSummary{Results: results}
+	for _, result := range results {
+		switch result.Status {
+		case UpdateSucceeded:
+			summary.Succeeded++
+		case UpdateWarnings:
+			summary.Warned++
+		case UpdateFailed:
+			summary.Failed++
+		case UpdateSkipped:
+			summary.Skipped++
+		}
+	}
+	switch {
+	case summary.Failed > 0 && summary.Succeeded+summary.Warned == 0:
+		summary.ExitCode = ExitAllFailed
+	case summary.Failed > 0:
+		summary.ExitCode = ExitErrors
+	case summary.Warned > 0:
+		summary.ExitCode = ExitWarnings
+	}
+	return summary
+}
+
+func (p NRTMProcessor) updateOutcome(source persist.NRTMSource, catchUp CatchUpMode) UpdateOutcome {
+	outcome := UpdateOutcome{SyncResult: SyncResult{Source: source.Source, Label: source.Label, Warnings: []Warning{}}}
+	if source.Paused {
+		logger.Info("Skipping paused source", "source", source.Source, "label", source.Label)
+		outcome.Status, outcome.Reason = UpdateSkipped, "paused"
+		return outcome
+	}
+	if err := checkQuarantine(source, util.AppClock.Now()); err != nil {
+		logger.Info("Skipping quarantined source", "source", source.Source, "label", source.Label, "reason", err)
+		outcome.Status, outcome.Reason = UpdateSkipped, err.Error()
+		return outcome
+	}
+	logger.Info("Updating", "source", source.Source, "label", source.Label)
+	result, err := p.updateIsolated(source, catchUp)
+	if len(result.Source) > 0 {
+		outcome.SyncResult = result
+	}
+	switch {
+	case err != nil:
+		logger.Warn("Update failed", "source", source.Source, "label", source.Label, "error", err)
+		outcome.Status, outcome.Err, outcome.Error = UpdateFailed, err, err.Error()
+		outcome.Failure = FailureOther
+		if isProtocolError(err) {
+			outcome.Failure = FailureProtocol
+		}
+	case len(result.Warnings) > 0:
+		outcome.Status = UpdateWarnings
+	default:
+		outcome.Status = UpdateSucceeded
+	}
+	return outcome
+}
+
+// updateIsolated turns a panic while updating into an error, so it only fails one source
+func (p NRTMProcessor) updateIsolated(source persist.NRTMSource, catchUp CatchUpMode) (result SyncResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("update panicked: %v", r)
+		}
+	}()
+	return p.Update(source.Source, source.Label, catchUp)
+}
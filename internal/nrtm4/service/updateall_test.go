@@ -0,0 +1,75 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+type updateAllRepo struct {
+	persist.Repository
+}
+
+func (r updateAllRepo) GetSources() ([]persist.NRTMSource, error) {
+	superseded := util.AppClock.Now().Add(-time.Hour)
+	return []persist.NRTMSource{
+		{ID: 1, Source: "RIPE"},
+		{ID: 2, Source: "ARIN", Paused: true},
+		{ID: 3, Source: "APNIC", Quarantine: &persist.Quarantine{Failures: 1, Reason: "bad hash", Until: util.AppClock.Now().Add(time.Hour)}},
+		{ID: 4, Source: "RIPE", Label: "old", Superseded: &superseded},
+	}, nil
+}
+
+// IsStandby makes every update fail, without needing a server
+func (r updateAllRepo) IsStandby() (bool, error) {
+	return true, nil
+}
+
+func TestUpdateAll(t *testing.T) {
+	p := NRTMProcessor{repo: updateAllRepo{}}
+	summary, err := p.UpdateAll(2, CatchUpAuto)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(summary.Results) != 3 {
+		t.Fatal("Expected a result for each live source but was", summary.Results)
+	}
+	ripe := summary.Results[0]
+	if ripe.Source != "RIPE" || ripe.Status != UpdateFailed || ripe.Failure != FailureOther || !errors.Is(ripe.Err, ErrStandby) || len(ripe.Error) == 0 {
+		t.Error("Expected RIPE to fail but was", ripe)
+	}
+	if arin := summary.Results[1]; arin.Status != UpdateSkipped || arin.Reason != "paused" {
+		t.Error("Expected ARIN to be skipped but was", arin)
+	}
+	if apnic := summary.Results[2]; apnic.Status != UpdateSkipped || len(apnic.Reason) == 0 {
+		t.Error("Expected APNIC to be skipped but was", apnic)
+	}
+	if summary.Failed != 1 || summary.Skipped != 2 || summary.ExitCode != ExitAllFailed {
+		t.Error("Unexpected summary", summary)
+	}
+}
+
+func TestUpdateSummaryExitCode(t *testing.T) {
+	tests := []struct {
+		statuses []UpdateStatus
+		expected int
+	}{
+		{[]UpdateStatus{}, ExitConformant},
+		{[]UpdateStatus{UpdateSucceeded, UpdateSkipped}, ExitConformant},
+		{[]UpdateStatus{UpdateSucceeded, UpdateWarnings}, ExitWarnings},
+		{[]UpdateStatus{UpdateWarnings, UpdateFailed}, ExitErrors},
+		{[]UpdateStatus{UpdateFailed, UpdateSkipped}, ExitAllFailed},
+	}
+	for _, tt := range tests {
+		results := []UpdateOutcome{}
+		for _, status := range tt.statuses {
+			results = append(results, UpdateOutcome{Status: status})
+		}
+		if summary := newUpdateSummary(results); summary.ExitCode != tt.expected {
+			t.Error("Expected exit code", tt.expected, "for", tt.statuses, "but was", summary.ExitCode)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/quirks"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+var (
+	// ErrNoUpstream the source has no whois or RDAP server to compare objects with
+	ErrNoUpstream = errors.New("no upstream whois or RDAP server for the source")
+	// ErrInvalidUpstream upstream.whois must be host:port, and upstream.rdap an http(s) URL
+	ErrInvalidUpstream = errors.New("upstream whois must be host:port and rdap an http or https url")
+	// ErrInvalidKey the primary key or object type has control characters, which would be sent
+	// to the whois server as more queries
+	ErrInvalidKey = errors.New("the key and type can't have control characters")
+	// ErrObjectTypeNeeded the mirror has no object with the key, so the registry can't be asked
+	// for it without a type
+	ErrObjectTypeNeeded = errors.New("the mirror has no object with the key, give its type")
+
+	whoisTimeout = 15 * time.Second
+)
+
+// maxWhoisResponse is the most that's read from a whois server for one query
+const maxWhoisResponse = 16 << 20
+
+// defaultWhoisQuery asks a RIPE style whois server for an object without related objects
+const defaultWhoisQuery = "-r -T {type} {key}"
+
+// knownWhoisServers are used for sources without an upstream in the config file
+var knownWhoisServers = map[string]string{
+	"AFRINIC": "whois.afrinic.net:43",
+	"APNIC":   "whois.apnic.net:43",
+	"RIPE":    "whois.ripe.net:43",
+}
+
+// UpstreamConfig is where the registry's live objects can be fetched from, to compare with the
+// mirror's
+type UpstreamConfig struct {
+	// Whois is the host:port of the registry's whois server
+	Whois string `json:"whois"`
+	// Query is sent to the whois server for each object, with {type} and {key} replaced by the
+	// object type and primary key. Default is "-r -T {type} {key}".
+	Query string `json:"query"`
+	// RDAP is the base URL of the registry's RDAP service, used when there's no whois server
+	RDAP string `json:"rdap"`
+}
+
+func (c *UpstreamConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Whois) > 0 {
+		if _, _, err := net.SplitHostPort(c.Whois); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidUpstream, c.Whois)
+		}
+	}
+	if len(c.RDAP) > 0 {
+		if u, err := url.Parse(c.RDAP); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("%w: %v", ErrInvalidUpstream, c.RDAP)
+		}
+	}
+	return nil
+}
+
+func (c AppConfig) upstream(sourceName string) (UpstreamConfig, error) {
+	upstream := UpstreamConfig{}
+	if sc := c.sourceConfig(sourceName).Upstream; sc != nil {
+		upstream = *sc
+	}
+	if upstream.Whois == "" && upstream.RDAP == "" {
+		upstream.Whois = knownWhoisServers[strings.ToUpper(sourceName)]
+		upstream.RDAP = knownRDAPServers[strings.ToUpper(sourceName)]
+	}
+	if upstream.Whois == "" && upstream.RDAP == "" {
+		return upstream, fmt.Errorf("%w: %v", ErrNoUpstream, sourceName)
+	}
+	if upstream.Query == "" {
+		upstream.Query = defaultWhoisQuery
+	}
+	return upstream, nil
+}
+
+// UpstreamStatus says how the mirror's object compares with the registry's
+type UpstreamStatus string
+
+const (
+	// UpstreamSame the attributes compared are the same
+	UpstreamSame UpstreamStatus = "same"
+	// UpstreamDifferent the attributes compared are different
+	UpstreamDifferent UpstreamStatus = "different"
+	// UpstreamMissing the registry doesn't have the mirror's object
+	UpstreamMissing UpstreamStatus = "missing_upstream"
+	// UpstreamMissingLocally the mirror doesn't have the registry's object
+	UpstreamMissingLocally UpstreamStatus = "missing_locally"
+)
+
+// UpstreamComparison compares one of the mirror's objects with the registry's. In Changes,
+// Removed values are only in the mirror and Added values are only in the registry.
+type UpstreamComparison struct {
+	Server     string
+	ObjectType string
+	PrimaryKey string
+	Status     UpstreamStatus
+	Local      string
+	Upstream   string
+	Changes    []rpsl.AttributeChange
+}
+
+// CompareUpstream fetches the objects with a primary key from the source's registry over
+// whois, or RDAP if it has no whois server, and compares their attributes with the mirror's.
+// With an object type only that type is compared, otherwise each of the mirror's objects with
+// the key is. Only the attributes in attrs are compared, or all of them if there are none.
+// Registries hide some attributes, e.g. e-mail addresses, in whois, so those may differ without
+// the mirror being stale. RDAP only has a few of an object's attributes, so only those are
+// compared unless attrs is given.
+func (p NRTMProcessor) CompareUpstream(sourceName, label, objectType, key string, attrs []string) ([]UpstreamComparison, error) {
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return nil, ErrSourceNotFound
+	}
+	upstream, err := p.config.upstream(source.Source)
+	if err != nil {
+		return nil, err
+	}
+	objectType = strings.ToUpper(strings.TrimSpace(objectType))
+	key = strings.ToUpper(strings.TrimSpace(key))
+	if strings.ContainsFunc(objectType+key, unicode.IsControl) {
+		return nil, ErrInvalidKey
+	}
+	local, err := p.repo.LookupObjects(*source, 0, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	types := []string{}
+	if len(objectType) > 0 {
+		types = append(types, objectType)
+	} else {
+		for _, obj := range local {
+			if !slices.Contains(types, obj.ObjectType) {
+				types = append(types, obj.ObjectType)
+			}
+		}
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrObjectTypeNeeded, key)
+	}
+	comparisons := []UpstreamComparison{}
+	for _, t := range types {
+		comparison := UpstreamComparison{Server: upstream.Whois, ObjectType: t, PrimaryKey: key}
+		for _, obj := range local {
+			if obj.ObjectType == t {
+				comparison.Local = obj.Payload
+			}
+		}
+		compared := attrs
+		if len(upstream.Whois) > 0 {
+			query := strings.NewReplacer("{type}", strings.ToLower(t), "{key}", key).Replace(upstream.Query)
+			response, err := p.queryWhois(upstream.Whois, query)
+			if err != nil {
+				return nil, err
+			}
+			for _, obj := range parseWhoisObjects(response, p.config.sourceQuirks(source.Source)) {
+				if obj.ObjectType == t && obj.PrimaryKey == key {
+					comparison.Upstream = obj.Payload
+				}
+			}
+		} else {
+			comparison.Server = upstream.RDAP
+			if comparison.Upstream, err = p.queryRDAP(upstream.RDAP, source.Source, t, key); err != nil {
+				return nil, err
+			}
+			if len(compared) == 0 {
+				for _, attr := range rpsl.Attributes(comparison.Upstream) {
+					compared = append(compared, attr.Name)
+				}
+			}
+		}
+		comparison.Changes = rpsl.DiffAttributes(rpsl.Attributes(comparison.Local), rpsl.Attributes(comparison.Upstream), compared...)
+		switch {
+		case len(comparison.Upstream) == 0:
+			comparison.Status = UpstreamMissing
+		case len(comparison.Local) == 0:
+			comparison.Status = UpstreamMissingLocally
+		case len(comparison.Changes) > 0:
+			comparison.Status = UpstreamDifferent
+		default:
+			comparison.Status = UpstreamSame
+		}
+		comparisons = append(comparisons, comparison)
+	}
+	return comparisons, nil
+}
+
+// queryWhois sends one query to a whois server and returns its whole response. The connection
+// uses the network settings, but not a proxy.
+func (p NRTMProcessor) queryWhois(server, query string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), whoisTimeout)
+	defer cancel()
+	conn, err := p.config.Network.dialContext(ctx, "tcp", server)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(whoisTimeout)); err != nil {
+		return "", err
+	}
+	httpLogger.Debug("Querying whois", "server", server, "query", query)
+	if _, err = io.WriteString(conn, query+"\r\n"); err != nil {
+		return "", err
+	}
+	response, err := io.ReadAll(io.LimitReader(conn, maxWhoisResponse))
+	return string(response), err
+}
+
+// parseWhoisObjects splits a whois response into objects at blank lines, leaving out the
+// server's comments, which start with % or #. Objects are parsed with objectQuirks, and anything
+// which can't be parsed is skipped.
+func parseWhoisObjects(response string, objectQuirks quirks.Set) []rpsl.Rpsl {
+	objects := []rpsl.Rpsl{}
+	var lines []string
+	flush := func() {
+		if len(lines) > 0 {
+			if obj, err := objectQuirks.Parse(strings.Join(lines, "\n") + "\n"); err == nil {
+				objects = append(objects, obj)
+			}
+		}
+		lines = nil
+	}
+	scanner := bufio.NewScanner(strings.NewReader(response))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case len(strings.TrimSpace(line)) == 0:
+			flush()
+		case strings.HasPrefix(line, "%") || strings.HasPrefix(line, "#"):
+			// The server's comments, e.g. its terms and conditions
+		default:
+			lines = append(lines, line)
+		}
+	}
+	flush()
+	return objects
+}
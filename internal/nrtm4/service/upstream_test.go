@@ -0,0 +1,179 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/quirks"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+// fakeWhois answers each query with response, and returns the address it listens on
+func fakeWhois(t *testing.T, response string, queries chan<- string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Cannot listen", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			query, _ := bufio.NewReader(conn).ReadString('\n')
+			queries <- strings.TrimSpace(query)
+			conn.Write([]byte(response))
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestParseWhoisObjects(t *testing.T) {
+	response := "% This is the whois server\n% Terms and conditions apply\n\naut-num:        AS65000\r\nas-name:        EXAMPLE\r\nsource:         TEST\r\n\r\n" +
+		"% Information related to AS-EXAMPLE\n\nas-set:         AS-EXAMPLE\nmembers:        AS65000\nsource:         TEST\n\n%ERROR:101: no entries found\n"
+	objects := parseWhoisObjects(response, quirks.Set{})
+	if len(objects) != 2 {
+		t.Fatal("Expected 2 objects but was", len(objects))
+	}
+	if objects[0].ObjectType != "AUT-NUM" || objects[0].PrimaryKey != "AS65000" || objects[1].PrimaryKey != "AS-EXAMPLE" {
+		t.Error("Unexpected objects", objects)
+	}
+}
+
+func TestCompareUpstream(t *testing.T) {
+	local := "aut-num:        AS65000\nas-name:        EXAMPLE\nremarks:        old\nsource:         TEST\n"
+	repo := &lookupRepo{objects: []rpsl.Rpsl{{ObjectType: "AUT-NUM", PrimaryKey: "AS65000", Payload: local}}}
+	queries := make(chan string, 10)
+	server := fakeWhois(t, "% Comment\n\naut-num:        AS65000\nas-name:        EXAMPLE\nremarks:        new\nsource:         TEST\n\n", queries)
+	p := NRTMProcessor{repo: repo}
+	p.config.Sources = map[string]SourceConfig{"test": {Upstream: &UpstreamConfig{Whois: server}}}
+
+	comparisons, err := p.CompareUpstream("TEST", "", "", "as65000", nil)
+	if err != nil {
+		t.Fatal("CompareUpstream failed", err)
+	}
+	if query := <-queries; query != "-r -T aut-num AS65000" {
+		t.Error("Unexpected query", query)
+	}
+	if len(comparisons) != 1 || comparisons[0].Status != UpstreamDifferent {
+		t.Fatal("Expected the object to be different but was", comparisons)
+	}
+	changes := comparisons[0].Changes
+	if len(changes) != 1 || changes[0].Name != "remarks" || changes[0].Removed[0] != "old" || changes[0].Added[0] != "new" {
+		t.Error("Unexpected changes", changes)
+	}
+
+	comparisons, err = p.CompareUpstream("TEST", "", "", "AS65000", []string{"as-name"})
+	if err != nil || len(comparisons) != 1 || comparisons[0].Status != UpstreamSame {
+		t.Error("Expected the compared attributes to be the same but was", comparisons, err)
+	}
+	<-queries
+
+	comparisons, err = p.CompareUpstream("TEST", "", "as-set", "AS65000", nil)
+	if err != nil || len(comparisons) != 1 || comparisons[0].Status != UpstreamMissing {
+		t.Error("Expected the object to be missing upstream but was", comparisons, err)
+	}
+	<-queries
+
+	if _, err = p.CompareUpstream("TEST", "", "", "AS65001", nil); !errors.Is(err, ErrObjectTypeNeeded) {
+		t.Error("Expected ErrObjectTypeNeeded but was", err)
+	}
+
+	p.config.Sources = nil
+	if _, err = p.CompareUpstream("TEST", "", "", "AS65000", nil); !errors.Is(err, ErrNoUpstream) {
+		t.Error("Expected ErrNoUpstream but was", err)
+	}
+	if err = (&UpstreamConfig{Whois: "whois.example.net"}).validate(); !errors.Is(err, ErrInvalidUpstream) {
+		t.Error("Expected ErrInvalidUpstream but was", err)
+	}
+	if err = (&UpstreamConfig{RDAP: "rdap.example.net"}).validate(); !errors.Is(err, ErrInvalidUpstream) {
+		t.Error("Expected ErrInvalidUpstream for the RDAP URL but was", err)
+	}
+}
+
+func TestCompareUpstreamRejectsControlCharacters(t *testing.T) {
+	repo := &lookupRepo{}
+	queries := make(chan string, 10)
+	p := NRTMProcessor{repo: repo}
+	p.config.Sources = map[string]SourceConfig{"test": {Upstream: &UpstreamConfig{Whois: fakeWhois(t, "", queries)}}}
+
+	for _, key := range []string{"AS65000\r\n-i mnt-by EXAMPLE-MNT", "AS65000\n-k", "AS\x0065000"} {
+		if _, err := p.CompareUpstream("TEST", "", "aut-num", key, nil); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Expected ErrInvalidKey for %q but was %v", key, err)
+		}
+	}
+	if _, err := p.CompareUpstream("TEST", "", "aut-num\r\n-i mnt-by", "AS65000", nil); !errors.Is(err, ErrInvalidKey) {
+		t.Error("Expected ErrInvalidKey for the type but was", err)
+	}
+	if len(queries) > 0 {
+		t.Error("Expected nothing to be sent to the whois server but was", <-queries)
+	}
+}
+
+func TestCompareUpstreamRDAP(t *testing.T) {
+	local := "aut-num:        AS65000\nas-name:        EXAMPLE\nremarks:        not in RDAP\nsource:         TEST\n"
+	repo := &lookupRepo{objects: []rpsl.Rpsl{{ObjectType: "AUT-NUM", PrimaryKey: "AS65000", Payload: local}}}
+	paths := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+		if r.URL.Path != "/rdap/autnum/65000" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.Write([]byte(`{"objectClassName":"autnum","handle":"AS65000","startAutnum":65000,"endAutnum":65000,"name":"EXAMPLE-NEW"}`))
+	}))
+	t.Cleanup(server.Close)
+	p := NRTMProcessor{repo: repo}
+	p.config.Sources = map[string]SourceConfig{"test": {Upstream: &UpstreamConfig{RDAP: server.URL + "/rdap/"}}}
+
+	comparisons, err := p.CompareUpstream("TEST", "", "", "AS65000", nil)
+	if err != nil {
+		t.Fatal("CompareUpstream failed", err)
+	}
+	if path := <-paths; path != "/rdap/autnum/65000" {
+		t.Error("Unexpected RDAP lookup", path)
+	}
+	if len(comparisons) != 1 || comparisons[0].Status != UpstreamDifferent || comparisons[0].Server != server.URL+"/rdap/" {
+		t.Fatal("Expected the object to be different but was", comparisons)
+	}
+	changes := comparisons[0].Changes
+	if len(changes) != 1 || changes[0].Name != "as-name" || changes[0].Added[0] != "EXAMPLE-NEW" {
+		t.Error("Expected only the attributes RDAP has to be compared but was", changes)
+	}
+
+	comparisons, err = p.CompareUpstream("TEST", "", "aut-num", "AS65001", nil)
+	if err != nil || len(comparisons) != 1 || comparisons[0].Status != UpstreamMissing {
+		t.Error("Expected the object to be missing upstream but was", comparisons, err)
+	}
+	<-paths
+	if _, err = p.CompareUpstream("TEST", "", "as-set", "AS-EXAMPLE", nil); !errors.Is(err, ErrNoRDAPType) {
+		t.Error("Expected ErrNoRDAPType but was", err)
+	}
+}
+
+func TestRDAPObjectRPSL(t *testing.T) {
+	obj := rdapObject{Name: "EXAMPLE-NET", Country: "NL", StartAddress: "2001:db8::", EndAddress: "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"}
+	expected := "inet6num:       2001:db8::/32\nnetname:        EXAMPLE-NET\ncountry:        NL\nsource:         TEST\n"
+	if text := obj.rpsl("INET6NUM", "test"); text != expected {
+		t.Errorf("Expected %q but was %q", expected, text)
+	}
+	var person rdapObject
+	if err := json.Unmarshal([]byte(`{"handle":"JD1-TEST","vcardArray":["vcard",[["version",{},"text","4.0"],["fn",{},"text","John Doe"]]]}`), &person); err != nil {
+		t.Fatal(err)
+	}
+	if text := person.rpsl("PERSON", "TEST"); text != "person:         John Doe\nnic-hdl:        JD1-TEST\nsource:         TEST\n" {
+		t.Error("Unexpected person", text)
+	}
+	if text := (rdapObject{}).rpsl("AUT-NUM", "TEST"); text != "" {
+		t.Error("Expected nothing for an empty response but was", text)
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var (
+	// ErrUndefinedURLVariable a notification URL uses a variable which has no value
+	ErrUndefinedURLVariable = errors.New("undefined URL variable")
+	// ErrInvalidURLVariable a URL variable's name has characters other than letters, digits, _ and -
+	ErrInvalidURLVariable = errors.New("invalid URL variable name")
+
+	urlVariableNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	urlVariableRe     = regexp.MustCompile(`\{([A-Za-z0-9_-]+)\}`)
+)
+
+func validateURLVariables(vars map[string]string) error {
+	for name := range vars {
+		if !urlVariableNameRe.MatchString(name) {
+			return fmt.Errorf("%w: %q", ErrInvalidURLVariable, name)
+		}
+	}
+	return nil
+}
+
+// expandURL replaces each {name} in a notification URL template with the variable's value. A
+// variable in a later map replaces one with the same name in an earlier map. Values are put in
+// as they are, so they can hold a host name or several path segments.
+func expandURL(template string, vars ...map[string]string) (string, error) {
+	var err error
+	expanded := urlVariableRe.ReplaceAllStringFunc(template, func(match string) string {
+		name := match[1 : len(match)-1]
+		for i := len(vars) - 1; i >= 0; i-- {
+			if value, ok := vars[i][name]; ok {
+				return value
+			}
+		}
+		if err == nil {
+			err = fmt.Errorf("%w: %v in %v", ErrUndefinedURLVariable, name, template)
+		}
+		return match
+	})
+	return expanded, err
+}
+
+// expandURL fills in a notification URL template with the config's url_variables, and vars,
+// which replace them
+func (p NRTMProcessor) expandURL(template string, vars map[string]string) (string, error) {
+	return expandURL(template, p.config.URLVariables, vars)
+}
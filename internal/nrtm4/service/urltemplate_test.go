@@ -0,0 +1,35 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExpandURL(t *testing.T) {
+	config := map[string]string{"host": "nrtm.example.net", "path": "nrtmv4/v1"}
+	source := map[string]string{"source": "RIPE", "host": "nrtm.example.org"}
+	for template, expected := range map[string]string{
+		"https://{host}/{source}/update-notification-file.json": "https://nrtm.example.org/RIPE/update-notification-file.json",
+		"https://{host}/{path}/notification.json":               "https://nrtm.example.org/nrtmv4/v1/notification.json",
+		"https://nrtm.example.net/notification.json":            "https://nrtm.example.net/notification.json",
+		"https://nrtm.example.net/{}/notification.json":         "https://nrtm.example.net/{}/notification.json",
+	} {
+		if url, err := expandURL(template, config, source); err != nil || url != expected {
+			t.Error("Expected", expected, "for", template, "but was", url, err)
+		}
+	}
+	if _, err := expandURL("https://{host}/{registry}/notification.json", config, nil); !errors.Is(err, ErrUndefinedURLVariable) {
+		t.Error("Expected ErrUndefinedURLVariable but was", err)
+	}
+}
+
+func TestValidateURLVariables(t *testing.T) {
+	if err := validateURLVariables(map[string]string{"host": "a", "source_name-2": "b"}); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	for _, name := range []string{"", "a b", "{host}", "a/b"} {
+		if err := validateURLVariables(map[string]string{name: "x"}); !errors.Is(err, ErrInvalidURLVariable) {
+			t.Error("Expected ErrInvalidURLVariable for", name, "but was", err)
+		}
+	}
+}
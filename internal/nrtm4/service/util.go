@@ -5,7 +5,7 @@ import (
 	"net/url"
 	"strings"
 
-	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
 )
 
 func fileNameFromURLString(rawURL string) (string, error) {
@@ -20,7 +20,7 @@ func fileNameFromURLString(rawURL string) (string, error) {
 	return "", errors.New("did not find file name in URL")
 }
 
-type fileRefsByVersion []persist.FileRefJSON
+type fileRefsByVersion []protocol.FileRefJSON
 
 func (s fileRefsByVersion) Len() int {
 	return len(s)
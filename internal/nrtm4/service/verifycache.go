@@ -0,0 +1,143 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+// CacheReport lists the files in NRTMFilePath by what state they're in
+type CacheReport struct {
+	// OK files are referenced by a notification and their hash matches
+	OK []string
+	// Corrupt files are referenced by a notification but their hash doesn't match
+	Corrupt []string
+	// Stale files belong to a source's session but are no longer referenced
+	Stale []string
+	// Orphaned files don't belong to any source we know about
+	Orphaned []string
+	// Deleted files were removed because they were corrupt, stale or orphaned
+	Deleted []string
+	// InUse files were being downloaded or checked by another instance, so they were left alone
+	InUse []string
+	// Unchecked files belong to a source whose notification file couldn't be fetched, so they
+	// were left alone
+	Unchecked []string
+	// Unreachable are the sources whose notification file couldn't be fetched, by display name,
+	// with the error
+	Unreachable map[string]string
+}
+
+// VerifyCache checks the downloaded files against the latest notification file of each source.
+// If remove is true then any file which isn't OK is deleted.
+func (p NRTMProcessor) VerifyCache(remove bool) (CacheReport, error) {
+	var report CacheReport
+	ds := NrtmDataService{Repository: p.repo}
+	sources, err := ds.getSources()
+	if err != nil {
+		return report, err
+	}
+	fm := fileManager{client: p.client, strictness: p.config.strictness}
+	refs := map[string]protocol.FileRefJSON{}
+	sessionIDs := []string{}
+	uncheckedIDs := []string{}
+	for _, source := range sources {
+		sessionIDs = append(sessionIDs, source.SessionID)
+		fm.source = source.Source
+		notification, _, err := fm.downloadNotificationFile(source.NotificationURL)
+		if err != nil {
+			logger.Error("Cannot fetch notification file", "source", source.Source, "error", err)
+			if report.Unreachable == nil {
+				report.Unreachable = map[string]string{}
+			}
+			report.Unreachable[sourceDisplayName(source)] = err.Error()
+			uncheckedIDs = append(uncheckedIDs, source.SessionID)
+			continue
+		}
+		for _, ref := range append([]protocol.FileRefJSON{notification.SnapshotRef}, notification.DeltaRefs...) {
+			refs[filepath.Base(ref.URL)] = ref
+		}
+	}
+	entries, err := os.ReadDir(p.config.NRTMFilePath)
+	if err != nil {
+		return report, err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		name := entry.Name()
+		if containsAny(name, uncheckedIDs) {
+			report.Unchecked = append(report.Unchecked, name)
+			continue
+		}
+		path := filepath.Join(p.config.NRTMFilePath, name)
+		lockName := path
+		if target := partTarget(name); len(target) > 0 {
+			lockName = filepath.Join(p.config.NRTMFilePath, target)
+		}
+		lock, err := tryLockFile(lockName)
+		if errors.Is(err, errFileLocked) {
+			report.InUse = append(report.InUse, name)
+			continue
+		} else if err != nil {
+			return report, err
+		}
+		err = p.verifyCachedFile(name, path, refs, sessionIDs, remove, &report)
+		lock.unlock()
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// verifyCachedFile adds a file to the report, and removes it if remove is true and it isn't OK.
+// The caller holds the file's lock.
+func (p NRTMProcessor) verifyCachedFile(name, path string, refs map[string]protocol.FileRefJSON, sessionIDs []string, remove bool, report *CacheReport) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		// It was moved or removed by another instance before it was locked
+		return nil
+	}
+	if ref, ok := refs[name]; ok {
+		if hashMatches(path, ref.Hash) {
+			report.OK = append(report.OK, name)
+			return nil
+		}
+		report.Corrupt = append(report.Corrupt, name)
+	} else if containsAny(name, sessionIDs) {
+		report.Stale = append(report.Stale, name)
+	} else {
+		report.Orphaned = append(report.Orphaned, name)
+	}
+	if remove {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		report.Deleted = append(report.Deleted, name)
+	}
+	return nil
+}
+
+func hashMatches(path string, hash string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		logger.Warn("Cannot open file", "path", path, "error", err)
+		return false
+	}
+	defer file.Close()
+	sum, err := calcHash256(file)
+	return err == nil && sum == hash
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, sub := range substrs {
+		if len(sub) > 0 && strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+)
+
+type sourcesRepo struct {
+	persist.Repository
+	sources []persist.NRTMSource
+}
+
+func (r sourcesRepo) GetSources() ([]persist.NRTMSource, error) {
+	return r.sources, nil
+}
+
+func TestVerifyCache(t *testing.T) {
+	sessionID := "db44e038-1f07-4d54-a307-1b32339f141a"
+	okBody := "ok"
+	okHash := "2689367b205c16ce32ed4200942b8b8b1e262dfc70d9bc9fbc77c49699a4f1df"
+	files := map[string]string{
+		"nrtm-delta.2.EXAMPLE." + sessionID + ".json":          okBody,
+		"nrtm-delta.3.EXAMPLE." + sessionID + ".json":          "bad",
+		"nrtm-delta.1.EXAMPLE." + sessionID + ".json":          "old",
+		"nrtm-delta.9.OTHER.00000000-0000-0000-0000-000.json":  "gone",
+		"nrtm-delta.4.EXAMPLE." + sessionID + ".json.123.part": "downloading",
+		"nrtm-delta.5.EXAMPLE." + sessionID + ".json.456.part": "abandoned",
+	}
+	notification := protocol.NotificationJSON{
+		NrtmFileJSON: protocol.NrtmFileJSON{NrtmVersion: 4, Source: "EXAMPLE", SessionID: sessionID, Version: 3},
+		SnapshotRef:  protocol.FileRefJSON{URL: "nrtm-snapshot.2.EXAMPLE." + sessionID + ".json.gz", Version: 2},
+		DeltaRefs: []protocol.FileRefJSON{
+			{URL: "nrtm-delta.2.EXAMPLE." + sessionID + ".json", Version: 2, Hash: okHash},
+			{URL: "nrtm-delta.3.EXAMPLE." + sessionID + ".json", Version: 3, Hash: okHash},
+		},
+	}
+	dir, err := os.MkdirTemp("", "nrtm4test")
+	if err != nil {
+		t.Fatal("Failed to create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	for name, body := range files {
+		if err = os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal("Failed to write file", err)
+		}
+	}
+	p := NRTMProcessor{
+		config: AppConfig{NRTMFilePath: dir},
+		repo: sourcesRepo{sources: []persist.NRTMSource{
+			{Source: "EXAMPLE", SessionID: sessionID, NotificationURL: "https://example.com/nrtm4/notification.json"},
+		}},
+		client: stubDeltaClient{notification: notification},
+	}
+	// Another instance is downloading delta 4
+	lock, err := lockFile(filepath.Join(dir, "nrtm-delta.4.EXAMPLE."+sessionID+".json"))
+	if err != nil {
+		t.Fatal("Failed to lock file", err)
+	}
+	defer lock.unlock()
+	report, err := p.VerifyCache(true)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	expect := func(kind string, got []string, names ...string) {
+		if !slices.Equal(got, names) {
+			t.Error("Expected", kind, names, "but was", got)
+		}
+	}
+	expect("ok", report.OK, "nrtm-delta.2.EXAMPLE."+sessionID+".json")
+	expect("corrupt", report.Corrupt, "nrtm-delta.3.EXAMPLE."+sessionID+".json")
+	expect("stale", report.Stale, "nrtm-delta.1.EXAMPLE."+sessionID+".json", "nrtm-delta.5.EXAMPLE."+sessionID+".json.456.part")
+	expect("orphaned", report.Orphaned, "nrtm-delta.9.OTHER.00000000-0000-0000-0000-000.json")
+	expect("in use", report.InUse, "nrtm-delta.4.EXAMPLE."+sessionID+".json.123.part")
+	remaining := []string{}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			remaining = append(remaining, entry.Name())
+		}
+	}
+	expect("remaining", remaining, "nrtm-delta.2.EXAMPLE."+sessionID+".json", "nrtm-delta.4.EXAMPLE."+sessionID+".json.123.part")
+	if len(report.Deleted) != 4 {
+		t.Error("Expected 4 files to be deleted but was", report.Deleted)
+	}
+}
+
+type unreachableClient struct {
+	stubDeltaClient
+	url string
+}
+
+func (c unreachableClient) getUpdateNotification(url string) (protocol.NotificationJSON, http.Header, error) {
+	if url == c.url {
+		return protocol.NotificationJSON{}, nil, errors.New("connection refused")
+	}
+	return c.stubDeltaClient.getUpdateNotification(url)
+}
+
+func TestVerifyCacheUnreachableSource(t *testing.T) {
+	sessionID := "db44e038-1f07-4d54-a307-1b32339f141a"
+	downID := "5d6cf2a1-8b2e-4c1a-9f3e-0a7b6c5d4e3f"
+	okHash := "2689367b205c16ce32ed4200942b8b8b1e262dfc70d9bc9fbc77c49699a4f1df"
+	files := map[string]string{
+		"nrtm-delta.2.EXAMPLE." + sessionID + ".json": "ok",
+		"nrtm-delta.1.EXAMPLE." + sessionID + ".json": "old",
+		"nrtm-delta.7.DOWN." + downID + ".json":       "unchecked",
+	}
+	notification := protocol.NotificationJSON{
+		NrtmFileJSON: protocol.NrtmFileJSON{NrtmVersion: 4, Source: "EXAMPLE", SessionID: sessionID, Version: 2},
+		SnapshotRef:  protocol.FileRefJSON{URL: "nrtm-snapshot.1.EXAMPLE." + sessionID + ".json.gz", Version: 1},
+		DeltaRefs:    []protocol.FileRefJSON{{URL: "nrtm-delta.2.EXAMPLE." + sessionID + ".json", Version: 2, Hash: okHash}},
+	}
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal("Failed to write file", err)
+		}
+	}
+	p := NRTMProcessor{
+		config: AppConfig{NRTMFilePath: dir},
+		repo: sourcesRepo{sources: []persist.NRTMSource{
+			{Source: "DOWN", Label: "mirror", SessionID: downID, NotificationURL: "https://down.example.com/notification.json"},
+			{Source: "EXAMPLE", SessionID: sessionID, NotificationURL: "https://example.com/nrtm4/notification.json"},
+		}},
+		client: unreachableClient{
+			stubDeltaClient: stubDeltaClient{notification: notification},
+			url:             "https://down.example.com/notification.json",
+		},
+	}
+	report, err := p.VerifyCache(true)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(report.Unreachable) != 1 || len(report.Unreachable["DOWN (mirror)"]) == 0 {
+		t.Error("Expected DOWN to be unreachable but was", report.Unreachable)
+	}
+	if !slices.Equal(report.OK, []string{"nrtm-delta.2.EXAMPLE." + sessionID + ".json"}) {
+		t.Error("Expected the other source's files to be checked but OK was", report.OK)
+	}
+	if !slices.Equal(report.Stale, []string{"nrtm-delta.1.EXAMPLE." + sessionID + ".json"}) {
+		t.Error("Expected the other source's stale file but was", report.Stale)
+	}
+	if !slices.Equal(report.Unchecked, []string{"nrtm-delta.7.DOWN." + downID + ".json"}) {
+		t.Error("Expected the unreachable source's file to be unchecked but was", report.Unchecked)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "nrtm-delta.7.DOWN."+downID+".json")); err != nil {
+		t.Error("Expected the unreachable source's file to be kept", err)
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/jsonseq"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/quirks"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+// ErrSourceBehindSnapshot the source hasn't been updated to the snapshot's version yet
+var ErrSourceBehindSnapshot = errors.New("source is behind the snapshot. update it first")
+
+// VerifySnapshot downloads the snapshot a server publishes and compares it with the objects an
+// existing source had at the snapshot's version. Nothing in the repo is changed.
+func (p NRTMProcessor) VerifySnapshot(notificationURL, sourceName, label string) (persist.SnapshotComparison, error) {
+	var cmp persist.SnapshotComparison
+	ds := NrtmDataService{Repository: p.repo}
+	source := ds.getSourceByNameAndLabel(sourceName, label)
+	if source == nil {
+		return cmp, ErrSourceNotFound
+	}
+	fm := fileManager{client: p.client, strictness: p.config.strictness, source: source.Source}
+	notification, _, err := fm.downloadNotificationFile(notificationURL)
+	if err != nil {
+		return cmp, err
+	}
+	if notification, err = p.verifyNotification(source.Source, notificationURL, notification); err != nil {
+		return cmp, err
+	}
+	if notification.SnapshotRef.Version > source.Version {
+		return cmp, fmt.Errorf("%w: snapshot is version %d, source is at %d", ErrSourceBehindSnapshot, notification.SnapshotRef.Version, source.Version)
+	}
+	if err = fm.ensureDirectoryExists(p.config.NRTMFilePath); err != nil {
+		return cmp, err
+	}
+	snapshotURL, err := resolveFileURL(notificationURL, notification.SnapshotRef.URL, p.config.StrictFileURLs)
+	if err != nil {
+		return cmp, err
+	}
+	logger.Info("Fetching snapshot file...")
+	file, err := fm.fetchFileAndCheckHash(snapshotURL, notification.SnapshotRef, p.config.NRTMFilePath, p.config.TempDir)
+	if err != nil {
+		return cmp, err
+	}
+	defer file.Close()
+	logger.Info("Comparing snapshot with source", "source", source.Source, "label", source.Label, "version", notification.SnapshotRef.Version)
+	readRecords := func(fn jsonseq.RecordReaderFunc) error {
+		return fm.readJSONSeqRecords(file, fn)
+	}
+	return p.repo.CompareSnapshot(*source, notification.SnapshotRef.Version, snapshotLoader(readRecords, notification.SnapshotRef.Version, new(protocol.SnapshotFileJSON), p.sourceFilter(*source), p.config.sourceQuirks(source.Source)))
+}
+
+// snapshotLoader reads the objects in a snapshot file in batches. The first record is read into
+// header. Objects are parsed with objectQuirks, and ones which can't be parsed or don't pass
+// filter are left out, as they are by Connect.
+func snapshotLoader(readRecords func(jsonseq.RecordReaderFunc) error, version uint32, header *protocol.SnapshotFileJSON, filter *FilterConfig, objectQuirks quirks.Set) persist.SnapshotLoader {
+	return func(save func([]rpsl.Rpsl) error) error {
+		batch := make([]rpsl.Rpsl, 0, rpslInsertBatchSize)
+		expectHeader := true
+		parser := rpslObjectParser{quirks: objectQuirks}
+		err := readRecords(func(bytes []byte, err error) error {
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if expectHeader {
+				expectHeader = false
+				decoded, revision, err := protocol.DecodeHeader(bytes)
+				header.NrtmFileJSON = decoded
+				parser.revision = revision
+				if err != nil {
+					return err
+				}
+				if header.Version != version {
+					return ErrNRTM4FileVersionMismatch
+				}
+			} else if len(bytes) > 0 {
+				// There's no last record if it was left out for being too large
+				if obj := parser.bytesToRPSL(bytes); obj != nil && filter.keeps(*obj) {
+					batch = append(batch, *obj)
+				}
+			}
+			if len(batch) < rpslInsertBatchSize && err != io.EOF {
+				return nil
+			}
+			if len(batch) == 0 {
+				return nil
+			}
+			if err := save(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+			return nil
+		})
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+}
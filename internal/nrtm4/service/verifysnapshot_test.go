@@ -0,0 +1,49 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/jsonseq"
+	"github.com/petchells/nrtm4client/internal/nrtm4/protocol"
+	"github.com/petchells/nrtm4client/internal/nrtm4/quirks"
+	"github.com/petchells/nrtm4client/internal/nrtm4/rpsl"
+)
+
+func snapshotJSONSeq(version string, objects ...string) func(jsonseq.RecordReaderFunc) error {
+	records := []string{`{"nrtm_version":4,"type":"snapshot","source":"EXAMPLE","session_id":"x","version":` + version + `}`}
+	for _, obj := range objects {
+		records = append(records, `{"object":"`+obj+`"}`)
+	}
+	seq := "\x1e" + strings.Join(records, "\n\x1e") + "\n"
+	return func(fn jsonseq.RecordReaderFunc) error {
+		return jsonseq.ReadStringRecords(seq, fn)
+	}
+}
+
+func TestSnapshotLoaderBatches(t *testing.T) {
+	objects := make([]string, rpslInsertBatchSize+1)
+	for i := range objects {
+		objects[i] = `mntner: TEST-MNT\nsource: EXAMPLE\n`
+	}
+	batches := []int{}
+	load := snapshotLoader(snapshotJSONSeq("3", objects...), 3, new(protocol.SnapshotFileJSON), nil, quirks.Set{})
+	err := load(func(objs []rpsl.Rpsl) error {
+		batches = append(batches, len(objs))
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(batches) != 2 || batches[0] != rpslInsertBatchSize || batches[1] != 1 {
+		t.Error("Unexpected batches", batches)
+	}
+}
+
+func TestSnapshotLoaderChecksVersion(t *testing.T) {
+	load := snapshotLoader(snapshotJSONSeq("4", `mntner: TEST-MNT\n`), 3, new(protocol.SnapshotFileJSON), nil, quirks.Set{})
+	err := load(func(objs []rpsl.Rpsl) error { return nil })
+	if err != ErrNRTM4FileVersionMismatch {
+		t.Error("Expected ErrNRTM4FileVersionMismatch but was", err)
+	}
+}
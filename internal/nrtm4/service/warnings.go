@@ -0,0 +1,86 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+)
+
+// WarningKind says what sort of problem a sync worked around
+type WarningKind string
+
+const (
+	// WarningSkippedRecord a record in a snapshot file couldn't be parsed, so it was left out
+	WarningSkippedRecord WarningKind = "skipped_record"
+	// WarningToleratedMismatch a delta didn't match the repo, e.g. it deleted an object we don't have
+	WarningToleratedMismatch WarningKind = "tolerated_mismatch"
+	// WarningRetry a download had to be retried or resumed
+	WarningRetry WarningKind = "retry"
+	// WarningServer the server's notification file was stale, or its clock is off
+	WarningServer WarningKind = "server"
+	// WarningAnomaly the changes in the deltas were unusual. See checkChangeRate.
+	WarningAnomaly WarningKind = "anomaly"
+	// WarningSnapshotShortcut deltas weren't applied one by one, because a later snapshot was loaded
+	WarningSnapshotShortcut WarningKind = "snapshot_shortcut"
+	// WarningOversizedObject a record was larger than max_object_size, so it was quarantined
+	// instead of being applied
+	WarningOversizedObject WarningKind = "oversized_object"
+	// WarningPostSync the source's post_sync script or command failed
+	WarningPostSync WarningKind = "post_sync"
+	// WarningBookkeeping something the client keeps for itself, like the file history, wasn't saved
+	WarningBookkeeping WarningKind = "bookkeeping"
+	// WarningIrregularity the server broke a minor rule of the protocol, which the source's
+	// strictness tolerated
+	WarningIrregularity WarningKind = "irregularity"
+	// WarningObjectCount the source's number of objects was outside the range in its config
+	// after the update
+	WarningObjectCount WarningKind = "object_count"
+)
+
+// Warning is something which went wrong during a sync, but didn't stop it
+type Warning struct {
+	Kind    WarningKind `json:"kind"`
+	Version uint32      `json:"version,omitempty"`
+	Message string      `json:"message"`
+}
+
+func (w Warning) String() string {
+	if w.Version > 0 {
+		return fmt.Sprintf("%v (version %d): %v", w.Kind, w.Version, w.Message)
+	}
+	return fmt.Sprintf("%v: %v", w.Kind, w.Message)
+}
+
+// SyncResult is what an update or connect did, including any warnings. A sync which returns
+// an error as well as a SyncResult failed.
+type SyncResult struct {
+	Source      string    `json:"source"`
+	Label       string    `json:"label"`
+	FromVersion uint32    `json:"from_version"`
+	ToVersion   uint32    `json:"to_version"`
+	Warnings    []Warning `json:"warnings"`
+}
+
+// syncWarnings collects warnings during one sync. It's safe for concurrent use, and a nil
+// collector ignores them, so code which also runs outside a sync doesn't have to check.
+type syncWarnings struct {
+	mu   sync.Mutex
+	list []Warning
+}
+
+func (w *syncWarnings) add(kind WarningKind, version uint32, format string, args ...any) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.list = append(w.list, Warning{Kind: kind, Version: version, Message: fmt.Sprintf(format, args...)})
+}
+
+func (w *syncWarnings) all() []Warning {
+	if w == nil {
+		return []Warning{}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]Warning{}, w.list...)
+}
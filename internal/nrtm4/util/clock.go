@@ -1,8 +1,13 @@
 package util
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
-// AppClock is the application's stubbable clock, set to UTC
+// AppClock is the application's stubbable clock, set to UTC. Timestamps, staleness and
+// retention checks, schedules and backoffs use it, so tests and simulations can replace it
+// with a ManualClock. Only timings of work which has been done use the time package directly.
 var AppClock Clock = realClock{}
 
 // RFC3339Milli format that rounds to ms, so browsers can grok it
@@ -12,9 +17,79 @@ const RFC3339Milli = "2006-01-02T15:04:05.999Z07:00"
 type Clock interface {
 	Now() time.Time
 	After(d time.Duration) <-chan time.Time
+	Since(t time.Time) time.Duration
+	Sleep(d time.Duration)
 }
 
 type realClock struct{}
 
 func (realClock) Now() time.Time                         { return time.Now().UTC() }
 func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+// ManualClock is a Clock which only moves when it's told to, for deterministic tests and
+// simulations. Sleep moves it on instead of waiting, so a simulated run takes no real time.
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []clockWaiter
+}
+
+type clockWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewManualClock returns a ManualClock which starts at start
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start.UTC()}
+}
+
+// Now is the clock's time
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel which receives the time once the clock has been moved on by d
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, clockWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Since is how long before the clock's time t is
+func (c *ManualClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Sleep moves the clock on by d
+func (c *ManualClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// Advance moves the clock on by d, and fires the channels from After which are due
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+		} else {
+			w.ch <- c.now
+		}
+	}
+	c.waiters = waiting
+}
@@ -27,6 +27,14 @@ func (tc testClock) Now() time.Time {
 	return time.Now().UTC().Add(-1 * tc.offset)
 }
 
+func (tc testClock) Since(t time.Time) time.Duration {
+	return tc.Now().Sub(t)
+}
+
+func (tc testClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
 func (tc testClock) After(d time.Duration) <-chan time.Time {
 	time.Sleep(d)
 	c := make(chan time.Time, 1)
@@ -42,3 +50,32 @@ func TestTimeFormat(t *testing.T) {
 		t.Error("Format failed expected", expected, "but was", ts)
 	}
 }
+
+func TestManualClock(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := NewManualClock(start)
+	due := clock.After(time.Minute)
+	clock.Sleep(30 * time.Second)
+	select {
+	case <-due:
+		t.Fatal("After fired before the clock reached it")
+	default:
+	}
+	clock.Advance(30 * time.Second)
+	select {
+	case fired := <-due:
+		if !fired.Equal(start.Add(time.Minute)) {
+			t.Error("After fired at", fired)
+		}
+	default:
+		t.Fatal("After didn't fire when the clock reached it")
+	}
+	if since := clock.Since(start); since != time.Minute {
+		t.Error("Expected a minute since the start but was", since)
+	}
+	select {
+	case <-clock.After(0):
+	default:
+		t.Error("After(0) should fire straight away")
+	}
+}
@@ -0,0 +1,31 @@
+package util
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WatchInterruptSignals escalates each SIGINT or SIGTERM the process gets. The first calls
+// stop, the second calls abort, and the third exits straight away.
+func WatchInterruptSignals(stop, abort func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		received := 0
+		for sig := range signals {
+			received++
+			switch received {
+			case 1:
+				Logger.Warn("Stopping after the current delta. Send the signal again to abort it", "signal", sig)
+				stop()
+			case 2:
+				Logger.Warn("Aborting and rolling back the current delta. Send the signal again to exit now", "signal", sig)
+				abort()
+			default:
+				Logger.Error("Exiting without rolling back", "signal", sig)
+				os.Exit(130)
+			}
+		}
+	}()
+}
@@ -1,17 +1,155 @@
 package util
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
 	"os"
+	"slices"
+	"sync"
 )
 
-// Logger global logger
-var Logger = slog.New(
-	slog.NewTextHandler(
+// DefaultLogModule is the key in a map of log levels which sets the level of every module
+const DefaultLogModule = "default"
+
+// ErrUnknownLogModule there's no logger for the module
+var ErrUnknownLogModule = errors.New("unknown log module")
+
+var (
+	baseHandler = slog.NewTextHandler(
 		os.Stdout,
 		&slog.HandlerOptions{
 			AddSource: true,
 			Level:     slog.LevelDebug,
 		},
-	),
+	)
+	logModules = map[string]*slog.LevelVar{}
+	// debugLevels holds the levels to go back to when debug logging is toggled off
+	debugLevels map[string]slog.Level
+	logMu       sync.Mutex
 )
+
+// Logger global logger, for code which isn't in one of the modules below
+var Logger = ModuleLogger("app")
+
+// ModuleLogger returns a logger for a module, e.g. http or pg, whose level can be changed while
+// the program runs. Loggers for the same module share a level.
+func ModuleLogger(module string) *slog.Logger {
+	logMu.Lock()
+	defer logMu.Unlock()
+	level, ok := logModules[module]
+	if !ok {
+		level = new(slog.LevelVar)
+		level.Set(slog.LevelDebug)
+		logModules[module] = level
+	}
+	return slog.New(moduleHandler{
+		Handler: baseHandler.WithAttrs([]slog.Attr{slog.String("module", module)}),
+		level:   level,
+	})
+}
+
+// SetLogLevel changes the level of a module's loggers
+func SetLogLevel(module string, level slog.Level) error {
+	logMu.Lock()
+	defer logMu.Unlock()
+	lv, ok := logModules[module]
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrUnknownLogModule, module)
+	}
+	lv.Set(level)
+	return nil
+}
+
+// LogLevels returns the level of each module
+func LogLevels() map[string]string {
+	logMu.Lock()
+	defer logMu.Unlock()
+	levels := map[string]string{}
+	for module, lv := range logModules {
+		levels[module] = lv.Level().String()
+	}
+	return levels
+}
+
+// ApplyLogLevels sets module levels from names such as "debug" or "warn". The default key sets
+// every module first, then the others override it. Nothing is changed if any of them is invalid.
+func ApplyLogLevels(levels map[string]string) error {
+	parsed, err := ParseLogLevels(levels)
+	if err != nil {
+		return err
+	}
+	if level, ok := parsed[DefaultLogModule]; ok {
+		logMu.Lock()
+		for _, lv := range logModules {
+			lv.Set(level)
+		}
+		logMu.Unlock()
+	}
+	for _, module := range slices.Sorted(maps.Keys(parsed)) {
+		if module != DefaultLogModule {
+			if err = SetLogLevel(module, parsed[module]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ParseLogLevels checks the modules and levels in a map of log levels
+func ParseLogLevels(levels map[string]string) (map[string]slog.Level, error) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	parsed := map[string]slog.Level{}
+	for module, name := range levels {
+		if _, ok := logModules[module]; !ok && module != DefaultLogModule {
+			return nil, fmt.Errorf("%w: %v", ErrUnknownLogModule, module)
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return nil, fmt.Errorf("module %v: %w", module, err)
+		}
+		parsed[module] = level
+	}
+	return parsed, nil
+}
+
+// ToggleDebugLogging switches every module to debug, or back to the levels they had before it
+// was switched on. It returns true when debug logging is on.
+func ToggleDebugLogging() bool {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if debugLevels != nil {
+		for module, level := range debugLevels {
+			logModules[module].Set(level)
+		}
+		debugLevels = nil
+		return false
+	}
+	debugLevels = map[string]slog.Level{}
+	for module, lv := range logModules {
+		debugLevels[module] = lv.Level()
+		lv.Set(slog.LevelDebug)
+	}
+	return true
+}
+
+// moduleHandler filters records by its module's level before the shared handler writes them
+type moduleHandler struct {
+	slog.Handler
+	level *slog.LevelVar
+}
+
+func (h moduleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return moduleHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h moduleHandler) WithGroup(name string) slog.Handler {
+	return moduleHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
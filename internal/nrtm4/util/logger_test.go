@@ -0,0 +1,53 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+)
+
+func TestModuleLogLevels(t *testing.T) {
+	netLog := ModuleLogger("test-net")
+	dbLog := ModuleLogger("test-db")
+	defer ApplyLogLevels(map[string]string{DefaultLogModule: "debug"})
+
+	if err := ApplyLogLevels(map[string]string{DefaultLogModule: "warn", "test-net": "DEBUG"}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if !netLog.Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("Expected debug logging for test-net")
+	}
+	if dbLog.Enabled(context.Background(), slog.LevelInfo) || !dbLog.Enabled(context.Background(), slog.LevelWarn) {
+		t.Error("Expected test-db to log warnings only")
+	}
+	if levels := LogLevels(); levels["test-db"] != "WARN" || levels["test-net"] != "DEBUG" {
+		t.Error("Unexpected levels", levels)
+	}
+	// Loggers derived from a module logger follow its level
+	if dbLog.With("source", "EXAMPLE").Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("Expected a derived logger to have the module's level")
+	}
+
+	if err := ApplyLogLevels(map[string]string{"test-db": "info", "nope": "debug"}); !errors.Is(err, ErrUnknownLogModule) {
+		t.Error("Expected ErrUnknownLogModule but was", err)
+	}
+	if err := ApplyLogLevels(map[string]string{"test-db": "loud"}); err == nil {
+		t.Error("Expected an error for an invalid level")
+	}
+	if dbLog.Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("Expected no change when a level is invalid")
+	}
+}
+
+func TestToggleDebugLogging(t *testing.T) {
+	log := ModuleLogger("test-toggle")
+	defer ApplyLogLevels(map[string]string{DefaultLogModule: "debug"})
+	SetLogLevel("test-toggle", slog.LevelError)
+	if !ToggleDebugLogging() || !log.Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("Expected debug logging to be on")
+	}
+	if ToggleDebugLogging() || log.Enabled(context.Background(), slog.LevelWarn) {
+		t.Error("Expected the error level to be restored")
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !(linux || darwin || freebsd)
+
+package util
+
+// WatchLogSignal does nothing where there's no SIGUSR1
+func WatchLogSignal() {}
@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd
+
+package util
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WatchLogSignal toggles debug logging for every module each time the process gets SIGUSR1
+func WatchLogSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			Logger.Info("SIGUSR1 received", "debug", ToggleDebugLogging())
+		}
+	}()
+}
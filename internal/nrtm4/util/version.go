@@ -0,0 +1,80 @@
+package util
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// ClientVersion is the version of the binary. It's set at build time with
+// -ldflags "-X github.com/petchells/nrtm4client/internal/nrtm4/util.ClientVersion=..."
+var ClientVersion = "dev"
+
+// Commit is the git commit the binary was built from. It can be set at build time the same way
+// as ClientVersion, otherwise it's taken from the VCS information Go embeds in the binary.
+var Commit = ""
+
+// SpecRevision is the revision of the NRTMv4 spec the client implements
+const SpecRevision = "draft-ietf-grow-nrtm-v4-06"
+
+// Backends are the repository backends compiled into the binary
+var Backends = []string{"postgresql"}
+
+// BuildInfo describes the binary, for reporting which client a problem was seen with
+type BuildInfo struct {
+	Version      string   `json:"version"`
+	Commit       string   `json:"commit"`
+	GoVersion    string   `json:"go_version"`
+	Platform     string   `json:"platform"`
+	Backends     []string `json:"backends"`
+	SpecRevision string   `json:"spec_revision"`
+}
+
+// GetBuildInfo returns the version, commit and build settings of the binary
+func GetBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:      ClientVersion,
+		Commit:       Commit,
+		GoVersion:    runtime.Version(),
+		Platform:     runtime.GOOS + "/" + runtime.GOARCH,
+		Backends:     Backends,
+		SpecRevision: SpecRevision,
+	}
+	if len(info.Commit) > 0 {
+		return info
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	modified := false
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Commit = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if modified && len(info.Commit) > 0 {
+		info.Commit += "-dirty"
+	}
+	return info
+}
+
+// UserAgent is sent with every request to NRTM servers, so their operators can tell which
+// client versions are in use
+func UserAgent() string {
+	info := GetBuildInfo()
+	commit, dirty := strings.CutSuffix(info.Commit, "-dirty")
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	if len(commit) == 0 {
+		commit = "unknown"
+	} else if dirty {
+		commit += "-dirty"
+	}
+	return fmt.Sprintf("nrtm4client/%v (%v; %v; %v)", info.Version, commit, info.GoVersion, info.SpecRevision)
+}
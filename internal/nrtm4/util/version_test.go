@@ -0,0 +1,22 @@
+package util
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUserAgent(t *testing.T) {
+	version, commit := ClientVersion, Commit
+	defer func() { ClientVersion, Commit = version, commit }()
+	ClientVersion = "v1.2.3"
+	Commit = "0123456789abcdef0123456789abcdef01234567-dirty"
+	expected := "nrtm4client/v1.2.3 (0123456789ab-dirty; " + runtime.Version() + "; " + SpecRevision + ")"
+	if ua := UserAgent(); ua != expected {
+		t.Error("Expected", expected, "but was", ua)
+	}
+	info := GetBuildInfo()
+	if info.Commit != Commit || info.Version != "v1.2.3" || !strings.Contains(info.Platform, "/") {
+		t.Error("Unexpected build info", info)
+	}
+}
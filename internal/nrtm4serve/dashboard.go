@@ -0,0 +1,110 @@
+package nrtm4serve
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/petchells/nrtm4client/internal/nrtm4/service"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// defaultDashboardRange is how far back a dashboard series goes without a from time
+const defaultDashboardRange = 24 * time.Hour
+
+// DashboardHandler returns a JSON time series of a source's updates, for Grafana's JSON data
+// sources, e.g. /dashboard/RIPE?label=prod&from=1760486400000&to=1760572800000&step=1h. from
+// and to are RFC 3339 times or milliseconds since the epoch, as in Grafana's ${__from} and
+// ${__to}, and default to the last day. step is a duration to sum the updates over.
+func DashboardHandler(processor service.NRTMProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source := mux.Vars(r)["source"]
+		query := r.URL.Query()
+		to, err := parseDashboardTime(query.Get("to"), util.AppClock.Now())
+		if err != nil {
+			http.Error(w, "to must be an RFC 3339 time or milliseconds", http.StatusBadRequest)
+			return
+		}
+		from, err := parseDashboardTime(query.Get("from"), to.Add(-defaultDashboardRange))
+		if err != nil {
+			http.Error(w, "from must be an RFC 3339 time or milliseconds", http.StatusBadRequest)
+			return
+		}
+		var step time.Duration
+		if s := query.Get("step"); len(s) > 0 {
+			if step, err = parseDashboardStep(s); err != nil || step < 0 {
+				http.Error(w, "step must be a duration", http.StatusBadRequest)
+				return
+			}
+		}
+		q, err := processor.BeginQuery("dashboard", 0)
+		if queryLimitError(w, err) {
+			return
+		}
+		defer q.End()
+		points, err := processor.WithQuery(q).Dashboard(source, query.Get("label"), from, to, step)
+		if errors.Is(err, service.ErrSourceNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if queryLimitError(w, err) {
+			return
+		} else if err != nil {
+			logger.Error("Dashboard query failed", "source", source, "error", err)
+			http.Error(w, "dashboard query failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(points); err != nil {
+			logger.Warn("Failed to write dashboard response", "error", err)
+		}
+	}
+}
+
+func parseDashboardTime(s string, def time.Time) (time.Time, error) {
+	if len(s) == 0 {
+		return def, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseDashboardStep parses a Go duration, or a number of days such as Grafana's "1d" interval
+func parseDashboardStep(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour, err
+	}
+	return time.ParseDuration(s)
+}
+
+// ForecastHandler returns the growth of the files and the database, and when they'll be full,
+// e.g. /admin/forecast?window=168h, for Grafana's JSON data sources and alerting. window is how
+// far back growth is measured, as a duration or a number of days.
+func ForecastHandler(processor service.NRTMProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var window time.Duration
+		if s := r.URL.Query().Get("window"); len(s) > 0 {
+			var err error
+			if window, err = parseDashboardStep(s); err != nil || window < 0 {
+				http.Error(w, "window must be a duration", http.StatusBadRequest)
+				return
+			}
+		}
+		report, err := processor.Forecast(window)
+		if err != nil {
+			logger.Error("Forecast failed", "error", err)
+			http.Error(w, "forecast failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(report); err != nil {
+			logger.Warn("Failed to write forecast response", "error", err)
+		}
+	}
+}
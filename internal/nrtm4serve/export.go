@@ -0,0 +1,132 @@
+package nrtm4serve
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/service"
+)
+
+// ExportHandler streams a source's current objects as RPSL. The source is in the path, and the
+// label, object types and an optional version to export them as they were at are in the query,
+// e.g. /export/RIPE?label=prod&type=mntner&type=route&version=1234. Instead of a version, pin
+// can be a token from PinHandler, and the source is exported at the version it pins. An encoding
+// of latin-1 or escape in the query converts the text for parsers which only read those. The
+// response is chunked and written as the objects are read, so it can be any size, unless the
+// query_limits in the config stop it.
+func ExportHandler(processor service.NRTMProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source := mux.Vars(r)["source"]
+		query := r.URL.Query()
+		var version uint64
+		if v := query.Get("version"); len(v) > 0 {
+			var err error
+			if version, err = strconv.ParseUint(v, 10, 32); err != nil {
+				http.Error(w, "version must be a number", http.StatusBadRequest)
+				return
+			}
+		}
+		if pin := query.Get("pin"); len(pin) > 0 {
+			if version > 0 {
+				http.Error(w, "version and pin can't both be given", http.StatusBadRequest)
+				return
+			}
+			snapshot, err := service.ParseReadSnapshot(pin)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			pinned, err := processor.PinnedVersion(snapshot, source, query.Get("label"))
+			if errors.Is(err, service.ErrSourceNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, service.ErrPinnedSessionGone) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			version = uint64(pinned)
+		}
+		encoding, err := service.ParseOutputEncoding(query.Get("encoding"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q, err := processor.BeginQuery(exportQueryKey(source, query.Get("label"), query["type"]), 0)
+		if queryLimitError(w, err) {
+			return
+		}
+		defer q.End()
+		w.Header().Set("Content-Type", "text/plain; charset="+encoding.Charset())
+		out := service.NewEncodingWriter(w, encoding)
+		n, err := processor.WithQuery(q).ExportObjects(out, source, query.Get("label"), uint32(version), query["type"])
+		if err == nil {
+			err = out.Close()
+		}
+		if err == nil {
+			return
+		}
+		if errors.Is(err, service.ErrSourceNotFound) || errors.Is(err, persist.ErrVersionNotApplied) || errors.Is(err, persist.ErrVersionNotInRepo) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if n == 0 && queryLimitError(w, err) {
+			return
+		}
+		logger.Error("Export failed", "source", source, "objects", n, "error", err)
+		if n == 0 {
+			http.Error(w, "export failed", http.StatusInternalServerError)
+			return
+		}
+		// The status has gone, so the response is cut off to tell the client it's incomplete
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// pinResponse is a ReadSnapshot with the token which passes it to /export
+type pinResponse struct {
+	service.ReadSnapshot
+	Token string `json:"token"`
+}
+
+// PinHandler takes a read snapshot of the sources in the query, e.g.
+// /pin?source=RIPE&source=ARIN/prod, of a group, e.g. /pin?group=irr-tier1, or of every source.
+// The token in the response is passed to /export as pin, so a run which exports several sources
+// reads them all as they were at the same moment, however many deltas land while it runs.
+func PinHandler(processor service.NRTMProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var snapshot service.ReadSnapshot
+		var err error
+		if group := query.Get("group"); len(group) > 0 {
+			if len(query["source"]) > 0 {
+				http.Error(w, "source and group can't both be given", http.StatusBadRequest)
+				return
+			}
+			snapshot, err = processor.PinGroup(group)
+		} else {
+			snapshot, err = processor.PinSources(query["source"])
+		}
+		if errors.Is(err, service.ErrSourceNotFound) || errors.Is(err, service.ErrGroupNotFound) || errors.Is(err, persist.ErrVersionNotApplied) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Pin failed", "error", err)
+			http.Error(w, "pin failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(pinResponse{ReadSnapshot: snapshot, Token: snapshot.Token()}); err != nil {
+			logger.Warn("Failed to write pin response", "error", err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package nrtm4serve
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/tabwriter"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/service"
+)
+
+// StatusHandler shows the version and state of this instance's sources, e.g. /admin/status.
+// It's what other instances read to combine it into their federation status.
+func StatusHandler(processor service.NRTMProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := processor.Status()
+		if err != nil {
+			logger.Error("Status query failed", "error", err)
+			http.Error(w, "status query failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(status); err != nil {
+			logger.Warn("Failed to write status response", "error", err)
+		}
+	}
+}
+
+// FederationHandler combines the status of this instance and the peers in the federation
+// config, e.g. /admin/federation, /admin/federation?format=text for a table of each source's
+// version on each instance, or /admin/federation?format=metrics for Prometheus
+func FederationHandler(processor service.NRTMProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if len(format) > 0 && format != "json" && format != "text" && format != "metrics" {
+			http.Error(w, "format must be json, text or metrics", http.StatusBadRequest)
+			return
+		}
+		status := processor.FederationStatus()
+		switch format {
+		case "text":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			writeFederationText(w, status)
+			return
+		case "metrics":
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+			if err := status.WriteMetrics(w); err != nil {
+				logger.Warn("Failed to write federation metrics", "error", err)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			logger.Warn("Failed to write federation response", "error", err)
+		}
+	}
+}
+
+func writeFederationText(w http.ResponseWriter, status service.FederationStatus) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Instances up: %d, down: %d\n\n", status.Up, status.Down)
+	fmt.Fprintln(tw, "INSTANCE\tCLIENT\tSTATE")
+	for _, instance := range status.Instances {
+		if instance.Status == nil {
+			fmt.Fprintf(tw, "%v\t\tdown: %v\n", instance.Name, instance.Error)
+		} else {
+			fmt.Fprintf(tw, "%v\t%v\tup\n", instance.Name, instance.Status.ClientVersion)
+		}
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "SOURCE\tLABEL\tINSTANCE\tVERSION\tBEHIND\tNOTE")
+	for _, src := range status.Sources {
+		for _, m := range src.Mirrors {
+			note := ""
+			switch {
+			case m.OtherSession:
+				note = "session " + m.SessionID
+			case m.Quarantined:
+				note = "quarantined"
+			case m.Paused:
+				note = "paused"
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%d\t%d\t%v\n", src.Source, src.Label, m.Instance, m.Version, m.Behind, note)
+		}
+	}
+	tw.Flush()
+}
@@ -1,25 +1,38 @@
 package nrtm4serve
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"time"
 
-	"github.com/petchells/nrtm4client/internal/nrtm4/pg"
+	"github.com/petchells/nrtm4client/client"
 	"github.com/petchells/nrtm4client/internal/nrtm4/service"
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
 	"github.com/petchells/nrtm4client/internal/nrtm4serve/rpc"
+	"github.com/petchells/nrtm4client/web"
 )
 
 // Launch sets up the rpc handler and starts the server
 func Launch(config service.AppConfig, port int, webRoot string) {
-	repo := pg.PostgresRepository{}
-	if err := repo.Initialize(config.PgDatabaseURL); err != nil {
+	repo := client.NewRepository(config)
+	if err := repo.Initialize(config.DatabaseURL()); err != nil {
 		log.Fatal("Failed to initialize repository")
 	}
 	defer repo.Close()
-	processor := service.NewNRTMProcessor(config, repo, service.HTTPClient{})
-	rpcHandler := rpc.Handler{API: WebAPI{Processor: processor}}
-	logger.Info("NRTM4serve is starting", "port", port)
+	processor := service.NewNRTMProcessor(config, repo, service.NewHTTPClient(config.Network))
+	if err := processor.CheckSchemaVersion(false); err != nil {
+		log.Fatal("Incompatible database schema: ", err)
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go processor.BuildIndexes(ctx)
+	go processor.RunDigests(ctx)
+	scheduler := processor.NewScheduler(config.AdminAPI.Parallel, nil)
+	go scheduler.Run(ctx)
+	rpcHandler := rpc.Handler{API: WebAPI{Processor: processor, AdminAPI: config.AdminAPI}}
+	info := util.GetBuildInfo()
+	logger.Info("NRTM4serve is starting", "port", port, "version", info.Version, "commit", info.Commit, "go", info.GoVersion)
 	defer func() {
 		if r := recover(); r != nil {
 			logger.Error("Recovered from Panic in launcher", "recover", r)
@@ -29,10 +42,37 @@ func Launch(config service.AppConfig, port int, webRoot string) {
 	s := rpc.NewServer()
 	s.Router().HandleFunc("/rpc", rpcHandler.ProcessRPC).Methods("POST")
 	s.Router().HandleFunc("/rpc", rpcHandler.ProcessRPC).Methods("OPTIONS")
+	s.GETHandler("/export/{source}", ExportHandler(processor))
+	s.GETHandler("/pin", PinHandler(processor))
+	s.GETHandler("/dashboard/{source}", DashboardHandler(processor))
+	admin := func(handler http.HandlerFunc) http.HandlerFunc {
+		return RequireAdminToken(config.AdminAPI, handler)
+	}
+	s.Router().HandleFunc("/admin/loglevels", admin(LogLevelsHandler)).Methods(http.MethodGet, http.MethodPut)
+	s.GETHandler("/admin/progress", admin(ProgressHandler(processor)))
+	s.GETHandler("/admin/status", admin(StatusHandler(processor)))
+	s.GETHandler("/admin/federation", admin(FederationHandler(processor)))
+	s.GETHandler("/admin/forecast", admin(ForecastHandler(processor)))
+	sync := admin(SyncHandler(scheduler))
+	s.Router().HandleFunc("/api/v1/sources/{name}/sync", sync).Methods(http.MethodPost)
+	s.Router().HandleFunc("/api/v1/sources/{name}/{label}/sync", sync).Methods(http.MethodPost)
+	s.GETHandler("/api/v1/syncs/{id}", admin(SyncStatusHandler(processor, scheduler)))
 
-	if len(webRoot) > 0 {
-		s.Router().PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir(webRoot))))
-
+	if handler := webHandler(webRoot); handler != nil {
+		s.Router().PathPrefix("/").Handler(handler)
 	}
 	s.Serve(port)
 }
+
+// webHandler serves the web client from webRoot, or from the build in the binary if webRoot is
+// empty. It's nil when there's neither.
+func webHandler(webRoot string) http.Handler {
+	if len(webRoot) > 0 {
+		return http.StripPrefix("/", http.FileServer(http.Dir(webRoot)))
+	}
+	if assets := web.Assets(); assets != nil {
+		logger.Info("Serving the web client built into the binary")
+		return http.FileServer(http.FS(assets))
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package nrtm4serve
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/util"
+)
+
+// LogLevelsHandler shows the log level of each module on GET, and changes them on PUT with a
+// JSON object of module names to levels, e.g. {"http": "debug"}. The levels after the change
+// are returned.
+func LogLevelsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		levels := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&levels); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := util.ApplyLogLevels(levels); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Info("Log levels changed", "levels", levels)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(util.LogLevels())
+}
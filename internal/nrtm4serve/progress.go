@@ -0,0 +1,21 @@
+package nrtm4serve
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/service"
+)
+
+// ProgressHandler shows the progress of the latest connect or update of each source, e.g.
+// /admin/progress, or of one source with /admin/progress?source=RIPE&label=prod. Syncs are only
+// seen if they were started by this server.
+func ProgressHandler(processor service.NRTMProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(processor.Progress(query.Get("source"), query.Get("label"))); err != nil {
+			logger.Warn("Failed to write progress response", "error", err)
+		}
+	}
+}
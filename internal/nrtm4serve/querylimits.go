@@ -0,0 +1,44 @@
+package nrtm4serve
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/petchells/nrtm4client/internal/nrtm4/persist"
+	"github.com/petchells/nrtm4client/internal/nrtm4/service"
+)
+
+// queryRetryAfter is how many seconds a client is told to wait when too many queries are running
+const queryRetryAfter = "1"
+
+// queryLimitError writes the response for a query which the limits stopped, and returns false
+// if err isn't one of theirs
+func queryLimitError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, service.ErrQueryBusy):
+		w.Header().Set("Retry-After", queryRetryAfter)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, service.ErrQueryTooExpensive):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, service.ErrQueryTimeout), errors.Is(err, persist.ErrStatementTimeout):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		return false
+	}
+	return true
+}
+
+// exportQueryKey identifies an export of some of a source's object types, so its cost the last
+// time it ran can be the estimate of the next one
+func exportQueryKey(source, label string, objectTypes []string) string {
+	types := []string{}
+	for _, t := range objectTypes {
+		if t = strings.ToUpper(strings.TrimSpace(t)); len(t) > 0 {
+			types = append(types, t)
+		}
+	}
+	slices.Sort(types)
+	return "export " + strings.ToUpper(source) + "/" + label + " " + strings.Join(slices.Compact(types), ",")
+}
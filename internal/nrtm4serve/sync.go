@@ -0,0 +1,94 @@
+package nrtm4serve
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/petchells/nrtm4client/internal/nrtm4/service"
+)
+
+// syncStatus is a triggered update, with the progress of the source's sync while it runs
+type syncStatus struct {
+	service.TriggeredSync
+	Progress *service.Progress `json:"progress,omitempty"`
+}
+
+// RequireAdminToken lets a request through to handler only if it has the bearer token in the
+// admin API config. Every request is refused when there's no token.
+func RequireAdminToken(config service.AdminAPIConfig, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, err := hasAdminToken(config, r)
+		if err != nil {
+			logger.Warn("Admin API request refused", "path", r.URL.Path, "error", err)
+			http.Error(w, "the admin API is disabled", http.StatusForbidden)
+			return
+		}
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="nrtm4serve"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// hasAdminToken says whether the request has the admin API's bearer token. It's an error when
+// there's no token to check against.
+func hasAdminToken(config service.AdminAPIConfig, r *http.Request) (bool, error) {
+	token, err := config.Token()
+	if err != nil {
+		return false, err
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(given)), []byte(token)) == 1, nil
+}
+
+// SyncHandler queues an update of a source, e.g. POST /api/v1/sources/RIPE/prod/sync, or
+// /api/v1/sources/RIPE/sync for a source without a label. It responds 202 with the update's ID,
+// and its status can be polled at the Location given.
+func SyncHandler(scheduler *service.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		trigger, err := scheduler.Trigger(vars["name"], vars["label"])
+		if errors.Is(err, service.ErrSourceNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.Error("Failed to trigger update", "source", vars["name"], "label", vars["label"], "error", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Location", "/api/v1/syncs/"+trigger.ID)
+		writeSyncStatus(w, http.StatusAccepted, syncStatus{TriggeredSync: trigger})
+	}
+}
+
+// SyncStatusHandler shows a triggered update, e.g. /api/v1/syncs/{id}
+func SyncStatusHandler(processor service.NRTMProcessor, scheduler *service.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		trigger, ok := scheduler.Triggered(mux.Vars(r)["id"])
+		if !ok {
+			http.Error(w, "no such sync", http.StatusNotFound)
+			return
+		}
+		status := syncStatus{TriggeredSync: trigger}
+		if trigger.State == service.TriggerRunning {
+			if runs := processor.Progress(trigger.Source, trigger.Label); len(runs) > 0 {
+				status.Progress = &runs[0]
+			}
+		}
+		writeSyncStatus(w, http.StatusOK, status)
+	}
+}
+
+func writeSyncStatus(w http.ResponseWriter, code int, status syncStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logger.Warn("Failed to write sync status response", "error", err)
+	}
+}
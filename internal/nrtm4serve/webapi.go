@@ -8,15 +8,30 @@ import (
 	"github.com/petchells/nrtm4client/internal/nrtm4serve/rpc"
 )
 
+// adminMethods can only be called with the admin API's bearer token. CompareUpstream makes
+// the server query the registry for whatever key it's given.
+var adminMethods = map[string]bool{
+	"CompareUpstream": true,
+}
+
 // WebAPI defines the RPC functions used by the web client
 type WebAPI struct {
 	//	rpc.API
 	Processor service.NRTMProcessor
+	AdminAPI  service.AdminAPIConfig
 }
 
-// GetAuth implements interface -- allows requests to all methods
+// GetAuth implements interface -- allows requests to all methods except adminMethods, which
+// need the admin API's bearer token
 func (api WebAPI) GetAuth(w http.ResponseWriter, r *http.Request, req rpc.JSONRPCRequest) (rpc.WebSession, bool) {
-	return rpc.WebSession{}, true
+	if req.Method == nil || !adminMethods[*req.Method] {
+		return rpc.WebSession{}, true
+	}
+	ok, err := hasAdminToken(api.AdminAPI, r)
+	if err != nil {
+		logger.Warn("RPC request refused", "method", *req.Method, "error", err)
+	}
+	return rpc.WebSession{}, ok
 }
 
 // ListSources returns a list of sources
@@ -24,21 +39,51 @@ func (api WebAPI) ListSources() ([]persist.NRTMSourceDetails, error) {
 	return api.Processor.ListSources()
 }
 
+// QuerySources returns a page of sources. See service.ListQuery for the filter and sort syntax.
+func (api WebAPI) QuerySources(query service.ListQuery) (service.Page[persist.NRTMSourceDetails], error) {
+	q, err := api.Processor.BeginQuery("sources", 0)
+	if err != nil {
+		return service.Page[persist.NRTMSourceDetails]{}, err
+	}
+	defer q.End()
+	return api.Processor.WithQuery(q).QuerySources(query)
+}
+
+// Lookup returns the current objects for a list of primary keys, and the keys which weren't
+// found. Each key is expected to cost an object read.
+func (api WebAPI) Lookup(source, label string, keys []string) (service.LookupResult, error) {
+	q, err := api.Processor.BeginQuery("lookup", len(keys))
+	if err != nil {
+		return service.LookupResult{}, err
+	}
+	defer q.End()
+	return api.Processor.WithQuery(q).Lookup(source, label, 0, keys)
+}
+
+// CompareUpstream compares the mirror's objects with a primary key with the registry's, fetched
+// over whois or RDAP
+func (api WebAPI) CompareUpstream(source, label, objectType, key string, attrs []string) ([]service.UpstreamComparison, error) {
+	return api.Processor.CompareUpstream(source, label, objectType, key, attrs)
+}
+
 // ReplaceLabel replaces a label on a source
 func (api WebAPI) ReplaceLabel(source, fromLabel, toLabel string) (*persist.NRTMSource, error) {
 	return api.Processor.ReplaceLabel(source, fromLabel, toLabel)
 }
 
 // Connect connects a new source to the repo
-func (api WebAPI) Connect(url, label string) (string, error) {
-	err := api.Processor.Connect(url, label)
-	return "OK", err
+func (api WebAPI) Connect(url, label string) (service.SyncResult, error) {
+	return api.Processor.Connect(url, label)
 }
 
 // Update updates a source to the latest version
-func (api WebAPI) Update(src, label string) (string, error) {
-	err := api.Processor.Update(src, label)
-	return "OK", err
+func (api WebAPI) Update(src, label string) (service.SyncResult, error) {
+	return api.Processor.Update(src, label, service.CatchUpAuto)
+}
+
+// Progress returns how far the latest connect or update of each source has got
+func (api WebAPI) Progress(src, label string) ([]service.Progress, error) {
+	return api.Processor.Progress(src, label), nil
 }
 
 // RemoveSource removes a source from the repo
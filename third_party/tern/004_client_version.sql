@@ -0,0 +1,12 @@
+create table nrtm_client_version (
+	client_version varchar(255) not null,
+	schema_version integer not null,
+	first_run timestamp without time zone not null,
+	last_run timestamp without time zone not null,
+
+	constraint nrtm_client_version__pk primary key (client_version, schema_version)
+);
+
+---- create above / drop below ----
+
+drop table nrtm_client_version;
@@ -0,0 +1,5 @@
+alter table nrtm_source add column paused boolean not null default false;
+
+---- create above / drop below ----
+
+alter table nrtm_source drop column paused;
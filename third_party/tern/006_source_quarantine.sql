@@ -0,0 +1,9 @@
+alter table nrtm_source add column quarantine_failures integer not null default 0;
+alter table nrtm_source add column quarantine_reason text not null default '';
+alter table nrtm_source add column quarantined_until timestamp without time zone;
+
+---- create above / drop below ----
+
+alter table nrtm_source drop column quarantined_until;
+alter table nrtm_source drop column quarantine_reason;
+alter table nrtm_source drop column quarantine_failures;
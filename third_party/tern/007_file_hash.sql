@@ -0,0 +1,8 @@
+alter table nrtm_file add column hash varchar(64) not null default '';
+
+create index nrtm_file__source_hash_idx on nrtm_file(nrtm_source_id, hash);
+
+---- create above / drop below ----
+
+drop index nrtm_file__source_hash_idx;
+alter table nrtm_file drop column hash;
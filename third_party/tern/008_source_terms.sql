@@ -0,0 +1,5 @@
+alter table nrtm_source add column terms_url text not null default '';
+
+---- create above / drop below ----
+
+alter table nrtm_source drop column terms_url;
@@ -0,0 +1,7 @@
+alter table nrtm_notification add column raw bytea;
+alter table nrtm_file add column header bytea;
+
+---- create above / drop below ----
+
+alter table nrtm_file drop column header;
+alter table nrtm_notification drop column raw;
@@ -0,0 +1,5 @@
+alter table nrtm_source add column superseded timestamp without time zone;
+
+---- create above / drop below ----
+
+alter table nrtm_source drop column superseded;
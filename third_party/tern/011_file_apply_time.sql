@@ -0,0 +1,7 @@
+alter table nrtm_file add column size bigint not null default 0;
+alter table nrtm_file add column apply_ms bigint not null default 0;
+
+---- create above / drop below ----
+
+alter table nrtm_file drop column apply_ms;
+alter table nrtm_file drop column size;
@@ -0,0 +1,43 @@
+create table nrtm_index_build (
+	name varchar(32) not null,
+	last_object_id bigint not null default 0,
+	objects bigint not null default 0,
+	started timestamp without time zone,
+	caught_up timestamp without time zone,
+
+	constraint index_build__pk primary key (name)
+);
+
+create table nrtm_fulltext_index (
+	rpslobject_id bigint not null,
+	nrtm_source_id bigint not null,
+	document tsvector not null
+);
+create index fulltext_index__rpslobject__idx on nrtm_fulltext_index(rpslobject_id);
+create index fulltext_index__source__idx on nrtm_fulltext_index(nrtm_source_id);
+create index fulltext_index__document__idx on nrtm_fulltext_index using gin(document);
+
+create table nrtm_prefix_index (
+	rpslobject_id bigint not null,
+	nrtm_source_id bigint not null,
+	prefix cidr
+);
+create index prefix_index__rpslobject__idx on nrtm_prefix_index(rpslobject_id);
+create index prefix_index__source__idx on nrtm_prefix_index(nrtm_source_id);
+create index prefix_index__prefix__idx on nrtm_prefix_index using gist(prefix inet_ops);
+
+create table nrtm_attribute_index (
+	rpslobject_id bigint not null,
+	nrtm_source_id bigint not null,
+	attributes jsonb not null
+);
+create index attribute_index__rpslobject__idx on nrtm_attribute_index(rpslobject_id);
+create index attribute_index__source__idx on nrtm_attribute_index(nrtm_source_id);
+create index attribute_index__attributes__idx on nrtm_attribute_index using gin(attributes jsonb_path_ops);
+
+---- create above / drop below ----
+
+drop table nrtm_attribute_index;
+drop table nrtm_prefix_index;
+drop table nrtm_fulltext_index;
+drop table nrtm_index_build;
@@ -0,0 +1,15 @@
+create table nrtm_pending_delta (
+	nrtm_source_id bigint not null,
+	version integer not null,
+	url text not null,
+	hash text not null default '',
+	expires text not null default '',
+	queued timestamp without time zone not null,
+
+	constraint nrtm_pending_delta__pk primary key (nrtm_source_id, version),
+	constraint nrtm_pending_delta__nrtm_source__fk foreign key(nrtm_source_id) references nrtm_source(id)
+);
+
+---- create above / drop below ----
+
+drop table nrtm_pending_delta;
@@ -0,0 +1,5 @@
+alter table nrtm_file add column run_id bigint not null default 0;
+
+---- create above / drop below ----
+
+alter table nrtm_file drop column run_id;
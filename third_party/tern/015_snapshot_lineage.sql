@@ -0,0 +1,16 @@
+create table nrtm_snapshot_ref (
+	nrtm_source_id bigint not null,
+	session_id text not null,
+	version integer not null,
+	url text not null,
+	hash text not null default '',
+	first_seen timestamp without time zone not null,
+	last_seen timestamp without time zone not null,
+
+	constraint nrtm_snapshot_ref__pk primary key (nrtm_source_id, session_id, version),
+	constraint nrtm_snapshot_ref__nrtm_source__fk foreign key(nrtm_source_id) references nrtm_source(id)
+);
+
+---- create above / drop below ----
+
+drop table nrtm_snapshot_ref;
@@ -0,0 +1,16 @@
+create table nrtm_oversized_object (
+	nrtm_source_id bigint not null,
+	version integer not null,
+	object_type text not null,
+	primary_key text not null,
+	size integer not null,
+	record_start text not null,
+	quarantined timestamp without time zone not null,
+
+	constraint nrtm_oversized_object__pk primary key (nrtm_source_id, version, object_type, primary_key),
+	constraint nrtm_oversized_object__nrtm_source__fk foreign key(nrtm_source_id) references nrtm_source(id)
+);
+
+---- create above / drop below ----
+
+drop table nrtm_oversized_object;
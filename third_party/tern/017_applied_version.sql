@@ -0,0 +1,7 @@
+alter table nrtm_source add column applied_version integer not null default 0;
+
+update nrtm_source set applied_version = version;
+
+---- create above / drop below ----
+
+alter table nrtm_source drop column applied_version;
@@ -0,0 +1,42 @@
+create table nrtm_sync_run (
+	id bigint not null,
+	nrtm_source_id bigint not null,
+	run_id bigint not null,
+	started timestamp without time zone not null,
+	duration_ms bigint not null,
+	from_version integer not null,
+	to_version integer not null,
+	changes integer not null,
+	lag_ms bigint,
+	warnings integer not null,
+	success boolean not null,
+	failure text not null,
+	reason text not null,
+
+	constraint nrtm_sync_run__pk primary key (id),
+	constraint nrtm_sync_run__nrtm_source__fk foreign key(nrtm_source_id) references nrtm_source(id)
+);
+
+create index nrtm_sync_run__source_started_idx on nrtm_sync_run(nrtm_source_id, started);
+
+-- One row per update, for a Grafana PostgreSQL data source
+create view nrtm_dashboard as
+	select
+		r.started as "time",
+		s.source,
+		s.label,
+		r.to_version as version,
+		r.lag_ms / 1000.0 as lag_seconds,
+		r.changes,
+		case when r.success then 0 else 1 end as failures,
+		r.failure,
+		r.reason,
+		r.warnings,
+		r.duration_ms / 1000.0 as duration_seconds
+	from nrtm_sync_run r
+	join nrtm_source s on s.id = r.nrtm_source_id;
+
+---- create above / drop below ----
+
+drop view nrtm_dashboard;
+drop table nrtm_sync_run;
@@ -0,0 +1,16 @@
+create table nrtm_session_history (
+	source varchar(255) not null,
+	notification_url text not null,
+	session_id varchar(255) not null,
+	first_seen timestamp without time zone not null,
+	last_seen timestamp without time zone not null,
+	first_version integer not null,
+	last_version integer not null,
+	announced boolean not null,
+
+	constraint nrtm_session_history__pk primary key (source, notification_url, session_id)
+);
+
+---- create above / drop below ----
+
+drop table nrtm_session_history;
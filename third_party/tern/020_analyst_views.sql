@@ -0,0 +1,70 @@
+-- Views for analysts who query the mirror directly. Their columns are kept stable when the
+-- tables under them change; new columns are only added at the end.
+
+-- The objects of each source at the latest version which has been completely applied
+create view current_objects as
+	select
+		s.source,
+		s.label,
+		r.object_type,
+		r.primary_key,
+		r.from_version as version,
+		r.rpsl
+	from nrtm_rpslobject r
+	join nrtm_source s on s.id = r.nrtm_source_id
+	where r.from_version <= s.applied_version
+		and (r.to_version = 0 or r.to_version > s.applied_version);
+
+-- Every version of every object. to_version is null while the version is current, and deleted
+-- is true when the object was deleted at to_version rather than replaced.
+create view object_history as
+	select
+		s.source,
+		s.label,
+		r.object_type,
+		r.primary_key,
+		r.from_version,
+		nullif(r.to_version, 0) as to_version,
+		r.to_version > 0 and not exists (
+			select 1 from nrtm_rpslobject nxt
+			where nxt.nrtm_source_id = r.nrtm_source_id
+				and nxt.object_type = r.object_type
+				and nxt.primary_key = r.primary_key
+				and nxt.from_version = r.to_version
+		) as deleted,
+		r.rpsl
+	from nrtm_rpslobject r
+	join nrtm_source s on s.id = r.nrtm_source_id;
+
+-- One row per source, with its session, versions and the outcome of its latest update
+create view source_status as
+	select
+		s.source,
+		s.label,
+		s.notification_url,
+		s.session_id,
+		s.version,
+		s.applied_version,
+		s.created,
+		s.paused,
+		s.quarantined_until,
+		s.quarantine_reason,
+		s.superseded,
+		r.started as last_update,
+		r.success as last_update_succeeded,
+		nullif(r.failure, '') as last_failure,
+		r.lag_ms / 1000.0 as lag_seconds
+	from nrtm_source s
+	left join lateral (
+		select started, success, failure, lag_ms
+		from nrtm_sync_run
+		where nrtm_source_id = s.id
+		order by started desc
+		limit 1
+	) r on true;
+
+---- create above / drop below ----
+
+drop view source_status;
+drop view object_history;
+drop view current_objects;
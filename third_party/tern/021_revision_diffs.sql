@@ -0,0 +1,109 @@
+-- Old versions of an object can be stored as a diff against the version which replaced it,
+-- instead of in full. rpsl is null for those, rpsl_diff holds the line edits which turn the
+-- text of the diff_base version back into the old one, and nrtm_rpsl() rebuilds the text.
+-- Current versions are always stored in full.
+alter table nrtm_rpslobject alter column rpsl drop not null;
+alter table nrtm_rpslobject add column rpsl_diff jsonb;
+alter table nrtm_rpslobject add column diff_base bigint;
+
+-- The edits are applied to the lines of the base text in order: {"k": n} keeps the next n lines,
+-- {"d": n} skips them and {"i": [...]} inserts new ones.
+create function nrtm_rpsl(revision bigint) returns text as $$
+declare
+	r record;
+	base text[];
+	result text[] := '{}';
+	op jsonb;
+	pos integer := 1;
+begin
+	select rpsl, rpsl_diff, diff_base into r from nrtm_rpslobject where id = revision;
+	if r.rpsl is not null or r.rpsl_diff is null then
+		return r.rpsl;
+	end if;
+	base := string_to_array(nrtm_rpsl(r.diff_base), E'\n');
+	for op in select jsonb_array_elements(r.rpsl_diff) loop
+		if op ? 'k' then
+			result := result || base[pos:pos + (op->>'k')::integer - 1];
+			pos := pos + (op->>'k')::integer;
+		elsif op ? 'd' then
+			pos := pos + (op->>'d')::integer;
+		else
+			result := result || array(select jsonb_array_elements_text(op->'i'));
+		end if;
+	end loop;
+	return array_to_string(result, E'\n');
+end;
+$$ language plpgsql stable;
+
+create or replace view current_objects as
+	select
+		s.source,
+		s.label,
+		r.object_type,
+		r.primary_key,
+		r.from_version as version,
+		coalesce(r.rpsl, nrtm_rpsl(r.id)) as rpsl
+	from nrtm_rpslobject r
+	join nrtm_source s on s.id = r.nrtm_source_id
+	where r.from_version <= s.applied_version
+		and (r.to_version = 0 or r.to_version > s.applied_version);
+
+create or replace view object_history as
+	select
+		s.source,
+		s.label,
+		r.object_type,
+		r.primary_key,
+		r.from_version,
+		nullif(r.to_version, 0) as to_version,
+		r.to_version > 0 and not exists (
+			select 1 from nrtm_rpslobject nxt
+			where nxt.nrtm_source_id = r.nrtm_source_id
+				and nxt.object_type = r.object_type
+				and nxt.primary_key = r.primary_key
+				and nxt.from_version = r.to_version
+		) as deleted,
+		coalesce(r.rpsl, nrtm_rpsl(r.id)) as rpsl
+	from nrtm_rpslobject r
+	join nrtm_source s on s.id = r.nrtm_source_id;
+
+---- create above / drop below ----
+
+update nrtm_rpslobject set rpsl = nrtm_rpsl(id) where rpsl is null;
+
+create or replace view current_objects as
+	select
+		s.source,
+		s.label,
+		r.object_type,
+		r.primary_key,
+		r.from_version as version,
+		r.rpsl
+	from nrtm_rpslobject r
+	join nrtm_source s on s.id = r.nrtm_source_id
+	where r.from_version <= s.applied_version
+		and (r.to_version = 0 or r.to_version > s.applied_version);
+
+create or replace view object_history as
+	select
+		s.source,
+		s.label,
+		r.object_type,
+		r.primary_key,
+		r.from_version,
+		nullif(r.to_version, 0) as to_version,
+		r.to_version > 0 and not exists (
+			select 1 from nrtm_rpslobject nxt
+			where nxt.nrtm_source_id = r.nrtm_source_id
+				and nxt.object_type = r.object_type
+				and nxt.primary_key = r.primary_key
+				and nxt.from_version = r.to_version
+		) as deleted,
+		r.rpsl
+	from nrtm_rpslobject r
+	join nrtm_source s on s.id = r.nrtm_source_id;
+
+drop function nrtm_rpsl;
+alter table nrtm_rpslobject drop column diff_base;
+alter table nrtm_rpslobject drop column rpsl_diff;
+alter table nrtm_rpslobject alter column rpsl set not null;
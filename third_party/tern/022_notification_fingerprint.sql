@@ -0,0 +1,9 @@
+alter table nrtm_source add column notification_hash text not null default '';
+alter table nrtm_source add column notification_etag text not null default '';
+alter table nrtm_source add column notification_checked timestamp without time zone;
+
+---- create above / drop below ----
+
+alter table nrtm_source drop column notification_checked;
+alter table nrtm_source drop column notification_etag;
+alter table nrtm_source drop column notification_hash;
@@ -0,0 +1,5 @@
+alter table nrtm_source add column sample_rate double precision not null default 0;
+
+---- create above / drop below ----
+
+alter table nrtm_source drop column sample_rate;
@@ -0,0 +1,49 @@
+-- Objects restored by undelete are local overrides rather than changes the server made.
+-- restored is when it was done, and null for every version which came from the server.
+alter table nrtm_rpslobject add column restored timestamptz;
+
+create or replace view object_history as
+	select
+		s.source,
+		s.label,
+		r.object_type,
+		r.primary_key,
+		r.from_version,
+		nullif(r.to_version, 0) as to_version,
+		r.to_version > 0 and not exists (
+			select 1 from nrtm_rpslobject nxt
+			where nxt.nrtm_source_id = r.nrtm_source_id
+				and nxt.object_type = r.object_type
+				and nxt.primary_key = r.primary_key
+				and nxt.from_version = r.to_version
+				and nxt.restored is null
+		) as deleted,
+		coalesce(r.rpsl, nrtm_rpsl(r.id)) as rpsl,
+		r.restored
+	from nrtm_rpslobject r
+	join nrtm_source s on s.id = r.nrtm_source_id;
+
+---- create above / drop below ----
+
+drop view object_history;
+
+create view object_history as
+	select
+		s.source,
+		s.label,
+		r.object_type,
+		r.primary_key,
+		r.from_version,
+		nullif(r.to_version, 0) as to_version,
+		r.to_version > 0 and not exists (
+			select 1 from nrtm_rpslobject nxt
+			where nxt.nrtm_source_id = r.nrtm_source_id
+				and nxt.object_type = r.object_type
+				and nxt.primary_key = r.primary_key
+				and nxt.from_version = r.to_version
+		) as deleted,
+		coalesce(r.rpsl, nrtm_rpsl(r.id)) as rpsl
+	from nrtm_rpslobject r
+	join nrtm_source s on s.id = r.nrtm_source_id;
+
+alter table nrtm_rpslobject drop column restored;
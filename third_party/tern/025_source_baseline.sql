@@ -0,0 +1,9 @@
+alter table nrtm_source add column baseline bigint not null default 0;
+
+-- The earliest version each source's objects are kept from
+update nrtm_source s
+set baseline = coalesce((select min(r.from_version) from nrtm_rpslobject r where r.nrtm_source_id = s.id), s.version);
+
+---- create above / drop below ----
+
+alter table nrtm_source drop column baseline;
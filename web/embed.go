@@ -0,0 +1,22 @@
+//go:build embedweb
+
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// dist is the production build of the web client, from npm run build
+//
+//go:embed all:dist
+var dist embed.FS
+
+// Assets returns the web client built into the binary
+func Assets() fs.FS {
+	assets, err := fs.Sub(dist, "dist")
+	if err != nil {
+		return nil
+	}
+	return assets
+}
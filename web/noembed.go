@@ -0,0 +1,12 @@
+//go:build !embedweb
+
+// Package web holds the web client. Binaries built with the embedweb tag have its production
+// build in ./dist built into them, so nrtm4serve can serve it without a copy on disk.
+package web
+
+import "io/fs"
+
+// Assets returns nil, since the web client wasn't built into the binary
+func Assets() fs.FS {
+	return nil
+}
@@ -1,4 +1,4 @@
-import { SourceModel } from "./models";
+import { ListQuery, LookupResult, Page, SourceModel, SyncResult } from "./models";
 import RPCClient from "./RPCClient";
 
 export default class WebAPIClient {
@@ -12,6 +12,10 @@ export default class WebAPIClient {
     return this.client.execute<SourceModel[]>("ListSources");
   }
 
+  public querySources(query: ListQuery): Promise<Page<SourceModel>> {
+    return this.client.execute<Page<SourceModel>>("QuerySources", [query]);
+  }
+
   public saveLabel(
     source: string,
     fromLabel: string,
@@ -24,11 +28,19 @@ export default class WebAPIClient {
     ]);
   }
 
+  public lookup(
+    source: string,
+    label: string,
+    keys: string[],
+  ): Promise<LookupResult> {
+    return this.client.execute<LookupResult>("Lookup", [source, label, keys]);
+  }
+
   public connectSource(
     url: string,
     label: string,
   ) {
-    return this.client.execute<string>("Connect", [
+    return this.client.execute<SyncResult>("Connect", [
       url,
       label,
     ])
@@ -38,7 +50,7 @@ export default class WebAPIClient {
     source: string,
     label: string,
   ) {
-    return this.client.execute<string>("Update", [
+    return this.client.execute<SyncResult>("Update", [
       source,
       label,
     ])
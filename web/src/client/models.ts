@@ -1,11 +1,19 @@
 export type FileRef = {
+  expires?: string;
   hash: string;
   url: string;
   version: number;
 };
 
+export type NextSession = {
+  session_id?: string;
+  timestamp: string;
+};
+
 export type NotificationJSON = {
   deltas: FileRef[];
+  expires?: string;
+  next_session?: NextSession;
   next_signing_key: string;
   nrtm_version: number;
   session_id: string;
@@ -26,6 +34,12 @@ export type Notification = {
   Version: number;
 };
 
+export type Quarantine = {
+  Failures: number;
+  Reason: string;
+  Until: string;
+};
+
 export type SourceModel = {
   ID: string;
   Source: string;
@@ -34,5 +48,54 @@ export type SourceModel = {
   NotificationURL: string;
   Label: string;
   Created: string;
+  Paused: boolean;
+  Quarantine: Quarantine | null;
+  TermsURL: string;
+  Superseded: string | null;
   Notifications: Notification[];
+  Expiries: Expiry[];
+};
+
+export type Expiry = {
+  File: string;
+  Version: number;
+  Expires: string;
+};
+
+export type ListQuery = {
+  Filter?: string;
+  Sort?: string;
+  Limit?: number;
+  Cursor?: string;
+};
+
+export type Page<T> = {
+  Items: T[];
+  NextCursor: string;
+};
+
+export type RPSLObject = {
+  PrimaryKey: string;
+  Source: string;
+  ObjectType: string;
+  Payload: string;
+};
+
+export type LookupResult = {
+  Objects: RPSLObject[];
+  Missing: string[];
+};
+
+export type Warning = {
+  kind: string;
+  version?: number;
+  message: string;
+};
+
+export type SyncResult = {
+  source: string;
+  label: string;
+  from_version: number;
+  to_version: number;
+  warnings: Warning[];
 };